@@ -0,0 +1,261 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+type principalKey struct{}
+
+// principal is the signed in user of a request and the session it came from, or the API
+// key of a service integration
+type principal struct {
+	user    *models.User
+	session *models.Session
+	key     *models.APIKey
+}
+
+// Unauthorized wraps the reason a request could not be signed in
+func Unauthorized(err error) error {
+	return &Error{Status: http.StatusUnauthorized, Code: CodeUnauthorized, Err: err}
+}
+
+// errPasswordChange is returned for restricted sessions, see RequireUser
+var errPasswordChange = &Error{
+	Status: http.StatusForbidden,
+	Code:   CodePasswordChangeRequired,
+	Err:    errors.New("change the temporary password with POST /api/v2/auth/password first"),
+}
+
+// errAPIKey is returned by RequireSession for requests made with an API key
+var errAPIKey = Forbidden(errors.New("API keys can't use this route, sign in as a user"))
+
+// errTwoFactorSetup is returned for sessions of users that must enroll, see RequireUser
+var errTwoFactorSetup = &Error{
+	Status: http.StatusForbidden,
+	Code:   CodeTwoFactorSetupRequired,
+	Err:    errors.New("set up two-factor authentication with POST /api/v2/auth/2fa/enroll first"),
+}
+
+// Authenticate signs in requests with an "Authorization: Bearer <token>" header, the token
+// is a session from POST /api/v2/auth/login or an API key. Requests without the header pass
+// through anonymously, routes that need a user are wrapped in RequireUser and routes API
+// keys may use in RequireScope
+func Authenticate(users databases.UserDatabase, keys databases.APIKeyDatabase) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !strings.HasPrefix(header, "Bearer ") {
+				WriteError(w, r, "invalid Authorization header", Unauthorized(errors.New("expected a Bearer token")))
+				return
+			}
+			token := strings.TrimPrefix(header, "Bearer ")
+			if auth.IsAPIKey(token) {
+				authenticateKey(keys, token, next, w, r)
+				return
+			}
+			userID, secret, err := auth.ParseSessionToken(token)
+			if err != nil {
+				WriteError(w, r, "invalid session", Unauthorized(err))
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+			user, err := users.FindOne(ctx, databases.FilterUsers().ID(userID))
+			cancel()
+			if errors.Is(err, databases.ErrNotFound) {
+				WriteError(w, r, "invalid session", Unauthorized(auth.ErrInvalidToken))
+				return
+			}
+			if err != nil {
+				WriteError(w, r, "failed to load the session", err)
+				return
+			}
+			if user.Details.Disabled {
+				WriteError(w, r, "invalid session", Unauthorized(errors.New("the account is disabled")))
+				return
+			}
+			session, err := auth.FindSession(user, secret, time.Now())
+			if err != nil {
+				WriteError(w, r, "invalid session", Unauthorized(err))
+				return
+			}
+			if session.TwoFactorLogin {
+				WriteError(w, r, "invalid session", Unauthorized(errors.New("finish signing in with POST /api/v2/auth/2fa/verify")))
+				return
+			}
+
+			ctx = context.WithValue(r.Context(), principalKey{}, &principal{user: user, session: session})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticateKey is a helper function signing in a request made with an API key and
+// recording its use
+func authenticateKey(keys databases.APIKeyDatabase, token string, next http.Handler, w http.ResponseWriter, r *http.Request) {
+	prefix, err := auth.ParseAPIKey(token)
+	if err != nil {
+		WriteError(w, r, "invalid API key", Unauthorized(err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+	key, err := keys.FindOne(ctx, databases.FilterAPIKeys().Prefix(prefix))
+	if errors.Is(err, databases.ErrNotFound) {
+		WriteError(w, r, "invalid API key", Unauthorized(auth.ErrInvalidToken))
+		return
+	}
+	if err != nil {
+		WriteError(w, r, "failed to load the API key", err)
+		return
+	}
+	now := time.Now().UTC()
+	if err := auth.CheckAPIKey(key, token, now); err != nil {
+		WriteError(w, r, "invalid API key", Unauthorized(err))
+		return
+	}
+
+	if now.Sub(key.Details.LastUsedAt) >= auth.APIKeyUsedEvery {
+		update := databases.UpdateAPIKey().SetLastUsed(now, auth.ClientIP(r))
+		if _, err := keys.UpdateOne(ctx, databases.FilterAPIKeys().ID(key.ID), update); err != nil {
+			zap.S().With("error", err, "requestId", RequestIDFromContext(r.Context())).Warnw("failed to record the use of an API key", "prefix", prefix)
+		}
+	}
+
+	ctx = context.WithValue(r.Context(), principalKey{}, &principal{key: key})
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// UserFromContext returns the user signed in by Authenticate and their session, or nils
+// for anonymous requests and requests made with an API key
+func UserFromContext(ctx context.Context) (*models.User, *models.Session) {
+	p, ok := ctx.Value(principalKey{}).(*principal)
+	if !ok {
+		return nil, nil
+	}
+	return p.user, p.session
+}
+
+// KeyFromContext returns the API key a request was made with, or nil
+func KeyFromContext(ctx context.Context) *models.APIKey {
+	p, ok := ctx.Value(principalKey{}).(*principal)
+	if !ok {
+		return nil
+	}
+	return p.key
+}
+
+// ActorFromContext returns who made a request signed in by Authenticate
+func ActorFromContext(ctx context.Context) models.Actor {
+	p, ok := ctx.Value(principalKey{}).(*principal)
+	switch {
+	case !ok:
+		return models.Actor{Type: models.ActorAnonymous}
+	case p.key != nil:
+		return models.Actor{Type: models.ActorAPIKey, ID: p.key.ID, Name: p.key.Details.Prefix, Business: p.key.Details.Business}
+	default:
+		return models.Actor{Type: models.ActorUser, ID: p.user.ID, Name: p.user.Details.Email, Business: p.user.Details.Business}
+	}
+}
+
+// RequireSession rejects anonymous requests. Sessions signed in with a temporary password
+// or without a required two-factor enrollment are let through, it only guards the routes
+// needed to finish those
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if KeyFromContext(r.Context()) != nil {
+			WriteError(w, r, "user required", errAPIKey)
+			return
+		}
+		if user, _ := UserFromContext(r.Context()); user == nil {
+			WriteError(w, r, "sign in required", Unauthorized(errors.New("missing Authorization header")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireUser rejects anonymous requests and sessions that still have to change a
+// temporary password or enroll in two-factor authentication
+func RequireUser(next http.Handler) http.Handler {
+	return RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, session := UserFromContext(r.Context())
+		switch {
+		case session.PasswordChange:
+			WriteError(w, r, "password change required", errPasswordChange)
+		case session.TwoFactorSetup:
+			WriteError(w, r, "two-factor authentication required", errTwoFactorSetup)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	}))
+}
+
+// RequireAdmin is RequireUser for Admins and SuperUsers
+func RequireAdmin(next http.Handler) http.Handler {
+	return RequireUser(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, _ := UserFromContext(r.Context()); user.Details.UserType > models.TypeAdmin {
+			WriteError(w, r, "admin required", Forbidden(errors.New("only Admins and SuperUsers can do this")))
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// RequireSignIn is RequireUser for sessions and RequireScope for API keys, it guards the
+// routes that change data anyone may read
+func RequireSignIn(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		user, key := RequireUser(next), RequireScope(scope)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if KeyFromContext(r.Context()) != nil {
+				key.ServeHTTP(w, r)
+				return
+			}
+			user.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectAPIKeys rejects every request made with an API key, for routes that have no scopes
+// and don't keep keys to the cows of their business, eg. /api/v1
+func RejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if KeyFromContext(r.Context()) != nil {
+			WriteError(w, r, "user required", errAPIKey)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireScope rejects requests made with an API key that lacks scope. Users and anonymous
+// requests pass, the routes it guards check them the way they did before API keys
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if key := KeyFromContext(r.Context()); key != nil && !auth.HasScope(key, scope) {
+				WriteError(w, r, "missing scope", Forbidden(fmt.Errorf("the API key needs the %s scope", scope)))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
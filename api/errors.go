@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// Error codes returned in models.APIError. They are part of the API contract, so existing
+// codes must never change meaning
+const (
+	CodeInvalidBody            = "invalid_body"              // the request body could not be read
+	CodeInvalidParameter       = "invalid_parameter"         // a path or query parameter is malformed
+	CodeValidation             = "validation_failed"         // the body was read but some fields are invalid
+	CodeUnauthorized           = "unauthorized"              // the request needs a valid session, see Authenticate
+	CodeForbidden              = "forbidden"                 // the request is not allowed, eg. a wrong setup token
+	CodePasswordChangeRequired = "password_change_required"  // the session can only change a temporary password
+	CodeTwoFactorSetupRequired = "two_factor_setup_required" // the session can only enroll in two-factor authentication
+	CodeNotFound               = "not_found"                 // the resource or route does not exist
+	CodeMethodNotAllowed       = "method_not_allowed"        // the route exists but not for this method
+	CodeConflict               = "conflict"                  // the change breaks a unique constraint
+	CodePolicyViolation        = "policy_violation"          // the booking breaks a booking policy of the business
+	CodeTooManyRequests        = "too_many_requests"         // too many failed logins, retry after the Retry-After header
+	CodeAccountLocked          = "account_locked"            // too many failed logins locked the account for a while
+	CodeInternal               = "internal_error"            // anything else, details are only logged
+)
+
+// Error is an error with a known status and code, for failures a handler detects itself
+type Error struct {
+	Status int
+	Code   string
+	Err    error
+	Fields []models.FieldError // optional, eg. for checks the validator can't express
+
+	RetryAfter time.Duration // optional, sent as the Retry-After header
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// InvalidField reports a body field failing a check done by the handler itself
+func InvalidField(field, code string, err error) error {
+	return &Error{
+		Status: http.StatusBadRequest,
+		Code:   CodeValidation,
+		Err:    err,
+		Fields: []models.FieldError{{Field: field, Code: code, Message: err.Error()}},
+	}
+}
+
+// PolicyViolation reports a booking breaking a rule of a booking policy, field is the body
+// field the rule looks at, if any
+func PolicyViolation(field, rule string, err error) error {
+	return &Error{
+		Status: http.StatusUnprocessableEntity,
+		Code:   CodePolicyViolation,
+		Err:    err,
+		Fields: []models.FieldError{{Field: field, Code: rule, Message: err.Error()}},
+	}
+}
+
+// InvalidBody wraps an error reading the request body
+func InvalidBody(err error) error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidBody, Err: err}
+}
+
+// InvalidParameter wraps an error reading a path or query parameter
+func InvalidParameter(err error) error {
+	return &Error{Status: http.StatusBadRequest, Code: CodeInvalidParameter, Err: err}
+}
+
+// Forbidden wraps the reason a request is not allowed
+func Forbidden(err error) error {
+	return &Error{Status: http.StatusForbidden, Code: CodeForbidden, Err: err}
+}
+
+// TooManyRequests wraps the reason a request must wait before it is retried
+func TooManyRequests(code string, retryAfter time.Duration, err error) error {
+	return &Error{Status: http.StatusTooManyRequests, Code: code, Err: err, RetryAfter: retryAfter}
+}
+
+// WriteError logs err and writes it as a models.ErrorResponse. The status and code are
+// worked out from err, message is shown to the client and should not contain err itself.
+// Details of malformed input, refusals and conflicts are added to the message, everything
+// else is only logged
+func WriteError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	apiErr := models.APIError{
+		Code:      CodeInternal,
+		Status:    http.StatusInternalServerError,
+		Message:   message,
+		RequestID: RequestIDFromContext(r.Context()),
+	}
+
+	var known *Error
+	var validationErrs validator.ValidationErrors
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validationErrs):
+		apiErr.Code, apiErr.Status = CodeValidation, http.StatusBadRequest
+		apiErr.Fields = fieldErrors(validationErrs)
+	case errors.As(err, &known):
+		apiErr.Code, apiErr.Status, apiErr.Fields = known.Code, known.Status, known.Fields
+		if errors.As(err, &typeErr) {
+			apiErr.Fields = []models.FieldError{{Field: typeErr.Field, Code: "type", Message: "must be " + jsonType(typeErr.Type)}}
+		} else if known.Code == CodeInvalidBody || known.Code == CodeInvalidParameter || known.Code == CodeForbidden || known.Code == CodeUnauthorized || known.Code == CodeConflict || known.Code == CodePolicyViolation || known.Status == http.StatusTooManyRequests {
+			apiErr.Message = fmt.Sprintf("%s: %v", message, known.Err)
+		}
+		if known.RetryAfter > 0 {
+			// rounded up, a client retrying on time must not be early
+			w.Header().Set("Retry-After", strconv.Itoa(int((known.RetryAfter+time.Second-1)/time.Second)))
+		}
+	case errors.Is(err, models.ErrInvalidID):
+		apiErr.Code, apiErr.Status = CodeInvalidParameter, http.StatusBadRequest
+		apiErr.Message = fmt.Sprintf("%s: %v", message, err)
+	case errors.Is(err, databases.ErrEmptyUpdate):
+		apiErr.Code, apiErr.Status = CodeInvalidBody, http.StatusBadRequest
+	case errors.Is(err, databases.ErrNotFound):
+		apiErr.Code, apiErr.Status = CodeNotFound, http.StatusNotFound
+	case errors.Is(err, databases.ErrDuplicate):
+		apiErr.Code, apiErr.Status = CodeConflict, http.StatusConflict
+	}
+
+	log := zap.S().With("status", apiErr.Status, "code", apiErr.Code, "requestId", apiErr.RequestID, "actor", ActorFromContext(r.Context()), "error", err)
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Error(message)
+	} else {
+		log.Info(message)
+	}
+
+	b, _ := json.Marshal(models.ErrorResponse{Error: apiErr})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Status)
+	w.Write(b)
+}
+
+// NotFoundHandler answers requests for routes that do not exist
+func NotFoundHandler() http.Handler {
+	return RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, "no route for "+r.URL.Path, &Error{Status: http.StatusNotFound, Code: CodeNotFound, Err: errors.New("unknown route")})
+	}))
+}
+
+// MethodNotAllowedHandler answers requests using a method a route does not support
+func MethodNotAllowedHandler() http.Handler {
+	return RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, r.Method+" is not allowed for "+r.URL.Path, &Error{Status: http.StatusMethodNotAllowed, Code: CodeMethodNotAllowed, Err: errors.New("method not allowed")})
+	}))
+}
+
+// fieldErrors is a helper function translating validator errors into field errors. The
+// validator must be set up to report JSON field names
+func fieldErrors(errs validator.ValidationErrors) []models.FieldError {
+	fields := make([]models.FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, models.FieldError{Field: fe.Field(), Code: fe.Tag(), Message: fieldMessage(fe)})
+	}
+	return fields
+}
+
+// fieldMessage is a helper function describing a failed validation tag
+func fieldMessage(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "email":
+		return "must be a valid email address"
+	case "min":
+		if fe.Kind() == reflect.String {
+			return "must be at least " + fe.Param() + " characters"
+		}
+		return "must be at least " + fe.Param()
+	case "max":
+		if fe.Kind() == reflect.String {
+			return "must be at most " + fe.Param() + " characters"
+		}
+		return "must be at most " + fe.Param()
+	case "len":
+		return "must have a length of " + fe.Param()
+	case "oneof":
+		return "must be one of " + fe.Param()
+	default:
+		return "failed the " + fe.Tag() + " check"
+	}
+}
+
+// jsonType is a helper function naming the JSON type a Go type is read from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.String:
+		return "a string"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// writeError is a helper function writing err through the RequestID middleware and
+// returning the response with its error
+func writeError(t *testing.T, requestID string, err error) (*httptest.ResponseRecorder, models.APIError) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/cows", nil)
+	if requestID != "" {
+		req.Header.Set(RequestIDHeader, requestID)
+	}
+	rec := httptest.NewRecorder()
+	RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteError(w, r, "failed to insert cow", err)
+	})).ServeHTTP(rec, req)
+
+	var body models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to read the error of %s: %v", rec.Body, err)
+	}
+	if rec.Code != body.Error.Status {
+		t.Errorf("the response has status %d, its error %d", rec.Code, body.Error.Status)
+	}
+	return rec, body.Error
+}
+
+func TestWriteError(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{"an unknown error isn't shown", errors.New("dial tcp 10.0.0.5:5432: connection refused"), http.StatusInternalServerError, CodeInternal, "failed to insert cow"},
+		{"a missing document", fmt.Errorf("cow: %w", databases.ErrNotFound), http.StatusNotFound, CodeNotFound, "failed to insert cow"},
+		{"a duplicate", fmt.Errorf("cow: %w", databases.ErrDuplicate), http.StatusConflict, CodeConflict, "failed to insert cow"},
+		{"an empty update", databases.ErrEmptyUpdate, http.StatusBadRequest, CodeInvalidBody, "failed to insert cow"},
+		{"an invalid ID", fmt.Errorf("%w: \"cart\"", models.ErrInvalidID), http.StatusBadRequest, CodeInvalidParameter, "failed to insert cow: invalid id: must be a 24 character hex string: \"cart\""},
+		{"a refusal says why", Forbidden(errors.New("Admins can only manage their own business")), http.StatusForbidden, CodeForbidden, "failed to insert cow: Admins can only manage their own business"},
+		{"a malformed body says why", InvalidBody(errors.New("unexpected EOF")), http.StatusBadRequest, CodeInvalidBody, "failed to insert cow: unexpected EOF"},
+		{"a known error wrapped by the handler", fmt.Errorf("booking: %w", &Error{Status: http.StatusConflict, Code: CodeConflict, Err: errors.New("the block is booked")}), http.StatusConflict, CodeConflict, "failed to insert cow: the block is booked"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got := writeError(t, "", tt.err)
+			if got.Status != tt.status || got.Code != tt.code || got.Message != tt.message {
+				t.Errorf("WriteError wrote %d %s %q, want %d %s %q", got.Status, got.Code, got.Message, tt.status, tt.code, tt.message)
+			}
+		})
+	}
+}
+
+func TestWriteErrorFields(t *testing.T) {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string { return strings.Split(field.Tag.Get("json"), ",")[0] })
+	type request struct {
+		Email    string `json:"email" validate:"required,email"`
+		Name     string `json:"name" validate:"min=3"`
+		UserType int    `json:"usertype" validate:"oneof=0 1"`
+	}
+	_, got := writeError(t, "", v.Struct(request{Name: "CA", UserType: 2}))
+	want := []models.FieldError{
+		{Field: "email", Code: "required", Message: "is required"},
+		{Field: "name", Code: "min", Message: "must be at least 3 characters"},
+		{Field: "usertype", Code: "oneof", Message: "must be one of 0 1"},
+	}
+	if got.Status != http.StatusBadRequest || got.Code != CodeValidation || !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("a failed validation wrote %d %s %+v, want 400 %s %+v", got.Status, got.Code, got.Fields, CodeValidation, want)
+	}
+
+	var cow struct {
+		DeviceTotal int `json:"deviceTotal"`
+	}
+	err := json.Unmarshal([]byte(`{"deviceTotal": "thirty"}`), &cow)
+	_, got = writeError(t, "", InvalidBody(err))
+	if want := []models.FieldError{{Field: "deviceTotal", Code: "type", Message: "must be a number"}}; got.Code != CodeInvalidBody || !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("a field of the wrong type wrote %s %+v, want %s %+v", got.Code, got.Fields, CodeInvalidBody, want)
+	}
+
+	_, got = writeError(t, "", InvalidField("endDate", "after", errors.New("must be after startDate")))
+	if want := []models.FieldError{{Field: "endDate", Code: "after", Message: "must be after startDate"}}; got.Code != CodeValidation || !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("a field checked by the handler wrote %s %+v, want %s %+v", got.Code, got.Fields, CodeValidation, want)
+	}
+
+	_, got = writeError(t, "", PolicyViolation("startDate", "max_per_week", errors.New("3 bookings a week")))
+	if got.Status != http.StatusUnprocessableEntity || got.Code != CodePolicyViolation || len(got.Fields) != 1 || got.Fields[0].Code != "max_per_week" {
+		t.Errorf("a policy violation wrote %d %s %+v", got.Status, got.Code, got.Fields)
+	}
+}
+
+func TestWriteErrorHeaders(t *testing.T) {
+	// clients can name the request, anything that isn't a short printable ID is replaced
+	rec, got := writeError(t, "sync-42", errors.New("boom"))
+	if rec.Header().Get(RequestIDHeader) != "sync-42" || got.RequestID != "sync-42" {
+		t.Errorf("a request named sync-42 answered header %q and requestId %q", rec.Header().Get(RequestIDHeader), got.RequestID)
+	}
+	for _, id := range []string{"", "two words", strings.Repeat("a", 65)} {
+		rec, got := writeError(t, id, errors.New("boom"))
+		if got.RequestID == "" || got.RequestID == id || rec.Header().Get(RequestIDHeader) != got.RequestID {
+			t.Errorf("a request named %q answered header %q and requestId %q, want a new ID in both", id, rec.Header().Get(RequestIDHeader), got.RequestID)
+		}
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("an error has Content-Type %q, want application/json", ct)
+	}
+
+	// a client retrying on time must not be early
+	rec, got = writeError(t, "", TooManyRequests(CodeAccountLocked, 1500*time.Millisecond, errors.New("the account is locked")))
+	if got.Status != http.StatusTooManyRequests || got.Code != CodeAccountLocked || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("a throttled request wrote %d %s with Retry-After %q, want 429 %s and 2", got.Status, got.Code, rec.Header().Get("Retry-After"), CodeAccountLocked)
+	}
+}
@@ -7,91 +7,399 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api/openapi"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/migrations"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
 	"github.com/go-playground/validator/v10"
 	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
+	"github.com/thanhpk/randstr"
 	"go.uber.org/zap"
 
 	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
 )
 
-var validate = validator.New()
+var validate = newValidator()
+
+// newValidator is a helper function creating a validator that reports fields by their
+// JSON name, so validation errors match the request body
+func newValidator() *validator.Validate {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" || name == "" {
+			return field.Name
+		}
+		return name
+	})
+	return v
+}
 
 // App stores the router and db connection so it can be reused
 type App struct {
-	Router   *mux.Router
-	DB       databases.CollectionHelper
-	Config   config.Config
-	dbHelper databases.DatabaseHelper
+	Router *mux.Router
+	DB     databases.CollectionHelper
+	Config config.Config
+	store  databases.Store
+	docs   *openapi.Document
+	setup  *Setup
+	mailer mailer.Mailer
+	oidc   map[string]*auth.OIDCProvider
+	ldap   map[string]*auth.LDAPProvider
+	proxy  auth.TrustedProxies
 }
 
 // New creates a new mux router and all the routes
 func (a *App) New() *mux.Router {
 
-	// Detect if system is new and needs default admin
-	a.newSystem()
-
 	r := mux.NewRouter()
-	cow := Cow{DB: databases.NewCowDatabase(a.dbHelper)}
-	device := Device{DB: databases.NewDeviceDatabase(a.dbHelper)}
-	// user := User{DB: databases.NewUserDatabase(a.dbHelper)}
+	r.Use(api.ForwardedFor(a.proxy))
+	r.Use(api.RequestID)
+	r.Use(api.Authenticate(a.store.Users(), a.store.APIKeys()))
+	r.NotFoundHandler = api.NotFoundHandler()
+	r.MethodNotAllowedHandler = api.MethodNotAllowedHandler()
+	if a.mailer == nil {
+		a.mailer = mailer.New(&a.Config)
+	}
+	audit := Auditor{DB: a.store.Audit(), Versions: a.store.Versions()}
+	approval := a.approval(audit)
+	waitlist := a.waitlist(approval)
+	policies := a.policies()
+	calendar := a.calendar()
+	cow := Cow{DB: a.store.Cows(), Devices: a.store.Devices(), Versions: a.store.Versions(), Audit: audit, Approval: approval, Waitlist: waitlist, Policies: policies, Calendar: calendar, Zone: a.Config.TimeZone}
+	device := Device{DB: a.store.Devices(), Cows: a.store.Cows(), Versions: a.store.Versions(), Audit: audit}
+	auditLog := Audit{DB: a.store.Audit()}
+	apiKeys := APIKey{DB: a.store.APIKeys()}
+	user := User{DB: a.store.Users(), Mailer: a.mailer, Audit: audit}
+	authn := Auth{
+		DB:          a.store.Users(),
+		Mailer:      a.mailer,
+		Config:      &a.Config,
+		Directories: a.ldap,
+		Addresses:   auth.NewIPThrottle(auth.AddressThrottle(a.Config.LoginIPMaxFailures, a.Config.LoginLockout)),
+		Audit:       audit,
+	}
+	sso := SSO{Auth: authn, Providers: a.oidc}
+	invitation := Invitation{Auth: authn, Invitations: a.store.Invitations()}
+	setup := a.setup
+	if setup == nil {
+		setup = &Setup{DB: a.store.Users(), done: true}
+	}
 
 	// healthcheck
-	r.HandleFunc("/health", healthCheckHandler)
+	r.HandleFunc("/health", healthCheckHandler).Methods("GET")
+
+	// first run setup, locked once a user exists, see newSystem
+	r.HandleFunc("/setup", setup.SetupStatusHandler).Methods("GET")
+	r.HandleFunc("/setup", setup.SetupHandler).Methods("POST")
 
+	// OpenAPI document and docs UI, see docs.go
+	a.docs = openAPIDocument()
+	r.Handle(openAPIPath, a.docs.Handler()).Methods("GET")
+	r.Handle(docsPath, openapi.DocsHandler(openAPIPath)).Methods("GET")
+
+	// v1 is kept for existing clients, every response points them to v2. API keys only use
+	// v2, where their scopes and business are checked
 	apiCreate := r.PathPrefix("/api/v1").Subrouter()
+	apiCreate.Use(api.Deprecated("/api/v2"))
+	apiCreate.Use(api.RejectAPIKeys)
+
+	// Data handlers, create, delete, update etc. Changes need a user of the cow's business
+	apiCreate.Handle("/cow/{cow_id}", api.Middleware(http.HandlerFunc(cow.CowByObjectIDHandler))).Methods("GET")                           // By Object ID not Cow Name
+	apiCreate.Handle("/cows", api.Middleware(http.HandlerFunc(cow.CowHandler))).Methods("GET")                                             // Returns all cows
+	apiCreate.Handle("/cows", api.Middleware(http.HandlerFunc(cow.CowHandlerQuery))).Methods("POST")                                       // Returns list of cows based of name query
+	apiCreate.Handle("/cows/new", api.RequireUser(api.Middleware(http.HandlerFunc(cow.NewCowHandler)))).Methods("POST")                    // Create new cow
+	apiCreate.Handle("/cows/update/{cow_id}", api.RequireUser(api.Middleware(http.HandlerFunc(cow.UpdateCowHandler)))).Methods("POST")     // Update Cow by Object ID
+	apiCreate.Handle("/cows/add_device/{cow_id}", api.RequireUser(api.Middleware(http.HandlerFunc(cow.AddDeviceHandler)))).Methods("POST") // Add Device to cow device list
+	apiCreate.Handle("/cows/get_devices/{cow_id}", api.Middleware(http.HandlerFunc(device.GetChildDevices))).Methods("POST")               // Returns a list of devices from a given Cow obj
+	apiCreate.Handle("/cows/bookings/{cow_id}", api.Middleware(http.HandlerFunc(cow.GetBookingsHandler))).Methods("GET")                   // Returns all bookings for a given cow
 
-	// Data handlers, create, delete, update etc.
-	apiCreate.Handle("/cow/{cow_id}", api.Middleware(http.HandlerFunc(cow.CowByObjectIDHandler))).Methods("GET")             // By Object ID not Cow Name
-	apiCreate.Handle("/cows", api.Middleware(http.HandlerFunc(cow.CowHandler))).Methods("GET")                               // Returns all cows
-	apiCreate.Handle("/cows", api.Middleware(http.HandlerFunc(cow.CowHandlerQuery))).Methods("POST")                         // Returns list of cows based of name query
-	apiCreate.Handle("/cows/new", api.Middleware(http.HandlerFunc(cow.NewCowHandler))).Methods("POST")                       // Create new cow
-	apiCreate.Handle("/cows/update/{cow_id}", api.Middleware(http.HandlerFunc(cow.UpdateCowHandler))).Methods("POST")        // Update Cow by Object ID
-	apiCreate.Handle("/cows/add_device/{cow_id}", api.Middleware(http.HandlerFunc(cow.AddDeviceHandler))).Methods("POST")    // Add Device to cow device list
-	apiCreate.Handle("/cows/get_devices/{cow_id}", api.Middleware(http.HandlerFunc(device.GetChildDevices))).Methods("POST") // Returns a list of devices from a given Cow obj
-	apiCreate.Handle("cows/bookings/{cow_id}", api.Middleware(http.HandlerFunc(cow.GetBookingsHandler))).Methods("GET")      // Returns all bookings for a given cow
-
-	apiCreate.Handle("/device/{device_id}", api.Middleware(http.HandlerFunc(device.DeviceByObjectIDHandler))).Methods("GET")      // By Object ID not Device Name
-	apiCreate.Handle("/devices", api.Middleware(http.HandlerFunc(device.DeviceHandler))).Methods("GET")                           // Returns all devices
-	apiCreate.Handle("/devices", api.Middleware(http.HandlerFunc(device.DeviceHandlerQuery))).Methods("POST")                     // Returns list of devices based of name query
-	apiCreate.Handle("/devices/new", api.Middleware(http.HandlerFunc(device.NewDeviceHandler))).Methods("POST")                   // create new device
-	apiCreate.Handle("/devices/update/{device_id}", api.Middleware(http.HandlerFunc(device.UpdateDeviceHandler))).Methods("POST") // Update Device by Object ID
+	apiCreate.Handle("/device/{device_id}", api.Middleware(http.HandlerFunc(device.DeviceByObjectIDHandler))).Methods("GET")                       // By Object ID not Device Name
+	apiCreate.Handle("/devices", api.Middleware(http.HandlerFunc(device.DeviceHandler))).Methods("GET")                                            // Returns all devices
+	apiCreate.Handle("/devices", api.Middleware(http.HandlerFunc(device.DeviceHandlerQuery))).Methods("POST")                                      // Returns list of devices based of name query
+	apiCreate.Handle("/devices/new", api.RequireUser(api.Middleware(http.HandlerFunc(device.NewDeviceHandler)))).Methods("POST")                   // create new device
+	apiCreate.Handle("/devices/update/{device_id}", api.RequireUser(api.Middleware(http.HandlerFunc(device.UpdateDeviceHandler)))).Methods("POST") // Update Device by Object ID
 
 	// Booking handling
-	apiCreate.Handle("/cow/book/{cow_id}", api.Middleware(http.HandlerFunc(cow.BookingHandler))).Methods("POST") // Add booking to cow by ID
+	apiCreate.Handle("/cow/book/{cow_id}", api.RequireUser(api.Middleware(http.HandlerFunc(cow.BookingHandler)))).Methods("POST") // Add booking to cow by ID
+
+	// v2 uses resource paths, HTTP verbs and status codes, list endpoints take ?limit and ?offset
+	v2 := r.PathPrefix("/api/v2").Subrouter()
+	v2.Use(api.Middleware)
+
+	// API keys only reach the routes their scopes allow, see RequireScope. Anyone can read
+	// cows, devices and bookings, changing them needs a user or an API key of their business
+	scoped := func(scope string, handler http.HandlerFunc) http.Handler { return api.RequireScope(scope)(handler) }
+	signedIn := func(scope string, handler http.HandlerFunc) http.Handler { return api.RequireSignIn(scope)(handler) }
+
+	v2.Handle("/cows", scoped(auth.ScopeCowsRead, cow.ListCows)).Methods("GET")                                                  // 200, filter by ?name, ?business, ?collection
+	v2.Handle("/cows", signedIn(auth.ScopeCowsWrite, cow.CreateCow)).Methods("POST")                                             // 201 with Location
+	v2.Handle("/cows/{cow_id}", scoped(auth.ScopeCowsRead, cow.GetCow)).Methods("GET")                                           // 200 or 404
+	v2.Handle("/cows/{cow_id}", signedIn(auth.ScopeCowsWrite, cow.PatchCow)).Methods("PATCH")                                    // 200 with the updated cow or 404
+	v2.Handle("/cows/{cow_id}", signedIn(auth.ScopeCowsWrite, cow.DeleteCow)).Methods("DELETE")                                  // 204 or 404
+	v2.Handle("/cows/{cow_id}/devices", scoped(auth.ScopeDevicesRead, cow.ListCowDevices)).Methods("GET")                        // Devices whose parent is the cow
+	v2.Handle("/cows/{cow_id}/devices", signedIn(auth.ScopeCowsWrite, cow.AddCowDevice)).Methods("POST")                         // Add a device ID to the cow device list
+	v2.Handle("/cows/{cow_id}/bookings", scoped(auth.ScopeBookingsRead, cow.ListCowBookings)).Methods("GET")                     // Every booking of the cow
+	v2.Handle("/cows/{cow_id}/bookings", signedIn(auth.ScopeBookingsWrite, cow.CreateCowBooking)).Methods("POST")                // 201 with the new booking
+	v2.Handle("/cows/{cow_id}/bookings/{booking_id}", api.RequireUser(http.HandlerFunc(cow.CancelCowBooking))).Methods("DELETE") // 204, offers the block to the waitlist
+
+	// bookings of cows with an approval policy wait for an approver, see Approval
+	v2.Handle("/cows/{cow_id}/approval", api.RequireAdmin(http.HandlerFunc(cow.GetApprovalPolicy))).Methods("GET")       // 200 or 404 when bookings don't need approval
+	v2.Handle("/cows/{cow_id}/approval", api.RequireAdmin(http.HandlerFunc(cow.SetApprovalPolicy))).Methods("PUT")       // 200, sets the approvers
+	v2.Handle("/cows/{cow_id}/approval", api.RequireAdmin(http.HandlerFunc(cow.DeleteApprovalPolicy))).Methods("DELETE") // 204, bookings no longer need approval
+
+	// booking requests are seen by their requester and those who can decide them
+	v2.Handle("/booking-requests", api.RequireUser(http.HandlerFunc(approval.ListBookingRequests))).Methods("GET")                         // 200, filter by ?status, ?cow, ?business
+	v2.Handle("/booking-requests/{request_id}", api.RequireUser(http.HandlerFunc(approval.GetBookingRequest))).Methods("GET")              // 200 or 404
+	v2.Handle("/booking-requests/{request_id}/approve", api.RequireUser(http.HandlerFunc(approval.ApproveBookingRequest))).Methods("POST") // 200, adds the booking to the cow
+	v2.Handle("/booking-requests/{request_id}/reject", api.RequireUser(http.HandlerFunc(approval.RejectBookingRequest))).Methods("POST")   // 200, needs a reason
+
+	// limits on the bookings users make, see BookingPolicy
+	v2.Handle("/booking-policies", api.RequireAdmin(http.HandlerFunc(policies.ListBookingPolicies))).Methods("GET")                // 200, SuperUsers filter by ?business
+	v2.Handle("/booking-policies", api.RequireAdmin(http.HandlerFunc(policies.SetBookingPolicy))).Methods("PUT")                   // 200, replaces the policy of the role
+	v2.Handle("/booking-policies/{policy_id}", api.RequireAdmin(http.HandlerFunc(policies.DeleteBookingPolicy))).Methods("DELETE") // 204
+
+	// closures and blackouts cows can't be booked on, see Calendar
+	v2.Handle("/availability", scoped(auth.ScopeBookingsRead, cow.SearchAvailability)).Methods("GET")                     // 200, ?from and ?to, filter by ?block, ?business, ?collection
+	v2.Handle("/calendar", api.RequireUser(http.HandlerFunc(calendar.ListCalendar))).Methods("GET")                       // 200, filter by ?kind, ?cow, ?from, ?to, ?business
+	v2.Handle("/calendar/closures", api.RequireAdmin(http.HandlerFunc(calendar.CreateClosure))).Methods("POST")           // 201 with the closure
+	v2.Handle("/calendar/blackouts", api.RequireAdmin(http.HandlerFunc(calendar.CreateBlackout))).Methods("POST")         // 201 with the blackout
+	v2.Handle("/calendar/import", api.RequireAdmin(http.HandlerFunc(calendar.ImportCalendar))).Methods("POST")            // 200, a text/calendar body, with the outcome of each event
+	v2.Handle("/calendar/{entry_id}", api.RequireAdmin(http.HandlerFunc(calendar.DeleteCalendarEntry))).Methods("DELETE") // 204
+
+	// users queue for booked blocks and are offered them in turn, see Waitlist
+	v2.Handle("/cows/{cow_id}/waitlist", api.RequireUser(http.HandlerFunc(waitlist.JoinWaitlist))).Methods("POST")            // 201 with the place in the queue
+	v2.Handle("/cows/{cow_id}/waitlist", api.RequireAdmin(http.HandlerFunc(waitlist.ListCowWaitlist))).Methods("GET")         // 200, filter by ?date, ?block, ?status
+	v2.Handle("/waitlist", api.RequireUser(http.HandlerFunc(waitlist.ListWaitlist))).Methods("GET")                           // 200, the entries of the user
+	v2.Handle("/waitlist/{entry_id}", api.RequireUser(http.HandlerFunc(waitlist.GetWaitlistEntry))).Methods("GET")            // 200 with the place in the queue
+	v2.Handle("/waitlist/{entry_id}", api.RequireUser(http.HandlerFunc(waitlist.LeaveWaitlist))).Methods("DELETE")            // 204, passes an offer on
+	v2.Handle("/waitlist/{entry_id}/accept", api.RequireUser(http.HandlerFunc(waitlist.AcceptWaitlistOffer))).Methods("POST") // 201 with the booking
+
+	// versions kept after every change to a cow or device, see Auditor. diff is registered
+	// before {version} so it isn't read as one
+	v2.Handle("/cows/{cow_id}/versions", api.RequireAdmin(http.HandlerFunc(cow.ListCowVersions))).Methods("GET")             // 200, oldest first
+	v2.Handle("/cows/{cow_id}/versions/diff", api.RequireAdmin(http.HandlerFunc(cow.DiffCowVersions))).Methods("GET")        // 200, ?from and ?to
+	v2.Handle("/cows/{cow_id}/versions/{version}", api.RequireAdmin(http.HandlerFunc(cow.GetCowVersion))).Methods("GET")     // 200 or 404
+	v2.Handle("/cows/{cow_id}/versions/{version}/revert", api.RequireAdmin(http.HandlerFunc(cow.RevertCow))).Methods("POST") // 200 with the cow, keeps bookings
+
+	// signing in, see package auth. Send the token as "Authorization: Bearer <token>"
+	v2.HandleFunc("/auth/login", authn.Login).Methods("POST")                                                          // 200 with a session token
+	v2.Handle("/auth/logout", api.RequireSession(http.HandlerFunc(authn.Logout))).Methods("POST")                      // 204, ends the session
+	v2.Handle("/auth/me", api.RequireSession(http.HandlerFunc(authn.Me))).Methods("GET")                               // The signed in user
+	v2.Handle("/auth/password", api.RequireSession(http.HandlerFunc(authn.ChangePassword))).Methods("POST")            // Also replaces a temporary password
+	v2.HandleFunc("/auth/forgot-password", authn.ForgotPassword).Methods("POST")                                       // 202, emails a reset link
+	v2.HandleFunc("/auth/reset-password", authn.ResetPassword).Methods("POST")                                         // 204, uses the token from the link
+	v2.HandleFunc("/auth/signup", invitation.Signup).Methods("POST")                                                   // 201 with a session, uses the token from an invitation
+	v2.Handle("/auth/sessions", api.RequireUser(http.HandlerFunc(authn.ListSessions))).Methods("GET")                  // Signed in devices
+	v2.Handle("/auth/sessions", api.RequireUser(http.HandlerFunc(authn.RevokeOtherSessions))).Methods("DELETE")        // 204, signs out every other session
+	v2.Handle("/auth/sessions/{session_id}", api.RequireUser(http.HandlerFunc(authn.RevokeSession))).Methods("DELETE") // 204, signs out one session
+
+	// two-factor authentication with an authenticator app, see auth/totp.go
+	v2.Handle("/auth/2fa/enroll", api.RequireSession(http.HandlerFunc(authn.TwoFactorEnroll))).Methods("POST")             // Secret and QR code
+	v2.Handle("/auth/2fa/confirm", api.RequireSession(http.HandlerFunc(authn.TwoFactorConfirm))).Methods("POST")           // Turn it on, returns the recovery codes
+	v2.HandleFunc("/auth/2fa/verify", authn.TwoFactorVerify).Methods("POST")                                               // Second step of a login
+	v2.Handle("/auth/2fa/recovery-codes", api.RequireUser(http.HandlerFunc(authn.TwoFactorRecoveryCodes))).Methods("POST") // Replace the recovery codes
+	v2.Handle("/auth/2fa/disable", api.RequireUser(http.HandlerFunc(authn.TwoFactorDisable))).Methods("POST")              // 204
+
+	// single sign-on with the OpenID Connect providers of OIDC_PROVIDERS_FILE, see auth/oidc.go
+	v2.HandleFunc("/auth/oidc", sso.ListProviders).Methods("GET")                // Providers, ?email= picks the ones for an email
+	v2.HandleFunc("/auth/oidc/{provider}/login", sso.Login).Methods("GET")       // 302 to the identity provider
+	v2.HandleFunc("/auth/oidc/{provider}/callback", sso.Callback).Methods("GET") // 302 to BASE_URL with the session in the fragment
+
+	v2.Handle("/users", api.RequireAdmin(http.HandlerFunc(user.ListUsers))).Methods("GET")                                     // 200, filter by ?type, ?email, ?disabled, ?business
+	v2.Handle("/users", api.RequireAdmin(http.HandlerFunc(user.CreateUser))).Methods("POST")                                   // 201, emails a temporary password without one
+	v2.Handle("/users/{user_id}", api.RequireAdmin(http.HandlerFunc(user.GetUser))).Methods("GET")                             // 200 or 404
+	v2.Handle("/users/{user_id}", api.RequireAdmin(http.HandlerFunc(user.PatchUser))).Methods("PATCH")                         // 200 with the updated user
+	v2.Handle("/users/{user_id}/role", api.RequireAdmin(http.HandlerFunc(user.SetUserRole))).Methods("PUT")                    // 200, promote or demote
+	v2.Handle("/users/{user_id}/deactivate", api.RequireAdmin(http.HandlerFunc(user.DeactivateUser))).Methods("POST")          // 204, keeps their bookings
+	v2.Handle("/users/{user_id}/reactivate", api.RequireAdmin(http.HandlerFunc(user.ReactivateUser))).Methods("POST")          // 204
+	v2.Handle("/users/{user_id}/reset-password", api.RequireAdmin(http.HandlerFunc(authn.AdminResetPassword))).Methods("POST") // Temporary password
+	v2.Handle("/users/{user_id}/2fa/reset", api.RequireAdmin(http.HandlerFunc(authn.AdminResetTwoFactor))).Methods("POST")     // 204, for lost devices
+	v2.Handle("/users/{user_id}/unlock", api.RequireAdmin(http.HandlerFunc(authn.AdminUnlock))).Methods("POST")                // 204, after failed logins
+
+	// invitations to sign up with an emailed link, see Invitation
+	v2.Handle("/invitations", api.RequireAdmin(http.HandlerFunc(invitation.ListInvitations))).Methods("GET")                          // 200, filter by ?status, ?email, ?business
+	v2.Handle("/invitations", api.RequireAdmin(http.HandlerFunc(invitation.CreateInvitation))).Methods("POST")                        // 201, emails the signup link
+	v2.Handle("/invitations/import", api.RequireAdmin(http.HandlerFunc(invitation.ImportInvitations))).Methods("POST")                // 200, a text/csv body, with the outcome of each row
+	v2.Handle("/invitations/{invitation_id}", api.RequireAdmin(http.HandlerFunc(invitation.GetInvitation))).Methods("GET")            // 200 or 404
+	v2.Handle("/invitations/{invitation_id}", api.RequireAdmin(http.HandlerFunc(invitation.RevokeInvitation))).Methods("DELETE")      // 204, only pending invitations
+	v2.Handle("/invitations/{invitation_id}/resend", api.RequireAdmin(http.HandlerFunc(invitation.ResendInvitation))).Methods("POST") // 200, with a new link
+
+	v2.Handle("/devices", scoped(auth.ScopeDevicesRead, device.ListDevices)).Methods("GET")                    // 200, filter by ?name, ?type, ?parent
+	v2.Handle("/devices", signedIn(auth.ScopeDevicesWrite, device.CreateDevice)).Methods("POST")               // 201 with Location
+	v2.Handle("/devices/{device_id}", scoped(auth.ScopeDevicesRead, device.GetDevice)).Methods("GET")          // 200 or 404
+	v2.Handle("/devices/{device_id}", signedIn(auth.ScopeDevicesWrite, device.PatchDevice)).Methods("PATCH")   // 200 with the updated device or 404
+	v2.Handle("/devices/{device_id}", signedIn(auth.ScopeDevicesWrite, device.DeleteDevice)).Methods("DELETE") // 204 or 404
+
+	v2.Handle("/devices/{device_id}/versions", api.RequireAdmin(http.HandlerFunc(device.ListDeviceVersions))).Methods("GET")             // 200, oldest first
+	v2.Handle("/devices/{device_id}/versions/diff", api.RequireAdmin(http.HandlerFunc(device.DiffDeviceVersions))).Methods("GET")        // 200, ?from and ?to
+	v2.Handle("/devices/{device_id}/versions/{version}", api.RequireAdmin(http.HandlerFunc(device.GetDeviceVersion))).Methods("GET")     // 200 or 404
+	v2.Handle("/devices/{device_id}/versions/{version}/revert", api.RequireAdmin(http.HandlerFunc(device.RevertDevice))).Methods("POST") // 200 with the device
+
+	// API keys of service integrations, see auth/apikey.go
+	v2.Handle("/api-keys", api.RequireAdmin(http.HandlerFunc(apiKeys.ListAPIKeys))).Methods("GET")              // 200, SuperUsers filter by ?business
+	v2.Handle("/api-keys", api.RequireAdmin(http.HandlerFunc(apiKeys.CreateAPIKey))).Methods("POST")            // 201, the key is only shown here
+	v2.Handle("/api-keys/{key_id}", api.RequireAdmin(http.HandlerFunc(apiKeys.GetAPIKey))).Methods("GET")       // 200 or 404
+	v2.Handle("/api-keys/{key_id}", api.RequireAdmin(http.HandlerFunc(apiKeys.DeleteAPIKey))).Methods("DELETE") // 204, revokes the key
+
+	// every change made through the cow, device, user and booking handlers, see Auditor
+	v2.Handle("/audit", api.RequireAdmin(http.HandlerFunc(auditLog.ListAudit))).Methods("GET")          // 200, filter by ?actor, ?action, ?type, ?entity, ?request, ?from, ?to, ?business
+	v2.Handle("/audit/export", api.RequireAdmin(http.HandlerFunc(auditLog.ExportAudit))).Methods("GET") // 200, the same filters as text/csv
 
 	return r
 }
 
 func (a *App) Initialize() error {
-	client, err := databases.NewClient(&a.Config)
-	if err != nil {
-		// if we fail to create a new database client, the kill the pod
-		zap.S().With(err).Error("failed to create new client")
+	if err := a.Connect(); err != nil {
+		return err
+	}
+
+	if a.Config.AutoMigrate {
+		if _, err := a.Migrator().Up(context.Background(), 0); err != nil {
+			zap.S().With(err).Error("failed to migrate database")
+			return err
+		}
+	}
+
+	// Detect if system is new and needs default admin
+	if err := a.newSystem(context.Background()); err != nil {
 		return err
 	}
 
-	a.dbHelper = databases.NewDatabase(&a.Config, client)
-	err = client.Connect()
+	oidcProviders, err := auth.NewOIDCProviders(a.Config.OIDCProviders, a.Config.BaseURL)
 	if err != nil {
-		// if we fail to connect to the database, the kill the pod
-		zap.S().With(err).Error("failed to connect to database")
+		return fmt.Errorf("invalid OIDC_PROVIDERS_FILE: %w", err)
+	}
+	a.oidc = oidcProviders
+	if a.ldap, err = a.LDAPProviders(); err != nil {
 		return err
 	}
-	zap.S().Info("DeviceBookingAPI has connected to the database")
+	if a.proxy, err = auth.ParseTrustedProxies(a.Config.TrustedProxies); err != nil {
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
 
 	// initialize api router
 	a.initializeRoutes()
+
+	// every route must be in the OpenAPI document, otherwise clients generated from it break.
+	// TestEveryRouteDocumented fails on them, this only warns about builds that skipped it
+	if missing, err := openapi.Missing(a.docs, a.Router); err != nil || len(missing) > 0 {
+		zap.S().Warnw("routes are missing from the OpenAPI document in handlers/docs.go", "routes", missing, "error", err)
+	}
 	return nil
 
 }
 
+// Connect opens the storage backend selected in the config
+func (a *App) Connect() error {
+	store, err := databases.Open(&a.Config)
+	if err != nil {
+		// if we fail to connect to the database, the kill the pod
+		zap.S().With(err).Errorw("failed to connect to database", "backend", a.Config.Backend)
+		return err
+	}
+
+	a.store = store
+	zap.S().Infow("DeviceBookingAPI has connected to the database", "backend", a.Config.Backend)
+	return nil
+}
+
+// LDAPProviders returns the directories of LDAP_PROVIDERS_FILE by name
+func (a *App) LDAPProviders() (map[string]*auth.LDAPProvider, error) {
+	providers, err := auth.NewLDAPProviders(a.Config.LDAPProviders)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP_PROVIDERS_FILE: %w", err)
+	}
+	return providers, nil
+}
+
+// StartDirectorySync runs the scheduled sync of every LDAP directory with a syncInterval
+// in the background until ctx is done. Call it after Initialize
+func (a *App) StartDirectorySync(ctx context.Context) {
+	for _, provider := range a.ldap {
+		if provider.SyncEvery > 0 {
+			go provider.RunSync(ctx, a.store.Users())
+		}
+	}
+}
+
+// StartBookingExpiry expires the booking requests nobody approved in time and passes on the
+// waitlist offers nobody accepted, checking every minute in the background until ctx is
+// done. Call it after Initialize
+func (a *App) StartBookingExpiry(ctx context.Context) {
+	approval := a.approval(Auditor{})
+	go approval.RunExpiry(ctx, time.Minute)
+	go a.waitlist(approval).RunOffers(ctx, time.Minute)
+}
+
+// approval is a helper function returning the booking approvals of the connected store
+func (a *App) approval(audit Auditor) Approval {
+	return Approval{
+		Policies: a.store.ApprovalPolicies(),
+		Requests: a.store.BookingRequests(),
+		Cows:     a.store.Cows(),
+		Users:    a.store.Users(),
+		Mailer:   a.mailer,
+		Config:   &a.Config,
+		Audit:    audit,
+		Calendar: a.calendar(),
+		Limits:   a.policies(),
+		Waitlist: a.store.Waitlist(),
+	}
+}
+
+// waitlist is a helper function returning the booking waitlists of the connected store
+func (a *App) waitlist(approval Approval) Waitlist {
+	return Waitlist{
+		DB:       a.store.Waitlist(),
+		Cows:     a.store.Cows(),
+		Users:    a.store.Users(),
+		Mailer:   a.mailer,
+		Config:   &a.Config,
+		Audit:    approval.Audit,
+		Approval: approval,
+		Policies: a.policies(),
+		Calendar: a.calendar(),
+	}
+}
+
+// policies is a helper function returning the booking policies of the connected store
+func (a *App) policies() BookingPolicy {
+	return BookingPolicy{DB: a.store.BookingPolicies(), Cows: a.store.Cows(), Requests: a.store.BookingRequests(), Waitlist: a.store.Waitlist(), Zone: a.Config.TimeZone}
+}
+
+// calendar is a helper function returning the closures and blackouts of the connected store
+func (a *App) calendar() Calendar {
+	return Calendar{DB: a.store.Calendar(), Cows: a.store.Cows(), Zone: a.Config.TimeZone}
+}
+
+// Store returns the connected storage backend, or nil before Connect
+func (a *App) Store() databases.Store {
+	return a.store
+}
+
+// Migrator returns a migrator for the connected database
+func (a *App) Migrator() *migrations.Migrator {
+	return a.store.Migrator()
+}
+
+// Backup writes a copy of the database to path, if the backend supports online backups
+func (a *App) Backup(ctx context.Context, path string) error {
+	backuper, ok := a.store.(databases.Backuper)
+	if !ok {
+		return fmt.Errorf("the %s backend does not support backups, use the database's own tools", a.Config.Backend)
+	}
+	return backuper.Backup(ctx, path)
+}
+
 func (a *App) initializeRoutes() {
 	a.Router = a.New()
 }
@@ -105,27 +413,44 @@ func healthCheckHandler(w http.ResponseWriter, r *http.Request) {
 	_, _ = io.WriteString(w, string(b))
 }
 
-func (a *App) newSystem() {
-	var DB databases.UserDatabase = databases.NewUserDatabase(a.dbHelper)
-	dbResp, err := DB.Find(context.TODO(), bson.M{})
+// newSystem creates the first SuperUser when there are no users. It comes from the config,
+// from stdin when InteractiveSetup is set, or otherwise from POST /setup using a one
+// time token that is logged here
+func (a *App) newSystem(ctx context.Context) error {
+	DB := a.store.Users()
+	a.setup = &Setup{DB: DB}
+
+	users, err := DB.Find(ctx, databases.FilterUsers().Limit(1))
 	if err != nil {
-		zap.S().With(err).Error("Unable to detect new system: failed to get users")
+		return fmt.Errorf("unable to detect new system: %w", err)
 	}
+	if len(users) > 0 {
+		a.setup.done = true
+		return nil
+	}
+
+	switch {
+	case a.Config.AdminEmail != "":
+		admin, err := configAdmin(DB, &a.Config)
+		if err != nil {
+			return err
+		}
+		if _, err := DB.InsertOne(ctx, admin); err != nil {
+			return fmt.Errorf("failed to create the admin from the config: %w", err)
+		}
+		zap.S().Infow("created the first SuperUser from the config", "email", admin.Details.Email, "uid", admin.Details.UID)
+		a.setup.done = true
 
-	if len(dbResp) == 0 {
+	case a.Config.InteractiveSetup:
 		fmt.Println("Admin account setup...")
 
 		for {
 			defaultAdmin := util.CreateDefaultAdmin(DB)
 
 			if util.Confirm("Are the above credentials correct?") {
-				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-				defer cancel()
-
 				_, err := DB.InsertOne(ctx, defaultAdmin)
 				if err != nil {
-					log.Printf("Failed to create an admin\n")
-					break
+					return fmt.Errorf("failed to create an admin: %w", err)
 				}
 
 				log.Printf("Successfully created default admin")
@@ -133,5 +458,46 @@ func (a *App) newSystem() {
 				break
 			}
 		}
+		a.setup.done = true
+
+	default:
+		a.setup.token = a.Config.SetupToken
+		if a.setup.token == "" {
+			a.setup.token = randstr.Hex(16)
+			zap.S().Warnw("there are no users, create the first SuperUser with POST /setup", "setupToken", a.setup.token)
+		} else {
+			zap.S().Warn("there are no users, create the first SuperUser with POST /setup and SETUP_TOKEN")
+		}
+	}
+	return nil
+}
+
+// configAdmin is a helper function building the first SuperUser from the config
+func configAdmin(DB databases.UserDatabase, conf *config.Config) (models.User, error) {
+	email, ok := util.ValidMailAddress(conf.AdminEmail)
+	if !ok {
+		return models.User{}, fmt.Errorf("ADMIN_EMAIL %q is not a valid email address", conf.AdminEmail)
+	}
+	if err := auth.ValidatePassword(conf.AdminPassword); err != nil {
+		return models.User{}, fmt.Errorf("ADMIN_PASSWORD: %w", err)
 	}
+
+	details := models.UserDetails{
+		FirstName: conf.AdminFirstName,
+		LastName:  conf.AdminLastName,
+		Email:     email,
+		UserType:  models.TypeSuperUser,
+	}
+	if details.FirstName == "" {
+		details.FirstName = "Admin"
+	}
+	if details.LastName == "" {
+		details.LastName = "User"
+	}
+	return util.NewUser(DB, details, conf.AdminPassword, false), nil
+}
+
+// idParam is a helper function parsing the route parameter name as an ID
+func idParam(r *http.Request, name string) (models.ID, error) {
+	return models.ParseID(mux.Vars(r)[name])
 }
@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// APIKey lets admins manage the API keys of service integrations. Admins manage the keys
+// of their own business, SuperUsers those of every business
+type APIKey struct {
+	DB databases.APIKeyDatabase
+}
+
+// ListAPIKeys returns a page of API keys, SuperUsers can filter by ?business
+func (k APIKey) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+	filter := databases.FilterAPIKeys().Limit(p.Limit).Skip(p.Offset)
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType != models.TypeSuperUser:
+		filter.Business(actor.Details.Business)
+	case !business.IsZero():
+		filter.Business(business)
+	}
+	keys, err := k.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get API keys", err)
+		return
+	}
+	if keys == nil {
+		keys = []models.APIKey{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": keys, "page": p})
+}
+
+// CreateAPIKey creates an API key and returns it, the key itself is only shown this once
+func (k APIKey) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req models.APIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	if err := auth.ValidScopes(req.Scopes); err != nil {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("scopes", "oneof", err))
+		return
+	}
+	now := time.Now().UTC()
+	if !req.ExpiresAt.IsZero() && !req.ExpiresAt.After(now) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("expiresAt", "future", errors.New("must be in the future")))
+		return
+	}
+
+	actor, _ := api.UserFromContext(r.Context())
+	switch {
+	case actor.Details.UserType == models.TypeSuperUser && req.Business.IsZero():
+		api.WriteError(w, r, "invalid request body", api.InvalidField("business", "required", errors.New("is required")))
+		return
+	case actor.Details.UserType != models.TypeSuperUser && actor.Details.Business.IsZero():
+		api.WriteError(w, r, "failed to create the API key", api.Forbidden(errors.New("you don't belong to a business")))
+		return
+	case actor.Details.UserType != models.TypeSuperUser && req.Business.IsZero():
+		req.Business = actor.Details.Business
+	case actor.Details.UserType != models.TypeSuperUser && req.Business != actor.Details.Business:
+		api.WriteError(w, r, "failed to create the API key", api.Forbidden(errors.New("admins can only create API keys for their own business")))
+		return
+	}
+
+	token, prefix, hash := auth.NewAPIKey()
+	key := models.APIKey{
+		ID: models.NewID(),
+		Details: models.APIKeyDetails{
+			Name:      req.Name,
+			Business:  req.Business,
+			Prefix:    prefix,
+			Hash:      hash,
+			Scopes:    req.Scopes,
+			CreatedBy: actor.ID,
+			CreatedAt: now,
+			ExpiresAt: req.ExpiresAt.UTC(),
+		},
+	}
+	if _, err := k.DB.InsertOne(ctx, key); err != nil {
+		api.WriteError(w, r, "failed to save the API key", err)
+		return
+	}
+	zap.S().Infow("created an API key", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "prefix", prefix, "business", key.Details.Business, "scopes", key.Details.Scopes)
+
+	w.Header().Set("Location", "/api/v2/api-keys/"+key.ID.String())
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": models.CreatedAPIKey{APIKey: key, Key: token}})
+}
+
+// GetAPIKey returns an API key by ID, without the key itself
+func (k APIKey) GetAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := idParam(r, "key_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid API key ID", err)
+		return
+	}
+
+	key, err := k.DB.FindOne(r.Context(), k.filter(r, keyID))
+	if err != nil {
+		api.WriteError(w, r, "API key not found", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": key})
+}
+
+// DeleteAPIKey revokes an API key, requests made with it fail straight away
+func (k APIKey) DeleteAPIKey(w http.ResponseWriter, r *http.Request) {
+	keyID, err := idParam(r, "key_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid API key ID", err)
+		return
+	}
+
+	result, err := k.DB.DeleteOne(r.Context(), k.filter(r, keyID))
+	if err != nil {
+		api.WriteError(w, r, "the API key could not be deleted", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "API key not found", databases.ErrNotFound)
+		return
+	}
+	zap.S().Infow("revoked an API key", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "id", keyID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// filter is a helper function matching the API key with id, limited to the business of an
+// Admin so the keys of other businesses look like they don't exist
+func (k APIKey) filter(r *http.Request, id models.ID) *databases.APIKeyFilter {
+	filter := databases.FilterAPIKeys().ID(id)
+	if actor, _ := api.UserFromContext(r.Context()); actor.Details.UserType != models.TypeSuperUser {
+		filter.Business(actor.Details.Business)
+	}
+	return filter
+}
@@ -0,0 +1,571 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+var (
+	// errRequestDecided is returned when deciding a booking request that isn't pending
+	errRequestDecided = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the booking request was already decided")}
+	// errRequestExpired is returned when deciding a booking request past its expiry
+	errRequestExpired = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the booking request expired")}
+	// errNotApprover is returned when someone who can see a booking request tries to decide it
+	errNotApprover = api.Forbidden(errors.New("only the approvers of the cow and Admins of its business can decide its bookings"))
+)
+
+// Approval holds the bookings of cows with an approval policy until an approver of the cow,
+// or an Admin of its business, approves or rejects them. Requests nobody decides expire
+// after Config.BookingApprovalTTL, see RunExpiry. A zero Approval books every cow straight away
+type Approval struct {
+	Policies databases.ApprovalPolicyDatabase
+	Requests databases.BookingRequestDatabase
+	Cows     databases.CowDatabase
+	Users    databases.UserDatabase
+	Mailer   mailer.Mailer
+	Config   *config.Config
+	Audit    Auditor
+	Calendar Calendar                   // approved bookings are checked again against the calendar,
+	Limits   BookingPolicy              // the booking policies of their requester
+	Waitlist databases.WaitlistDatabase // and the waitlist offers of their block
+}
+
+// GetApprovalPolicy returns the approval policy of a cow, 404 when its bookings don't need
+// approval
+func (c Cow) GetApprovalPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cow, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	policy, err := c.Approval.Policies.FindOne(ctx, databases.FilterApprovalPolicies().Cow(cow.ID))
+	if err != nil {
+		api.WriteError(w, r, "the cow has no approval policy", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": policy})
+}
+
+// SetApprovalPolicy makes the bookings of a cow wait for one of the approvers in the body,
+// replacing its approvers when it already has a policy. Approvers are active users of the
+// cow's business, or SuperUsers
+func (c Cow) SetApprovalPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req models.ApprovalPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	cow, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+
+	var approvers []models.ID
+	seen := map[models.ID]bool{}
+	for _, id := range req.Approvers {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		user, err := c.Approval.Users.FindOne(ctx, databases.FilterUsers().ID(id))
+		if errors.Is(err, databases.ErrNotFound) {
+			api.WriteError(w, r, "invalid request body", api.InvalidField("approvers", "exists", errors.New("has a user that doesn't exist: "+id.String())))
+			return
+		}
+		if err != nil {
+			api.WriteError(w, r, "failed to find the approvers", err)
+			return
+		}
+		if user.Details.Disabled || (user.Details.UserType != models.TypeSuperUser && user.Details.Business != cow.Details.Business) {
+			api.WriteError(w, r, "invalid request body", api.InvalidField("approvers", "business", errors.New("must be active users of the cow's business: "+id.String())))
+			return
+		}
+		approvers = append(approvers, id)
+	}
+
+	actor, _ := api.UserFromContext(r.Context())
+	now := time.Now().UTC()
+	policy := models.ApprovalPolicy{
+		ID: cow.ID,
+		Details: models.ApprovalPolicyDetails{
+			Business:  cow.Details.Business,
+			Approvers: approvers,
+			UpdatedBy: actor.ID,
+			UpdatedAt: now,
+		},
+	}
+	filter := databases.FilterApprovalPolicies().Cow(cow.ID)
+	result, err := c.Approval.Policies.UpdateOne(ctx, filter, databases.UpdateApprovalPolicy().SetApprovers(approvers, actor.ID, now))
+	if err == nil && result.MatchedCount == 0 {
+		_, err = c.Approval.Policies.InsertOne(ctx, policy)
+	}
+	if err != nil {
+		api.WriteError(w, r, "the approval policy could not be saved", err)
+		return
+	}
+	zap.S().Infow("set the approval policy of a cow", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "cow", cow.ID, "approvers", approvers)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": policy})
+}
+
+// DeleteApprovalPolicy lets the cow be booked without approval again. Pending requests
+// can still be decided by Admins, or expire
+func (c Cow) DeleteApprovalPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cow, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	result, err := c.Approval.Policies.DeleteOne(ctx, databases.FilterApprovalPolicies().Cow(cow.ID))
+	if err != nil {
+		api.WriteError(w, r, "the approval policy could not be deleted", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "the cow has no approval policy", databases.ErrNotFound)
+		return
+	}
+	zap.S().Infow("removed the approval policy of a cow", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "cow", cow.ID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListBookingRequests returns a page of booking requests in the order they were made,
+// filtered by ?status. Users see their own requests, or every request for a ?cow they
+// approve. Admins see the requests of their business, SuperUsers can filter by ?business
+func (a Approval) ListBookingRequests(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	cowID, err := optionalIDParam(r, "cow")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+
+	filter := databases.FilterBookingRequests().Limit(p.Limit).Skip(p.Offset)
+	if !cowID.IsZero() {
+		filter.Cow(cowID)
+	}
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType == models.TypeSuperUser:
+		if !business.IsZero() {
+			filter.Business(business)
+		}
+	case actor.Details.UserType == models.TypeAdmin && actor.Details.Business.IsZero():
+		api.WriteError(w, r, "failed to get booking requests", errNoBusiness)
+		return
+	case actor.Details.UserType == models.TypeAdmin:
+		filter.Business(actor.Details.Business)
+	case cowID.IsZero() || !a.approves(ctx, actor, cowID):
+		filter.Requester(actor.ID)
+	}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case models.BookingPending, models.BookingApproved, models.BookingRejected, models.BookingExpired:
+		filter.Status(status)
+	default:
+		api.WriteError(w, r, "invalid status", api.InvalidParameter(errors.New("status must be pending, approved, rejected or expired")))
+		return
+	}
+
+	requests, err := a.Requests.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get booking requests", err)
+		return
+	}
+	if requests == nil {
+		requests = []models.BookingRequest{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": requests, "page": p})
+}
+
+// GetBookingRequest returns a booking request to its requester and those who can decide it
+func (a Approval) GetBookingRequest(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	request, err := a.find(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "booking request not found", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": request})
+}
+
+// ApproveBookingRequest adds a pending booking to its cow and emails the requester. The
+// body may give a reason
+func (a Approval) ApproveBookingRequest(w http.ResponseWriter, r *http.Request) {
+	a.decide(w, r, models.BookingApproved)
+}
+
+// RejectBookingRequest turns down a pending booking and emails the requester the reason,
+// which the body must give
+func (a Approval) RejectBookingRequest(w http.ResponseWriter, r *http.Request) {
+	a.decide(w, r, models.BookingRejected)
+}
+
+// decide is a helper function approving or rejecting the booking request of r
+func (a Approval) decide(w http.ResponseWriter, r *http.Request, status string) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	// approving doesn't need a body
+	var req models.BookingDecision
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if status == models.BookingRejected && req.Reason == "" {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("reason", "required", errors.New("is required to reject a booking")))
+		return
+	}
+
+	request, err := a.find(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "booking request not found", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if !a.canDecide(ctx, actor, request) {
+		api.WriteError(w, r, "the booking request could not be decided", errNotApprover)
+		return
+	}
+	if request.Details.Status != models.BookingPending {
+		api.WriteError(w, r, "the booking request could not be decided", errRequestDecided)
+		return
+	}
+	now := time.Now().UTC()
+	if !now.Before(request.Details.ExpiresAt) {
+		a.expire(ctx, *request)
+		api.WriteError(w, r, "the booking request could not be decided", errRequestExpired)
+		return
+	}
+
+	cow, err := a.Cows.FindOne(ctx, databases.FilterCows().ID(request.Details.Cow))
+	if err != nil && (status == models.BookingApproved || !errors.Is(err, databases.ErrNotFound)) {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	var requester *models.User
+	if status == models.BookingApproved {
+		if requester, err = a.requester(ctx, request); err != nil {
+			api.WriteError(w, r, "failed to find the requester", err)
+			return
+		}
+		if err := a.recheck(ctx, r, cow, request, requester); err != nil {
+			api.WriteError(w, r, "the booking could not be added to the cow", err)
+			return
+		}
+	}
+
+	// deciding first, filtered on the pending state, means two approvers deciding at once
+	// can't both add the booking
+	pending := databases.FilterBookingRequests().ID(request.ID).Status(models.BookingPending)
+	result, err := a.Requests.UpdateOne(ctx, pending, databases.UpdateBookingRequest().SetDecision(status, actor.ID, now, req.Reason))
+	if err != nil {
+		api.WriteError(w, r, "the booking request could not be decided", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "the booking request could not be decided", errRequestDecided)
+		return
+	}
+	request.Details.Status, request.Details.DecidedBy, request.Details.DecidedAt, request.Details.Reason = status, actor.ID, now, req.Reason
+
+	if status == models.BookingApproved {
+		booked, err := pushBooking(ctx, a.Cows, databases.FilterCows().ID(cow.ID), cow.ID, request.Details.Booking, a.zone())
+		if err == nil && booked.MatchedCount == 0 {
+			err = databases.ErrNotFound
+		}
+		if err == nil {
+			err = a.Limits.confirmFor(ctx, r, requester, cow, request.Details.Booking)
+		}
+		if err != nil {
+			reopen := databases.UpdateBookingRequest().SetDecision(models.BookingPending, "", time.Time{}, "")
+			if _, revertErr := a.Requests.UpdateOne(ctx, databases.FilterBookingRequests().ID(request.ID), reopen); revertErr != nil {
+				zap.S().With("error", revertErr, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to reopen a booking request", "bookingRequest", request.ID)
+			}
+			api.WriteError(w, r, "the booking could not be added to the cow", err)
+			return
+		}
+	}
+	zap.S().Infow("decided a booking request", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "bookingRequest", request.ID, "cow", request.Details.Cow, "status", status)
+
+	cowName := request.Details.Cow.String()
+	if cow != nil {
+		cowName = cow.Details.Name
+		if status == models.BookingApproved {
+			a.Audit.booking(r, cow, request.Details.Booking)
+		}
+	}
+	a.notifyRequester(ctx, request, cowName)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": request})
+}
+
+// zone returns the time zone of the days of blocks
+func (a Approval) zone() *time.Location {
+	if a.Config == nil {
+		return time.UTC
+	}
+	return zoneOf(a.Config.TimeZone)
+}
+
+// requester is a helper function loading the user who asked for a booking request, nil
+// for requests made with API keys and by users who were deleted since
+func (a Approval) requester(ctx context.Context, request *models.BookingRequest) (*models.User, error) {
+	if request.Details.Requester == "" {
+		return nil, nil
+	}
+	user, err := a.Users.FindOne(ctx, databases.FilterUsers().ID(request.Details.Requester))
+	if errors.Is(err, databases.ErrNotFound) {
+		return nil, nil
+	}
+	return user, err
+}
+
+// recheck is a helper function checking the booking of a request again for its requester
+// before it is approved, as the cow, the calendar, their bookings and the waitlist change
+// while it waits. The request stays pending when it fails
+func (a Approval) recheck(ctx context.Context, r *http.Request, cow *models.Cow, request *models.BookingRequest, requester *models.User) error {
+	b := request.Details.Booking
+	if b.Block != "" {
+		if slotBooked(cow, slotDate(b, a.zone()), b.Block, a.zone()) {
+			return errSlotBooked
+		}
+		if err := slotHeld(ctx, a.Waitlist, request.Details.Requester, cow.ID, slotDate(b, a.zone()), b.Block); err != nil {
+			return err
+		}
+	}
+	if err := a.Calendar.check(ctx, r, cow, b); err != nil {
+		return err
+	}
+	return a.Limits.checkFor(ctx, r, requester, cow, b, request.ID)
+}
+
+// request is a helper function holding booking for approval when its cow has an approval
+// policy. It returns nil without a policy, for the booking to be added straight away
+func (a Approval) request(ctx context.Context, r *http.Request, cowID models.ID, booking models.BookDetails) (*models.BookingRequest, error) {
+	if a.Policies == nil {
+		return nil, nil
+	}
+	policy, err := a.Policies.FindOne(ctx, databases.FilterApprovalPolicies().Cow(cowID))
+	if errors.Is(err, databases.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cow, err := a.Cows.FindOne(ctx, databases.FilterCows().ID(cowID))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	expires := now.Add(a.Config.BookingApprovalTTL)
+	if start := booking.StartDate.Time(); start.After(now) && start.Before(expires) {
+		expires = start
+	}
+	request := models.BookingRequest{
+		ID: models.NewID(),
+		Details: models.BookingRequestDetails{
+			Cow:       cowID,
+			Business:  cow.Details.Business,
+			Booking:   booking,
+			Status:    models.BookingPending,
+			CreatedAt: now,
+			ExpiresAt: expires,
+		},
+	}
+	if user, _ := api.UserFromContext(r.Context()); user != nil {
+		request.Details.Requester = user.ID
+	}
+	if _, err := a.Requests.InsertOne(ctx, request); err != nil {
+		return nil, err
+	}
+	zap.S().Infow("held a booking for approval", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "bookingRequest", request.ID, "cow", cowID)
+
+	for _, id := range policy.Details.Approvers {
+		approver, err := a.Users.FindOne(ctx, databases.FilterUsers().ID(id))
+		if err != nil || approver.Details.Disabled {
+			continue
+		}
+		a.send(ctx, auth.BookingRequestEmail(approver, cow.Details.Name, &request, a.Config.BaseURL), request.ID)
+	}
+	return &request, nil
+}
+
+// RunExpiry expires the pending booking requests past their expiry every interval until
+// ctx is done, emailing their requesters
+func (a Approval) RunExpiry(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, interval)
+		expired, err := a.ExpireRequests(runCtx)
+		cancel()
+		if err != nil {
+			zap.S().With("error", err).Error("failed to expire booking requests")
+		} else if expired > 0 {
+			zap.S().Infow("expired booking requests", "expired", expired)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExpireRequests expires every pending booking request past its expiry, returning how many
+func (a Approval) ExpireRequests(ctx context.Context) (int, error) {
+	due := databases.FilterBookingRequests().Status(models.BookingPending).ExpiresBefore(time.Now().UTC())
+	requests, err := a.Requests.Find(ctx, due)
+	if err != nil {
+		return 0, err
+	}
+	expired := 0
+	for _, request := range requests {
+		if a.expire(ctx, request) {
+			expired++
+		}
+	}
+	return expired, ctx.Err()
+}
+
+// expire is a helper function expiring a pending booking request and emailing its
+// requester, reporting whether it was still pending
+func (a Approval) expire(ctx context.Context, request models.BookingRequest) bool {
+	now := time.Now().UTC()
+	pending := databases.FilterBookingRequests().ID(request.ID).Status(models.BookingPending)
+	result, err := a.Requests.UpdateOne(ctx, pending, databases.UpdateBookingRequest().SetDecision(models.BookingExpired, "", now, ""))
+	if err != nil {
+		zap.S().With("error", err).Errorw("failed to expire a booking request", "bookingRequest", request.ID)
+		return false
+	}
+	if result.MatchedCount == 0 {
+		return false
+	}
+	request.Details.Status, request.Details.DecidedAt = models.BookingExpired, now
+
+	cowName := request.Details.Cow.String()
+	if cow, err := a.Cows.FindOne(ctx, databases.FilterCows().ID(request.Details.Cow)); err == nil {
+		cowName = cow.Details.Name
+	}
+	a.notifyRequester(ctx, &request, cowName)
+	return true
+}
+
+// find is a helper function loading the booking request of r, when the user making it
+// asked for it or can decide it. Others get ErrNotFound
+func (a Approval) find(ctx context.Context, r *http.Request) (*models.BookingRequest, error) {
+	id, err := idParam(r, "request_id")
+	if err != nil {
+		return nil, err
+	}
+	request, err := a.Requests.FindOne(ctx, databases.FilterBookingRequests().ID(id))
+	if err != nil {
+		return nil, err
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if request.Details.Requester != actor.ID && !a.canDecide(ctx, actor, request) {
+		return nil, databases.ErrNotFound
+	}
+	return request, nil
+}
+
+// canDecide is a helper function reporting whether actor may approve or reject request:
+// Admins of its business, SuperUsers and the approvers of the cow
+func (a Approval) canDecide(ctx context.Context, actor *models.User, request *models.BookingRequest) bool {
+	switch {
+	case actor.Details.UserType == models.TypeSuperUser:
+		return true
+	case actor.Details.UserType == models.TypeAdmin && !actor.Details.Business.IsZero() && actor.Details.Business == request.Details.Business:
+		return true
+	}
+	return a.approves(ctx, actor, request.Details.Cow)
+}
+
+// approves is a helper function reporting whether actor is an approver of the cow
+func (a Approval) approves(ctx context.Context, actor *models.User, cowID models.ID) bool {
+	policy, err := a.Policies.FindOne(ctx, databases.FilterApprovalPolicies().Cow(cowID))
+	if err != nil {
+		return false
+	}
+	for _, id := range policy.Details.Approvers {
+		if id == actor.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyRequester is a helper function emailing the requester what became of their request
+func (a Approval) notifyRequester(ctx context.Context, request *models.BookingRequest, cowName string) {
+	if request.Details.Requester.IsZero() {
+		return
+	}
+	requester, err := a.Users.FindOne(ctx, databases.FilterUsers().ID(request.Details.Requester))
+	if err != nil {
+		return
+	}
+	a.send(ctx, auth.BookingDecisionEmail(requester, cowName, request), request.ID)
+}
+
+// send is a helper function emailing msg about a booking request. The request stands
+// without the email, so failures are only logged
+func (a Approval) send(ctx context.Context, msg mailer.Message, id models.ID) {
+	if err := a.Mailer.Send(ctx, msg); err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+		zap.S().With("error", err).Errorw("failed to email about a booking request", "bookingRequest", id)
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// approvedCow is a helper function adding a cow of business whose bookings wait for approver
+func (a *testApp) approvedCow(business, approver models.ID) *models.Cow {
+	a.t.Helper()
+	cow := a.cow(business)
+	policy := models.ApprovalPolicy{ID: cow.ID, Details: models.ApprovalPolicyDetails{Business: business, Approvers: []models.ID{approver}, UpdatedAt: time.Now().UTC()}}
+	if _, err := a.store.ApprovalPolicies().InsertOne(context.Background(), policy); err != nil {
+		a.t.Fatalf("failed to add an approval policy: %v", err)
+	}
+	return cow
+}
+
+// requestBooking is a helper function booking a cow that needs approval, returning the
+// booking request
+func (a *testApp) requestBooking(cow *models.Cow, token string, booking models.BookDetails) models.BookingRequest {
+	a.t.Helper()
+	rec := a.do(http.MethodPost, "/api/v2/cows/"+cow.ID.String()+"/bookings", token, booking)
+	expect(a.t, rec, http.StatusAccepted, "booking a cow that needs approval")
+	return result[models.BookingRequest](a.t, rec)
+}
+
+// requestStatus is a helper function reading the status of a booking request from the store
+func (a *testApp) requestStatus(id models.ID) string {
+	a.t.Helper()
+	request, err := a.store.BookingRequests().FindOne(context.Background(), databases.FilterBookingRequests().ID(id))
+	if err != nil {
+		a.t.Fatalf("failed to read booking request %s: %v", id, err)
+	}
+	return request.Details.Status
+}
+
+func TestApproveAndReject(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, teacher := a.user(models.TypeUser, business)
+	approver, approverToken := a.user(models.TypeUser, business)
+	_, admin := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, models.NewID())
+	cow := a.approvedCow(business, approver.ID)
+
+	request := a.requestBooking(cow, teacher, bookingOn(3, "1"))
+	if request.Details.Status != models.BookingPending {
+		t.Errorf("a new request is %s, want pending", request.Details.Status)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 0 {
+		t.Errorf("a pending request booked the cow: %+v", got.Details.Bookings)
+	}
+
+	path := "/api/v2/booking-requests/" + request.ID.String()
+	expect(t, a.do(http.MethodPost, path+"/approve", teacher, nil), http.StatusForbidden, "the requester approving")
+	expect(t, a.do(http.MethodPost, path+"/approve", otherAdmin, nil), http.StatusNotFound, "an Admin of another business approving")
+	expect(t, a.do(http.MethodPost, path+"/reject", approverToken, models.BookingDecision{}), http.StatusBadRequest, "rejecting without a reason")
+
+	rec := a.do(http.MethodPost, path+"/approve", approverToken, nil)
+	expect(t, rec, http.StatusOK, "the approver approving")
+	if got := result[models.BookingRequest](t, rec); got.Details.Status != models.BookingApproved || got.Details.DecidedBy != approver.ID {
+		t.Errorf("an approved request is %s decided by %s, want approved by %s", got.Details.Status, got.Details.DecidedBy, approver.ID)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 || got.Details.Bookings[0].ID != request.Details.Booking.ID {
+		t.Errorf("approving booked %+v, want the requested booking", got.Details.Bookings)
+	}
+	expect(t, a.do(http.MethodPost, path+"/approve", admin, nil), http.StatusConflict, "approving twice")
+	expect(t, a.do(http.MethodPost, path+"/reject", admin, models.BookingDecision{Reason: "too late"}), http.StatusConflict, "rejecting an approved request")
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 {
+		t.Errorf("deciding twice left %d bookings, want 1", len(got.Details.Bookings))
+	}
+
+	rejected := a.requestBooking(cow, teacher, bookingOn(3, "2"))
+	path = "/api/v2/booking-requests/" + rejected.ID.String()
+	rec = a.do(http.MethodPost, path+"/reject", admin, models.BookingDecision{Reason: "the cart is being repaired"})
+	expect(t, rec, http.StatusOK, "an Admin of the business rejecting")
+	if got := result[models.BookingRequest](t, rec); got.Details.Status != models.BookingRejected || got.Details.Reason != "the cart is being repaired" {
+		t.Errorf("a rejected request is %s with reason %q", got.Details.Status, got.Details.Reason)
+	}
+	expect(t, a.do(http.MethodPost, path+"/approve", approverToken, nil), http.StatusConflict, "approving a rejected request")
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 {
+		t.Errorf("a rejected request booked the cow: %+v", got.Details.Bookings)
+	}
+}
+
+func TestBookingRequestsExpire(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	business := models.NewID()
+	teacher, _ := a.user(models.TypeUser, business)
+	approver, approverToken := a.user(models.TypeUser, business)
+	cow := a.approvedCow(business, approver.ID)
+
+	expired := func() models.BookingRequest {
+		booking := bookingOn(3, "1")
+		booking.ID = cow.ID.String() + "." + models.NewID().String()
+		request := models.BookingRequest{ID: models.NewID(), Details: models.BookingRequestDetails{
+			Cow:       cow.ID,
+			Business:  business,
+			Booking:   booking,
+			Requester: teacher.ID,
+			Status:    models.BookingPending,
+			CreatedAt: time.Now().UTC().Add(-2 * time.Hour),
+			ExpiresAt: time.Now().UTC().Add(-time.Hour),
+		}}
+		if _, err := a.store.BookingRequests().InsertOne(ctx, request); err != nil {
+			t.Fatalf("failed to add a booking request: %v", err)
+		}
+		return request
+	}
+
+	late := expired()
+	expect(t, a.do(http.MethodPost, "/api/v2/booking-requests/"+late.ID.String()+"/approve", approverToken, nil), http.StatusConflict, "approving an expired request")
+	if got := a.requestStatus(late.ID); got != models.BookingExpired {
+		t.Errorf("deciding a request past its expiry left it %s, want expired", got)
+	}
+
+	swept := expired()
+	if n, err := a.approval(Auditor{}).ExpireRequests(ctx); err != nil || n != 1 {
+		t.Errorf("ExpireRequests expired %d and returned %v, want 1 and nil", n, err)
+	}
+	if got := a.requestStatus(swept.ID); got != models.BookingExpired {
+		t.Errorf("a swept request is %s, want expired", got)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 0 {
+		t.Errorf("an expired request booked the cow: %+v", got.Details.Bookings)
+	}
+}
+
+func TestApprovingChecksTheBookingAgain(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	business := models.NewID()
+	teacher, teacherToken := a.user(models.TypeUser, business)
+	approver, approverToken := a.user(models.TypeAdmin, business)
+	cow := a.approvedCow(business, approver.ID)
+	approve := func(request models.BookingRequest, query string) *httptest.ResponseRecorder {
+		return a.do(http.MethodPost, "/api/v2/booking-requests/"+request.ID.String()+"/approve"+query, approverToken, nil)
+	}
+
+	// a block booked since it was requested
+	booked := a.requestBooking(cow, teacherToken, bookingOn(3, "1"))
+	direct := bookingOn(3, "1")
+	direct.ID = cow.ID.String() + ".direct"
+	if _, err := a.store.Cows().UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(direct)); err != nil {
+		t.Fatalf("failed to book the block directly: %v", err)
+	}
+	expect(t, approve(booked, ""), http.StatusConflict, "approving a block booked since")
+
+	// a block held for the waitlist since
+	held := a.requestBooking(cow, teacherToken, bookingOn(4, "1"))
+	offer := models.WaitlistEntry{ID: models.NewID(), Details: models.WaitlistEntryDetails{
+		Cow:            cow.ID,
+		Business:       business,
+		Date:           slotDate(held.Details.Booking, time.UTC),
+		Block:          "1",
+		User:           approver.ID,
+		Booking:        held.Details.Booking,
+		Status:         models.WaitlistOffered,
+		OfferedAt:      time.Now().UTC(),
+		OfferExpiresAt: time.Now().UTC().Add(time.Hour),
+	}}
+	if _, err := a.store.Waitlist().InsertOne(ctx, offer); err != nil {
+		t.Fatalf("failed to add a waitlist offer: %v", err)
+	}
+	expect(t, approve(held, ""), http.StatusConflict, "approving a block held for the waitlist")
+
+	// a closure added since
+	closed := a.requestBooking(cow, teacherToken, bookingOn(5, "1"))
+	day := closed.Details.Booking.StartDate.Time().Truncate(24 * time.Hour)
+	closure := models.CalendarEntry{ID: models.NewID(), Details: models.CalendarEntryDetails{Business: business, Kind: models.CalendarClosure, Reason: "Snow day", Start: day, End: day.AddDate(0, 0, 1)}}
+	if _, err := a.store.Calendar().InsertOne(ctx, closure); err != nil {
+		t.Fatalf("failed to add a closure: %v", err)
+	}
+	expect(t, approve(closed, "?override=true"), http.StatusConflict, "approving on a closure")
+
+	// a booking policy the requester breaks by now
+	limited := a.requestBooking(cow, teacherToken, bookingOn(6, "1"))
+	mine := bookingOn(6, "2")
+	mine.ID, mine.User = cow.ID.String()+".mine", teacher.ID
+	if _, err := a.store.Cows().UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(mine)); err != nil {
+		t.Fatalf("failed to book for the requester: %v", err)
+	}
+	policy := models.BookingPolicy{ID: models.NewID(), Details: models.BookingPolicyDetails{Business: business, MaxPerWeek: 1}}
+	if _, err := a.store.BookingPolicies().InsertOne(ctx, policy); err != nil {
+		t.Fatalf("failed to add a booking policy: %v", err)
+	}
+	rec := approve(limited, "")
+	expect(t, rec, http.StatusUnprocessableEntity, "approving past the requester's weekly limit")
+	if got := errorOf(t, rec); len(got.Fields) == 0 || got.Fields[0].Code != "max_per_week" {
+		t.Errorf("approving past the limit failed with %+v, want the rule max_per_week", got)
+	}
+
+	for _, request := range []models.BookingRequest{booked, held, closed, limited} {
+		if got := a.requestStatus(request.ID); got != models.BookingPending {
+			t.Errorf("a request that couldn't be approved is %s, want pending", got)
+		}
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 2 {
+		t.Errorf("the cow has %d bookings, want only the 2 made directly", len(got.Details.Bookings))
+	}
+
+	// approvers can approve past a policy
+	expect(t, approve(limited, "?override=true"), http.StatusOK, "approving past a policy with ?override=true")
+}
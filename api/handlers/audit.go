@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// exportBatch is how many audit entries are read at a time for a CSV export
+const exportBatch = 500
+
+// auditActions and auditTypes are the accepted values of ?action and ?type
+var (
+	auditActions = map[string]bool{models.AuditCreate: true, models.AuditUpdate: true, models.AuditDelete: true}
+	auditTypes   = map[string]bool{models.AuditCow: true, models.AuditDevice: true, models.AuditUser: true, models.AuditBooking: true}
+)
+
+// Auditor appends the changes made through the handlers to the audit log, and keeps a
+// version of every cow and device after each change. The change is already made when it
+// is recorded, so failing to record it is logged rather than failing the request. A zero
+// Auditor records nothing
+type Auditor struct {
+	DB       databases.AuditDatabase
+	Versions databases.VersionDatabase
+}
+
+// enabled reports whether the Auditor records anything, so handlers can skip reading an
+// entity only needed for it
+func (a Auditor) enabled() bool {
+	return a.DB != nil || a.Versions != nil
+}
+
+// record is a helper function appending a change made by r. before and after are the
+// entity as the API returns it, nil when it didn't exist
+func (a Auditor) record(r *http.Request, change models.AuditDetails, before, after interface{}) {
+	if a.DB == nil {
+		return
+	}
+	log := zap.S().With("requestId", api.RequestIDFromContext(r.Context()), "action", change.Action, "entityType", change.EntityType, "entityId", change.EntityID)
+
+	var err error
+	if change.Before, err = models.NewSnapshot(before); err == nil {
+		change.After, err = models.NewSnapshot(after)
+	}
+	if err != nil {
+		log.With("error", err).Errorw("failed to snapshot a change for the audit log")
+	}
+	change.Time = time.Now().UTC()
+	change.Actor = api.ActorFromContext(r.Context())
+	change.RequestID = api.RequestIDFromContext(r.Context())
+	change.IP = auth.ClientIP(r)
+	change.Method = r.Method
+	change.Path = r.URL.Path
+
+	// the request may already be cancelled, the entry must still be written
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := a.DB.InsertOne(ctx, models.AuditEntry{ID: models.NewID(), Details: change}); err != nil {
+		log.With("error", err).Errorw("failed to record a change in the audit log")
+	}
+}
+
+// version is a helper function keeping after, as the API returns it, as the next version
+// of change.EntityID. Entities changed for the first time since versions were kept get
+// before as a baseline version first
+func (a Auditor) version(r *http.Request, change models.VersionDetails, before, after interface{}) {
+	if a.Versions == nil {
+		return
+	}
+	log := zap.S().With("requestId", api.RequestIDFromContext(r.Context()), "entityType", change.EntityType, "entityId", change.EntityID)
+
+	baseline, err := models.NewSnapshot(before)
+	if err == nil {
+		change.Snapshot, err = models.NewSnapshot(after)
+	}
+	if err != nil {
+		log.With("error", err).Errorw("failed to snapshot a version")
+		return
+	}
+	change.Time = time.Now().UTC()
+	change.Actor = api.ActorFromContext(r.Context())
+	change.RequestID = api.RequestIDFromContext(r.Context())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// two changes at once can pick the same number, the unique index makes one try the next
+	for attempt := 0; attempt < 3; attempt++ {
+		var latest []models.Version
+		if latest, err = a.Versions.Find(ctx, databases.FilterVersions().Entity(change.EntityType, change.EntityID).Newest().Limit(1)); err != nil {
+			break
+		}
+		change.Version = 1
+		if len(latest) > 0 {
+			change.Version = latest[0].Details.Version + 1
+		} else if baseline != nil {
+			first := change
+			first.Version, first.Action, first.Snapshot = 1, models.VersionBaseline, baseline
+			if _, err = a.Versions.InsertOne(ctx, models.Version{ID: models.NewID(), Details: first}); errors.Is(err, databases.ErrDuplicate) {
+				continue
+			} else if err != nil {
+				break
+			}
+			change.Version = 2
+		}
+		if _, err = a.Versions.InsertOne(ctx, models.Version{ID: models.NewID(), Details: change}); !errors.Is(err, databases.ErrDuplicate) {
+			break
+		}
+	}
+	if err != nil {
+		log.With("error", err).Errorw("failed to keep a version")
+	}
+}
+
+// cow is a helper function recording a change to a cow
+func (a Auditor) cow(r *http.Request, action string, before, after *models.Cow) {
+	change := models.AuditDetails{Action: action, EntityType: models.AuditCow}
+	var b, af interface{}
+	if before != nil {
+		b, change.EntityID, change.Business = before, before.ID.String(), before.Details.Business
+	}
+	if after != nil {
+		af, change.EntityID, change.Business = after, after.ID.String(), after.Details.Business
+	}
+	a.record(r, change, b, af)
+	if after != nil {
+		a.version(r, models.VersionDetails{EntityType: models.AuditCow, EntityID: after.ID, Business: after.Details.Business, Action: action}, b, after)
+	}
+}
+
+// device is a helper function recording a change to a device of a cow of business
+func (a Auditor) device(r *http.Request, action string, business models.ID, before, after *models.Device) {
+	change := models.AuditDetails{Action: action, EntityType: models.AuditDevice, Business: business}
+	var b, af interface{}
+	if before != nil {
+		b, change.EntityID = before, before.ID.String()
+	}
+	if after != nil {
+		af, change.EntityID = after, after.ID.String()
+	}
+	a.record(r, change, b, af)
+	if after != nil {
+		a.version(r, models.VersionDetails{EntityType: models.AuditDevice, EntityID: after.ID, Business: business, Action: action}, b, after)
+	}
+}
+
+// user is a helper function recording a change to a user
+func (a Auditor) user(r *http.Request, action string, before, after *models.User) {
+	change := models.AuditDetails{Action: action, EntityType: models.AuditUser}
+	var b, af interface{}
+	if before != nil {
+		b, change.EntityID, change.Business = before, before.ID.String(), before.Details.Business
+	}
+	if after != nil {
+		af, change.EntityID, change.Business = after, after.ID.String(), after.Details.Business
+	}
+	a.record(r, change, b, af)
+}
+
+// userUpdate is a helper function recording the update of a user, reading how it looks now
+func (a Auditor) userUpdate(ctx context.Context, r *http.Request, users databases.UserDatabase, before *models.User) {
+	if a.DB == nil {
+		return
+	}
+	after, err := users.FindOne(ctx, databases.FilterUsers().ID(before.ID))
+	if err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to read a user for the audit log", "uid", before.Details.UID)
+		after = nil
+	}
+	a.user(r, models.AuditUpdate, before, after)
+}
+
+// reverted is a helper function recording that a cow or device was set back to a version
+func (a Auditor) reverted(r *http.Request, entityType string, id, business models.ID, restored int, before, after interface{}) {
+	a.record(r, models.AuditDetails{Action: models.AuditUpdate, EntityType: entityType, EntityID: id.String(), Business: business}, before, after)
+	a.version(r, models.VersionDetails{EntityType: entityType, EntityID: id, Business: business, Action: models.AuditUpdate, Restored: restored}, before, after)
+}
+
+// booking is a helper function recording a new booking of cow
+func (a Auditor) booking(r *http.Request, cow *models.Cow, booking models.BookDetails) {
+	change := models.AuditDetails{Action: models.AuditCreate, EntityType: models.AuditBooking, EntityID: booking.ID, Business: cow.Details.Business}
+	a.record(r, change, nil, booking)
+}
+
+// cancelled is a helper function recording a cancelled booking of cow
+func (a Auditor) cancelled(r *http.Request, cow *models.Cow, booking models.BookDetails) {
+	change := models.AuditDetails{Action: models.AuditDelete, EntityType: models.AuditBooking, EntityID: booking.ID, Business: cow.Details.Business}
+	a.record(r, change, booking, nil)
+}
+
+// Audit lets admins read the audit log. Admins see the changes to their own business,
+// SuperUsers every change
+type Audit struct {
+	DB databases.AuditDatabase
+}
+
+// ListAudit returns a page of audit entries in the order they were recorded, filtered by
+// ?actor, ?action, ?type, ?entity, ?request, ?from and ?to. SuperUsers can filter by ?business
+func (a Audit) ListAudit(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	filter, err := auditFilter(r)
+	if err != nil {
+		api.WriteError(w, r, "failed to get the audit log", err)
+		return
+	}
+
+	entries, err := a.DB.Find(ctx, filter.Limit(p.Limit).Skip(p.Offset))
+	if err != nil {
+		api.WriteError(w, r, "failed to get the audit log", err)
+		return
+	}
+	if entries == nil {
+		entries = []models.AuditEntry{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": entries, "page": p})
+}
+
+// ExportAudit writes every audit entry matching the filters of ListAudit as CSV
+func (a Audit) ExportAudit(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	filter, err := auditFilter(r)
+	if err != nil {
+		api.WriteError(w, r, "failed to export the audit log", err)
+		return
+	}
+	// read the first batch before answering, so a failure can still be an error response
+	entries, err := a.DB.Find(ctx, filter.Limit(exportBatch))
+	if err != nil {
+		api.WriteError(w, r, "failed to export the audit log", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"id", "time", "actor_type", "actor_id", "actor_name", "business", "action", "entity_type", "entity_id", "request_id", "ip", "method", "path", "before", "after"})
+	for offset := int64(0); ; {
+		for _, e := range entries {
+			d := e.Details
+			out.Write([]string{
+				e.ID.String(), d.Time.UTC().Format(time.RFC3339Nano), d.Actor.Type, d.Actor.ID.String(), d.Actor.Name, d.Business.String(),
+				d.Action, d.EntityType, d.EntityID, d.RequestID, d.IP, d.Method, d.Path, string(d.Before), string(d.After),
+			})
+		}
+		if len(entries) < exportBatch {
+			break
+		}
+		offset += exportBatch
+		if entries, err = a.DB.Find(ctx, filter.Skip(offset)); err != nil {
+			// the status is sent, all that is left is to cut the file short
+			zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to export the audit log")
+			break
+		}
+	}
+	out.Flush()
+}
+
+// auditFilter is a helper function reading the filters of an audit log request. Admins
+// only see the changes to their own business
+func auditFilter(r *http.Request) (*databases.AuditFilter, error) {
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		return nil, err
+	}
+	filter := databases.FilterAudit()
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType != models.TypeSuperUser && actor.Details.Business.IsZero():
+		return nil, errNoBusiness
+	case actor.Details.UserType != models.TypeSuperUser:
+		filter.Business(actor.Details.Business)
+	case !business.IsZero():
+		filter.Business(business)
+	}
+
+	actorID, err := optionalIDParam(r, "actor")
+	if err != nil {
+		return nil, err
+	}
+	if !actorID.IsZero() {
+		filter.Actor(actorID)
+	}
+	query := r.URL.Query()
+	if action := query.Get("action"); action != "" {
+		if !auditActions[action] {
+			return nil, api.InvalidParameter(errors.New("action must be create, update or delete"))
+		}
+		filter.Action(action)
+	}
+	if entityType := query.Get("type"); entityType != "" {
+		if !auditTypes[entityType] {
+			return nil, api.InvalidParameter(errors.New("type must be cow, device, user or booking"))
+		}
+		filter.EntityType(entityType)
+	}
+	if entity := query.Get("entity"); entity != "" {
+		filter.EntityID(entity)
+	}
+	if request := query.Get("request"); request != "" {
+		filter.RequestID(request)
+	}
+	for name, bound := range map[string]func(time.Time) *databases.AuditFilter{"from": filter.Since, "to": filter.Until} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, api.InvalidParameter(errors.New(name + " must be an RFC 3339 time, eg. 2024-09-01T00:00:00Z"))
+		}
+		bound(t)
+	}
+	return filter, nil
+}
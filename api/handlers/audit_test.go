@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// audit is a helper function listing the audit log with query, signed in with token
+func (a *testApp) audit(token, query string) []models.AuditEntry {
+	a.t.Helper()
+	rec := a.do(http.MethodGet, "/api/v2/audit?limit=100"+query, token, nil)
+	expect(a.t, rec, http.StatusOK, "listing the audit log with "+query)
+	return result[[]models.AuditEntry](a.t, rec)
+}
+
+func TestAuditLog(t *testing.T) {
+	a := newTestApp(t)
+	business, other := models.NewID(), models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	admin, adminToken := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, other)
+	teacher, teacherToken := a.user(models.TypeUser, business)
+	a.withPassword(teacher)
+
+	rec := a.do(http.MethodPost, "/api/v2/cows", adminToken, models.CowDetails{Name: "CA-1", Collection: "Laptop", DeviceTotal: 30})
+	expect(t, rec, http.StatusCreated, "adding a cow")
+	cow := result[models.Cow](t, rec)
+	path := "/api/v2/cows/" + cow.ID.String()
+	rec = a.do(http.MethodPatch, path, adminToken, models.CowDetails{Collection: "iPad"})
+	expect(t, rec, http.StatusOK, "changing the cow")
+	patched := rec.Header().Get(api.RequestIDHeader)
+	rec = a.do(http.MethodPost, path+"/bookings", teacherToken, bookingOn(3, "1"))
+	expect(t, rec, http.StatusCreated, "booking the cow")
+	booking := result[models.BookDetails](t, rec)
+	expect(t, a.do(http.MethodDelete, path+"/bookings/"+booking.ID, teacherToken, nil), http.StatusNoContent, "cancelling the booking")
+	expect(t, a.do(http.MethodPatch, "/api/v2/users/"+teacher.ID.String(), adminToken, models.UserUpdateRequest{LastName: "Lovelace"}), http.StatusOK, "renaming the user")
+	expect(t, a.do(http.MethodDelete, path, adminToken, nil), http.StatusNoContent, "removing the cow")
+	expect(t, a.do(http.MethodPost, "/api/v2/cows", otherAdmin, models.CowDetails{Name: "CA-2"}), http.StatusCreated, "adding a cow to another business")
+
+	// every change is kept in order, even those of entities since removed
+	entries := a.audit(adminToken, "")
+	want := []struct{ action, entityType string }{
+		{models.AuditCreate, models.AuditCow},
+		{models.AuditUpdate, models.AuditCow},
+		{models.AuditCreate, models.AuditBooking},
+		{models.AuditDelete, models.AuditBooking},
+		{models.AuditUpdate, models.AuditUser},
+		{models.AuditDelete, models.AuditCow},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("the audit log of the business has %d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for n, e := range entries {
+		if e.Details.Action != want[n].action || e.Details.EntityType != want[n].entityType || e.Details.Business != business {
+			t.Errorf("entry %d is %s %s of %s, want %s %s of the business", n, e.Details.Action, e.Details.EntityType, e.Details.Business, want[n].action, want[n].entityType)
+		}
+	}
+
+	// an entry says who changed what, how, and in which request
+	update := entries[1].Details
+	var before, after models.Cow
+	beforeErr, afterErr := json.Unmarshal(update.Before, &before), json.Unmarshal(update.After, &after)
+	if beforeErr != nil || afterErr != nil || before.Details.Collection != "Laptop" || after.Details.Collection != "iPad" {
+		t.Errorf("the update of the cow went from %s to %s, want its collection from Laptop to iPad", update.Before, update.After)
+	}
+	if update.Actor.ID != admin.ID || update.Actor.Type != models.ActorUser || update.EntityID != cow.ID.String() || update.RequestID != patched || update.Method != http.MethodPatch || update.Path != path {
+		t.Errorf("the update of the cow is %+v, want it made by the Admin in request %s", update, patched)
+	}
+	if removed := entries[5].Details; len(removed.Before) == 0 || len(removed.After) != 0 {
+		t.Errorf("the removal of the cow went from %s to %s, want only a before", removed.Before, removed.After)
+	}
+	if renamed, hash := entries[4].Details, a.findUser(teacher.ID).Details.Password; strings.Contains(string(renamed.Before)+string(renamed.After), hash) {
+		t.Error("the audit log keeps a password hash")
+	}
+
+	// searching
+	for _, tt := range []struct {
+		query string
+		want  int
+	}{
+		{"&type=booking", 2},
+		{"&action=delete", 2},
+		{"&actor=" + teacher.ID.String(), 2},
+		{"&entity=" + cow.ID.String(), 3},
+		{"&request=" + patched, 1},
+		{"&type=cow&action=update", 1},
+		{"&from=" + time.Now().UTC().Add(time.Hour).Format(time.RFC3339), 0},
+		{"&to=" + time.Now().UTC().Add(-time.Hour).Format(time.RFC3339), 0},
+		{"&from=" + time.Now().UTC().Add(-time.Hour).Format(time.RFC3339) + "&to=" + time.Now().UTC().Add(time.Hour).Format(time.RFC3339), 6},
+	} {
+		if got := a.audit(adminToken, tt.query); len(got) != tt.want {
+			t.Errorf("searching the audit log with %s found %d entries, want %d", tt.query, len(got), tt.want)
+		}
+	}
+	for _, query := range []string{"?action=drop", "?type=cart", "?from=yesterday", "?actor=someone"} {
+		expect(t, a.do(http.MethodGet, "/api/v2/audit"+query, adminToken, nil), http.StatusBadRequest, "searching the audit log with "+query)
+	}
+
+	// Admins only read their own business, SuperUsers every one
+	expect(t, a.do(http.MethodGet, "/api/v2/audit", teacherToken, nil), http.StatusForbidden, "a User reading the audit log")
+	if got := a.audit(otherAdmin, ""); len(got) != 1 || got[0].Details.Business != other {
+		t.Errorf("an Admin of another business reads %+v, want the cow they added", got)
+	}
+	if got := a.audit(otherAdmin, "&business="+business.String()); len(got) != 1 {
+		t.Errorf("an Admin naming another business reads %d entries, want only their own", len(got))
+	}
+	if got := a.audit(super, ""); len(got) != 7 {
+		t.Errorf("a SuperUser reads %d entries, want 7", len(got))
+	}
+	if got := a.audit(super, "&business="+other.String()); len(got) != 1 {
+		t.Errorf("a SuperUser reads %d entries of a business, want 1", len(got))
+	}
+
+	// entries can't be changed or removed
+	expect(t, a.do(http.MethodDelete, "/api/v2/audit", super, nil), http.StatusMethodNotAllowed, "clearing the audit log")
+	expect(t, a.do(http.MethodPatch, "/api/v2/audit", super, nil), http.StatusMethodNotAllowed, "changing the audit log")
+	if got := a.audit(super, ""); len(got) != 7 {
+		t.Errorf("the audit log has %d entries after trying to change it, want 7", len(got))
+	}
+}
+
+func TestExportAudit(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, admin := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, models.NewID())
+	_, teacher := a.user(models.TypeUser, business)
+	export := func(token, query string) [][]string {
+		t.Helper()
+		rec := a.do(http.MethodGet, "/api/v2/audit/export"+query, token, nil)
+		expect(t, rec, http.StatusOK, "exporting the audit log")
+		if ct, cd := rec.Header().Get("Content-Type"), rec.Header().Get("Content-Disposition"); !strings.HasPrefix(ct, "text/csv") || !strings.HasPrefix(cd, "attachment") {
+			t.Errorf("the export has Content-Type %q and Content-Disposition %q, want a CSV attachment", ct, cd)
+		}
+		rows, err := csv.NewReader(rec.Body).ReadAll()
+		if err != nil || len(rows) == 0 || rows[0][0] != "id" {
+			t.Fatalf("the export isn't a CSV with a header: %v %q", err, rows)
+		}
+		return rows[1:]
+	}
+
+	// more entries than are read at once
+	start := time.Now().UTC().Add(-time.Hour)
+	ids := make([]models.ID, exportBatch+1)
+	for n := range ids {
+		ids[n] = models.NewID()
+		entry := models.AuditEntry{ID: ids[n], Details: models.AuditDetails{
+			Time:       start.Add(time.Duration(n) * time.Millisecond),
+			Actor:      models.Actor{Type: models.ActorUser, ID: models.NewID(), Name: "ada@school.example"},
+			Business:   business,
+			Action:     models.AuditUpdate,
+			EntityType: models.AuditCow,
+			EntityID:   models.NewID().String(),
+			Before:     models.Snapshot(`{"name":"CA-1"}`),
+			After:      models.Snapshot(`{"name":"CA-2, by the gym"}`),
+		}}
+		if _, err := a.store.Audit().InsertOne(context.Background(), entry); err != nil {
+			t.Fatalf("failed to add an audit entry: %v", err)
+		}
+	}
+	rows := export(admin, "")
+	if len(rows) != len(ids) {
+		t.Fatalf("the export has %d rows, want %d", len(rows), len(ids))
+	}
+	for n, row := range rows {
+		if row[0] != ids[n].String() {
+			t.Fatalf("row %d is entry %s, want %s", n, row[0], ids[n])
+		}
+	}
+	if last := rows[len(rows)-1]; last[5] != business.String() || last[6] != models.AuditUpdate || last[14] != `{"name":"CA-2, by the gym"}` {
+		t.Errorf("the last row is %q", last)
+	}
+
+	// the filters and scoping of the list apply
+	if rows := export(admin, "?action=delete"); len(rows) != 0 {
+		t.Errorf("exporting deletions has %d rows, want none", len(rows))
+	}
+	if rows := export(otherAdmin, ""); len(rows) != 0 {
+		t.Errorf("an Admin of another business exports %d rows, want none", len(rows))
+	}
+	expect(t, a.do(http.MethodGet, "/api/v2/audit/export", teacher, nil), http.StatusForbidden, "a User exporting the audit log")
+	expect(t, a.do(http.MethodGet, "/api/v2/audit/export?type=cart", admin, nil), http.StatusBadRequest, "exporting with an unknown type")
+}
@@ -0,0 +1,459 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// errBadCredentials is the same for unknown emails and wrong passwords, so logins can't be
+// used to find out which emails have accounts
+var errBadCredentials = errors.New("wrong email or password")
+
+// errDisabled is returned when a disabled user signs in with the right password
+var errDisabled = errors.New("this account is disabled, ask an Admin")
+
+// Auth handles signing in, passwords, password resets and two-factor authentication
+type Auth struct {
+	DB          databases.UserDatabase
+	Mailer      mailer.Mailer
+	Config      *config.Config
+	Directories map[string]*auth.LDAPProvider
+	Addresses   *auth.IPThrottle // failed logins of each address
+	Audit       Auditor          // records what admins do to other users, not the users' own sign ins
+}
+
+// Login checks an email and password and starts a session. Users with a temporary password
+// get a session that can only change it, and users with two-factor authentication get a
+// token for TwoFactorVerify instead of a session. Emails of an LDAP directory are checked
+// against it, see directoryLogin. Failed logins slow down and lock the account and the
+// address they come from, see auth.Throttle
+func (a Auth) Login(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.LoginRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().Email(req.Email))
+	if err != nil && !errors.Is(err, databases.ErrNotFound) {
+		api.WriteError(w, r, "failed to find the user", err)
+		return
+	}
+	if err := a.throttled(r, user); err != nil {
+		api.WriteError(w, r, "failed to sign in", err)
+		return
+	}
+	if directory := a.directory(req.Email, user); directory != nil {
+		a.directoryLogin(ctx, w, r, directory, req, user)
+		return
+	}
+	if user == nil || !user.ComparePasswords(req.Password) {
+		a.loginFailed(ctx, r, user)
+		api.WriteError(w, r, "failed to sign in", api.Unauthorized(errBadCredentials))
+		return
+	}
+	if user.Details.Disabled {
+		api.WriteError(w, r, "failed to sign in", api.Forbidden(errDisabled))
+		return
+	}
+
+	resp, err := a.signIn(ctx, r, user)
+	if err != nil {
+		api.WriteError(w, r, "failed to save the session", err)
+		return
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": resp})
+}
+
+// directory is a helper function returning the LDAP directory that checks the password of
+// email: the one that created user, or for new users the one with the email's domain.
+// Local accounts keep their own password
+func (a Auth) directory(email string, user *models.User) *auth.LDAPProvider {
+	if user != nil {
+		if !strings.HasPrefix(user.Details.AuthProvider, auth.LDAPProviderPrefix) {
+			return nil
+		}
+		return a.Directories[strings.TrimPrefix(user.Details.AuthProvider, auth.LDAPProviderPrefix)]
+	}
+	for _, directory := range a.Directories {
+		if directory.AllowsEmail(email) {
+			return directory
+		}
+	}
+	return nil
+}
+
+// directoryLogin is a helper function signing in with the password of an LDAP directory,
+// creating the user on their first login. known is the user with the email, nil before
+// their first login
+func (a Auth) directoryLogin(ctx context.Context, w http.ResponseWriter, r *http.Request, directory *auth.LDAPProvider, req models.LoginRequest, known *models.User) {
+	identity, userType, err := directory.Authenticate(ctx, req.Email, req.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		a.loginFailed(ctx, r, known)
+		api.WriteError(w, r, "failed to sign in", api.Unauthorized(errBadCredentials))
+		return
+	}
+	if errors.Is(err, auth.ErrSSODenied) {
+		api.WriteError(w, r, "failed to sign in", api.Forbidden(err))
+		return
+	}
+	if err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("LDAP sign in failed", "provider", directory.Name)
+		api.WriteError(w, r, "failed to sign in", errors.New("the directory is unavailable"))
+		return
+	}
+
+	user, err := auth.Provision(ctx, a.DB, directory.Key(), directory.Business, identity, userType)
+	if errors.Is(err, auth.ErrSSODenied) {
+		api.WriteError(w, r, "failed to sign in", api.Forbidden(err))
+		return
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to sign in", err)
+		return
+	}
+	resp, err := a.signIn(ctx, r, user)
+	if err != nil {
+		api.WriteError(w, r, "failed to save the session", err)
+		return
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": resp})
+}
+
+// signIn is a helper function starting a session for a user whose password or single
+// sign-on identity was checked
+func (a Auth) signIn(ctx context.Context, r *http.Request, user *models.User) (models.LoginResponse, error) {
+	// with two-factor authentication the first step only buys a few minutes to send the code
+	if user.Details.TOTPEnabled {
+		token, challenge := auth.NewSession(user.ID, r, auth.TwoFactorLoginTTL, user.Details.TempPassword)
+		challenge.TwoFactorLogin = true
+		if err := a.addSession(ctx, user, challenge); err != nil {
+			return models.LoginResponse{}, err
+		}
+		return models.LoginResponse{
+			Token:                  token,
+			ExpiresAt:              challenge.ExpiresAt,
+			PasswordChangeRequired: challenge.PasswordChange,
+			TwoFactorRequired:      true,
+		}, nil
+	}
+
+	token, session := auth.NewSession(user.ID, r, a.Config.SessionTTL, user.Details.TempPassword)
+	session.TwoFactorSetup = a.twoFactorSetupRequired(user)
+	if err := a.addSession(ctx, user, session); err != nil {
+		return models.LoginResponse{}, err
+	}
+	a.signedIn(ctx, r, user, session)
+	return loginResponse(token, session, user), nil
+}
+
+// addSession is a helper function saving a new session of user, dropping expired ones
+func (a Auth) addSession(ctx context.Context, user *models.User, session models.Session) error {
+	update := databases.UpdateUser()
+	active := auth.ActiveSessions(user.Details.Sessions, time.Now())
+	if len(active) < len(user.Details.Sessions) {
+		update.SetSessions(append(active, session))
+	} else {
+		update.PushSession(session)
+	}
+	_, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update)
+	return err
+}
+
+// twoFactorSetupRequired is a helper function reporting whether the role of user needs
+// two-factor authentication they haven't enrolled in yet
+func (a Auth) twoFactorSetupRequired(user *models.User) bool {
+	return user.Details.UserType <= a.Config.TwoFactorRequired && !user.Details.TOTPEnabled
+}
+
+// loginResponse is a helper function describing a new session
+func loginResponse(token string, session models.Session, user *models.User) models.LoginResponse {
+	return models.LoginResponse{
+		Token:                  token,
+		ExpiresAt:              session.ExpiresAt,
+		PasswordChangeRequired: session.PasswordChange,
+		TwoFactorSetupRequired: session.TwoFactorSetup,
+		User:                   user,
+	}
+}
+
+// Logout ends the session of the request
+func (a Auth) Logout(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, session := api.UserFromContext(r.Context())
+	sessions := auth.WithoutSession(auth.ActiveSessions(user.Details.Sessions, time.Now()), session.ID)
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetSessions(sessions)); err != nil {
+		api.WriteError(w, r, "failed to end the session", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Me returns the signed in user
+func (a Auth) Me(w http.ResponseWriter, r *http.Request) {
+	user, _ := api.UserFromContext(r.Context())
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": user})
+}
+
+// ChangePassword replaces the password of the signed in user, including a temporary one.
+// Every other session is signed out and a new full session is returned
+func (a Auth) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	user, _ := api.UserFromContext(r.Context())
+	if !user.ComparePasswords(req.CurrentPassword) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("currentPassword", "password", errors.New("is wrong")))
+		return
+	}
+	if req.NewPassword == req.CurrentPassword {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("newPassword", "password", errors.New("must be different from the current password")))
+		return
+	}
+	if err := auth.ValidatePassword(req.NewPassword); err != nil {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("newPassword", "password", err))
+		return
+	}
+
+	token, session := auth.NewSession(user.ID, r, a.Config.SessionTTL, false)
+	session.TwoFactorSetup = a.twoFactorSetupRequired(user)
+	now := time.Now().UTC()
+	update := databases.UpdateUser().
+		SetPassword(user.HashPassword(req.NewPassword)).
+		SetTempPassword(false).
+		SetResetToken("", time.Time{}).
+		SetSessions([]models.Session{session}).
+		SetUpdatedAt(now)
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to change the password", err)
+		return
+	}
+	user.Details.TempPassword = false
+	user.Details.Updated_at = now
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": loginResponse(token, session, user)})
+}
+
+// ForgotPassword emails a single use reset link. It always answers 202, so it can't be
+// used to find out which emails have accounts
+func (a Auth) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().Email(req.Email))
+	if errors.Is(err, databases.ErrNotFound) {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to find the user", err)
+		return
+	}
+	// passwords of provider accounts are changed at the provider
+	if user.Details.Disabled || user.Details.AuthProvider != "" {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	token, hash := auth.NewToken()
+	update := databases.UpdateUser().SetResetToken(hash, time.Now().UTC().Add(auth.ResetTokenTTL))
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to save the reset token", err)
+		return
+	}
+	if err := a.Mailer.Send(ctx, auth.ResetPasswordEmail(user, a.Config.BaseURL, token)); err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to email a password reset link", "uid", user.Details.UID)
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password with the token from a forgot password email. The token
+// can only be used once and every session is signed out
+func (a Auth) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	hash := auth.HashToken(req.Token)
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().ResetToken(hash))
+	if errors.Is(err, databases.ErrNotFound) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("token", "token", auth.ErrInvalidToken))
+		return
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to find the user", err)
+		return
+	}
+	if !time.Now().Before(user.Details.ResetTokenExpires) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("token", "token", auth.ErrInvalidToken))
+		return
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("password", "password", err))
+		return
+	}
+
+	// filtering on the token as well means a second request with it changes nothing
+	update := databases.UpdateUser().
+		SetPassword(user.HashPassword(req.Password)).
+		SetTempPassword(false).
+		SetResetToken("", time.Time{}).
+		SetSessions(nil).
+		SetUpdatedAt(time.Now().UTC())
+	result, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID).ResetToken(hash), update)
+	if err != nil {
+		api.WriteError(w, r, "failed to reset the password", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("token", "token", auth.ErrInvalidToken))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminResetPassword gives a user a temporary password they must change on their next
+// login, and signs them out. The password is emailed, or returned when it can't be
+func (a Auth) AdminResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	userID, err := idParam(r, "user_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid user ID", err)
+		return
+	}
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().ID(userID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get user by ID", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if !canManage(actor, user) {
+		api.WriteError(w, r, "failed to reset the password", api.Forbidden(errors.New("you can only reset the passwords of users below you in your business")))
+		return
+	}
+
+	password := auth.TemporaryPassword()
+	update := databases.UpdateUser().
+		SetPassword(user.HashPassword(password)).
+		SetTempPassword(true).
+		SetResetToken("", time.Time{}).
+		SetSessions(nil).
+		SetUpdatedAt(time.Now().UTC())
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to reset the password", err)
+		return
+	}
+	a.Audit.userUpdate(ctx, r, a.DB, user)
+
+	result := models.PasswordResetResult{Emailed: true}
+	if err := a.Mailer.Send(ctx, auth.TemporaryPasswordEmail(user, password)); err != nil {
+		if !errors.Is(err, mailer.ErrNotConfigured) {
+			zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to email a temporary password", "uid", user.Details.UID)
+		}
+		result = models.PasswordResetResult{TemporaryPassword: password}
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": result})
+}
+
+// AdminUnlock lets a user locked by failed logins sign in again straight away. ?address=
+// also unlocks the address they sign in from, eg. of their school
+func (a Auth) AdminUnlock(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := idParam(r, "user_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid user ID", err)
+		return
+	}
+	address := r.URL.Query().Get("address")
+	if address != "" && net.ParseIP(address) == nil {
+		api.WriteError(w, r, "invalid address", api.InvalidParameter(errors.New("address must be an IP address")))
+		return
+	}
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().ID(userID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get user by ID", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if !canManage(actor, user) {
+		api.WriteError(w, r, "failed to unlock the user", api.Forbidden(errors.New("you can only unlock users below you in your business")))
+		return
+	}
+
+	update := databases.UpdateUser().SetFailedLogins(0, time.Time{}).SetLockedUntil(time.Time{})
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to unlock the user", err)
+		return
+	}
+	if address != "" {
+		a.Addresses.Reset(address)
+	}
+	zap.S().Infow("unlocked a user", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "uid", user.Details.UID, "address", address)
+	a.Audit.userUpdate(ctx, r, a.DB, user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// canManage is a helper function applying the role rules in models/user.go: SuperUsers
+// manage everyone below them, Admins manage Users of their own business
+func canManage(actor, user *models.User) bool {
+	if actor.Details.UserType >= user.Details.UserType {
+		return false
+	}
+	return actor.Details.UserType == models.TypeSuperUser || actor.Details.Business == user.Details.Business
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const testPassword = "Correct-Horse-7"
+
+// throttledAuth is the login and unlock routes behind the proxies of the config, with the
+// throttle of addresses at hand so tests can count failures without waiting out the delays
+type throttledAuth struct {
+	*testApp
+	router    *mux.Router
+	addresses *auth.IPThrottle
+}
+
+// newThrottledAuth is a helper function starting the routes
+func newThrottledAuth(t *testing.T, configure func(*config.Config)) *throttledAuth {
+	t.Helper()
+	a := newTestApp(t, configure)
+	proxies, err := auth.ParseTrustedProxies(a.Config.TrustedProxies)
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	addresses := auth.NewIPThrottle(auth.AddressThrottle(a.Config.LoginIPMaxFailures, a.Config.LoginLockout))
+	authn := Auth{DB: a.store.Users(), Mailer: a.mailer, Config: &a.Config, Addresses: addresses, Audit: Auditor{DB: a.store.Audit(), Versions: a.store.Versions()}}
+	router := mux.NewRouter()
+	router.Use(api.ForwardedFor(proxies))
+	router.Use(api.Authenticate(a.store.Users(), a.store.APIKeys()))
+	router.HandleFunc("/login", authn.Login).Methods(http.MethodPost)
+	router.Handle("/users/{user_id}/unlock", api.RequireAdmin(http.HandlerFunc(authn.AdminUnlock))).Methods(http.MethodPost)
+	return &throttledAuth{testApp: a, router: router, addresses: addresses}
+}
+
+// send is a helper function sending a request from the address remote, forwarded is the
+// X-Forwarded-For header when it isn't empty
+func (a *throttledAuth) send(method, path, token, remote, forwarded string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+	b, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, bytes.NewReader(b))
+	req.RemoteAddr = remote
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if forwarded != "" {
+		req.Header.Set("X-Forwarded-For", forwarded)
+	}
+	rec := httptest.NewRecorder()
+	a.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// login is a helper function signing user in from remote
+func (a *throttledAuth) login(user *models.User, password, remote, forwarded string) *httptest.ResponseRecorder {
+	a.t.Helper()
+	return a.send(http.MethodPost, "/login", "", remote, forwarded, models.LoginRequest{Email: user.Details.Email, Password: password})
+}
+
+// withPassword is a helper function giving user testPassword, hashed cheaply
+func (a *testApp) withPassword(user *models.User) {
+	a.t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		a.t.Fatalf("failed to hash the password: %v", err)
+	}
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetPassword(string(hash))); err != nil {
+		a.t.Fatalf("failed to set the password: %v", err)
+	}
+}
+
+func TestAccountLockout(t *testing.T) {
+	a := newThrottledAuth(t, func(c *config.Config) { c.LoginMaxFailures, c.LoginLockout = 3, 15*time.Minute })
+	business := models.NewID()
+	user, _ := a.user(models.TypeUser, business)
+	a.withPassword(user)
+	_, admin := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, models.NewID())
+	const remote = "192.0.2.1:5000"
+
+	// failures before the limit only slow down, a login resets them
+	for i := 0; i < 2; i++ {
+		expect(t, a.login(user, "wrong", remote, ""), http.StatusUnauthorized, "a wrong password")
+	}
+	expect(t, a.login(user, testPassword, remote, ""), http.StatusOK, "the right password after 2 failures")
+	if found := a.findUser(user.ID); found.Details.FailedLogins != 0 {
+		t.Errorf("a login left %d failed logins, want 0", found.Details.FailedLogins)
+	}
+
+	for i := 0; i < 3; i++ {
+		expect(t, a.login(user, "wrong", remote, ""), http.StatusUnauthorized, "a wrong password")
+	}
+	rec := a.login(user, testPassword, remote, "")
+	expect(t, rec, http.StatusTooManyRequests, "the right password of a locked account")
+	if got := errorOf(t, rec).Code; got != api.CodeAccountLocked {
+		t.Errorf("a locked account answered %s, want %s", got, api.CodeAccountLocked)
+	}
+
+	// the lock ends after LOGIN_LOCKOUT
+	past := time.Now().UTC().Add(-time.Second)
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetLockedUntil(past)); err != nil {
+		t.Fatalf("failed to end the lock: %v", err)
+	}
+	expect(t, a.login(user, testPassword, remote, ""), http.StatusOK, "the right password after the lock ended")
+
+	// Admins of the business unlock it straight away
+	for i := 0; i < 3; i++ {
+		expect(t, a.login(user, "wrong", remote, ""), http.StatusUnauthorized, "a wrong password")
+	}
+	unlock := "/users/" + user.ID.String() + "/unlock"
+	expect(t, a.send(http.MethodPost, unlock, otherAdmin, remote, "", nil), http.StatusForbidden, "an Admin of another business unlocking")
+	expect(t, a.login(user, testPassword, remote, ""), http.StatusTooManyRequests, "the right password of a locked account")
+	expect(t, a.send(http.MethodPost, unlock, admin, remote, "", nil), http.StatusNoContent, "an Admin unlocking")
+	expect(t, a.login(user, testPassword, remote, ""), http.StatusOK, "the right password after an Admin unlocked the account")
+}
+
+func TestAddressLockout(t *testing.T) {
+	a := newThrottledAuth(t, func(c *config.Config) {
+		c.LoginIPMaxFailures, c.LoginLockout, c.TrustedProxies = 25, 15*time.Minute, []string{"127.0.0.1"}
+	})
+	business := models.NewID()
+	user, _ := a.user(models.TypeUser, business)
+	a.withPassword(user)
+	_, admin := a.user(models.TypeAdmin, business)
+	const client, other, proxy = "192.0.2.1", "192.0.2.2", "127.0.0.1:5000"
+	now := time.Now()
+
+	// a login from the address forgets its failures
+	for i := 0; i < 19; i++ {
+		a.addresses.Fail(client, now)
+	}
+	expect(t, a.login(user, testPassword, client+":5000", ""), http.StatusOK, "the right password after 19 failures of the address")
+	a.addresses.Fail(client, now)
+	if wait, _ := a.addresses.Wait(client, now); wait != 0 {
+		t.Errorf("a failure after a login waits %s, want 0 as the login reset the failures of its address", wait)
+	}
+
+	for i := 0; i < 25; i++ {
+		a.addresses.Fail(client, now)
+	}
+	expect(t, a.login(user, testPassword, client+":5000", ""), http.StatusTooManyRequests, "a login from a locked address")
+	expect(t, a.login(user, testPassword, other+":5000", ""), http.StatusOK, "a login from another address")
+
+	// only trusted proxies say where a request came from
+	expect(t, a.login(user, testPassword, client+":5000", other), http.StatusTooManyRequests, "a login from a locked address naming another")
+	expect(t, a.login(user, testPassword, proxy, client), http.StatusTooManyRequests, "a login through a proxy from a locked address")
+	expect(t, a.login(user, testPassword, proxy, client+", "+other), http.StatusOK, "a login through a proxy from another address")
+
+	// an Admin unlocking a user can unlock their address
+	unlock := "/users/" + user.ID.String() + "/unlock"
+	expect(t, a.send(http.MethodPost, unlock+"?address=school", admin, other+":5000", "", nil), http.StatusBadRequest, "unlocking an invalid address")
+	expect(t, a.send(http.MethodPost, unlock+"?address="+client, admin, other+":5000", "", nil), http.StatusNoContent, "unlocking the address")
+	expect(t, a.login(user, testPassword, client+":5000", ""), http.StatusOK, "a login from an unlocked address")
+
+	// the lock ends after LOGIN_LOCKOUT
+	for i := 0; i < 25; i++ {
+		a.addresses.Fail(other, now)
+	}
+	if _, locked := a.addresses.Wait(other, now.Add(15*time.Minute)); locked {
+		t.Error("the lock of an address lasted past LOGIN_LOCKOUT")
+	}
+}
@@ -7,12 +7,9 @@ import (
 	"net/http"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-
-	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
-	"github.com/gorilla/mux"
 	"github.com/thanhpk/randstr"
 )
 
@@ -23,35 +20,83 @@ func (c Cow) BookingHandler(w http.ResponseWriter, r *http.Request) {
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&bookingDetails); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&bookingDetails); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
 		return
 	}
 
-	cowID := mux.Vars(r)["cow_id"]
-
-	cID, err := primitive.ObjectIDFromHex(cowID)
+	cowID, err := idParam(r, "cow_id")
 	if err != nil {
-		config.ErrorStatus("failed to get objectID from Hex", http.StatusBadRequest, w, err)
+		api.WriteError(w, r, "invalid cow ID", err)
 		return
 	}
 
 	bookingDetails.ID = fmt.Sprintf("%s.%s", cowID, randstr.Hex(16))
+	bookingDetails.User = bookedBy(r)
+
+	cow, err := c.findForAudit(ctx, cowID)
+	if err != nil {
+		api.WriteError(w, r, "failed to get cow by ID", err)
+		return
+	}
+	if cow != nil {
+		if err := checkBusiness(r, cow.Details.Business); err != nil {
+			api.WriteError(w, r, "the booking could not be added to the cow", err)
+			return
+		}
+		if err := c.checkSlot(ctx, r, cow, bookingDetails); err != nil {
+			api.WriteError(w, r, "the booking could not be added to the cow", err)
+			return
+		}
+		if err := c.Calendar.check(ctx, r, cow, bookingDetails); err != nil {
+			api.WriteError(w, r, "the cow can't be booked then", err)
+			return
+		}
+		if err := c.Policies.check(ctx, r, cow, bookingDetails); err != nil {
+			api.WriteError(w, r, "the booking breaks a booking policy", err)
+			return
+		}
+	}
 
-	dbResp, err := c.DB.UpdateOne(ctx, bson.M{"_id": cID}, bson.M{"$push": bson.M{"Cow.Bookings": bookingDetails}})
+	// cows with an approval policy are booked once an approver agrees
+	request, err := c.Approval.request(ctx, r, cowID, bookingDetails)
 	if err != nil {
-		config.ErrorStatus("the booking could not be added to the cow", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "the booking could not be requested", err)
 		return
 	}
+	if request != nil {
+		b, err := json.Marshal(models.UserResponse{Status: http.StatusAccepted, Message: "waiting for approval", Data: map[string]interface{}{"result": request}})
+		if err != nil {
+			api.WriteError(w, r, "failed to marshal response", err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		w.Write(b)
+		return
+	}
+
+	dbResp, err := pushBooking(ctx, c.DB, databases.FilterCows().ID(cowID), cowID, bookingDetails, c.Zone)
+	if err != nil {
+		api.WriteError(w, r, "the booking could not be added to the cow", err)
+		return
+	}
+	if cow != nil && dbResp.MatchedCount > 0 {
+		if err := c.Policies.confirm(ctx, r, cow, bookingDetails); err != nil {
+			api.WriteError(w, r, "the booking breaks a booking policy", err)
+			return
+		}
+		c.Audit.booking(r, cow, bookingDetails)
+		c.Waitlist.booked(ctx, r, cow.ID, bookingDetails)
+	}
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -0,0 +1,419 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// maxCalendarEvents is how many events one iCal file can import
+const maxCalendarEvents = 2000
+
+// Calendar holds the closures and blackouts of businesses. Bookings overlapping a closure of
+// the cow's business, or a blackout of the cow, are refused and availability searches show
+// the cow as unavailable. A zero Calendar has no entries
+type Calendar struct {
+	DB   databases.CalendarDatabase
+	Cows databases.CowDatabase // blackouts of a cow must name a cow of the business
+	Zone *time.Location        // the days of closures, config.Config.TimeZone
+}
+
+// ListCalendar returns a page of the closures and blackouts of the user's business,
+// filtered by ?kind, ?cow and the time range ?from to ?to. SuperUsers can filter by ?business
+func (cal Calendar) ListCalendar(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+	cowID, err := optionalIDParam(r, "cow")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	filter := databases.FilterCalendar().Limit(p.Limit).Skip(p.Offset)
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType == models.TypeSuperUser:
+		if !business.IsZero() {
+			filter.Business(business)
+		}
+	case actor.Details.Business.IsZero():
+		api.WriteError(w, r, "failed to get the calendar", errNoBusiness)
+		return
+	default:
+		filter.Business(actor.Details.Business)
+	}
+	switch kind := r.URL.Query().Get("kind"); kind {
+	case "":
+	case models.CalendarClosure, models.CalendarBlackout:
+		filter.Kind(kind)
+	default:
+		api.WriteError(w, r, "invalid kind", api.InvalidParameter(errors.New("kind must be closure or blackout")))
+		return
+	}
+	if !cowID.IsZero() {
+		filter.Cow(cowID)
+	}
+	if r.URL.Query().Get("from") != "" || r.URL.Query().Get("to") != "" {
+		from, to, err := spanParams(r)
+		if err != nil {
+			api.WriteError(w, r, "invalid time range", err)
+			return
+		}
+		filter.Between(from, to)
+	}
+
+	entries, err := cal.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get the calendar", err)
+		return
+	}
+	if entries == nil {
+		entries = []models.CalendarEntry{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": entries, "page": p})
+}
+
+// CreateClosure closes a business for whole days of Zone, nothing can be booked on them
+func (cal Calendar) CreateClosure(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req models.ClosureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	business, err := adminBusiness(actor, req.Business)
+	if err != nil {
+		api.WriteError(w, r, "the closure could not be added", err)
+		return
+	}
+
+	start, _ := time.ParseInLocation(slotDateLayout, req.StartDate, zoneOf(cal.Zone))
+	last := start
+	if req.EndDate != "" {
+		last, _ = time.ParseInLocation(slotDateLayout, req.EndDate, zoneOf(cal.Zone))
+	}
+	if last.Before(start) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("endDate", "after", errors.New("must not be before startDate")))
+		return
+	}
+
+	entry := cal.entry(r, business, models.CalendarClosure, req.Reason, start.UTC(), last.AddDate(0, 0, 1).UTC())
+	cal.create(ctx, w, r, entry)
+}
+
+// CreateBlackout keeps a cow, the cows of a device type or every cow of a business from
+// being booked for a while, eg. to reserve carts for testing weeks
+func (cal Calendar) CreateBlackout(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req models.BlackoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	business, err := adminBusiness(actor, req.Business)
+	if err != nil {
+		api.WriteError(w, r, "the blackout could not be added", err)
+		return
+	}
+	switch {
+	case req.Start.IsZero():
+		api.WriteError(w, r, "invalid request body", api.InvalidField("start", "required", errors.New("is required")))
+		return
+	case !req.End.After(req.Start):
+		api.WriteError(w, r, "invalid request body", api.InvalidField("end", "after", errors.New("must be after start")))
+		return
+	case !req.Cow.IsZero() && req.DeviceType != "":
+		api.WriteError(w, r, "invalid request body", api.InvalidField("deviceType", "excluded_with", errors.New("can't be set with cow")))
+		return
+	}
+	if !req.Cow.IsZero() {
+		cow, err := cal.Cows.FindOne(ctx, databases.FilterCows().ID(req.Cow))
+		if err == nil && cow.Details.Business != business {
+			err = databases.ErrNotFound
+		}
+		if errors.Is(err, databases.ErrNotFound) {
+			api.WriteError(w, r, "invalid request body", api.InvalidField("cow", "exists", errors.New("must be a cow of the business")))
+			return
+		}
+		if err != nil {
+			api.WriteError(w, r, "failed to find the cow", err)
+			return
+		}
+	}
+
+	entry := cal.entry(r, business, models.CalendarBlackout, req.Reason, req.Start.UTC(), req.End.UTC())
+	entry.Details.Cow = req.Cow
+	entry.Details.DeviceType = strings.TrimSpace(req.DeviceType)
+	cal.create(ctx, w, r, entry)
+}
+
+// DeleteCalendarEntry removes a closure or blackout, the cows can be booked again
+func (cal Calendar) DeleteCalendarEntry(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entryID, err := idParam(r, "entry_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid calendar entry ID", err)
+		return
+	}
+	entry, err := cal.DB.FindOne(ctx, databases.FilterCalendar().ID(entryID))
+	if err == nil && !ownBusiness(r, entry.Details.Business) {
+		err = databases.ErrNotFound
+	}
+	if err != nil {
+		api.WriteError(w, r, "calendar entry not found", err)
+		return
+	}
+	result, err := cal.DB.DeleteOne(ctx, databases.FilterCalendar().ID(entryID))
+	if err != nil {
+		api.WriteError(w, r, "the calendar entry could not be deleted", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "calendar entry not found", databases.ErrNotFound)
+		return
+	}
+	zap.S().Infow("removed a calendar entry", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "calendarEntry", entryID, "kind", entry.Details.Kind)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ImportCalendar adds the events of an iCal body, eg. the holiday calendar of the district,
+// to the calendar of the business. Whole day events become closures, the others blackouts
+// of every cow. Events imported before are matched by their UID and moved, so the same file
+// can be imported again. The result says what became of each event. SuperUsers name the
+// business with ?business
+func (cal Calendar) ImportCalendar(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	requested, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	business, err := adminBusiness(actor, requested)
+	if err != nil {
+		api.WriteError(w, r, "failed to import the calendar", err)
+		return
+	}
+
+	events, err := parseICal(http.MaxBytesReader(w, r.Body, 2<<20), zoneOf(cal.Zone))
+	if err != nil {
+		api.WriteError(w, r, "failed to read the iCal file", api.InvalidBody(err))
+		return
+	}
+	if len(events) > maxCalendarEvents {
+		api.WriteError(w, r, "failed to read the iCal file", api.InvalidBody(fmt.Errorf("more than %d events, split the file", maxCalendarEvents)))
+		return
+	}
+
+	result := models.CalendarImport{Events: []models.CalendarImportEvent{}}
+	for _, event := range events {
+		outcome := models.CalendarImportEvent{UID: event.UID, Summary: event.Summary}
+		if event.Err == nil {
+			outcome.Entry, outcome.Updated, event.Err = cal.importEvent(ctx, r, business, event)
+		}
+		switch {
+		case event.Err != nil:
+			outcome.Error = event.Err.Error()
+		case outcome.Updated:
+			result.Updated++
+		default:
+			result.Imported++
+		}
+		result.Events = append(result.Events, outcome)
+	}
+	zap.S().Infow("imported a calendar", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "business", business, "imported", result.Imported, "updated", result.Updated, "events", len(events))
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": result})
+}
+
+// importEvent is a helper function adding an iCal event to the calendar of a business, or
+// moving the entry imported before from the same event. It reports whether it moved one
+func (cal Calendar) importEvent(ctx context.Context, r *http.Request, business models.ID, event icalEvent) (models.ID, bool, error) {
+	kind := models.CalendarBlackout
+	if event.AllDay {
+		kind = models.CalendarClosure
+	}
+	reason := event.Summary
+	if reason == "" {
+		reason = "Imported"
+	}
+	if runes := []rune(reason); len(runes) > 200 {
+		reason = string(runes[:200])
+	}
+	entry := cal.entry(r, business, kind, reason, event.Start, event.End)
+	entry.Details.UID = event.UID
+
+	if event.UID != "" {
+		filter := databases.FilterCalendar().Business(business).UID(event.UID)
+		existing, err := cal.DB.FindOne(ctx, filter)
+		if err == nil {
+			_, err = cal.DB.UpdateOne(ctx, databases.FilterCalendar().ID(existing.ID), databases.UpdateCalendar().SetEvent(entry.Details))
+			return existing.ID, true, err
+		}
+		if !errors.Is(err, databases.ErrNotFound) {
+			return "", false, err
+		}
+	}
+	_, err := cal.DB.InsertOne(ctx, entry)
+	return entry.ID, false, err
+}
+
+// entry is a helper function starting a calendar entry made by the actor of the request
+func (cal Calendar) entry(r *http.Request, business models.ID, kind, reason string, start, end time.Time) models.CalendarEntry {
+	actor, _ := api.UserFromContext(r.Context())
+	return models.CalendarEntry{
+		ID: models.NewID(),
+		Details: models.CalendarEntryDetails{
+			Business:  business,
+			Kind:      kind,
+			Reason:    strings.TrimSpace(reason),
+			Start:     start,
+			End:       end,
+			CreatedBy: actor.ID,
+			CreatedAt: time.Now().UTC(),
+		},
+	}
+}
+
+// create is a helper function inserting a calendar entry and writing it
+func (cal Calendar) create(ctx context.Context, w http.ResponseWriter, r *http.Request, entry models.CalendarEntry) {
+	if _, err := cal.DB.InsertOne(ctx, entry); err != nil {
+		api.WriteError(w, r, "the calendar entry could not be added", err)
+		return
+	}
+	zap.S().Infow("added a calendar entry", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "calendarEntry", entry.ID, "kind", entry.Details.Kind, "business", entry.Details.Business)
+
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": entry})
+}
+
+// check is a helper function refusing a booking overlapping a closure of the cow's business
+// or a blackout of the cow. Admins of the business and SuperUsers can book over blackouts
+// with ?override=true, never over closures
+func (cal Calendar) check(ctx context.Context, r *http.Request, cow *models.Cow, booking models.BookDetails) error {
+	if cal.DB == nil {
+		return nil
+	}
+	from, to := bookingSpan(booking)
+	entries, err := cal.entries(ctx, []models.ID{cow.Details.Business}, from, to)
+	if err != nil {
+		return err
+	}
+	entry := blockingEntry(entries, cow)
+	if entry == nil {
+		return nil
+	}
+	if entry.Details.Kind == models.CalendarBlackout {
+		skip, err := overrides(r, cow)
+		if err != nil {
+			return err
+		}
+		if skip {
+			zap.S().Infow("booked a cow over a blackout", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "cow", cow.ID, "calendarEntry", entry.ID)
+			return nil
+		}
+	}
+	return &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New(unavailableReason(entry))}
+}
+
+// entries is a helper function returning the calendar entries of the businesses
+// overlapping [from, to)
+func (cal Calendar) entries(ctx context.Context, businesses []models.ID, from, to time.Time) ([]models.CalendarEntry, error) {
+	if cal.DB == nil || len(businesses) == 0 {
+		return nil, nil
+	}
+	return cal.DB.Find(ctx, databases.FilterCalendar().Businesses(businesses...).Between(from, to))
+}
+
+// blockingEntry is a helper function returning the entry that keeps the cow from being
+// booked, closures first, or nil
+func blockingEntry(entries []models.CalendarEntry, cow *models.Cow) *models.CalendarEntry {
+	var blackout *models.CalendarEntry
+	for i := range entries {
+		if !entries[i].Applies(cow) {
+			continue
+		}
+		if entries[i].Details.Kind == models.CalendarClosure {
+			return &entries[i]
+		}
+		if blackout == nil {
+			blackout = &entries[i]
+		}
+	}
+	return blackout
+}
+
+// unavailableReason is a helper function explaining why a calendar entry keeps cows from
+// being booked
+func unavailableReason(entry *models.CalendarEntry) string {
+	if entry.Details.Kind == models.CalendarClosure {
+		return "closed: " + entry.Details.Reason
+	}
+	return "blacked out: " + entry.Details.Reason
+}
+
+// bookingSpan is a helper function returning when a booking starts and ends. Bookings
+// without a later end are taken to last a minute
+func bookingSpan(booking models.BookDetails) (time.Time, time.Time) {
+	start, end := booking.StartDate.Time().UTC(), booking.EndDate.Time().UTC()
+	if !end.After(start) {
+		end = start.Add(time.Minute)
+	}
+	return start, end
+}
+
+// spanParams is a helper function parsing the query parameters ?from and ?to as the time
+// range [from, to)
+func spanParams(r *http.Request) (time.Time, time.Time, error) {
+	var span [2]time.Time
+	for i, name := range []string{"from", "to"} {
+		t, err := time.Parse(time.RFC3339, r.URL.Query().Get(name))
+		if err != nil {
+			return span[0], span[1], api.InvalidParameter(errors.New(name + " must be an RFC 3339 time, eg. 2024-09-01T00:00:00Z"))
+		}
+		span[i] = t.UTC()
+	}
+	if !span[1].After(span[0]) {
+		return span[0], span[1], api.InvalidParameter(errors.New("to must be after from"))
+	}
+	return span[0], span[1], nil
+}
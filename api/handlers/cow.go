@@ -3,28 +3,34 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"reflect"
 	"time"
 
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 
-	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
 )
 
 type Cow struct {
-	DB databases.CowDatabase
+	DB       databases.CowDatabase
+	Devices  databases.DeviceDatabase // used to list the child devices of a cow
+	Versions databases.VersionDatabase
+	Audit    Auditor
+	Approval Approval       // holds bookings of cows with an approval policy
+	Waitlist Waitlist       // offers the blocks of cancelled bookings to the next person waiting
+	Policies BookingPolicy  // limits the bookings users make
+	Calendar Calendar       // refuses bookings on closures and blackouts
+	Zone     *time.Location // the days of blocks, config.Config.TimeZone
 }
 
 // CowHandler returns all cows
 func (c Cow) CowHandler(w http.ResponseWriter, r *http.Request) {
-	dbResp, err := c.DB.Find(context.TODO(), bson.M{})
+	dbResp, err := c.DB.Find(context.TODO(), nil)
 	if err != nil {
-		config.ErrorStatus("failed to get cows", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get cows", err)
 		return
 	}
 
@@ -34,7 +40,7 @@ func (c Cow) CowHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -47,19 +53,19 @@ func (c Cow) CowHandlerQuery(w http.ResponseWriter, r *http.Request) {
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&query); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
 		return
 	}
 
-	dbResp, err := c.DB.Find(context.TODO(), bson.M{"detials.name": query.Name}) // Search by cow name
+	dbResp, err := c.DB.Find(context.TODO(), databases.FilterCows().Name(query.Name)) // Search by cow name
 	if err != nil {
-		config.ErrorStatus("failed to get cows", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get cows", err)
 		return
 	}
 
@@ -69,7 +75,7 @@ func (c Cow) CowHandlerQuery(w http.ResponseWriter, r *http.Request) {
 	}
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -78,23 +84,21 @@ func (c Cow) CowHandlerQuery(w http.ResponseWriter, r *http.Request) {
 
 // CowByIDHandler returns a cow by ID
 func (c Cow) CowByObjectIDHandler(w http.ResponseWriter, r *http.Request) {
-	cowID := mux.Vars(r)["cow_id"]
-
-	cID, err := primitive.ObjectIDFromHex(cowID)
+	cowID, err := idParam(r, "cow_id")
 	if err != nil {
-		config.ErrorStatus("failed to get objectID from Hex", http.StatusBadRequest, w, err)
+		api.WriteError(w, r, "invalid cow ID", err)
 		return
 	}
 
-	dbResp, err := c.DB.FindOne(context.Background(), bson.M{"_id": cID})
+	dbResp, err := c.DB.FindOne(context.Background(), databases.FilterCows().ID(cowID))
 	if err != nil {
-		config.ErrorStatus("failed to get cow by ID", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get cow by ID", err)
 		return
 	}
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -109,30 +113,37 @@ func (c Cow) NewCowHandler(w http.ResponseWriter, r *http.Request) {
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&cowDetails); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&cowDetails); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	cowDetails.Business = ownCowBusiness(r, cowDetails.Business)
+	if err := checkManage(r, cowDetails.Business); err != nil {
+		api.WriteError(w, r, "failed to insert cow", err)
 		return
 	}
 
 	newCow := models.Cow{
-		ID:      primitive.NewObjectID().Hex(),
+		ID:      models.NewID(),
 		Details: cowDetails,
 	}
 
 	result, err := c.DB.InsertOne(ctx, newCow)
 	if err != nil {
-		config.ErrorStatus("failed to insert cow", http.StatusBadRequest, w, err)
+		api.WriteError(w, r, "failed to insert cow", err)
 		return
 	}
+	c.Audit.cow(r, models.AuditCreate, nil, &newCow)
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"result": result}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
@@ -146,41 +157,49 @@ func (c Cow) UpdateCowHandler(w http.ResponseWriter, r *http.Request) {
 	var newDetails models.CowDetails // Json data will represent the cow details model
 	defer cancel()
 
-	cowID := mux.Vars(r)["cow_id"]
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&newDetails); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&newDetails); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
 		return
 	}
 
-	e := reflect.ValueOf(&newDetails).Elem()
-	var update bson.M = bson.M{}
-
-	// Only get provided values to update
-	for i := 0; i < e.NumField(); i++ {
-		varName := e.Type().Field(i).Name
-		varValue := e.Field(i).Interface()
-		if varValue != nil && varValue != "" && varName != "Bookings" && varName != "Devices" {
-			update["Cow."+varName] = varValue
-		}
+	update := cowUpdate(newDetails)
+	if update.Empty() {
+		api.WriteError(w, r, "nothing to update", databases.ErrEmptyUpdate)
+		return
 	}
 
-	dbResp, err := c.DB.UpdateOne(ctx, bson.M{"_id": cowID}, bson.M{"$set": update})
+	before, err := c.findForAudit(ctx, cowID)
+	if err != nil {
+		api.WriteError(w, r, "failed to get cow by ID", err)
+		return
+	}
+	if err := c.checkChange(r, before, newDetails.Business); err != nil {
+		api.WriteError(w, r, "the cow could not be updated", err)
+		return
+	}
+	dbResp, err := c.DB.UpdateOne(ctx, databases.FilterCows().ID(cowID), update)
 	if err != nil {
-		config.ErrorStatus("the cow could not be updated", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "the cow could not be updated", err)
 		return
 	}
+	c.recordUpdate(ctx, r, before)
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -190,33 +209,43 @@ func (c Cow) UpdateCowHandler(w http.ResponseWriter, r *http.Request) {
 func (c Cow) AddDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	var newDevice models.NewDeviceToCow
 
-	cowID := mux.Vars(r)["cow_id"]
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&newDevice); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&newDevice); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
 		return
 	}
 
-	update := bson.M{
-		"Cow.Devices": newDevice,
+	before, err := c.findForAudit(context.TODO(), cowID)
+	if err != nil {
+		api.WriteError(w, r, "failed to get cow by ID", err)
+		return
 	}
-
-	dbResp, err := c.DB.UpdateOne(context.TODO(), bson.M{"_id": cowID}, bson.M{"$push": update})
+	if err := c.checkChange(r, before, ""); err != nil {
+		api.WriteError(w, r, "the device could not be inserted into the cow", err)
+		return
+	}
+	dbResp, err := c.DB.UpdateOne(context.TODO(), databases.FilterCows().ID(cowID), databases.UpdateCow().PushDevice(newDevice.ID))
 	if err != nil {
-		config.ErrorStatus("the device could not be inserted into the cow", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "the device could not be inserted into the cow", err)
 		return
 	}
+	c.recordUpdate(context.TODO(), r, before)
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -224,25 +253,103 @@ func (c Cow) AddDeviceHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func (c Cow) GetBookingsHandler(w http.ResponseWriter, r *http.Request) {
-	cowID := mux.Vars(r)["cow_id"]
-
-	cID, err := primitive.ObjectIDFromHex(cowID)
+	cowID, err := idParam(r, "cow_id")
 	if err != nil {
-		config.ErrorStatus("failed to get objectID from Hex", http.StatusBadRequest, w, err)
+		api.WriteError(w, r, "invalid cow ID", err)
 		return
 	}
 
-	dbResp, err := c.DB.FindOne(context.Background(), bson.M{"_id": cID})
+	dbResp, err := c.DB.FindOne(context.Background(), databases.FilterCows().ID(cowID))
 	if err != nil {
-		config.ErrorStatus("failed to get cow by ID", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get cow by ID", err)
 		return
 	}
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp.Details.Bookings}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 }
+
+// findForAudit is a helper function reading a cow before a v1 handler changes it. v1
+// answers changes to missing cows with an empty result, so a missing cow is nil, not an error
+func (c Cow) findForAudit(ctx context.Context, cowID models.ID) (*models.Cow, error) {
+	cow, err := c.DB.FindOne(ctx, databases.FilterCows().ID(cowID))
+	if errors.Is(err, databases.ErrNotFound) {
+		return nil, nil
+	}
+	return cow, err
+}
+
+// checkChange is a helper function refusing v1 changes to a cow of another business, or
+// moving it to another business, see checkManage. Missing cows are left to the update
+func (c Cow) checkChange(r *http.Request, cow *models.Cow, business models.ID) error {
+	if cow != nil {
+		if err := checkManage(r, cow.Details.Business); err != nil {
+			return err
+		}
+	}
+	if !business.IsZero() {
+		return checkManage(r, business)
+	}
+	return nil
+}
+
+// checkSlot is a helper function refusing a booking of a block the cow already has a
+// booking for that day, or that is held for someone on its waitlist. Bookings without a
+// block aren't checked
+func (c Cow) checkSlot(ctx context.Context, r *http.Request, cow *models.Cow, booking models.BookDetails) error {
+	if booking.Block == "" {
+		return nil
+	}
+	date := slotDate(booking, c.Zone)
+	if slotBooked(cow, date, booking.Block, c.Zone) {
+		return errSlotBooked
+	}
+	return c.Waitlist.held(ctx, r, cow.ID, date, booking.Block)
+}
+
+// bookedBy is a helper function returning the signed in user making a booking, empty for
+// API keys
+func bookedBy(r *http.Request) models.ID {
+	if actor, _ := api.UserFromContext(r.Context()); actor != nil {
+		return actor.ID
+	}
+	return ""
+}
+
+// recordUpdate is a helper function recording the update of a cow, reading how it looks
+// now. Nothing is recorded when the cow didn't exist
+func (c Cow) recordUpdate(ctx context.Context, r *http.Request, before *models.Cow) {
+	if before == nil || !c.Audit.enabled() {
+		return
+	}
+	after, err := c.DB.FindOne(ctx, databases.FilterCows().ID(before.ID))
+	if err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to read a cow for the audit log", "cow", before.ID)
+		after = nil
+	}
+	c.Audit.cow(r, models.AuditUpdate, before, after)
+}
+
+// cowUpdate is a helper function building an update from the provided cow details.
+// Only set values are updated, bookings and devices have their own handlers
+func cowUpdate(details models.CowDetails) *databases.CowUpdate {
+	update := databases.UpdateCow()
+	if details.Name != "" {
+		update.SetName(details.Name)
+	}
+	if !details.Business.IsZero() {
+		update.SetBusiness(details.Business)
+	}
+	if details.Collection != "" {
+		update.SetCollection(details.Collection)
+	}
+	if details.DeviceTotal != 0 {
+		update.SetDeviceTotal(details.DeviceTotal)
+	}
+	return update
+}
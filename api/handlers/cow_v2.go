@@ -0,0 +1,539 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/thanhpk/randstr"
+)
+
+// errNotYourBooking is returned when someone cancels a booking they didn't make
+var errNotYourBooking = api.Forbidden(errors.New("only the user who booked and Admins of the cow's business can cancel a booking"))
+
+// ListCows returns a page of cows, optionally filtered by ?name, ?business and ?collection
+func (c Cow) ListCows(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+
+	filter := scopedCows(r).Limit(p.Limit).Skip(p.Offset)
+	if name := r.URL.Query().Get("name"); name != "" {
+		filter.Name(name)
+	}
+	if !business.IsZero() {
+		if err := checkKeyBusiness(r, business); err != nil {
+			api.WriteError(w, r, "failed to get cows", err)
+			return
+		}
+		filter.Business(business)
+	}
+	if collection := r.URL.Query().Get("collection"); collection != "" {
+		filter.Collection(collection)
+	}
+
+	cows, err := c.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get cows", err)
+		return
+	}
+	if cows == nil {
+		cows = []models.Cow{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": cows, "page": p})
+}
+
+// CreateCow inserts a new cow and returns it with its location
+func (c Cow) CreateCow(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var details models.CowDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&details); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	details.Business = ownCowBusiness(r, details.Business)
+	if err := checkManage(r, details.Business); err != nil {
+		api.WriteError(w, r, "failed to insert cow", err)
+		return
+	}
+
+	// Bookings and devices are added through their own endpoints
+	details.Bookings = []models.BookDetails{}
+	if details.Devices == nil {
+		details.Devices = []models.ID{}
+	}
+
+	cow := models.Cow{ID: models.NewID(), Details: details}
+	if _, err := c.DB.InsertOne(ctx, cow); err != nil {
+		api.WriteError(w, r, "failed to insert cow", err)
+		return
+	}
+	c.Audit.cow(r, models.AuditCreate, nil, &cow)
+
+	w.Header().Set("Location", "/api/v2/cows/"+cow.ID.String())
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": cow})
+}
+
+// GetCow returns a cow by ID
+func (c Cow) GetCow(w http.ResponseWriter, r *http.Request) {
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	cow, err := c.DB.FindOne(r.Context(), scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": cow})
+}
+
+// PatchCow updates the provided name, business, collection or device total of a cow and
+// returns the updated cow
+func (c Cow) PatchCow(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	var details models.CowDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+
+	update := cowUpdate(details)
+	if update.Empty() {
+		api.WriteError(w, r, "nothing to update", databases.ErrEmptyUpdate)
+		return
+	}
+	before, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	if err := checkManage(r, before.Details.Business); err != nil {
+		api.WriteError(w, r, "the cow could not be updated", err)
+		return
+	}
+	if !details.Business.IsZero() {
+		if err := checkManage(r, details.Business); err != nil {
+			api.WriteError(w, r, "the cow could not be updated", err)
+			return
+		}
+	}
+
+	result, err := c.DB.UpdateOne(ctx, scopedCows(r).ID(cowID), update)
+	if err != nil {
+		api.WriteError(w, r, "the cow could not be updated", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "cow not found", databases.ErrNotFound)
+		return
+	}
+
+	cow, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get cow by ID", err)
+		return
+	}
+	c.Audit.cow(r, models.AuditUpdate, before, cow)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": cow})
+}
+
+// DeleteCow removes a cow along with its bookings
+func (c Cow) DeleteCow(w http.ResponseWriter, r *http.Request) {
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	// read first so the audit log keeps the bookings that go with it
+	cow, err := c.DB.FindOne(r.Context(), scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	if err := checkManage(r, cow.Details.Business); err != nil {
+		api.WriteError(w, r, "the cow could not be deleted", err)
+		return
+	}
+	result, err := c.DB.DeleteOne(r.Context(), scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "the cow could not be deleted", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "cow not found", databases.ErrNotFound)
+		return
+	}
+	c.Audit.cow(r, models.AuditDelete, cow, nil)
+	if c.Approval.Policies != nil {
+		// the SQL backends already removed it with the cow
+		if _, err := c.Approval.Policies.DeleteOne(r.Context(), databases.FilterApprovalPolicies().Cow(cowID)); err != nil {
+			zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to delete the approval policy of a deleted cow", "cow", cowID)
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ListCowDevices returns the devices whose parent is the cow
+func (c Cow) ListCowDevices(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+
+	if _, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID)); err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+
+	devices, err := c.Devices.Find(ctx, databases.FilterDevices().Parent(cowID).Limit(p.Limit).Skip(p.Offset))
+	if err != nil {
+		api.WriteError(w, r, "failed to get devices", err)
+		return
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": devices, "page": p})
+}
+
+// AddCowDevice adds a device ID to the device list of a cow and returns the new list
+func (c Cow) AddCowDevice(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	var newDevice models.NewDeviceToCow
+	if err := json.NewDecoder(r.Body).Decode(&newDevice); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&newDevice); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	before, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	if err := checkManage(r, before.Details.Business); err != nil {
+		api.WriteError(w, r, "the device could not be added to the cow", err)
+		return
+	}
+	result, err := c.DB.UpdateOne(ctx, scopedCows(r).ID(cowID), databases.UpdateCow().PushDevice(newDevice.ID))
+	if err != nil {
+		api.WriteError(w, r, "the device could not be added to the cow", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "cow not found", databases.ErrNotFound)
+		return
+	}
+
+	cow, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get cow by ID", err)
+		return
+	}
+	c.Audit.cow(r, models.AuditUpdate, before, cow)
+
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": cow.Details.Devices})
+}
+
+// ListCowBookings returns every booking of a cow
+func (c Cow) ListCowBookings(w http.ResponseWriter, r *http.Request) {
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	cow, err := c.DB.FindOne(r.Context(), scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	bookings := cow.Details.Bookings
+	if bookings == nil {
+		bookings = []models.BookDetails{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": bookings})
+}
+
+// CreateCowBooking adds a booking to a cow and returns it with its generated ID
+func (c Cow) CreateCowBooking(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	var booking models.BookDetails
+	if err := json.NewDecoder(r.Body).Decode(&booking); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&booking); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	if booking.Devices == nil {
+		booking.Devices = []models.ID{}
+	}
+	booking.ID = fmt.Sprintf("%s.%s", cowID, randstr.Hex(16))
+	booking.User = bookedBy(r)
+
+	cow, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	if err := checkBusiness(r, cow.Details.Business); err != nil {
+		api.WriteError(w, r, "the booking could not be added to the cow", err)
+		return
+	}
+	if err := c.checkSlot(ctx, r, cow, booking); err != nil {
+		api.WriteError(w, r, "the booking could not be added to the cow", err)
+		return
+	}
+	if err := c.Calendar.check(ctx, r, cow, booking); err != nil {
+		api.WriteError(w, r, "the cow can't be booked then", err)
+		return
+	}
+	if err := c.Policies.check(ctx, r, cow, booking); err != nil {
+		api.WriteError(w, r, "the booking breaks a booking policy", err)
+		return
+	}
+
+	// cows with an approval policy are booked once an approver agrees, 202 with the request
+	request, err := c.Approval.request(ctx, r, cowID, booking)
+	if err != nil {
+		api.WriteError(w, r, "the booking could not be requested", err)
+		return
+	}
+	if request != nil {
+		w.Header().Set("Location", "/api/v2/booking-requests/"+request.ID.String())
+		writeResult(w, r, http.StatusAccepted, map[string]interface{}{"result": request})
+		return
+	}
+
+	result, err := pushBooking(ctx, c.DB, scopedCows(r).ID(cowID), cowID, booking, c.Zone)
+	if err != nil {
+		api.WriteError(w, r, "the booking could not be added to the cow", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "cow not found", databases.ErrNotFound)
+		return
+	}
+	if err := c.Policies.confirm(ctx, r, cow, booking); err != nil {
+		api.WriteError(w, r, "the booking breaks a booking policy", err)
+		return
+	}
+	c.Audit.booking(r, cow, booking)
+	c.Waitlist.booked(ctx, r, cow.ID, booking)
+
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": booking})
+}
+
+// CancelCowBooking removes a booking from a cow and offers its block to the first person on
+// its waitlist. Only the user who booked, Admins of the cow's business and SuperUsers can
+func (c Cow) CancelCowBooking(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+	cow, err := c.DB.FindOne(ctx, scopedCows(r).ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	var booking *models.BookDetails
+	for i := range cow.Details.Bookings {
+		if cow.Details.Bookings[i].ID == mux.Vars(r)["booking_id"] {
+			booking = &cow.Details.Bookings[i]
+		}
+	}
+	if booking == nil {
+		api.WriteError(w, r, "booking not found", databases.ErrNotFound)
+		return
+	}
+	if actor, _ := api.UserFromContext(r.Context()); booking.User != actor.ID && (actor.Details.UserType > models.TypeAdmin || !ownBusiness(r, cow.Details.Business)) {
+		api.WriteError(w, r, "the booking could not be cancelled", errNotYourBooking)
+		return
+	}
+
+	result, err := c.DB.UpdateOne(ctx, scopedCows(r).ID(cowID), databases.UpdateCow().PullBooking(booking.ID))
+	if err != nil {
+		api.WriteError(w, r, "the booking could not be cancelled", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "cow not found", databases.ErrNotFound)
+		return
+	}
+	c.Audit.cancelled(r, cow, *booking)
+	if booking.Block != "" {
+		c.Waitlist.promote(ctx, cow.ID, slotDate(*booking, c.Zone), booking.Block)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SearchAvailability returns a page of cows, filtered by ?business and ?collection, saying
+// whether each can be booked from ?from to ?to, and with ?block for that block of the day
+// ?from falls on. Cows aren't available when booked then, held for their waitlist, or
+// kept from being booked by a closure or blackout
+func (c Cow) SearchAvailability(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	from, to, err := spanParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid time range", err)
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+
+	filter := scopedCows(r).Limit(p.Limit).Skip(p.Offset)
+	if !business.IsZero() {
+		if err := checkKeyBusiness(r, business); err != nil {
+			api.WriteError(w, r, "failed to search availability", err)
+			return
+		}
+		filter.Business(business)
+	}
+	if collection := r.URL.Query().Get("collection"); collection != "" {
+		filter.Collection(collection)
+	}
+	cows, err := c.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get cows", err)
+		return
+	}
+
+	var businesses []models.ID
+	seen := map[models.ID]bool{}
+	for _, cow := range cows {
+		if !seen[cow.Details.Business] {
+			seen[cow.Details.Business] = true
+			businesses = append(businesses, cow.Details.Business)
+		}
+	}
+	entries, err := c.Calendar.entries(ctx, businesses, from, to)
+	if err != nil {
+		api.WriteError(w, r, "failed to get the calendar", err)
+		return
+	}
+
+	block := strings.TrimSpace(r.URL.Query().Get("block"))
+	date := from.In(zoneOf(c.Zone)).Format(slotDateLayout)
+	result := []models.CowAvailability{}
+	for i := range cows {
+		cow := &cows[i]
+		availability := models.CowAvailability{Cow: cow.ID, Name: cow.Details.Name, Collection: cow.Details.Collection}
+		if entry := blockingEntry(entries, cow); entry != nil {
+			availability.Reason = unavailableReason(entry)
+		} else if bookedBetween(cow, from, to) || (block != "" && slotBooked(cow, date, block, c.Zone)) {
+			availability.Reason = "booked"
+		} else if block != "" {
+			if err := c.Waitlist.held(ctx, r, cow.ID, date, block); errors.Is(err, errSlotHeld) {
+				availability.Reason = "held for the waitlist"
+			} else if err != nil {
+				api.WriteError(w, r, "failed to get the waitlist", err)
+				return
+			}
+		}
+		availability.Available = availability.Reason == ""
+		result = append(result, availability)
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": result, "page": p})
+}
+
+// bookedBetween is a helper function reporting whether a booking of the cow overlaps
+// [from, to)
+func bookedBetween(cow *models.Cow, from, to time.Time) bool {
+	for _, booking := range cow.Details.Bookings {
+		if booking.StartDate.Time().Before(to) && booking.EndDate.Time().After(from) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+func TestCowWritesNeedAdmin(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, teacher := a.user(models.TypeUser, business)
+	_, admin := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, models.NewID())
+	cow := a.cow(business)
+	path := "/api/v2/cows/" + cow.ID.String()
+
+	for _, tc := range []struct {
+		name  string
+		token string
+	}{
+		{"a User of the business", teacher},
+		{"an Admin of another business", otherAdmin},
+	} {
+		expect(t, a.do(http.MethodPost, "/api/v2/cows", tc.token, models.CowDetails{Name: "CA-99", Business: business}), http.StatusForbidden, "POST /api/v2/cows by "+tc.name)
+		expect(t, a.do(http.MethodPatch, path, tc.token, models.CowDetails{Collection: "iPad"}), http.StatusForbidden, "PATCH by "+tc.name)
+		expect(t, a.do(http.MethodPost, path+"/devices", tc.token, models.NewDeviceToCow{ID: models.NewID()}), http.StatusForbidden, "POST devices by "+tc.name)
+		expect(t, a.do(http.MethodDelete, path, tc.token, nil), http.StatusForbidden, "DELETE by "+tc.name)
+		expect(t, a.do(http.MethodPost, "/api/v1/cows/update/"+cow.ID.String(), tc.token, models.CowDetails{Collection: "iPad"}), http.StatusForbidden, "v1 update by "+tc.name)
+		expect(t, a.do(http.MethodPost, "/api/v2/devices", tc.token, models.DeviceDetails{Parent: cow.ID}), http.StatusForbidden, "POST /api/v2/devices by "+tc.name)
+	}
+	if got := a.findCow(cow.ID); got.Details.Collection != "Laptop" {
+		t.Errorf("a refused change was saved: %+v", got.Details)
+	}
+
+	// Users of the business still book its cows
+	expect(t, a.do(http.MethodPost, path+"/bookings", teacher, bookingOn(3, "1")), http.StatusCreated, "booking by a User of the business")
+
+	expect(t, a.do(http.MethodPatch, path, admin, models.CowDetails{Collection: "iPad"}), http.StatusOK, "PATCH by an Admin of the business")
+	expect(t, a.do(http.MethodPost, "/api/v2/cows", admin, models.CowDetails{Name: "CA-99"}), http.StatusCreated, "POST /api/v2/cows by an Admin of the business")
+	expect(t, a.do(http.MethodDelete, path, admin, nil), http.StatusNoContent, "DELETE by an Admin of the business")
+}
+
+func TestConcurrentBookings(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	cow := a.cow(business)
+	path := "/api/v2/cows/" + cow.ID.String() + "/bookings"
+
+	const n = 8
+	tokens := make([]string, n)
+	for i := range tokens {
+		_, tokens[i] = a.user(models.TypeUser, business)
+	}
+	codes := make([]int, n)
+	var wg sync.WaitGroup
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = a.do(http.MethodPost, path, tokens[i], bookingOn(3, "1")).Code
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, code := range codes {
+		switch code {
+		case http.StatusCreated:
+			created++
+		case http.StatusConflict:
+		default:
+			t.Errorf("booking the same block at once returned %d, want 201 or 409", code)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d of %d requests booked the same block, want 1", created, n)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 {
+		t.Errorf("the cow has %d bookings, want 1", len(got.Details.Bookings))
+	}
+
+	rec := a.do(http.MethodPost, "/api/v1/cow/book/"+cow.ID.String(), tokens[0], bookingOn(3, "1"))
+	expect(t, rec, http.StatusConflict, "v1 booking of a booked block")
+	expect(t, a.do(http.MethodPost, path, tokens[0], bookingOn(3, "2")), http.StatusCreated, "booking another block of the day")
+	expect(t, a.do(http.MethodPost, path, tokens[0], bookingOn(4, "1")), http.StatusCreated, "booking the block on another day")
+}
+
+// staleCows reads every cow as it was before it was booked, as a request checking the
+// block just before another books it does
+type staleCows struct{ databases.CowDatabase }
+
+func (s staleCows) FindOne(ctx context.Context, filter *databases.CowFilter) (*models.Cow, error) {
+	cow, err := s.CowDatabase.FindOne(ctx, filter)
+	if cow != nil {
+		cow.Details.Bookings = []models.BookDetails{}
+	}
+	return cow, err
+}
+
+func TestBookingChecksTheBlockWhenWriting(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, teacher := a.user(models.TypeUser, business)
+	cow := a.cow(business)
+
+	stale := Cow{DB: staleCows{a.store.Cows()}}
+	router := mux.NewRouter()
+	router.Use(api.Authenticate(a.store.Users(), a.store.APIKeys()))
+	router.HandleFunc("/cows/{cow_id}/bookings", stale.CreateCowBooking).Methods(http.MethodPost)
+	router.HandleFunc("/cows/book/{cow_id}", stale.BookingHandler).Methods(http.MethodPost)
+	book := func(path string) int {
+		b, _ := json.Marshal(bookingOn(3, "1"))
+		req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(b))
+		req.Header.Set("Authorization", "Bearer "+teacher)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := book("/cows/" + cow.ID.String() + "/bookings"); code != http.StatusCreated {
+		t.Fatalf("booking a free block returned %d, want 201", code)
+	}
+	if code := book("/cows/" + cow.ID.String() + "/bookings"); code != http.StatusConflict {
+		t.Errorf("booking a block booked since it was checked returned %d, want 409", code)
+	}
+	if code := book("/cows/book/" + cow.ID.String()); code != http.StatusConflict {
+		t.Errorf("v1 booking of a block booked since it was checked returned %d, want 409", code)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 {
+		t.Errorf("the cow has %d bookings, want 1", len(got.Details.Bookings))
+	}
+}
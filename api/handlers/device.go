@@ -3,28 +3,29 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
-	"reflect"
 	"time"
 
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 
-	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
 )
 
 type Device struct {
-	DB databases.DeviceDatabase
+	DB       databases.DeviceDatabase
+	Cows     databases.CowDatabase // the business of a device is that of its parent cow
+	Versions databases.VersionDatabase
+	Audit    Auditor
 }
 
 // DeviceHandler returns all cows
 func (d Device) DeviceHandler(w http.ResponseWriter, r *http.Request) {
-	dbResp, err := d.DB.Find(context.TODO(), bson.M{})
+	dbResp, err := d.DB.Find(context.TODO(), nil)
 	if err != nil {
-		config.ErrorStatus("failed to get devices", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get devices", err)
 		return
 	}
 
@@ -35,7 +36,7 @@ func (d Device) DeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -48,19 +49,19 @@ func (d Device) DeviceHandlerQuery(w http.ResponseWriter, r *http.Request) {
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&query); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
 		return
 	}
 
-	dbResp, err := d.DB.Find(context.TODO(), bson.M{"detials.name": query.Name}) // Search by device name
+	dbResp, err := d.DB.Find(context.TODO(), databases.FilterDevices().Name(query.Name)) // Search by device name
 	if err != nil {
-		config.ErrorStatus("failed to get cow(s)", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get cow(s)", err)
 		return
 	}
 
@@ -71,7 +72,7 @@ func (d Device) DeviceHandlerQuery(w http.ResponseWriter, r *http.Request) {
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -80,17 +81,21 @@ func (d Device) DeviceHandlerQuery(w http.ResponseWriter, r *http.Request) {
 
 // DeviceByIDHandler returns a cow by ID
 func (d Device) DeviceByObjectIDHandler(w http.ResponseWriter, r *http.Request) {
-	deviceID := mux.Vars(r)["device_id"]
+	deviceID, err := idParam(r, "device_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid device ID", err)
+		return
+	}
 
-	dbResp, err := d.DB.FindOne(context.Background(), bson.M{"_id": deviceID})
+	dbResp, err := d.DB.FindOne(context.Background(), databases.FilterDevices().ID(deviceID))
 	if err != nil {
-		config.ErrorStatus("failed to get device by ObjectID", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to get device by ObjectID", err)
 		return
 	}
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
@@ -105,30 +110,36 @@ func (d Device) NewDeviceHandler(w http.ResponseWriter, r *http.Request) {
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&deviceDetails); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&deviceDetails); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	if err := d.checkParent(ctx, r, deviceDetails.Parent); err != nil {
+		api.WriteError(w, r, "failed to insert device", err)
 		return
 	}
 
 	newDevice := models.Device{
-		ID:      primitive.NewObjectID().Hex(),
+		ID:      models.NewID(),
 		Details: deviceDetails,
 	}
 
 	result, err := d.DB.InsertOne(ctx, newDevice)
 	if err != nil {
-		config.ErrorStatus("failed to insert device", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to insert device", err)
 		return
 	}
+	d.Audit.device(r, models.AuditCreate, d.business(ctx, r, newDevice.Details.Parent), nil, &newDevice)
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"result": result}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusCreated)
@@ -141,54 +152,112 @@ func (d Device) UpdateDeviceHandler(w http.ResponseWriter, r *http.Request) {
 	var newDetails models.DeviceDetails // Json data will represent the cow details model
 	defer cancel()
 
-	deviceID := mux.Vars(r)["device_id"]
+	deviceID, err := idParam(r, "device_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid device ID", err)
+		return
+	}
 
 	// validate the request body
 	if err := json.NewDecoder(r.Body).Decode(&newDetails); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
 
 	// use the validator library to validate required fields
 	if validationErr := validate.Struct(&newDetails); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+		api.WriteError(w, r, "invalid request body", validationErr)
 		return
 	}
 
-	e := reflect.ValueOf(&newDetails).Elem()
-	var update bson.M = bson.M{}
+	update := deviceUpdate(newDetails)
+	if update.Empty() {
+		api.WriteError(w, r, "nothing to update", databases.ErrEmptyUpdate)
+		return
+	}
 
-	// Only get provided values to update
-	for i := 0; i < e.NumField(); i++ {
-		varName := e.Type().Field(i).Name
-		varValue := e.Field(i).Interface()
-		if varValue != nil && varValue != "" {
-			update["Device."+varName] = varValue
+	before, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(deviceID))
+	if errors.Is(err, databases.ErrNotFound) {
+		// v1 answers updates of missing devices with an empty result
+		before, err = nil, nil
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to get device by ID", err)
+		return
+	}
+	if before != nil {
+		if err := d.checkParent(ctx, r, before.Details.Parent); err != nil {
+			api.WriteError(w, r, "the device could not be updated", err)
+			return
 		}
 	}
-
-	dbResp, err := d.DB.UpdateOne(ctx, bson.M{"_id": deviceID}, bson.M{"$set": update})
+	if !newDetails.Parent.IsZero() {
+		if err := d.checkParent(ctx, r, newDetails.Parent); err != nil {
+			api.WriteError(w, r, "the device could not be updated", err)
+			return
+		}
+	}
+	dbResp, err := d.DB.UpdateOne(ctx, databases.FilterDevices().ID(deviceID), update)
 	if err != nil {
-		config.ErrorStatus("the device could not be updated", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "the device could not be updated", err)
 		return
 	}
+	if before != nil {
+		d.recordUpdate(ctx, r, before)
+	}
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 }
 
+// business is a helper function returning the business of the parent cow of a device for
+// the audit log, empty when the device has no parent or it can't be read
+func (d Device) business(ctx context.Context, r *http.Request, parent models.ID) models.ID {
+	if parent.IsZero() || !d.Audit.enabled() {
+		return ""
+	}
+	cow, err := d.Cows.FindOne(ctx, databases.FilterCows().ID(parent))
+	if err != nil {
+		if !errors.Is(err, databases.ErrNotFound) {
+			zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to read the cow of a device for the audit log", "cow", parent)
+		}
+		return ""
+	}
+	return cow.Details.Business
+}
+
+// recordUpdate is a helper function recording the update of a device, reading how it
+// looks now
+func (d Device) recordUpdate(ctx context.Context, r *http.Request, before *models.Device) {
+	if !d.Audit.enabled() {
+		return
+	}
+	after, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(before.ID))
+	if err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to read a device for the audit log", "device", before.ID)
+		d.Audit.device(r, models.AuditUpdate, d.business(ctx, r, before.Details.Parent), before, nil)
+		return
+	}
+	d.Audit.device(r, models.AuditUpdate, d.business(ctx, r, after.Details.Parent), before, after)
+}
+
 // Is able to get child devices with a prodived Cow Model
 func (d Device) GetChildDevices(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	cowID := mux.Vars(r)["cow_id"]
 	defer cancel()
 
-	devices, _ := d.DB.Find(ctx, bson.M{"Device.Parent": cowID})
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	devices, _ := d.DB.Find(ctx, databases.FilterDevices().Parent(cowID))
 
 	// If there is no devices from the query, return empty device array.
 	if len(devices) == 0 {
@@ -197,9 +266,25 @@ func (d Device) GetChildDevices(w http.ResponseWriter, r *http.Request) {
 
 	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": devices}})
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to marshal response", err)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
 	w.Write(b)
 }
+
+// deviceUpdate is a helper function building an update from the provided device details.
+// Only set values are updated
+func deviceUpdate(details models.DeviceDetails) *databases.DeviceUpdate {
+	update := databases.UpdateDevice()
+	if details.Type != "" {
+		update.SetType(details.Type)
+	}
+	if details.Name != "" {
+		update.SetName(details.Name)
+	}
+	if !details.Parent.IsZero() {
+		update.SetParent(details.Parent)
+	}
+	return update
+}
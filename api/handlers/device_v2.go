@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// ListDevices returns a page of devices, optionally filtered by ?name, ?type and ?parent
+func (d Device) ListDevices(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	parent, err := optionalIDParam(r, "parent")
+	if err != nil {
+		api.WriteError(w, r, "invalid parent ID", err)
+		return
+	}
+
+	filter := databases.FilterDevices().Limit(p.Limit).Skip(p.Offset)
+	if name := r.URL.Query().Get("name"); name != "" {
+		filter.Name(name)
+	}
+	if deviceType := r.URL.Query().Get("type"); deviceType != "" {
+		filter.Type(deviceType)
+	}
+	switch {
+	case !parent.IsZero():
+		if err := d.checkKeyCow(ctx, r, parent); err != nil {
+			api.WriteError(w, r, "parent cow not found", err)
+			return
+		}
+		filter.Parent(parent)
+	case api.KeyFromContext(r.Context()) != nil:
+		// devices belong to the business of their parent cow
+		cows, err := d.Cows.Find(ctx, scopedCows(r))
+		if err != nil {
+			api.WriteError(w, r, "failed to get cows", err)
+			return
+		}
+		ids := make([]models.ID, len(cows))
+		for i, cow := range cows {
+			ids[i] = cow.ID
+		}
+		filter.Parents(ids...)
+	}
+
+	devices, err := d.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get devices", err)
+		return
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": devices, "page": p})
+}
+
+// CreateDevice inserts a new device and returns it with its location
+func (d Device) CreateDevice(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var details models.DeviceDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&details); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	if err := d.checkParent(ctx, r, details.Parent); err != nil {
+		api.WriteError(w, r, "failed to insert device", err)
+		return
+	}
+
+	device := models.Device{ID: models.NewID(), Details: details}
+	if _, err := d.DB.InsertOne(ctx, device); err != nil {
+		api.WriteError(w, r, "failed to insert device", err)
+		return
+	}
+	d.Audit.device(r, models.AuditCreate, d.business(ctx, r, device.Details.Parent), nil, &device)
+
+	w.Header().Set("Location", "/api/v2/devices/"+device.ID.String())
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": device})
+}
+
+// GetDevice returns a device by ID
+func (d Device) GetDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID, err := idParam(r, "device_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid device ID", err)
+		return
+	}
+
+	device, err := d.DB.FindOne(r.Context(), databases.FilterDevices().ID(deviceID))
+	if err == nil {
+		err = d.checkKeyCow(r.Context(), r, device.Details.Parent)
+	}
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": device})
+}
+
+// PatchDevice updates the provided type, name or parent of a device and returns the updated device
+func (d Device) PatchDevice(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	deviceID, err := idParam(r, "device_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid device ID", err)
+		return
+	}
+
+	var details models.DeviceDetails
+	if err := json.NewDecoder(r.Body).Decode(&details); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+
+	update := deviceUpdate(details)
+	if update.Empty() {
+		api.WriteError(w, r, "nothing to update", databases.ErrEmptyUpdate)
+		return
+	}
+	if err := d.checkKeyDevice(ctx, r, deviceID); err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	before, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(deviceID))
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	if err := d.checkParent(ctx, r, before.Details.Parent); err != nil {
+		api.WriteError(w, r, "the device could not be updated", err)
+		return
+	}
+	if !details.Parent.IsZero() {
+		if err := d.checkParent(ctx, r, details.Parent); err != nil {
+			api.WriteError(w, r, "the device could not be updated", err)
+			return
+		}
+	}
+
+	result, err := d.DB.UpdateOne(ctx, databases.FilterDevices().ID(deviceID), update)
+	if err != nil {
+		api.WriteError(w, r, "the device could not be updated", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "device not found", databases.ErrNotFound)
+		return
+	}
+
+	device, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(deviceID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get device by ID", err)
+		return
+	}
+	d.Audit.device(r, models.AuditUpdate, d.business(ctx, r, device.Details.Parent), before, device)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": device})
+}
+
+// DeleteDevice removes a device
+func (d Device) DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	deviceID, err := idParam(r, "device_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid device ID", err)
+		return
+	}
+
+	if err := d.checkKeyDevice(r.Context(), r, deviceID); err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	device, err := d.DB.FindOne(r.Context(), databases.FilterDevices().ID(deviceID))
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	if err := d.checkParent(r.Context(), r, device.Details.Parent); err != nil {
+		api.WriteError(w, r, "the device could not be deleted", err)
+		return
+	}
+
+	result, err := d.DB.DeleteOne(r.Context(), databases.FilterDevices().ID(deviceID))
+	if err != nil {
+		api.WriteError(w, r, "the device could not be deleted", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "device not found", databases.ErrNotFound)
+		return
+	}
+	d.Audit.device(r, models.AuditDelete, d.business(r.Context(), r, device.Details.Parent), device, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkKeyCow is a helper function returning ErrNotFound for requests made with an API key
+// when cowID is not a cow of the key's business
+func (d Device) checkKeyCow(ctx context.Context, r *http.Request, cowID models.ID) error {
+	if api.KeyFromContext(r.Context()) == nil {
+		return nil
+	}
+	_, err := d.Cows.FindOne(ctx, scopedCows(r).ID(cowID))
+	return err
+}
+
+// checkKeyDevice is a helper function returning ErrNotFound for requests made with an API
+// key when the device doesn't belong to a cow of the key's business
+func (d Device) checkKeyDevice(ctx context.Context, r *http.Request, deviceID models.ID) error {
+	if api.KeyFromContext(r.Context()) == nil {
+		return nil
+	}
+	device, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(deviceID))
+	if err != nil {
+		return err
+	}
+	return d.checkKeyCow(ctx, r, device.Details.Parent)
+}
+
+// checkParent is a helper function refusing changes to the devices of a cow of another
+// business, see checkManage. Devices without a parent, or whose parent is gone, belong to
+// no business
+func (d Device) checkParent(ctx context.Context, r *http.Request, cowID models.ID) error {
+	var business models.ID
+	if !cowID.IsZero() {
+		cow, err := d.Cows.FindOne(ctx, databases.FilterCows().ID(cowID))
+		if err != nil && !errors.Is(err, databases.ErrNotFound) {
+			return err
+		}
+		if err == nil {
+			business = cow.Details.Business
+		}
+	}
+	return checkManage(r, business)
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api/openapi"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const (
+	openAPIPath = "/api/openapi.json"
+	docsPath    = "/api/docs"
+)
+
+// openAPIDocument describes every route registered in App.New. TestEveryRouteDocumented fails
+// when a registered route is missing here, so new routes must be documented when added
+func openAPIDocument() *openapi.Document {
+	doc := openapi.New(openapi.Info{
+		Title:       "DeviceBookingAPI",
+		Description: "Manage and book school resources such as laptop and iPad carts (cows). /api/v1 is deprecated, use /api/v2.",
+		Version:     "2.0.0",
+	}, envelopeSchema, models.ErrorResponse{})
+
+	paging := []openapi.Parameter{
+		{Name: "limit", In: "query", Description: "page size, 1 to 500, default 50", Schema: &openapi.Schema{Type: "integer"}},
+		{Name: "offset", In: "query", Description: "number of results to skip", Schema: &openapi.Schema{Type: "integer"}},
+	}
+	query := func(name, description string) openapi.Parameter {
+		return openapi.Parameter{Name: name, In: "query", Description: description, Schema: &openapi.Schema{Type: "string"}}
+	}
+	auditQuery := []openapi.Parameter{
+		query("actor", "user or API key ID"), query("action", "create, update or delete"), query("type", "cow, device, user or booking"),
+		query("entity", "ID of the changed entity"), query("request", "X-Request-ID of the change"), query("from", "RFC 3339 time, inclusive"),
+		query("to", "RFC 3339 time, exclusive"), query("business", "business ID, SuperUsers only"),
+	}
+
+	doc.Add(
+		openapi.Route{Method: "GET", Path: "/health", Summary: "Health check", Tag: "system", Result: models.HealthCheckResponse{}, Raw: true},
+		openapi.Route{Method: "GET", Path: openAPIPath, Summary: "This OpenAPI document", Tag: "system", Result: map[string]interface{}{}, Raw: true},
+		openapi.Route{Method: "GET", Path: docsPath, Summary: "Interactive API docs (HTML)", Tag: "system"},
+		openapi.Route{Method: "GET", Path: "/setup", Summary: "Whether the first SuperUser still has to be created", Tag: "system", Result: models.SetupStatus{}},
+		openapi.Route{Method: "POST", Path: "/setup", Summary: "Create the first SuperUser with the setup token from the logs", Tag: "system", Body: models.SetupRequest{}, Status: http.StatusCreated, Result: models.User{}},
+	)
+
+	// v1, kept for existing clients
+	v1 := []openapi.Route{
+		{Method: "GET", Path: "/api/v1/cow/{cow_id}", Summary: "Get a cow", Tag: "cows", Result: models.Cow{}},
+		{Method: "GET", Path: "/api/v1/cows", Summary: "List every cow", Tag: "cows", Result: []models.Cow{}},
+		{Method: "POST", Path: "/api/v1/cows", Summary: "Find cows by name", Tag: "cows", Body: models.Query{}, Result: []models.Cow{}},
+		{Method: "POST", Path: "/api/v1/cows/new", Summary: "Create a cow (Admins)", Tag: "cows", Body: models.CowDetails{}, Status: http.StatusCreated, Result: databases.InsertOneResult{}, Auth: true},
+		{Method: "POST", Path: "/api/v1/cows/update/{cow_id}", Summary: "Update a cow (Admins)", Tag: "cows", Body: models.CowDetails{}, Result: databases.UpdateResult{}, Auth: true},
+		{Method: "POST", Path: "/api/v1/cows/add_device/{cow_id}", Summary: "Add a device to a cow (Admins)", Tag: "cows", Body: models.NewDeviceToCow{}, Result: databases.UpdateResult{}, Auth: true},
+		{Method: "POST", Path: "/api/v1/cows/get_devices/{cow_id}", Summary: "List the devices of a cow", Tag: "cows", Result: []models.Device{}},
+		{Method: "GET", Path: "/api/v1/cows/bookings/{cow_id}", Summary: "List the bookings of a cow", Tag: "bookings", Result: []models.BookDetails{}},
+		{Method: "GET", Path: "/api/v1/device/{device_id}", Summary: "Get a device", Tag: "devices", Result: models.Device{}},
+		{Method: "GET", Path: "/api/v1/devices", Summary: "List every device", Tag: "devices", Result: []models.Device{}},
+		{Method: "POST", Path: "/api/v1/devices", Summary: "Find devices by name", Tag: "devices", Body: models.Query{}, Result: []models.Device{}},
+		{Method: "POST", Path: "/api/v1/devices/new", Summary: "Create a device (Admins)", Tag: "devices", Body: models.DeviceDetails{}, Status: http.StatusCreated, Result: databases.InsertOneResult{}, Auth: true},
+		{Method: "POST", Path: "/api/v1/devices/update/{device_id}", Summary: "Update a device (Admins)", Tag: "devices", Body: models.DeviceDetails{}, Result: databases.UpdateResult{}, Auth: true},
+		{Method: "POST", Path: "/api/v1/cow/book/{cow_id}", Summary: "Book a cow, or 202 with a booking request when its bookings need approval", Tag: "bookings", Body: models.BookDetails{}, Result: databases.UpdateResult{}, Auth: true},
+	}
+	for _, route := range v1 {
+		route.Deprecated = true
+		doc.Add(route)
+	}
+
+	doc.Add(
+		openapi.Route{Method: "GET", Path: "/api/v2/cows", Summary: "List cows", Tag: "cows", Result: []models.Cow{}, Paged: true,
+			Query: append([]openapi.Parameter{query("name", "exact cow name"), query("business", "business ID"), query("collection", "eg. Laptop")}, paging...)},
+		openapi.Route{Method: "POST", Path: "/api/v2/cows", Summary: "Create a cow (Admins)", Tag: "cows", Body: models.CowDetails{}, Status: http.StatusCreated, Result: models.Cow{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}", Summary: "Get a cow", Tag: "cows", Result: models.Cow{}},
+		openapi.Route{Method: "PATCH", Path: "/api/v2/cows/{cow_id}", Summary: "Update the provided fields of a cow (Admins)", Tag: "cows", Body: models.CowDetails{}, Result: models.Cow{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/cows/{cow_id}", Summary: "Delete a cow and its bookings (Admins)", Tag: "cows", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/devices", Summary: "List the devices whose parent is the cow", Tag: "cows", Result: []models.Device{}, Paged: true, Query: paging},
+		openapi.Route{Method: "POST", Path: "/api/v2/cows/{cow_id}/devices", Summary: "Add a device to the device list of a cow (Admins)", Tag: "cows", Body: models.NewDeviceToCow{}, Status: http.StatusCreated, Result: []models.ID{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/bookings", Summary: "List the bookings of a cow", Tag: "bookings", Result: []models.BookDetails{}},
+		openapi.Route{Method: "POST", Path: "/api/v2/cows/{cow_id}/bookings", Summary: "Book a cow, 409 when the block is taken that day or the cow is closed or blacked out, 422 when it breaks a booking policy", Tag: "bookings", Body: models.BookDetails{}, Status: http.StatusCreated, Result: models.BookDetails{}, Auth: true,
+			Query: []openapi.Parameter{query("override", "true to skip the booking policies and blackouts, Admins of the business only")}},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/cows/{cow_id}/bookings/{booking_id}", Summary: "Cancel your booking, or any booking of your business as an Admin, offering its block to the waitlist", Tag: "bookings", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/versions", Summary: "List the versions of a cow, oldest first (Admins)", Tag: "versions", Result: []models.Version{}, Paged: true, Auth: true, Query: paging},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/versions/diff", Summary: "List the changes to a cow between two versions (Admins)", Tag: "versions", Result: models.VersionDiff{}, Auth: true,
+			Query: []openapi.Parameter{query("from", "version, defaults to the one before to"), query("to", "version, defaults to the latest")}},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/versions/{version}", Summary: "Get how a cow looked at a version (Admins)", Tag: "versions", Result: models.Version{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/cows/{cow_id}/versions/{version}/revert", Summary: "Set a cow back to a version, keeping its bookings (Admins)", Tag: "versions", Result: models.Cow{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/approval", Summary: "Get the approvers of a cow whose bookings need approval (Admins)", Tag: "approvals", Result: models.ApprovalPolicy{}, Auth: true},
+		openapi.Route{Method: "PUT", Path: "/api/v2/cows/{cow_id}/approval", Summary: "Make the bookings of a cow need approval, or replace its approvers (Admins)", Tag: "approvals", Body: models.ApprovalPolicyRequest{}, Result: models.ApprovalPolicy{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/cows/{cow_id}/approval", Summary: "Let a cow be booked without approval (Admins)", Tag: "approvals", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/booking-requests", Summary: "List booking requests, your own or those you can decide", Tag: "approvals", Result: []models.BookingRequest{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("status", "pending, approved, rejected or expired"), query("cow", "cow ID"), query("business", "business ID, SuperUsers only")}, paging...)},
+		openapi.Route{Method: "GET", Path: "/api/v2/booking-requests/{request_id}", Summary: "Get a booking request", Tag: "approvals", Result: models.BookingRequest{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/booking-requests/{request_id}/approve", Summary: "Approve a pending booking request, adding the booking to the cow, 409 or 422 when it can't be booked anymore", Tag: "approvals", Body: models.BookingDecision{}, Result: models.BookingRequest{}, Auth: true,
+			Query: []openapi.Parameter{query("override", "true to approve past the booking policies and blackouts")}},
+		openapi.Route{Method: "POST", Path: "/api/v2/booking-requests/{request_id}/reject", Summary: "Reject a pending booking request with a reason", Tag: "approvals", Body: models.BookingDecision{}, Result: models.BookingRequest{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/booking-policies", Summary: "List the booking policies of your business (Admins)", Tag: "policies", Result: []models.BookingPolicy{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("business", "business ID, SuperUsers only")}, paging...)},
+		openapi.Route{Method: "PUT", Path: "/api/v2/booking-policies", Summary: "Set the booking limits of a business for a role, usertype 0 for every role (Admins)", Tag: "policies", Body: models.BookingPolicyRequest{}, Result: models.BookingPolicy{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/booking-policies/{policy_id}", Summary: "Delete a booking policy (Admins)", Tag: "policies", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/availability", Summary: "Search which cows can be booked for a time, and why the others can't", Tag: "calendar", Result: []models.CowAvailability{}, Paged: true,
+			Query: append([]openapi.Parameter{query("from", "RFC 3339 time, required"), query("to", "RFC 3339 time, required"), query("block", "block of the day from falls on"), query("business", "business ID"), query("collection", "eg. Laptop")}, paging...)},
+		openapi.Route{Method: "GET", Path: "/api/v2/calendar", Summary: "List the closures and blackouts of your business", Tag: "calendar", Result: []models.CalendarEntry{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("kind", "closure or blackout"), query("cow", "cow ID"), query("from", "RFC 3339 time"), query("to", "RFC 3339 time"), query("business", "business ID, SuperUsers only")}, paging...)},
+		openapi.Route{Method: "POST", Path: "/api/v2/calendar/closures", Summary: "Close the business for whole days (Admins)", Tag: "calendar", Body: models.ClosureRequest{}, Status: http.StatusCreated, Result: models.CalendarEntry{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/calendar/blackouts", Summary: "Keep a cow, the cows of a device type or every cow from being booked for a while (Admins)", Tag: "calendar", Body: models.BlackoutRequest{}, Status: http.StatusCreated, Result: models.CalendarEntry{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/calendar/import", Summary: "Import the events of an iCal file, whole day events as closures (Admins)", Tag: "calendar", Body: "", BodyType: "text/calendar", Result: models.CalendarImport{}, Auth: true,
+			Query: []openapi.Parameter{query("business", "business ID, required for SuperUsers")}},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/calendar/{entry_id}", Summary: "Delete a closure or blackout (Admins)", Tag: "calendar", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/cows/{cow_id}/waitlist", Summary: "Queue for a block the cow is already booked for", Tag: "waitlist", Body: models.BookDetails{}, Status: http.StatusCreated, Result: models.WaitlistPlace{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/cows/{cow_id}/waitlist", Summary: "List the queues for the blocks of a cow (Admins)", Tag: "waitlist", Result: []models.WaitlistEntry{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("date", "YYYY-MM-DD"), query("block", "block"), query("status", "waiting, offered, accepted, missed, left or closed, defaults to waiting and offered")}, paging...)},
+		openapi.Route{Method: "GET", Path: "/api/v2/waitlist", Summary: "List your waitlist entries with their place in the queue", Tag: "waitlist", Result: []models.WaitlistPlace{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("status", "waiting, offered, accepted, missed, left or closed")}, paging...)},
+		openapi.Route{Method: "GET", Path: "/api/v2/waitlist/{entry_id}", Summary: "Get a waitlist entry with its place in the queue", Tag: "waitlist", Result: models.WaitlistPlace{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/waitlist/{entry_id}", Summary: "Leave a waitlist, passing an offered block to the next person", Tag: "waitlist", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/waitlist/{entry_id}/accept", Summary: "Book the block offered to you, or 202 with a booking request when its bookings need approval", Tag: "waitlist", Status: http.StatusCreated, Result: models.BookDetails{}, Auth: true},
+
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/login", Summary: "Sign in with an email and password", Tag: "auth", Body: models.LoginRequest{}, Result: models.LoginResponse{}},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/logout", Summary: "End the current session", Tag: "auth", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/auth/me", Summary: "Get the signed in user", Tag: "auth", Result: models.User{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/password", Summary: "Change the password, signing out every other session", Tag: "auth", Body: models.ChangePasswordRequest{}, Result: models.LoginResponse{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/forgot-password", Summary: "Email a password reset link", Tag: "auth", Body: models.ForgotPasswordRequest{}, Status: http.StatusAccepted},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/reset-password", Summary: "Set a new password with the token from a reset link", Tag: "auth", Body: models.ResetPasswordRequest{}, Status: http.StatusNoContent},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/signup", Summary: "Create an account with the token from an invitation and sign in", Tag: "auth", Body: models.SignupRequest{}, Status: http.StatusCreated, Result: models.LoginResponse{}},
+		openapi.Route{Method: "GET", Path: "/api/v2/auth/sessions", Summary: "List the signed in sessions", Tag: "auth", Result: []models.SessionInfo{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/auth/sessions", Summary: "Sign out every other session", Tag: "auth", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/auth/sessions/{session_id}", Summary: "Sign out a session", Tag: "auth", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/2fa/enroll", Summary: "Create a two-factor secret and QR code for an authenticator app", Tag: "auth", Result: models.TwoFactorEnrollment{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/2fa/confirm", Summary: "Turn two-factor authentication on with a first code", Tag: "auth", Body: models.TwoFactorCodeRequest{}, Result: models.RecoveryCodes{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/2fa/verify", Summary: "Finish a login with a two-factor or recovery code", Tag: "auth", Body: models.TwoFactorVerifyRequest{}, Result: models.LoginResponse{}},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/2fa/recovery-codes", Summary: "Replace the recovery codes", Tag: "auth", Body: models.TwoFactorCodeRequest{}, Result: models.RecoveryCodes{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/auth/2fa/disable", Summary: "Turn two-factor authentication off", Tag: "auth", Body: models.TwoFactorDisableRequest{}, Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/auth/oidc", Summary: "List the single sign-on providers", Tag: "auth", Result: []models.SSOProvider{},
+			Query: []openapi.Parameter{query("email", "only providers accepting this email")}},
+		openapi.Route{Method: "GET", Path: "/api/v2/auth/oidc/{provider}/login", Summary: "Start a single sign-on login (browser redirect)", Tag: "auth", Status: http.StatusFound,
+			Query: []openapi.Parameter{query("return_to", "path of BASE_URL to come back to, default /")}},
+		openapi.Route{Method: "GET", Path: "/api/v2/auth/oidc/{provider}/callback", Summary: "Finish a single sign-on login, redirects with the LoginResponse in the URL fragment", Tag: "auth", Status: http.StatusFound,
+			Query: []openapi.Parameter{query("code", "authorization code"), query("state", "login state")}},
+		openapi.Route{Method: "GET", Path: "/api/v2/users", Summary: "List users (Admins)", Tag: "users", Result: []models.User{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("type", "superuser, admin or user"), query("email", "exact email"), query("disabled", "true for deactivated users"), query("business", "business ID, SuperUsers only")}, paging...)},
+		openapi.Route{Method: "POST", Path: "/api/v2/users", Summary: "Create a user, without a password a temporary one is emailed (Admins)", Tag: "users", Body: models.UserRequest{}, Status: http.StatusCreated, Result: models.CreatedUser{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/users/{user_id}", Summary: "Get a user (Admins)", Tag: "users", Result: models.User{}, Auth: true},
+		openapi.Route{Method: "PATCH", Path: "/api/v2/users/{user_id}", Summary: "Change the names, email or business of a user (Admins)", Tag: "users", Body: models.UserUpdateRequest{}, Result: models.User{}, Auth: true},
+		openapi.Route{Method: "PUT", Path: "/api/v2/users/{user_id}/role", Summary: "Promote or demote a user (Admins)", Tag: "users", Body: models.RoleRequest{}, Result: models.User{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/users/{user_id}/deactivate", Summary: "Stop a user signing in, keeping their bookings (Admins)", Tag: "users", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/users/{user_id}/reactivate", Summary: "Let a deactivated user sign in again (Admins)", Tag: "users", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/users/{user_id}/2fa/reset", Summary: "Remove the two-factor authentication of a user (Admins)", Tag: "users", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/users/{user_id}/reset-password", Summary: "Give a user a temporary password (Admins)", Tag: "users", Result: models.PasswordResetResult{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/users/{user_id}/unlock", Summary: "Unlock a user after failed logins (Admins)", Tag: "users", Status: http.StatusNoContent, Auth: true,
+			Query: []openapi.Parameter{query("address", "an IP address to unlock as well, eg. of the user's school")}},
+
+		openapi.Route{Method: "GET", Path: "/api/v2/invitations", Summary: "List invitations (Admins)", Tag: "invitations", Result: []models.Invitation{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("status", "pending or accepted"), query("email", "exact email"), query("business", "business ID, SuperUsers only")}, paging...)},
+		openapi.Route{Method: "POST", Path: "/api/v2/invitations", Summary: "Invite an email to sign up (Admins)", Tag: "invitations", Body: models.InvitationRequest{}, Status: http.StatusCreated, Result: models.CreatedInvitation{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/invitations/import", Summary: "Invite every row of a CSV with the columns email, firstname, lastname and usertype (Admins)", Tag: "invitations", Body: "", BodyType: "text/csv", Result: models.InvitationImport{}, Auth: true,
+			Query: []openapi.Parameter{query("business", "business ID, required for SuperUsers")}},
+		openapi.Route{Method: "GET", Path: "/api/v2/invitations/{invitation_id}", Summary: "Get an invitation (Admins)", Tag: "invitations", Result: models.Invitation{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/invitations/{invitation_id}", Summary: "Revoke a pending invitation (Admins)", Tag: "invitations", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/invitations/{invitation_id}/resend", Summary: "Email an invitation again with a new link (Admins)", Tag: "invitations", Result: models.CreatedInvitation{}, Auth: true},
+
+		openapi.Route{Method: "GET", Path: "/api/v2/devices", Summary: "List devices", Tag: "devices", Result: []models.Device{}, Paged: true,
+			Query: append([]openapi.Parameter{query("name", "exact device name"), query("type", "eg. Laptop"), query("parent", "parent cow ID")}, paging...)},
+		openapi.Route{Method: "POST", Path: "/api/v2/devices", Summary: "Create a device (Admins)", Tag: "devices", Body: models.DeviceDetails{}, Status: http.StatusCreated, Result: models.Device{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/devices/{device_id}", Summary: "Get a device", Tag: "devices", Result: models.Device{}},
+		openapi.Route{Method: "PATCH", Path: "/api/v2/devices/{device_id}", Summary: "Update the provided fields of a device (Admins)", Tag: "devices", Body: models.DeviceDetails{}, Result: models.Device{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/devices/{device_id}", Summary: "Delete a device (Admins)", Tag: "devices", Status: http.StatusNoContent, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/devices/{device_id}/versions", Summary: "List the versions of a device, oldest first (Admins)", Tag: "versions", Result: []models.Version{}, Paged: true, Auth: true, Query: paging},
+		openapi.Route{Method: "GET", Path: "/api/v2/devices/{device_id}/versions/diff", Summary: "List the changes to a device between two versions (Admins)", Tag: "versions", Result: models.VersionDiff{}, Auth: true,
+			Query: []openapi.Parameter{query("from", "version, defaults to the one before to"), query("to", "version, defaults to the latest")}},
+		openapi.Route{Method: "GET", Path: "/api/v2/devices/{device_id}/versions/{version}", Summary: "Get how a device looked at a version (Admins)", Tag: "versions", Result: models.Version{}, Auth: true},
+		openapi.Route{Method: "POST", Path: "/api/v2/devices/{device_id}/versions/{version}/revert", Summary: "Set a device back to a version (Admins)", Tag: "versions", Result: models.Device{}, Auth: true},
+
+		openapi.Route{Method: "GET", Path: "/api/v2/api-keys", Summary: "List API keys (Admins)", Tag: "api-keys", Result: []models.APIKey{}, Paged: true, Auth: true,
+			Query: append([]openapi.Parameter{query("business", "business ID, SuperUsers only")}, paging...)},
+		openapi.Route{Method: "POST", Path: "/api/v2/api-keys", Summary: "Create an API key, the key is only returned here (Admins)", Tag: "api-keys", Body: models.APIKeyRequest{}, Status: http.StatusCreated, Result: models.CreatedAPIKey{}, Auth: true},
+		openapi.Route{Method: "GET", Path: "/api/v2/api-keys/{key_id}", Summary: "Get an API key (Admins)", Tag: "api-keys", Result: models.APIKey{}, Auth: true},
+		openapi.Route{Method: "DELETE", Path: "/api/v2/api-keys/{key_id}", Summary: "Revoke an API key (Admins)", Tag: "api-keys", Status: http.StatusNoContent, Auth: true},
+
+		openapi.Route{Method: "GET", Path: "/api/v2/audit", Summary: "List the changes made through the API, oldest first (Admins)", Tag: "audit", Result: []models.AuditEntry{}, Paged: true, Auth: true,
+			Query: append(auditQuery, paging...)},
+		openapi.Route{Method: "GET", Path: "/api/v2/audit/export", Summary: "Export the changes made through the API as CSV (Admins)", Tag: "audit", Result: "", Raw: true, ResultType: "text/csv", Auth: true,
+			Query: auditQuery},
+	)
+	return doc
+}
+
+// envelopeSchema describes models.UserResponse with result as data.result
+func envelopeSchema(doc *openapi.Document, result *openapi.Schema, paged bool) *openapi.Schema {
+	data := &openapi.Schema{Type: "object", Properties: map[string]*openapi.Schema{"result": result}, Required: []string{"result"}}
+	if paged {
+		data.Properties["page"] = doc.Schema(models.Page{})
+		data.Required = append(data.Required, "page")
+	}
+	return &openapi.Schema{
+		Type: "object",
+		Properties: map[string]*openapi.Schema{
+			"status":  {Type: "integer"},
+			"message": {Type: "string"},
+			"data":    data,
+		},
+		Required: []string{"status", "message", "data"},
+	}
+}
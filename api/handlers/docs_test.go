@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api/openapi"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+)
+
+// TestEveryRouteDocumented fails when a route registered in App.New is missing from
+// openAPIDocument
+func TestEveryRouteDocumented(t *testing.T) {
+	store, err := databases.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close(context.Background())
+
+	a := &App{store: store}
+	router := a.New()
+	missing, err := openapi.Missing(a.docs, router)
+	if err != nil {
+		t.Fatalf("Missing: %v", err)
+	}
+	for _, route := range missing {
+		t.Errorf("%s is not in openAPIDocument in handlers/docs.go", route)
+	}
+}
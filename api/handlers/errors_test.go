@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+func TestErrorFields(t *testing.T) {
+	a := newTestApp(t)
+	_, admin := a.user(models.TypeAdmin, models.NewID())
+
+	// fields are named as in the JSON body
+	rec := a.do(http.MethodPost, "/api/v2/users", admin, models.UserRequest{FirstName: "Ada", Email: "ada", UserType: 5, Password: "short"})
+	expect(t, rec, http.StatusBadRequest, "creating an invalid user")
+	got := errorOf(t, rec)
+	want := []models.FieldError{
+		{Field: "lastname", Code: "required", Message: "is required"},
+		{Field: "email", Code: "email", Message: "must be a valid email address"},
+		{Field: "usertype", Code: "oneof", Message: "must be one of 2 3"},
+		{Field: "password", Code: "min", Message: "must be at least 10 characters"},
+	}
+	if got.Code != api.CodeValidation || !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("an invalid user answered %s %+v, want %s %+v", got.Code, got.Fields, api.CodeValidation, want)
+	}
+	if got.RequestID == "" || got.RequestID != rec.Header().Get(api.RequestIDHeader) {
+		t.Errorf("the error has requestId %q and the response %q, want the same ID", got.RequestID, rec.Header().Get(api.RequestIDHeader))
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/users", strings.NewReader(`{"firstname": "Ada", "usertype": "admin"}`))
+	req.Header.Set("Authorization", "Bearer "+admin)
+	rec = httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	expect(t, rec, http.StatusBadRequest, "creating a user with a field of the wrong type")
+	got = errorOf(t, rec)
+	if want := []models.FieldError{{Field: "usertype", Code: "type", Message: "must be a number"}}; got.Code != api.CodeInvalidBody || !reflect.DeepEqual(got.Fields, want) {
+		t.Errorf("a field of the wrong type answered %s %+v, want %s %+v", got.Code, got.Fields, api.CodeInvalidBody, want)
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thanhpk/randstr"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// testApp is the API on an in-memory SQLite store. Users are added straight to the store
+// with a session, so tests don't pay for hashing a password
+type testApp struct {
+	*App
+	t    *testing.T
+	sent *outbox
+}
+
+// outbox keeps the emails of the API instead of sending them
+type outbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+// to returns the emails sent to address, oldest first
+func (o *outbox) to(address string) []mailer.Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var messages []mailer.Message
+	for _, msg := range o.messages {
+		if msg.To == address {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+// linkToken matches the token of the links in emails, eg. reset-password?token=…
+var linkToken = regexp.MustCompile(`token=([0-9A-Za-z_-]+)`)
+
+// token is a helper function returning the token of the link in the last email to address
+func (a *testApp) token(address string) string {
+	a.t.Helper()
+	messages := a.sent.to(address)
+	if len(messages) == 0 {
+		a.t.Fatalf("no email was sent to %s", address)
+	}
+	match := linkToken.FindStringSubmatch(messages[len(messages)-1].Body)
+	if match == nil {
+		a.t.Fatalf("the last email to %s has no link: %s", address, messages[len(messages)-1].Body)
+	}
+	return match[1]
+}
+
+// newTestApp is a helper function starting the API, configure changes the config first
+func newTestApp(t *testing.T, configure ...func(*config.Config)) *testApp {
+	t.Helper()
+	a := &App{Config: config.Config{
+		Backend:            "sqlite",
+		URL:                ":memory:",
+		AutoMigrate:        true,
+		BaseURL:            "http://booking.test",
+		SessionTTL:         time.Hour,
+		BookingApprovalTTL: 72 * time.Hour,
+	}, mailer: &outbox{}}
+	for _, f := range configure {
+		f(&a.Config)
+	}
+	if err := a.Initialize(); err != nil {
+		t.Fatalf("failed to start the API: %v", err)
+	}
+	t.Cleanup(func() { a.store.Close(context.Background()) })
+	return &testApp{App: a, t: t, sent: a.mailer.(*outbox)}
+}
+
+// user is a helper function adding a user of userType to business and returning them with
+// the token of a new session
+func (a *testApp) user(userType int, business models.ID) (*models.User, string) {
+	a.t.Helper()
+	user := models.User{ID: models.NewID(), Details: models.UserDetails{
+		FirstName:  "Test",
+		LastName:   fmt.Sprintf("Type%d", userType),
+		Email:      randstr.Hex(8) + "@school.example",
+		UID:        randstr.Hex(8),
+		Business:   business,
+		UserType:   userType,
+		Created_at: time.Now().UTC(),
+		Updated_at: time.Now().UTC(),
+	}}
+	token, session := auth.NewSession(user.ID, httptest.NewRequest(http.MethodGet, "/", nil), time.Hour, false)
+	user.Details.Sessions = []models.Session{session}
+	if _, err := a.store.Users().InsertOne(context.Background(), user); err != nil {
+		a.t.Fatalf("failed to add a user: %v", err)
+	}
+	return &user, token
+}
+
+// cow is a helper function adding a cow to business
+func (a *testApp) cow(business models.ID) *models.Cow {
+	a.t.Helper()
+	cow := models.Cow{ID: models.NewID(), Details: models.CowDetails{
+		Name:        "CA-" + randstr.Hex(4),
+		Business:    business,
+		Collection:  "Laptop",
+		DeviceTotal: 30,
+		Bookings:    []models.BookDetails{},
+		Devices:     []models.ID{},
+	}}
+	if _, err := a.store.Cows().InsertOne(context.Background(), cow); err != nil {
+		a.t.Fatalf("failed to add a cow: %v", err)
+	}
+	return &cow
+}
+
+// findCow is a helper function reading a cow from the store
+func (a *testApp) findCow(id models.ID) *models.Cow {
+	a.t.Helper()
+	cow, err := a.store.Cows().FindOne(context.Background(), databases.FilterCows().ID(id))
+	if err != nil {
+		a.t.Fatalf("failed to read cow %s: %v", id, err)
+	}
+	return cow
+}
+
+// findUser is a helper function reading a user from the store
+func (a *testApp) findUser(id models.ID) *models.User {
+	a.t.Helper()
+	user, err := a.store.Users().FindOne(context.Background(), databases.FilterUsers().ID(id))
+	if err != nil {
+		a.t.Fatalf("failed to read user %s: %v", id, err)
+	}
+	return user
+}
+
+// do is a helper function sending a request with a JSON body, signed in with token
+func (a *testApp) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	a.t.Helper()
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			a.t.Fatalf("failed to marshal the body: %v", err)
+		}
+		reader = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+// expect is a helper function failing the test when a response doesn't have status
+func expect(t *testing.T, rec *httptest.ResponseRecorder, status int, what string) {
+	t.Helper()
+	if rec.Code != status {
+		t.Errorf("%s returned %d, want %d: %s", what, rec.Code, status, rec.Body)
+	}
+}
+
+// result is a helper function reading data.result of a response
+func result[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var body struct {
+		Data struct {
+			Result T `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to read the result of %s: %v", rec.Body, err)
+	}
+	return body.Data.Result
+}
+
+// errorOf is a helper function reading the error model of a failed response
+func errorOf(t *testing.T, rec *httptest.ResponseRecorder) models.APIError {
+	t.Helper()
+	var body models.ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("failed to read the error of %s: %v", rec.Body, err)
+	}
+	return body.Error
+}
+
+// bookingOn is a helper function returning a booking of block on the day days from now
+func bookingOn(days int, block string) models.BookDetails {
+	day := time.Now().UTC().AddDate(0, 0, days).Truncate(24 * time.Hour).Add(9 * time.Hour)
+	return models.BookDetails{
+		Author:    "Test",
+		Block:     block,
+		StartDate: primitive.NewDateTimeFromTime(day),
+		EndDate:   primitive.NewDateTimeFromTime(day.Add(time.Hour)),
+	}
+}
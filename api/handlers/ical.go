@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+// icalEvent is a VEVENT of an iCal file, see RFC 5545. Only what a calendar entry needs is
+// read, Err says why the event can't become one
+type icalEvent struct {
+	UID     string
+	Summary string
+	Start   time.Time
+	End     time.Time // exclusive
+	AllDay  bool      // DTSTART is a date, the event covers whole days
+	Err     error
+}
+
+// parseICal reads the events of an iCal file. Dates of whole day events, and times without a
+// time zone, are read in loc, the time zone of the business. The error is for files that
+// aren't iCal at all
+func parseICal(r io.Reader, loc *time.Location) ([]icalEvent, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		// long lines are folded onto the next ones, which start with a space or a tab
+		if line != "" && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(strings.TrimSpace(strings.TrimPrefix(lines[0], "\ufeff")), "BEGIN:VCALENDAR") {
+		return nil, errors.New("not an iCal file, it must start with BEGIN:VCALENDAR")
+	}
+
+	var events []icalEvent
+	var props map[string]icalProperty
+	depth := 0 // of the components nested in the event, eg. VALARM
+	for _, line := range lines {
+		name, params, value := icalLine(line)
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && props == nil:
+			props = map[string]icalProperty{}
+		case props == nil:
+		case name == "BEGIN":
+			depth++
+		case name == "END" && depth > 0:
+			depth--
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			events = append(events, icalEventOf(props, loc))
+			props = nil
+		case depth == 0:
+			if _, seen := props[name]; !seen {
+				props[name] = icalProperty{params: params, value: value}
+			}
+		}
+	}
+	return events, nil
+}
+
+// icalProperty is the value of a content line with its parameters, eg. VALUE=DATE
+type icalProperty struct {
+	params map[string]string
+	value  string
+}
+
+// icalLine is a helper function splitting a content line, NAME;PARAM=x:value, into its
+// upper case name, its parameters and its value
+func icalLine(line string) (string, map[string]string, string) {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			parts := strings.Split(line[:i], ";")
+			params := map[string]string{}
+			for _, param := range parts[1:] {
+				key, value, _ := strings.Cut(param, "=")
+				params[strings.ToUpper(key)] = strings.Trim(value, `"`)
+			}
+			return strings.ToUpper(parts[0]), params, line[i+1:]
+		}
+	}
+	return strings.ToUpper(line), nil, ""
+}
+
+// icalEventOf is a helper function building an event from the properties of a VEVENT
+func icalEventOf(props map[string]icalProperty, loc *time.Location) icalEvent {
+	event := icalEvent{UID: props["UID"].value, Summary: icalText(props["SUMMARY"].value)}
+	start, ok := props["DTSTART"]
+	if !ok {
+		event.Err = errors.New("the event has no DTSTART")
+		return event
+	}
+	if _, ok := props["RRULE"]; ok {
+		event.Err = errors.New("recurring events aren't supported, export every occurrence")
+		return event
+	}
+	if strings.EqualFold(props["STATUS"].value, "CANCELLED") {
+		event.Err = errors.New("the event is cancelled")
+		return event
+	}
+
+	event.AllDay = start.params["VALUE"] == "DATE" || len(start.value) == len("20060102")
+	if event.Start, event.Err = icalTime(start, event.AllDay, loc); event.Err != nil {
+		return event
+	}
+	end, ok := props["DTEND"]
+	switch {
+	case ok:
+		event.End, event.Err = icalTime(end, event.AllDay, loc)
+	case event.AllDay:
+		event.End = event.Start.AddDate(0, 0, 1)
+	default:
+		event.Err = errors.New("the event has no DTEND, DURATION isn't supported")
+	}
+	if event.Err == nil && !event.End.After(event.Start) {
+		event.Err = errors.New("the event ends before it starts")
+	}
+	event.Start, event.End = event.Start.UTC(), event.End.UTC()
+	return event
+}
+
+// icalTime is a helper function reading a DATE or DATE-TIME value. Dates are days of loc,
+// and so are times without a zone, which RFC 5545 calls floating
+func icalTime(p icalProperty, date bool, loc *time.Location) (time.Time, error) {
+	if date {
+		t, err := time.ParseInLocation("20060102", p.value, loc)
+		if err != nil {
+			return t, errors.New("the event has an invalid date: " + p.value)
+		}
+		return t, nil
+	}
+	switch tzid := p.params["TZID"]; {
+	case strings.HasSuffix(p.value, "Z"):
+		loc = time.UTC
+	case tzid != "":
+		var err error
+		if loc, err = icalZone(tzid); err != nil {
+			return time.Time{}, err
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", strings.TrimSuffix(p.value, "Z"), loc)
+	if err != nil {
+		return t, errors.New("the event has an invalid time: " + p.value)
+	}
+	return t, nil
+}
+
+// icalZone is a helper function loading the time zone of a TZID, an IANA name or the
+// Windows name Outlook and Exchange use, eg. "Pacific Standard Time"
+func icalZone(tzid string) (*time.Location, error) {
+	if loc, err := time.LoadLocation(tzid); err == nil {
+		return loc, nil
+	}
+	if name, ok := windowsZones[tzid]; ok {
+		if loc, err := time.LoadLocation(name); err == nil {
+			return loc, nil
+		}
+	}
+	return nil, errors.New("the event has an unknown time zone: " + tzid)
+}
+
+// icalText unescapes a TEXT value, line breaks become spaces
+var icalText = strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace
+
+// windowsZones maps the Windows time zones of Outlook and Exchange calendars to IANA time
+// zones, as the 001 territory of CLDR's windowsZones.xml does
+var windowsZones = map[string]string{
+	"Dateline Standard Time":          "Etc/GMT+12",
+	"UTC-11":                          "Etc/GMT+11",
+	"Hawaiian Standard Time":          "Pacific/Honolulu",
+	"Alaskan Standard Time":           "America/Anchorage",
+	"Pacific Standard Time (Mexico)":  "America/Tijuana",
+	"Pacific Standard Time":           "America/Los_Angeles",
+	"US Mountain Standard Time":       "America/Phoenix",
+	"Mountain Standard Time (Mexico)": "America/Mazatlan",
+	"Mountain Standard Time":          "America/Denver",
+	"Yukon Standard Time":             "America/Whitehorse",
+	"Central America Standard Time":   "America/Guatemala",
+	"Central Standard Time":           "America/Chicago",
+	"Central Standard Time (Mexico)":  "America/Mexico_City",
+	"Canada Central Standard Time":    "America/Regina",
+	"SA Pacific Standard Time":        "America/Bogota",
+	"Eastern Standard Time":           "America/New_York",
+	"Eastern Standard Time (Mexico)":  "America/Cancun",
+	"US Eastern Standard Time":        "America/Indiana/Indianapolis",
+	"Atlantic Standard Time":          "America/Halifax",
+	"Newfoundland Standard Time":      "America/St_Johns",
+	"SA Eastern Standard Time":        "America/Cayenne",
+	"E. South America Standard Time":  "America/Sao_Paulo",
+	"Argentina Standard Time":         "America/Argentina/Buenos_Aires",
+	"UTC":                             "Etc/UTC",
+	"GMT Standard Time":               "Europe/London",
+	"Greenwich Standard Time":         "Atlantic/Reykjavik",
+	"W. Europe Standard Time":         "Europe/Berlin",
+	"Central Europe Standard Time":    "Europe/Budapest",
+	"Romance Standard Time":           "Europe/Paris",
+	"Central European Standard Time":  "Europe/Warsaw",
+	"GTB Standard Time":               "Europe/Bucharest",
+	"FLE Standard Time":               "Europe/Kiev",
+	"South Africa Standard Time":      "Africa/Johannesburg",
+	"Israel Standard Time":            "Asia/Jerusalem",
+	"Russian Standard Time":           "Europe/Moscow",
+	"Arabian Standard Time":           "Asia/Dubai",
+	"India Standard Time":             "Asia/Kolkata",
+	"China Standard Time":             "Asia/Shanghai",
+	"Singapore Standard Time":         "Asia/Singapore",
+	"Tokyo Standard Time":             "Asia/Tokyo",
+	"Korea Standard Time":             "Asia/Seoul",
+	"W. Australia Standard Time":      "Australia/Perth",
+	"Cen. Australia Standard Time":    "Australia/Adelaide",
+	"E. Australia Standard Time":      "Australia/Brisbane",
+	"AUS Eastern Standard Time":       "Australia/Sydney",
+	"New Zealand Standard Time":       "Pacific/Auckland",
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// icalFile is a helper function wrapping events in a calendar, with CRLF line endings
+func icalFile(events ...string) string {
+	lines := []string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//School District//Holidays//EN"}
+	for _, event := range events {
+		lines = append(lines, "BEGIN:VEVENT")
+		lines = append(lines, strings.Split(strings.TrimSpace(event), "\n")...)
+		lines = append(lines, "END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+func TestParseICal(t *testing.T) {
+	vancouver, err := time.LoadLocation("America/Vancouver")
+	if err != nil {
+		t.Fatalf("LoadLocation: %v", err)
+	}
+	utc := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatalf("time.Parse: %v", err)
+		}
+		return parsed
+	}
+
+	for _, tt := range []struct {
+		name       string
+		event      string
+		start, end time.Time
+		allDay     bool
+		err        string
+	}{{
+		name:   "a whole day is a day of the business",
+		event:  "UID:1\nSUMMARY:Winter break\nDTSTART;VALUE=DATE:20241223\nDTEND;VALUE=DATE:20250104",
+		start:  utc("2024-12-23T08:00:00Z"),
+		end:    utc("2025-01-04T08:00:00Z"),
+		allDay: true,
+	}, {
+		name:   "a whole day without DTEND lasts a day, across a daylight saving change",
+		event:  "UID:2\nDTSTART;VALUE=DATE:20241103",
+		start:  utc("2024-11-03T07:00:00Z"),
+		end:    utc("2024-11-04T08:00:00Z"),
+		allDay: true,
+	}, {
+		name:  "a time of another zone",
+		event: "UID:3\nDTSTART;TZID=America/Toronto:20240910T080000\nDTEND;TZID=America/Toronto:20240910T100000",
+		start: utc("2024-09-10T12:00:00Z"),
+		end:   utc("2024-09-10T14:00:00Z"),
+	}, {
+		name:  "a Windows time zone",
+		event: "UID:4\nDTSTART;TZID=\"Eastern Standard Time\":20240910T080000\nDTEND;TZID=Eastern Standard Time:20240910T100000",
+		start: utc("2024-09-10T12:00:00Z"),
+		end:   utc("2024-09-10T14:00:00Z"),
+	}, {
+		name:  "a UTC time",
+		event: "UID:5\nDTSTART:20240910T080000Z\nDTEND;TZID=America/Toronto:20240910T090000Z",
+		start: utc("2024-09-10T08:00:00Z"),
+		end:   utc("2024-09-10T09:00:00Z"),
+	}, {
+		name:  "an event ending before it starts",
+		event: "UID:13\nDTSTART:20240910T090000Z\nDTEND:20240910T080000Z",
+		err:   "the event ends before it starts",
+	}, {
+		name:  "a floating time is a time of the business",
+		event: "UID:6\nDTSTART:20240910T080000\nDTEND:20240910T093000",
+		start: utc("2024-09-10T15:00:00Z"),
+		end:   utc("2024-09-10T16:30:00Z"),
+	}, {
+		name:  "an unknown time zone",
+		event: "UID:7\nDTSTART;TZID=Mars Standard Time:20240910T080000\nDTEND;TZID=Mars Standard Time:20240910T090000",
+		err:   "the event has an unknown time zone: Mars Standard Time",
+	}, {
+		name:  "a recurring event",
+		event: "UID:8\nDTSTART;VALUE=DATE:20240902\nRRULE:FREQ=YEARLY;BYMONTH=9;BYDAY=1MO",
+		err:   "recurring events aren't supported, export every occurrence",
+	}, {
+		name:  "a cancelled event",
+		event: "UID:9\nDTSTART;VALUE=DATE:20240902\nSTATUS:CANCELLED",
+		err:   "the event is cancelled",
+	}, {
+		name:  "an event without DTSTART",
+		event: "UID:10\nSUMMARY:Someday",
+		err:   "the event has no DTSTART",
+	}, {
+		name:  "a time without DTEND",
+		event: "UID:11\nDTSTART:20240910T080000Z\nDURATION:PT1H",
+		err:   "the event has no DTEND, DURATION isn't supported",
+	}, {
+		name:  "an invalid date",
+		event: "UID:12\nDTSTART;VALUE=DATE:20241332",
+		err:   "the event has an invalid date: 20241332",
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := parseICal(strings.NewReader(icalFile(tt.event)), vancouver)
+			if err != nil {
+				t.Fatalf("parseICal: %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("parseICal read %d events, want 1", len(events))
+			}
+			event := events[0]
+			if tt.err != "" {
+				if event.Err == nil || event.Err.Error() != tt.err {
+					t.Errorf("the event failed with %v, want %q", event.Err, tt.err)
+				}
+				return
+			}
+			if event.Err != nil {
+				t.Fatalf("the event failed with %v", event.Err)
+			}
+			if !event.Start.Equal(tt.start) || !event.End.Equal(tt.end) || event.AllDay != tt.allDay {
+				t.Errorf("the event is %s to %s, all day %v, want %s to %s, all day %v", event.Start, event.End, event.AllDay, tt.start, tt.end, tt.allDay)
+			}
+			if event.Start.Location() != time.UTC {
+				t.Errorf("the event starts in %s, want UTC", event.Start.Location())
+			}
+		})
+	}
+}
+
+func TestParseICalLines(t *testing.T) {
+	file := icalFile(
+		// long lines are folded onto the next ones, parameters can be lower case and quoted
+		"UID:folded@school.example\nSUMMARY:Professional \n development day\\, no\n\t classes\nDTSTART;value=DATE:2024\n 1025\nBEGIN:VALARM\nDTSTART:20000101T000000Z\nEND:VALARM",
+		"UID:second\nDTSTART;VALUE=DATE:20241111",
+	)
+	events, err := parseICal(strings.NewReader("\ufeff"+file), time.UTC)
+	if err != nil {
+		t.Fatalf("parseICal: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("parseICal read %d events, want 2", len(events))
+	}
+	event := events[0]
+	if event.Err != nil || event.UID != "folded@school.example" || event.Summary != "Professional development day, no classes" {
+		t.Errorf("the folded event is %+v", event)
+	}
+	if want := time.Date(2024, 10, 25, 0, 0, 0, 0, time.UTC); !event.Start.Equal(want) || !event.AllDay {
+		t.Errorf("the folded event starts %s, all day %v, want %s, all day", event.Start, event.AllDay, want)
+	}
+	if events[1].UID != "second" || events[1].Err != nil {
+		t.Errorf("the second event is %+v", events[1])
+	}
+
+	if _, err := parseICal(strings.NewReader("BEGIN:VCARD\r\nEND:VCARD\r\n"), time.UTC); err == nil {
+		t.Error("parseICal read a file that isn't iCal")
+	}
+}
+
+func TestCalendarTimeZone(t *testing.T) {
+	a := newTestApp(t, func(c *config.Config) { c.TimeZone, _ = time.LoadLocation("America/Vancouver") })
+	business := models.NewID()
+	_, teacher := a.user(models.TypeUser, business)
+	_, admin := a.user(models.TypeAdmin, business)
+	cow := a.cow(business)
+	path := "/api/v2/cows/" + cow.ID.String() + "/bookings"
+	at := func(day time.Time, hour int, block string) models.BookDetails {
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, 0, 0, 0, a.Config.TimeZone)
+		return models.BookDetails{Author: "Test", Block: block, StartDate: primitive.NewDateTimeFromTime(start), EndDate: primitive.NewDateTimeFromTime(start.Add(time.Hour))}
+	}
+	day := time.Now().AddDate(0, 0, 7)
+
+	// 17:00 and 09:00 in Vancouver are on different UTC days, but the same day of the business
+	expect(t, a.do(http.MethodPost, path, teacher, at(day, 17, "1")), http.StatusCreated, "booking a block in the evening")
+	expect(t, a.do(http.MethodPost, path, teacher, at(day, 9, "1")), http.StatusConflict, "booking the block in the morning of the same day")
+	expect(t, a.do(http.MethodPost, path, teacher, at(day.AddDate(0, 0, 1), 9, "1")), http.StatusCreated, "booking the block the next day")
+
+	closed := day.AddDate(0, 0, 2)
+	rec := a.do(http.MethodPost, "/api/v2/calendar/closures", admin, models.ClosureRequest{StartDate: closed.Format(slotDateLayout), Reason: "Pro-D day"})
+	expect(t, rec, http.StatusCreated, "closing a day")
+	expect(t, a.do(http.MethodPost, path, teacher, at(closed, 20, "1")), http.StatusConflict, "booking the evening of a closed day")
+	expect(t, a.do(http.MethodPost, path, teacher, at(closed.AddDate(0, 0, 1), 1, "1")), http.StatusCreated, "booking early the day after a closure")
+
+	// an imported whole day event closes the same day
+	imported := closed.AddDate(0, 0, 7)
+	body := icalFile("UID:import-1\nSUMMARY:Stat holiday\nDTSTART;VALUE=DATE:"+imported.Format("20060102"),
+		"UID:import-2\nSUMMARY:Staff meeting\nDTSTART;TZID=Mars Standard Time:"+imported.Format("20060102")+"T150000\nDTEND;TZID=Mars Standard Time:"+imported.Format("20060102")+"T160000")
+	req := httptest.NewRequest(http.MethodPost, "/api/v2/calendar/import", strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/calendar")
+	req.Header.Set("Authorization", "Bearer "+admin)
+	rec = httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	expect(t, rec, http.StatusOK, "importing a calendar")
+	result := result[models.CalendarImport](t, rec)
+	if result.Imported != 1 || len(result.Events) != 2 {
+		t.Fatalf("the import is %+v, want 1 imported of 2 events", result)
+	}
+	if skipped := result.Events[1]; skipped.UID != "import-2" || !strings.Contains(skipped.Error, "Mars Standard Time") {
+		t.Errorf("the event with an unknown time zone was reported as %+v", skipped)
+	}
+	expect(t, a.do(http.MethodPost, path, teacher, at(imported, 20, "1")), http.StatusConflict, "booking the evening of an imported closure")
+}
@@ -0,0 +1,486 @@
+package handlers
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
+)
+
+// maxImportRows is how many invitations one CSV can send
+const maxImportRows = 1000
+
+var (
+	// errEmailTaken is returned when inviting or signing up with the email of an existing user
+	errEmailTaken = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the email already has an account")}
+	// errAlreadyInvited is returned when inviting an email with a pending invitation
+	errAlreadyInvited = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the email is already invited, resend the invitation instead")}
+	// errInvitationAccepted is returned when resending or revoking a used invitation
+	errInvitationAccepted = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the invitation was already accepted")}
+)
+
+// Invitation lets admins invite people to their business, who then sign up by choosing
+// their own password. The role rules are those of creating users, see newMemberBusiness
+type Invitation struct {
+	Auth
+	Invitations databases.InvitationDatabase
+}
+
+// ListInvitations returns a page of invitations, filtered by ?status and ?email. SuperUsers
+// can filter by ?business
+func (i Invitation) ListInvitations(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+
+	filter := databases.FilterInvitations().Limit(p.Limit).Skip(p.Offset)
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType != models.TypeSuperUser && actor.Details.Business.IsZero():
+		api.WriteError(w, r, "failed to get invitations", errNoBusiness)
+		return
+	case actor.Details.UserType != models.TypeSuperUser:
+		filter.Business(actor.Details.Business)
+	case !business.IsZero():
+		filter.Business(business)
+	}
+	query := r.URL.Query()
+	switch status := query.Get("status"); status {
+	case "":
+	case models.InvitationPending, models.InvitationAccepted:
+		filter.Status(status)
+	default:
+		api.WriteError(w, r, "invalid status", api.InvalidParameter(errors.New("status must be pending or accepted")))
+		return
+	}
+	if email := query.Get("email"); email != "" {
+		filter.Email(email)
+	}
+
+	invitations, err := i.Invitations.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get invitations", err)
+		return
+	}
+	if invitations == nil {
+		invitations = []models.Invitation{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": invitations, "page": p})
+}
+
+// CreateInvitation invites an email and returns the invitation with its location. The
+// signup link is emailed, or returned when it can't be
+func (i Invitation) CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var req models.InvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	result, err := i.invite(ctx, r, req)
+	if err != nil {
+		api.WriteError(w, r, "failed to invite", err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v2/invitations/"+result.ID.String())
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": result})
+}
+
+// ImportInvitations invites every row of a CSV body with the columns email, firstname,
+// lastname and optionally usertype (admin or user, user by default). The header row names
+// the columns. Rows are invited one by one, the result says what became of each.
+// SuperUsers name the business with ?business
+func (i Invitation) ImportInvitations(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Minute)
+	defer cancel()
+
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if _, err := newMemberBusiness(actor, models.TypeUser, business); err != nil {
+		api.WriteError(w, r, "failed to invite", err)
+		return
+	}
+
+	reader := csv.NewReader(http.MaxBytesReader(w, r.Body, 1<<20))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		api.WriteError(w, r, "failed to read the CSV", api.InvalidBody(err))
+		return
+	}
+	columns := map[string]int{}
+	for n, name := range header {
+		// spreadsheets often start the file with a byte order mark
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = n
+	}
+	if _, ok := columns["email"]; !ok {
+		api.WriteError(w, r, "failed to read the CSV", api.InvalidBody(errors.New("the header has no email column")))
+		return
+	}
+	column := func(record []string, name string) string {
+		if n, ok := columns[name]; ok && n < len(record) {
+			return strings.TrimSpace(record[n])
+		}
+		return ""
+	}
+
+	result := models.InvitationImport{Rows: []models.InvitationImportRow{}}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			api.WriteError(w, r, "failed to read the CSV", api.InvalidBody(err))
+			return
+		}
+		line, _ := reader.FieldPos(0)
+		if len(result.Rows) == maxImportRows {
+			api.WriteError(w, r, "failed to read the CSV", api.InvalidBody(fmt.Errorf("more than %d rows, split the file", maxImportRows)))
+			return
+		}
+
+		row := models.InvitationImportRow{Line: line, Email: column(record, "email")}
+		req := models.InvitationRequest{
+			Email:     row.Email,
+			FirstName: column(record, "firstname"),
+			LastName:  column(record, "lastname"),
+			UserType:  models.TypeUser,
+			Business:  business,
+		}
+		if name := strings.ToLower(column(record, "usertype")); name != "" {
+			t, ok := userTypes[name]
+			if n, err := strconv.Atoi(name); err == nil {
+				t, ok = n, true
+			}
+			if !ok || (t != models.TypeAdmin && t != models.TypeUser) {
+				row.Error = "usertype must be admin or user"
+				result.Rows = append(result.Rows, row)
+				continue
+			}
+			req.UserType = t
+		}
+
+		invitation, err := i.invite(ctx, r, req)
+		if err != nil {
+			row.Error = importError(err)
+		} else {
+			row.Invitation, row.Emailed, row.SignupURL = invitation.ID, invitation.Emailed, invitation.SignupURL
+			result.Invited++
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": result})
+}
+
+// GetInvitation returns an invitation by ID
+func (i Invitation) GetInvitation(w http.ResponseWriter, r *http.Request) {
+	invitation, err := i.find(r)
+	if err != nil {
+		api.WriteError(w, r, "invitation not found", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": invitation})
+}
+
+// ResendInvitation emails a pending invitation again with a new link, which also restarts
+// its expiry. The previous link stops working
+func (i Invitation) ResendInvitation(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	invitation, err := i.managed(r)
+	if err != nil {
+		api.WriteError(w, r, "the invitation could not be resent", err)
+		return
+	}
+
+	token, hash := auth.NewToken()
+	now := time.Now().UTC()
+	update := databases.UpdateInvitation().SetToken(hash, now, now.Add(i.Config.InvitationTTL))
+	result, err := i.Invitations.UpdateOne(ctx, databases.FilterInvitations().ID(invitation.ID).Status(models.InvitationPending), update)
+	if err != nil {
+		api.WriteError(w, r, "the invitation could not be resent", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "the invitation could not be resent", errInvitationAccepted)
+		return
+	}
+	invitation.Details.SentAt, invitation.Details.ExpiresAt = now, now.Add(i.Config.InvitationTTL)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": i.send(ctx, r, invitation, token)})
+}
+
+// RevokeInvitation deletes a pending invitation, so its link stops working
+func (i Invitation) RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	invitation, err := i.managed(r)
+	if err != nil {
+		api.WriteError(w, r, "the invitation could not be revoked", err)
+		return
+	}
+	result, err := i.Invitations.DeleteOne(ctx, databases.FilterInvitations().ID(invitation.ID).Status(models.InvitationPending))
+	if err != nil {
+		api.WriteError(w, r, "the invitation could not be revoked", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "the invitation could not be revoked", errInvitationAccepted)
+		return
+	}
+	zap.S().Infow("revoked an invitation", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "invitation", invitation.ID, "business", invitation.Details.Business)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Signup accepts an invitation with the token of its link: it creates the user with the
+// chosen password and signs them in. A token works once, until the invitation expires
+func (i Invitation) Signup(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.SignupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	hash := auth.HashToken(req.Token)
+	pending := databases.FilterInvitations().Token(hash).Status(models.InvitationPending)
+	invitation, err := i.Invitations.FindOne(ctx, pending)
+	if errors.Is(err, databases.ErrNotFound) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("token", "token", auth.ErrInvalidToken))
+		return
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to find the invitation", err)
+		return
+	}
+	if !time.Now().Before(invitation.Details.ExpiresAt) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("token", "token", errors.New("the invitation expired, ask for a new one")))
+		return
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("password", "password", err))
+		return
+	}
+
+	d := invitation.Details
+	if req.FirstName != "" {
+		d.FirstName = req.FirstName
+	}
+	if req.LastName != "" {
+		d.LastName = req.LastName
+	}
+	if d.FirstName == "" {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("firstname", "required", errors.New("is required")))
+		return
+	}
+	if d.LastName == "" {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("lastname", "required", errors.New("is required")))
+		return
+	}
+	if _, err := i.DB.FindOne(ctx, databases.FilterUsers().Email(d.Email)); err == nil {
+		api.WriteError(w, r, "failed to sign up", errEmailTaken)
+		return
+	} else if !errors.Is(err, databases.ErrNotFound) {
+		api.WriteError(w, r, "failed to find the user", err)
+		return
+	}
+
+	user := util.NewUser(i.DB, models.UserDetails{
+		FirstName: d.FirstName,
+		LastName:  d.LastName,
+		Email:     d.Email,
+		Business:  d.Business,
+		UserType:  d.UserType,
+	}, req.Password, false)
+
+	// accepting first, filtered on the token and state, means a second request with the
+	// token changes nothing even when both get this far
+	now := time.Now().UTC()
+	result, err := i.Invitations.UpdateOne(ctx, pending.ID(invitation.ID), databases.UpdateInvitation().SetAccepted(user.ID, now))
+	if err != nil {
+		api.WriteError(w, r, "failed to accept the invitation", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("token", "token", auth.ErrInvalidToken))
+		return
+	}
+	if _, err := i.DB.InsertOne(ctx, user); err != nil {
+		if _, revertErr := i.Invitations.UpdateOne(ctx, databases.FilterInvitations().ID(invitation.ID), databases.UpdateInvitation().SetAccepted("", time.Time{})); revertErr != nil {
+			zap.S().With("error", revertErr, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to reopen an invitation", "invitation", invitation.ID)
+		}
+		if errors.Is(err, databases.ErrDuplicate) {
+			err = errEmailTaken
+		}
+		api.WriteError(w, r, "failed to sign up", err)
+		return
+	}
+	zap.S().Infow("signed up with an invitation", "requestId", api.RequestIDFromContext(r.Context()), "invitation", invitation.ID, "uid", user.Details.UID, "business", user.Details.Business, "usertype", user.Details.UserType)
+	i.Audit.user(r, models.AuditCreate, nil, &user)
+
+	resp, err := i.signIn(ctx, r, &user)
+	if err != nil {
+		api.WriteError(w, r, "failed to save the session", err)
+		return
+	}
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": resp})
+}
+
+// invite is a helper function inviting the email of req on behalf of the user of r
+func (i Invitation) invite(ctx context.Context, r *http.Request, req models.InvitationRequest) (models.CreatedInvitation, error) {
+	actor, _ := api.UserFromContext(r.Context())
+	business, err := newMemberBusiness(actor, req.UserType, req.Business)
+	if err != nil {
+		return models.CreatedInvitation{}, err
+	}
+	email, ok := util.ValidMailAddress(req.Email)
+	if !ok {
+		return models.CreatedInvitation{}, api.InvalidField("email", "email", errors.New("must be a valid email address"))
+	}
+
+	if _, err := i.DB.FindOne(ctx, databases.FilterUsers().Email(email)); err == nil {
+		return models.CreatedInvitation{}, errEmailTaken
+	} else if !errors.Is(err, databases.ErrNotFound) {
+		return models.CreatedInvitation{}, err
+	}
+	if _, err := i.Invitations.FindOne(ctx, databases.FilterInvitations().Email(email).Status(models.InvitationPending)); err == nil {
+		return models.CreatedInvitation{}, errAlreadyInvited
+	} else if !errors.Is(err, databases.ErrNotFound) {
+		return models.CreatedInvitation{}, err
+	}
+
+	token, hash := auth.NewToken()
+	now := time.Now().UTC()
+	invitation := models.Invitation{
+		ID: models.NewID(),
+		Details: models.InvitationDetails{
+			Email:     email,
+			FirstName: req.FirstName,
+			LastName:  req.LastName,
+			Business:  business,
+			UserType:  req.UserType,
+			Status:    models.InvitationPending,
+			TokenHash: hash,
+			InvitedBy: actor.ID,
+			CreatedAt: now,
+			SentAt:    now,
+			ExpiresAt: now.Add(i.Config.InvitationTTL),
+		},
+	}
+	if _, err := i.Invitations.InsertOne(ctx, invitation); err != nil {
+		return models.CreatedInvitation{}, err
+	}
+	zap.S().Infow("invited a user", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "invitation", invitation.ID, "business", business, "usertype", req.UserType)
+
+	return i.send(ctx, r, &invitation, token), nil
+}
+
+// send is a helper function emailing the signup link of invitation. The link is returned
+// instead when it can't be emailed
+func (i Invitation) send(ctx context.Context, r *http.Request, invitation *models.Invitation, token string) models.CreatedInvitation {
+	result := models.CreatedInvitation{Invitation: *invitation, Emailed: true}
+	if err := i.Mailer.Send(ctx, auth.InvitationEmail(invitation, i.Config.BaseURL, token, i.Config.InvitationTTL)); err != nil {
+		if !errors.Is(err, mailer.ErrNotConfigured) {
+			zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to email an invitation", "invitation", invitation.ID)
+		}
+		result.Emailed, result.SignupURL = false, auth.SignupURL(i.Config.BaseURL, token)
+	}
+	return result
+}
+
+// find is a helper function loading the invitation of the request. Admins only find the
+// invitations of their own business, others look like they don't exist
+func (i Invitation) find(r *http.Request) (*models.Invitation, error) {
+	id, err := idParam(r, "invitation_id")
+	if err != nil {
+		return nil, err
+	}
+	filter := databases.FilterInvitations().ID(id)
+	if actor, _ := api.UserFromContext(r.Context()); actor.Details.UserType != models.TypeSuperUser {
+		if actor.Details.Business.IsZero() {
+			return nil, errNoBusiness
+		}
+		filter.Business(actor.Details.Business)
+	}
+	return i.Invitations.FindOne(r.Context(), filter)
+}
+
+// managed is a helper function loading the pending invitation of the request, refusing
+// invitations the actor couldn't have sent
+func (i Invitation) managed(r *http.Request) (*models.Invitation, error) {
+	invitation, err := i.find(r)
+	if err != nil {
+		return nil, err
+	}
+	if invitation.Details.Status != models.InvitationPending {
+		return nil, errInvitationAccepted
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if _, err := newMemberBusiness(actor, invitation.Details.UserType, invitation.Details.Business); err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+// importError is a helper function describing why a row of a CSV wasn't invited. Only
+// failures of the row itself are shown, others are logged
+func importError(err error) string {
+	var known *api.Error
+	if errors.As(err, &known) {
+		if len(known.Fields) > 0 {
+			return known.Fields[0].Field + " " + known.Fields[0].Message
+		}
+		return known.Err.Error()
+	}
+	zap.S().With("error", err).Errorw("failed to invite a row of a CSV")
+	return "failed to invite"
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// withInvitations is a helper function giving invitation links a week, like the default
+func withInvitations(c *config.Config) { c.InvitationTTL = 7 * 24 * time.Hour }
+
+// invite is a helper function inviting email to the business of the Admin signed in with
+// token, returning the invitation and the token of its link
+func (a *testApp) invite(token, email string) (models.CreatedInvitation, string) {
+	a.t.Helper()
+	rec := a.do(http.MethodPost, "/api/v2/invitations", token, models.InvitationRequest{Email: email, UserType: models.TypeUser})
+	expect(a.t, rec, http.StatusCreated, "inviting "+email)
+	return result[models.CreatedInvitation](a.t, rec), a.token(email)
+}
+
+// postCSV is a helper function sending a text/csv body, signed in with token
+func (a *testApp) postCSV(path, token, body string) *httptest.ResponseRecorder {
+	a.t.Helper()
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+// unknownEmails finds no user by email, as a signup checking the email just before another
+// signs up with it does
+type unknownEmails struct {
+	databases.UserDatabase
+}
+
+func (u unknownEmails) FindOne(ctx context.Context, filter *databases.UserFilter) (*models.User, error) {
+	return nil, databases.ErrNotFound
+}
+
+func TestInvitationRoles(t *testing.T) {
+	a := newTestApp(t, withInvitations)
+	business := models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	_, admin := a.user(models.TypeAdmin, business)
+	_, stranger := a.user(models.TypeAdmin, models.NewID())
+	user, teacher := a.user(models.TypeUser, business)
+
+	expect(t, a.do(http.MethodPost, "/api/v2/invitations", teacher, models.InvitationRequest{Email: "ada@school.example", UserType: models.TypeUser}), http.StatusForbidden, "a User inviting")
+	expect(t, a.do(http.MethodPost, "/api/v2/invitations", admin, models.InvitationRequest{Email: "ada@school.example", UserType: models.TypeAdmin}), http.StatusForbidden, "an Admin inviting an Admin")
+	expect(t, a.do(http.MethodPost, "/api/v2/invitations", super, models.InvitationRequest{Email: "ada@school.example", UserType: models.TypeAdmin}), http.StatusBadRequest, "a SuperUser inviting without a business")
+	expect(t, a.do(http.MethodPost, "/api/v2/invitations", admin, models.InvitationRequest{Email: user.Details.Email, UserType: models.TypeUser}), http.StatusConflict, "inviting the email of a user")
+
+	invitation, _ := a.invite(admin, "ada@school.example")
+	if invitation.Details.Business != business || invitation.Details.Status != models.InvitationPending || !invitation.Emailed || invitation.SignupURL != "" {
+		t.Errorf("the invitation is %+v, want a pending invitation to the business, emailed", invitation)
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/invitations", admin, models.InvitationRequest{Email: "ada@school.example", UserType: models.TypeUser}), http.StatusConflict, "inviting the same email twice")
+
+	// Admins of other businesses don't see it
+	path := "/api/v2/invitations/" + invitation.ID.String()
+	expect(t, a.do(http.MethodGet, path, stranger, nil), http.StatusNotFound, "an Admin of another business reading the invitation")
+	expect(t, a.do(http.MethodPost, path+"/resend", stranger, nil), http.StatusNotFound, "an Admin of another business resending the invitation")
+	expect(t, a.do(http.MethodDelete, path, stranger, nil), http.StatusNotFound, "an Admin of another business revoking the invitation")
+	rec := a.do(http.MethodGet, "/api/v2/invitations", stranger, nil)
+	expect(t, rec, http.StatusOK, "an Admin of another business listing invitations")
+	if listed := result[[]models.Invitation](t, rec); len(listed) != 0 {
+		t.Errorf("an Admin of another business lists %d invitations, want none", len(listed))
+	}
+	expect(t, a.do(http.MethodGet, path, admin, nil), http.StatusOK, "an Admin reading the invitation")
+}
+
+func TestSignup(t *testing.T) {
+	a := newTestApp(t, withInvitations)
+	business := models.NewID()
+	_, admin := a.user(models.TypeAdmin, business)
+	signup := func(req models.SignupRequest) *httptest.ResponseRecorder {
+		return a.do(http.MethodPost, "/api/v2/auth/signup", "", req)
+	}
+
+	invitation, token := a.invite(admin, "ada@school.example")
+	expect(t, signup(models.SignupRequest{Token: token, Password: "Correct,Horse7"}), http.StatusBadRequest, "signing up without a name")
+	expect(t, signup(models.SignupRequest{Token: token, Password: "weakpassword", FirstName: "Ada", LastName: "Lovelace"}), http.StatusBadRequest, "signing up with a weak password")
+	expect(t, signup(models.SignupRequest{Token: strings.Repeat("0", 64), Password: "Correct,Horse7", FirstName: "Ada", LastName: "Lovelace"}), http.StatusBadRequest, "signing up with a wrong token")
+
+	rec := signup(models.SignupRequest{Token: token, Password: "Correct,Horse7", FirstName: "Ada", LastName: "Lovelace"})
+	expect(t, rec, http.StatusCreated, "signing up")
+	login := result[models.LoginResponse](t, rec)
+	if login.User == nil || login.User.Details.Business != business || login.User.Details.UserType != models.TypeUser || login.User.Details.Email != "ada@school.example" {
+		t.Fatalf("signing up created %+v, want a User of the business", login.User)
+	}
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", login.Token, nil), http.StatusOK, "using the session of the signup")
+
+	// the link works once, and the invitation can't be changed after
+	expect(t, signup(models.SignupRequest{Token: token, Password: "Correct,Horse8", FirstName: "Ada", LastName: "Lovelace"}), http.StatusBadRequest, "signing up with a used token")
+	path := "/api/v2/invitations/" + invitation.ID.String()
+	expect(t, a.do(http.MethodPost, path+"/resend", admin, nil), http.StatusConflict, "resending an accepted invitation")
+	expect(t, a.do(http.MethodDelete, path, admin, nil), http.StatusConflict, "revoking an accepted invitation")
+	rec = a.do(http.MethodGet, path, admin, nil)
+	expect(t, rec, http.StatusOK, "reading the accepted invitation")
+	if got := result[models.Invitation](t, rec); got.Details.Status != models.InvitationAccepted || got.Details.User != login.User.ID {
+		t.Errorf("the accepted invitation is %+v, want it accepted by the new user", got.Details)
+	}
+
+	// resending replaces the link, revoking ends it, and links expire
+	_, old := a.invite(admin, "grace@school.example")
+	rec = a.do(http.MethodGet, "/api/v2/invitations?email=grace@school.example", admin, nil)
+	pending := result[[]models.Invitation](t, rec)
+	if len(pending) != 1 {
+		t.Fatalf("listing the invitations of an email found %d, want 1", len(pending))
+	}
+	path = "/api/v2/invitations/" + pending[0].ID.String()
+	expect(t, a.do(http.MethodPost, path+"/resend", admin, nil), http.StatusOK, "resending an invitation")
+	resent := a.token("grace@school.example")
+	expect(t, signup(models.SignupRequest{Token: old, Password: "Correct,Horse7", FirstName: "Grace", LastName: "Hopper"}), http.StatusBadRequest, "signing up with a replaced link")
+
+	update := databases.UpdateInvitation().SetToken(auth.HashToken(resent), time.Now().UTC().Add(-time.Hour), time.Now().UTC().Add(-time.Second))
+	if _, err := a.store.Invitations().UpdateOne(context.Background(), databases.FilterInvitations().ID(pending[0].ID), update); err != nil {
+		t.Fatalf("failed to expire the invitation: %v", err)
+	}
+	expect(t, signup(models.SignupRequest{Token: resent, Password: "Correct,Horse7", FirstName: "Grace", LastName: "Hopper"}), http.StatusBadRequest, "signing up with an expired link")
+
+	expect(t, a.do(http.MethodDelete, path, admin, nil), http.StatusNoContent, "revoking an invitation")
+	expect(t, a.do(http.MethodGet, path, admin, nil), http.StatusNotFound, "reading a revoked invitation")
+}
+
+func TestSignupUsesATokenOnce(t *testing.T) {
+	a := newTestApp(t, withInvitations)
+	_, admin := a.user(models.TypeAdmin, models.NewID())
+	_, token := a.invite(admin, "ada@school.example")
+
+	first, err := a.store.Invitations().FindOne(context.Background(), databases.FilterInvitations().Token(auth.HashToken(token)))
+	if err != nil {
+		t.Fatalf("failed to read the invitation: %v", err)
+	}
+
+	// both requests checked the token and the email before either signed up
+	stale := Invitation{
+		Auth:        Auth{DB: unknownEmails{a.store.Users()}, Mailer: a.sent, Config: &a.Config, Addresses: auth.NewIPThrottle(auth.AddressThrottle(0, 0))},
+		Invitations: staleInvitations{a.store.Invitations(), *first},
+	}
+	for i, want := range []int{http.StatusCreated, http.StatusBadRequest} {
+		b, _ := json.Marshal(models.SignupRequest{Token: token, Password: "Correct,Horse7", FirstName: "Ada", LastName: "Lovelace"})
+		rec := httptest.NewRecorder()
+		stale.Signup(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+		if rec.Code != want {
+			t.Errorf("signup #%d with a token read before it was used returned %d, want %d: %s", i+1, rec.Code, want, rec.Body)
+		}
+	}
+	users, err := a.store.Users().Find(context.Background(), databases.FilterUsers().Email("ada@school.example"))
+	if err != nil || len(users) != 1 {
+		t.Errorf("signing up twice with one link created %d users, %v", len(users), err)
+	}
+}
+
+// staleInvitations reads every invitation as it was when the test started
+type staleInvitations struct {
+	databases.InvitationDatabase
+	invitation models.Invitation
+}
+
+func (s staleInvitations) FindOne(ctx context.Context, filter *databases.InvitationFilter) (*models.Invitation, error) {
+	invitation := s.invitation
+	return &invitation, nil
+}
+
+func TestImportInvitations(t *testing.T) {
+	a := newTestApp(t, withInvitations)
+	business := models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	_, admin := a.user(models.TypeAdmin, business)
+	user, teacher := a.user(models.TypeUser, business)
+
+	body := "\ufeffEmail,FirstName,LastName,UserType\n" +
+		"ada@school.example,Ada,Lovelace,\n" +
+		"grace@school.example, Grace , Hopper,user\n" +
+		"not an email,No,Body,\n" +
+		user.Details.Email + ",Taken,Email,\n" +
+		"alan@school.example,Alan,Turing,admin\n" +
+		"edsger@school.example,Edsger,Dijkstra,owner\n" +
+		"ada@school.example,Ada,Again,\n"
+	expect(t, a.postCSV("/api/v2/invitations/import", teacher, body), http.StatusForbidden, "a User importing invitations")
+	expect(t, a.postCSV("/api/v2/invitations/import", super, body), http.StatusBadRequest, "a SuperUser importing without a business")
+	expect(t, a.postCSV("/api/v2/invitations/import", admin, "name\nAda\n"), http.StatusBadRequest, "importing a CSV without an email column")
+
+	rec := a.postCSV("/api/v2/invitations/import", admin, body)
+	expect(t, rec, http.StatusOK, "importing invitations")
+	got := result[models.InvitationImport](t, rec)
+	want := []struct {
+		line  int
+		error string
+	}{
+		{2, ""},
+		{3, ""},
+		{4, "email must be a valid email address"},
+		{5, "the email already has an account"},
+		{6, "admins can only add Users, promote them afterwards"},
+		{7, "usertype must be admin or user"},
+		{8, "the email is already invited, resend the invitation instead"},
+	}
+	if got.Invited != 2 || len(got.Rows) != len(want) {
+		t.Fatalf("the import is %+v, want 2 invited of %d rows", got, len(want))
+	}
+	for n, row := range got.Rows {
+		if row.Line != want[n].line || row.Error != want[n].error || (row.Error == "") == row.Invitation.IsZero() {
+			t.Errorf("row %d is %+v, want line %d with error %q", n, row, want[n].line, want[n].error)
+		}
+	}
+
+	rec = a.do(http.MethodGet, "/api/v2/invitations/"+got.Rows[1].Invitation.String(), admin, nil)
+	expect(t, rec, http.StatusOK, "reading an imported invitation")
+	if invitation := result[models.Invitation](t, rec); invitation.Details.FirstName != "Grace" || invitation.Details.Business != business {
+		t.Errorf("the imported invitation is %+v, want Grace invited to the business", invitation.Details)
+	}
+	if sent := a.sent.to("grace@school.example"); len(sent) != 1 {
+		t.Errorf("an imported invitee was sent %d emails, want 1", len(sent))
+	}
+
+	// SuperUsers name the business, and can invite Admins
+	rec = a.postCSV("/api/v2/invitations/import?business="+business.String(), super, "email,usertype\nalan@school.example,admin\n")
+	expect(t, rec, http.StatusOK, "a SuperUser importing invitations")
+	if got := result[models.InvitationImport](t, rec); got.Invited != 1 {
+		t.Errorf("a SuperUser's import is %+v, want 1 invited", got)
+	}
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// errUncounted is returned for bookings made with an API key on the cows of a business that
+// limits how many bookings each user makes
+var errUncounted = api.PolicyViolation("", "user_required", errors.New("the business limits how many bookings each user makes, book as a signed in user"))
+
+// errNoOverride is returned when someone who can't override booking policies asks to
+var errNoOverride = api.Forbidden(errors.New("only Admins of the cow's business can override its booking policies and blackouts"))
+
+// BookingPolicy limits the bookings users make on the cows of a business, see
+// models.BookingPolicy. Admins of the business can book past the limits with ?override=true.
+// A zero BookingPolicy doesn't limit bookings
+type BookingPolicy struct {
+	DB       databases.BookingPolicyDatabase
+	Cows     databases.CowDatabase            // read to count the bookings of a user,
+	Requests databases.BookingRequestDatabase // their bookings waiting for approval
+	Waitlist databases.WaitlistDatabase       // and the blocks offered to them
+	Zone     *time.Location                   // weeks start on Monday in it, config.Config.TimeZone
+}
+
+// ListBookingPolicies returns a page of booking policies. Admins see the policies of their
+// business, SuperUsers can filter by ?business
+func (bp BookingPolicy) ListBookingPolicies(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+
+	filter := databases.FilterBookingPolicies().Limit(p.Limit).Skip(p.Offset)
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType == models.TypeSuperUser:
+		if !business.IsZero() {
+			filter.Business(business)
+		}
+	case actor.Details.Business.IsZero():
+		api.WriteError(w, r, "failed to get booking policies", errNoBusiness)
+		return
+	default:
+		filter.Business(actor.Details.Business)
+	}
+
+	policies, err := bp.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get booking policies", err)
+		return
+	}
+	if policies == nil {
+		policies = []models.BookingPolicy{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": policies, "page": p})
+}
+
+// SetBookingPolicy sets the limits of a business for the role in the body, replacing the
+// policy the role already has
+func (bp BookingPolicy) SetBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	var req models.BookingPolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	actor, _ := api.UserFromContext(r.Context())
+	business, err := adminBusiness(actor, req.Business)
+	if err != nil {
+		api.WriteError(w, r, "the booking policy could not be saved", err)
+		return
+	}
+
+	policy := models.BookingPolicy{
+		ID: models.NewID(),
+		Details: models.BookingPolicyDetails{
+			Business:           business,
+			UserType:           req.UserType,
+			MaxConcurrent:      req.MaxConcurrent,
+			MaxPerWeek:         req.MaxPerWeek,
+			MaxLeadDays:        req.MaxLeadDays,
+			MinNoticeMinutes:   req.MinNoticeMinutes,
+			MaxDurationMinutes: req.MaxDurationMinutes,
+			UpdatedBy:          actor.ID,
+			UpdatedAt:          time.Now().UTC(),
+		},
+	}
+	filter := databases.FilterBookingPolicies().Business(business).UserType(req.UserType)
+	result, err := bp.DB.UpdateOne(ctx, filter, databases.UpdateBookingPolicy().SetLimits(policy.Details))
+	if err == nil && result.MatchedCount == 0 {
+		_, err = bp.DB.InsertOne(ctx, policy)
+	} else if err == nil {
+		var existing *models.BookingPolicy
+		if existing, err = bp.DB.FindOne(ctx, filter); err == nil {
+			policy.ID = existing.ID
+		}
+	}
+	if err != nil {
+		api.WriteError(w, r, "the booking policy could not be saved", err)
+		return
+	}
+	zap.S().Infow("set a booking policy", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "business", business, "usertype", req.UserType)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": policy})
+}
+
+// DeleteBookingPolicy removes a booking policy, the role falls back to the business wide
+// policy
+func (bp BookingPolicy) DeleteBookingPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	policyID, err := idParam(r, "policy_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid booking policy ID", err)
+		return
+	}
+	policy, err := bp.DB.FindOne(ctx, databases.FilterBookingPolicies().ID(policyID))
+	if err == nil && !ownBusiness(r, policy.Details.Business) {
+		err = databases.ErrNotFound
+	}
+	if err != nil {
+		api.WriteError(w, r, "booking policy not found", err)
+		return
+	}
+	result, err := bp.DB.DeleteOne(ctx, databases.FilterBookingPolicies().ID(policyID))
+	if err != nil {
+		api.WriteError(w, r, "the booking policy could not be deleted", err)
+		return
+	}
+	if result.DeletedCount == 0 {
+		api.WriteError(w, r, "booking policy not found", databases.ErrNotFound)
+		return
+	}
+	zap.S().Infow("removed a booking policy", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "bookingPolicy", policyID)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// check is a helper function refusing a booking that breaks the booking policy of the cow's
+// business for the signed in user's role. Bookings made with API keys are held to the business
+// wide policy, and refused when any policy of the business counts bookings, as they can't be
+// counted against a user. ?override=true skips the policy for Admins of the business and
+// SuperUsers
+func (bp BookingPolicy) check(ctx context.Context, r *http.Request, cow *models.Cow, booking models.BookDetails) error {
+	actor, _ := api.UserFromContext(r.Context())
+	return bp.checkFor(ctx, r, actor, cow, booking, "")
+}
+
+// checkFor is check for a booking made for booker rather than the signed in user, eg. by an
+// approver, nil for bookings asked for with API keys. pending is the booking request or
+// waitlist offer the booking is made from, which isn't counted against them twice.
+// ?override=true of r still applies
+func (bp BookingPolicy) checkFor(ctx context.Context, r *http.Request, actor *models.User, cow *models.Cow, booking models.BookDetails, pending models.ID) error {
+	if bp.DB == nil {
+		return nil
+	}
+	skip, err := overrides(r, cow)
+	if err != nil {
+		return err
+	}
+	if skip {
+		zap.S().Infow("overrode the booking policies of a business", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "cow", cow.ID, "business", cow.Details.Business)
+		return nil
+	}
+	if actor == nil {
+		counted, err := bp.counts(ctx, cow.Details.Business)
+		if err != nil {
+			return err
+		}
+		if counted {
+			return errUncounted
+		}
+	}
+
+	policy, err := bp.resolve(ctx, cow.Details.Business, actor)
+	if err != nil || policy == nil {
+		return err
+	}
+	limits := policy.Details
+
+	now := time.Now().UTC()
+	start, end := booking.StartDate.Time().UTC(), booking.EndDate.Time().UTC()
+	if notice := time.Duration(limits.MinNoticeMinutes) * time.Minute; notice > 0 && start.Sub(now) < notice {
+		return api.PolicyViolation("startdate", "min_notice", fmt.Errorf("bookings must be made at least %d minutes before they start", limits.MinNoticeMinutes))
+	}
+	if lead := time.Duration(limits.MaxLeadDays) * 24 * time.Hour; lead > 0 && start.Sub(now) > lead {
+		return api.PolicyViolation("startdate", "max_lead_time", fmt.Errorf("bookings can start at most %d days ahead", limits.MaxLeadDays))
+	}
+	if duration := time.Duration(limits.MaxDurationMinutes) * time.Minute; duration > 0 && end.Sub(start) > duration {
+		return api.PolicyViolation("enddate", "max_duration", fmt.Errorf("bookings can last at most %d minutes", limits.MaxDurationMinutes))
+	}
+	return bp.quota(ctx, actor, cow, booking, pending, limits, 0)
+}
+
+// confirm is a helper function counting the bookings of the signed in user again once
+// booking was added for them, see confirmFor
+func (bp BookingPolicy) confirm(ctx context.Context, r *http.Request, cow *models.Cow, booking models.BookDetails) error {
+	actor, _ := api.UserFromContext(r.Context())
+	return bp.confirmFor(ctx, r, actor, cow, booking)
+}
+
+// confirmFor is a helper function counting the bookings of booker again once booking was
+// added for them. Two bookings made at once both pass check, so the one taking them past a
+// limit is removed again here and its violation returned. When both do, both are removed
+func (bp BookingPolicy) confirmFor(ctx context.Context, r *http.Request, actor *models.User, cow *models.Cow, booking models.BookDetails) error {
+	if bp.DB == nil || actor == nil {
+		return nil
+	}
+	if skip, err := overrides(r, cow); skip || err != nil {
+		return err
+	}
+	policy, err := bp.resolve(ctx, cow.Details.Business, actor)
+	if err == nil && policy != nil {
+		err = bp.quota(ctx, actor, cow, booking, "", policy.Details, 1)
+	}
+	if err == nil {
+		return nil
+	}
+	if _, pullErr := bp.Cows.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PullBooking(booking.ID)); pullErr != nil {
+		zap.S().With("error", pullErr, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to remove a booking past a booking policy", "cow", cow.ID, "booking", booking.ID)
+	}
+	return err
+}
+
+// quota is a helper function refusing a booking that takes actor past the number of bookings
+// limits allow. Their bookings, their booking requests waiting for approval and the blocks
+// offered to them on waitlists count, except pending, the request or offer being booked.
+// added is 1 once the booking itself is among their bookings
+func (bp BookingPolicy) quota(ctx context.Context, actor *models.User, cow *models.Cow, booking models.BookDetails, pending models.ID, limits models.BookingPolicyDetails, added int) error {
+	if limits.MaxConcurrent == 0 && limits.MaxPerWeek == 0 {
+		return nil
+	}
+	business := cow.Details.Business
+	now := time.Now().UTC()
+	var counted []models.BookDetails
+
+	cows, err := bp.Cows.Find(ctx, databases.FilterCows().Business(business))
+	if err != nil {
+		return err
+	}
+	for _, c := range cows {
+		for _, b := range c.Details.Bookings {
+			if b.User == actor.ID {
+				counted = append(counted, b)
+			}
+		}
+	}
+	if bp.Requests != nil {
+		requests, err := bp.Requests.Find(ctx, databases.FilterBookingRequests().Business(business).Requester(actor.ID).Status(models.BookingPending))
+		if err != nil {
+			return err
+		}
+		for _, request := range requests {
+			if request.ID != pending && now.Before(request.Details.ExpiresAt) {
+				counted = append(counted, request.Details.Booking)
+			}
+		}
+	}
+	if bp.Waitlist != nil {
+		offers, err := bp.Waitlist.Find(ctx, databases.FilterWaitlist().Business(business).User(actor.ID).Status(models.WaitlistOffered))
+		if err != nil {
+			return err
+		}
+		for _, offer := range offers {
+			if offer.ID != pending && now.Before(offer.Details.OfferExpiresAt) {
+				counted = append(counted, offer.Details.Booking)
+			}
+		}
+	}
+
+	week := weekStart(booking.StartDate.Time(), bp.Zone)
+	concurrent, weekly := -added, -added
+	for _, b := range counted {
+		if b.EndDate.Time().After(now) {
+			concurrent++
+		}
+		if weekStart(b.StartDate.Time(), bp.Zone).Equal(week) {
+			weekly++
+		}
+	}
+	if limits.MaxConcurrent > 0 && concurrent >= limits.MaxConcurrent {
+		return api.PolicyViolation("", "max_concurrent", fmt.Errorf("you already have %d bookings that haven't ended, counting those waiting for approval or offered to you, the limit is %d", concurrent, limits.MaxConcurrent))
+	}
+	if limits.MaxPerWeek > 0 && weekly >= limits.MaxPerWeek {
+		return api.PolicyViolation("startdate", "max_per_week", fmt.Errorf("you already have %d bookings that week, counting those waiting for approval or offered to you, the limit is %d", weekly, limits.MaxPerWeek))
+	}
+	return nil
+}
+
+// overrides is a helper function reporting whether the request books with ?override=true,
+// which only Admins of the cow's business and SuperUsers can
+func overrides(r *http.Request, cow *models.Cow) (bool, error) {
+	switch r.URL.Query().Get("override") {
+	case "":
+		return false, nil
+	case "true":
+		if actor, _ := api.UserFromContext(r.Context()); actor == nil || actor.Details.UserType == models.TypeUser || !ownBusiness(r, cow.Details.Business) {
+			return false, errNoOverride
+		}
+		return true, nil
+	}
+	return false, api.InvalidParameter(errors.New("override can only be true"))
+}
+
+// counts is a helper function reporting whether a policy of the business limits how many
+// bookings users make
+func (bp BookingPolicy) counts(ctx context.Context, business models.ID) (bool, error) {
+	policies, err := bp.DB.Find(ctx, databases.FilterBookingPolicies().Business(business))
+	if err != nil {
+		return false, err
+	}
+	for _, policy := range policies {
+		if policy.Details.MaxConcurrent > 0 || policy.Details.MaxPerWeek > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// resolve is a helper function returning the policy of a business for the role of the
+// actor, or its business wide policy. It is nil when the business has no policy
+func (bp BookingPolicy) resolve(ctx context.Context, business models.ID, actor *models.User) (*models.BookingPolicy, error) {
+	if actor != nil {
+		policy, err := bp.DB.FindOne(ctx, databases.FilterBookingPolicies().Business(business).UserType(actor.Details.UserType))
+		if !errors.Is(err, databases.ErrNotFound) {
+			return policy, err
+		}
+	}
+	policy, err := bp.DB.FindOne(ctx, databases.FilterBookingPolicies().Business(business).UserType(0))
+	if errors.Is(err, databases.ErrNotFound) {
+		return nil, nil
+	}
+	return policy, err
+}
+
+// weekStart is a helper function returning the Monday starting the week of t in loc
+func weekStart(t time.Time, loc *time.Location) time.Time {
+	t = t.In(zoneOf(loc))
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// setPolicy is a helper function setting the booking policy of the business of the Admin
+// signed in with token
+func (a *testApp) setPolicy(token string, policy models.BookingPolicyRequest) {
+	a.t.Helper()
+	expect(a.t, a.do(http.MethodPut, "/api/v2/booking-policies", token, policy), http.StatusOK, "setting a booking policy")
+}
+
+// violation is a helper function failing the test unless a response is a policy violation
+// of rule
+func violation(t *testing.T, rec *httptest.ResponseRecorder, rule, what string) {
+	t.Helper()
+	if rec.Code != http.StatusUnprocessableEntity {
+		t.Errorf("%s returned %d, want 422: %s", what, rec.Code, rec.Body)
+		return
+	}
+	if got := errorOf(t, rec); len(got.Fields) == 0 || got.Fields[0].Code != rule {
+		t.Errorf("%s failed with %+v, want the rule %s", what, got, rule)
+	}
+}
+
+func TestPolicyTimeLimits(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, teacher := a.user(models.TypeUser, business)
+	_, admin := a.user(models.TypeAdmin, business)
+	cow := a.cow(business)
+	path := "/api/v2/cows/" + cow.ID.String() + "/bookings"
+	a.setPolicy(admin, models.BookingPolicyRequest{MaxLeadDays: 7, MinNoticeMinutes: 120, MaxDurationMinutes: 90})
+
+	soon := time.Now().UTC().Add(30 * time.Minute)
+	short := models.BookDetails{Block: "1", StartDate: primitive.NewDateTimeFromTime(soon), EndDate: primitive.NewDateTimeFromTime(soon.Add(time.Hour))}
+	violation(t, a.do(http.MethodPost, path, teacher, short), "min_notice", "booking 30 minutes ahead")
+	violation(t, a.do(http.MethodPost, path, teacher, bookingOn(10, "1")), "max_lead_time", "booking 10 days ahead")
+	long := bookingOn(3, "1")
+	long.EndDate = primitive.NewDateTimeFromTime(long.StartDate.Time().Add(2 * time.Hour))
+	violation(t, a.do(http.MethodPost, path, teacher, long), "max_duration", "booking 2 hours")
+	expect(t, a.do(http.MethodPost, path, teacher, bookingOn(3, "1")), http.StatusCreated, "booking within the policy")
+
+	// Admins of the business can book past the policy, Users can't ask to
+	expect(t, a.do(http.MethodPost, path+"?override=true", teacher, bookingOn(10, "1")), http.StatusForbidden, "a User overriding the policy")
+	expect(t, a.do(http.MethodPost, path+"?override=true", admin, bookingOn(10, "1")), http.StatusCreated, "an Admin overriding the policy")
+	expect(t, a.do(http.MethodPost, path+"?override=yes", admin, bookingOn(10, "2")), http.StatusBadRequest, "an invalid ?override")
+
+	// the business wide policy gives way to the policy of a role
+	a.setPolicy(admin, models.BookingPolicyRequest{UserType: models.TypeUser, MaxLeadDays: 30})
+	expect(t, a.do(http.MethodPost, path, teacher, bookingOn(10, "2")), http.StatusCreated, "booking within the policy of the role")
+}
+
+func TestPolicyQuotas(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	business := models.NewID()
+	teacher, teacherToken := a.user(models.TypeUser, business)
+	_, otherToken := a.user(models.TypeUser, business)
+	approver, admin := a.user(models.TypeAdmin, business)
+	cow := a.cow(business)
+	approved := a.approvedCow(business, approver.ID)
+	path := "/api/v2/cows/" + cow.ID.String() + "/bookings"
+	a.setPolicy(admin, models.BookingPolicyRequest{MaxConcurrent: 3, MaxPerWeek: 2})
+
+	// a booking and a request waiting for approval count against the weekly limit
+	expect(t, a.do(http.MethodPost, path, teacherToken, bookingOn(7, "1")), http.StatusCreated, "the first booking of the week")
+	request := a.requestBooking(approved, teacherToken, bookingOn(7, "2"))
+	violation(t, a.do(http.MethodPost, path, teacherToken, bookingOn(7, "3")), "max_per_week", "a third booking in the week")
+	expect(t, a.do(http.MethodPost, path, otherToken, bookingOn(7, "3")), http.StatusCreated, "another user's booking in the week")
+
+	// approving the request it counts as itself, not twice
+	expect(t, a.do(http.MethodPost, "/api/v2/booking-requests/"+request.ID.String()+"/approve", admin, nil), http.StatusOK, "approving a request within the limit")
+
+	// a block offered to them counts against the concurrent limit
+	offered := bookingOn(14, "1")
+	offered.User = teacher.ID
+	offer := models.WaitlistEntry{ID: models.NewID(), Details: models.WaitlistEntryDetails{
+		Cow:            cow.ID,
+		Business:       business,
+		Date:           slotDate(offered, time.UTC),
+		Block:          "1",
+		User:           teacher.ID,
+		Booking:        offered,
+		Status:         models.WaitlistOffered,
+		OfferedAt:      time.Now().UTC(),
+		OfferExpiresAt: time.Now().UTC().Add(time.Hour),
+	}}
+	if _, err := a.store.Waitlist().InsertOne(ctx, offer); err != nil {
+		t.Fatalf("failed to add a waitlist offer: %v", err)
+	}
+	violation(t, a.do(http.MethodPost, path, teacherToken, bookingOn(21, "1")), "max_concurrent", "a booking past the concurrent limit with an offer")
+	expect(t, a.do(http.MethodPost, path+"?override=true", admin, bookingOn(21, "1")), http.StatusCreated, "an Admin booking past the limit with ?override=true")
+
+	// accepting the offer it counts as itself, not twice
+	expect(t, a.do(http.MethodPost, "/api/v2/waitlist/"+offer.ID.String()+"/accept", teacherToken, nil), http.StatusCreated, "accepting an offer within the limit")
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 4 {
+		t.Errorf("the cow has %d bookings, want 4", len(got.Details.Bookings))
+	}
+}
+
+func TestPolicyConfirmRemovesTheBookingPastALimit(t *testing.T) {
+	a := newTestApp(t)
+	ctx := context.Background()
+	business := models.NewID()
+	teacher, _ := a.user(models.TypeUser, business)
+	_, admin := a.user(models.TypeAdmin, business)
+	cow := a.cow(business)
+	a.setPolicy(admin, models.BookingPolicyRequest{MaxConcurrent: 1})
+
+	// two bookings made at once both passed check and were added
+	var bookings []models.BookDetails
+	for _, block := range []string{"1", "2"} {
+		booking := bookingOn(3, block)
+		booking.ID, booking.User = cow.ID.String()+"."+block, teacher.ID
+		if _, err := a.store.Cows().UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(booking)); err != nil {
+			t.Fatalf("failed to add a booking: %v", err)
+		}
+		bookings = append(bookings, booking)
+	}
+
+	policies := a.policies()
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	if err := policies.confirmFor(ctx, r, teacher, cow, bookings[1]); err == nil {
+		t.Error("confirming a booking past the concurrent limit succeeded")
+	}
+	got := a.findCow(cow.ID)
+	if len(got.Details.Bookings) != 1 || got.Details.Bookings[0].ID != bookings[0].ID {
+		t.Fatalf("the cow has bookings %+v, want only the first", got.Details.Bookings)
+	}
+	if err := policies.confirmFor(ctx, r, teacher, cow, bookings[0]); err != nil {
+		t.Errorf("confirming a booking within the limit failed: %v", err)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 {
+		t.Errorf("confirming a booking within the limit removed it")
+	}
+}
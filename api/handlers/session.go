@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// ListSessions returns the active sessions of the signed in user, the one of the request
+// is marked current
+func (a Auth) ListSessions(w http.ResponseWriter, r *http.Request) {
+	user, current := api.UserFromContext(r.Context())
+
+	sessions := []models.SessionInfo{}
+	for _, session := range auth.ActiveSessions(user.Details.Sessions, time.Now()) {
+		if session.TwoFactorLogin {
+			continue // a login waiting for its code, not a session yet
+		}
+		sessions = append(sessions, models.SessionInfo{Session: session, Current: session.ID == current.ID})
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": sessions})
+}
+
+// RevokeSession signs out one session of the signed in user, eg. a lost or shared computer
+func (a Auth) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	id := mux.Vars(r)["session_id"]
+	user, _ := api.UserFromContext(r.Context())
+	active := auth.ActiveSessions(user.Details.Sessions, time.Now())
+	rest := auth.WithoutSession(active, id)
+	if len(rest) == len(active) {
+		api.WriteError(w, r, "session not found", databases.ErrNotFound)
+		return
+	}
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetSessions(rest)); err != nil {
+		api.WriteError(w, r, "failed to end the session", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RevokeOtherSessions signs out every session of the signed in user but the one of the request
+func (a Auth) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, session := api.UserFromContext(r.Context())
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetSessions([]models.Session{*session})); err != nil {
+		api.WriteError(w, r, "failed to end the sessions", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// throttled is a helper function returning an error when a login from the address of r, or
+// for user when the email is known, has to wait after failed logins
+func (a Auth) throttled(r *http.Request, user *models.User) error {
+	now := time.Now()
+	if wait, locked := a.Addresses.Wait(auth.ClientIP(r), now); locked {
+		return api.TooManyRequests(api.CodeTooManyRequests, wait, errors.New("too many failed logins from your network, try again later"))
+	} else if wait > 0 {
+		return api.TooManyRequests(api.CodeTooManyRequests, wait, fmt.Errorf("too many failed logins, wait %s", wait.Round(time.Second)))
+	}
+	if user == nil {
+		return nil
+	}
+	if wait, locked := a.accounts().AccountWait(user, now); locked {
+		return api.TooManyRequests(api.CodeAccountLocked, wait, fmt.Errorf("too many failed logins, the account is locked until %s", user.Details.LockedUntil.UTC().Format(time.RFC3339)))
+	} else if wait > 0 {
+		return api.TooManyRequests(api.CodeTooManyRequests, wait, fmt.Errorf("too many failed logins, wait %s", wait.Round(time.Second)))
+	}
+	return nil
+}
+
+// loginFailed is a helper function counting a failed login from the address of r and of
+// user, which is nil for unknown emails. Enough failures in a row lock the account
+func (a Auth) loginFailed(ctx context.Context, r *http.Request, user *models.User) {
+	now := time.Now().UTC()
+	ip := auth.ClientIP(r)
+	log := zap.S().With("requestId", api.RequestIDFromContext(r.Context()), "ip", ip)
+	if a.Addresses.Fail(ip, now) {
+		log.Warnw("locked the logins of an address after failed logins", "until", now.Add(a.Addresses.LockFor))
+	}
+	if user == nil {
+		return
+	}
+
+	throttle := a.accounts()
+	failures := throttle.Failures(user.Details.FailedLogins, user.Details.LastFailedLogin, now) + 1
+	update := databases.UpdateUser().SetFailedLogins(failures, now)
+	if throttle.Locks(failures) {
+		update.SetFailedLogins(0, now).SetLockedUntil(now.Add(throttle.LockFor))
+		log.Warnw("locked a user after failed logins", "uid", user.Details.UID, "until", now.Add(throttle.LockFor))
+	}
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		log.With("error", err).Errorw("failed to count a failed login", "uid", user.Details.UID)
+	}
+}
+
+// signedIn is a helper function run once a login is complete. It resets the failed logins
+// of user and of their address, and emails them when they signed in from a new device. Neither fails the login
+func (a Auth) signedIn(ctx context.Context, r *http.Request, user *models.User, session models.Session) {
+	log := zap.S().With("requestId", api.RequestIDFromContext(r.Context()), "uid", user.Details.UID)
+
+	a.Addresses.Reset(auth.ClientIP(r))
+	d := user.Details
+	update := databases.UpdateUser()
+	if d.FailedLogins != 0 || !d.LastFailedLogin.IsZero() || !d.LockedUntil.IsZero() {
+		update.SetFailedLogins(0, time.Time{}).SetLockedUntil(time.Time{})
+	}
+	devices, isNew := auth.RememberDevice(d.KnownDevices, auth.DeviceHash(session))
+	if isNew {
+		update.SetKnownDevices(devices)
+	}
+	if update.Empty() {
+		return
+	}
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		log.With("error", err).Errorw("failed to update the user after a login")
+		return
+	}
+
+	// the first device of a user, or of a user from before devices were remembered, isn't news
+	if !isNew || len(d.KnownDevices) == 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := a.Mailer.Send(ctx, auth.NewDeviceEmail(user, session)); err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+			log.With("error", err).Errorw("failed to email a new device login")
+		}
+	}()
+}
+
+// accounts is a helper function returning the throttle of failed logins per account
+func (a Auth) accounts() auth.Throttle {
+	return auth.AccountThrottle(a.Config.LoginMaxFailures, a.Config.LoginLockout)
+}
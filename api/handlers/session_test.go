@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// signIn is a helper function signing user in with password and returning the response
+func (a *testApp) signIn(user *models.User, password string) (*httptest.ResponseRecorder, models.LoginResponse) {
+	a.t.Helper()
+	rec := a.do(http.MethodPost, "/api/v2/auth/login", "", models.LoginRequest{Email: user.Details.Email, Password: password})
+	if rec.Code != http.StatusOK {
+		return rec, models.LoginResponse{}
+	}
+	return rec, result[models.LoginResponse](a.t, rec)
+}
+
+// frozenUsers reads the user as they were the first time, as a request reading a user just
+// before another changes them does
+type frozenUsers struct {
+	databases.UserDatabase
+	read *models.User
+}
+
+func (f *frozenUsers) FindOne(ctx context.Context, filter *databases.UserFilter) (*models.User, error) {
+	if f.read != nil {
+		user := *f.read
+		return &user, nil
+	}
+	user, err := f.UserDatabase.FindOne(ctx, filter)
+	f.read = user
+	return user, err
+}
+
+func TestSessions(t *testing.T) {
+	a := newTestApp(t)
+	user, first := a.user(models.TypeUser, models.NewID())
+	a.withPassword(user)
+	_, second := a.signIn(user, testPassword)
+	_, third := a.signIn(user, testPassword)
+	_, other := a.user(models.TypeUser, models.NewID())
+
+	rec := a.do(http.MethodGet, "/api/v2/auth/sessions", first, nil)
+	expect(t, rec, http.StatusOK, "listing sessions")
+	sessions := result[[]models.SessionInfo](t, rec)
+	var firstID string
+	for _, session := range sessions {
+		if session.Current {
+			firstID = session.ID
+		}
+	}
+	if len(sessions) != 3 || firstID == "" {
+		t.Fatalf("the user has the sessions %+v, want 3 with the current one", sessions)
+	}
+
+	// a user only signs out their own sessions
+	expect(t, a.do(http.MethodDelete, "/api/v2/auth/sessions/"+firstID, other, nil), http.StatusNotFound, "signing out the session of someone else")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", first, nil), http.StatusOK, "using a session")
+	expect(t, a.do(http.MethodDelete, "/api/v2/auth/sessions/"+firstID, second.Token, nil), http.StatusNoContent, "signing out a session")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", first, nil), http.StatusUnauthorized, "using a signed out session")
+
+	expect(t, a.do(http.MethodDelete, "/api/v2/auth/sessions", second.Token, nil), http.StatusNoContent, "signing out the other sessions")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", third.Token, nil), http.StatusUnauthorized, "using another signed out session")
+	expect(t, a.do(http.MethodPost, "/api/v2/auth/logout", second.Token, nil), http.StatusNoContent, "signing out")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", second.Token, nil), http.StatusUnauthorized, "using the session after signing out")
+}
+
+func TestForgotPassword(t *testing.T) {
+	a := newTestApp(t)
+	user, session := a.user(models.TypeUser, models.NewID())
+	a.withPassword(user)
+	const password = "Correct,Horse8"
+	forgot := func(email string) {
+		t.Helper()
+		expect(t, a.do(http.MethodPost, "/api/v2/auth/forgot-password", "", models.ForgotPasswordRequest{Email: email}), http.StatusAccepted, "asking to reset the password of "+email)
+	}
+	reset := func(token, password string) *httptest.ResponseRecorder {
+		return a.do(http.MethodPost, "/api/v2/auth/reset-password", "", models.ResetPasswordRequest{Token: token, Password: password})
+	}
+
+	// unknown emails answer the same, without an email
+	forgot("nobody@school.example")
+	if sent := a.sent.to("nobody@school.example"); len(sent) != 0 {
+		t.Errorf("an unknown email was sent %d emails", len(sent))
+	}
+
+	forgot(user.Details.Email)
+	token := a.token(user.Details.Email)
+	expect(t, reset(token, "weakpassword"), http.StatusBadRequest, "resetting to a weak password")
+	expect(t, reset(strings.Repeat("0", 64), password), http.StatusBadRequest, "resetting with a wrong token")
+	expect(t, reset(token, password), http.StatusNoContent, "resetting the password")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", session, nil), http.StatusUnauthorized, "using a session from before the reset")
+	if rec, _ := a.signIn(user, password); rec.Code != http.StatusOK {
+		t.Errorf("signing in with the new password returned %d", rec.Code)
+	}
+
+	// each link works once
+	rec := reset(token, "Correct,Horse9")
+	expect(t, rec, http.StatusBadRequest, "resetting with a used token")
+	if got := errorOf(t, rec); got.Code != api.CodeValidation || len(got.Fields) != 1 || got.Fields[0].Field != "token" {
+		t.Errorf("a used token answered %s %+v, want a token field error", got.Code, got.Fields)
+	}
+
+	// a new link replaces the last one, and expires
+	forgot(user.Details.Email)
+	old := a.token(user.Details.Email)
+	forgot(user.Details.Email)
+	expect(t, reset(old, "Correct,Horse9"), http.StatusBadRequest, "resetting with a replaced token")
+	latest := a.token(user.Details.Email)
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetResetToken(auth.HashToken(latest), time.Now().UTC().Add(-time.Second))); err != nil {
+		t.Fatalf("failed to expire the token: %v", err)
+	}
+	expect(t, reset(latest, "Correct,Horse9"), http.StatusBadRequest, "resetting with an expired token")
+
+	// disabled users can't get a link
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetDisabled(true)); err != nil {
+		t.Fatalf("failed to disable the user: %v", err)
+	}
+	before := len(a.sent.to(user.Details.Email))
+	forgot(user.Details.Email)
+	if after := len(a.sent.to(user.Details.Email)); after != before {
+		t.Errorf("a disabled user was sent a reset link")
+	}
+}
+
+func TestResetPasswordUsesATokenOnce(t *testing.T) {
+	a := newTestApp(t)
+	user, _ := a.user(models.TypeUser, models.NewID())
+	token, hash := auth.NewToken()
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetResetToken(hash, time.Now().UTC().Add(time.Hour))); err != nil {
+		t.Fatalf("failed to save the reset token: %v", err)
+	}
+
+	// both requests read the user before either reset the password
+	frozen := Auth{DB: &frozenUsers{UserDatabase: a.store.Users()}, Config: &a.Config}
+	for i, want := range []int{http.StatusNoContent, http.StatusBadRequest} {
+		b, _ := json.Marshal(models.ResetPasswordRequest{Token: token, Password: "Correct,Horse" + string(rune('7'+i))})
+		rec := httptest.NewRecorder()
+		frozen.ResetPassword(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+		if rec.Code != want {
+			t.Errorf("reset #%d with a token read before it was used returned %d, want %d", i+1, rec.Code, want)
+		}
+	}
+	if found := a.findUser(user.ID); !found.ComparePasswords("Correct,Horse7") {
+		t.Error("the second reset changed the password")
+	}
+}
+
+func TestTemporaryPassword(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	user, _ := a.user(models.TypeUser, business)
+	_, admin := a.user(models.TypeAdmin, business)
+	otherAdmin, otherAdminToken := a.user(models.TypeAdmin, business)
+	_, stranger := a.user(models.TypeAdmin, models.NewID())
+	_, teacher := a.user(models.TypeUser, business)
+	reset := "/api/v2/users/" + user.ID.String() + "/reset-password"
+
+	// only those above the user in their business reset their password
+	expect(t, a.do(http.MethodPost, reset, stranger, nil), http.StatusForbidden, "an Admin of another business resetting a password")
+	expect(t, a.do(http.MethodPost, reset, teacher, nil), http.StatusForbidden, "a User resetting a password")
+	expect(t, a.do(http.MethodPost, "/api/v2/users/"+otherAdmin.ID.String()+"/reset-password", admin, nil), http.StatusForbidden, "an Admin resetting the password of an Admin")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", otherAdminToken, nil), http.StatusOK, "using a session of the Admin")
+
+	rec := a.do(http.MethodPost, reset, admin, nil)
+	expect(t, rec, http.StatusOK, "an Admin resetting the password of a User")
+	if got := result[models.PasswordResetResult](t, rec); !got.Emailed || got.TemporaryPassword != "" {
+		t.Errorf("the reset answered %+v, want the password emailed and not returned", got)
+	}
+	sent := a.sent.to(user.Details.Email)
+	if len(sent) != 1 {
+		t.Fatalf("the user was sent %d emails, want 1", len(sent))
+	}
+	temporary := strings.TrimSpace(strings.Split(strings.Split(sent[0].Body, "\n\n")[2], "\n")[0])
+
+	// the temporary password only allows changing it
+	rec, login := a.signIn(user, temporary)
+	expect(t, rec, http.StatusOK, "signing in with the temporary password")
+	if !login.PasswordChangeRequired {
+		t.Error("signing in with a temporary password doesn't require changing it")
+	}
+	rec = a.do(http.MethodGet, "/api/v2/auth/sessions", login.Token, nil)
+	expect(t, rec, http.StatusForbidden, "listing sessions before changing the temporary password")
+	if got := errorOf(t, rec).Code; got != api.CodePasswordChangeRequired {
+		t.Errorf("a session with a temporary password answered %s, want %s", got, api.CodePasswordChangeRequired)
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/auth/password", login.Token, models.ChangePasswordRequest{CurrentPassword: temporary, NewPassword: temporary}), http.StatusBadRequest, "keeping the temporary password")
+	rec = a.do(http.MethodPost, "/api/v2/auth/password", login.Token, models.ChangePasswordRequest{CurrentPassword: temporary, NewPassword: "Correct,Horse8"})
+	expect(t, rec, http.StatusOK, "changing the temporary password")
+	changed := result[models.LoginResponse](t, rec)
+	if changed.PasswordChangeRequired {
+		t.Error("the session after changing the temporary password still requires changing it")
+	}
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/sessions", changed.Token, nil), http.StatusOK, "listing sessions after changing the password")
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", login.Token, nil), http.StatusUnauthorized, "using the session of the temporary password")
+	if rec, _ := a.signIn(user, temporary); rec.Code != http.StatusUnauthorized {
+		t.Errorf("signing in with the old temporary password returned %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
+)
+
+// Setup creates the first SuperUser with POST /setup. It needs the token logged on startup
+// and locks itself once any user exists, so it can only be used once
+type Setup struct {
+	DB    databases.UserDatabase
+	token string
+
+	mu   sync.Mutex
+	done bool
+}
+
+// required is a helper function reporting whether there are still no users
+func (s *Setup) required(ctx context.Context) (bool, error) {
+	if s.done {
+		return false, nil
+	}
+	users, err := s.DB.Find(ctx, databases.FilterUsers().Limit(1))
+	if err != nil {
+		return false, err
+	}
+	// users can also be created with the CLI while the API runs
+	s.done = len(users) > 0
+	return !s.done, nil
+}
+
+// SetupStatusHandler reports whether the first SuperUser still has to be created
+func (s *Setup) SetupStatusHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	s.mu.Lock()
+	required, err := s.required(ctx)
+	s.mu.Unlock()
+	if err != nil {
+		api.WriteError(w, r, "failed to check for users", err)
+		return
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": models.SetupStatus{Required: required}})
+}
+
+// SetupHandler creates the first SuperUser and locks the setup
+func (s *Setup) SetupHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.SetupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("password", "password", err))
+		return
+	}
+
+	// hold the lock until the user is inserted, so two requests can't both create one
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	required, err := s.required(ctx)
+	if err != nil {
+		api.WriteError(w, r, "failed to check for users", err)
+		return
+	}
+	if !required {
+		api.WriteError(w, r, "setup is locked", api.Forbidden(errors.New("the first SuperUser has already been created")))
+		return
+	}
+	if s.token == "" || subtle.ConstantTimeCompare([]byte(req.Token), []byte(s.token)) != 1 {
+		api.WriteError(w, r, "setup is locked", api.Forbidden(errors.New("wrong setup token")))
+		return
+	}
+
+	admin := util.NewUser(s.DB, models.UserDetails{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		UserType:  models.TypeSuperUser,
+	}, req.Password, false)
+	if _, err := s.DB.InsertOne(ctx, admin); err != nil {
+		api.WriteError(w, r, "failed to create the SuperUser", err)
+		return
+	}
+	s.done = true
+
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": admin})
+}
@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+func TestSetup(t *testing.T) {
+	a := newTestApp(t, func(c *config.Config) { c.SetupToken = "setup-token" })
+	setup := func(password string) *httptest.ResponseRecorder {
+		return a.do(http.MethodPost, "/setup", "", models.SetupRequest{Token: "setup-token", FirstName: "First", LastName: "Admin", Email: "admin@school.example", Password: password})
+	}
+
+	// passwords are checked up to the 72 bytes bcrypt reads, like everywhere else
+	long := "Correct,Horse7" + strings.Repeat("battery", 10)
+	rec := setup(long)
+	expect(t, rec, http.StatusBadRequest, "a password past 72 characters")
+	if got := errorOf(t, rec); len(got.Fields) == 0 || got.Fields[0].Field != "password" {
+		t.Errorf("a password past 72 characters failed with %+v, want the field password", got)
+	}
+	expect(t, setup(strings.Repeat("battery", 5)), http.StatusBadRequest, "a weak password")
+	expect(t, a.do(http.MethodPost, "/setup", "", models.SetupRequest{Token: "wrong", FirstName: "First", LastName: "Admin", Email: "admin@school.example", Password: long[:40]}), http.StatusForbidden, "a wrong setup token")
+
+	rec = setup(long[:40])
+	expect(t, rec, http.StatusCreated, "a strong password of 40 characters")
+	if got := result[models.User](t, rec); got.Details.UserType != models.TypeSuperUser {
+		t.Errorf("setup created a user of type %d, want a SuperUser", got.Details.UserType)
+	}
+	expect(t, setup(long[:40]), http.StatusForbidden, "setting up twice")
+}
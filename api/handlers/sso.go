@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// oidcCookie holds the state, nonce and PKCE verifier of a login until the callback
+const oidcCookie = "devicebooking_oidc"
+
+// oidcLoginTTL is how long the browser may spend at the identity provider
+const oidcLoginTTL = 10 * time.Minute
+
+// SSO signs users in with the OpenID Connect providers of their business, creating their
+// account on the first login
+type SSO struct {
+	Auth
+	Providers map[string]*auth.OIDCProvider
+}
+
+// ListProviders lists the single sign-on providers, ?email= picks the ones that accept it
+func (s SSO) ListProviders(w http.ResponseWriter, r *http.Request) {
+	email := strings.TrimSpace(r.URL.Query().Get("email"))
+	base := strings.TrimRight(s.Config.BaseURL, "/")
+
+	providers := []models.SSOProvider{}
+	for _, p := range s.Providers {
+		if email != "" && !p.AllowsEmail(email) {
+			continue
+		}
+		providers = append(providers, models.SSOProvider{
+			Name:        p.Name,
+			DisplayName: p.DisplayName,
+			Business:    p.Business,
+			Domains:     p.Domains,
+			LoginURL:    base + "/api/v2/auth/oidc/" + p.Name + "/login",
+		})
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name < providers[j].Name })
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": providers})
+}
+
+// Login sends the browser to the identity provider. ?return_to= is the path of BASE_URL
+// the callback redirects to, "/" by default
+func (s SSO) Login(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, ok := s.Providers[mux.Vars(r)["provider"]]
+	if !ok {
+		api.WriteError(w, r, "unknown single sign-on provider", databases.ErrNotFound)
+		return
+	}
+	returnTo := r.URL.Query().Get("return_to")
+	if returnTo == "" {
+		returnTo = "/"
+	}
+	// only paths of our own site, so the login can't be used as an open redirect
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.HasPrefix(returnTo, "/\\") {
+		api.WriteError(w, r, "invalid return_to", api.InvalidParameter(errors.New("return_to must be a path, eg. /bookings")))
+		return
+	}
+
+	login := auth.NewOIDCLogin()
+	target, err := provider.AuthCodeURL(ctx, login)
+	if err != nil {
+		api.WriteError(w, r, "the identity provider is unavailable", err)
+		return
+	}
+
+	value := strings.Join([]string{login.State, login.Nonce, login.Verifier, base64.RawURLEncoding.EncodeToString([]byte(returnTo))}, ".")
+	http.SetCookie(w, s.cookie(value, int(oidcLoginTTL/time.Second)))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// Callback finishes a login at the identity provider. The user with the email is signed
+// in, or created in the provider's business, and the browser is sent back to the
+// return_to path with the LoginResponse in the URL fragment
+func (s SSO) Callback(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	provider, ok := s.Providers[mux.Vars(r)["provider"]]
+	if !ok {
+		api.WriteError(w, r, "unknown single sign-on provider", databases.ErrNotFound)
+		return
+	}
+	http.SetCookie(w, s.cookie("", -1)) // every login state is used once
+
+	query := r.URL.Query()
+	if reason := query.Get("error"); reason != "" {
+		api.WriteError(w, r, "the identity provider refused the login", api.Unauthorized(fmt.Errorf("%s: %s", reason, query.Get("error_description"))))
+		return
+	}
+	login, returnTo, err := parseOIDCCookie(r)
+	if err != nil || query.Get("state") != login.State {
+		api.WriteError(w, r, "invalid single sign-on state", api.Unauthorized(errors.New("the login expired or was started in another browser, try again")))
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, login, query.Get("code"))
+	if errors.Is(err, auth.ErrSSODenied) {
+		api.WriteError(w, r, "single sign-on denied", api.Forbidden(err))
+		return
+	}
+	if err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Warnw("single sign-on failed", "provider", provider.Name)
+		api.WriteError(w, r, "single sign-on failed", api.Unauthorized(errors.New("the identity provider's response could not be verified")))
+		return
+	}
+
+	user, err := auth.Provision(ctx, s.DB, provider.Key(), provider.Business, identity, provider.UserType(identity))
+	if errors.Is(err, auth.ErrSSODenied) {
+		api.WriteError(w, r, "single sign-on denied", api.Forbidden(err))
+		return
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to sign in", err)
+		return
+	}
+
+	resp, err := s.signIn(ctx, r, user)
+	if err != nil {
+		api.WriteError(w, r, "failed to save the session", err)
+		return
+	}
+	fragment := url.Values{}
+	fragment.Set("token", resp.Token)
+	fragment.Set("expiresAt", resp.ExpiresAt.Format(time.RFC3339))
+	fragment.Set("passwordChangeRequired", strconv.FormatBool(resp.PasswordChangeRequired))
+	fragment.Set("twoFactorRequired", strconv.FormatBool(resp.TwoFactorRequired))
+	fragment.Set("twoFactorSetupRequired", strconv.FormatBool(resp.TwoFactorSetupRequired))
+	http.Redirect(w, r, strings.TrimRight(s.Config.BaseURL, "/")+returnTo+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// cookie is a helper function building the login state cookie, a negative maxAge deletes it
+func (s SSO) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcCookie,
+		Value:    value,
+		Path:     "/api/v2/auth/oidc/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(s.Config.BaseURL, "https://"),
+		SameSite: http.SameSiteLaxMode, // sent on the provider's redirect back to us
+	}
+}
+
+// parseOIDCCookie is a helper function reading the login state set by SSO.Login
+func parseOIDCCookie(r *http.Request) (auth.OIDCLogin, string, error) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		return auth.OIDCLogin{}, "", err
+	}
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 4 {
+		return auth.OIDCLogin{}, "", errors.New("malformed login state")
+	}
+	returnTo, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return auth.OIDCLogin{}, "", err
+	}
+	return auth.OIDCLogin{State: parts[0], Nonce: parts[1], Verifier: parts[2]}, string(returnTo), nil
+}
@@ -0,0 +1,317 @@
+package handlers
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/thanhpk/randstr"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const (
+	ssoBaseURL  = "http://booking.test"
+	ssoClientID = "device-booking"
+)
+
+// fakeIssuer is an OpenID Connect provider serving discovery, JWKS and a token endpoint
+// that checks the PKCE verifier. Codes are handed out by authorize instead of a login page
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]fakeCode
+}
+
+// fakeCode is an authorization code waiting for the token request
+type fakeCode struct {
+	challenge string
+	claims    map[string]interface{}
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate the signing key: %v", err)
+	}
+	issuer := &fakeIssuer{key: key, codes: map[string]fakeCode{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"issuer":                                issuer.URL,
+			"authorization_endpoint":                issuer.URL + "/authorize",
+			"token_endpoint":                        issuer.URL + "/token",
+			"jwks_uri":                              issuer.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.Server = httptest.NewServer(mux)
+	t.Cleanup(issuer.Close)
+	return issuer
+}
+
+// token exchanges a code for an ID token once the verifier matches the code's challenge
+func (f *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	f.mu.Lock()
+	code, ok := f.codes[r.PostForm.Get("code")]
+	delete(f.codes, r.PostForm.Get("code"))
+	f.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != code.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randstr.Hex(16),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     f.sign(code.claims),
+	})
+}
+
+// authorize is what the login page of the issuer does: it reads the authorization request
+// the API redirected to and returns a code for an ID token with the claims. The nonce of
+// the request is used unless the claims set one
+func (f *fakeIssuer) authorize(t *testing.T, location string, claims map[string]interface{}) (state, code string) {
+	t.Helper()
+	target, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, f.URL+"/authorize") {
+		t.Fatalf("login redirected to %q, want the authorization endpoint", location)
+	}
+	query := target.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request %q has no S256 PKCE challenge", location)
+	}
+	if query.Get("redirect_uri") != ssoBaseURL+"/api/v2/auth/oidc/school/callback" {
+		t.Fatalf("authorization request has redirect_uri %q", query.Get("redirect_uri"))
+	}
+
+	now := time.Now()
+	full := map[string]interface{}{
+		"iss":   f.URL,
+		"aud":   ssoClientID,
+		"sub":   randstr.Hex(8),
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+		"nonce": query.Get("nonce"),
+	}
+	for name, value := range claims {
+		full[name] = value
+	}
+
+	code = randstr.Hex(16)
+	f.mu.Lock()
+	f.codes[code] = fakeCode{challenge: query.Get("code_challenge"), claims: full}
+	f.mu.Unlock()
+	return query.Get("state"), code
+}
+
+// sign is a helper function returning claims as an RS256 JWT
+func (f *fakeIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// newSSOApp is a helper function starting the API on an in-memory SQLite store with the
+// issuer as the "school" provider of business
+func newSSOApp(t *testing.T, issuer *fakeIssuer, business models.ID) *App {
+	t.Helper()
+	a := &App{Config: config.Config{
+		Backend:       "sqlite",
+		URL:           ":memory:",
+		AutoMigrate:   true,
+		BaseURL:       ssoBaseURL,
+		AdminEmail:    "root@example.com",
+		AdminPassword: "Password1!root",
+		SessionTTL:    time.Hour,
+		OIDCProviders: []config.OIDCProvider{{
+			Name:        "school",
+			Business:    business,
+			Issuer:      issuer.URL,
+			ClientID:    ssoClientID,
+			Domains:     []string{"school.example"},
+			AdminGroups: []string{"staff"},
+		}},
+	}}
+	if err := a.Initialize(); err != nil {
+		t.Fatalf("failed to start the API: %v", err)
+	}
+	t.Cleanup(func() { a.store.Close(context.Background()) })
+	return a
+}
+
+// startLogin is a helper function requesting the login redirect, it returns the
+// authorization request and the login state cookie
+func startLogin(t *testing.T, a *App) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v2/auth/oidc/school/login?return_to=/bookings", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login returned %d: %s", rec.Code, rec.Body)
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == oidcCookie {
+			return rec.Header().Get("Location"), cookie
+		}
+	}
+	t.Fatal("login set no state cookie")
+	return "", nil
+}
+
+// callback is a helper function returning the response of the browser's redirect back
+func callback(a *App, cookie *http.Cookie, state, code string) *httptest.ResponseRecorder {
+	query := url.Values{"state": {state}, "code": {code}}
+	req := httptest.NewRequest(http.MethodGet, "/api/v2/auth/oidc/school/callback?"+query.Encode(), nil)
+	req.AddCookie(cookie)
+	rec := httptest.NewRecorder()
+	a.Router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSSOFirstLoginProvisions(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	business := models.NewID()
+	a := newSSOApp(t, issuer, business)
+	claims := map[string]interface{}{
+		"email":          "Grace.Teacher@school.example",
+		"email_verified": true,
+		"given_name":     "Grace",
+		"family_name":    "Teacher",
+		"groups":         []string{"staff"},
+	}
+
+	var first models.ID
+	for i := 0; i < 2; i++ {
+		location, cookie := startLogin(t, a)
+		state, code := issuer.authorize(t, location, claims)
+		rec := callback(a, cookie, state, code)
+		if rec.Code != http.StatusFound {
+			t.Fatalf("callback returned %d: %s", rec.Code, rec.Body)
+		}
+		redirect, _ := url.Parse(rec.Header().Get("Location"))
+		fragment, _ := url.ParseQuery(redirect.Fragment)
+		if redirect.Path != "/bookings" || fragment.Get("token") == "" {
+			t.Fatalf("callback redirected to %q, want /bookings with a session token", rec.Header().Get("Location"))
+		}
+
+		user, err := a.store.Users().FindOne(context.Background(), databases.FilterUsers().Email("grace.teacher@school.example"))
+		if err != nil {
+			t.Fatalf("the user was not provisioned: %v", err)
+		}
+		if user.Details.Business != business || user.Details.UserType != models.TypeAdmin || user.Details.AuthProvider != auth.ProviderPrefix+"school" {
+			t.Errorf("provisioned %+v, want an Admin of %s from oidc:school", user.Details, business)
+		}
+		if user.Details.FirstName != "Grace" || user.Details.LastName != "Teacher" {
+			t.Errorf("provisioned %s %s, want Grace Teacher", user.Details.FirstName, user.Details.LastName)
+		}
+		if i == 0 {
+			first = user.ID
+		} else if user.ID != first {
+			t.Errorf("the second login made user %s, want the first login's %s", user.ID, first)
+		}
+	}
+}
+
+func TestSSOStateMismatch(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	a := newSSOApp(t, issuer, models.NewID())
+
+	location, cookie := startLogin(t, a)
+	_, code := issuer.authorize(t, location, map[string]interface{}{"email": "grace@school.example"})
+	if rec := callback(a, cookie, "another-state", code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback with another state returned %d, want 401", rec.Code)
+	}
+	assertNotProvisioned(t, a, "grace@school.example")
+}
+
+func TestSSONonceMismatch(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	a := newSSOApp(t, issuer, models.NewID())
+
+	location, cookie := startLogin(t, a)
+	state, code := issuer.authorize(t, location, map[string]interface{}{"email": "grace@school.example", "nonce": "replayed"})
+	if rec := callback(a, cookie, state, code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback with an ID token for another nonce returned %d, want 401", rec.Code)
+	}
+	assertNotProvisioned(t, a, "grace@school.example")
+}
+
+func TestSSOVerifierMismatch(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	a := newSSOApp(t, issuer, models.NewID())
+
+	// an intercepted code is useless without the verifier kept in the browser's cookie
+	location, cookie := startLogin(t, a)
+	state, code := issuer.authorize(t, location, map[string]interface{}{"email": "grace@school.example"})
+	parts := strings.Split(cookie.Value, ".")
+	parts[2] = randstr.Hex(32)
+	forged := &http.Cookie{Name: cookie.Name, Value: strings.Join(parts, ".")}
+	if rec := callback(a, forged, state, code); rec.Code != http.StatusUnauthorized {
+		t.Errorf("callback with another PKCE verifier returned %d, want 401", rec.Code)
+	}
+	assertNotProvisioned(t, a, "grace@school.example")
+}
+
+func TestSSODisallowedDomain(t *testing.T) {
+	issuer := newFakeIssuer(t)
+	a := newSSOApp(t, issuer, models.NewID())
+
+	location, cookie := startLogin(t, a)
+	state, code := issuer.authorize(t, location, map[string]interface{}{"email": "grace@gmail.example", "email_verified": true})
+	if rec := callback(a, cookie, state, code); rec.Code != http.StatusForbidden {
+		t.Errorf("callback for an email of another domain returned %d, want 403", rec.Code)
+	}
+	assertNotProvisioned(t, a, "grace@gmail.example")
+}
+
+func assertNotProvisioned(t *testing.T, a *App, email string) {
+	t.Helper()
+	if _, err := a.store.Users().FindOne(context.Background(), databases.FilterUsers().Email(email)); err == nil {
+		t.Errorf("%s was provisioned by a failed login", email)
+	}
+}
@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+var (
+	errWrongCode       = errors.New("is wrong or was already used")
+	errTwoFactorFailed = errors.New("wrong two-factor code, sign in again")
+)
+
+// errTwoFactorState rejects requests that don't fit the enrollment state of the user, the
+// message of the response says why
+var errTwoFactorState = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("wrong two-factor state")}
+
+// TwoFactorEnroll creates a new TOTP secret for the signed in user. Logins only need it
+// once it is confirmed with TwoFactorConfirm
+func (a Auth) TwoFactorEnroll(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user, _ := api.UserFromContext(r.Context())
+	if user.Details.TOTPEnabled {
+		api.WriteError(w, r, "two-factor authentication is already enabled, disable it first", errTwoFactorState)
+		return
+	}
+
+	secret := auth.NewTOTPSecret()
+	uri := auth.TOTPURI(a.Config.TOTPIssuer, user.Details.Email, secret)
+	qr, err := auth.TOTPQRCode(uri)
+	if err != nil {
+		api.WriteError(w, r, "failed to create the QR code", err)
+		return
+	}
+
+	update := databases.UpdateUser().SetTOTP(secret, false).SetTOTPLastStep(0)
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to save the secret", err)
+		return
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": models.TwoFactorEnrollment{Secret: secret, URI: uri, QRCode: qr}})
+}
+
+// TwoFactorConfirm turns two-factor authentication on with a first code from the
+// authenticator app, and returns the recovery codes
+func (a Auth) TwoFactorConfirm(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	user, _ := api.UserFromContext(r.Context())
+	if user.Details.TOTPEnabled {
+		api.WriteError(w, r, "two-factor authentication is already enabled, disable it first", errTwoFactorState)
+		return
+	}
+	if user.Details.TOTPSecret == "" {
+		api.WriteError(w, r, "two-factor authentication is not enrolled, start with POST /api/v2/auth/2fa/enroll", errTwoFactorState)
+		return
+	}
+	step, ok := auth.VerifyTOTP(user.Details.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("code", "totp", errWrongCode))
+		return
+	}
+
+	// sessions waiting for the enrollment become full sessions
+	sessions := auth.ActiveSessions(user.Details.Sessions, time.Now())
+	for i := range sessions {
+		sessions[i].TwoFactorSetup = false
+	}
+	codes, hashes := auth.NewRecoveryCodes()
+	update := databases.UpdateUser().
+		SetTOTP(user.Details.TOTPSecret, true).
+		SetTOTPLastStep(step).
+		SetRecoveryCodes(hashes).
+		SetSessions(sessions).
+		SetUpdatedAt(time.Now().UTC())
+	result, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID).TOTPStepBefore(step), update)
+	if err != nil {
+		api.WriteError(w, r, "failed to enable two-factor authentication", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("code", "totp", errWrongCode))
+		return
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": models.RecoveryCodes{Codes: codes}})
+}
+
+// TwoFactorVerify finishes a login of a user with two-factor authentication. A wrong code
+// ends the attempt, the password has to be sent again
+func (a Auth) TwoFactorVerify(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.TwoFactorVerifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("code", "required", errors.New("is required unless a recoveryCode is sent")))
+		return
+	}
+
+	userID, secret, err := auth.ParseSessionToken(req.Token)
+	if err != nil {
+		api.WriteError(w, r, "failed to sign in", api.Unauthorized(err))
+		return
+	}
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().ID(userID))
+	if errors.Is(err, databases.ErrNotFound) {
+		api.WriteError(w, r, "failed to sign in", api.Unauthorized(auth.ErrInvalidToken))
+		return
+	}
+	if err != nil {
+		api.WriteError(w, r, "failed to find the user", err)
+		return
+	}
+	challenge, err := auth.FindSession(user, secret, time.Now())
+	if err != nil || !challenge.TwoFactorLogin {
+		api.WriteError(w, r, "failed to sign in", api.Unauthorized(auth.ErrInvalidToken))
+		return
+	}
+	if err := a.throttled(r, user); err != nil {
+		api.WriteError(w, r, "failed to sign in", err)
+		return
+	}
+	// an Admin may have disabled the user since the password step
+	if user.Details.Disabled {
+		api.WriteError(w, r, "failed to sign in", api.Forbidden(errDisabled))
+		return
+	}
+
+	rest := auth.WithoutSession(auth.ActiveSessions(user.Details.Sessions, time.Now()), challenge.ID)
+	token, session := auth.NewSession(user.ID, r, a.Config.SessionTTL, challenge.PasswordChange)
+	filter := databases.FilterUsers().ID(user.ID)
+	update := databases.UpdateUser().SetSessions(append(rest, session))
+
+	ok := false
+	if req.Code != "" {
+		var step int64
+		if step, ok = auth.VerifyTOTP(user.Details.TOTPSecret, req.Code, time.Now()); ok {
+			filter.TOTPStepBefore(step)
+			update.SetTOTPLastStep(step)
+		}
+	} else {
+		var remaining []string
+		if remaining, ok = auth.UseRecoveryCode(user.Details.RecoveryCodes, req.RecoveryCode); ok {
+			filter.RecoveryCode(auth.RecoveryCodeHash(req.RecoveryCode))
+			update.SetRecoveryCodes(remaining)
+		}
+	}
+
+	if ok {
+		result, err := a.DB.UpdateOne(ctx, filter, update)
+		if err != nil {
+			api.WriteError(w, r, "failed to save the session", err)
+			return
+		}
+		if result.MatchedCount == 1 {
+			a.signedIn(ctx, r, user, session)
+			writeResult(w, r, http.StatusOK, map[string]interface{}{"result": loginResponse(token, session, user)})
+			return
+		}
+	}
+
+	// drop the attempt, so codes can't be guessed with one password check, and count it
+	// like a wrong password
+	a.loginFailed(ctx, r, user)
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetSessions(rest)); err != nil {
+		api.WriteError(w, r, "failed to end the login", err)
+		return
+	}
+	api.WriteError(w, r, "failed to sign in", api.Unauthorized(errTwoFactorFailed))
+}
+
+// TwoFactorRecoveryCodes replaces the recovery codes of the signed in user, the old ones
+// stop working
+func (a Auth) TwoFactorRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	user, _ := api.UserFromContext(r.Context())
+	if !user.Details.TOTPEnabled {
+		api.WriteError(w, r, "two-factor authentication is not enabled, start with POST /api/v2/auth/2fa/enroll", errTwoFactorState)
+		return
+	}
+	step, ok := auth.VerifyTOTP(user.Details.TOTPSecret, req.Code, time.Now())
+	if !ok {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("code", "totp", errWrongCode))
+		return
+	}
+
+	codes, hashes := auth.NewRecoveryCodes()
+	update := databases.UpdateUser().SetTOTPLastStep(step).SetRecoveryCodes(hashes)
+	result, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID).TOTPStepBefore(step), update)
+	if err != nil {
+		api.WriteError(w, r, "failed to save the recovery codes", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("code", "totp", errWrongCode))
+		return
+	}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": models.RecoveryCodes{Codes: codes}})
+}
+
+// TwoFactorDisable turns two-factor authentication off for the signed in user, unless their
+// role requires it
+func (a Auth) TwoFactorDisable(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var req models.TwoFactorDisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+
+	user, _ := api.UserFromContext(r.Context())
+	if !user.Details.TOTPEnabled {
+		api.WriteError(w, r, "two-factor authentication is not enabled", errTwoFactorState)
+		return
+	}
+	if user.Details.UserType <= a.Config.TwoFactorRequired {
+		api.WriteError(w, r, "failed to disable two-factor authentication", api.Forbidden(errors.New("it is required for your role")))
+		return
+	}
+	if !user.ComparePasswords(req.Password) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("password", "password", errors.New("is wrong")))
+		return
+	}
+	if _, ok := auth.VerifyTOTP(user.Details.TOTPSecret, req.Code, time.Now()); !ok {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("code", "totp", errWrongCode))
+		return
+	}
+
+	update := databases.UpdateUser().SetTOTP("", false).SetRecoveryCodes(nil).SetUpdatedAt(time.Now().UTC())
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to disable two-factor authentication", err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminResetTwoFactor removes two-factor authentication from a user who lost their device,
+// and signs them out. Their next login enrolls again if their role requires it
+func (a Auth) AdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := idParam(r, "user_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid user ID", err)
+		return
+	}
+	user, err := a.DB.FindOne(ctx, databases.FilterUsers().ID(userID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get user by ID", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if !canManage(actor, user) {
+		api.WriteError(w, r, "failed to reset two-factor authentication", api.Forbidden(errors.New("you can only reset users below you in your business")))
+		return
+	}
+
+	update := databases.UpdateUser().
+		SetTOTP("", false).
+		SetRecoveryCodes(nil).
+		SetSessions(nil).
+		SetUpdatedAt(time.Now().UTC())
+	if _, err := a.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "failed to reset two-factor authentication", err)
+		return
+	}
+	a.Audit.userUpdate(ctx, r, a.DB, user)
+	w.WriteHeader(http.StatusNoContent)
+}
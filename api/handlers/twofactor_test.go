@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// twoFactorUser is a helper function turning on two-factor authentication for user,
+// returning the recovery codes
+func (a *testApp) twoFactorUser(user *models.User) []string {
+	a.t.Helper()
+	codes, hashes := auth.NewRecoveryCodes()
+	user.Details.TOTPSecret, user.Details.TOTPEnabled, user.Details.RecoveryCodes = auth.NewTOTPSecret(), true, hashes
+	update := databases.UpdateUser().SetTOTP(user.Details.TOTPSecret, true).SetRecoveryCodes(hashes)
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), update); err != nil {
+		a.t.Fatalf("failed to turn on two-factor authentication: %v", err)
+	}
+	return codes
+}
+
+// challenge is a helper function starting a login of user waiting for the two-factor code,
+// as the password step does, and returning its token
+func (a *testApp) challenge(user *models.User) string {
+	a.t.Helper()
+	ctx := context.Background()
+	found, err := a.store.Users().FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		a.t.Fatalf("failed to read user %s: %v", user.ID, err)
+	}
+	token, challenge := auth.NewSession(user.ID, httptest.NewRequest(http.MethodPost, "/", nil), auth.TwoFactorLoginTTL, false)
+	challenge.TwoFactorLogin = true
+	sessions := append(auth.ActiveSessions(found.Details.Sessions, time.Now()), challenge)
+	if _, err := a.store.Users().UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetSessions(sessions)); err != nil {
+		a.t.Fatalf("failed to start a login: %v", err)
+	}
+	return token
+}
+
+// staleUsers reads every user with the two-factor state they had when the test started, as
+// a request checking a code just before another uses it does
+type staleUsers struct {
+	databases.UserDatabase
+	details models.UserDetails
+}
+
+func (s staleUsers) FindOne(ctx context.Context, filter *databases.UserFilter) (*models.User, error) {
+	user, err := s.UserDatabase.FindOne(ctx, filter)
+	if user != nil {
+		user.Details.TOTPLastStep, user.Details.RecoveryCodes = s.details.TOTPLastStep, s.details.RecoveryCodes
+	}
+	return user, err
+}
+
+func TestTwoFactorVerify(t *testing.T) {
+	a := newTestApp(t)
+	user, _ := a.user(models.TypeUser, models.NewID())
+	codes := a.twoFactorUser(user)
+	verify := func(req models.TwoFactorVerifyRequest) int {
+		return a.do(http.MethodPost, "/api/v2/auth/2fa/verify", "", req).Code
+	}
+
+	code, err := auth.TOTPCode(user.Details.TOTPSecret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	if got := verify(models.TwoFactorVerifyRequest{Token: a.challenge(user), Code: code}); got != http.StatusOK {
+		t.Fatalf("verifying a code returned %d, want 200", got)
+	}
+	if got := verify(models.TwoFactorVerifyRequest{Token: a.challenge(user), Code: code}); got != http.StatusUnauthorized {
+		t.Errorf("verifying a used code returned %d, want 401", got)
+	}
+
+	if got := verify(models.TwoFactorVerifyRequest{Token: a.challenge(user), RecoveryCode: codes[0]}); got != http.StatusOK {
+		t.Fatalf("verifying a recovery code returned %d, want 200", got)
+	}
+	if got := verify(models.TwoFactorVerifyRequest{Token: a.challenge(user), RecoveryCode: codes[0]}); got != http.StatusUnauthorized {
+		t.Errorf("verifying a used recovery code returned %d, want 401", got)
+	}
+
+	// an Admin disabled them after the password step
+	token := a.challenge(user)
+	if _, err := a.store.Users().UpdateOne(context.Background(), databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetDisabled(true)); err != nil {
+		t.Fatalf("failed to disable the user: %v", err)
+	}
+	if got := verify(models.TwoFactorVerifyRequest{Token: token, RecoveryCode: codes[1]}); got != http.StatusForbidden {
+		t.Errorf("verifying for a disabled user returned %d, want 403", got)
+	}
+}
+
+func TestTwoFactorVerifyUsesACodeOnce(t *testing.T) {
+	a := newTestApp(t)
+	user, _ := a.user(models.TypeUser, models.NewID())
+	codes := a.twoFactorUser(user)
+
+	stale := Auth{DB: staleUsers{a.store.Users(), user.Details}, Config: &a.Config, Addresses: auth.NewIPThrottle(auth.AddressThrottle(0, 0))}
+	verify := func(req models.TwoFactorVerifyRequest) int {
+		b, _ := json.Marshal(req)
+		rec := httptest.NewRecorder()
+		stale.TwoFactorVerify(rec, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b)))
+		return rec.Code
+	}
+
+	code, err := auth.TOTPCode(user.Details.TOTPSecret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		t.Fatalf("TOTPCode: %v", err)
+	}
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		if got := verify(models.TwoFactorVerifyRequest{Token: a.challenge(user), Code: code}); got != want {
+			t.Errorf("verifying a code read before it was used #%d returned %d, want %d", i+1, got, want)
+		}
+	}
+	for i, want := range []int{http.StatusOK, http.StatusUnauthorized} {
+		if got := verify(models.TwoFactorVerifyRequest{Token: a.challenge(user), RecoveryCode: codes[0]}); got != want {
+			t.Errorf("verifying a recovery code read before it was used #%d returned %d, want %d", i+1, got, want)
+		}
+	}
+}
@@ -3,82 +3,351 @@ package handlers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"time"
 
-	"github.com/gorilla/mux"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.uber.org/zap"
 
-	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
 )
 
+// userTypes are the names of the user types in ?type
+var userTypes = map[string]int{
+	"superuser": models.TypeSuperUser,
+	"admin":     models.TypeAdmin,
+	"user":      models.TypeUser,
+}
+
+// errNoBusiness is returned to Admins that don't belong to a business, they have no users
+var errNoBusiness = api.Forbidden(errors.New("you don't belong to a business"))
+
+// User lets admins manage accounts following the role rules in models/user.go. Admins see
+// the users of their own business and manage its Users, SuperUsers manage every Admin and
+// User. Users are deactivated rather than deleted, so their bookings keep an author
 type User struct {
-	DB databases.UserDatabase
+	DB     databases.UserDatabase
+	Mailer mailer.Mailer
+	Audit  Auditor
 }
 
-// CowByIDHandler returns a cow by ID
-func (u User) UserByObjectIDHandler(w http.ResponseWriter, r *http.Request) {
-	userID := mux.Vars(r)["user_object_id"]
+// ListUsers returns a page of users, filtered by ?type, ?email and ?disabled=true.
+// SuperUsers can filter by ?business
+func (u User) ListUsers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
 
-	cID, err := primitive.ObjectIDFromHex(userID)
+	p, err := pageParams(r)
 	if err != nil {
-		config.ErrorStatus("failed to get objectID from Hex", http.StatusBadRequest, w, err)
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	business, err := optionalIDParam(r, "business")
+	if err != nil {
+		api.WriteError(w, r, "invalid business ID", err)
+		return
+	}
+
+	filter := databases.FilterUsers().Limit(p.Limit).Skip(p.Offset)
+	switch actor, _ := api.UserFromContext(r.Context()); {
+	case actor.Details.UserType != models.TypeSuperUser && actor.Details.Business.IsZero():
+		api.WriteError(w, r, "failed to get users", errNoBusiness)
+		return
+	case actor.Details.UserType != models.TypeSuperUser:
+		filter.Business(actor.Details.Business)
+	case !business.IsZero():
+		filter.Business(business)
+	}
+	query := r.URL.Query()
+	if name := query.Get("type"); name != "" {
+		t, ok := userTypes[name]
+		if !ok {
+			api.WriteError(w, r, "invalid user type", api.InvalidParameter(errors.New("type must be superuser, admin or user")))
+			return
+		}
+		filter.UserType(t)
+	}
+	if email := query.Get("email"); email != "" {
+		filter.Email(email)
+	}
+	switch query.Get("disabled") {
+	case "":
+	case "true":
+		filter.Disabled()
+	default:
+		api.WriteError(w, r, "invalid disabled filter", api.InvalidParameter(errors.New("disabled can only be true")))
+		return
+	}
+
+	users, err := u.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get users", err)
+		return
+	}
+	if users == nil {
+		users = []models.User{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": users, "page": p})
+}
+
+// CreateUser creates a user and returns it with its location. Without a password the user
+// gets a temporary one, emailed or returned when it can't be
+func (u User) CreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	var req models.UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
 		return
 	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	if req.Password != "" {
+		if err := auth.ValidatePassword(req.Password); err != nil {
+			api.WriteError(w, r, "invalid request body", api.InvalidField("password", "password", err))
+			return
+		}
+	}
 
-	dbResp, err := u.DB.FindOne(context.Background(), bson.M{"_id": cID})
+	actor, _ := api.UserFromContext(r.Context())
+	business, err := newMemberBusiness(actor, req.UserType, req.Business)
 	if err != nil {
-		config.ErrorStatus("failed to get cow by ID", http.StatusNotFound, w, err)
+		api.WriteError(w, r, "failed to create the user", err)
 		return
 	}
+	req.Business = business
 
-	b, err := json.Marshal(models.UserResponse{Status: http.StatusOK, Message: "success", Data: map[string]interface{}{"result": dbResp}})
+	password, temp := req.Password, req.Password == ""
+	if temp {
+		password = auth.TemporaryPassword()
+	}
+	user := util.NewUser(u.DB, models.UserDetails{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Business:  req.Business,
+		UserType:  req.UserType,
+	}, password, temp)
+	if _, err := u.DB.InsertOne(ctx, user); err != nil {
+		api.WriteError(w, r, "failed to save the user", err)
+		return
+	}
+	zap.S().Infow("created a user", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "uid", user.Details.UID, "business", user.Details.Business, "usertype", user.Details.UserType)
+	u.Audit.user(r, models.AuditCreate, nil, &user)
+
+	result := models.CreatedUser{User: user}
+	if temp {
+		result.Emailed = true
+		if err := u.Mailer.Send(ctx, auth.WelcomeEmail(&user, password)); err != nil {
+			if !errors.Is(err, mailer.ErrNotConfigured) {
+				zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to email a temporary password", "uid", user.Details.UID)
+			}
+			result.Emailed, result.TemporaryPassword = false, password
+		}
+	}
+
+	w.Header().Set("Location", "/api/v2/users/"+user.ID.String())
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": result})
+}
+
+// GetUser returns a user by ID
+func (u User) GetUser(w http.ResponseWriter, r *http.Request) {
+	user, err := u.find(r)
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "user not found", err)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write(b)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": user})
 }
 
-// TODO: Handle User.Details.UserType properly i.e only SuperUser
-// NewUserHandler inserts a new cow into the collection and returns a result and error
-func (u User) NewUserHandler(w http.ResponseWriter, r *http.Request) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	var userDetails models.UserDetails // Json data will represent the user details model
+// PatchUser changes the names, email or business of a user and returns it
+func (u User) PatchUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	// validate the request body
-	if err := json.NewDecoder(r.Body).Decode(&userDetails); err != nil {
-		config.ErrorStatus("failed to unpack request body", http.StatusInternalServerError, w, err)
+	var req models.UserUpdateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	user, err := u.managed(r)
+	if err != nil {
+		api.WriteError(w, r, "the user could not be updated", err)
+		return
+	}
+
+	update := databases.UpdateUser()
+	if req.FirstName != "" {
+		update.SetFirstName(req.FirstName)
+	}
+	if req.LastName != "" {
+		update.SetLastName(req.LastName)
+	}
+	if req.Email != "" && req.Email != user.Details.Email {
+		email, ok := util.ValidMailAddress(req.Email)
+		if !ok {
+			api.WriteError(w, r, "invalid request body", api.InvalidField("email", "email", errors.New("must be a valid email address")))
+			return
+		}
+		// the provider would change it back, and its users sign in with it there
+		if user.Details.AuthProvider != "" {
+			api.WriteError(w, r, "invalid request body", api.InvalidField("email", "provider", errors.New("is managed by "+user.Details.AuthProvider)))
+			return
+		}
+		update.SetEmail(email)
+	}
+	if !req.Business.IsZero() && req.Business != user.Details.Business {
+		if actor, _ := api.UserFromContext(r.Context()); actor.Details.UserType != models.TypeSuperUser {
+			api.WriteError(w, r, "the user could not be updated", api.Forbidden(errors.New("only SuperUsers can move users to another business")))
+			return
+		}
+		update.SetBusiness(req.Business)
+	}
+	if update.Empty() {
+		api.WriteError(w, r, "nothing to update", databases.ErrEmptyUpdate)
 		return
 	}
 
-	// use the validator library to validate required fields
-	if validationErr := validate.Struct(&userDetails); validationErr != nil {
-		config.ErrorStatus("invalid request body", http.StatusBadRequest, w, validationErr)
+	if _, err := u.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update.SetUpdatedAt(time.Now().UTC())); err != nil {
+		api.WriteError(w, r, "the user could not be updated", err)
 		return
 	}
+	u.Audit.userUpdate(ctx, r, u.DB, user)
+	u.writeUser(ctx, w, r, user.ID)
+}
+
+// SetUserRole promotes or demotes a user. Admins promote Users of their business to Admins,
+// SuperUsers change any Admin or User
+func (u User) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
 
-	newUser := models.User{
-		ID:      primitive.NewObjectID().Hex(),
-		Details: userDetails,
+	var req models.RoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
 	}
+	if validationErr := validate.Struct(&req); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	user, err := u.managed(r)
+	if err != nil {
+		api.WriteError(w, r, "the role could not be changed", err)
+		return
+	}
+
+	if req.UserType != user.Details.UserType {
+		update := databases.UpdateUser().SetUserType(req.UserType).SetUpdatedAt(time.Now().UTC())
+		if _, err := u.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+			api.WriteError(w, r, "the role could not be changed", err)
+			return
+		}
+		zap.S().Infow("changed the role of a user", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "uid", user.Details.UID, "from", user.Details.UserType, "to", req.UserType)
+		u.Audit.userUpdate(ctx, r, u.DB, user)
+	}
+	u.writeUser(ctx, w, r, user.ID)
+}
+
+// DeactivateUser stops a user signing in and signs them out. Their bookings are kept
+func (u User) DeactivateUser(w http.ResponseWriter, r *http.Request) {
+	u.setDisabled(w, r, true)
+}
+
+// ReactivateUser lets a deactivated user sign in again
+func (u User) ReactivateUser(w http.ResponseWriter, r *http.Request) {
+	u.setDisabled(w, r, false)
+}
+
+// setDisabled is a helper function deactivating or reactivating the user of the request
+func (u User) setDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
 
-	result, err := u.DB.InsertOne(ctx, newUser)
+	user, err := u.managed(r)
 	if err != nil {
-		config.ErrorStatus("failed to insert user", http.StatusBadRequest, w, err)
+		api.WriteError(w, r, "the user could not be changed", err)
 		return
 	}
 
-	b, err := json.Marshal(models.UserResponse{Status: http.StatusCreated, Message: "success", Data: map[string]interface{}{"result": result}})
+	update := databases.UpdateUser().SetDisabled(disabled).SetUpdatedAt(time.Now().UTC())
+	if disabled {
+		update.SetSessions(nil)
+	}
+	if _, err := u.DB.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		api.WriteError(w, r, "the user could not be changed", err)
+		return
+	}
+	zap.S().Infow("changed whether a user can sign in", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "uid", user.Details.UID, "disabled", disabled)
+	u.Audit.userUpdate(ctx, r, u.DB, user)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// newMemberBusiness is a helper function returning the business of a new user of userType
+// that actor creates or invites. SuperUsers name any business, Admins only add Users to
+// their own, business defaults to it
+func newMemberBusiness(actor *models.User, userType int, business models.ID) (models.ID, error) {
+	switch {
+	case actor.Details.UserType == models.TypeSuperUser && business.IsZero():
+		return "", api.InvalidField("business", "required", errors.New("is required"))
+	case actor.Details.UserType == models.TypeSuperUser:
+		return business, nil
+	case actor.Details.Business.IsZero():
+		return "", errNoBusiness
+	case userType != models.TypeUser:
+		return "", api.Forbidden(errors.New("admins can only add Users, promote them afterwards"))
+	case !business.IsZero() && business != actor.Details.Business:
+		return "", api.Forbidden(errors.New("admins can only add users to their own business"))
+	}
+	return actor.Details.Business, nil
+}
+
+// find is a helper function loading the user of the request. Admins only find the users of
+// their own business, others look like they don't exist
+func (u User) find(r *http.Request) (*models.User, error) {
+	userID, err := idParam(r, "user_id")
+	if err != nil {
+		return nil, err
+	}
+	filter := databases.FilterUsers().ID(userID)
+	if actor, _ := api.UserFromContext(r.Context()); actor.Details.UserType != models.TypeSuperUser {
+		if actor.Details.Business.IsZero() {
+			return nil, errNoBusiness
+		}
+		filter.Business(actor.Details.Business)
+	}
+	return u.DB.FindOne(r.Context(), filter)
+}
+
+// managed is a helper function loading the user of the request, refusing users the actor
+// can't manage
+func (u User) managed(r *http.Request) (*models.User, error) {
+	user, err := u.find(r)
+	if err != nil {
+		return nil, err
+	}
+	if actor, _ := api.UserFromContext(r.Context()); !canManage(actor, user) {
+		return nil, api.Forbidden(errors.New("you can only change users below you in your business"))
+	}
+	return user, nil
+}
+
+// writeUser is a helper function answering with the current state of a user
+func (u User) writeUser(ctx context.Context, w http.ResponseWriter, r *http.Request, id models.ID) {
+	user, err := u.DB.FindOne(ctx, databases.FilterUsers().ID(id))
 	if err != nil {
-		config.ErrorStatus("failed to marshal response", http.StatusInternalServerError, w, err)
+		api.WriteError(w, r, "failed to get user by ID", err)
 		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	w.Write(b)
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": user})
 }
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+func TestCanManage(t *testing.T) {
+	business, other := models.NewID(), models.NewID()
+	member := func(userType int, business models.ID) *models.User {
+		return &models.User{ID: models.NewID(), Details: models.UserDetails{UserType: userType, Business: business}}
+	}
+	super, admin, user := member(models.TypeSuperUser, ""), member(models.TypeAdmin, business), member(models.TypeUser, business)
+
+	for _, tt := range []struct {
+		name        string
+		actor, user *models.User
+		want        bool
+	}{
+		{"a SuperUser manages Admins", super, admin, true},
+		{"a SuperUser manages Users of any business", super, member(models.TypeUser, other), true},
+		{"a SuperUser doesn't manage SuperUsers", super, member(models.TypeSuperUser, ""), false},
+		{"an Admin manages Users of their business", admin, user, true},
+		{"an Admin doesn't manage Users of another business", admin, member(models.TypeUser, other), false},
+		{"an Admin doesn't manage Admins", admin, member(models.TypeAdmin, business), false},
+		{"an Admin doesn't manage themselves", admin, admin, false},
+		{"a User doesn't manage Users", user, member(models.TypeUser, business), false},
+	} {
+		if got := canManage(tt.actor, tt.user); got != tt.want {
+			t.Errorf("%s: canManage = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestUserManagement(t *testing.T) {
+	a := newTestApp(t)
+	business, other := models.NewID(), models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	_, adminToken := a.user(models.TypeAdmin, business)
+	user, userToken := a.user(models.TypeUser, business)
+	stranger, _ := a.user(models.TypeUser, other)
+	path := "/api/v2/users/" + user.ID.String()
+
+	// Admins only see their own business
+	rec := a.do(http.MethodGet, "/api/v2/users", adminToken, nil)
+	expect(t, rec, http.StatusOK, "an Admin listing users")
+	if users := result[[]models.User](t, rec); len(users) != 2 {
+		t.Errorf("an Admin lists %d users, want the 2 of their business", len(users))
+	}
+	rec = a.do(http.MethodGet, "/api/v2/users?business="+other.String(), super, nil)
+	expect(t, rec, http.StatusOK, "a SuperUser listing the users of a business")
+	if users := result[[]models.User](t, rec); len(users) != 1 || users[0].ID != stranger.ID {
+		t.Errorf("a SuperUser lists %+v, want the user of the business", users)
+	}
+	expect(t, a.do(http.MethodGet, "/api/v2/users", userToken, nil), http.StatusForbidden, "a User listing users")
+	expect(t, a.do(http.MethodGet, "/api/v2/users/"+stranger.ID.String(), adminToken, nil), http.StatusNotFound, "an Admin reading a user of another business")
+	expect(t, a.do(http.MethodGet, "/api/v2/users?type=owner", adminToken, nil), http.StatusBadRequest, "listing users of an unknown type")
+
+	// Admins add Users to their business, SuperUsers anyone anywhere
+	newUser := func(userType int, business models.ID) models.UserRequest {
+		return models.UserRequest{FirstName: "Grace", LastName: "Hopper", Email: "grace@school.example", UserType: userType, Business: business}
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/users", adminToken, newUser(models.TypeAdmin, "")), http.StatusForbidden, "an Admin adding an Admin")
+	expect(t, a.do(http.MethodPost, "/api/v2/users", adminToken, newUser(models.TypeUser, other)), http.StatusForbidden, "an Admin adding a User to another business")
+	rec = a.do(http.MethodPost, "/api/v2/users", super, newUser(models.TypeUser, ""))
+	expect(t, rec, http.StatusBadRequest, "a SuperUser adding a user without a business")
+	if got := errorOf(t, rec); len(got.Fields) != 1 || got.Fields[0].Field != "business" {
+		t.Errorf("a user without a business answered %+v, want a business field error", got)
+	}
+	rec = a.do(http.MethodPost, "/api/v2/users", adminToken, newUser(models.TypeUser, ""))
+	expect(t, rec, http.StatusCreated, "an Admin adding a User")
+	created := result[models.CreatedUser](t, rec)
+	if created.Details.Business != business || !created.Emailed || created.TemporaryPassword != "" || !created.Details.TempPassword {
+		t.Errorf("an Admin added %+v, want a User of their business with an emailed temporary password", created)
+	}
+	if sent := a.sent.to(created.Details.Email); len(sent) != 1 {
+		t.Errorf("the new user was sent %d emails, want the temporary password", len(sent))
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/users", adminToken, newUser(models.TypeUser, "")), http.StatusConflict, "adding a user with the same email")
+
+	// only SuperUsers move users to another business
+	expect(t, a.do(http.MethodPatch, path, adminToken, models.UserUpdateRequest{Business: other}), http.StatusForbidden, "an Admin moving a user")
+	expect(t, a.do(http.MethodPatch, path, adminToken, models.UserUpdateRequest{Email: "not an email"}), http.StatusBadRequest, "changing the email to an invalid one")
+	expect(t, a.do(http.MethodPatch, path, adminToken, models.UserUpdateRequest{}), http.StatusBadRequest, "changing nothing")
+	rec = a.do(http.MethodPatch, path, adminToken, models.UserUpdateRequest{LastName: "Lovelace"})
+	expect(t, rec, http.StatusOK, "an Admin renaming a User")
+	if got := result[models.User](t, rec); got.Details.LastName != "Lovelace" || got.Details.FirstName != user.Details.FirstName {
+		t.Errorf("the renamed user is %s %s", got.Details.FirstName, got.Details.LastName)
+	}
+	expect(t, a.do(http.MethodPatch, "/api/v2/users/"+stranger.ID.String(), super, models.UserUpdateRequest{Business: business}), http.StatusOK, "a SuperUser moving a user")
+	if found := a.findUser(stranger.ID); found.Details.Business != business {
+		t.Errorf("the moved user belongs to %s, want %s", found.Details.Business, business)
+	}
+}
+
+func TestSetUserRole(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	admin, adminToken := a.user(models.TypeAdmin, business)
+	user, _ := a.user(models.TypeUser, business)
+	role := func(user *models.User) string { return "/api/v2/users/" + user.ID.String() + "/role" }
+
+	rec := a.do(http.MethodPut, role(user), adminToken, models.RoleRequest{UserType: models.TypeSuperUser})
+	expect(t, rec, http.StatusBadRequest, "promoting a user to SuperUser")
+	if got := errorOf(t, rec).Code; got != api.CodeValidation {
+		t.Errorf("promoting a user to SuperUser answered %s, want %s", got, api.CodeValidation)
+	}
+	expect(t, a.do(http.MethodPut, role(admin), adminToken, models.RoleRequest{UserType: models.TypeUser}), http.StatusForbidden, "an Admin demoting themselves")
+
+	rec = a.do(http.MethodPut, role(user), adminToken, models.RoleRequest{UserType: models.TypeAdmin})
+	expect(t, rec, http.StatusOK, "an Admin promoting a User")
+	if got := result[models.User](t, rec); got.Details.UserType != models.TypeAdmin {
+		t.Errorf("the promoted user has type %d, want %d", got.Details.UserType, models.TypeAdmin)
+	}
+	// now an Admin, only a SuperUser can demote them
+	expect(t, a.do(http.MethodPut, role(user), adminToken, models.RoleRequest{UserType: models.TypeUser}), http.StatusForbidden, "an Admin demoting an Admin")
+	expect(t, a.do(http.MethodPut, role(user), super, models.RoleRequest{UserType: models.TypeUser}), http.StatusOK, "a SuperUser demoting an Admin")
+	if found := a.findUser(user.ID); found.Details.UserType != models.TypeUser {
+		t.Errorf("the demoted user has type %d, want %d", found.Details.UserType, models.TypeUser)
+	}
+}
+
+func TestDeactivateUser(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, adminToken := a.user(models.TypeAdmin, business)
+	otherAdmin, _ := a.user(models.TypeAdmin, business)
+	user, userToken := a.user(models.TypeUser, business)
+	a.withPassword(user)
+	path := "/api/v2/users/" + user.ID.String()
+
+	expect(t, a.do(http.MethodPost, "/api/v2/users/"+otherAdmin.ID.String()+"/deactivate", adminToken, nil), http.StatusForbidden, "an Admin deactivating an Admin")
+	expect(t, a.do(http.MethodPost, path+"/deactivate", userToken, nil), http.StatusForbidden, "a User deactivating themselves")
+	expect(t, a.do(http.MethodPost, path+"/deactivate", adminToken, nil), http.StatusNoContent, "an Admin deactivating a User")
+
+	// they are signed out and can't sign in, but are still listed
+	expect(t, a.do(http.MethodGet, "/api/v2/auth/me", userToken, nil), http.StatusUnauthorized, "using a session of a deactivated user")
+	if rec, _ := a.signIn(user, testPassword); rec.Code == http.StatusOK {
+		t.Error("a deactivated user signed in")
+	}
+	rec := a.do(http.MethodGet, "/api/v2/users?disabled=true", adminToken, nil)
+	expect(t, rec, http.StatusOK, "listing deactivated users")
+	if users := result[[]models.User](t, rec); len(users) != 1 || users[0].ID != user.ID {
+		t.Errorf("the deactivated users are %+v, want the user", users)
+	}
+	expect(t, a.do(http.MethodGet, "/api/v2/users?disabled=false", adminToken, nil), http.StatusBadRequest, "listing with disabled=false")
+
+	expect(t, a.do(http.MethodPost, path+"/reactivate", adminToken, nil), http.StatusNoContent, "an Admin reactivating a User")
+	if rec, _ := a.signIn(user, testPassword); rec.Code != http.StatusOK {
+		t.Errorf("signing in after being reactivated returned %d", rec.Code)
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const (
+	defaultPageLimit = 50  // page size when a list request does not set ?limit
+	maxPageLimit     = 500 // largest page a client can ask for
+)
+
+// pageParams is a helper function reading ?limit and ?offset from a list request
+func pageParams(r *http.Request) (models.Page, error) {
+	p := models.Page{Limit: defaultPageLimit}
+
+	if value := r.URL.Query().Get("limit"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return p, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		p.Limit = limit
+	}
+	if value := r.URL.Query().Get("offset"); value != "" {
+		offset, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || offset < 0 {
+			return p, fmt.Errorf("offset must be a positive number")
+		}
+		p.Offset = offset
+	}
+	return p, nil
+}
+
+// optionalIDParam is a helper function parsing the query parameter name as an ID, if it is set
+func optionalIDParam(r *http.Request, name string) (models.ID, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return "", nil
+	}
+	return models.ParseID(value)
+}
+
+// writeResult is a helper function writing data in the standard response envelope
+func writeResult(w http.ResponseWriter, r *http.Request, status int, data map[string]interface{}) {
+	b, err := json.Marshal(models.UserResponse{Status: status, Message: "success", Data: data})
+	if err != nil {
+		api.WriteError(w, r, "failed to marshal response", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+// scopedCows is a helper function starting a cow filter that only matches the cows of the
+// key's business for requests made with an API key
+func scopedCows(r *http.Request) *databases.CowFilter {
+	filter := databases.FilterCows()
+	if key := api.KeyFromContext(r.Context()); key != nil {
+		filter.Business(key.Details.Business)
+	}
+	return filter
+}
+
+// adminBusiness is a helper function returning the business an Admin or SuperUser manages
+// with a request naming business. Admins manage their own, SuperUsers must name one
+func adminBusiness(actor *models.User, business models.ID) (models.ID, error) {
+	switch {
+	case actor.Details.UserType == models.TypeSuperUser && business.IsZero():
+		return "", api.InvalidField("business", "required", errors.New("is required for SuperUsers"))
+	case actor.Details.UserType == models.TypeSuperUser:
+		return business, nil
+	case actor.Details.Business.IsZero():
+		return "", errNoBusiness
+	case !business.IsZero() && business != actor.Details.Business:
+		return "", api.Forbidden(errors.New("Admins can only manage their own business"))
+	}
+	return actor.Details.Business, nil
+}
+
+// checkBusiness is a helper function refusing changes to the cows, devices and bookings of
+// another business than the API key's or the signed in user's. SuperUsers change every business
+func checkBusiness(r *http.Request, business models.ID) error {
+	if api.KeyFromContext(r.Context()) != nil {
+		return checkKeyBusiness(r, business)
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	switch {
+	case actor == nil:
+		return api.Unauthorized(errors.New("sign in to change cows, devices and bookings"))
+	case actor.Details.UserType == models.TypeSuperUser:
+		return nil
+	case actor.Details.Business.IsZero():
+		return errNoBusiness
+	case business != actor.Details.Business:
+		return api.Forbidden(errors.New("you can only change the cows of your own business"))
+	}
+	return nil
+}
+
+// checkManage is a helper function refusing changes to the cows and devices of a business
+// by anyone but its Admins, API keys of the business and SuperUsers, see checkBusiness.
+// Users of the business only book
+func checkManage(r *http.Request, business models.ID) error {
+	if err := checkBusiness(r, business); err != nil {
+		return err
+	}
+	if actor, _ := api.UserFromContext(r.Context()); actor != nil && actor.Details.UserType > models.TypeAdmin {
+		return errNotManager
+	}
+	return nil
+}
+
+// ownCowBusiness is a helper function defaulting the business of a new cow to the API key's
+// or the signed in user's. SuperUsers name it
+func ownCowBusiness(r *http.Request, business models.ID) models.ID {
+	if !business.IsZero() {
+		return business
+	}
+	if key := api.KeyFromContext(r.Context()); key != nil {
+		return key.Details.Business
+	}
+	if actor, _ := api.UserFromContext(r.Context()); actor != nil && actor.Details.UserType != models.TypeSuperUser {
+		return actor.Details.Business
+	}
+	return business
+}
+
+// errNotManager is returned to Users changing the cows or devices of their business
+var errNotManager = api.Forbidden(errors.New("only Admins of the business can change its cows and devices"))
+
+// checkKeyBusiness is a helper function refusing requests made with an API key that name
+// another business than the key's
+func checkKeyBusiness(r *http.Request, business models.ID) error {
+	if key := api.KeyFromContext(r.Context()); key != nil && business != key.Details.Business {
+		return api.Forbidden(errors.New("API keys can only use the cows of their own business"))
+	}
+	return nil
+}
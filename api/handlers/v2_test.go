@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// apiKey is a helper function adding an API key of business with scopes and returning it
+func (a *testApp) apiKey(business models.ID, scopes ...string) string {
+	a.t.Helper()
+	token, prefix, hash := auth.NewAPIKey()
+	key := models.APIKey{ID: models.NewID(), Details: models.APIKeyDetails{
+		Name:      "Library signage",
+		Business:  business,
+		Prefix:    prefix,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedAt: time.Now().UTC(),
+	}}
+	if _, err := a.store.APIKeys().InsertOne(context.Background(), key); err != nil {
+		a.t.Fatalf("failed to add an API key: %v", err)
+	}
+	return token
+}
+
+func TestListPaging(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, teacher := a.user(models.TypeUser, business)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, a.cow(business).ID.String())
+	}
+	sort.Strings(ids)
+	list := func(query string) []models.Cow {
+		rec := a.do(http.MethodGet, "/api/v2/cows?business="+business.String()+query, teacher, nil)
+		expect(t, rec, http.StatusOK, "listing cows"+query)
+		return result[[]models.Cow](t, rec)
+	}
+
+	// pages are ordered by ID, so they don't overlap
+	first, second := list("&limit=2"), list("&limit=2&offset=2")
+	if len(first) != 2 || len(second) != 1 {
+		t.Fatalf("the pages have %d and %d cows, want 2 and 1", len(first), len(second))
+	}
+	if got := []string{first[0].ID.String(), first[1].ID.String(), second[0].ID.String()}; !reflect.DeepEqual(got, ids) {
+		t.Errorf("the pages list %v, want %v", got, ids)
+	}
+	if got := list("&offset=3"); len(got) != 0 {
+		t.Errorf("a page past the end lists %d cows, want none", len(got))
+	}
+	if got := list("&collection=iPad"); len(got) != 0 {
+		t.Errorf("filtering on another collection lists %d cows, want none", len(got))
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=501", "?limit=ten", "?offset=-1", "?business=cart"} {
+		rec := a.do(http.MethodGet, "/api/v2/cows"+query, teacher, nil)
+		expect(t, rec, http.StatusBadRequest, "listing cows with "+query)
+		if got := errorOf(t, rec).Code; got != api.CodeInvalidParameter {
+			t.Errorf("listing cows with %s answered %s, want %s", query, got, api.CodeInvalidParameter)
+		}
+	}
+}
+
+func TestV2Routes(t *testing.T) {
+	a := newTestApp(t)
+	business := models.NewID()
+	_, admin := a.user(models.TypeAdmin, business)
+
+	rec := a.do(http.MethodPost, "/api/v2/cows", admin, models.CowDetails{Name: "CA-1", Collection: "Laptop", DeviceTotal: 30})
+	expect(t, rec, http.StatusCreated, "creating a cow")
+	created := result[models.Cow](t, rec)
+	location := rec.Header().Get("Location")
+	if location != "/api/v2/cows/"+created.ID.String() {
+		t.Fatalf("the new cow is at %q, want /api/v2/cows/%s", location, created.ID)
+	}
+	if created.Details.Business != business {
+		t.Errorf("an Admin created a cow of business %s, want their own", created.Details.Business)
+	}
+	expect(t, a.do(http.MethodGet, location, "", nil), http.StatusOK, "reading the new cow")
+
+	for _, tt := range []struct {
+		method, path, token string
+		status              int
+		code                string
+	}{
+		{http.MethodGet, "/api/v2/cows/" + models.NewID().String(), "", http.StatusNotFound, api.CodeNotFound},
+		{http.MethodGet, "/api/v2/cows/cart", "", http.StatusBadRequest, api.CodeInvalidParameter},
+		{http.MethodGet, "/api/v2/carts", "", http.StatusNotFound, api.CodeNotFound},
+		{http.MethodPut, location, admin, http.StatusMethodNotAllowed, api.CodeMethodNotAllowed},
+		{http.MethodPost, "/api/v2/cows", "", http.StatusUnauthorized, api.CodeUnauthorized},
+		{http.MethodDelete, location, "", http.StatusUnauthorized, api.CodeUnauthorized},
+	} {
+		rec := a.do(tt.method, tt.path, tt.token, nil)
+		expect(t, rec, tt.status, tt.method+" "+tt.path)
+		if got := errorOf(t, rec).Code; got != tt.code {
+			t.Errorf("%s %s answered %s, want %s", tt.method, tt.path, got, tt.code)
+		}
+	}
+
+	expect(t, a.do(http.MethodDelete, location, admin, nil), http.StatusNoContent, "deleting the cow")
+	expect(t, a.do(http.MethodGet, location, "", nil), http.StatusNotFound, "reading a deleted cow")
+
+	// v1 still answers, pointing clients to v2
+	rec = a.do(http.MethodGet, "/api/v1/cows", "", nil)
+	expect(t, rec, http.StatusOK, "listing cows with v1")
+	if rec.Header().Get("Deprecation") != "true" || rec.Header().Get("Link") != `</api/v2>; rel="successor-version"` {
+		t.Errorf("v1 answered with Deprecation %q and Link %q", rec.Header().Get("Deprecation"), rec.Header().Get("Link"))
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	a := newTestApp(t)
+	business, other := models.NewID(), models.NewID()
+	own, theirs := a.cow(business), a.cow(other)
+	reader := a.apiKey(business, auth.ScopeCowsRead)
+	writer := a.apiKey(business, auth.ScopeCowsRead, auth.ScopeCowsWrite, auth.ScopeBookingsWrite)
+
+	// a key only sees the cows of its business
+	rec := a.do(http.MethodGet, "/api/v2/cows", reader, nil)
+	expect(t, rec, http.StatusOK, "listing cows with a key")
+	if cows := result[[]models.Cow](t, rec); len(cows) != 1 || cows[0].ID != own.ID {
+		t.Errorf("a key lists %+v, want only the cow of its business", cows)
+	}
+	expect(t, a.do(http.MethodGet, "/api/v2/cows/"+theirs.ID.String(), reader, nil), http.StatusNotFound, "reading a cow of another business with a key")
+	expect(t, a.do(http.MethodGet, "/api/v2/cows?business="+other.String(), reader, nil), http.StatusForbidden, "listing the cows of another business with a key")
+
+	// and only reaches the routes of its scopes
+	expect(t, a.do(http.MethodGet, "/api/v2/cows/"+own.ID.String()+"/bookings", reader, nil), http.StatusForbidden, "reading bookings without bookings:read")
+	expect(t, a.do(http.MethodPatch, "/api/v2/cows/"+own.ID.String(), reader, models.CowDetails{Collection: "iPad"}), http.StatusForbidden, "changing a cow without cows:write")
+	expect(t, a.do(http.MethodPatch, "/api/v2/cows/"+own.ID.String(), writer, models.CowDetails{Collection: "iPad"}), http.StatusOK, "changing a cow with cows:write")
+	expect(t, a.do(http.MethodPatch, "/api/v2/cows/"+theirs.ID.String(), writer, models.CowDetails{Collection: "iPad"}), http.StatusNotFound, "changing a cow of another business with a key")
+	expect(t, a.do(http.MethodPost, "/api/v2/cows", writer, models.CowDetails{Name: "CA-2", Business: other}), http.StatusForbidden, "creating a cow for another business with a key")
+	expect(t, a.do(http.MethodPost, "/api/v2/cows/"+own.ID.String()+"/bookings", writer, bookingOn(3, "1")), http.StatusCreated, "booking with bookings:write")
+
+	// keys can't use v1, which doesn't check scopes
+	expect(t, a.do(http.MethodGet, "/api/v1/cows", reader, nil), http.StatusForbidden, "listing cows with a key on v1")
+	expect(t, a.do(http.MethodGet, "/api/v2/cows", "dbk_00000000_wrong", nil), http.StatusUnauthorized, "listing cows with an unknown key")
+}
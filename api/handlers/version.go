@@ -0,0 +1,405 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// errParentGone is returned when reverting a device to a cow that no longer exists
+var errParentGone = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the cow of that version no longer exists")}
+
+// ListCowVersions returns a page of the versions of a cow, oldest first. Admins only see
+// the cows of their business
+func (c Cow) ListCowVersions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cow, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	listVersions(ctx, w, r, c.Versions, models.AuditCow, cow.ID)
+}
+
+// GetCowVersion returns how a cow looked at a version
+func (c Cow) GetCowVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cow, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	getVersion(ctx, w, r, c.Versions, models.AuditCow, cow.ID)
+}
+
+// DiffCowVersions lists the changes to a cow between ?from and ?to. to defaults to the
+// latest version and from to the one before to
+func (c Cow) DiffCowVersions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cow, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	diffVersions(ctx, w, r, c.Versions, models.AuditCow, cow.ID)
+}
+
+// RevertCow sets the name, business, collection, device total and devices of a cow back to
+// those of a version, and returns the cow. Bookings made since are kept. The revert is
+// itself a new version
+func (c Cow) RevertCow(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	before, err := c.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	version, err := findVersion(ctx, r, c.Versions, models.AuditCow, before.ID)
+	if err != nil {
+		api.WriteError(w, r, "version not found", err)
+		return
+	}
+	var old models.Cow
+	if err := json.Unmarshal(version.Details.Snapshot, &old); err != nil {
+		api.WriteError(w, r, "failed to read the version", err)
+		return
+	}
+	if old.Details.Business != before.Details.Business && !ownBusiness(r, old.Details.Business) {
+		api.WriteError(w, r, "the cow could not be reverted", api.Forbidden(errors.New("the version belongs to another business")))
+		return
+	}
+	if old.Details.Devices == nil {
+		old.Details.Devices = []models.ID{}
+	}
+
+	update := databases.UpdateCow().
+		SetName(old.Details.Name).
+		SetBusiness(old.Details.Business).
+		SetCollection(old.Details.Collection).
+		SetDeviceTotal(old.Details.DeviceTotal).
+		SetDevices(old.Details.Devices)
+	if _, err := c.DB.UpdateOne(ctx, databases.FilterCows().ID(before.ID), update); err != nil {
+		api.WriteError(w, r, "the cow could not be reverted", err)
+		return
+	}
+	after, err := c.DB.FindOne(ctx, databases.FilterCows().ID(before.ID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get cow by ID", err)
+		return
+	}
+	c.Audit.reverted(r, models.AuditCow, after.ID, after.Details.Business, version.Details.Version, before, after)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": after})
+}
+
+// managed is a helper function returning the cow of the request when the Admin making it
+// may see its history
+func (c Cow) managed(ctx context.Context, r *http.Request) (*models.Cow, error) {
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		return nil, err
+	}
+	cow, err := c.DB.FindOne(ctx, databases.FilterCows().ID(cowID))
+	if err != nil {
+		return nil, err
+	}
+	if !ownBusiness(r, cow.Details.Business) {
+		return nil, databases.ErrNotFound
+	}
+	return cow, nil
+}
+
+// ListDeviceVersions returns a page of the versions of a device, oldest first. Admins only
+// see the devices of the cows of their business
+func (d Device) ListDeviceVersions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	device, err := d.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	listVersions(ctx, w, r, d.Versions, models.AuditDevice, device.ID)
+}
+
+// GetDeviceVersion returns how a device looked at a version
+func (d Device) GetDeviceVersion(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	device, err := d.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	getVersion(ctx, w, r, d.Versions, models.AuditDevice, device.ID)
+}
+
+// DiffDeviceVersions lists the changes to a device between ?from and ?to, see DiffCowVersions
+func (d Device) DiffDeviceVersions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	device, err := d.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	diffVersions(ctx, w, r, d.Versions, models.AuditDevice, device.ID)
+}
+
+// RevertDevice sets the name, type and parent of a device back to those of a version, and
+// returns the device. The revert is itself a new version
+func (d Device) RevertDevice(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	before, err := d.managed(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "device not found", err)
+		return
+	}
+	version, err := findVersion(ctx, r, d.Versions, models.AuditDevice, before.ID)
+	if err != nil {
+		api.WriteError(w, r, "version not found", err)
+		return
+	}
+	var old models.Device
+	if err := json.Unmarshal(version.Details.Snapshot, &old); err != nil {
+		api.WriteError(w, r, "failed to read the version", err)
+		return
+	}
+
+	var business models.ID
+	if !old.Details.Parent.IsZero() {
+		parent, err := d.Cows.FindOne(ctx, databases.FilterCows().ID(old.Details.Parent))
+		if errors.Is(err, databases.ErrNotFound) {
+			err = errParentGone
+		}
+		if err != nil {
+			api.WriteError(w, r, "the device could not be reverted", err)
+			return
+		}
+		if !ownBusiness(r, parent.Details.Business) {
+			api.WriteError(w, r, "the device could not be reverted", api.Forbidden(errors.New("the cow of that version belongs to another business")))
+			return
+		}
+		business = parent.Details.Business
+	}
+
+	update := databases.UpdateDevice().SetName(old.Details.Name).SetType(old.Details.Type).SetParent(old.Details.Parent)
+	if _, err := d.DB.UpdateOne(ctx, databases.FilterDevices().ID(before.ID), update); err != nil {
+		api.WriteError(w, r, "the device could not be reverted", err)
+		return
+	}
+	after, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(before.ID))
+	if err != nil {
+		api.WriteError(w, r, "failed to get device by ID", err)
+		return
+	}
+	d.Audit.reverted(r, models.AuditDevice, after.ID, business, version.Details.Version, before, after)
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": after})
+}
+
+// managed is a helper function returning the device of the request when the Admin making
+// it may see its history. The business of a device is that of its parent cow
+func (d Device) managed(ctx context.Context, r *http.Request) (*models.Device, error) {
+	deviceID, err := idParam(r, "device_id")
+	if err != nil {
+		return nil, err
+	}
+	device, err := d.DB.FindOne(ctx, databases.FilterDevices().ID(deviceID))
+	if err != nil {
+		return nil, err
+	}
+	if actor, _ := api.UserFromContext(r.Context()); actor.Details.UserType == models.TypeSuperUser {
+		return device, nil
+	}
+	if device.Details.Parent.IsZero() {
+		return nil, databases.ErrNotFound
+	}
+	parent, err := d.Cows.FindOne(ctx, databases.FilterCows().ID(device.Details.Parent))
+	if err != nil {
+		return nil, err
+	}
+	if !ownBusiness(r, parent.Details.Business) {
+		return nil, databases.ErrNotFound
+	}
+	return device, nil
+}
+
+// ownBusiness is a helper function reporting whether the user of r manages business.
+// SuperUsers manage every business, Admins their own
+func ownBusiness(r *http.Request, business models.ID) bool {
+	actor, _ := api.UserFromContext(r.Context())
+	if actor.Details.UserType == models.TypeSuperUser {
+		return true
+	}
+	return !actor.Details.Business.IsZero() && actor.Details.Business == business
+}
+
+// listVersions is a helper function writing a page of the versions of an entity
+func listVersions(ctx context.Context, w http.ResponseWriter, r *http.Request, db databases.VersionDatabase, entityType string, id models.ID) {
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+
+	versions, err := db.Find(ctx, databases.FilterVersions().Entity(entityType, id).Limit(p.Limit).Skip(p.Offset))
+	if err != nil {
+		api.WriteError(w, r, "failed to get the versions", err)
+		return
+	}
+	if versions == nil {
+		versions = []models.Version{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": versions, "page": p})
+}
+
+// getVersion is a helper function writing the version of an entity named in the path
+func getVersion(ctx context.Context, w http.ResponseWriter, r *http.Request, db databases.VersionDatabase, entityType string, id models.ID) {
+	version, err := findVersion(ctx, r, db, entityType, id)
+	if err != nil {
+		api.WriteError(w, r, "version not found", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": version})
+}
+
+// findVersion is a helper function reading the version of an entity named in the path
+func findVersion(ctx context.Context, r *http.Request, db databases.VersionDatabase, entityType string, id models.ID) (*models.Version, error) {
+	n, err := strconv.Atoi(mux.Vars(r)["version"])
+	if err != nil || n < 1 {
+		return nil, api.InvalidParameter(errors.New("version must be a positive number"))
+	}
+	return db.FindOne(ctx, databases.FilterVersions().Entity(entityType, id).Version(n))
+}
+
+// diffVersions is a helper function writing the changes to an entity between the versions
+// ?from and ?to
+func diffVersions(ctx context.Context, w http.ResponseWriter, r *http.Request, db databases.VersionDatabase, entityType string, id models.ID) {
+	var bounds [2]int
+	for i, name := range []string{"from", "to"} {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			api.WriteError(w, r, "invalid version", api.InvalidParameter(fmt.Errorf("%s must be a positive number", name)))
+			return
+		}
+		bounds[i] = n
+	}
+	from, to := bounds[0], bounds[1]
+	if to == 0 {
+		latest, err := db.Find(ctx, databases.FilterVersions().Entity(entityType, id).Newest().Limit(1))
+		if err != nil {
+			api.WriteError(w, r, "failed to get the versions", err)
+			return
+		}
+		if len(latest) == 0 {
+			api.WriteError(w, r, "version not found", databases.ErrNotFound)
+			return
+		}
+		to = latest[0].Details.Version
+	}
+	if from == 0 {
+		from = to - 1
+	}
+	if from < 1 {
+		api.WriteError(w, r, "invalid version", api.InvalidParameter(errors.New("there is no version before the first one, name ?from")))
+		return
+	}
+
+	var snapshots [2]interface{}
+	for i, n := range []int{from, to} {
+		version, err := db.FindOne(ctx, databases.FilterVersions().Entity(entityType, id).Version(n))
+		if err != nil {
+			api.WriteError(w, r, fmt.Sprintf("version %d not found", n), err)
+			return
+		}
+		if err := json.Unmarshal(version.Details.Snapshot, &snapshots[i]); err != nil {
+			api.WriteError(w, r, "failed to read the version", err)
+			return
+		}
+	}
+
+	diff := models.VersionDiff{From: from, To: to, Changes: diffJSON("", snapshots[0], snapshots[1], []models.VersionChange{})}
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": diff})
+}
+
+// diffJSON is a helper function appending the values that differ between two decoded JSON
+// documents to changes. Objects are compared key by key and arrays index by index
+func diffJSON(path string, from, to interface{}, changes []models.VersionChange) []models.VersionChange {
+	switch f := from.(type) {
+	case map[string]interface{}:
+		t, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(f)+len(t))
+		for key := range f {
+			keys = append(keys, key)
+		}
+		for key := range t {
+			if _, ok := f[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			changes = diffJSON(child, f[key], t[key], changes)
+		}
+		return changes
+	case []interface{}:
+		t, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < len(f) || i < len(t); i++ {
+			var a, b interface{}
+			if i < len(f) {
+				a = f[i]
+			}
+			if i < len(t) {
+				b = t[i]
+			}
+			changes = diffJSON(fmt.Sprintf("%s[%d]", path, i), a, b, changes)
+		}
+		return changes
+	}
+	if !reflect.DeepEqual(from, to) {
+		changes = append(changes, models.VersionChange{Path: path, From: from, To: to})
+	}
+	return changes
+}
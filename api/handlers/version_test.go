@@ -0,0 +1,180 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// versions is a helper function listing the versions of the cow or device at path
+func (a *testApp) versions(token, path string) []models.Version {
+	a.t.Helper()
+	rec := a.do(http.MethodGet, path+"/versions", token, nil)
+	expect(a.t, rec, http.StatusOK, "listing the versions of "+path)
+	return result[[]models.Version](a.t, rec)
+}
+
+// diff is a helper function reading the changes to the cow or device at path with query
+func (a *testApp) diff(token, path, query string) models.VersionDiff {
+	a.t.Helper()
+	rec := a.do(http.MethodGet, path+"/versions/diff"+query, token, nil)
+	expect(a.t, rec, http.StatusOK, "comparing the versions of "+path+" with "+query)
+	return result[models.VersionDiff](a.t, rec)
+}
+
+func TestDiffJSON(t *testing.T) {
+	var from, to interface{}
+	json.Unmarshal([]byte(`{"cow": {"name": "CA-1", "deviceTotal": 30, "devices": ["a", "b"], "collection": "Laptop"}}`), &from)
+	json.Unmarshal([]byte(`{"cow": {"name": "CA-1", "deviceTotal": 28, "devices": ["a", "c", "d"], "business": "b1"}}`), &to)
+	want := []models.VersionChange{
+		{Path: "cow.business", From: nil, To: "b1"},
+		{Path: "cow.collection", From: "Laptop", To: nil},
+		{Path: "cow.deviceTotal", From: 30.0, To: 28.0},
+		{Path: "cow.devices[1]", From: "b", To: "c"},
+		{Path: "cow.devices[2]", From: nil, To: "d"},
+	}
+	if got := diffJSON("", from, to, []models.VersionChange{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffJSON = %+v, want %+v", got, want)
+	}
+	if got := diffJSON("", from, from, []models.VersionChange{}); len(got) != 0 {
+		t.Errorf("diffJSON of a document with itself = %+v, want no changes", got)
+	}
+	if got := diffJSON("cow", map[string]interface{}{"name": "CA-1"}, "CA-1", nil); len(got) != 1 || got[0].Path != "cow" {
+		t.Errorf("diffJSON of an object and a string = %+v, want the whole value changed", got)
+	}
+}
+
+func TestCowVersions(t *testing.T) {
+	a := newTestApp(t)
+	business, other := models.NewID(), models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	_, admin := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, other)
+	_, teacher := a.user(models.TypeUser, business)
+	cow := a.cow(business)
+	name := cow.Details.Name
+	path := "/api/v2/cows/" + cow.ID.String()
+
+	// a cow added before versions were kept starts with a baseline
+	expect(t, a.do(http.MethodPatch, path, admin, models.CowDetails{Collection: "iPad"}), http.StatusOK, "changing the collection")
+	expect(t, a.do(http.MethodPatch, path, admin, models.CowDetails{Name: "CA-gym"}), http.StatusOK, "renaming the cow")
+	versions := a.versions(admin, path)
+	want := []string{models.VersionBaseline, models.AuditUpdate, models.AuditUpdate}
+	if len(versions) != len(want) {
+		t.Fatalf("the cow has %d versions, want %d", len(versions), len(want))
+	}
+	for n, v := range versions {
+		if v.Details.Version != n+1 || v.Details.Action != want[n] || v.Details.EntityID != cow.ID || v.Details.Business != business {
+			t.Errorf("version %d is %+v, want %s", n+1, v.Details, want[n])
+		}
+	}
+	rec := a.do(http.MethodGet, path+"/versions/1", admin, nil)
+	expect(t, rec, http.StatusOK, "reading the baseline")
+	var baseline models.Cow
+	if err := json.Unmarshal(result[models.Version](t, rec).Details.Snapshot, &baseline); err != nil || baseline.Details.Collection != "Laptop" || baseline.Details.Name != name {
+		t.Errorf("the baseline is %+v, %v, want the cow as it was added", baseline.Details, err)
+	}
+	expect(t, a.do(http.MethodGet, path+"/versions/9", admin, nil), http.StatusNotFound, "reading a version that doesn't exist")
+
+	// comparing defaults to the latest change
+	if got := a.diff(admin, path, ""); got.From != 2 || got.To != 3 || !reflect.DeepEqual(got.Changes, []models.VersionChange{{Path: "cow.name", From: name, To: "CA-gym"}}) {
+		t.Errorf("the latest change is %+v, want the rename", got)
+	}
+	got := a.diff(admin, path, "?from=1&to=3")
+	if len(got.Changes) != 2 || got.Changes[0].Path != "cow.collection" || got.Changes[1].Path != "cow.name" {
+		t.Errorf("the changes since the baseline are %+v, want the collection and the name", got.Changes)
+	}
+	for _, query := range []string{"?from=0", "?to=first", "?to=1"} {
+		expect(t, a.do(http.MethodGet, path+"/versions/diff"+query, admin, nil), http.StatusBadRequest, "comparing versions with "+query)
+	}
+
+	// reverting brings back the details and keeps the bookings made since
+	expect(t, a.do(http.MethodPost, path+"/bookings", teacher, bookingOn(3, "1")), http.StatusCreated, "booking the cow")
+	rec = a.do(http.MethodPost, path+"/versions/1/revert", admin, nil)
+	expect(t, rec, http.StatusOK, "reverting to the baseline")
+	reverted := result[models.Cow](t, rec)
+	if reverted.Details.Name != name || reverted.Details.Collection != "Laptop" || len(reverted.Details.Bookings) != 1 {
+		t.Errorf("the reverted cow is %+v, want the baseline with the booking", reverted.Details)
+	}
+	versions = a.versions(admin, path)
+	if latest := versions[len(versions)-1].Details; len(versions) != 4 || latest.Restored != 1 || latest.Action != models.AuditUpdate {
+		t.Errorf("the revert made version %+v, want version 4 restoring 1", latest)
+	}
+	expect(t, a.do(http.MethodPost, path+"/versions/9/revert", admin, nil), http.StatusNotFound, "reverting to a version that doesn't exist")
+
+	// only Admins of the business see and revert the history
+	expect(t, a.do(http.MethodGet, path+"/versions", teacher, nil), http.StatusForbidden, "a User listing the versions")
+	expect(t, a.do(http.MethodPost, path+"/versions/1/revert", teacher, nil), http.StatusForbidden, "a User reverting the cow")
+	expect(t, a.do(http.MethodGet, path+"/versions", otherAdmin, nil), http.StatusNotFound, "an Admin of another business listing the versions")
+	expect(t, a.do(http.MethodGet, path+"/versions/diff", otherAdmin, nil), http.StatusNotFound, "an Admin of another business comparing the versions")
+	expect(t, a.do(http.MethodPost, path+"/versions/1/revert", otherAdmin, nil), http.StatusNotFound, "an Admin of another business reverting the cow")
+
+	// a cow moved to another business can't be reverted back into one its Admins don't manage
+	expect(t, a.do(http.MethodPatch, path, super, models.CowDetails{Business: other}), http.StatusOK, "a SuperUser moving the cow")
+	expect(t, a.do(http.MethodGet, path+"/versions", admin, nil), http.StatusNotFound, "an Admin listing the versions of a cow moved away")
+	expect(t, a.do(http.MethodPost, path+"/versions/1/revert", otherAdmin, nil), http.StatusForbidden, "an Admin reverting the cow to another business")
+	if found := a.findCow(cow.ID); found.Details.Business != other {
+		t.Errorf("a refused revert moved the cow to %s", found.Details.Business)
+	}
+	expect(t, a.do(http.MethodPost, path+"/versions/1/revert", super, nil), http.StatusOK, "a SuperUser reverting the cow to another business")
+	if found := a.findCow(cow.ID); found.Details.Business != business {
+		t.Errorf("the reverted cow belongs to %s, want %s", found.Details.Business, business)
+	}
+}
+
+func TestDeviceVersions(t *testing.T) {
+	a := newTestApp(t)
+	business, other := models.NewID(), models.NewID()
+	_, super := a.user(models.TypeSuperUser, "")
+	_, admin := a.user(models.TypeAdmin, business)
+	_, otherAdmin := a.user(models.TypeAdmin, other)
+	_, teacher := a.user(models.TypeUser, business)
+	first, second, theirs := a.cow(business), a.cow(business), a.cow(other)
+
+	rec := a.do(http.MethodPost, "/api/v2/devices", admin, models.DeviceDetails{Name: "LAP-01", Type: "Laptop", Parent: first.ID})
+	expect(t, rec, http.StatusCreated, "adding a device")
+	device := result[models.Device](t, rec)
+	path := "/api/v2/devices/" + device.ID.String()
+	expect(t, a.do(http.MethodPatch, path, admin, models.DeviceDetails{Name: "LAP-02", Parent: second.ID}), http.StatusOK, "moving the device")
+
+	// a device added through the API starts with its creation
+	versions := a.versions(admin, path)
+	if len(versions) != 2 || versions[0].Details.Action != models.AuditCreate || versions[1].Details.Action != models.AuditUpdate {
+		t.Fatalf("the device has the versions %+v, want its creation and the move", versions)
+	}
+	want := []models.VersionChange{
+		{Path: "device.name", From: "LAP-01", To: "LAP-02"},
+		{Path: "device.parent", From: first.ID.String(), To: second.ID.String()},
+	}
+	if got := a.diff(admin, path, ""); !reflect.DeepEqual(got.Changes, want) {
+		t.Errorf("the move changed %+v, want %+v", got.Changes, want)
+	}
+
+	rec = a.do(http.MethodPost, path+"/versions/1/revert", admin, nil)
+	expect(t, rec, http.StatusOK, "reverting the device")
+	if got := result[models.Device](t, rec); got.Details.Name != "LAP-01" || got.Details.Parent != first.ID {
+		t.Errorf("the reverted device is %+v, want it back in the first cow", got.Details)
+	}
+	if got := a.diff(admin, path, "?from=1"); got.To != 3 || len(got.Changes) != 0 {
+		t.Errorf("the revert is %+v, want version 3 the same as version 1", got)
+	}
+
+	// the cow of a version must still exist and be managed by the Admin
+	expect(t, a.do(http.MethodPost, path+"/versions/2/revert", admin, nil), http.StatusOK, "reverting the device to the second cow")
+	expect(t, a.do(http.MethodDelete, "/api/v2/cows/"+first.ID.String(), admin, nil), http.StatusNoContent, "removing the first cow")
+	expect(t, a.do(http.MethodPost, path+"/versions/1/revert", admin, nil), http.StatusConflict, "reverting the device to a removed cow")
+
+	expect(t, a.do(http.MethodGet, path+"/versions", teacher, nil), http.StatusForbidden, "a User listing the versions")
+	expect(t, a.do(http.MethodGet, path+"/versions", otherAdmin, nil), http.StatusNotFound, "an Admin of another business listing the versions")
+	expect(t, a.do(http.MethodPost, path+"/versions/2/revert", otherAdmin, nil), http.StatusNotFound, "an Admin of another business reverting the device")
+
+	expect(t, a.do(http.MethodPatch, path, super, models.DeviceDetails{Parent: theirs.ID}), http.StatusOK, "a SuperUser moving the device to another business")
+	expect(t, a.do(http.MethodGet, path+"/versions", admin, nil), http.StatusNotFound, "an Admin listing the versions of a device moved away")
+	expect(t, a.do(http.MethodPost, path+"/versions/2/revert", otherAdmin, nil), http.StatusForbidden, "an Admin reverting the device to a cow of another business")
+	if got := a.versions(otherAdmin, path); len(got) != 5 {
+		t.Errorf("the device has %d versions after a refused revert, want 5", len(got))
+	}
+}
@@ -0,0 +1,742 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thanhpk/randstr"
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+var (
+	// errSlotBooked is returned when booking a block the cow already has a booking for that day
+	errSlotBooked = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the cow is already booked for the block, join its waitlist instead")}
+	// errSlotHeld is returned when booking a block held for the next person on its waitlist
+	errSlotHeld = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the block is held for the next person on its waitlist")}
+	// errSlotFree is returned when joining the waitlist of a block that can be booked
+	errSlotFree = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the cow is free for the block, book it instead")}
+	// errAlreadyWaiting is returned when joining a waitlist twice
+	errAlreadyWaiting = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("you are already on the waitlist for the block")}
+	// errNotOffered is returned when accepting a block that isn't offered to the entry
+	errNotOffered = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the block isn't offered to you")}
+	// errOfferExpired is returned when accepting an offer past its hold window
+	errOfferExpired = &api.Error{Status: http.StatusConflict, Code: api.CodeConflict, Err: errors.New("the offer expired and passed to the next person")}
+	// errNotWaitlisted is returned when someone who can see a waitlist entry tries to accept it
+	errNotWaitlisted = api.Forbidden(errors.New("only the user on the waitlist can accept the offer"))
+)
+
+// Waitlist queues users for a block of a cow that is already booked. When the booking is
+// cancelled the first user waiting is offered the block and has Config.WaitlistHold to
+// accept it before it passes to the next, see RunOffers. Blocks are a cow, a block and the
+// day the booking starts in Config.TimeZone. A zero Waitlist has no queues
+type Waitlist struct {
+	DB       databases.WaitlistDatabase
+	Cows     databases.CowDatabase
+	Users    databases.UserDatabase
+	Mailer   mailer.Mailer
+	Config   *config.Config
+	Audit    Auditor
+	Approval Approval      // accepted offers of cows with an approval policy wait for an approver
+	Policies BookingPolicy // the booking must keep to the policies when joining and accepting
+	Calendar Calendar      // and not fall on a closure or blackout
+}
+
+// JoinWaitlist queues the user for the block of the booking in the body, to be booked for
+// them once it frees up and they accept it. The cow must already be booked for the block
+func (wl Waitlist) JoinWaitlist(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+
+	var booking models.BookDetails
+	if err := json.NewDecoder(r.Body).Decode(&booking); err != nil {
+		api.WriteError(w, r, "failed to unpack request body", api.InvalidBody(err))
+		return
+	}
+	if validationErr := validate.Struct(&booking); validationErr != nil {
+		api.WriteError(w, r, "invalid request body", validationErr)
+		return
+	}
+	booking.Block = strings.TrimSpace(booking.Block)
+	if booking.Block == "" {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("block", "required", errors.New("is required to join a waitlist")))
+		return
+	}
+	now := time.Now().UTC()
+	if !booking.StartDate.Time().After(now) {
+		api.WriteError(w, r, "invalid request body", api.InvalidField("startdate", "future", errors.New("must be in the future")))
+		return
+	}
+	if booking.Devices == nil {
+		booking.Devices = []models.ID{}
+	}
+
+	cow, err := wl.Cows.FindOne(ctx, databases.FilterCows().ID(cowID))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	if err := checkBusiness(r, cow.Details.Business); err != nil {
+		api.WriteError(w, r, "the waitlist could not be joined", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	date := slotDate(booking, wl.zone())
+	if !slotBooked(cow, date, booking.Block, wl.zone()) && wl.held(ctx, r, cow.ID, date, booking.Block) == nil {
+		api.WriteError(w, r, "the waitlist could not be joined", errSlotFree)
+		return
+	}
+	active := databases.FilterWaitlist().Slot(cow.ID, date, booking.Block).User(actor.ID).Status(models.WaitlistWaiting, models.WaitlistOffered)
+	if _, err := wl.DB.FindOne(ctx, active); err == nil {
+		api.WriteError(w, r, "the waitlist could not be joined", errAlreadyWaiting)
+		return
+	} else if !errors.Is(err, databases.ErrNotFound) {
+		api.WriteError(w, r, "the waitlist could not be joined", err)
+		return
+	}
+
+	if booking.Author == "" {
+		booking.Author = strings.TrimSpace(actor.Details.FirstName + " " + actor.Details.LastName)
+	}
+	// the booking gets its ID once the offer is accepted
+	booking.ID = ""
+	booking.User = actor.ID
+	if err := wl.Calendar.check(ctx, r, cow, booking); err != nil {
+		api.WriteError(w, r, "the cow can't be booked then", err)
+		return
+	}
+	if err := wl.Policies.check(ctx, r, cow, booking); err != nil {
+		api.WriteError(w, r, "the booking breaks a booking policy", err)
+		return
+	}
+	entry := models.WaitlistEntry{
+		ID: models.NewID(),
+		Details: models.WaitlistEntryDetails{
+			Cow:       cow.ID,
+			Business:  cow.Details.Business,
+			Date:      date,
+			Block:     booking.Block,
+			User:      actor.ID,
+			Booking:   booking,
+			Status:    models.WaitlistWaiting,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+	}
+	if _, err := wl.DB.InsertOne(ctx, entry); err != nil {
+		api.WriteError(w, r, "the waitlist could not be joined", err)
+		return
+	}
+	zap.S().Infow("joined a waitlist", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "waitlistEntry", entry.ID, "cow", cow.ID, "date", date, "block", booking.Block)
+
+	place, err := wl.place(ctx, entry)
+	if err != nil {
+		api.WriteError(w, r, "failed to find the place in the queue", err)
+		return
+	}
+	w.Header().Set("Location", "/api/v2/waitlist/"+entry.ID.String())
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": place})
+}
+
+// ListCowWaitlist returns the users queueing for the blocks of a cow in the order they
+// joined, filtered by ?date and ?block. Only entries still waiting or offered the block are
+// returned unless ?status asks for others. Admins see the cows of their business
+func (wl Waitlist) ListCowWaitlist(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	cowID, err := idParam(r, "cow_id")
+	if err != nil {
+		api.WriteError(w, r, "invalid cow ID", err)
+		return
+	}
+	cow, err := wl.Cows.FindOne(ctx, databases.FilterCows().ID(cowID))
+	if err == nil && !ownBusiness(r, cow.Details.Business) {
+		err = databases.ErrNotFound
+	}
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+
+	filter := databases.FilterWaitlist().Cow(cow.ID).Limit(p.Limit).Skip(p.Offset)
+	query := r.URL.Query()
+	if date := query.Get("date"); date != "" {
+		if _, err := time.Parse(slotDateLayout, date); err != nil {
+			api.WriteError(w, r, "invalid date", api.InvalidParameter(errors.New("date must be formatted YYYY-MM-DD")))
+			return
+		}
+		filter.Date(date)
+	}
+	if block := query.Get("block"); block != "" {
+		filter.Block(block)
+	}
+	if err := waitlistStatus(query.Get("status"), filter); err != nil {
+		api.WriteError(w, r, "invalid status", err)
+		return
+	}
+
+	entries, err := wl.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get the waitlist", err)
+		return
+	}
+	if entries == nil {
+		entries = []models.WaitlistEntry{}
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": entries, "page": p})
+}
+
+// ListWaitlist returns a page of the waitlist entries of the user with their place in the
+// queue, filtered by ?status
+func (wl Waitlist) ListWaitlist(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	p, err := pageParams(r)
+	if err != nil {
+		api.WriteError(w, r, "invalid pagination", api.InvalidParameter(err))
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	filter := databases.FilterWaitlist().User(actor.ID).Limit(p.Limit).Skip(p.Offset)
+	if status := r.URL.Query().Get("status"); status != "" {
+		if err := waitlistStatus(status, filter); err != nil {
+			api.WriteError(w, r, "invalid status", err)
+			return
+		}
+	}
+
+	entries, err := wl.DB.Find(ctx, filter)
+	if err != nil {
+		api.WriteError(w, r, "failed to get the waitlist", err)
+		return
+	}
+	places := []models.WaitlistPlace{}
+	for _, entry := range entries {
+		place, err := wl.place(ctx, entry)
+		if err != nil {
+			api.WriteError(w, r, "failed to find the place in the queue", err)
+			return
+		}
+		places = append(places, place)
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": places, "page": p})
+}
+
+// GetWaitlistEntry returns a waitlist entry with its place in the queue, to its user and
+// the Admins of its business
+func (wl Waitlist) GetWaitlistEntry(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entry, err := wl.find(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "waitlist entry not found", err)
+		return
+	}
+	place, err := wl.place(ctx, *entry)
+	if err != nil {
+		api.WriteError(w, r, "failed to find the place in the queue", err)
+		return
+	}
+
+	writeResult(w, r, http.StatusOK, map[string]interface{}{"result": place})
+}
+
+// AcceptWaitlistOffer books the block offered to the entry for its user. Cows with an
+// approval policy return 202 with the booking request instead
+func (wl Waitlist) AcceptWaitlistOffer(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	entry, err := wl.find(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "waitlist entry not found", err)
+		return
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if entry.Details.User != actor.ID {
+		api.WriteError(w, r, "the offer could not be accepted", errNotWaitlisted)
+		return
+	}
+	if entry.Details.Status != models.WaitlistOffered {
+		api.WriteError(w, r, "the offer could not be accepted", errNotOffered)
+		return
+	}
+	now := time.Now().UTC()
+	if !now.Before(entry.Details.OfferExpiresAt) {
+		if wl.miss(ctx, *entry) {
+			wl.promote(ctx, entry.Details.Cow, entry.Details.Date, entry.Details.Block)
+		}
+		api.WriteError(w, r, "the offer could not be accepted", errOfferExpired)
+		return
+	}
+
+	cow, err := wl.Cows.FindOne(ctx, databases.FilterCows().ID(entry.Details.Cow))
+	if err != nil {
+		api.WriteError(w, r, "cow not found", err)
+		return
+	}
+	if slotBooked(cow, entry.Details.Date, entry.Details.Block, wl.zone()) {
+		wl.requeue(ctx, r, entry)
+		api.WriteError(w, r, "the offer could not be accepted", errSlotBooked)
+		return
+	}
+	if err := wl.Calendar.check(ctx, r, cow, entry.Details.Booking); err != nil {
+		api.WriteError(w, r, "the cow can't be booked then", err)
+		return
+	}
+	if err := wl.Policies.checkFor(ctx, r, actor, cow, entry.Details.Booking, entry.ID); err != nil {
+		api.WriteError(w, r, "the booking breaks a booking policy", err)
+		return
+	}
+
+	// accepting first, filtered on the offered state, means the block is only booked once
+	offered := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistOffered)
+	result, err := wl.DB.UpdateOne(ctx, offered, databases.UpdateWaitlist().SetStatus(models.WaitlistAccepted, now))
+	if err != nil {
+		api.WriteError(w, r, "the offer could not be accepted", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "the offer could not be accepted", errNotOffered)
+		return
+	}
+	reopen := func() {
+		if _, err := wl.DB.UpdateOne(ctx, databases.FilterWaitlist().ID(entry.ID), databases.UpdateWaitlist().SetStatus(models.WaitlistOffered, now)); err != nil {
+			zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to reopen a waitlist offer", "waitlistEntry", entry.ID)
+		}
+	}
+
+	booking := entry.Details.Booking
+	booking.ID = fmt.Sprintf("%s.%s", cow.ID, randstr.Hex(16))
+	request, err := wl.Approval.request(ctx, r, cow.ID, booking)
+	if err != nil {
+		reopen()
+		api.WriteError(w, r, "the booking could not be requested", err)
+		return
+	}
+	if request != nil {
+		w.Header().Set("Location", "/api/v2/booking-requests/"+request.ID.String())
+		writeResult(w, r, http.StatusAccepted, map[string]interface{}{"result": request})
+		return
+	}
+
+	booked, err := pushBooking(ctx, wl.Cows, databases.FilterCows().ID(cow.ID), cow.ID, booking, wl.zone())
+	if err == nil && booked.MatchedCount == 0 {
+		err = databases.ErrNotFound
+	}
+	if err == nil {
+		err = wl.Policies.confirm(ctx, r, cow, booking)
+	}
+	if errors.Is(err, errSlotBooked) {
+		wl.requeue(ctx, r, entry)
+		api.WriteError(w, r, "the offer could not be accepted", errSlotBooked)
+		return
+	}
+	if err != nil {
+		reopen()
+		api.WriteError(w, r, "the booking could not be added to the cow", err)
+		return
+	}
+	wl.Audit.booking(r, cow, booking)
+	zap.S().Infow("accepted a waitlist offer", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "waitlistEntry", entry.ID, "cow", cow.ID)
+
+	writeResult(w, r, http.StatusCreated, map[string]interface{}{"result": booking})
+}
+
+// LeaveWaitlist takes an entry off the waitlist, its user or an Admin of its business can.
+// A block offered to the entry is offered to the next person
+func (wl Waitlist) LeaveWaitlist(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	entry, err := wl.find(ctx, r)
+	if err != nil {
+		api.WriteError(w, r, "waitlist entry not found", err)
+		return
+	}
+	if !entry.Active() {
+		api.WriteError(w, r, "waitlist entry not found", databases.ErrNotFound)
+		return
+	}
+	active := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistWaiting, models.WaitlistOffered)
+	result, err := wl.DB.UpdateOne(ctx, active, databases.UpdateWaitlist().SetStatus(models.WaitlistLeft, time.Now().UTC()))
+	if err != nil {
+		api.WriteError(w, r, "the waitlist could not be left", err)
+		return
+	}
+	if result.MatchedCount == 0 {
+		api.WriteError(w, r, "waitlist entry not found", databases.ErrNotFound)
+		return
+	}
+	zap.S().Infow("left a waitlist", "requestId", api.RequestIDFromContext(r.Context()), "actor", api.ActorFromContext(r.Context()), "waitlistEntry", entry.ID, "cow", entry.Details.Cow)
+
+	if entry.Details.Status == models.WaitlistOffered {
+		wl.promote(ctx, entry.Details.Cow, entry.Details.Date, entry.Details.Block)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RunOffers passes on the offers nobody accepted in time, closes the entries of days that
+// passed and offers blocks that freed up without a cancellation, eg. bookings removed with
+// the CLI, every interval until ctx is done
+func (wl Waitlist) RunOffers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		runCtx, cancel := context.WithTimeout(ctx, interval)
+		offered, err := wl.PassOffers(runCtx)
+		cancel()
+		if err != nil {
+			zap.S().With("error", err).Error("failed to pass on waitlist offers")
+		} else if offered > 0 {
+			zap.S().Infow("offered waitlisted blocks", "offered", offered)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// PassOffers misses every offer past its hold window, closes the entries of days before
+// today and offers every free block to the first user waiting for it, returning how many
+// offers were made
+func (wl Waitlist) PassOffers(ctx context.Context) (int, error) {
+	now := time.Now().UTC()
+	missed, err := wl.DB.Find(ctx, databases.FilterWaitlist().Status(models.WaitlistOffered).OfferExpiresBefore(now))
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range missed {
+		wl.miss(ctx, entry)
+	}
+
+	past, err := wl.DB.Find(ctx, databases.FilterWaitlist().Status(models.WaitlistWaiting, models.WaitlistOffered).DateBefore(now.In(wl.zone()).Format(slotDateLayout)))
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range past {
+		active := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistWaiting, models.WaitlistOffered)
+		if _, err := wl.DB.UpdateOne(ctx, active, databases.UpdateWaitlist().SetStatus(models.WaitlistClosed, now)); err != nil {
+			zap.S().With("error", err).Errorw("failed to close a waitlist entry", "waitlistEntry", entry.ID)
+		}
+	}
+
+	waiting, err := wl.DB.Find(ctx, databases.FilterWaitlist().Status(models.WaitlistWaiting))
+	if err != nil {
+		return 0, err
+	}
+	offered := 0
+	seen := map[string]bool{}
+	for _, entry := range waiting {
+		d := entry.Details
+		slot := d.Cow.String() + "/" + d.Date + "/" + d.Block
+		if seen[slot] {
+			continue
+		}
+		seen[slot] = true
+		if wl.promote(ctx, d.Cow, d.Date, d.Block) {
+			offered++
+		}
+	}
+	return offered, ctx.Err()
+}
+
+// promote is a helper function offering a free block to the first user waiting for it,
+// unless it is already held for someone. It reports whether an offer was made
+func (wl Waitlist) promote(ctx context.Context, cowID models.ID, date, block string) bool {
+	if wl.DB == nil {
+		return false
+	}
+	log := zap.S().With("cow", cowID, "date", date, "block", block)
+	now := time.Now().UTC()
+	offers, err := wl.DB.Find(ctx, databases.FilterWaitlist().Slot(cowID, date, block).Status(models.WaitlistOffered))
+	if err != nil {
+		log.With("error", err).Error("failed to find the waitlist offers of a block")
+		return false
+	}
+	for _, offer := range offers {
+		if now.Before(offer.Details.OfferExpiresAt) {
+			return false
+		}
+	}
+	cow, err := wl.Cows.FindOne(ctx, databases.FilterCows().ID(cowID))
+	if err != nil {
+		if !errors.Is(err, databases.ErrNotFound) {
+			log.With("error", err).Error("failed to find the cow of a waitlist")
+		}
+		return false
+	}
+	if slotBooked(cow, date, block, wl.zone()) {
+		return false
+	}
+
+	for {
+		next, err := wl.DB.Find(ctx, databases.FilterWaitlist().Slot(cowID, date, block).Status(models.WaitlistWaiting).Limit(1))
+		if err != nil {
+			log.With("error", err).Error("failed to find the next entry of a waitlist")
+			return false
+		}
+		if len(next) == 0 {
+			return false
+		}
+		entry := next[0]
+
+		// a block that started can't be offered anymore, the entry is closed for the next
+		start := entry.Details.Booking.StartDate.Time()
+		if !start.After(now) {
+			waiting := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistWaiting)
+			if _, err := wl.DB.UpdateOne(ctx, waiting, databases.UpdateWaitlist().SetStatus(models.WaitlistClosed, now)); err != nil {
+				log.With("error", err).Errorw("failed to close a waitlist entry", "waitlistEntry", entry.ID)
+				return false
+			}
+			continue
+		}
+		expires := now.Add(wl.Config.WaitlistHold)
+		if start.Before(expires) {
+			expires = start
+		}
+
+		// offering is filtered on the waiting state so two cancellations at once offer it once
+		waiting := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistWaiting)
+		result, err := wl.DB.UpdateOne(ctx, waiting, databases.UpdateWaitlist().SetOffer(now, expires))
+		if err != nil {
+			log.With("error", err).Errorw("failed to offer a block to a waitlist entry", "waitlistEntry", entry.ID)
+			return false
+		}
+		if result.MatchedCount == 0 {
+			return false
+		}
+		entry.Details.Status, entry.Details.OfferedAt, entry.Details.OfferExpiresAt = models.WaitlistOffered, now, expires
+		log.Infow("offered a block to the waitlist", "waitlistEntry", entry.ID, "user", entry.Details.User)
+
+		if user, err := wl.Users.FindOne(ctx, databases.FilterUsers().ID(entry.Details.User)); err == nil {
+			msg := auth.WaitlistOfferEmail(user, cow.Details.Name, &entry, wl.Config.BaseURL)
+			if err := wl.Mailer.Send(ctx, msg); err != nil && !errors.Is(err, mailer.ErrNotConfigured) {
+				log.With("error", err).Errorw("failed to email a waitlist offer", "waitlistEntry", entry.ID)
+			}
+		}
+		return true
+	}
+}
+
+// miss is a helper function ending an offer nobody accepted, reporting whether it was
+// still offered
+func (wl Waitlist) miss(ctx context.Context, entry models.WaitlistEntry) bool {
+	offered := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistOffered)
+	result, err := wl.DB.UpdateOne(ctx, offered, databases.UpdateWaitlist().SetStatus(models.WaitlistMissed, time.Now().UTC()))
+	if err != nil {
+		zap.S().With("error", err).Errorw("failed to end a waitlist offer", "waitlistEntry", entry.ID)
+		return false
+	}
+	return result.MatchedCount > 0
+}
+
+// requeue is a helper function putting an entry offered a block that was booked before it
+// was accepted back in its place in the queue, to be offered the block if it frees up
+func (wl Waitlist) requeue(ctx context.Context, r *http.Request, entry *models.WaitlistEntry) {
+	filter := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistOffered, models.WaitlistAccepted)
+	if _, err := wl.DB.UpdateOne(ctx, filter, databases.UpdateWaitlist().SetStatus(models.WaitlistWaiting, time.Now().UTC())); err != nil {
+		zap.S().With("error", err, "requestId", api.RequestIDFromContext(r.Context())).Errorw("failed to put a waitlist entry back in its queue", "waitlistEntry", entry.ID)
+	}
+}
+
+// held is a helper function refusing to book a block that is offered to someone else on
+// its waitlist
+func (wl Waitlist) held(ctx context.Context, r *http.Request, cowID models.ID, date, block string) error {
+	var user models.ID
+	if actor, _ := api.UserFromContext(r.Context()); actor != nil {
+		user = actor.ID
+	}
+	return slotHeld(ctx, wl.DB, user, cowID, date, block)
+}
+
+// slotHeld is a helper function refusing to book a block for user, empty for API keys, when
+// it is offered to someone else on its waitlist
+func slotHeld(ctx context.Context, db databases.WaitlistDatabase, user, cowID models.ID, date, block string) error {
+	if db == nil {
+		return nil
+	}
+	offers, err := db.Find(ctx, databases.FilterWaitlist().Slot(cowID, date, block).Status(models.WaitlistOffered))
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	for _, offer := range offers {
+		if now.Before(offer.Details.OfferExpiresAt) && (user == "" || offer.Details.User != user) {
+			return errSlotHeld
+		}
+	}
+	return nil
+}
+
+// booked is a helper function marking the entries of the user of r for a block they booked
+// themselves as accepted, so the block isn't offered to them anymore
+func (wl Waitlist) booked(ctx context.Context, r *http.Request, cowID models.ID, booking models.BookDetails) {
+	actor, _ := api.UserFromContext(r.Context())
+	if wl.DB == nil || actor == nil || booking.Block == "" {
+		return
+	}
+	active := databases.FilterWaitlist().Slot(cowID, slotDate(booking, wl.zone()), booking.Block).User(actor.ID).Status(models.WaitlistWaiting, models.WaitlistOffered)
+	entries, err := wl.DB.Find(ctx, active)
+	if err != nil {
+		zap.S().With("error", err).Errorw("failed to find the waitlist entries of a booking", "cow", cowID)
+		return
+	}
+	for _, entry := range entries {
+		filter := databases.FilterWaitlist().ID(entry.ID).Status(models.WaitlistWaiting, models.WaitlistOffered)
+		if _, err := wl.DB.UpdateOne(ctx, filter, databases.UpdateWaitlist().SetStatus(models.WaitlistAccepted, time.Now().UTC())); err != nil {
+			zap.S().With("error", err).Errorw("failed to accept a waitlist entry", "waitlistEntry", entry.ID)
+		}
+	}
+}
+
+// place is a helper function returning an entry with its place in the queue for its block
+func (wl Waitlist) place(ctx context.Context, entry models.WaitlistEntry) (models.WaitlistPlace, error) {
+	place := models.WaitlistPlace{WaitlistEntry: entry}
+	if !entry.Active() {
+		return place, nil
+	}
+	d := entry.Details
+	queue, err := wl.DB.Find(ctx, databases.FilterWaitlist().Slot(d.Cow, d.Date, d.Block).Status(models.WaitlistWaiting))
+	if err != nil {
+		return place, err
+	}
+	place.Waiting = len(queue)
+	for i, queued := range queue {
+		if queued.ID == entry.ID {
+			place.Position = i + 1
+		}
+	}
+	return place, nil
+}
+
+// find is a helper function loading the waitlist entry of r, when the user making it is
+// its user, an Admin of its business or a SuperUser. Others get ErrNotFound
+func (wl Waitlist) find(ctx context.Context, r *http.Request) (*models.WaitlistEntry, error) {
+	id, err := idParam(r, "entry_id")
+	if err != nil {
+		return nil, err
+	}
+	entry, err := wl.DB.FindOne(ctx, databases.FilterWaitlist().ID(id))
+	if err != nil {
+		return nil, err
+	}
+	actor, _ := api.UserFromContext(r.Context())
+	if entry.Details.User != actor.ID && (actor.Details.UserType == models.TypeUser || !ownBusiness(r, entry.Details.Business)) {
+		return nil, databases.ErrNotFound
+	}
+	return entry, nil
+}
+
+// waitlistStatus is a helper function filtering entries by the ?status of a request, the
+// entries waiting for or offered a block when it is empty
+func waitlistStatus(status string, filter *databases.WaitlistFilter) error {
+	switch status {
+	case "":
+		filter.Status(models.WaitlistWaiting, models.WaitlistOffered)
+	case models.WaitlistWaiting, models.WaitlistOffered, models.WaitlistAccepted, models.WaitlistMissed, models.WaitlistLeft, models.WaitlistClosed:
+		filter.Status(status)
+	default:
+		return api.InvalidParameter(errors.New("status must be waiting, offered, accepted, missed, left or closed"))
+	}
+	return nil
+}
+
+// slotDateLayout formats the day of a block
+const slotDateLayout = "2006-01-02"
+
+// zone returns the time zone of the days of blocks
+func (wl Waitlist) zone() *time.Location {
+	if wl.Config == nil {
+		return time.UTC
+	}
+	return zoneOf(wl.Config.TimeZone)
+}
+
+// zoneOf is a helper function returning loc, or UTC for handlers built without a time zone
+func zoneOf(loc *time.Location) *time.Location {
+	if loc == nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// slotDate is a helper function returning the day a booking starts in loc, the day of its
+// block
+func slotDate(booking models.BookDetails, loc *time.Location) string {
+	return booking.StartDate.Time().In(zoneOf(loc)).Format(slotDateLayout)
+}
+
+// slotSpan is a helper function returning the start and end of the day of a booking's block
+func slotSpan(booking models.BookDetails, loc *time.Location) (time.Time, time.Time) {
+	return daySpan(booking.StartDate.Time(), loc)
+}
+
+// daySpan is a helper function returning the start and end of the day of t in loc. Days of
+// a daylight saving change are 23 or 25 hours long
+func daySpan(t time.Time, loc *time.Location) (time.Time, time.Time) {
+	t = t.In(zoneOf(loc))
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	return day.UTC(), day.AddDate(0, 0, 1).UTC()
+}
+
+// pushBooking is a helper function adding a booking to the cow matched by filter while its
+// block is free. Checking and adding are one update, so of two requests for a block only one
+// books it and the other gets errSlotBooked. A missing cow matches nothing, as before
+func pushBooking(ctx context.Context, cows databases.CowDatabase, filter *databases.CowFilter, cowID models.ID, booking models.BookDetails, loc *time.Location) (*databases.UpdateResult, error) {
+	if booking.Block == "" {
+		return cows.UpdateOne(ctx, filter, databases.UpdateCow().PushBooking(booking))
+	}
+	from, to := slotSpan(booking, loc)
+	result, err := cows.UpdateOne(ctx, filter.SlotFree(booking.Block, from, to), databases.UpdateCow().PushBooking(booking))
+	if err != nil || result.MatchedCount > 0 {
+		return result, err
+	}
+	if _, err := cows.FindOne(ctx, databases.FilterCows().ID(cowID)); err == nil {
+		return result, errSlotBooked
+	} else if !errors.Is(err, databases.ErrNotFound) {
+		return result, err
+	}
+	return result, nil
+}
+
+// slotBooked is a helper function reporting whether the cow has a booking for the block on
+// the day, a day of loc
+func slotBooked(cow *models.Cow, date, block string, loc *time.Location) bool {
+	for _, booking := range cow.Details.Bookings {
+		if booking.Block == block && slotDate(booking, loc) == date {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// entryStatus is a helper function reading the status of a waitlist entry from the store
+func (a *testApp) entryStatus(id models.ID) string {
+	a.t.Helper()
+	entry, err := a.store.Waitlist().FindOne(context.Background(), databases.FilterWaitlist().ID(id))
+	if err != nil {
+		a.t.Fatalf("failed to read waitlist entry %s: %v", id, err)
+	}
+	return entry.Details.Status
+}
+
+func TestWaitlistOffers(t *testing.T) {
+	a := newTestApp(t, func(c *config.Config) { c.WaitlistHold = time.Hour })
+	ctx := context.Background()
+	wl := a.waitlist(a.approval(Auditor{}))
+	business := models.NewID()
+	_, owner := a.user(models.TypeUser, business)
+	_, second := a.user(models.TypeUser, business)
+	_, third := a.user(models.TypeUser, business)
+	cow := a.cow(business)
+	path := "/api/v2/cows/" + cow.ID.String()
+	booking := bookingOn(3, "1")
+
+	expect(t, a.do(http.MethodPost, path+"/waitlist", second, booking), http.StatusConflict, "joining the waitlist of a free block")
+	rec := a.do(http.MethodPost, path+"/bookings", owner, booking)
+	expect(t, rec, http.StatusCreated, "booking a free block")
+	booked := result[models.BookDetails](t, rec)
+
+	join := func(token string) models.WaitlistPlace {
+		t.Helper()
+		rec := a.do(http.MethodPost, path+"/waitlist", token, booking)
+		expect(t, rec, http.StatusCreated, "joining the waitlist")
+		return result[models.WaitlistPlace](t, rec)
+	}
+	secondEntry, thirdEntry := join(second), join(third)
+	if secondEntry.Position != 1 || thirdEntry.Position != 2 || thirdEntry.Waiting != 2 {
+		t.Errorf("the queue has places %d and %d of %d, want 1 and 2 of 2", secondEntry.Position, thirdEntry.Position, thirdEntry.Waiting)
+	}
+	expect(t, a.do(http.MethodPost, path+"/waitlist", second, booking), http.StatusConflict, "joining the waitlist twice")
+
+	// cancelling offers the block to the first in the queue and holds it for them
+	expect(t, a.do(http.MethodDelete, path+"/bookings/"+booked.ID, owner, nil), http.StatusNoContent, "cancelling the booking")
+	if got := a.entryStatus(secondEntry.ID); got != models.WaitlistOffered {
+		t.Fatalf("the first in the queue is %s after a cancellation, want offered", got)
+	}
+	if got := a.entryStatus(thirdEntry.ID); got != models.WaitlistWaiting {
+		t.Errorf("the second in the queue is %s, want waiting", got)
+	}
+	rec = a.do(http.MethodPost, path+"/bookings", third, booking)
+	expect(t, rec, http.StatusConflict, "booking a block held for the waitlist")
+	if got := errorOf(t, rec).Message; !strings.HasSuffix(got, errSlotHeld.Error()) {
+		t.Errorf("booking a held block failed with %q, want %q", got, errSlotHeld.Error())
+	}
+
+	// an offer in its hold window isn't passed on
+	if offered, err := wl.PassOffers(ctx); err != nil || offered != 0 {
+		t.Errorf("PassOffers made %d offers and returned %v, want 0 and nil", offered, err)
+	}
+
+	// an offer nobody accepted in time is missed and passed to the next
+	past := time.Now().UTC().Add(-2 * time.Hour)
+	if _, err := a.store.Waitlist().UpdateOne(ctx, databases.FilterWaitlist().ID(secondEntry.ID), databases.UpdateWaitlist().SetOffer(past, past.Add(time.Hour))); err != nil {
+		t.Fatalf("failed to expire the offer: %v", err)
+	}
+	if offered, err := wl.PassOffers(ctx); err != nil || offered != 1 {
+		t.Errorf("PassOffers made %d offers and returned %v, want 1 and nil", offered, err)
+	}
+	if got := a.entryStatus(secondEntry.ID); got != models.WaitlistMissed {
+		t.Errorf("an expired offer is %s, want missed", got)
+	}
+	if got := a.entryStatus(thirdEntry.ID); got != models.WaitlistOffered {
+		t.Fatalf("the next in the queue is %s after an offer expired, want offered", got)
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/waitlist/"+secondEntry.ID.String()+"/accept", second, nil), http.StatusConflict, "accepting a missed offer")
+
+	// a booking made around the hold, eg. with the CLI, wins and the entry waits again
+	direct := bookingOn(3, "1")
+	direct.ID = cow.ID.String() + ".direct"
+	if _, err := a.store.Cows().UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(direct)); err != nil {
+		t.Fatalf("failed to book the block directly: %v", err)
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/waitlist/"+thirdEntry.ID.String()+"/accept", third, nil), http.StatusConflict, "accepting an offer of a block booked since")
+	if got := a.entryStatus(thirdEntry.ID); got != models.WaitlistWaiting {
+		t.Errorf("an entry that lost its block is %s, want waiting", got)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 {
+		t.Errorf("the cow has %d bookings, want 1", len(got.Details.Bookings))
+	}
+
+	// the sweep offers blocks that freed up without a cancellation
+	if _, err := a.store.Cows().UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PullBooking(direct.ID)); err != nil {
+		t.Fatalf("failed to remove the booking: %v", err)
+	}
+	if offered, err := wl.PassOffers(ctx); err != nil || offered != 1 {
+		t.Errorf("PassOffers made %d offers and returned %v, want 1 and nil", offered, err)
+	}
+	expect(t, a.do(http.MethodPost, "/api/v2/waitlist/"+thirdEntry.ID.String()+"/accept", third, nil), http.StatusCreated, "accepting an offer")
+	if got := a.entryStatus(thirdEntry.ID); got != models.WaitlistAccepted {
+		t.Errorf("an accepted offer is %s, want accepted", got)
+	}
+	if got := a.findCow(cow.ID); len(got.Details.Bookings) != 1 || got.Details.Bookings[0].Block != "1" {
+		t.Errorf("the cow has bookings %+v, want the accepted block", got.Details.Bookings)
+	}
+}
+
+func TestWaitlistClosesPastDays(t *testing.T) {
+	a := newTestApp(t, func(c *config.Config) { c.WaitlistHold = time.Hour })
+	ctx := context.Background()
+	business := models.NewID()
+	user, _ := a.user(models.TypeUser, business)
+	cow := a.cow(business)
+
+	yesterday := bookingOn(-1, "1")
+	entry := models.WaitlistEntry{ID: models.NewID(), Details: models.WaitlistEntryDetails{
+		Cow:       cow.ID,
+		Business:  business,
+		Date:      slotDate(yesterday, time.UTC),
+		Block:     "1",
+		User:      user.ID,
+		Booking:   yesterday,
+		Status:    models.WaitlistWaiting,
+		CreatedAt: time.Now().UTC(),
+		UpdatedAt: time.Now().UTC(),
+	}}
+	if _, err := a.store.Waitlist().InsertOne(ctx, entry); err != nil {
+		t.Fatalf("failed to add a waitlist entry: %v", err)
+	}
+	if offered, err := a.waitlist(a.approval(Auditor{})).PassOffers(ctx); err != nil || offered != 0 {
+		t.Errorf("PassOffers made %d offers and returned %v, want 0 and nil", offered, err)
+	}
+	if got := a.entryStatus(entry.ID); got != models.WaitlistClosed {
+		t.Errorf("the entry of a past day is %s, want closed", got)
+	}
+}
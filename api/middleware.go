@@ -1,9 +1,20 @@
 package api
 
 import (
+	"context"
 	"net/http"
+
+	"github.com/thanhpk/randstr"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
 )
 
+// RequestIDHeader carries the ID of a request, it is set on every response and
+// accepted from clients and proxies that already assign one
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
 // Middleware adds some basic header authentication around accessing the routes
 func Middleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -12,3 +23,64 @@ func Middleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// Deprecated marks every response as coming from a deprecated API version and links
+// clients to the version that replaces it
+func Deprecated(successor string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+successor+">; rel=\"successor-version\"")
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ForwardedFor sets the remote address of requests from proxies to the address of the client
+// they name in X-Forwarded-For, so sessions, audit entries and the throttle of failed logins
+// see the client. Requests from anywhere else keep their own address
+func ForwardedFor(proxies auth.TrustedProxies) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(proxies) > 0 {
+				if ip := proxies.ClientIP(r); ip != auth.ClientIP(r) {
+					r = r.Clone(r.Context())
+					r.RemoteAddr = ip
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequestID gives every request an ID, stored in its context and sent back in the
+// X-Request-ID header so errors can be matched with the logs
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = randstr.Hex(16)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey{}, id)))
+	})
+}
+
+// RequestIDFromContext returns the ID set by RequestID, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// validRequestID is a helper function accepting short printable IDs from clients
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
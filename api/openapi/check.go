@@ -0,0 +1,34 @@
+package openapi
+
+import (
+	"sort"
+
+	"github.com/gorilla/mux"
+)
+
+// Missing walks the router and returns every route that is not documented, eg.
+// "POST /api/v2/cows". Routes registered without a method are reported as "ANY"
+func Missing(doc *Document, router *mux.Router) ([]string, error) {
+	var missing []string
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil // subrouter prefixes
+		}
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{"ANY"}
+		}
+		for _, method := range methods {
+			if method == "ANY" || !doc.Has(method, path) {
+				missing = append(missing, method+" "+path)
+			}
+		}
+		return nil
+	})
+	sort.Strings(missing)
+	return missing, err
+}
@@ -0,0 +1,32 @@
+package openapi
+
+import (
+	_ "embed" // docs.html is bundled into the binary
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+//go:embed docs.html
+var docsHTML string
+
+// Handler serves the document as JSON. The document must not change once it is served
+func (doc *Document) Handler() http.Handler {
+	b, err := json.Marshal(doc)
+	if err != nil {
+		panic("openapi: " + err.Error())
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	})
+}
+
+// DocsHandler serves the bundled docs UI, which renders the document found at specURL
+func DocsHandler(specURL string) http.Handler {
+	page := []byte(strings.Replace(docsHTML, "{{SPEC_URL}}", specURL, 1))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+}
@@ -0,0 +1,246 @@
+// Package openapi builds an OpenAPI 3 document for the API. Request and response schemas
+// are generated from the models structs and their json and validate tags, so the document
+// follows the code instead of being written by hand
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version of the OpenAPI specification the document follows
+const Version = "3.0.3"
+
+// Document is the root of an OpenAPI document
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+
+	envelope    Envelope
+	errorSchema *Schema
+}
+
+// Info describes the API
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem holds the operations of a path by lower case HTTP method
+type PathItem map[string]*Operation
+
+// Components holds the schemas referenced from operations and the security schemes
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme describes how requests are signed in, eg. a bearer token
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// BearerAuth is the name of the security scheme used by routes with Route.Auth set
+const BearerAuth = "bearerAuth"
+
+// Operation describes a single method on a path
+type Operation struct {
+	Summary     string                `json:"summary,omitempty"`
+	OperationID string                `json:"operationId,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Deprecated  bool                  `json:"deprecated,omitempty"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+}
+
+// Parameter is a path or query parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body an operation accepts
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes one status code an operation can return
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON schema used by the document
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// Route documents one route registered on the router
+type Route struct {
+	Method     string
+	Path       string // mux path template, eg. /api/v2/cows/{cow_id}
+	Summary    string
+	Tag        string
+	Deprecated bool
+	Query      []Parameter // path parameters are added from the template
+	Body       interface{} // zero value of the request body, nil when there is none
+	BodyType   string      // media type of Body when it isn't JSON, eg. text/csv with a string Body
+	Status     int         // success status, defaults to 200
+	Result     interface{} // zero value of data.result in the response envelope, nil for an empty body
+	Paged      bool        // the response envelope includes data.page
+	Raw        bool        // the response is not wrapped in the envelope, eg. the document itself
+	ResultType string      // media type of a Raw Result when it isn't JSON, eg. text/csv with a string Result
+	Auth       bool        // the route needs a bearer token, see Document.SecuritySchemes
+}
+
+// Envelope builds the schema of the standard response envelope around result
+type Envelope func(doc *Document, result *Schema, paged bool) *Schema
+
+// New creates an empty document. envelope wraps results, errorType is the body of every failed request
+func New(info Info, envelope Envelope, errorType interface{}) *Document {
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]PathItem{},
+		Components: Components{
+			Schemas: map[string]*Schema{},
+			SecuritySchemes: map[string]SecurityScheme{
+				BearerAuth: {Type: "http", Scheme: "bearer", Description: "token from POST /api/v2/auth/login, or an API key"},
+			},
+		},
+	}
+	doc.envelope = envelope
+	doc.errorSchema = doc.Schema(errorType)
+	return doc
+}
+
+// Add documents routes, it panics when a method and path is documented twice
+func (doc *Document) Add(routes ...Route) {
+	for _, route := range routes {
+		method := strings.ToLower(route.Method)
+		item, ok := doc.Paths[route.Path]
+		if !ok {
+			item = PathItem{}
+			doc.Paths[route.Path] = item
+		}
+		if _, exists := item[method]; exists {
+			panic(fmt.Sprintf("openapi: %s %s documented twice", route.Method, route.Path))
+		}
+		item[method] = doc.operation(route)
+	}
+}
+
+// Has reports whether the method and mux path template are documented
+func (doc *Document) Has(method, path string) bool {
+	_, ok := doc.Paths[path][strings.ToLower(method)]
+	return ok
+}
+
+var pathParam = regexp.MustCompile(`{([^}:]+)(:[^}]*)?}`)
+
+func (doc *Document) operation(route Route) *Operation {
+	op := &Operation{
+		Summary:     route.Summary,
+		OperationID: operationID(route.Method, route.Path),
+		Deprecated:  route.Deprecated,
+		Responses:   map[string]Response{},
+	}
+	if route.Tag != "" {
+		op.Tags = []string{route.Tag}
+	}
+	if route.Auth {
+		op.Security = []map[string][]string{{BearerAuth: {}}}
+	}
+
+	for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+		param := Parameter{Name: match[1], In: "path", Required: true, Schema: &Schema{Type: "string"}}
+		if strings.HasSuffix(match[1], "_id") {
+			param.Schema = idSchema()
+		}
+		op.Parameters = append(op.Parameters, param)
+	}
+	op.Parameters = append(op.Parameters, route.Query...)
+
+	switch {
+	case route.Body != nil && route.BodyType != "":
+		op.RequestBody = &RequestBody{Required: true, Content: map[string]MediaType{route.BodyType: {Schema: doc.Schema(route.Body)}}}
+	case route.Body != nil:
+		op.RequestBody = &RequestBody{Required: true, Content: jsonContent(doc.Schema(route.Body))}
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := Response{Description: http.StatusText(status)}
+	switch {
+	case route.Result == nil:
+	case route.Raw && route.ResultType != "":
+		response.Content = map[string]MediaType{route.ResultType: {Schema: doc.Schema(route.Result)}}
+	case route.Raw:
+		response.Content = jsonContent(doc.Schema(route.Result))
+	default:
+		response.Content = jsonContent(doc.envelope(doc, doc.Schema(route.Result), route.Paged))
+	}
+	op.Responses[strconv.Itoa(status)] = response
+	op.Responses["default"] = Response{Description: "Error", Content: jsonContent(doc.errorSchema)}
+	return op
+}
+
+// operationID is a helper function naming an operation from its method and path,
+// eg. GET /api/v2/cows/{cow_id} is get_v2_cows_cow_id
+func operationID(method, path string) string {
+	path = strings.TrimPrefix(path, "/api")
+	path = pathParam.ReplaceAllString(path, "$1")
+	parts := strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '.' || r == '-' })
+	return strings.ToLower(method) + "_" + strings.Join(parts, "_")
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {Schema: schema}}
+}
+
+// Routes returns every documented method and path, sorted, eg. "GET /api/v2/cows"
+func (doc *Document) Routes() []string {
+	var routes []string
+	for path, item := range doc.Paths {
+		for method := range item {
+			routes = append(routes, strings.ToUpper(method)+" "+path)
+		}
+	}
+	sort.Strings(routes)
+	return routes
+}
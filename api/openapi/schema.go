@@ -0,0 +1,168 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+var (
+	idType       = reflect.TypeOf(models.ID(""))
+	objectIDType = reflect.TypeOf(primitive.ObjectID{})
+	dateTimeType = reflect.TypeOf(primitive.DateTime(0))
+	timeType     = reflect.TypeOf(time.Time{})
+	rawJSONType  = reflect.TypeOf(json.RawMessage{})
+)
+
+// idSchema is the schema of models.ID
+func idSchema() *Schema {
+	return &Schema{Type: "string", Pattern: "^[0-9a-f]{24}$", Description: "24 character hex ID"}
+}
+
+// Schema returns the schema of v's type. Named structs are added to the components and
+// referenced, so every model appears once in the document
+func (doc *Document) Schema(v interface{}) *Schema {
+	return doc.schema(reflect.TypeOf(v))
+}
+
+func (doc *Document) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case idType, objectIDType:
+		return idSchema()
+	case dateTimeType, timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawJSONType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: doc.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return doc.object(t)
+		}
+		if _, ok := doc.Components.Schemas[t.Name()]; !ok {
+			doc.Components.Schemas[t.Name()] = &Schema{} // placeholder for self referencing types
+			doc.Components.Schemas[t.Name()] = doc.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	default:
+		return &Schema{}
+	}
+}
+
+// object is a helper function building the schema of a struct from its exported fields
+func (doc *Document) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, omitempty := jsonName(field)
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" {
+			embedded := doc.object(field.Type)
+			for key, value := range embedded.Properties {
+				s.Properties[key] = value
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := doc.schema(field.Type)
+		if required := applyValidation(property, field); required && !omitempty {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = property
+	}
+	return s
+}
+
+// jsonName is a helper function returning the JSON name of a field and whether it is omitempty
+func jsonName(field reflect.StructField) (string, bool) {
+	parts := strings.Split(field.Tag.Get("json"), ",")
+	for _, option := range parts[1:] {
+		if option == "omitempty" {
+			return parts[0], true
+		}
+	}
+	return parts[0], false
+}
+
+// applyValidation is a helper function adding the constraints of the validate tag of a
+// field to its schema and reporting whether the field is required
+func applyValidation(s *Schema, field reflect.StructField) bool {
+	required := false
+	for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+		tag, param, _ := strings.Cut(rule, "=")
+		switch tag {
+		case "required":
+			required = true
+		case "email":
+			s.Format = "email"
+		case "oneof":
+			s.Enum = strings.Fields(param)
+		case "min", "max", "len":
+			n, err := strconv.Atoi(param)
+			if err != nil || s.Ref != "" {
+				continue
+			}
+			limit(s, tag, n)
+		}
+	}
+	return required
+}
+
+// limit is a helper function applying a min, max or len rule to strings, numbers or arrays
+func limit(s *Schema, tag string, n int) {
+	switch s.Type {
+	case "string":
+		if tag != "max" {
+			s.MinLength = &n
+		}
+		if tag != "min" {
+			s.MaxLength = &n
+		}
+	case "integer", "number":
+		f := float64(n)
+		if tag != "max" {
+			s.Minimum = &f
+		}
+		if tag != "min" {
+			s.Maximum = &f
+		}
+	}
+}
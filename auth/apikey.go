@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thanhpk/randstr"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// APIKeyPrefix starts every API key, so keys are told apart from session tokens and can be
+// found by secret scanners
+const APIKeyPrefix = "dbk_"
+
+// APIKeyUsedEvery is how often the last use of an API key is written, keys of busy
+// integrations would otherwise write on every request
+const APIKeyUsedEvery = time.Minute
+
+// API key scopes. A write scope includes the read scope of the same resource
+const (
+	ScopeBookingsRead  = "bookings:read"
+	ScopeBookingsWrite = "bookings:write"
+	ScopeCowsRead      = "cows:read"
+	ScopeCowsWrite     = "cows:write"
+	ScopeDevicesRead   = "devices:read"
+	ScopeDevicesWrite  = "devices:write"
+)
+
+// Scopes lists every scope an API key can have
+var Scopes = []string{ScopeBookingsRead, ScopeBookingsWrite, ScopeCowsRead, ScopeCowsWrite, ScopeDevicesRead, ScopeDevicesWrite}
+
+// NewAPIKey returns a random API key, the prefix that identifies it and the hash to store
+// in its place. The key is "dbk_<8 hex>_<64 hex>" and the prefix is "dbk_<8 hex>"
+func NewAPIKey() (key, prefix, hash string) {
+	prefix = APIKeyPrefix + randstr.Hex(4)
+	key = prefix + "_" + randstr.Hex(32)
+	return key, prefix, HashToken(key)
+}
+
+// IsAPIKey reports whether a bearer token is an API key rather than a session token
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+// ParseAPIKey returns the prefix of an API key, used to look it up
+func ParseAPIKey(token string) (string, error) {
+	prefix, secret, ok := strings.Cut(strings.TrimPrefix(token, APIKeyPrefix), "_")
+	if !ok || len(prefix) != 8 || secret == "" {
+		return "", ErrInvalidToken
+	}
+	return APIKeyPrefix + prefix, nil
+}
+
+// CheckAPIKey verifies that token is the unexpired key
+func CheckAPIKey(key *models.APIKey, token string, now time.Time) error {
+	if subtle.ConstantTimeCompare([]byte(key.Details.Hash), []byte(HashToken(token))) != 1 {
+		return ErrInvalidToken
+	}
+	if !key.Details.ExpiresAt.IsZero() && !now.Before(key.Details.ExpiresAt) {
+		return fmt.Errorf("%w: the API key expired on %s", ErrInvalidToken, key.Details.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ValidScopes returns an error naming the first scope that doesn't exist
+func ValidScopes(scopes []string) error {
+	for _, scope := range scopes {
+		found := false
+		for _, known := range Scopes {
+			found = found || scope == known
+		}
+		if !found {
+			return fmt.Errorf("unknown scope %q, use one of %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+	return nil
+}
+
+// HasScope reports whether an API key may do what scope allows
+func HasScope(key *models.APIKey, scope string) bool {
+	resource, _, _ := strings.Cut(scope, ":")
+	for _, granted := range key.Details.Scopes {
+		if granted == scope || granted == resource+":write" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,234 @@
+// Package auth holds the building blocks of signing in: session and reset tokens, password
+// rules and the emails sent about them. Tokens are random secrets, only their SHA-256 hashes
+// are stored on the user record
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/thanhpk/randstr"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// ResetTokenTTL is how long a forgot password link can be used
+const ResetTokenTTL = time.Hour
+
+// maxPasswordLength is the most bcrypt reads, anything after it would be ignored
+const maxPasswordLength = 72
+
+var (
+	// ErrInvalidToken is returned for malformed, unknown and expired tokens
+	ErrInvalidToken = errors.New("invalid or expired token")
+
+	// ErrWeakPassword is returned by ValidatePassword, the message says what is missing
+	ErrWeakPassword = errors.New("the password must be 10 to 72 characters with an upper and lower case letter and one of " + specialCharacters)
+)
+
+// specialCharacters matches the set required by models.User.CheckPasswordStrength
+const specialCharacters = "!@#$%&*?,.`~"
+
+// NewToken returns a random token and the hash to store in its place
+func NewToken() (token, hash string) {
+	token = randstr.Hex(32)
+	return token, HashToken(token)
+}
+
+// HashToken returns the stored form of a token
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSession creates a session for userID signing in with request r. The returned bearer
+// token is "<user id>.<secret>", so the user can be loaded before the secret is checked
+func NewSession(userID models.ID, r *http.Request, ttl time.Duration, passwordChange bool) (string, models.Session) {
+	secret, hash := NewToken()
+	now := time.Now().UTC()
+	session := models.Session{
+		ID:             randstr.Hex(8),
+		TokenHash:      hash,
+		PasswordChange: passwordChange,
+		IP:             ClientIP(r),
+		UserAgent:      r.UserAgent(),
+		CreatedAt:      now,
+		ExpiresAt:      now.Add(ttl),
+	}
+	return userID.String() + "." + secret, session
+}
+
+// ParseSessionToken splits a bearer token into the user ID and the secret
+func ParseSessionToken(token string) (models.ID, string, error) {
+	userID, secret, ok := strings.Cut(token, ".")
+	if !ok || secret == "" {
+		return "", "", ErrInvalidToken
+	}
+	id, err := models.ParseID(userID)
+	if err != nil {
+		return "", "", ErrInvalidToken
+	}
+	return id, secret, nil
+}
+
+// FindSession returns the unexpired session of user with the secret
+func FindSession(user *models.User, secret string, now time.Time) (*models.Session, error) {
+	hash := HashToken(secret)
+	for i, session := range user.Details.Sessions {
+		if subtle.ConstantTimeCompare([]byte(session.TokenHash), []byte(hash)) == 1 {
+			if !now.Before(session.ExpiresAt) {
+				return nil, ErrInvalidToken
+			}
+			return &user.Details.Sessions[i], nil
+		}
+	}
+	return nil, ErrInvalidToken
+}
+
+// ActiveSessions returns the sessions that have not expired, expired ones are dropped
+// whenever the sessions of a user are rewritten
+func ActiveSessions(sessions []models.Session, now time.Time) []models.Session {
+	active := make([]models.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if now.Before(session.ExpiresAt) {
+			active = append(active, session)
+		}
+	}
+	return active
+}
+
+// WithoutSession returns the sessions except the one with the ID
+func WithoutSession(sessions []models.Session, id string) []models.Session {
+	rest := make([]models.Session, 0, len(sessions))
+	for _, session := range sessions {
+		if session.ID != id {
+			rest = append(rest, session)
+		}
+	}
+	return rest
+}
+
+// ClientIP returns the address a request came from
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// ValidatePassword checks a new password against models.User.CheckPasswordStrength
+func ValidatePassword(password string) error {
+	var u models.User
+	if len(password) < 10 || len(password) > maxPasswordLength || !u.CheckPasswordStrength(password) {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// TemporaryPassword generates a password that passes ValidatePassword
+func TemporaryPassword() string {
+	var u models.User
+	for {
+		password := u.GeneratePassword(14, 2, 2, 2)
+		if ValidatePassword(password) == nil {
+			return password
+		}
+	}
+}
+
+// TemporaryPasswordEmail tells a user an admin reset their password
+func TemporaryPasswordEmail(user *models.User, password string) mailer.Message {
+	return mailer.Message{
+		To:      user.Details.Email,
+		Subject: "Your DeviceBooking password was reset",
+		Body: fmt.Sprintf(`Hi %s,
+
+An administrator reset your DeviceBooking password. Sign in with this temporary password,
+you will be asked to choose a new one:
+
+    %s
+
+`, user.Details.FirstName, password),
+	}
+}
+
+// WelcomeEmail gives a user an admin created their temporary password
+func WelcomeEmail(user *models.User, password string) mailer.Message {
+	return mailer.Message{
+		To:      user.Details.Email,
+		Subject: "Your DeviceBooking account",
+		Body: fmt.Sprintf(`Hi %s,
+
+An administrator created a DeviceBooking account for you. Sign in with your email and this
+temporary password, you will be asked to choose a new one:
+
+    %s
+
+`, user.Details.FirstName, password),
+	}
+}
+
+// ResetPasswordEmail sends the link of a forgot password request
+func ResetPasswordEmail(user *models.User, baseURL, token string) mailer.Message {
+	return mailer.Message{
+		To:      user.Details.Email,
+		Subject: "Reset your DeviceBooking password",
+		Body: fmt.Sprintf(`Hi %s,
+
+Someone asked to reset your DeviceBooking password. Choose a new one within %s using this link:
+
+    %s/reset-password?token=%s
+
+If it wasn't you, ignore this email and your password stays the same.
+`, user.Details.FirstName, durationText(ResetTokenTTL), strings.TrimRight(baseURL, "/"), token),
+	}
+}
+
+// InvitationEmail sends the signup link of an invitation, valid for ttl
+func InvitationEmail(invitation *models.Invitation, baseURL, token string, ttl time.Duration) mailer.Message {
+	greeting := "Hi"
+	if invitation.Details.FirstName != "" {
+		greeting += " " + invitation.Details.FirstName
+	}
+	return mailer.Message{
+		To:      invitation.Details.Email,
+		Subject: "You're invited to DeviceBooking",
+		Body: fmt.Sprintf(`%s,
+
+You were invited to book devices with DeviceBooking. Choose a password to create your
+account within %s using this link:
+
+    %s
+
+If you weren't expecting it, ignore this email.
+`, greeting, durationText(ttl), SignupURL(baseURL, token)),
+	}
+}
+
+// SignupURL returns the link completing an invitation
+func SignupURL(baseURL, token string) string {
+	return fmt.Sprintf("%s/signup?token=%s", strings.TrimRight(baseURL, "/"), token)
+}
+
+// durationText is a helper function writing short durations for emails, eg. "1 hour"
+func durationText(d time.Duration) string {
+	if d%(24*time.Hour) == 0 && d > 24*time.Hour {
+		return fmt.Sprintf("%d days", d/(24*time.Hour))
+	}
+	if d%time.Hour == 0 {
+		if d == time.Hour {
+			return "1 hour"
+		}
+		return fmt.Sprintf("%d hours", d/time.Hour)
+	}
+	return fmt.Sprintf("%d minutes", d/time.Minute)
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// BookingRequestEmail asks an approver of a cow to approve or reject a booking request
+func BookingRequestEmail(approver *models.User, cowName string, request *models.BookingRequest, baseURL string) mailer.Message {
+	b := request.Details.Booking
+	return mailer.Message{
+		To:      approver.Details.Email,
+		Subject: fmt.Sprintf("Booking of %s waiting for your approval", cowName),
+		Body: fmt.Sprintf(`Hi %s,
+
+%s asked to book %s:
+
+    block %s, %s to %s
+
+Approve or reject it before %s using this link:
+
+    %s
+
+`, approver.Details.FirstName, b.Author, cowName, b.Block, bookingTime(b.StartDate.Time()), bookingTime(b.EndDate.Time()),
+			bookingTime(request.Details.ExpiresAt), BookingRequestURL(baseURL, request.ID)),
+	}
+}
+
+// BookingDecisionEmail tells the requester their booking request was approved, rejected
+// or expired without a decision
+func BookingDecisionEmail(requester *models.User, cowName string, request *models.BookingRequest) mailer.Message {
+	b := request.Details.Booking
+	var outcome string
+	switch request.Details.Status {
+	case models.BookingApproved:
+		outcome = "was approved, the cart is yours"
+	case models.BookingRejected:
+		outcome = "was rejected"
+	default:
+		outcome = "expired before anyone approved it, book again or ask an approver"
+	}
+	reason := ""
+	if request.Details.Reason != "" {
+		reason = fmt.Sprintf("\nThe approver said:\n\n    %s\n", request.Details.Reason)
+	}
+	return mailer.Message{
+		To:      requester.Details.Email,
+		Subject: fmt.Sprintf("Your booking of %s %s", cowName, request.Details.Status),
+		Body: fmt.Sprintf(`Hi %s,
+
+Your booking of %s for block %s, %s to %s, %s.
+%s`, requester.Details.FirstName, cowName, b.Block, bookingTime(b.StartDate.Time()), bookingTime(b.EndDate.Time()), outcome, reason),
+	}
+}
+
+// WaitlistOfferEmail offers a user on the waitlist of a block the booking they queued for
+func WaitlistOfferEmail(user *models.User, cowName string, entry *models.WaitlistEntry, baseURL string) mailer.Message {
+	b := entry.Details.Booking
+	return mailer.Message{
+		To:      user.Details.Email,
+		Subject: fmt.Sprintf("%s is free for block %s", cowName, b.Block),
+		Body: fmt.Sprintf(`Hi %s,
+
+A booking of %s was cancelled and you are next on its waitlist:
+
+    block %s, %s to %s
+
+It is held for you until %s, after that it is offered to the next person. Accept it using
+this link:
+
+    %s
+
+`, user.Details.FirstName, cowName, b.Block, bookingTime(b.StartDate.Time()), bookingTime(b.EndDate.Time()),
+			bookingTime(entry.Details.OfferExpiresAt), WaitlistEntryURL(baseURL, entry.ID)),
+	}
+}
+
+// WaitlistEntryURL returns the link to a waitlist entry
+func WaitlistEntryURL(baseURL string, id models.ID) string {
+	return fmt.Sprintf("%s/waitlist/%s", strings.TrimRight(baseURL, "/"), id)
+}
+
+// BookingRequestURL returns the link to a booking request
+func BookingRequestURL(baseURL string, id models.ID) string {
+	return fmt.Sprintf("%s/booking-requests/%s", strings.TrimRight(baseURL, "/"), id)
+}
+
+// bookingTime is a helper function writing the times of a booking for emails
+func bookingTime(t time.Time) string {
+	return t.UTC().Format("Mon 2 Jan 2006 15:04 MST")
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// MaxKnownDevices is how many browsers are remembered per user, the oldest are forgotten
+const MaxKnownDevices = 20
+
+// DeviceHash returns what is remembered of the browser of a session. It is the user agent,
+// so a new browser or a new computer counts as a new device but a new address doesn't
+func DeviceHash(session models.Session) string {
+	return HashToken(session.UserAgent)
+}
+
+// RememberDevice returns known with device added, reporting whether it was new
+func RememberDevice(known []string, device string) ([]string, bool) {
+	for _, hash := range known {
+		if hash == device {
+			return known, false
+		}
+	}
+	known = append(append([]string{}, known...), device)
+	if len(known) > MaxKnownDevices {
+		known = known[len(known)-MaxKnownDevices:]
+	}
+	return known, true
+}
+
+// NewDeviceEmail tells a user someone signed in to their account from a new device
+func NewDeviceEmail(user *models.User, session models.Session) mailer.Message {
+	device := session.UserAgent
+	if device == "" {
+		device = "an unknown browser"
+	}
+	return mailer.Message{
+		To:      user.Details.Email,
+		Subject: "New sign in to your DeviceBooking account",
+		Body: fmt.Sprintf(`Hi %s,
+
+Your DeviceBooking account was signed in to from a device it hasn't seen before:
+
+    %s
+    from %s on %s
+
+If it was you, there is nothing to do. If it wasn't, change your password and sign out
+the sessions you don't recognize.
+`, user.Details.FirstName, device, session.IP, session.CreatedAt.UTC().Format(time.RFC1123)),
+	}
+}
@@ -0,0 +1,388 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// LDAPProviderPrefix is prepended to the name of an LDAP provider in models.UserDetails.AuthProvider
+const LDAPProviderPrefix = "ldap:"
+
+// ErrInvalidCredentials is returned by LDAPProvider.Authenticate for unknown users and wrong passwords
+var ErrInvalidCredentials = errors.New("wrong email or password")
+
+// ldapTimeout bounds every connection to a directory
+const ldapTimeout = 10 * time.Second
+
+// inChainRule is the Active Directory matching rule that follows nested group membership
+const inChainRule = "1.2.840.113556.1.4.1941"
+
+// LDAPProvider signs in the users of one business with their directory password: the
+// service account finds the user by email, then the password is checked by binding as them.
+// Sync keeps the accounts it created in step with the directory groups
+type LDAPProvider struct {
+	config.LDAPProvider
+	SyncEvery time.Duration // 0 when the scheduled sync is off
+}
+
+// LDAPSyncResult counts what a sync changed
+type LDAPSyncResult struct {
+	Created  int `json:"created"`
+	Updated  int `json:"updated"`  // role or name changed
+	Enabled  int `json:"enabled"`  // back in a UserGroups group
+	Disabled int `json:"disabled"` // gone from the directory or its groups
+	Skipped  int `json:"skipped"`  // local accounts, or emails outside Domains
+}
+
+// NewLDAPProviders checks the configured directories and returns them by name
+func NewLDAPProviders(providers []config.LDAPProvider) (map[string]*LDAPProvider, error) {
+	out := make(map[string]*LDAPProvider, len(providers))
+	domains := map[string]string{}
+	for _, p := range providers {
+		switch {
+		case p.Name == "":
+			return nil, errors.New("an LDAP provider needs a name")
+		case out[p.Name] != nil:
+			return nil, fmt.Errorf("LDAP provider %q is configured twice", p.Name)
+		case p.URL == "" || p.BaseDN == "":
+			return nil, fmt.Errorf("LDAP provider %q needs a url and a baseDn", p.Name)
+		case p.Business == "":
+			return nil, fmt.Errorf("LDAP provider %q needs a business", p.Name)
+		case len(p.Domains) == 0:
+			return nil, fmt.Errorf("LDAP provider %q needs at least one email domain", p.Name)
+		}
+		// the domain of an email picks the directory a new user signs in with
+		for _, domain := range p.Domains {
+			domain = strings.ToLower(domain)
+			if other, ok := domains[domain]; ok {
+				return nil, fmt.Errorf("LDAP providers %q and %q both have the domain %s", other, p.Name, domain)
+			}
+			domains[domain] = p.Name
+		}
+		if p.UserFilter == "" {
+			p.UserFilter = "(objectClass=person)"
+		}
+		if _, err := ldap.CompileFilter(p.UserFilter); err != nil {
+			return nil, fmt.Errorf("LDAP provider %q has an invalid userFilter: %w", p.Name, err)
+		}
+		if p.EmailAttribute == "" {
+			p.EmailAttribute = "mail"
+		}
+
+		provider := &LDAPProvider{LDAPProvider: p}
+		if p.SyncInterval != "" {
+			every, err := time.ParseDuration(p.SyncInterval)
+			if err != nil || every < time.Minute {
+				return nil, fmt.Errorf("LDAP provider %q needs a syncInterval of at least 1m, eg. \"1h\"", p.Name)
+			}
+			provider.SyncEvery = every
+		}
+		out[p.Name] = provider
+	}
+	return out, nil
+}
+
+// Key returns the models.UserDetails.AuthProvider of the users the provider creates
+func (p *LDAPProvider) Key() string { return LDAPProviderPrefix + p.Name }
+
+// AllowsEmail reports whether email is in one of the provider's domains
+func (p *LDAPProvider) AllowsEmail(email string) bool { return emailInDomains(email, p.Domains) }
+
+// connect is a helper function dialing the directory and binding as the service account
+func (p *LDAPProvider) connect(ctx context.Context) (*ldap.Conn, error) {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP url: %w", err)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: p.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	conn, err := ldap.DialURL(p.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", p.URL, err)
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if p.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to start TLS with %s: %w", p.URL, err)
+		}
+	}
+	if p.BindDN != "" {
+		if err := conn.Bind(p.BindDN, p.BindPassword); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to bind as the service account: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate checks an email and password against the directory and returns the
+// person and their role. Users outside UserGroups are ErrSSODenied
+func (p *LDAPProvider) Authenticate(ctx context.Context, email, password string) (*Identity, int, error) {
+	// an empty password is an unauthenticated bind, which many directories accept
+	if password == "" || !p.AllowsEmail(email) {
+		return nil, 0, ErrInvalidCredentials
+	}
+	conn, err := p.connect(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	filter := fmt.Sprintf("(&%s(%s=%s))", p.UserFilter, p.EmailAttribute, ldap.EscapeFilter(email))
+	result, err := conn.Search(p.searchRequest(p.BaseDN, ldap.ScopeWholeSubtree, filter))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to search for the user: %w", err)
+	}
+	switch len(result.Entries) {
+	case 0:
+		return nil, 0, ErrInvalidCredentials
+	case 1:
+	default:
+		return nil, 0, fmt.Errorf("%d directory entries have the email %s", len(result.Entries), email)
+	}
+	entry := result.Entries[0]
+
+	// groups are read as the service account, the bind below switches to the user
+	allowed, err := p.member(conn, entry.DN, p.UserGroups)
+	if err != nil {
+		return nil, 0, err
+	}
+	admin, err := p.member(conn, entry.DN, p.AdminGroups)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, 0, ErrInvalidCredentials
+		}
+		return nil, 0, fmt.Errorf("failed to bind as the user: %w", err)
+	}
+	if !allowed && len(p.UserGroups) > 0 {
+		return nil, 0, fmt.Errorf("%w: not a member of the directory groups that may sign in", ErrSSODenied)
+	}
+
+	userType := models.TypeUser
+	if admin {
+		userType = models.TypeAdmin
+	}
+	return p.identity(entry), userType, nil
+}
+
+// Sync creates the members of UserGroups that have no account, updates the role and name
+// of the accounts the provider created, and disables those no longer in the directory.
+// Local accounts and accounts of other providers are left alone
+func (p *LDAPProvider) Sync(ctx context.Context, users databases.UserDatabase) (LDAPSyncResult, error) {
+	var result LDAPSyncResult
+	conn, err := p.connect(ctx)
+	if err != nil {
+		return result, err
+	}
+	defer conn.Close()
+
+	people := fmt.Sprintf("(&%s(%s=*)", p.UserFilter, p.EmailAttribute)
+	members, err := p.searchAll(conn, people+p.groupFilter(p.UserGroups)+")")
+	if err != nil {
+		return result, err
+	}
+	admins := map[string]bool{}
+	if len(p.AdminGroups) > 0 {
+		entries, err := p.searchAll(conn, people+p.groupFilter(p.AdminGroups)+")")
+		if err != nil {
+			return result, err
+		}
+		for _, entry := range entries {
+			admins[strings.ToLower(entry.DN)] = true
+		}
+	}
+
+	managed, err := users.Find(ctx, databases.FilterUsers().AuthProvider(p.Key()))
+	if err != nil {
+		return result, err
+	}
+	// an empty answer is more likely a wrong filter or group than everyone leaving
+	if len(members) == 0 && len(managed) > 0 {
+		return result, fmt.Errorf("the directory returned no members, not disabling %d accounts", len(managed))
+	}
+	accounts := make(map[string]*models.User, len(managed))
+	for i := range managed {
+		accounts[managed[i].Details.Email] = &managed[i]
+	}
+
+	now := time.Now().UTC()
+	seen := map[string]bool{}
+	for _, entry := range members {
+		identity := p.identity(entry)
+		if !p.AllowsEmail(identity.Email) || seen[identity.Email] {
+			result.Skipped++
+			continue
+		}
+		seen[identity.Email] = true
+		userType := models.TypeUser
+		if admins[strings.ToLower(entry.DN)] {
+			userType = models.TypeAdmin
+		}
+
+		user, ok := accounts[identity.Email]
+		if !ok {
+			_, err := users.FindOne(ctx, databases.FilterUsers().Email(identity.Email))
+			if err == nil {
+				result.Skipped++ // a local account or one of another provider
+				continue
+			}
+			if !errors.Is(err, databases.ErrNotFound) {
+				return result, err
+			}
+			if _, err := Provision(ctx, users, p.Key(), p.Business, identity, userType); err != nil {
+				return result, err
+			}
+			result.Created++
+			continue
+		}
+
+		update := databases.UpdateUser()
+		changed := false
+		if user.Details.Disabled {
+			update.SetDisabled(false)
+			result.Enabled++
+			changed = true
+		}
+		if user.Details.UserType != userType && user.Details.UserType != models.TypeSuperUser {
+			update.SetUserType(userType)
+			result.Updated++
+			changed = true
+		} else if user.Details.FirstName != identity.FirstName || user.Details.LastName != identity.LastName {
+			result.Updated++
+			changed = true
+		}
+		if changed {
+			update.SetFirstName(identity.FirstName).SetLastName(identity.LastName).SetUpdatedAt(now)
+			if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	for email, user := range accounts {
+		if seen[email] || user.Details.Disabled {
+			continue
+		}
+		update := databases.UpdateUser().SetDisabled(true).SetSessions(nil).SetUpdatedAt(now)
+		if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+			return result, err
+		}
+		result.Disabled++
+	}
+	return result, nil
+}
+
+// RunSync calls Sync every SyncEvery until ctx is done, starting now
+func (p *LDAPProvider) RunSync(ctx context.Context, users databases.UserDatabase) {
+	if p.SyncEvery == 0 {
+		return
+	}
+	ticker := time.NewTicker(p.SyncEvery)
+	defer ticker.Stop()
+	for {
+		syncCtx, cancel := context.WithTimeout(ctx, p.SyncEvery)
+		result, err := p.Sync(syncCtx, users)
+		cancel()
+		if err != nil {
+			zap.S().With("error", err).Errorw("LDAP sync failed", "provider", p.Name)
+		} else {
+			zap.S().Infow("LDAP sync finished", "provider", p.Name, "created", result.Created, "updated", result.Updated,
+				"enabled", result.Enabled, "disabled", result.Disabled, "skipped", result.Skipped)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// member is a helper function reporting whether the entry dn is in one of groups
+func (p *LDAPProvider) member(conn *ldap.Conn, dn string, groups []string) (bool, error) {
+	if len(groups) == 0 {
+		return false, nil
+	}
+	result, err := conn.Search(p.searchRequest(dn, ldap.ScopeBaseObject, p.groupFilter(groups)))
+	if err != nil {
+		return false, fmt.Errorf("failed to read the groups of %s: %w", dn, err)
+	}
+	return len(result.Entries) > 0, nil
+}
+
+// groupFilter is a helper function matching members of any of groups, "" for no groups
+func (p *LDAPProvider) groupFilter(groups []string) string {
+	if len(groups) == 0 {
+		return ""
+	}
+	attribute := "memberOf"
+	if p.NestedGroups {
+		attribute += ":" + inChainRule + ":"
+	}
+	var b strings.Builder
+	b.WriteString("(|")
+	for _, group := range groups {
+		fmt.Fprintf(&b, "(%s=%s)", attribute, ldap.EscapeFilter(group))
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+// searchAll is a helper function returning every person matching filter, a page at a time
+// since Active Directory caps results at 1000
+func (p *LDAPProvider) searchAll(conn *ldap.Conn, filter string) ([]*ldap.Entry, error) {
+	result, err := conn.SearchWithPaging(p.searchRequest(p.BaseDN, ldap.ScopeWholeSubtree, filter), 500)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search the directory: %w", err)
+	}
+	return result.Entries, nil
+}
+
+// searchRequest is a helper function building a search for the attributes of a person
+func (p *LDAPProvider) searchRequest(base string, scope int, filter string) *ldap.SearchRequest {
+	return ldap.NewSearchRequest(base, scope, ldap.NeverDerefAliases, 0, int(ldapTimeout/time.Second), false,
+		filter, []string{p.EmailAttribute, "givenName", "sn", "displayName"}, nil)
+}
+
+// identity is a helper function reading a person from their entry
+func (p *LDAPProvider) identity(entry *ldap.Entry) *Identity {
+	identity := &Identity{
+		Subject:   entry.DN,
+		Email:     strings.ToLower(strings.TrimSpace(entry.GetAttributeValue(p.EmailAttribute))),
+		FirstName: strings.TrimSpace(entry.GetAttributeValue("givenName")),
+		LastName:  strings.TrimSpace(entry.GetAttributeValue("sn")),
+	}
+	if identity.FirstName == "" && identity.LastName == "" {
+		identity.FirstName, identity.LastName, _ = strings.Cut(strings.TrimSpace(entry.GetAttributeValue("displayName")), " ")
+	}
+	if identity.FirstName == "" {
+		identity.FirstName, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if identity.LastName == "" {
+		identity.LastName = "-"
+	}
+	return identity
+}
@@ -0,0 +1,401 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
+)
+
+const (
+	testBaseDN      = "dc=school,dc=example"
+	testServiceDN   = "cn=svc," + testBaseDN
+	testServicePass = "service-secret"
+	testStaffGroup  = "cn=staff,ou=groups," + testBaseDN
+	testAdminGroup  = "cn=admins,ou=groups," + testBaseDN
+)
+
+// LDAP protocol operations, RFC 4511 section 4.2
+const (
+	opBindRequest   = 0
+	opBindResponse  = 1
+	opUnbindRequest = 2
+	opSearchRequest = 3
+	opSearchEntry   = 4
+	opSearchDone    = 5
+	resultSuccess   = 0
+	resultBadCreds  = 49
+	resultUnwilling = 53
+	filterAnd       = 0
+	filterOr        = 1
+	filterNot       = 2
+	filterEquality  = 3
+	filterPresent   = 7
+	scopeBaseObject = 0
+)
+
+// fakeDirectory is an in-process LDAP server answering the binds and searches of
+// LDAPProvider from a map of entries the test changes between calls
+type fakeDirectory struct {
+	listener net.Listener
+
+	mu      sync.Mutex
+	entries map[string]*fakeEntry // by lower case DN
+}
+
+// fakeEntry is a person in the directory, memberOf lists the DNs of their groups
+type fakeEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string // by lower case attribute name
+}
+
+func newFakeDirectory(t *testing.T) *fakeDirectory {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	d := &fakeDirectory{listener: listener, entries: map[string]*fakeEntry{}}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go d.serve(conn)
+		}
+	}()
+	return d
+}
+
+func (d *fakeDirectory) URL() string { return "ldap://" + d.listener.Addr().String() }
+
+// add puts a person under the base DN, replacing one with the same uid
+func (d *fakeDirectory) add(uid, email, password string, groups ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dn := "uid=" + uid + ",ou=people," + testBaseDN
+	d.entries[strings.ToLower(dn)] = &fakeEntry{dn: dn, password: password, attrs: map[string][]string{
+		"objectclass": {"person"},
+		"mail":        {email},
+		"givenname":   {strings.ToUpper(uid[:1]) + uid[1:]},
+		"sn":          {"Teacher"},
+		"memberof":    groups,
+	}}
+}
+
+// setGroups replaces the groups of a person
+func (d *fakeDirectory) setGroups(uid string, groups ...string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[strings.ToLower("uid="+uid+",ou=people,"+testBaseDN)].attrs["memberof"] = groups
+}
+
+func (d *fakeDirectory) serve(conn net.Conn) {
+	defer conn.Close()
+	bound := false
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, op := packet.Children[0].Value, packet.Children[1]
+		switch op.Tag {
+		case opBindRequest:
+			code := d.bind(op.Children[1].Value.(string), op.Children[2].Data.String())
+			bound = code == resultSuccess
+			conn.Write(response(id, ldapResult(opBindResponse, code)).Bytes())
+		case opSearchRequest:
+			if !bound {
+				conn.Write(response(id, ldapResult(opSearchDone, resultUnwilling)).Bytes())
+				continue
+			}
+			for _, entry := range d.search(op) {
+				conn.Write(response(id, entry).Bytes())
+			}
+			conn.Write(response(id, ldapResult(opSearchDone, resultSuccess)).Bytes())
+		case opUnbindRequest:
+			return
+		default:
+			return
+		}
+	}
+}
+
+// bind checks the password of the service account or a person
+func (d *fakeDirectory) bind(dn, password string) int64 {
+	if strings.EqualFold(dn, testServiceDN) {
+		if password == testServicePass {
+			return resultSuccess
+		}
+		return resultBadCreds
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	entry, ok := d.entries[strings.ToLower(dn)]
+	if !ok || password == "" || entry.password != password {
+		return resultBadCreds
+	}
+	return resultSuccess
+}
+
+// search returns the entries of a search request as SearchResultEntry operations
+func (d *fakeDirectory) search(op *ber.Packet) []*ber.Packet {
+	base := strings.ToLower(op.Children[0].Value.(string))
+	scope := op.Children[1].Value.(int64)
+	filter := op.Children[6]
+	var wanted []string
+	for _, attr := range op.Children[7].Children {
+		wanted = append(wanted, attr.Value.(string))
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var out []*ber.Packet
+	for dn, entry := range d.entries {
+		if scope == scopeBaseObject && dn != base || scope != scopeBaseObject && !strings.HasSuffix(dn, base) {
+			continue
+		}
+		if !matches(entry, filter) {
+			continue
+		}
+		result := ber.Encode(ber.ClassApplication, ber.TypeConstructed, opSearchEntry, nil, "")
+		result.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+		attrs := ber.NewSequence("")
+		for _, name := range wanted {
+			values, ok := entry.attrs[strings.ToLower(name)]
+			if !ok {
+				continue
+			}
+			attr := ber.NewSequence("")
+			attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+			set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+			for _, value := range values {
+				set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+			}
+			attr.AppendChild(set)
+			attrs.AppendChild(attr)
+		}
+		result.AppendChild(attrs)
+		out = append(out, result)
+	}
+	return out
+}
+
+// matches evaluates the and, or, not, equality and present filters LDAPProvider sends
+func matches(entry *fakeEntry, filter *ber.Packet) bool {
+	switch filter.Tag {
+	case filterAnd:
+		for _, child := range filter.Children {
+			if !matches(entry, child) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, child := range filter.Children {
+			if matches(entry, child) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !matches(entry, filter.Children[0])
+	case filterEquality:
+		name, value := filter.Children[0].Value.(string), filter.Children[1].Value.(string)
+		for _, have := range entry.attrs[strings.ToLower(name)] {
+			if strings.EqualFold(have, value) {
+				return true
+			}
+		}
+		return false
+	case filterPresent:
+		return len(entry.attrs[strings.ToLower(filter.Data.String())]) > 0
+	}
+	return false
+}
+
+// response is a helper function wrapping an operation in an LDAPMessage
+func response(id interface{}, op *ber.Packet) *ber.Packet {
+	message := ber.NewSequence("")
+	message.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	message.AppendChild(op)
+	return message
+}
+
+// ldapResult is a helper function building a response operation with only a result code
+func ldapResult(tag ber.Tag, code int64) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return op
+}
+
+// newTestLDAPProvider is a helper function returning a provider of the directory for business
+func newTestLDAPProvider(t *testing.T, d *fakeDirectory, business models.ID) *LDAPProvider {
+	t.Helper()
+	providers, err := NewLDAPProviders([]config.LDAPProvider{{
+		Name:         "school-ad",
+		Business:     business,
+		URL:          d.URL(),
+		BindDN:       testServiceDN,
+		BindPassword: testServicePass,
+		BaseDN:       testBaseDN,
+		Domains:      []string{"school.example"},
+		UserGroups:   []string{testStaffGroup},
+		AdminGroups:  []string{testAdminGroup},
+	}})
+	if err != nil {
+		t.Fatalf("NewLDAPProviders: %v", err)
+	}
+	return providers["school-ad"]
+}
+
+// newTestUsers is a helper function returning the users of a migrated in-memory SQLite store
+func newTestUsers(t *testing.T) databases.UserDatabase {
+	t.Helper()
+	store, err := databases.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close(context.Background()) })
+	if _, err := store.Migrator().Up(context.Background(), 0); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return store.Users()
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	d := newFakeDirectory(t)
+	d.add("alice", "alice@school.example", "alice-secret", testStaffGroup, testAdminGroup)
+	d.add("bob", "bob@school.example", "bob-secret", testStaffGroup)
+	d.add("carol", "carol@school.example", "carol-secret")
+	p := newTestLDAPProvider(t, d, models.NewID())
+
+	identity, userType, err := p.Authenticate(ctx, "alice@school.example", "alice-secret")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if identity.Email != "alice@school.example" || identity.FirstName != "Alice" || userType != models.TypeAdmin {
+		t.Errorf("Authenticate returned %+v of type %d, want Alice as an Admin", identity, userType)
+	}
+	if _, userType, err := p.Authenticate(ctx, "bob@school.example", "bob-secret"); err != nil || userType != models.TypeUser {
+		t.Errorf("Authenticate of a staff member returned type %d and %v, want a User", userType, err)
+	}
+
+	for _, tc := range []struct {
+		name, email, password string
+		want                  error
+	}{
+		{"wrong password", "bob@school.example", "alice-secret", ErrInvalidCredentials},
+		{"empty password", "bob@school.example", "", ErrInvalidCredentials},
+		{"unknown email", "nobody@school.example", "bob-secret", ErrInvalidCredentials},
+		{"other domain", "bob@other.example", "bob-secret", ErrInvalidCredentials},
+		{"outside the user groups", "carol@school.example", "carol-secret", ErrSSODenied},
+	} {
+		if _, _, err := p.Authenticate(ctx, tc.email, tc.password); !errors.Is(err, tc.want) {
+			t.Errorf("%s: Authenticate returned %v, want %v", tc.name, err, tc.want)
+		}
+	}
+
+	p.BindPassword = "wrong"
+	if _, _, err := p.Authenticate(ctx, "bob@school.example", "bob-secret"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Authenticate with a wrong service account password returned %v, want a bind error", err)
+	}
+}
+
+func TestLDAPSync(t *testing.T) {
+	ctx := context.Background()
+	business := models.NewID()
+	users := newTestUsers(t)
+	d := newFakeDirectory(t)
+	d.add("alice", "alice@school.example", "", testStaffGroup, testAdminGroup)
+	d.add("bob", "bob@school.example", "", testStaffGroup)
+	d.add("carol", "carol@school.example", "")               // not allowed to sign in
+	d.add("dave", "dave@other.example", "", testStaffGroup)  // outside the domains
+	d.add("erin", "erin@school.example", "", testStaffGroup) // has a local account
+	p := newTestLDAPProvider(t, d, business)
+
+	local := util.NewUser(users, models.UserDetails{FirstName: "Erin", LastName: "Local", Email: "erin@school.example", Business: business, UserType: models.TypeUser}, "Password1!erin", false)
+	if _, err := users.InsertOne(ctx, local); err != nil {
+		t.Fatalf("failed to add a local user: %v", err)
+	}
+
+	user := func(email string) *models.User {
+		t.Helper()
+		u, err := users.FindOne(ctx, databases.FilterUsers().Email(email))
+		if err != nil {
+			t.Fatalf("failed to find %s: %v", email, err)
+		}
+		return u
+	}
+	sync := func(want LDAPSyncResult) {
+		t.Helper()
+		got, err := p.Sync(ctx, users)
+		if err != nil {
+			t.Fatalf("Sync: %v", err)
+		}
+		if got != want {
+			t.Errorf("Sync returned %+v, want %+v", got, want)
+		}
+	}
+
+	sync(LDAPSyncResult{Created: 2, Skipped: 2})
+	if alice := user("alice@school.example"); alice.Details.UserType != models.TypeAdmin || alice.Details.Business != business || alice.Details.AuthProvider != p.Key() {
+		t.Errorf("Sync created %+v, want an Admin of %s from %s", alice.Details, business, p.Key())
+	}
+	if bob := user("bob@school.example"); bob.Details.UserType != models.TypeUser {
+		t.Errorf("Sync created bob as type %d, want a User", bob.Details.UserType)
+	}
+	if _, err := users.FindOne(ctx, databases.FilterUsers().Email("carol@school.example")); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("Sync created carol, who is outside the user groups: %v", err)
+	}
+	if erin := user("erin@school.example"); erin.Details.AuthProvider != "" || erin.Details.LastName != "Local" {
+		t.Errorf("Sync changed the local account %+v", erin.Details)
+	}
+
+	// alice leaves the admins and bob the staff
+	d.setGroups("alice", testStaffGroup)
+	d.setGroups("bob")
+	sync(LDAPSyncResult{Updated: 1, Disabled: 1, Skipped: 2})
+	if alice := user("alice@school.example"); alice.Details.UserType != models.TypeUser {
+		t.Errorf("alice has type %d after leaving the admins, want a User", alice.Details.UserType)
+	}
+	if bob := user("bob@school.example"); !bob.Details.Disabled {
+		t.Error("bob is still enabled after leaving the staff")
+	}
+
+	// bob comes back as an Admin
+	d.setGroups("bob", testStaffGroup, testAdminGroup)
+	sync(LDAPSyncResult{Updated: 1, Enabled: 1, Skipped: 2})
+	if bob := user("bob@school.example"); bob.Details.Disabled || bob.Details.UserType != models.TypeAdmin {
+		t.Errorf("bob is %+v after rejoining, want an enabled Admin", bob.Details)
+	}
+	sync(LDAPSyncResult{Skipped: 2})
+
+	// a directory answering with nobody is more likely misconfigured than empty
+	for _, uid := range []string{"alice", "bob", "dave", "erin"} {
+		d.setGroups(uid)
+	}
+	if _, err := p.Sync(ctx, users); err == nil || !strings.Contains(err.Error(), "returned no members") {
+		t.Errorf("Sync of an empty directory returned %v, want the no members error", err)
+	}
+	for _, email := range []string{"alice@school.example", "bob@school.example"} {
+		if user(email).Details.Disabled {
+			t.Errorf("Sync of an empty directory disabled %s", email)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/thanhpk/randstr"
+	"golang.org/x/oauth2"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// ProviderPrefix is prepended to the name of an OIDC provider in models.UserDetails.AuthProvider
+const ProviderPrefix = "oidc:"
+
+// OIDCProvider signs in the users of one business with OpenID Connect, using the
+// authorization code flow with PKCE. The provider's discovery document is loaded on first
+// use, so the API starts even while an identity provider is down
+type OIDCProvider struct {
+	config.OIDCProvider
+	redirectURL string
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOIDCProviders checks the configured providers and returns them by name. The callback
+// of each is baseURL + "/api/v2/auth/oidc/<name>/callback", which must be registered with
+// the identity provider
+func NewOIDCProviders(providers []config.OIDCProvider, baseURL string) (map[string]*OIDCProvider, error) {
+	out := make(map[string]*OIDCProvider, len(providers))
+	if len(providers) > 0 && baseURL == "" {
+		return nil, errors.New("single sign-on needs BASE_URL for the OIDC callback URLs")
+	}
+	for _, p := range providers {
+		switch {
+		case p.Name == "" || strings.ContainsAny(p.Name, "/?#"):
+			return nil, fmt.Errorf("OIDC provider %q needs a name without /, ? or #", p.Name)
+		case out[p.Name] != nil:
+			return nil, fmt.Errorf("OIDC provider %q is configured twice", p.Name)
+		case p.Issuer == "" || p.ClientID == "":
+			return nil, fmt.Errorf("OIDC provider %q needs an issuer and a clientId", p.Name)
+		case p.Business == "":
+			return nil, fmt.Errorf("OIDC provider %q needs a business", p.Name)
+		case len(p.Domains) == 0:
+			return nil, fmt.Errorf("OIDC provider %q needs at least one email domain", p.Name)
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		if p.GroupsClaim == "" {
+			p.GroupsClaim = "groups"
+		}
+		out[p.Name] = &OIDCProvider{
+			OIDCProvider: p,
+			redirectURL:  strings.TrimRight(baseURL, "/") + "/api/v2/auth/oidc/" + p.Name + "/callback",
+		}
+	}
+	return out, nil
+}
+
+// Key returns the models.UserDetails.AuthProvider of the users the provider creates
+func (p *OIDCProvider) Key() string { return ProviderPrefix + p.Name }
+
+// discover is a helper function loading the discovery document once it is reachable
+func (p *OIDCProvider) discover(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, p.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load the discovery document of %s: %w", p.Issuer, err)
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.ClientID,
+		ClientSecret: p.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  p.redirectURL,
+		Scopes:       append([]string{oidc.ScopeOpenID, "email", "profile"}, p.Scopes...),
+	}
+	p.verifier = provider.Verifier(&oidc.Config{ClientID: p.ClientID})
+	return p.oauth, p.verifier, nil
+}
+
+// OIDCLogin is the state of a login between the redirect to the provider and the
+// callback, kept in a cookie by the caller
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string // PKCE code verifier
+}
+
+// NewOIDCLogin returns random state, nonce and PKCE verifier for a login
+func NewOIDCLogin() OIDCLogin {
+	return OIDCLogin{State: randstr.Hex(16), Nonce: randstr.Hex(16), Verifier: randstr.Hex(32)}
+}
+
+// AuthCodeURL returns the page of the provider to send the browser to
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, login OIDCLogin) (string, error) {
+	conf, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(login.Verifier))
+	return conf.AuthCodeURL(login.State,
+		oidc.Nonce(login.Nonce),
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	), nil
+}
+
+// Exchange trades the code of the callback for an ID token and returns the identity in it.
+// Identities without a verified email in one of the provider's domains are ErrSSODenied
+func (p *OIDCProvider) Exchange(ctx context.Context, login OIDCLogin, code string) (*Identity, error) {
+	conf, verifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := conf.Exchange(ctx, code, oauth2.SetAuthURLParam("code_verifier", login.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange the code: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("the token response has no id_token")
+	}
+	idToken, err := verifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, errors.New("the ID token nonce doesn't match the login")
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token claims: %w", err)
+	}
+	identity := &Identity{
+		Subject:   idToken.Subject,
+		Email:     strings.ToLower(stringClaim(claims, "email")),
+		FirstName: stringClaim(claims, "given_name"),
+		LastName:  stringClaim(claims, "family_name"),
+		Groups:    stringsClaim(claims, p.GroupsClaim),
+	}
+	if identity.FirstName == "" && identity.LastName == "" {
+		identity.FirstName, identity.LastName, _ = strings.Cut(stringClaim(claims, "name"), " ")
+	}
+	if identity.FirstName == "" {
+		identity.FirstName, _, _ = strings.Cut(identity.Email, "@")
+	}
+	if identity.LastName == "" {
+		identity.LastName = "-"
+	}
+
+	// providers that don't send email_verified, eg. Microsoft Entra, only issue tenant emails
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		return nil, fmt.Errorf("%w: the email %s is not verified", ErrSSODenied, identity.Email)
+	}
+	if !p.AllowsEmail(identity.Email) {
+		return nil, fmt.Errorf("%w: %s is not an email of %s", ErrSSODenied, identity.Email, p.DisplayName)
+	}
+	return identity, nil
+}
+
+// AllowsEmail reports whether email is in one of the provider's domains
+func (p *OIDCProvider) AllowsEmail(email string) bool { return emailInDomains(email, p.Domains) }
+
+// UserType returns the role of an identity in the provider's business, Admin for members
+// of an AdminGroups group and User for everyone else
+func (p *OIDCProvider) UserType(identity *Identity) int {
+	for _, group := range identity.Groups {
+		for _, admin := range p.AdminGroups {
+			if group == admin {
+				return models.TypeAdmin
+			}
+		}
+	}
+	return models.TypeUser
+}
+
+// stringClaim is a helper function reading a string claim, "" when missing
+func stringClaim(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return strings.TrimSpace(s)
+}
+
+// stringsClaim is a helper function reading a claim that is a list of strings or a single one
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch v := claims[name].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
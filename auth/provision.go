@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
+)
+
+// ErrSSODenied is returned for identities a provider may not sign in, the message says why
+var ErrSSODenied = errors.New("this account can't sign in here")
+
+// Identity is a person an identity provider or directory vouched for
+type Identity struct {
+	Subject   string // ID token subject or directory DN
+	Email     string
+	FirstName string
+	LastName  string
+	Groups    []string
+}
+
+// Provision returns the user of identity, creating them in business with userType on their
+// first sign in. key is the provider's models.UserDetails.AuthProvider: the role of users it
+// created follows userType, other accounts keep theirs. Disabled users and users of another
+// business are ErrSSODenied
+func Provision(ctx context.Context, users databases.UserDatabase, key string, business models.ID, identity *Identity, userType int) (*models.User, error) {
+	user, err := users.FindOne(ctx, databases.FilterUsers().Email(identity.Email))
+	if errors.Is(err, databases.ErrNotFound) {
+		created := util.NewUser(users, models.UserDetails{
+			FirstName:    identity.FirstName,
+			LastName:     identity.LastName,
+			Email:        identity.Email,
+			Business:     business,
+			UserType:     userType,
+			AuthProvider: key,
+		}, TemporaryPassword(), false) // never told to anyone, the account signs in with the provider
+		_, err := users.InsertOne(ctx, created)
+		if errors.Is(err, databases.ErrDuplicate) {
+			// created by a sync or another sign in since the lookup
+			return Provision(ctx, users, key, business, identity, userType)
+		}
+		if err != nil {
+			return nil, err
+		}
+		zap.S().Infow("created a user from an identity provider", "provider", key, "uid", created.Details.UID, "usertype", userType)
+		return &created, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.Details.UserType != models.TypeSuperUser && user.Details.Business != business {
+		return nil, fmt.Errorf("%w: the account belongs to another business", ErrSSODenied)
+	}
+	if user.Details.Disabled {
+		return nil, fmt.Errorf("%w: the account is disabled", ErrSSODenied)
+	}
+	if user.Details.AuthProvider == key && user.Details.UserType != models.TypeSuperUser && user.Details.UserType != userType {
+		update := databases.UpdateUser().SetUserType(userType).SetUpdatedAt(time.Now().UTC())
+		if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+			return nil, err
+		}
+		zap.S().Infow("changed the role of a user from their provider groups", "provider", key, "uid", user.Details.UID, "usertype", userType)
+		user.Details.UserType = userType
+	}
+	return user, nil
+}
+
+// emailInDomains is a helper function reporting whether email is in one of domains
+func emailInDomains(email string, domains []string) bool {
+	_, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return false
+	}
+	for _, allowed := range domains {
+		if strings.EqualFold(domain, allowed) {
+			return true
+		}
+	}
+	return false
+}
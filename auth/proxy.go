@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// TrustedProxies are the reverse proxies in front of the API. Only requests from them may
+// say which address they came from with X-Forwarded-For, anyone else could send any address
+// and get past IPThrottle
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies reads addresses and CIDR ranges, eg. "10.0.0.0/8" or "127.0.0.1"
+func ParseTrustedProxies(list []string) (TrustedProxies, error) {
+	var proxies TrustedProxies
+	for _, entry := range list {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// trusts is a helper function reporting whether address is one of the proxies
+func (p TrustedProxies) trusts(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the address r came from. When it came through trusted proxies that is
+// the last address of X-Forwarded-For that isn't one of them: each proxy appends the address
+// it was sent the request from, the addresses before could be made up by the client
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	ip := ClientIP(r)
+	if !p.trusts(ip) {
+		return ip
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			return ip
+		}
+		if ip = hop; !p.trusts(ip) {
+			return ip
+		}
+	}
+	return ip
+}
@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// Throttle slows down password guessing. After Free failed logins in a row each attempt has
+// to wait for a delay that doubles from a second up to MaxDelay, and LockAfter failures lock
+// for LockFor. Failures are forgotten after LockFor without another one
+type Throttle struct {
+	Free      int
+	MaxDelay  time.Duration
+	LockAfter int // 0 never locks
+	LockFor   time.Duration
+}
+
+// AccountThrottle returns the throttle of the failed logins of one account
+func AccountThrottle(lockAfter int, lockFor time.Duration) Throttle {
+	return Throttle{Free: 3, MaxDelay: 30 * time.Second, LockAfter: lockAfter, LockFor: lockFor}
+}
+
+// AddressThrottle returns the throttle of the failed logins from one IP address. It allows
+// more failures than AccountThrottle since a school or library can share one address
+func AddressThrottle(lockAfter int, lockFor time.Duration) Throttle {
+	return Throttle{Free: 20, MaxDelay: 10 * time.Second, LockAfter: lockAfter, LockFor: lockFor}
+}
+
+// Delay returns how long the attempt after failures must wait after the last failure
+func (t Throttle) Delay(failures int) time.Duration {
+	if failures < t.Free {
+		return 0
+	}
+	steps := failures - t.Free
+	if steps > 16 {
+		steps = 16
+	}
+	delay := time.Second << steps
+	if delay > t.MaxDelay {
+		return t.MaxDelay
+	}
+	return delay
+}
+
+// Failures returns the failures in a row that still count at now
+func (t Throttle) Failures(failures int, last, now time.Time) int {
+	if now.Sub(last) >= t.LockFor {
+		return 0
+	}
+	return failures
+}
+
+// Locks reports whether failures in a row lock
+func (t Throttle) Locks(failures int) bool {
+	return t.LockAfter > 0 && failures >= t.LockAfter
+}
+
+// Wait returns how long the next attempt must wait, locked is true while a lock lasts
+func (t Throttle) Wait(failures int, last, lockedUntil, now time.Time) (wait time.Duration, locked bool) {
+	if now.Before(lockedUntil) {
+		return lockedUntil.Sub(now), true
+	}
+	failures = t.Failures(failures, last, now)
+	if wait = last.Add(t.Delay(failures)).Sub(now); wait < 0 {
+		return 0, false
+	}
+	return wait, false
+}
+
+// AccountWait returns how long the next login of user must wait
+func (t Throttle) AccountWait(user *models.User, now time.Time) (time.Duration, bool) {
+	d := user.Details
+	return t.Wait(d.FailedLogins, d.LastFailedLogin, d.LockedUntil, now)
+}
+
+// IPThrottle keeps the failed logins of each IP address in memory, so an address guessing
+// the passwords of many accounts is slowed down as well. Each instance of the API counts
+// on its own
+type IPThrottle struct {
+	Throttle
+
+	mu        sync.Mutex
+	addresses map[string]*addressFailures
+}
+
+type addressFailures struct {
+	failures    int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// maxAddresses is how many addresses an IPThrottle tracks before it drops forgotten ones
+const maxAddresses = 10000
+
+// NewIPThrottle returns an empty IPThrottle
+func NewIPThrottle(t Throttle) *IPThrottle {
+	return &IPThrottle{Throttle: t, addresses: map[string]*addressFailures{}}
+}
+
+// Wait returns how long the next login from ip must wait, locked is true while a lock lasts
+func (t *IPThrottle) Wait(ip string, now time.Time) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.addresses[ip]
+	if !ok {
+		return 0, false
+	}
+	return t.Throttle.Wait(a.failures, a.last, a.lockedUntil, now)
+}
+
+// Fail records a failed login from ip and reports whether it locked the address
+func (t *IPThrottle) Fail(ip string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	a, ok := t.addresses[ip]
+	if !ok {
+		if len(t.addresses) >= maxAddresses {
+			t.sweep(now)
+		}
+		a = &addressFailures{}
+		t.addresses[ip] = a
+	}
+	a.failures = t.Failures(a.failures, a.last, now) + 1
+	a.last = now
+	if t.Locks(a.failures) {
+		a.failures, a.lockedUntil = 0, now.Add(t.LockFor)
+		return true
+	}
+	return false
+}
+
+// Reset forgets the failed logins from ip and lifts its lock
+func (t *IPThrottle) Reset(ip string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.addresses, ip)
+}
+
+// sweep is a helper function dropping the addresses whose failures are forgotten
+func (t *IPThrottle) sweep(now time.Time) {
+	for ip, a := range t.addresses {
+		if now.Sub(a.last) >= t.LockFor && !now.Before(a.lockedUntil) {
+			delete(t.addresses, ip)
+		}
+	}
+}
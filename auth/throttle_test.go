@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+func TestThrottleDelay(t *testing.T) {
+	throttle := AccountThrottle(10, 15*time.Minute)
+	for failures, want := range map[int]time.Duration{0: 0, 2: 0, 3: time.Second, 4: 2 * time.Second, 7: 16 * time.Second, 8: 30 * time.Second, 100: 30 * time.Second} {
+		if got := throttle.Delay(failures); got != want {
+			t.Errorf("Delay(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestAccountThrottle(t *testing.T) {
+	throttle := AccountThrottle(5, 15*time.Minute)
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	user := &models.User{}
+
+	// each failure is counted on the user as Auth.loginFailed does
+	fail := func(at time.Time) {
+		d := &user.Details
+		d.FailedLogins, d.LastFailedLogin = throttle.Failures(d.FailedLogins, d.LastFailedLogin, at)+1, at
+		if throttle.Locks(d.FailedLogins) {
+			d.FailedLogins, d.LockedUntil = 0, at.Add(throttle.LockFor)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		fail(now)
+	}
+	if wait, locked := throttle.AccountWait(user, now); wait != time.Second || locked {
+		t.Errorf("after 3 failures AccountWait = %s, %v, want 1s, false", wait, locked)
+	}
+	if wait, _ := throttle.AccountWait(user, now.Add(time.Second)); wait != 0 {
+		t.Errorf("after the delay AccountWait = %s, want 0", wait)
+	}
+
+	// the failures are forgotten after LockFor without another one
+	if wait, _ := throttle.AccountWait(user, now.Add(15*time.Minute)); wait != 0 {
+		t.Errorf("after LockFor AccountWait = %s, want 0", wait)
+	}
+	fail(now.Add(15 * time.Minute))
+	if user.Details.FailedLogins != 1 {
+		t.Errorf("a failure after LockFor counts %d failures, want 1", user.Details.FailedLogins)
+	}
+
+	now = now.Add(15 * time.Minute)
+	for i := 0; i < 4; i++ {
+		fail(now)
+	}
+	if wait, locked := throttle.AccountWait(user, now.Add(time.Minute)); wait != 14*time.Minute || !locked {
+		t.Errorf("after 5 failures AccountWait = %s, %v, want 14m, true", wait, locked)
+	}
+	if _, locked := throttle.AccountWait(user, now.Add(15*time.Minute)); locked {
+		t.Error("the lock lasted past LockFor")
+	}
+
+	// a login or an Admin resets the failures and the lock, see Auth.signedIn and AdminUnlock
+	user.Details.FailedLogins, user.Details.LastFailedLogin, user.Details.LockedUntil = 0, time.Time{}, time.Time{}
+	if wait, locked := throttle.AccountWait(user, now); wait != 0 || locked {
+		t.Errorf("after a reset AccountWait = %s, %v, want 0, false", wait, locked)
+	}
+
+	if never := AccountThrottle(0, time.Minute); never.Locks(1000) {
+		t.Error("a throttle with LockAfter 0 locked")
+	}
+}
+
+func TestIPThrottle(t *testing.T) {
+	throttle := NewIPThrottle(AddressThrottle(25, 15*time.Minute))
+	now := time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)
+	const ip, other = "192.0.2.1", "192.0.2.2"
+
+	for i := 0; i < 20; i++ {
+		if throttle.Fail(ip, now) {
+			t.Fatalf("failure %d locked the address, want 25", i+1)
+		}
+	}
+	if wait, locked := throttle.Wait(ip, now); wait != time.Second || locked {
+		t.Errorf("after 20 failures Wait = %s, %v, want 1s, false", wait, locked)
+	}
+	if wait, _ := throttle.Wait(other, now); wait != 0 {
+		t.Errorf("another address waits %s, want 0", wait)
+	}
+
+	// the failures are forgotten after LockFor without another one
+	if wait, _ := throttle.Wait(ip, now.Add(15*time.Minute)); wait != 0 {
+		t.Errorf("after LockFor Wait = %s, want 0", wait)
+	}
+
+	locked := false
+	for i := 0; i < 25 && !locked; i++ {
+		locked = throttle.Fail(ip, now)
+	}
+	if !locked {
+		t.Fatal("25 failures didn't lock the address")
+	}
+	if wait, locked := throttle.Wait(ip, now.Add(time.Minute)); wait != 14*time.Minute || !locked {
+		t.Errorf("a locked address Wait = %s, %v, want 14m, true", wait, locked)
+	}
+	if _, locked := throttle.Wait(ip, now.Add(15*time.Minute)); locked {
+		t.Error("the lock lasted past LockFor")
+	}
+
+	// a login from the address or an Admin resets it, see Auth.signedIn and AdminUnlock
+	for i := 0; i < 25; i++ {
+		throttle.Fail(ip, now)
+	}
+	throttle.Reset(ip)
+	if wait, locked := throttle.Wait(ip, now); wait != 0 || locked {
+		t.Errorf("after Reset Wait = %s, %v, want 0, false", wait, locked)
+	}
+	if throttle.Fail(ip, now); throttle.addresses[ip].failures != 1 {
+		t.Errorf("a failure after Reset counts %d failures, want 1", throttle.addresses[ip].failures)
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", " 127.0.0.1", "", "::1"})
+	if err != nil {
+		t.Fatalf("ParseTrustedProxies: %v", err)
+	}
+	for _, invalid := range []string{"proxy.example", "10.0.0.0/40"} {
+		if _, err := ParseTrustedProxies([]string{invalid}); err == nil {
+			t.Errorf("ParseTrustedProxies accepted %q", invalid)
+		}
+	}
+
+	for _, tt := range []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.9:5000", "", "203.0.113.9"},
+		{"203.0.113.9:5000", "198.51.100.1", "203.0.113.9"}, // not a proxy, the header is made up
+		{"127.0.0.1:5000", "", "127.0.0.1"},
+		{"127.0.0.1:5000", "198.51.100.1", "198.51.100.1"},
+		{"[::1]:5000", "198.51.100.1", "198.51.100.1"},
+		{"127.0.0.1:5000", "198.51.100.1, 10.1.2.3", "198.51.100.1"},
+		{"127.0.0.1:5000", "192.0.2.66, 198.51.100.1", "198.51.100.1"}, // the client added the first
+		{"127.0.0.1:5000", "10.1.2.3", "10.1.2.3"},
+		{"127.0.0.1:5000", "garbage, 198.51.100.1", "198.51.100.1"},
+		{"127.0.0.1:5000", "198.51.100.1, garbage", "127.0.0.1"},
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := proxies.ClientIP(r); got != tt.want {
+			t.Errorf("ClientIP from %s forwarding %q = %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.RemoteAddr = "127.0.0.1:5000"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	if got := TrustedProxies(nil).ClientIP(r); got != "127.0.0.1" {
+		t.Errorf("ClientIP without trusted proxies = %s, want the remote address", got)
+	}
+}
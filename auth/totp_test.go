@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the test vectors of RFC 6238 Appendix B, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	// the vectors have 8 digits, 6 digit codes are their last 6
+	for _, tt := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		got, err := TOTPCode(rfcSecret, TOTPStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("TOTPCode at %d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("TOTPCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if _, err := TOTPCode("not base32!", 1); err == nil {
+		t.Error("TOTPCode accepted an invalid secret")
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := TOTPStep(now)
+	code := func(step int64) string {
+		c, err := TOTPCode(rfcSecret, step)
+		if err != nil {
+			t.Fatalf("TOTPCode: %v", err)
+		}
+		return c
+	}
+
+	// a step either side of now is accepted for clock drift, and reported so it can't be
+	// used again
+	for _, step := range []int64{current - 1, current, current + 1} {
+		got, ok := VerifyTOTP(rfcSecret, code(step), now)
+		if !ok || got != step {
+			t.Errorf("VerifyTOTP of step %+d = %d, %v, want %d, true", step-current, got, ok, step)
+		}
+	}
+	for _, step := range []int64{current - 2, current + 2} {
+		if _, ok := VerifyTOTP(rfcSecret, code(step), now); ok {
+			t.Errorf("VerifyTOTP accepted the code of step %+d", step-current)
+		}
+	}
+
+	spaced := code(current)[:3] + " " + code(current)[3:]
+	if _, ok := VerifyTOTP(rfcSecret, " "+spaced+" ", now); !ok {
+		t.Errorf("VerifyTOTP refused %q", spaced)
+	}
+	for _, wrong := range []string{"", "12345", "1234567", "abcdef"} {
+		if _, ok := VerifyTOTP(rfcSecret, wrong, now); ok {
+			t.Errorf("VerifyTOTP accepted %q", wrong)
+		}
+	}
+}
+
+func TestUseRecoveryCode(t *testing.T) {
+	codes, hashes := NewRecoveryCodes()
+	if len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("NewRecoveryCodes returned %d codes and %d hashes, want %d", len(codes), len(hashes), RecoveryCodeCount)
+	}
+
+	rest, ok := UseRecoveryCode(hashes, " "+codes[3]+" ")
+	if !ok || len(rest) != RecoveryCodeCount-1 {
+		t.Fatalf("UseRecoveryCode left %d codes and returned %v, want %d and true", len(rest), ok, RecoveryCodeCount-1)
+	}
+	for _, hash := range rest {
+		if hash == RecoveryCodeHash(codes[3]) {
+			t.Error("UseRecoveryCode left the used code")
+		}
+	}
+	if _, ok := UseRecoveryCode(rest, codes[3]); ok {
+		t.Error("UseRecoveryCode accepted a used code")
+	}
+	if len(hashes) != RecoveryCodeCount {
+		t.Error("UseRecoveryCode changed the hashes it was given")
+	}
+}
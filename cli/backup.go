@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
+)
+
+// backup handles the backup command, eg. `devicebooking backup -o backup.db`
+func backup(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("backup")
+	out := fs.String("o", "", "file to write the backup to")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *out == "" {
+		return fmt.Errorf("an output file is required")
+	}
+	if err := sqliteOnly("backup", e.app.Config.Backend); err != nil {
+		return err
+	}
+
+	if _, err := e.store(); err != nil {
+		return err
+	}
+	if err := e.app.Backup(ctx, *out); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "backup written to %s\n", *out)
+	return nil
+}
+
+// restore handles the restore command, eg. `devicebooking restore -i backup.db`. The API
+// must be stopped first, it would keep writing to the replaced database
+func restore(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("restore")
+	in := fs.String("i", "", "backup file to restore")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *in == "" {
+		return fmt.Errorf("a backup file is required")
+	}
+
+	conf := e.app.Config
+	if err := sqliteOnly("restore", conf.Backend); err != nil {
+		return err
+	}
+	if !*yes && !util.Confirm(fmt.Sprintf("Replace %s with %s? Stop the API before restoring.", conf.URL, *in)) {
+		return fmt.Errorf("restore cancelled")
+	}
+
+	if err := databases.RestoreSQLite(ctx, *in, conf.URL); err != nil {
+		return err
+	}
+	fmt.Fprintf(e.out, "%s restored from %s\n", conf.URL, *in)
+	return nil
+}
+
+// sqliteOnly is a helper function refusing backups and restores of the backends with their
+// own tools for them, before connecting
+func sqliteOnly(command, backend string) error {
+	switch backend {
+	case databases.BackendSQLite:
+		return nil
+	case databases.BackendMongo:
+		return fmt.Errorf("%s only works with DB_BACKEND=sqlite, use mongodump and mongorestore for MongoDB", command)
+	case databases.BackendPostgres:
+		return fmt.Errorf("%s only works with DB_BACKEND=sqlite, use pg_dump and pg_restore for PostgreSQL", command)
+	}
+	return fmt.Errorf("%s only works with DB_BACKEND=sqlite, not %q", command, backend)
+}
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const dateLayout = "2006-01-02"
+
+// bookingResult is a booking with the cow it belongs to
+type bookingResult struct {
+	Cow     models.ID `json:"cow"`
+	CowName string    `json:"cowName"`
+	models.BookDetails
+}
+
+// bookingList handles `devicebooking booking list [-cow <id>] [-from 2022-09-01] [-to 2022-10-01]`
+func bookingList(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("booking list")
+	cowID := fs.String("cow", "", "only bookings of this cow ID")
+	from := fs.String("from", "", "only bookings ending after this date, YYYY-MM-DD")
+	to := fs.String("to", "", "only bookings starting before this date, YYYY-MM-DD")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := databases.FilterCows()
+	if *cowID != "" {
+		id, err := models.ParseID(*cowID)
+		if err != nil {
+			return fmt.Errorf("invalid cow: %w", err)
+		}
+		filter.ID(id)
+	}
+	start, end := time.Time{}, time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	var err error
+	if *from != "" {
+		if start, err = time.Parse(dateLayout, *from); err != nil {
+			return fmt.Errorf("invalid -from date: %w", err)
+		}
+	}
+	if *to != "" {
+		if end, err = time.Parse(dateLayout, *to); err != nil {
+			return fmt.Errorf("invalid -to date: %w", err)
+		}
+	}
+	if *from != "" || *to != "" {
+		filter.BookedBetween(start, end)
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	cows, err := store.Cows().Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	bookings := []bookingResult{}
+	rows := [][]string{}
+	for _, cow := range cows {
+		for _, booking := range cow.Details.Bookings {
+			// a cow matches when any booking overlaps, only print the ones that do
+			if !booking.EndDate.Time().After(start) || !booking.StartDate.Time().Before(end) {
+				continue
+			}
+			bookings = append(bookings, bookingResult{Cow: cow.ID, CowName: cow.Details.Name, BookDetails: booking})
+			rows = append(rows, []string{
+				booking.ID,
+				cow.Details.Name,
+				booking.Author,
+				booking.Block,
+				booking.StartDate.Time().UTC().Format(time.RFC3339),
+				booking.EndDate.Time().UTC().Format(time.RFC3339),
+				strconv.Itoa(len(booking.Devices)),
+			})
+		}
+	}
+	return e.write(*format, bookings, []string{"ID", "COW", "AUTHOR", "BLOCK", "START", "END", "DEVICES"}, rows)
+}
+
+// bookingCancel handles `devicebooking booking cancel <booking id>`
+func bookingCancel(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("booking cancel")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: devicebooking booking cancel <booking id>")
+	}
+	bookingID := fs.Arg(0)
+
+	// booking IDs start with the ID of their cow, see Cow.BookingHandler
+	cowID, err := models.ParseID(strings.SplitN(bookingID, ".", 2)[0])
+	if err != nil {
+		return fmt.Errorf("%q is not a booking ID", bookingID)
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	cow, err := store.Cows().FindOne(ctx, databases.FilterCows().ID(cowID))
+	if errors.Is(err, databases.ErrNotFound) {
+		return fmt.Errorf("booking %s not found", bookingID)
+	}
+	if err != nil {
+		return err
+	}
+
+	found := false
+	for _, booking := range cow.Details.Bookings {
+		found = found || booking.ID == bookingID
+	}
+	if !found {
+		return fmt.Errorf("booking %s not found", bookingID)
+	}
+
+	// pulling the one booking keeps the bookings made since the cow was read
+	result, err := store.Cows().UpdateOne(ctx, databases.FilterCows().ID(cowID), databases.UpdateCow().PullBooking(bookingID))
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("booking %s not found", bookingID)
+	}
+	fmt.Fprintf(e.out, "cancelled booking %s of %s\n", bookingID, cow.Details.Name)
+	return nil
+}
@@ -0,0 +1,181 @@
+// Package cli implements the commands of the devicebooking binary. Without a command the
+// API is served, the other commands operate a deployment by talking to the database
+// directly, so they work while the API is down
+//
+//	devicebooking user create -first Ada -last Lovelace -email ada@example.com -type superuser
+//	devicebooking device import -f devices.json
+//	devicebooking booking list -from 2022-09-01 -format json
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api/handlers"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+)
+
+const usage = `usage: devicebooking <command> [flags]
+
+commands:
+  serve                                  run the API (the default without a command)
+  migrate                                apply, roll back or list database migrations
+  user create|reset-password|reset-2fa|unlock|promote|sync-ldap
+                                         manage user accounts
+  cow list|import|export                 manage cows
+  device list|import|export              manage devices
+  booking list|cancel                    list or cancel bookings
+  backup -o FILE                         write a copy of the database (sqlite)
+  restore -i FILE                        replace the database with a backup (sqlite)
+
+run "devicebooking <command> -h" for the flags of a command
+`
+
+// env is what the commands share, the database is connected on first use
+type env struct {
+	app *handlers.App
+	in  io.Reader
+	out io.Writer
+}
+
+// Run executes the command in args, eg. []string{"cow", "list"}, reading input from in
+// and writing results to out
+func Run(ctx context.Context, args []string, in io.Reader, out io.Writer) error {
+	a := &handlers.App{}
+	a.Config = *config.New()
+	e := &env{app: a, in: in, out: out}
+
+	if len(args) == 0 || args[0] == "serve" {
+		if len(args) > 0 {
+			args = args[1:]
+		}
+		return ignoreHelp(serve(ctx, e, args))
+	}
+
+	// commands write results to out, so logs only show problems and go to stderr
+	zap.ReplaceGlobals(zap.New(zapcore.NewCore(
+		zapcore.NewConsoleEncoder(zap.NewDevelopmentEncoderConfig()),
+		zapcore.Lock(os.Stderr),
+		zap.WarnLevel,
+	)))
+
+	var err error
+	switch args[0] {
+	case "migrate":
+		err = migrate(ctx, e, args[1:])
+	case "backup":
+		err = backup(ctx, e, args[1:])
+	case "restore":
+		err = restore(ctx, e, args[1:])
+	case "user":
+		err = subcommand(ctx, e, args, map[string]func(context.Context, *env, []string) error{
+			"create":         userCreate,
+			"reset-password": userResetPassword,
+			"reset-2fa":      userResetTwoFactor,
+			"unlock":         userUnlock,
+			"promote":        userPromote,
+			"sync-ldap":      userSyncLDAP,
+		})
+	case "cow":
+		err = subcommand(ctx, e, args, map[string]func(context.Context, *env, []string) error{
+			"list":   cowList,
+			"import": cowImport,
+			"export": cowExport,
+		})
+	case "device":
+		err = subcommand(ctx, e, args, map[string]func(context.Context, *env, []string) error{
+			"list":   deviceList,
+			"import": deviceImport,
+			"export": deviceExport,
+		})
+	case "booking":
+		err = subcommand(ctx, e, args, map[string]func(context.Context, *env, []string) error{
+			"list":   bookingList,
+			"cancel": bookingCancel,
+		})
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(out, usage)
+	default:
+		err = fmt.Errorf("unknown command %q, run \"devicebooking help\" for the list of commands", args[0])
+	}
+	return ignoreHelp(err)
+}
+
+// subcommand is a helper function running the subcommand named by args[1]
+func subcommand(ctx context.Context, e *env, args []string, commands map[string]func(context.Context, *env, []string) error) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(args) < 2 {
+		return fmt.Errorf("usage: devicebooking %s <%s> [flags]", args[0], strings.Join(names, "|"))
+	}
+	run, ok := commands[args[1]]
+	if !ok {
+		return fmt.Errorf("unknown command %q, expected one of %s", args[0]+" "+args[1], strings.Join(names, ", "))
+	}
+	return run(ctx, e, args[2:])
+}
+
+// ignoreHelp is a helper function, -h prints the flags and isn't a failure
+func ignoreHelp(err error) error {
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	return err
+}
+
+// store connects to the database selected in the config
+func (e *env) store() (databases.Store, error) {
+	if e.app.Store() == nil {
+		if err := e.app.Connect(); err != nil {
+			return nil, err
+		}
+	}
+	return e.app.Store(), nil
+}
+
+// newFlagSet is a helper function creating the flags of a command, errors are returned
+// instead of exiting so Run can be called from other programs
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("devicebooking "+name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+// formatFlag adds the -format flag to commands that print results
+func formatFlag(fs *flag.FlagSet) *string {
+	return fs.String("format", "table", "output format, table or json")
+}
+
+// write prints v as indented JSON, or the rows as an aligned table
+func (e *env) write(format string, v interface{}, header []string, rows [][]string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(e.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case "table":
+		tw := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+		for _, row := range rows {
+			fmt.Fprintln(tw, strings.Join(row, "\t"))
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown format %q, use table or json", format)
+	}
+}
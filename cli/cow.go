@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// cowList handles `devicebooking cow list [-collection Laptop] [-format json]`
+func cowList(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("cow list")
+	name := fs.String("name", "", "only the cow with this name")
+	business := fs.String("business", "", "only cows of this business ID")
+	collection := fs.String("collection", "", "only cows of this collection, eg. Laptop")
+	limit := fs.Int64("limit", 0, "list at most this many cows (0 lists all)")
+	offset := fs.Int64("offset", 0, "skip this many cows")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := databases.FilterCows().Limit(*limit).Skip(*offset)
+	if *name != "" {
+		filter.Name(*name)
+	}
+	if *business != "" {
+		id, err := models.ParseID(*business)
+		if err != nil {
+			return fmt.Errorf("invalid business: %w", err)
+		}
+		filter.Business(id)
+	}
+	if *collection != "" {
+		filter.Collection(*collection)
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	cows, err := store.Cows().Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if cows == nil {
+		cows = []models.Cow{}
+	}
+
+	rows := make([][]string, 0, len(cows))
+	for _, cow := range cows {
+		rows = append(rows, []string{
+			cow.ID.String(),
+			cow.Details.Name,
+			cow.Details.Collection,
+			cow.Details.Business.String(),
+			fmt.Sprintf("%d/%d", len(cow.Details.Devices), cow.Details.DeviceTotal),
+			strconv.Itoa(len(cow.Details.Bookings)),
+		})
+	}
+	return e.write(*format, cows, []string{"ID", "NAME", "COLLECTION", "BUSINESS", "DEVICES", "BOOKINGS"}, rows)
+}
+
+// cowExport handles `devicebooking cow export [-f cows.json]`, the file can be imported again
+func cowExport(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("cow export")
+	file := fs.String("f", "-", "file to write, - writes to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	cows, err := store.Cows().Find(ctx, databases.FilterCows())
+	if err != nil {
+		return err
+	}
+	if cows == nil {
+		cows = []models.Cow{}
+	}
+	return e.writeJSONFile(*file, cows)
+}
+
+// cowImport handles `devicebooking cow import -f cows.json`. Cows that already exist are
+// skipped, so an export can be imported again after fixing a failed import
+func cowImport(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("cow import")
+	file := fs.String("f", "-", "JSON file with an array of cows, as written by cow export, - reads stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var cows []models.Cow
+	if err := e.readJSONFile(*file, &cows); err != nil {
+		return err
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	var imported, skipped int
+	for i, cow := range cows {
+		if cow.Details.Name == "" {
+			return fmt.Errorf("cow %d has no name, imported %d cows before it", i+1, imported)
+		}
+		if cow.ID.IsZero() {
+			cow.ID = models.NewID()
+		}
+		if _, err := store.Cows().InsertOne(ctx, cow); err != nil {
+			if errors.Is(err, databases.ErrDuplicate) {
+				skipped++
+				continue
+			}
+			return fmt.Errorf("failed to import cow %s, imported %d cows before it: %w", cow.Details.Name, imported, err)
+		}
+		imported++
+	}
+	fmt.Fprintf(e.out, "imported %d cows, skipped %d that already exist\n", imported, skipped)
+	return nil
+}
+
+// readJSONFile is a helper function decoding the file at path, or stdin for "-", into v
+func (e *env) readJSONFile(path string, v interface{}) error {
+	var r io.Reader = e.in
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeJSONFile is a helper function writing v as indented JSON to path, or stdout for "-"
+func (e *env) writeJSONFile(path string, v interface{}) error {
+	if path == "-" {
+		return e.write("json", v, nil, nil)
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(b, '\n'), 0o644)
+}
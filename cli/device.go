@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// deviceList handles `devicebooking device list [-parent <cow id>] [-format json]`
+func deviceList(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("device list")
+	name := fs.String("name", "", "only the device with this name")
+	deviceType := fs.String("type", "", "only devices of this type, eg. Laptop")
+	parent := fs.String("parent", "", "only devices in this cow ID")
+	limit := fs.Int64("limit", 0, "list at most this many devices (0 lists all)")
+	offset := fs.Int64("offset", 0, "skip this many devices")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := databases.FilterDevices().Limit(*limit).Skip(*offset)
+	if *name != "" {
+		filter.Name(*name)
+	}
+	if *deviceType != "" {
+		filter.Type(*deviceType)
+	}
+	if *parent != "" {
+		id, err := models.ParseID(*parent)
+		if err != nil {
+			return fmt.Errorf("invalid parent: %w", err)
+		}
+		filter.Parent(id)
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	devices, err := store.Devices().Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+
+	rows := make([][]string, 0, len(devices))
+	for _, device := range devices {
+		rows = append(rows, []string{device.ID.String(), device.Details.Name, device.Details.Type, device.Details.Parent.String()})
+	}
+	return e.write(*format, devices, []string{"ID", "NAME", "TYPE", "PARENT"}, rows)
+}
+
+// deviceExport handles `devicebooking device export [-f devices.json]`, the file can be imported again
+func deviceExport(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("device export")
+	file := fs.String("f", "-", "file to write, - writes to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	devices, err := store.Devices().Find(ctx, databases.FilterDevices())
+	if err != nil {
+		return err
+	}
+	if devices == nil {
+		devices = []models.Device{}
+	}
+	return e.writeJSONFile(*file, devices)
+}
+
+// deviceImport handles `devicebooking device import -f devices.json`. Devices that already
+// exist are skipped, so an export can be imported again after fixing a failed import
+func deviceImport(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("device import")
+	file := fs.String("f", "-", "JSON file with an array of devices, as written by device export, - reads stdin")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var devices []models.Device
+	if err := e.readJSONFile(*file, &devices); err != nil {
+		return err
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	var imported, skipped int
+	for i, device := range devices {
+		if device.Details.Name == "" {
+			return fmt.Errorf("device %d has no name, imported %d devices before it", i+1, imported)
+		}
+		if device.ID.IsZero() {
+			device.ID = models.NewID()
+		}
+		if _, err := store.Devices().InsertOne(ctx, device); err != nil {
+			if errors.Is(err, databases.ErrDuplicate) {
+				skipped++
+				continue
+			}
+			return fmt.Errorf("failed to import device %s, imported %d devices before it: %w", device.Details.Name, imported, err)
+		}
+		imported++
+	}
+	fmt.Fprintf(e.out, "imported %d devices, skipped %d that already exist\n", imported, skipped)
+	return nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// serve runs the API, eg. `devicebooking serve -interactive-setup`
+func serve(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("serve")
+	interactive := fs.Bool("interactive-setup", false, "prompt for the first SuperUser on stdin when there are no users")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	a := e.app
+	a.Config.InteractiveSetup = *interactive
+	if err := a.Initialize(); err != nil { //initialize database and router
+		return err
+	}
+	a.StartDirectorySync(ctx)
+	a.StartBookingExpiry(ctx)
+
+	zap.S().Infow("DeviceBookingAPI is up and running", "url", a.Config.BaseURL, "port", a.Config.Port)
+	return http.ListenAndServe(":"+a.Config.Port, a.Router)
+}
+
+// migrate handles the migrate command, eg. `devicebooking migrate -down -to 2 -dry-run`
+func migrate(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("migrate")
+	down := fs.Bool("down", false, "roll back migrations instead of applying them")
+	to := fs.Int("to", 0, "target version (0 applies all, or rolls back all with -down)")
+	dryRun := fs.Bool("dry-run", false, "print the migrations that would run without changing anything")
+	status := fs.Bool("status", false, "list applied and pending migrations")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := e.store(); err != nil {
+		return err
+	}
+
+	m := e.app.Migrator()
+	m.DryRun = *dryRun
+
+	if *status {
+		applied, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, record := range applied {
+			fmt.Fprintf(e.out, "applied  %4d  %s  (%s)\n", record.Version, record.Description, record.AppliedAt.Format("2006-01-02 15:04:05"))
+		}
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		for _, migration := range pending {
+			fmt.Fprintf(e.out, "pending  %4d  %s\n", migration.Version, migration.Description)
+		}
+		return nil
+	}
+
+	run := m.Up
+	if *down {
+		run = m.Down
+	}
+	ran, err := run(ctx, *to)
+	for _, migration := range ran {
+		fmt.Fprintf(e.out, "%4d  %s\n", migration.Version, migration.Description)
+	}
+	if err != nil {
+		return err
+	}
+	if len(ran) == 0 {
+		fmt.Fprintln(e.out, "nothing to do")
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/howeyc/gopass"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/auth"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/mailer"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/util"
+)
+
+var userTypes = map[string]int{
+	"superuser": models.TypeSuperUser,
+	"admin":     models.TypeAdmin,
+	"user":      models.TypeUser,
+}
+
+// userTypeName returns the name of a user type as used by the -type flags
+func userTypeName(t int) string {
+	for name, value := range userTypes {
+		if value == t {
+			return name
+		}
+	}
+	return fmt.Sprint(t)
+}
+
+// passwordFlags are the ways a command can set a password
+type passwordFlags struct {
+	stdin *bool
+	temp  *bool
+}
+
+func addPasswordFlags(fs *flag.FlagSet) passwordFlags {
+	return passwordFlags{
+		stdin: fs.Bool("password-stdin", false, "read the password from the first line of stdin instead of prompting"),
+		temp:  fs.Bool("temp-password", false, "generate a temporary password the user must change, it is printed once"),
+	}
+}
+
+// password returns the plain text password chosen with the flags, and whether it is temporary
+func (e *env) password(flags passwordFlags) (string, bool, error) {
+	if *flags.temp {
+		return auth.TemporaryPassword(), true, nil
+	}
+
+	var password string
+	if *flags.stdin {
+		line, err := bufio.NewReader(e.in).ReadString('\n')
+		if err != nil && line == "" {
+			return "", false, fmt.Errorf("failed to read the password from stdin: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	} else {
+		first, err := gopass.GetPasswdPrompt("Password: ", true, os.Stdin, os.Stderr)
+		if err != nil {
+			return "", false, err
+		}
+		again, err := gopass.GetPasswdPrompt("Repeat password: ", true, os.Stdin, os.Stderr)
+		if err != nil {
+			return "", false, err
+		}
+		if string(first) != string(again) {
+			return "", false, errors.New("the passwords don't match")
+		}
+		password = string(first)
+	}
+
+	if err := auth.ValidatePassword(password); err != nil {
+		return "", false, err
+	}
+	return password, false, nil
+}
+
+// findUser is a helper function looking up a user by email, or by UID when ref has no @
+func findUser(ctx context.Context, users databases.UserDatabase, ref string) (*models.User, error) {
+	filter := databases.FilterUsers().UID(ref)
+	if strings.Contains(ref, "@") {
+		filter = databases.FilterUsers().Email(ref)
+	}
+	user, err := users.FindOne(ctx, filter)
+	if errors.Is(err, databases.ErrNotFound) {
+		return nil, fmt.Errorf("no user with the UID or email %q", ref)
+	}
+	return user, err
+}
+
+// userResult is printed by the user commands, Password is only set when one was generated
+type userResult struct {
+	ID       models.ID `json:"id"`
+	UID      string    `json:"uid"`
+	Email    string    `json:"email"`
+	UserType string    `json:"usertype"`
+	Password string    `json:"password,omitempty"`
+}
+
+func (e *env) writeUser(format string, user models.User, password string) error {
+	result := userResult{
+		ID:       user.ID,
+		UID:      user.Details.UID,
+		Email:    user.Details.Email,
+		UserType: userTypeName(user.Details.UserType),
+		Password: password,
+	}
+	header := []string{"UID", "EMAIL", "TYPE"}
+	row := []string{result.UID, result.Email, result.UserType}
+	if password != "" {
+		header, row = append(header, "TEMPORARY PASSWORD"), append(row, password)
+	}
+	return e.write(format, result, header, [][]string{row})
+}
+
+// userCreate handles `devicebooking user create -first Ada -last Lovelace -email ada@example.com`
+func userCreate(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("user create")
+	first := fs.String("first", "", "first name")
+	last := fs.String("last", "", "last name")
+	email := fs.String("email", "", "email address, used to sign in")
+	userType := fs.String("type", "user", "superuser, admin or user")
+	business := fs.String("business", "", "ID of the business the user belongs to")
+	passwords := addPasswordFlags(fs)
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	details := models.UserDetails{FirstName: *first, LastName: *last}
+	if details.FirstName == "" || details.LastName == "" {
+		return errors.New("-first and -last are required")
+	}
+	address, ok := util.ValidMailAddress(*email)
+	if !ok {
+		return fmt.Errorf("%q is not a valid email address", *email)
+	}
+	details.Email = address
+	t, ok := userTypes[*userType]
+	if !ok {
+		return fmt.Errorf("unknown user type %q, use superuser, admin or user", *userType)
+	}
+	details.UserType = t
+	if *business != "" {
+		id, err := models.ParseID(*business)
+		if err != nil {
+			return fmt.Errorf("invalid business: %w", err)
+		}
+		details.Business = id
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	users := store.Users()
+	existing, err := users.Find(ctx, databases.FilterUsers().Email(details.Email).Limit(1))
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("a user with the email %s already exists", details.Email)
+	}
+
+	password, temp, err := e.password(passwords)
+	if err != nil {
+		return err
+	}
+	user := util.NewUser(users, details, password, temp)
+	if _, err := users.InsertOne(ctx, user); err != nil {
+		return err
+	}
+
+	if !temp {
+		password = ""
+	}
+	return e.writeUser(*format, user, password)
+}
+
+// userResetPassword handles `devicebooking user reset-password [-temp-password] <uid|email>`
+func userResetPassword(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("user reset-password")
+	passwords := addPasswordFlags(fs)
+	sendEmail := fs.Bool("send-email", false, "email the temporary password instead of printing it, needs -temp-password and SMTP_HOST")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: devicebooking user reset-password [flags] <uid|email>")
+	}
+	if *sendEmail && !*passwords.temp {
+		return errors.New("-send-email needs -temp-password")
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	users := store.Users()
+	user, err := findUser(ctx, users, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	password, temp, err := e.password(passwords)
+	if err != nil {
+		return err
+	}
+	// a reset signs the user out everywhere and invalidates forgot password links
+	update := databases.UpdateUser().
+		SetPassword(user.HashPassword(password)).
+		SetTempPassword(temp).
+		SetResetToken("", time.Time{}).
+		SetSessions(nil).
+		SetUpdatedAt(time.Now().UTC())
+	if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		return err
+	}
+
+	if *sendEmail {
+		if err := mailer.New(&e.app.Config).Send(ctx, auth.TemporaryPasswordEmail(user, password)); err != nil {
+			return fmt.Errorf("the password was reset but could not be emailed, run the command again without -send-email: %w", err)
+		}
+		password = ""
+	}
+	if !temp {
+		password = ""
+	}
+	return e.writeUser(*format, *user, password)
+}
+
+// userResetTwoFactor handles `devicebooking user reset-2fa <uid|email>`, for users who lost
+// their authenticator app. It also signs them out
+func userResetTwoFactor(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("user reset-2fa")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: devicebooking user reset-2fa [flags] <uid|email>")
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	users := store.Users()
+	user, err := findUser(ctx, users, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	update := databases.UpdateUser().
+		SetTOTP("", false).
+		SetRecoveryCodes(nil).
+		SetSessions(nil).
+		SetUpdatedAt(time.Now().UTC())
+	if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		return err
+	}
+	return e.writeUser(*format, *user, "")
+}
+
+// userUnlock handles `devicebooking user unlock <uid|email>`, for users locked by failed
+// logins, including the last SuperUser
+func userUnlock(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("user unlock")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: devicebooking user unlock [flags] <uid|email>")
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	users := store.Users()
+	user, err := findUser(ctx, users, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	update := databases.UpdateUser().SetFailedLogins(0, time.Time{}).SetLockedUntil(time.Time{})
+	if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		return err
+	}
+	return e.writeUser(*format, *user, "")
+}
+
+// userPromote handles `devicebooking user promote [-type superuser] <uid|email>`
+func userPromote(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("user promote")
+	userType := fs.String("type", "admin", "admin or superuser")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: devicebooking user promote [flags] <uid|email>")
+	}
+	if *userType != "admin" && *userType != "superuser" {
+		return fmt.Errorf("users can only be promoted to admin or superuser, not %q", *userType)
+	}
+
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+	users := store.Users()
+	user, err := findUser(ctx, users, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	t := userTypes[*userType]
+	if user.Details.UserType <= t {
+		return fmt.Errorf("%s is already %s", user.Details.Email, article(userTypeName(user.Details.UserType)))
+	}
+	update := databases.UpdateUser().SetUserType(t).SetUpdatedAt(time.Now().UTC())
+	if _, err := users.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		return err
+	}
+
+	user.Details.UserType = t
+	return e.writeUser(*format, *user, "")
+}
+
+// syncResult is a line of `devicebooking user sync-ldap`
+type syncResult struct {
+	Provider string `json:"provider"`
+	auth.LDAPSyncResult
+}
+
+// userSyncLDAP handles `devicebooking user sync-ldap [-provider sd42-ad]`, running the
+// scheduled sync of the LDAP directories now
+func userSyncLDAP(ctx context.Context, e *env, args []string) error {
+	fs := newFlagSet("user sync-ldap")
+	name := fs.String("provider", "", "only sync the directory with this name")
+	format := formatFlag(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	providers, err := e.app.LDAPProviders()
+	if err != nil {
+		return err
+	}
+	if len(providers) == 0 {
+		return errors.New("no LDAP directories, set LDAP_PROVIDERS_FILE")
+	}
+	if *name != "" {
+		provider, ok := providers[*name]
+		if !ok {
+			return fmt.Errorf("no LDAP directory is named %q", *name)
+		}
+		providers = map[string]*auth.LDAPProvider{*name: provider}
+	}
+	store, err := e.store()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var results []syncResult
+	var rows [][]string
+	for _, name := range names {
+		result, err := providers[name].Sync(ctx, store.Users())
+		if err != nil {
+			return fmt.Errorf("failed to sync %s: %w", name, err)
+		}
+		results = append(results, syncResult{Provider: name, LDAPSyncResult: result})
+		rows = append(rows, []string{name, strconv.Itoa(result.Created), strconv.Itoa(result.Updated),
+			strconv.Itoa(result.Enabled), strconv.Itoa(result.Disabled), strconv.Itoa(result.Skipped)})
+	}
+	return e.write(*format, results, []string{"PROVIDER", "CREATED", "UPDATED", "ENABLED", "DISABLED", "SKIPPED"}, rows)
+}
+
+// article is a helper function prefixing a user type name with a or an
+func article(name string) string {
+	if strings.IndexAny(name, "aeiou") == 0 {
+		return "an " + name
+	}
+	return "a " + name
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// APIKeyListOptions filters and pages a list of API keys
+type APIKeyListOptions struct {
+	ListOptions
+	Business models.ID // SuperUsers only, Admins always see their own business
+}
+
+func (o APIKeyListOptions) query() url.Values {
+	v := url.Values{}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// APIKeyService calls the /api/v2/api-keys endpoints, as an Admin or SuperUser. A service
+// integration uses a key with WithToken
+type APIKeyService struct {
+	c *Client
+}
+
+// List returns a page of API keys
+func (s *APIKeyService) List(ctx context.Context, opts APIKeyListOptions) ([]models.APIKey, models.Page, error) {
+	keys, page, err := do[[]models.APIKey](ctx, s.c, http.MethodGet, "/api/v2/api-keys", withPage(opts.query(), opts.ListOptions), nil)
+	return keys, pageOrZero(page), err
+}
+
+// Get returns an API key by ID, without the key itself
+func (s *APIKeyService) Get(ctx context.Context, id models.ID) (*models.APIKey, error) {
+	return doPointer[models.APIKey](ctx, s.c, http.MethodGet, "/api/v2/api-keys/"+url.PathEscape(id.String()), nil)
+}
+
+// Create makes a new API key. The returned Key is not shown again
+func (s *APIKeyService) Create(ctx context.Context, req models.APIKeyRequest) (*models.CreatedAPIKey, error) {
+	return doPointer[models.CreatedAPIKey](ctx, s.c, http.MethodPost, "/api/v2/api-keys", req)
+}
+
+// Delete revokes an API key
+func (s *APIKeyService) Delete(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/api-keys/"+url.PathEscape(id.String()), nil, nil, nil)
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// ApprovalPolicy returns who approves the bookings of a cow, ErrNotFound when they don't
+// need approval. Admins only
+func (s *CowService) ApprovalPolicy(ctx context.Context, cowID models.ID) (*models.ApprovalPolicy, error) {
+	return doPointer[models.ApprovalPolicy](ctx, s.c, http.MethodGet, approvalPath(cowID), nil)
+}
+
+// SetApprovers makes the bookings of a cow need the approval of one of approvers,
+// replacing the approvers it had. Admins only
+func (s *CowService) SetApprovers(ctx context.Context, cowID models.ID, approvers []models.ID) (*models.ApprovalPolicy, error) {
+	return doPointer[models.ApprovalPolicy](ctx, s.c, http.MethodPut, approvalPath(cowID), models.ApprovalPolicyRequest{Approvers: approvers})
+}
+
+// RemoveApprovalPolicy lets a cow be booked without approval again. Admins only
+func (s *CowService) RemoveApprovalPolicy(ctx context.Context, cowID models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, approvalPath(cowID), nil, nil, nil)
+}
+
+func approvalPath(cowID models.ID) string {
+	return "/api/v2/cows/" + url.PathEscape(cowID.String()) + "/approval"
+}
+
+// BookingRequestListOptions filters and pages a list of booking requests
+type BookingRequestListOptions struct {
+	ListOptions
+	Status   string    // pending, approved, rejected or expired
+	Cow      models.ID // approvers see every request for the cows they approve
+	Business models.ID // SuperUsers only, Admins always see their own business
+}
+
+func (o BookingRequestListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Status != "" {
+		v.Set("status", o.Status)
+	}
+	if !o.Cow.IsZero() {
+		v.Set("cow", o.Cow.String())
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// BookingRequestService calls the /api/v2/booking-requests endpoints, for the bookings of
+// cows that need approval
+type BookingRequestService struct {
+	c *Client
+}
+
+// List returns a page of booking requests, your own or those you can decide
+func (s *BookingRequestService) List(ctx context.Context, opts BookingRequestListOptions) ([]models.BookingRequest, models.Page, error) {
+	requests, page, err := do[[]models.BookingRequest](ctx, s.c, http.MethodGet, "/api/v2/booking-requests", withPage(opts.query(), opts.ListOptions), nil)
+	return requests, pageOrZero(page), err
+}
+
+// All iterates over every booking request matching the options, starting at opts.Offset
+func (s *BookingRequestService) All(opts BookingRequestListOptions) *Iterator[models.BookingRequest] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.BookingRequest, error) {
+		opts.ListOptions = page
+		requests, _, err := s.List(ctx, opts)
+		return requests, err
+	})
+}
+
+// Get returns a booking request by ID
+func (s *BookingRequestService) Get(ctx context.Context, id models.ID) (*models.BookingRequest, error) {
+	return doPointer[models.BookingRequest](ctx, s.c, http.MethodGet, bookingRequestPath(id), nil)
+}
+
+// Approve adds the booking of a pending request to its cow, reason is optional
+func (s *BookingRequestService) Approve(ctx context.Context, id models.ID, reason string) (*models.BookingRequest, error) {
+	return doPointer[models.BookingRequest](ctx, s.c, http.MethodPost, bookingRequestPath(id)+"/approve", models.BookingDecision{Reason: reason})
+}
+
+// Reject turns down a pending request, the reason is emailed to the requester
+func (s *BookingRequestService) Reject(ctx context.Context, id models.ID, reason string) (*models.BookingRequest, error) {
+	return doPointer[models.BookingRequest](ctx, s.c, http.MethodPost, bookingRequestPath(id)+"/reject", models.BookingDecision{Reason: reason})
+}
+
+func bookingRequestPath(id models.ID) string {
+	return "/api/v2/booking-requests/" + url.PathEscape(id.String())
+}
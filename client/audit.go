@@ -0,0 +1,78 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// AuditListOptions filters and pages the audit log
+type AuditListOptions struct {
+	ListOptions
+	Actor      models.ID // user or API key
+	Action     string    // models.AuditCreate, AuditUpdate or AuditDelete
+	EntityType string    // models.AuditCow, AuditDevice, AuditUser or AuditBooking
+	EntityID   string
+	RequestID  string
+	From, To   time.Time // From is inclusive, To exclusive
+	Business   models.ID // SuperUsers only, Admins always see their own business
+}
+
+func (o AuditListOptions) query() url.Values {
+	v := url.Values{}
+	if !o.Actor.IsZero() {
+		v.Set("actor", o.Actor.String())
+	}
+	if o.Action != "" {
+		v.Set("action", o.Action)
+	}
+	if o.EntityType != "" {
+		v.Set("type", o.EntityType)
+	}
+	if o.EntityID != "" {
+		v.Set("entity", o.EntityID)
+	}
+	if o.RequestID != "" {
+		v.Set("request", o.RequestID)
+	}
+	if !o.From.IsZero() {
+		v.Set("from", o.From.UTC().Format(time.RFC3339))
+	}
+	if !o.To.IsZero() {
+		v.Set("to", o.To.UTC().Format(time.RFC3339))
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// AuditService calls the /api/v2/audit endpoints, as an Admin or SuperUser
+type AuditService struct {
+	c *Client
+}
+
+// List returns a page of audit entries, oldest first
+func (s *AuditService) List(ctx context.Context, opts AuditListOptions) ([]models.AuditEntry, models.Page, error) {
+	entries, page, err := do[[]models.AuditEntry](ctx, s.c, http.MethodGet, "/api/v2/audit", withPage(opts.query(), opts.ListOptions), nil)
+	return entries, pageOrZero(page), err
+}
+
+// All iterates over every audit entry matching the options, starting at opts.Offset
+func (s *AuditService) All(opts AuditListOptions) *Iterator[models.AuditEntry] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.AuditEntry, error) {
+		opts.ListOptions = page
+		entries, _, err := s.List(ctx, opts)
+		return entries, err
+	})
+}
+
+// Export returns every audit entry matching the options as CSV, ignoring the paging
+func (s *AuditService) Export(ctx context.Context, opts AuditListOptions) ([]byte, error) {
+	var csv []byte
+	err := s.c.send(ctx, http.MethodGet, "/api/v2/audit/export", opts.query(), nil, &csv)
+	return csv, err
+}
@@ -0,0 +1,137 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// AuthService calls the /api/v2/auth endpoints
+type AuthService struct {
+	c *Client
+}
+
+// Login signs in and uses the session token for every following request. When
+// PasswordChangeRequired is set, only ChangePassword works until it is called. When
+// TwoFactorRequired is set, pass the token to VerifyTwoFactor with a code
+func (s *AuthService) Login(ctx context.Context, email, password string) (*models.LoginResponse, error) {
+	resp, err := doPointer[models.LoginResponse](ctx, s.c, http.MethodPost, "/api/v2/auth/login", models.LoginRequest{Email: email, Password: password})
+	if err != nil {
+		return nil, err
+	}
+	if !resp.TwoFactorRequired {
+		s.c.SetToken(resp.Token)
+	}
+	return resp, nil
+}
+
+// VerifyTwoFactor finishes a login with the token from Login and a code or recovery code,
+// and uses the new session for every following request
+func (s *AuthService) VerifyTwoFactor(ctx context.Context, req models.TwoFactorVerifyRequest) (*models.LoginResponse, error) {
+	resp, err := doPointer[models.LoginResponse](ctx, s.c, http.MethodPost, "/api/v2/auth/2fa/verify", req)
+	if err != nil {
+		return nil, err
+	}
+	s.c.SetToken(resp.Token)
+	return resp, nil
+}
+
+// EnrollTwoFactor creates a secret for an authenticator app, confirm it with ConfirmTwoFactor
+func (s *AuthService) EnrollTwoFactor(ctx context.Context) (*models.TwoFactorEnrollment, error) {
+	return doPointer[models.TwoFactorEnrollment](ctx, s.c, http.MethodPost, "/api/v2/auth/2fa/enroll", nil)
+}
+
+// ConfirmTwoFactor turns two-factor authentication on and returns the recovery codes
+func (s *AuthService) ConfirmTwoFactor(ctx context.Context, code string) ([]string, error) {
+	codes, _, err := do[models.RecoveryCodes](ctx, s.c, http.MethodPost, "/api/v2/auth/2fa/confirm", nil, models.TwoFactorCodeRequest{Code: code})
+	return codes.Codes, err
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes
+func (s *AuthService) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	codes, _, err := do[models.RecoveryCodes](ctx, s.c, http.MethodPost, "/api/v2/auth/2fa/recovery-codes", nil, models.TwoFactorCodeRequest{Code: code})
+	return codes.Codes, err
+}
+
+// DisableTwoFactor turns two-factor authentication off
+func (s *AuthService) DisableTwoFactor(ctx context.Context, password, code string) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/auth/2fa/disable", nil, models.TwoFactorDisableRequest{Password: password, Code: code}, nil)
+}
+
+// SSOProviders lists the single sign-on providers, only those accepting email when it is set.
+// Signing in with one happens in a browser, starting at its LoginURL
+func (s *AuthService) SSOProviders(ctx context.Context, email string) ([]models.SSOProvider, error) {
+	q := url.Values{}
+	if email != "" {
+		q.Set("email", email)
+	}
+	providers, _, err := do[[]models.SSOProvider](ctx, s.c, http.MethodGet, "/api/v2/auth/oidc", q, nil)
+	return providers, err
+}
+
+// Logout ends the session and stops sending its token
+func (s *AuthService) Logout(ctx context.Context) error {
+	if err := s.c.send(ctx, http.MethodPost, "/api/v2/auth/logout", nil, nil, nil); err != nil {
+		return err
+	}
+	s.c.SetToken("")
+	return nil
+}
+
+// Me returns the signed in user
+func (s *AuthService) Me(ctx context.Context) (*models.User, error) {
+	return doPointer[models.User](ctx, s.c, http.MethodGet, "/api/v2/auth/me", nil)
+}
+
+// ChangePassword replaces the password, including a temporary one. Other sessions are
+// signed out and the client switches to the new session token
+func (s *AuthService) ChangePassword(ctx context.Context, current, password string) (*models.LoginResponse, error) {
+	resp, err := doPointer[models.LoginResponse](ctx, s.c, http.MethodPost, "/api/v2/auth/password", models.ChangePasswordRequest{CurrentPassword: current, NewPassword: password})
+	if err != nil {
+		return nil, err
+	}
+	s.c.SetToken(resp.Token)
+	return resp, nil
+}
+
+// ForgotPassword emails a password reset link if the email has an account
+func (s *AuthService) ForgotPassword(ctx context.Context, email string) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/auth/forgot-password", nil, models.ForgotPasswordRequest{Email: email}, nil)
+}
+
+// ResetPassword sets a new password with the token from a reset link
+func (s *AuthService) ResetPassword(ctx context.Context, token, password string) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/auth/reset-password", nil, models.ResetPasswordRequest{Token: token, Password: password}, nil)
+}
+
+// Signup creates the account of an invitation with the token from its link, and uses the
+// new session for every following request. The names can be left empty when the
+// invitation has them
+func (s *AuthService) Signup(ctx context.Context, req models.SignupRequest) (*models.LoginResponse, error) {
+	resp, err := doPointer[models.LoginResponse](ctx, s.c, http.MethodPost, "/api/v2/auth/signup", req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.TwoFactorRequired {
+		s.c.SetToken(resp.Token)
+	}
+	return resp, nil
+}
+
+// Sessions lists the signed in sessions, the one of the client is marked Current
+func (s *AuthService) Sessions(ctx context.Context) ([]models.SessionInfo, error) {
+	sessions, _, err := do[[]models.SessionInfo](ctx, s.c, http.MethodGet, "/api/v2/auth/sessions", nil, nil)
+	return sessions, err
+}
+
+// RevokeSession signs out one session
+func (s *AuthService) RevokeSession(ctx context.Context, id string) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/auth/sessions/"+url.PathEscape(id), nil, nil, nil)
+}
+
+// RevokeOtherSessions signs out every session but the one of the client
+func (s *AuthService) RevokeOtherSessions(ctx context.Context) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/auth/sessions", nil, nil, nil)
+}
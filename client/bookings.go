@@ -0,0 +1,68 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// BookingService calls the /api/v2/cows/{cow_id}/bookings endpoints
+type BookingService struct {
+	c *Client
+}
+
+// List returns every booking of a cow
+func (s *BookingService) List(ctx context.Context, cowID models.ID) ([]models.BookDetails, error) {
+	bookings, _, err := do[[]models.BookDetails](ctx, s.c, http.MethodGet, bookingsPath(cowID), nil, nil)
+	return bookings, err
+}
+
+// Create books a cow and returns the booking with its generated ID. When the bookings of
+// the cow need approval it returns an *ApprovalPending error holding the request instead.
+// Bookings breaking a booking policy of the business fail with ErrPolicyViolation
+func (s *BookingService) Create(ctx context.Context, cowID models.ID, booking models.BookDetails) (*models.BookDetails, error) {
+	return s.create(ctx, cowID, booking, nil)
+}
+
+// Override books a cow like Create, past the booking policies of its business. Admins of
+// the business only
+func (s *BookingService) Override(ctx context.Context, cowID models.ID, booking models.BookDetails) (*models.BookDetails, error) {
+	return s.create(ctx, cowID, booking, url.Values{"override": {"true"}})
+}
+
+func (s *BookingService) create(ctx context.Context, cowID models.ID, booking models.BookDetails, query url.Values) (*models.BookDetails, error) {
+	var result envelope[json.RawMessage]
+	if err := s.c.send(ctx, http.MethodPost, bookingsPath(cowID), query, booking, &result); err != nil {
+		return nil, err
+	}
+	if result.Status == http.StatusAccepted {
+		pending := &ApprovalPending{}
+		if err := json.Unmarshal(result.Data.Result, &pending.Request); err != nil {
+			return nil, err
+		}
+		return nil, pending
+	}
+	created := &models.BookDetails{}
+	if err := json.Unmarshal(result.Data.Result, created); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ApprovalPending is returned by BookingService.Create for cows whose bookings need
+// approval. The booking is only added to the cow once an approver approves the request,
+// see BookingRequestService
+type ApprovalPending struct {
+	Request models.BookingRequest
+}
+
+func (e *ApprovalPending) Error() string {
+	return "the booking is waiting for approval, request " + e.Request.ID.String()
+}
+
+func bookingsPath(cowID models.ID) string {
+	return "/api/v2/cows/" + url.PathEscape(cowID.String()) + "/bookings"
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// AvailabilityOptions is the time to search free cows for, with filters and paging. From
+// and To are required
+type AvailabilityOptions struct {
+	ListOptions
+	From       time.Time
+	To         time.Time
+	Block      string // checks the block of the day From falls on as well
+	Business   models.ID
+	Collection string
+}
+
+func (o AvailabilityOptions) query() url.Values {
+	v := url.Values{}
+	v.Set("from", o.From.UTC().Format(time.RFC3339))
+	v.Set("to", o.To.UTC().Format(time.RFC3339))
+	if o.Block != "" {
+		v.Set("block", o.Block)
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	if o.Collection != "" {
+		v.Set("collection", o.Collection)
+	}
+	return v
+}
+
+// Availability returns a page of cows saying whether each can be booked for the time of
+// the options, and why not
+func (s *CowService) Availability(ctx context.Context, opts AvailabilityOptions) ([]models.CowAvailability, models.Page, error) {
+	cows, page, err := do[[]models.CowAvailability](ctx, s.c, http.MethodGet, "/api/v2/availability", withPage(opts.query(), opts.ListOptions), nil)
+	return cows, pageOrZero(page), err
+}
+
+// CalendarListOptions filters and pages the closures and blackouts of a business
+type CalendarListOptions struct {
+	ListOptions
+	Kind     string    // closure or blackout
+	Cow      models.ID // blackouts of the cow
+	From     time.Time // with To, entries overlapping the range
+	To       time.Time
+	Business models.ID // SuperUsers only, others always see their own business
+}
+
+func (o CalendarListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Kind != "" {
+		v.Set("kind", o.Kind)
+	}
+	if !o.Cow.IsZero() {
+		v.Set("cow", o.Cow.String())
+	}
+	if !o.From.IsZero() || !o.To.IsZero() {
+		v.Set("from", o.From.UTC().Format(time.RFC3339))
+		v.Set("to", o.To.UTC().Format(time.RFC3339))
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// CalendarService calls the /api/v2/calendar endpoints, the closures and blackouts cows
+// can't be booked on. Changes are for Admins
+type CalendarService struct {
+	c *Client
+}
+
+// List returns a page of calendar entries
+func (s *CalendarService) List(ctx context.Context, opts CalendarListOptions) ([]models.CalendarEntry, models.Page, error) {
+	entries, page, err := do[[]models.CalendarEntry](ctx, s.c, http.MethodGet, "/api/v2/calendar", withPage(opts.query(), opts.ListOptions), nil)
+	return entries, pageOrZero(page), err
+}
+
+// All iterates over every calendar entry matching the options, starting at opts.Offset
+func (s *CalendarService) All(opts CalendarListOptions) *Iterator[models.CalendarEntry] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.CalendarEntry, error) {
+		opts.ListOptions = page
+		entries, _, err := s.List(ctx, opts)
+		return entries, err
+	})
+}
+
+// Close closes a business for whole days
+func (s *CalendarService) Close(ctx context.Context, req models.ClosureRequest) (*models.CalendarEntry, error) {
+	return doPointer[models.CalendarEntry](ctx, s.c, http.MethodPost, "/api/v2/calendar/closures", req)
+}
+
+// Blackout keeps a cow, the cows of a device type or every cow of a business from being
+// booked for a while
+func (s *CalendarService) Blackout(ctx context.Context, req models.BlackoutRequest) (*models.CalendarEntry, error) {
+	return doPointer[models.CalendarEntry](ctx, s.c, http.MethodPost, "/api/v2/calendar/blackouts", req)
+}
+
+// Delete removes a closure or blackout
+func (s *CalendarService) Delete(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/calendar/"+url.PathEscape(id.String()), nil, nil, nil)
+}
+
+// Import adds the events of an iCal file, eg. the holiday calendar of a district, whole day
+// events as closures. Importing the same events again moves them. SuperUsers name the
+// business, Admins leave it zero
+func (s *CalendarService) Import(ctx context.Context, ical io.Reader, business models.ID) (*models.CalendarImport, error) {
+	data, err := io.ReadAll(ical)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	if !business.IsZero() {
+		q.Set("business", business.String())
+	}
+	result, _, err := do[models.CalendarImport](ctx, s.c, http.MethodPost, "/api/v2/calendar/import", q, rawBody{contentType: "text/calendar", data: data})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,268 @@
+// Package client is the Go SDK for the DeviceBookingAPI v2 REST API.
+//
+//	c, err := client.New("http://localhost:8000", client.WithToken(token))
+//	if err != nil {
+//		return err
+//	}
+//	cow, err := c.Cows.Get(ctx, cowID)
+//	if errors.Is(err, client.ErrNotFound) {
+//		...
+//	}
+//
+// Every method takes a context, list methods return a single page and All methods
+// return an iterator that fetches the following pages as needed.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const (
+	defaultRetries = 2
+	defaultBackoff = 200 * time.Millisecond
+	userAgent      = "DeviceBookingAPI-go-client"
+)
+
+// Client talks to one DeviceBookingAPI server. It is safe for concurrent use
+type Client struct {
+	Cows            *CowService
+	Devices         *DeviceService
+	Bookings        *BookingService
+	Auth            *AuthService
+	Users           *UserService
+	APIKeys         *APIKeyService
+	Invitations     *InvitationService
+	Audit           *AuditService
+	BookingRequests *BookingRequestService
+	Waitlist        *WaitlistService
+	BookingPolicies *BookingPolicyService
+	Calendar        *CalendarService
+
+	baseURL    *url.URL
+	httpClient *http.Client
+	retries    int
+	backoff    time.Duration
+	userAgent  string
+
+	mu    sync.RWMutex
+	token string
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient uses hc for every request instead of http.DefaultClient
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) { c.httpClient = hc }
+}
+
+// WithToken sends token as a bearer token with every request
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithRetries sets how many times idempotent requests are retried after a network error
+// or a 429, 502, 503 or 504 response. The wait starts at backoff and doubles on each retry
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) { c.retries, c.backoff = retries, backoff }
+}
+
+// WithUserAgent adds product to the User-Agent header, eg. "kiosk/1.2"
+func WithUserAgent(product string) Option {
+	return func(c *Client) { c.userAgent = product + " " + userAgent }
+}
+
+// New creates a client for the server at baseURL, eg. "https://booking.example.com"
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: base URL must be http or https, got %q", baseURL)
+	}
+
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+		userAgent:  userAgent,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.Cows = &CowService{c: c}
+	c.Devices = &DeviceService{c: c}
+	c.Bookings = &BookingService{c: c}
+	c.Auth = &AuthService{c: c}
+	c.Users = &UserService{c: c}
+	c.APIKeys = &APIKeyService{c: c}
+	c.Invitations = &InvitationService{c: c}
+	c.Audit = &AuditService{c: c}
+	c.BookingRequests = &BookingRequestService{c: c}
+	c.Waitlist = &WaitlistService{c: c}
+	c.BookingPolicies = &BookingPolicyService{c: c}
+	c.Calendar = &CalendarService{c: c}
+	return c, nil
+}
+
+// SetToken replaces the bearer token sent with every following request, an empty
+// token sends none
+func (c *Client) SetToken(token string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token = token
+}
+
+// Token returns the current bearer token
+func (c *Client) Token() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.token
+}
+
+// envelope is the standard response body of the API, see models.UserResponse
+type envelope[T any] struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		Result T            `json:"result"`
+		Page   *models.Page `json:"page"`
+	} `json:"data"`
+}
+
+// rawBody is a request body sent as is rather than as JSON, eg. a CSV
+type rawBody struct {
+	contentType string
+	data        []byte
+}
+
+// do sends a request with an optional JSON body and decodes data.result into a T
+func do[T any](ctx context.Context, c *Client, method, path string, query url.Values, body interface{}) (T, *models.Page, error) {
+	var result envelope[T]
+	err := c.send(ctx, method, path, query, body, &result)
+	return result.Data.Result, result.Data.Page, err
+}
+
+// send is a helper function sending a request, retrying it when allowed, and decoding a
+// successful JSON response into out, if out is not nil
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	var payload []byte
+	contentType := "application/json"
+	if raw, ok := body.(rawBody); ok {
+		payload, contentType = raw.data, raw.contentType
+	} else if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = query.Encode()
+
+	wait := c.backoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.attempt(ctx, method, u.String(), payload, contentType)
+		if err == nil && !retryStatus(resp.StatusCode) || attempt >= c.retries || !idempotent(method) {
+			if err != nil {
+				return err
+			}
+			return decode(resp, out)
+		}
+
+		if resp != nil {
+			if after := retryAfter(resp); after > 0 {
+				wait = after
+			}
+			resp.Body.Close()
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, method, u string, payload []byte, contentType string) (*http.Response, error) {
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", c.userAgent)
+	if payload != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token := c.Token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return c.httpClient.Do(req)
+}
+
+// decode is a helper function reading a response into out, or into an *Error for failures.
+// A *[]byte out gets the body as is, eg. a CSV
+func decode(resp *http.Response, out interface{}) error {
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		return responseError(resp, b)
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw = b
+		return nil
+	}
+	if out == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, out)
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter is a helper function reading a Retry-After header given in seconds
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
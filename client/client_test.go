@@ -0,0 +1,265 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/api/handlers"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/client"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	rootEmail    = "root@example.com"
+	rootPassword = "Password1!root"
+)
+
+// newServer is a helper function serving the API on an in-memory SQLite store, signed in
+// clients are made with signIn
+func newServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	app := &handlers.App{Config: config.Config{
+		Backend:       "sqlite",
+		URL:           ":memory:",
+		AutoMigrate:   true,
+		AdminEmail:    rootEmail,
+		AdminPassword: rootPassword,
+		SessionTTL:    time.Hour,
+	}}
+	if err := app.Initialize(); err != nil {
+		t.Fatalf("failed to start the API: %v", err)
+	}
+	server := httptest.NewServer(app.Router)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newClient is a helper function returning a client of the server that doesn't retry
+func newClient(t *testing.T, server *httptest.Server) *client.Client {
+	t.Helper()
+	c, err := client.New(server.URL, client.WithRetries(0, 0))
+	if err != nil {
+		t.Fatalf("failed to make a client: %v", err)
+	}
+	return c
+}
+
+// signIn is a helper function returning a client signed in as the user, changing a
+// temporary password first
+func signIn(ctx context.Context, t *testing.T, server *httptest.Server, email, password string) *client.Client {
+	t.Helper()
+	c := newClient(t, server)
+	resp, err := c.Auth.Login(ctx, email, password)
+	if err != nil {
+		t.Fatalf("failed to sign in as %s: %v", email, err)
+	}
+	if resp.PasswordChangeRequired {
+		if _, err := c.Auth.ChangePassword(ctx, password, password+"x"); err != nil {
+			t.Fatalf("failed to change the password of %s: %v", email, err)
+		}
+	}
+	return c
+}
+
+// newAdmin is a helper function adding an Admin of the business and signing them in
+func newAdmin(ctx context.Context, t *testing.T, server *httptest.Server, root *client.Client, business models.ID) *client.Client {
+	t.Helper()
+	email := fmt.Sprintf("admin-%s@example.com", business)
+	_, err := root.Users.Create(ctx, models.UserRequest{
+		FirstName: "Ada",
+		LastName:  "Admin",
+		Email:     email,
+		UserType:  models.TypeAdmin,
+		Business:  business,
+		Password:  "Password1!admin",
+	})
+	if err != nil {
+		t.Fatalf("failed to add an Admin: %v", err)
+	}
+	return signIn(ctx, t, server, email, "Password1!admin")
+}
+
+func TestAuth(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := newServer(t)
+
+	anonymous := newClient(t, server)
+	if _, err := anonymous.Auth.Me(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Me without signing in returned %v, want ErrUnauthorized", err)
+	}
+	if _, err := anonymous.Auth.Login(ctx, rootEmail, "Password1!wrong"); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Login with a wrong password returned %v, want ErrUnauthorized", err)
+	}
+
+	root := signIn(ctx, t, server, rootEmail, rootPassword)
+	me, err := root.Auth.Me(ctx)
+	if err != nil {
+		t.Fatalf("Me failed: %v", err)
+	}
+	if me.Details.Email != rootEmail || me.Details.UserType != models.TypeSuperUser {
+		t.Errorf("Me returned %s of type %d, want the SuperUser %s", me.Details.Email, me.Details.UserType, rootEmail)
+	}
+
+	if err := root.Auth.Logout(ctx); err != nil {
+		t.Fatalf("Logout failed: %v", err)
+	}
+	if _, err := root.Auth.Me(ctx); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Me after Logout returned %v, want ErrUnauthorized", err)
+	}
+}
+
+func TestCows(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := newServer(t)
+	root := signIn(ctx, t, server, rootEmail, rootPassword)
+	business := models.NewID()
+	admin := newAdmin(ctx, t, server, root, business)
+
+	anonymous := newClient(t, server)
+	if _, err := anonymous.Cows.Create(ctx, models.CowDetails{Name: "CA-00", Collection: "Laptop"}); !errors.Is(err, client.ErrUnauthorized) {
+		t.Errorf("Create without signing in returned %v, want ErrUnauthorized", err)
+	}
+
+	var ids []models.ID
+	for i := 0; i < 5; i++ {
+		cow, err := admin.Cows.Create(ctx, models.CowDetails{Name: fmt.Sprintf("CA-%02d", i), Collection: "Laptop", DeviceTotal: 30})
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+		if cow.ID == "" || cow.Details.Business != business {
+			t.Fatalf("Create returned cow %q of business %q, want an ID and business %q", cow.ID, cow.Details.Business, business)
+		}
+		ids = append(ids, cow.ID)
+	}
+	if _, err := admin.Cows.Create(ctx, models.CowDetails{Name: "CA-00", Collection: "Laptop"}); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("Create with a taken name returned %v, want ErrConflict", err)
+	}
+	if _, err := admin.Cows.Create(ctx, models.CowDetails{Name: "CA-99", Business: models.NewID()}); !errors.Is(err, client.ErrForbidden) {
+		t.Errorf("Create for another business returned %v, want ErrForbidden", err)
+	}
+
+	cows, page, err := admin.Cows.List(ctx, client.CowListOptions{ListOptions: client.ListOptions{Limit: 2, Offset: 2}})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(cows) != 2 || page.Limit != 2 || page.Offset != 2 {
+		t.Errorf("List returned %d cows and page %+v, want 2 cows and page {Limit:2 Offset:2}", len(cows), page)
+	}
+
+	// a page size that doesn't divide the cows checks the iterator stops after a short page
+	all, err := admin.Cows.All(client.CowListOptions{ListOptions: client.ListOptions{Limit: 2}}).Collect(ctx)
+	if err != nil {
+		t.Fatalf("All failed: %v", err)
+	}
+	if len(all) != len(ids) {
+		t.Errorf("All returned %d cows, want %d", len(all), len(ids))
+	}
+
+	updated, err := admin.Cows.Update(ctx, ids[0], models.CowDetails{Collection: "iPad"})
+	if err != nil {
+		t.Fatalf("Update failed: %v", err)
+	}
+	if updated.Details.Collection != "iPad" || updated.Details.Name != "CA-00" {
+		t.Errorf("Update returned %+v, want the collection changed and the name kept", updated.Details)
+	}
+
+	if err := admin.Cows.Delete(ctx, ids[0]); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := admin.Cows.Get(ctx, ids[0]); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("Get of a deleted cow returned %v, want ErrNotFound", err)
+	}
+}
+
+func TestBookings(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := newServer(t)
+	root := signIn(ctx, t, server, rootEmail, rootPassword)
+	admin := newAdmin(ctx, t, server, root, models.NewID())
+
+	cow, err := admin.Cows.Create(ctx, models.CowDetails{Name: "CA-01", Collection: "Laptop", DeviceTotal: 30})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	day := time.Now().AddDate(0, 0, 7).Truncate(24 * time.Hour)
+	booking := models.BookDetails{
+		Author:    "Ada Admin",
+		Block:     "1",
+		StartDate: primitive.NewDateTimeFromTime(day),
+		EndDate:   primitive.NewDateTimeFromTime(day.Add(time.Hour)),
+	}
+	created, err := admin.Bookings.Create(ctx, cow.ID, booking)
+	if err != nil {
+		t.Fatalf("Create booking failed: %v", err)
+	}
+	if created.ID == "" {
+		t.Fatal("Create booking returned no ID")
+	}
+	if _, err := admin.Bookings.Create(ctx, cow.ID, booking); !errors.Is(err, client.ErrConflict) {
+		t.Errorf("booking a booked block returned %v, want ErrConflict", err)
+	}
+	if _, err := admin.Bookings.Create(ctx, models.NewID(), booking); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("booking a missing cow returned %v, want ErrNotFound", err)
+	}
+
+	bookings, err := admin.Bookings.List(ctx, cow.ID)
+	if err != nil {
+		t.Fatalf("List bookings failed: %v", err)
+	}
+	if len(bookings) != 1 || bookings[0].ID != created.ID {
+		t.Errorf("List bookings returned %+v, want only %s", bookings, created.ID)
+	}
+
+	if err := admin.Bookings.Cancel(ctx, cow.ID, created.ID); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	if err := admin.Bookings.Cancel(ctx, cow.ID, created.ID); !errors.Is(err, client.ErrNotFound) {
+		t.Errorf("cancelling a cancelled booking returned %v, want ErrNotFound", err)
+	}
+	if _, err := admin.Bookings.Create(ctx, cow.ID, booking); err != nil {
+		t.Errorf("booking a cancelled block failed: %v", err)
+	}
+}
+
+func TestErrorDecoding(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	server := newServer(t)
+	root := signIn(ctx, t, server, rootEmail, rootPassword)
+
+	_, err := root.Users.Create(ctx, models.UserRequest{FirstName: "No", LastName: "Email", Email: "not-an-email", UserType: models.TypeUser})
+	if !errors.Is(err, client.ErrValidation) {
+		t.Fatalf("Create user with an invalid email returned %v, want ErrValidation", err)
+	}
+	var apiErr *client.Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Create user returned %T, want *client.Error", err)
+	}
+	if apiErr.Status != http.StatusBadRequest {
+		t.Errorf("validation error has status %d, want %d", apiErr.Status, http.StatusBadRequest)
+	}
+	if apiErr.RequestID == "" {
+		t.Error("validation error has no request ID")
+	}
+	if len(apiErr.Fields) == 0 || apiErr.Fields[0].Field == "" {
+		t.Errorf("validation error has fields %+v, want the invalid email", apiErr.Fields)
+	}
+	if errors.Is(err, client.ErrNotFound) {
+		t.Error("a validation error matches ErrNotFound")
+	}
+
+	if _, err := root.Cows.Get(ctx, "not an id"); !errors.Is(err, client.ErrInvalidParameter) {
+		t.Errorf("Get with an invalid ID returned %v, want ErrInvalidParameter", err)
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// CowListOptions filters and pages a list of cows
+type CowListOptions struct {
+	ListOptions
+	Name       string
+	Business   models.ID
+	Collection string
+}
+
+func (o CowListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Name != "" {
+		v.Set("name", o.Name)
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	if o.Collection != "" {
+		v.Set("collection", o.Collection)
+	}
+	return v
+}
+
+// CowService calls the /api/v2/cows endpoints
+type CowService struct {
+	c *Client
+}
+
+// List returns a page of cows
+func (s *CowService) List(ctx context.Context, opts CowListOptions) ([]models.Cow, models.Page, error) {
+	cows, page, err := do[[]models.Cow](ctx, s.c, http.MethodGet, "/api/v2/cows", withPage(opts.query(), opts.ListOptions), nil)
+	return cows, pageOrZero(page), err
+}
+
+// All iterates over every cow matching the options, starting at opts.Offset
+func (s *CowService) All(opts CowListOptions) *Iterator[models.Cow] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.Cow, error) {
+		opts.ListOptions = page
+		cows, _, err := s.List(ctx, opts)
+		return cows, err
+	})
+}
+
+// Get returns a cow by ID
+func (s *CowService) Get(ctx context.Context, id models.ID) (*models.Cow, error) {
+	return doPointer[models.Cow](ctx, s.c, http.MethodGet, "/api/v2/cows/"+url.PathEscape(id.String()), nil)
+}
+
+// Create adds a new cow and returns it with its ID
+func (s *CowService) Create(ctx context.Context, details models.CowDetails) (*models.Cow, error) {
+	return doPointer[models.Cow](ctx, s.c, http.MethodPost, "/api/v2/cows", details)
+}
+
+// Update changes the non zero name, business, collection and device total of a cow and
+// returns the updated cow
+func (s *CowService) Update(ctx context.Context, id models.ID, details models.CowDetails) (*models.Cow, error) {
+	return doPointer[models.Cow](ctx, s.c, http.MethodPatch, "/api/v2/cows/"+url.PathEscape(id.String()), details)
+}
+
+// Delete removes a cow and its bookings
+func (s *CowService) Delete(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/cows/"+url.PathEscape(id.String()), nil, nil, nil)
+}
+
+// Devices returns a page of the devices whose parent is the cow
+func (s *CowService) Devices(ctx context.Context, id models.ID, opts ListOptions) ([]models.Device, models.Page, error) {
+	devices, page, err := do[[]models.Device](ctx, s.c, http.MethodGet, "/api/v2/cows/"+url.PathEscape(id.String())+"/devices", opts.values(), nil)
+	return devices, pageOrZero(page), err
+}
+
+// AddDevice adds a device to the device list of a cow and returns the new list
+func (s *CowService) AddDevice(ctx context.Context, id, deviceID models.ID) ([]models.ID, error) {
+	devices, _, err := do[[]models.ID](ctx, s.c, http.MethodPost, "/api/v2/cows/"+url.PathEscape(id.String())+"/devices", nil, models.NewDeviceToCow{ID: deviceID})
+	return devices, err
+}
+
+// doPointer is a helper function for requests returning a single resource
+func doPointer[T any](ctx context.Context, c *Client, method, path string, body interface{}) (*T, error) {
+	result, _, err := do[T](ctx, c, method, path, nil, body)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// DeviceListOptions filters and pages a list of devices
+type DeviceListOptions struct {
+	ListOptions
+	Name   string
+	Type   string
+	Parent models.ID
+}
+
+func (o DeviceListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Name != "" {
+		v.Set("name", o.Name)
+	}
+	if o.Type != "" {
+		v.Set("type", o.Type)
+	}
+	if !o.Parent.IsZero() {
+		v.Set("parent", o.Parent.String())
+	}
+	return v
+}
+
+// DeviceService calls the /api/v2/devices endpoints
+type DeviceService struct {
+	c *Client
+}
+
+// List returns a page of devices
+func (s *DeviceService) List(ctx context.Context, opts DeviceListOptions) ([]models.Device, models.Page, error) {
+	devices, page, err := do[[]models.Device](ctx, s.c, http.MethodGet, "/api/v2/devices", withPage(opts.query(), opts.ListOptions), nil)
+	return devices, pageOrZero(page), err
+}
+
+// All iterates over every device matching the options, starting at opts.Offset
+func (s *DeviceService) All(opts DeviceListOptions) *Iterator[models.Device] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.Device, error) {
+		opts.ListOptions = page
+		devices, _, err := s.List(ctx, opts)
+		return devices, err
+	})
+}
+
+// Get returns a device by ID
+func (s *DeviceService) Get(ctx context.Context, id models.ID) (*models.Device, error) {
+	return doPointer[models.Device](ctx, s.c, http.MethodGet, "/api/v2/devices/"+url.PathEscape(id.String()), nil)
+}
+
+// Create adds a new device and returns it with its ID
+func (s *DeviceService) Create(ctx context.Context, details models.DeviceDetails) (*models.Device, error) {
+	return doPointer[models.Device](ctx, s.c, http.MethodPost, "/api/v2/devices", details)
+}
+
+// Update changes the non zero type, name and parent of a device and returns the updated device
+func (s *DeviceService) Update(ctx context.Context, id models.ID, details models.DeviceDetails) (*models.Device, error) {
+	return doPointer[models.Device](ctx, s.c, http.MethodPatch, "/api/v2/devices/"+url.PathEscape(id.String()), details)
+}
+
+// Delete removes a device
+func (s *DeviceService) Delete(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/devices/"+url.PathEscape(id.String()), nil, nil, nil)
+}
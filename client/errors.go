@@ -0,0 +1,92 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// Error is returned for every response with a 4xx or 5xx status. It holds the error
+// model of the server, compare it with the Err values using errors.Is
+type Error struct {
+	models.APIError
+}
+
+func (e *Error) Error() string {
+	msg := fmt.Sprintf("%s (%d %s)", e.Message, e.Status, e.Code)
+	if e.RequestID != "" {
+		msg += ", request " + e.RequestID
+	}
+	for _, field := range e.Fields {
+		msg += fmt.Sprintf("; %s %s", field.Field, field.Message)
+	}
+	return msg
+}
+
+// Is matches errors with the same code, so errors.Is(err, client.ErrNotFound) works
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Errors matching the stable codes of the server error model
+var (
+	ErrInvalidBody            = &Error{models.APIError{Code: "invalid_body"}}
+	ErrInvalidParameter       = &Error{models.APIError{Code: "invalid_parameter"}}
+	ErrValidation             = &Error{models.APIError{Code: "validation_failed"}}
+	ErrUnauthorized           = &Error{models.APIError{Code: "unauthorized"}}
+	ErrForbidden              = &Error{models.APIError{Code: "forbidden"}}
+	ErrPasswordChangeRequired = &Error{models.APIError{Code: "password_change_required"}}
+	ErrTwoFactorSetupRequired = &Error{models.APIError{Code: "two_factor_setup_required"}}
+	ErrNotFound               = &Error{models.APIError{Code: "not_found"}}
+	ErrMethodNotAllowed       = &Error{models.APIError{Code: "method_not_allowed"}}
+	ErrConflict               = &Error{models.APIError{Code: "conflict"}}
+	ErrPolicyViolation        = &Error{models.APIError{Code: "policy_violation"}}
+	ErrTooManyRequests        = &Error{models.APIError{Code: "too_many_requests"}}
+	ErrAccountLocked          = &Error{models.APIError{Code: "account_locked"}}
+	ErrInternal               = &Error{models.APIError{Code: "internal_error"}}
+)
+
+// responseError is a helper function reading the error model from a failed response. Errors
+// from proxies or older servers that don't use the model keep their status and body
+func responseError(resp *http.Response, body []byte) error {
+	var errResp models.ErrorResponse
+	if err := json.Unmarshal(body, &errResp); err == nil && errResp.Error.Code != "" {
+		return &Error{errResp.Error}
+	}
+
+	apiErr := models.APIError{
+		Code:      codeForStatus(resp.StatusCode),
+		Status:    resp.StatusCode,
+		Message:   strings.TrimSpace(string(body)),
+		RequestID: resp.Header.Get("X-Request-ID"),
+	}
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+	return &Error{apiErr}
+}
+
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrInvalidBody.Code
+	case http.StatusUnauthorized:
+		return ErrUnauthorized.Code
+	case http.StatusForbidden:
+		return ErrForbidden.Code
+	case http.StatusNotFound:
+		return ErrNotFound.Code
+	case http.StatusMethodNotAllowed:
+		return ErrMethodNotAllowed.Code
+	case http.StatusConflict:
+		return ErrConflict.Code
+	case http.StatusTooManyRequests:
+		return ErrTooManyRequests.Code
+	default:
+		return ErrInternal.Code
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// InvitationListOptions filters and pages a list of invitations
+type InvitationListOptions struct {
+	ListOptions
+	Status   string // pending or accepted
+	Email    string
+	Business models.ID // SuperUsers only, Admins always see their own business
+}
+
+func (o InvitationListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Status != "" {
+		v.Set("status", o.Status)
+	}
+	if o.Email != "" {
+		v.Set("email", o.Email)
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// InvitationService calls the /api/v2/invitations endpoints, as an Admin or SuperUser. The
+// invitee signs up with AuthService.Signup
+type InvitationService struct {
+	c *Client
+}
+
+// List returns a page of invitations
+func (s *InvitationService) List(ctx context.Context, opts InvitationListOptions) ([]models.Invitation, models.Page, error) {
+	invitations, page, err := do[[]models.Invitation](ctx, s.c, http.MethodGet, "/api/v2/invitations", withPage(opts.query(), opts.ListOptions), nil)
+	return invitations, pageOrZero(page), err
+}
+
+// All iterates over every invitation matching the options, starting at opts.Offset
+func (s *InvitationService) All(opts InvitationListOptions) *Iterator[models.Invitation] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.Invitation, error) {
+		opts.ListOptions = page
+		invitations, _, err := s.List(ctx, opts)
+		return invitations, err
+	})
+}
+
+// Get returns an invitation by ID
+func (s *InvitationService) Get(ctx context.Context, id models.ID) (*models.Invitation, error) {
+	return doPointer[models.Invitation](ctx, s.c, http.MethodGet, "/api/v2/invitations/"+url.PathEscape(id.String()), nil)
+}
+
+// Create invites an email. The signup link is returned only when the server could not
+// email it
+func (s *InvitationService) Create(ctx context.Context, req models.InvitationRequest) (*models.CreatedInvitation, error) {
+	return doPointer[models.CreatedInvitation](ctx, s.c, http.MethodPost, "/api/v2/invitations", req)
+}
+
+// Import invites every row of a CSV with a header naming the columns email, firstname,
+// lastname and optionally usertype. SuperUsers name the business, Admins leave it zero
+func (s *InvitationService) Import(ctx context.Context, csv io.Reader, business models.ID) (*models.InvitationImport, error) {
+	data, err := io.ReadAll(csv)
+	if err != nil {
+		return nil, err
+	}
+	q := url.Values{}
+	if !business.IsZero() {
+		q.Set("business", business.String())
+	}
+	result, _, err := do[models.InvitationImport](ctx, s.c, http.MethodPost, "/api/v2/invitations/import", q, rawBody{contentType: "text/csv", data: data})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Resend emails a pending invitation again with a new link, the previous one stops working
+func (s *InvitationService) Resend(ctx context.Context, id models.ID) (*models.CreatedInvitation, error) {
+	return doPointer[models.CreatedInvitation](ctx, s.c, http.MethodPost, "/api/v2/invitations/"+url.PathEscape(id.String())+"/resend", nil)
+}
+
+// Revoke deletes a pending invitation
+func (s *InvitationService) Revoke(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/invitations/"+url.PathEscape(id.String()), nil, nil, nil)
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// defaultPageSize is used by iterators when the list options don't set a limit
+const defaultPageSize = 100
+
+// ListOptions selects a page of a list, the zero value is the first page with the
+// server's default size
+type ListOptions struct {
+	Limit  int64
+	Offset int64
+}
+
+func (o ListOptions) values() url.Values {
+	v := url.Values{}
+	if o.Limit > 0 {
+		v.Set("limit", strconv.FormatInt(o.Limit, 10))
+	}
+	if o.Offset > 0 {
+		v.Set("offset", strconv.FormatInt(o.Offset, 10))
+	}
+	return v
+}
+
+// Iterator walks every item of a paginated list, fetching a page at a time
+//
+//	it := c.Cows.All(client.CowListOptions{Collection: "Laptop"})
+//	for it.Next(ctx) {
+//		cow := it.Value()
+//	}
+//	if err := it.Err(); err != nil {
+//		return err
+//	}
+type Iterator[T any] struct {
+	fetch func(ctx context.Context, page ListOptions) ([]T, error)
+	page  ListOptions
+	items []T
+	pos   int
+	last  bool
+	err   error
+}
+
+func newIterator[T any](page ListOptions, fetch func(ctx context.Context, page ListOptions) ([]T, error)) *Iterator[T] {
+	if page.Limit <= 0 {
+		page.Limit = defaultPageSize
+	}
+	return &Iterator[T]{fetch: fetch, page: page, pos: -1}
+}
+
+// Next advances to the next item, fetching the next page when needed. It returns false
+// when there are no more items or a request failed, check Err to tell them apart
+func (it *Iterator[T]) Next(ctx context.Context) bool {
+	if it.err != nil {
+		return false
+	}
+	if it.pos+1 < len(it.items) {
+		it.pos++
+		return true
+	}
+	if it.last {
+		return false
+	}
+
+	items, err := it.fetch(ctx, it.page)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.items, it.pos = items, 0
+	it.last = int64(len(items)) < it.page.Limit
+	it.page.Offset += int64(len(items))
+	return len(items) > 0
+}
+
+// Value returns the current item
+func (it *Iterator[T]) Value() T {
+	return it.items[it.pos]
+}
+
+// Err returns the error that stopped the iterator, if any
+func (it *Iterator[T]) Err() error {
+	return it.err
+}
+
+// Collect reads every remaining item into a slice
+func (it *Iterator[T]) Collect(ctx context.Context) ([]T, error) {
+	var all []T
+	for it.Next(ctx) {
+		all = append(all, it.Value())
+	}
+	return all, it.Err()
+}
+
+// withPage is a helper function adding the page to a copy of query
+func withPage(query url.Values, page ListOptions) url.Values {
+	v := page.values()
+	for key, values := range query {
+		v[key] = values
+	}
+	return v
+}
+
+// pageOrZero is a helper function for servers that don't send the page, eg. older versions
+func pageOrZero(page *models.Page) models.Page {
+	if page == nil {
+		return models.Page{}
+	}
+	return *page
+}
@@ -0,0 +1,46 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// BookingPolicyListOptions filters and pages a list of booking policies
+type BookingPolicyListOptions struct {
+	ListOptions
+	Business models.ID // SuperUsers only, Admins always see their own business
+}
+
+func (o BookingPolicyListOptions) query() url.Values {
+	v := url.Values{}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// BookingPolicyService calls the /api/v2/booking-policies endpoints, which limit the
+// bookings users make. Admins only
+type BookingPolicyService struct {
+	c *Client
+}
+
+// List returns a page of booking policies
+func (s *BookingPolicyService) List(ctx context.Context, opts BookingPolicyListOptions) ([]models.BookingPolicy, models.Page, error) {
+	policies, page, err := do[[]models.BookingPolicy](ctx, s.c, http.MethodGet, "/api/v2/booking-policies", withPage(opts.query(), opts.ListOptions), nil)
+	return policies, pageOrZero(page), err
+}
+
+// Set replaces the limits of a business for the role of the request, usertype 0 sets the
+// policy of every role without one
+func (s *BookingPolicyService) Set(ctx context.Context, req models.BookingPolicyRequest) (*models.BookingPolicy, error) {
+	return doPointer[models.BookingPolicy](ctx, s.c, http.MethodPut, "/api/v2/booking-policies", req)
+}
+
+// Delete removes a booking policy, the role falls back to the policy of every role
+func (s *BookingPolicyService) Delete(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, "/api/v2/booking-policies/"+url.PathEscape(id.String()), nil, nil, nil)
+}
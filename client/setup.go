@@ -0,0 +1,20 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// SetupRequired reports whether the first SuperUser still has to be created
+func (c *Client) SetupRequired(ctx context.Context) (bool, error) {
+	status, _, err := do[models.SetupStatus](ctx, c, http.MethodGet, "/setup", nil, nil)
+	return status.Required, err
+}
+
+// Setup creates the first SuperUser with the setup token logged by the API. It fails with
+// ErrForbidden once any user exists
+func (c *Client) Setup(ctx context.Context, req models.SetupRequest) (*models.User, error) {
+	return doPointer[models.User](ctx, c, http.MethodPost, "/setup", req)
+}
@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// UserListOptions filters and pages a list of users
+type UserListOptions struct {
+	ListOptions
+	Type     string // superuser, admin or user
+	Email    string
+	Disabled bool      // only deactivated users
+	Business models.ID // SuperUsers only, Admins always see their own business
+}
+
+func (o UserListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Type != "" {
+		v.Set("type", o.Type)
+	}
+	if o.Email != "" {
+		v.Set("email", o.Email)
+	}
+	if o.Disabled {
+		v.Set("disabled", "true")
+	}
+	if !o.Business.IsZero() {
+		v.Set("business", o.Business.String())
+	}
+	return v
+}
+
+// UserService calls the /api/v2/users endpoints, as an Admin or SuperUser
+type UserService struct {
+	c *Client
+}
+
+// List returns a page of users
+func (s *UserService) List(ctx context.Context, opts UserListOptions) ([]models.User, models.Page, error) {
+	users, page, err := do[[]models.User](ctx, s.c, http.MethodGet, "/api/v2/users", withPage(opts.query(), opts.ListOptions), nil)
+	return users, pageOrZero(page), err
+}
+
+// All iterates over every user matching the options, starting at opts.Offset
+func (s *UserService) All(opts UserListOptions) *Iterator[models.User] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.User, error) {
+		opts.ListOptions = page
+		users, _, err := s.List(ctx, opts)
+		return users, err
+	})
+}
+
+// Get returns a user by ID
+func (s *UserService) Get(ctx context.Context, id models.ID) (*models.User, error) {
+	return doPointer[models.User](ctx, s.c, http.MethodGet, "/api/v2/users/"+url.PathEscape(id.String()), nil)
+}
+
+// Create makes a new user. Without a password the user gets a temporary one, returned
+// only when the server could not email it
+func (s *UserService) Create(ctx context.Context, req models.UserRequest) (*models.CreatedUser, error) {
+	return doPointer[models.CreatedUser](ctx, s.c, http.MethodPost, "/api/v2/users", req)
+}
+
+// Update changes the non-empty fields of req and returns the updated user
+func (s *UserService) Update(ctx context.Context, id models.ID, req models.UserUpdateRequest) (*models.User, error) {
+	return doPointer[models.User](ctx, s.c, http.MethodPatch, "/api/v2/users/"+url.PathEscape(id.String()), req)
+}
+
+// SetRole promotes or demotes a user to userType, eg. models.TypeAdmin
+func (s *UserService) SetRole(ctx context.Context, id models.ID, userType int) (*models.User, error) {
+	return doPointer[models.User](ctx, s.c, http.MethodPut, "/api/v2/users/"+url.PathEscape(id.String())+"/role", models.RoleRequest{UserType: userType})
+}
+
+// Deactivate stops a user signing in, their bookings are kept
+func (s *UserService) Deactivate(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/users/"+url.PathEscape(id.String())+"/deactivate", nil, nil, nil)
+}
+
+// Reactivate lets a deactivated user sign in again
+func (s *UserService) Reactivate(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/users/"+url.PathEscape(id.String())+"/reactivate", nil, nil, nil)
+}
+
+// ResetPassword gives a user a temporary password. It is only returned when the server
+// could not email it
+func (s *UserService) ResetPassword(ctx context.Context, id models.ID) (*models.PasswordResetResult, error) {
+	return doPointer[models.PasswordResetResult](ctx, s.c, http.MethodPost, "/api/v2/users/"+url.PathEscape(id.String())+"/reset-password", nil)
+}
+
+// ResetTwoFactor removes the two-factor authentication of a user and signs them out
+func (s *UserService) ResetTwoFactor(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/users/"+url.PathEscape(id.String())+"/2fa/reset", nil, nil, nil)
+}
+
+// Unlock lets a user locked by failed logins sign in again
+func (s *UserService) Unlock(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodPost, "/api/v2/users/"+url.PathEscape(id.String())+"/unlock", nil, nil, nil)
+}
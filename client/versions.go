@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// Versions returns a page of the versions of a cow, oldest first. Admins only
+func (s *CowService) Versions(ctx context.Context, id models.ID, opts ListOptions) ([]models.Version, models.Page, error) {
+	return listVersions(ctx, s.c, "/api/v2/cows/"+url.PathEscape(id.String()), opts)
+}
+
+// Version returns how a cow looked at version n
+func (s *CowService) Version(ctx context.Context, id models.ID, n int) (*models.Version, error) {
+	return doPointer[models.Version](ctx, s.c, http.MethodGet, "/api/v2/cows/"+url.PathEscape(id.String())+"/versions/"+strconv.Itoa(n), nil)
+}
+
+// Diff lists the changes to a cow between two versions. A zero to is the latest version
+// and a zero from the one before to
+func (s *CowService) Diff(ctx context.Context, id models.ID, from, to int) (*models.VersionDiff, error) {
+	return diffVersions(ctx, s.c, "/api/v2/cows/"+url.PathEscape(id.String()), from, to)
+}
+
+// Revert sets the name, business, collection, device total and devices of a cow back to
+// version n and returns the cow. Its bookings are kept
+func (s *CowService) Revert(ctx context.Context, id models.ID, n int) (*models.Cow, error) {
+	return doPointer[models.Cow](ctx, s.c, http.MethodPost, "/api/v2/cows/"+url.PathEscape(id.String())+"/versions/"+strconv.Itoa(n)+"/revert", nil)
+}
+
+// Versions returns a page of the versions of a device, oldest first. Admins only
+func (s *DeviceService) Versions(ctx context.Context, id models.ID, opts ListOptions) ([]models.Version, models.Page, error) {
+	return listVersions(ctx, s.c, "/api/v2/devices/"+url.PathEscape(id.String()), opts)
+}
+
+// Version returns how a device looked at version n
+func (s *DeviceService) Version(ctx context.Context, id models.ID, n int) (*models.Version, error) {
+	return doPointer[models.Version](ctx, s.c, http.MethodGet, "/api/v2/devices/"+url.PathEscape(id.String())+"/versions/"+strconv.Itoa(n), nil)
+}
+
+// Diff lists the changes to a device between two versions, see CowService.Diff
+func (s *DeviceService) Diff(ctx context.Context, id models.ID, from, to int) (*models.VersionDiff, error) {
+	return diffVersions(ctx, s.c, "/api/v2/devices/"+url.PathEscape(id.String()), from, to)
+}
+
+// Revert sets the name, type and parent of a device back to version n and returns the device
+func (s *DeviceService) Revert(ctx context.Context, id models.ID, n int) (*models.Device, error) {
+	return doPointer[models.Device](ctx, s.c, http.MethodPost, "/api/v2/devices/"+url.PathEscape(id.String())+"/versions/"+strconv.Itoa(n)+"/revert", nil)
+}
+
+// listVersions is a helper function returning a page of the versions of the entity at path
+func listVersions(ctx context.Context, c *Client, path string, opts ListOptions) ([]models.Version, models.Page, error) {
+	versions, page, err := do[[]models.Version](ctx, c, http.MethodGet, path+"/versions", opts.values(), nil)
+	return versions, pageOrZero(page), err
+}
+
+// diffVersions is a helper function comparing two versions of the entity at path
+func diffVersions(ctx context.Context, c *Client, path string, from, to int) (*models.VersionDiff, error) {
+	q := url.Values{}
+	if from > 0 {
+		q.Set("from", strconv.Itoa(from))
+	}
+	if to > 0 {
+		q.Set("to", strconv.Itoa(to))
+	}
+	diff, _, err := do[models.VersionDiff](ctx, c, http.MethodGet, path+"/versions/diff", q, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &diff, nil
+}
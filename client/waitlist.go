@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// Cancel removes a booking from a cow, offering its block to the first person on its waitlist.
+// It needs the user who booked, or an Admin of the cow's business
+func (s *BookingService) Cancel(ctx context.Context, cowID models.ID, bookingID string) error {
+	return s.c.send(ctx, http.MethodDelete, bookingsPath(cowID)+"/"+url.PathEscape(bookingID), nil, nil, nil)
+}
+
+// WaitlistListOptions filters and pages a list of waitlist entries
+type WaitlistListOptions struct {
+	ListOptions
+	Status string // waiting, offered, accepted, missed, left or closed
+	Date   string // YYYY-MM-DD, only used by CowService.Waitlist
+	Block  string // only used by CowService.Waitlist
+}
+
+func (o WaitlistListOptions) query() url.Values {
+	v := url.Values{}
+	if o.Status != "" {
+		v.Set("status", o.Status)
+	}
+	if o.Date != "" {
+		v.Set("date", o.Date)
+	}
+	if o.Block != "" {
+		v.Set("block", o.Block)
+	}
+	return v
+}
+
+// Waitlist returns a page of the users queueing for the blocks of a cow in the order they
+// joined, those still waiting or offered a block unless opts.Status asks for others. Admins only
+func (s *CowService) Waitlist(ctx context.Context, cowID models.ID, opts WaitlistListOptions) ([]models.WaitlistEntry, models.Page, error) {
+	entries, page, err := do[[]models.WaitlistEntry](ctx, s.c, http.MethodGet, cowWaitlistPath(cowID), withPage(opts.query(), opts.ListOptions), nil)
+	return entries, pageOrZero(page), err
+}
+
+func cowWaitlistPath(cowID models.ID) string {
+	return "/api/v2/cows/" + url.PathEscape(cowID.String()) + "/waitlist"
+}
+
+// WaitlistService calls the /api/v2/waitlist endpoints, for users queueing for blocks of
+// cows that are already booked
+type WaitlistService struct {
+	c *Client
+}
+
+// Join queues the signed in user for the block of booking, which the cow must already be
+// booked for. It returns the entry with its place in the queue
+func (s *WaitlistService) Join(ctx context.Context, cowID models.ID, booking models.BookDetails) (*models.WaitlistPlace, error) {
+	return doPointer[models.WaitlistPlace](ctx, s.c, http.MethodPost, cowWaitlistPath(cowID), booking)
+}
+
+// List returns a page of your waitlist entries with their place in the queue
+func (s *WaitlistService) List(ctx context.Context, opts WaitlistListOptions) ([]models.WaitlistPlace, models.Page, error) {
+	entries, page, err := do[[]models.WaitlistPlace](ctx, s.c, http.MethodGet, "/api/v2/waitlist", withPage(opts.query(), opts.ListOptions), nil)
+	return entries, pageOrZero(page), err
+}
+
+// All iterates over every waitlist entry of yours matching the options, starting at opts.Offset
+func (s *WaitlistService) All(opts WaitlistListOptions) *Iterator[models.WaitlistPlace] {
+	return newIterator(opts.ListOptions, func(ctx context.Context, page ListOptions) ([]models.WaitlistPlace, error) {
+		opts.ListOptions = page
+		entries, _, err := s.List(ctx, opts)
+		return entries, err
+	})
+}
+
+// Get returns a waitlist entry by ID with its place in the queue
+func (s *WaitlistService) Get(ctx context.Context, id models.ID) (*models.WaitlistPlace, error) {
+	return doPointer[models.WaitlistPlace](ctx, s.c, http.MethodGet, waitlistPath(id), nil)
+}
+
+// Leave takes an entry off its waitlist, a block offered to it passes to the next person
+func (s *WaitlistService) Leave(ctx context.Context, id models.ID) error {
+	return s.c.send(ctx, http.MethodDelete, waitlistPath(id), nil, nil, nil)
+}
+
+// Accept books the block offered to an entry of yours. When the bookings of the cow need
+// approval it returns an *ApprovalPending error holding the request instead
+func (s *WaitlistService) Accept(ctx context.Context, id models.ID) (*models.BookDetails, error) {
+	var result envelope[json.RawMessage]
+	if err := s.c.send(ctx, http.MethodPost, waitlistPath(id)+"/accept", nil, nil, &result); err != nil {
+		return nil, err
+	}
+	if result.Status == http.StatusAccepted {
+		pending := &ApprovalPending{}
+		if err := json.Unmarshal(result.Data.Result, &pending.Request); err != nil {
+			return nil, err
+		}
+		return nil, pending
+	}
+	booking := &models.BookDetails{}
+	if err := json.Unmarshal(result.Data.Result, booking); err != nil {
+		return nil, err
+	}
+	return booking, nil
+}
+
+func waitlistPath(id models.ID) string {
+	return "/api/v2/waitlist/" + url.PathEscape(id.String())
+}
@@ -3,21 +3,115 @@ package config
 import (
 	"encoding/json"
 	"fmt"
-	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata" // TIME_ZONE and the TZIDs of imported calendars, on hosts without zoneinfo
 
 	"go.uber.org/zap"
 
-	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
 	"github.com/joho/godotenv"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
 )
 
 // Config holds the project config values
 type Config struct {
+	Backend      string // storage backend, "mongo" (default), "postgres" or "sqlite"
 	URL          string
 	DatabaseName string
 	BaseURL      string
 	Port         string
+	AutoMigrate  bool // run pending database migrations on startup
+
+	// The first SuperUser, created on startup when there are no users. Without an email a
+	// one time token is logged instead, to create the SuperUser with POST /setup
+	AdminEmail       string
+	AdminPassword    string // or read from the file in ADMIN_PASSWORD_FILE, eg. a mounted secret
+	AdminFirstName   string
+	AdminLastName    string
+	SetupToken       string // token for POST /setup, a random one is generated when empty
+	InteractiveSetup bool   // prompt for the first SuperUser on stdin, set by `serve -interactive-setup`
+
+	SessionTTL    time.Duration // how long a login lasts, SESSION_TTL eg. 12h
+	InvitationTTL time.Duration // how long an invitation link works, INVITATION_TTL eg. 72h
+
+	// How long a booking of a cow with an approval policy waits for an approver before it
+	// expires, BOOKING_APPROVAL_TTL eg. 48h. Requests also expire when the booking starts
+	BookingApprovalTTL time.Duration
+
+	// How long the first person on the waitlist of a block that frees up has to accept it
+	// before it is offered to the next, WAITLIST_HOLD eg. 2h. Offers also end when the block starts
+	WaitlistHold time.Duration
+
+	// The time zone of the businesses, TIME_ZONE eg. America/Vancouver. The blocks of
+	// bookings, closures and the weeks of booking policies are its days. UTC when unset
+	TimeZone *time.Location
+
+	// Two-factor authentication. Users with a UserType up to TwoFactorRequired must enroll
+	// before using the API, eg. models.TypeAdmin for Admins and SuperUsers. 0 leaves it optional
+	TwoFactorRequired int
+	TOTPIssuer        string // name shown in authenticator apps
+
+	// Password guessing. After a few failed logins each attempt has to wait longer, and
+	// LoginMaxFailures failures in a row lock the account for LoginLockout. Addresses are
+	// locked after LoginIPMaxFailures, set it high when a school shares one address. 0 never locks
+	LoginMaxFailures   int
+	LoginIPMaxFailures int
+	LoginLockout       time.Duration
+
+	// Reverse proxies in front of the API whose X-Forwarded-For header is believed, as
+	// addresses or CIDR ranges, TRUSTED_PROXIES eg. 10.0.0.0/8,127.0.0.1. Empty uses the
+	// address of the connection
+	TrustedProxies []string
+
+	// Single sign-on providers of the businesses, read from the JSON array in OIDC_PROVIDERS_FILE
+	OIDCProviders []OIDCProvider
+	// LDAP directories, eg. on-prem Active Directory, read from the JSON array in LDAP_PROVIDERS_FILE
+	LDAPProviders []LDAPProvider
+
+	// Outgoing email, eg. password resets. Without a host emails are only logged
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+}
+
+// OIDCProvider is an OpenID Connect identity provider, eg. a district Google or Microsoft
+// tenant, that signs in the users of one business
+type OIDCProvider struct {
+	Name         string    `json:"name"`        // used in the login URL, eg. "sd42-google"
+	DisplayName  string    `json:"displayName"` // shown on login pages, eg. "District Google account"
+	Business     models.ID `json:"business"`
+	Issuer       string    `json:"issuer"` // eg. https://accounts.google.com
+	ClientID     string    `json:"clientId"`
+	ClientSecret string    `json:"clientSecret"`
+	Scopes       []string  `json:"scopes"`      // requested on top of openid, email and profile
+	Domains      []string  `json:"domains"`     // email domains allowed to sign in, eg. "sd42.ca"
+	GroupsClaim  string    `json:"groupsClaim"` // ID token claim listing the groups, default "groups"
+	AdminGroups  []string  `json:"adminGroups"` // members are Admins of the business, everyone else a User
+}
+
+// LDAPProvider is an LDAP directory, eg. an on-prem Active Directory, whose users sign in to
+// one business with their directory password
+type LDAPProvider struct {
+	Name               string    `json:"name"` // eg. "sd42-ad"
+	Business           models.ID `json:"business"`
+	URL                string    `json:"url"`                // ldaps://dc1.sd42.local or ldap://dc1.sd42.local:389
+	StartTLS           bool      `json:"startTls"`           // upgrade ldap:// connections to TLS
+	InsecureSkipVerify bool      `json:"insecureSkipVerify"` // accept any certificate, only for testing
+	BindDN             string    `json:"bindDn"`             // service account that searches the directory
+	BindPassword       string    `json:"bindPassword"`
+	BaseDN             string    `json:"baseDn"`         // where users are searched, eg. "OU=Staff,DC=sd42,DC=local"
+	UserFilter         string    `json:"userFilter"`     // matches people, default "(objectClass=person)"
+	EmailAttribute     string    `json:"emailAttribute"` // default "mail", eg. "userPrincipalName"
+	Domains            []string  `json:"domains"`        // email domains that sign in with the directory
+	UserGroups         []string  `json:"userGroups"`     // DNs of the groups allowed to sign in, empty allows everyone under BaseDN
+	AdminGroups        []string  `json:"adminGroups"`    // DNs of the groups whose members are Admins
+	NestedGroups       bool      `json:"nestedGroups"`   // follow nested Active Directory groups
+	SyncInterval       string    `json:"syncInterval"`   // eg. "1h", how often accounts are synced, empty turns it off
 }
 
 // New sets up all config related services
@@ -34,21 +128,160 @@ func New() *Config {
 	defer logger.Sync()
 	_ = zap.ReplaceGlobals(logger)
 
+	backend := os.Getenv("DB_BACKEND")
+	if backend == "" {
+		backend = "mongo"
+	}
+
+	adminPassword := os.Getenv("ADMIN_PASSWORD")
+	if file := os.Getenv("ADMIN_PASSWORD_FILE"); adminPassword == "" && file != "" {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			zap.S().With(err).Warnw("failed to read ADMIN_PASSWORD_FILE", "file", file)
+		}
+		adminPassword = strings.TrimRight(string(b), "\r\n")
+	}
+
+	var twoFactorRequired int
+	switch value := os.Getenv("REQUIRE_TWO_FACTOR"); value {
+	case "":
+	case "superuser":
+		twoFactorRequired = models.TypeSuperUser
+	case "admin":
+		twoFactorRequired = models.TypeAdmin
+	case "all":
+		twoFactorRequired = models.TypeUser
+	default:
+		zap.S().Warnw("invalid REQUIRE_TWO_FACTOR, two-factor authentication stays optional", "value", value, "expected", "superuser, admin or all")
+	}
+
+	totpIssuer := os.Getenv("TOTP_ISSUER")
+	if totpIssuer == "" {
+		totpIssuer = "DeviceBooking"
+	}
+
+	var oidcProviders []OIDCProvider
+	if err := readJSONFile("OIDC_PROVIDERS_FILE", &oidcProviders); err != nil {
+		zap.S().With(err).Warn("failed to read OIDC_PROVIDERS_FILE, single sign-on is off")
+		oidcProviders = nil
+	}
+	var ldapProviders []LDAPProvider
+	if err := readJSONFile("LDAP_PROVIDERS_FILE", &ldapProviders); err != nil {
+		zap.S().With(err).Warn("failed to read LDAP_PROVIDERS_FILE, directory sign in is off")
+		ldapProviders = nil
+	}
+
+	smtpPort := os.Getenv("SMTP_PORT")
+	if smtpPort == "" {
+		smtpPort = "587"
+	}
+
 	return &Config{
+		Backend:      backend,
 		URL:          os.Getenv("DB_URI"),
 		DatabaseName: os.Getenv("DB_NAME"),
 		BaseURL:      os.Getenv("BASE_URL"),
 		Port:         os.Getenv("PORT"),
+		AutoMigrate:  os.Getenv("AUTO_MIGRATE") != "false",
+
+		AdminEmail:     os.Getenv("ADMIN_EMAIL"),
+		AdminPassword:  adminPassword,
+		AdminFirstName: os.Getenv("ADMIN_FIRSTNAME"),
+		AdminLastName:  os.Getenv("ADMIN_LASTNAME"),
+		SetupToken:     os.Getenv("SETUP_TOKEN"),
+
+		SessionTTL:         envDuration("SESSION_TTL", 24*time.Hour),
+		InvitationTTL:      envDuration("INVITATION_TTL", 7*24*time.Hour),
+		BookingApprovalTTL: envDuration("BOOKING_APPROVAL_TTL", 72*time.Hour),
+		WaitlistHold:       envDuration("WAITLIST_HOLD", 2*time.Hour),
+		TimeZone:           envLocation("TIME_ZONE", time.UTC),
+		TwoFactorRequired:  twoFactorRequired,
+		TOTPIssuer:         totpIssuer,
+
+		LoginMaxFailures:   envInt("LOGIN_MAX_FAILURES", 10),
+		LoginIPMaxFailures: envInt("LOGIN_IP_MAX_FAILURES", 100),
+		LoginLockout:       envDuration("LOGIN_LOCKOUT", 15*time.Minute),
+		TrustedProxies:     envList("TRUSTED_PROXIES"),
+
+		OIDCProviders: oidcProviders,
+		LDAPProviders: ldapProviders,
+
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     smtpPort,
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		MailFrom:     os.Getenv("MAIL_FROM"),
 	}
 }
 
-// ErrorStatus is a useful function that will log, write http headers and body for a
-// given message, status code and error
-func ErrorStatus(message string, httpStatusCode int, w http.ResponseWriter, err error) {
-	zap.S().With(err).Error(message)
-	w.WriteHeader(httpStatusCode)
-	b, _ := json.Marshal(models.ErrorMessageResponse{Response: models.MessageError{Message: message, Error: err.Error()}})
-	w.Write(b)
+// readJSONFile is a helper function decoding the file named by the environment variable
+// into v, it does nothing when the variable is unset
+func readJSONFile(variable string, v interface{}) error {
+	file := os.Getenv(variable)
+	if file == "" {
+		return nil
+	}
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// envDuration is a helper function reading a positive duration, eg. 15m, from the
+// environment variable, or def when it is unset or invalid
+func envDuration(variable string, def time.Duration) time.Duration {
+	value := os.Getenv(variable)
+	if value == "" {
+		return def
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		zap.S().Warnw("invalid "+variable+", using the default", "value", value, "default", def)
+		return def
+	}
+	return parsed
+}
+
+// envLocation is a helper function reading an IANA time zone name, eg. Europe/Berlin, from
+// the environment variable, or def when it is unset or unknown
+func envLocation(variable string, def *time.Location) *time.Location {
+	value := os.Getenv(variable)
+	if value == "" {
+		return def
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		zap.S().Warnw("invalid "+variable+", using the default", "value", value, "default", def.String())
+		return def
+	}
+	return loc
+}
+
+// envInt is a helper function reading a number that isn't negative from the environment
+// variable, or def when it is unset or invalid
+func envInt(variable string, def int) int {
+	value := os.Getenv(variable)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		zap.S().Warnw("invalid "+variable+", using the default", "value", value, "default", def)
+		return def
+	}
+	return parsed
+}
+
+// envList is a helper function reading a comma separated list from the environment variable
+func envList(variable string) []string {
+	var list []string
+	for _, value := range strings.Split(os.Getenv(variable), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			list = append(list, value)
+		}
+	}
+	return list
 }
 
 // setLogger is a helper function to set the Logger based on the environment
@@ -0,0 +1,76 @@
+package databases
+
+// go generate: mockery --name APIKeyDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const apiKeyDBO = "apikeys"
+
+// APIKeyDatabase contains the methods to use with the API key database
+type APIKeyDatabase interface {
+	FindOne(ctx context.Context, filter *APIKeyFilter) (*models.APIKey, error)
+	Find(ctx context.Context, filter *APIKeyFilter) ([]models.APIKey, error)
+	InsertOne(ctx context.Context, key models.APIKey) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *APIKeyFilter, update *APIKeyUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *APIKeyFilter) (*DeleteResult, error)
+}
+
+type apiKeyDatabase struct {
+	db DatabaseHelper
+}
+
+// NewAPIKeyDatabase initializes a new instance of an API key database with the provided db connection
+func NewAPIKeyDatabase(db DatabaseHelper) APIKeyDatabase {
+	return &apiKeyDatabase{
+		db: db,
+	}
+}
+
+func (k *apiKeyDatabase) FindOne(ctx context.Context, filter *APIKeyFilter) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	err := k.db.Collection(apiKeyDBO).FindOne(ctx, filter.query().Bson()).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (k *apiKeyDatabase) Find(ctx context.Context, filter *APIKeyFilter) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := k.db.Collection(apiKeyDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&keys)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (k *apiKeyDatabase) InsertOne(ctx context.Context, key models.APIKey) (*InsertOneResult, error) {
+	result, err := k.db.Collection(apiKeyDBO).InsertOne(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (k *apiKeyDatabase) UpdateOne(ctx context.Context, filter *APIKeyFilter, update *APIKeyUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := k.db.Collection(apiKeyDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (k *apiKeyDatabase) DeleteOne(ctx context.Context, filter *APIKeyFilter) (*DeleteResult, error) {
+	deleted, err := k.db.Collection(apiKeyDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
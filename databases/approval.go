@@ -0,0 +1,138 @@
+package databases
+
+// go generate: mockery --name ApprovalPolicyDatabase
+// go generate: mockery --name BookingRequestDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const (
+	approvalPolicyDBO = "approvalpolicies"
+	bookingRequestDBO = "bookingrequests"
+)
+
+// ApprovalPolicyDatabase contains the methods to use with the approval policies of cows.
+// A policy has the ID of its cow, so a cow has at most one
+type ApprovalPolicyDatabase interface {
+	FindOne(ctx context.Context, filter *ApprovalPolicyFilter) (*models.ApprovalPolicy, error)
+	Find(ctx context.Context, filter *ApprovalPolicyFilter) ([]models.ApprovalPolicy, error)
+	InsertOne(ctx context.Context, policy models.ApprovalPolicy) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *ApprovalPolicyFilter, update *ApprovalPolicyUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *ApprovalPolicyFilter) (*DeleteResult, error)
+}
+
+// BookingRequestDatabase contains the methods to use with the bookings waiting for, or
+// decided by, an approver
+type BookingRequestDatabase interface {
+	FindOne(ctx context.Context, filter *BookingRequestFilter) (*models.BookingRequest, error)
+	Find(ctx context.Context, filter *BookingRequestFilter) ([]models.BookingRequest, error)
+	InsertOne(ctx context.Context, request models.BookingRequest) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *BookingRequestFilter, update *BookingRequestUpdate) (*UpdateResult, error)
+}
+
+type approvalPolicyDatabase struct {
+	db DatabaseHelper
+}
+
+// NewApprovalPolicyDatabase initializes a new instance of an approval policy database with the provided db connection
+func NewApprovalPolicyDatabase(db DatabaseHelper) ApprovalPolicyDatabase {
+	return &approvalPolicyDatabase{
+		db: db,
+	}
+}
+
+func (a *approvalPolicyDatabase) FindOne(ctx context.Context, filter *ApprovalPolicyFilter) (*models.ApprovalPolicy, error) {
+	policy := &models.ApprovalPolicy{}
+	err := a.db.Collection(approvalPolicyDBO).FindOne(ctx, filter.query().Bson()).Decode(&policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (a *approvalPolicyDatabase) Find(ctx context.Context, filter *ApprovalPolicyFilter) ([]models.ApprovalPolicy, error) {
+	var policies []models.ApprovalPolicy
+	err := a.db.Collection(approvalPolicyDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&policies)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (a *approvalPolicyDatabase) InsertOne(ctx context.Context, policy models.ApprovalPolicy) (*InsertOneResult, error) {
+	result, err := a.db.Collection(approvalPolicyDBO).InsertOne(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (a *approvalPolicyDatabase) UpdateOne(ctx context.Context, filter *ApprovalPolicyFilter, update *ApprovalPolicyUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := a.db.Collection(approvalPolicyDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (a *approvalPolicyDatabase) DeleteOne(ctx context.Context, filter *ApprovalPolicyFilter) (*DeleteResult, error) {
+	deleted, err := a.db.Collection(approvalPolicyDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
+
+type bookingRequestDatabase struct {
+	db DatabaseHelper
+}
+
+// NewBookingRequestDatabase initializes a new instance of a booking request database with the provided db connection
+func NewBookingRequestDatabase(db DatabaseHelper) BookingRequestDatabase {
+	return &bookingRequestDatabase{
+		db: db,
+	}
+}
+
+func (b *bookingRequestDatabase) FindOne(ctx context.Context, filter *BookingRequestFilter) (*models.BookingRequest, error) {
+	request := &models.BookingRequest{}
+	err := b.db.Collection(bookingRequestDBO).FindOne(ctx, filter.query().Bson()).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+func (b *bookingRequestDatabase) Find(ctx context.Context, filter *BookingRequestFilter) ([]models.BookingRequest, error) {
+	var requests []models.BookingRequest
+	err := b.db.Collection(bookingRequestDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&requests)
+	if err != nil {
+		return nil, err
+	}
+	return requests, nil
+}
+
+func (b *bookingRequestDatabase) InsertOne(ctx context.Context, request models.BookingRequest) (*InsertOneResult, error) {
+	result, err := b.db.Collection(bookingRequestDBO).InsertOne(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *bookingRequestDatabase) UpdateOne(ctx context.Context, filter *BookingRequestFilter, update *BookingRequestUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := b.db.Collection(bookingRequestDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,46 @@
+package databases
+
+// go generate: mockery --name AuditDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const auditDBO = "audit"
+
+// AuditDatabase contains the methods to use with the audit log. It is append-only, entries
+// can't be changed or deleted once inserted
+type AuditDatabase interface {
+	Find(ctx context.Context, filter *AuditFilter) ([]models.AuditEntry, error)
+	InsertOne(ctx context.Context, entry models.AuditEntry) (*InsertOneResult, error)
+}
+
+type auditDatabase struct {
+	db DatabaseHelper
+}
+
+// NewAuditDatabase initializes a new instance of an audit log with the provided db connection
+func NewAuditDatabase(db DatabaseHelper) AuditDatabase {
+	return &auditDatabase{
+		db: db,
+	}
+}
+
+func (a *auditDatabase) Find(ctx context.Context, filter *AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	err := a.db.Collection(auditDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (a *auditDatabase) InsertOne(ctx context.Context, entry models.AuditEntry) (*InsertOneResult, error) {
+	result, err := a.db.Collection(auditDBO).InsertOne(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,77 @@
+package databases
+
+// go generate: mockery --name CalendarDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const calendarDBO = "calendar"
+
+// CalendarDatabase contains the methods to use with the closures and blackouts in the
+// calendars of businesses
+type CalendarDatabase interface {
+	FindOne(ctx context.Context, filter *CalendarFilter) (*models.CalendarEntry, error)
+	Find(ctx context.Context, filter *CalendarFilter) ([]models.CalendarEntry, error)
+	InsertOne(ctx context.Context, entry models.CalendarEntry) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *CalendarFilter, update *CalendarUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *CalendarFilter) (*DeleteResult, error)
+}
+
+type calendarDatabase struct {
+	db DatabaseHelper
+}
+
+// NewCalendarDatabase initializes a new instance of a calendar database with the provided db connection
+func NewCalendarDatabase(db DatabaseHelper) CalendarDatabase {
+	return &calendarDatabase{
+		db: db,
+	}
+}
+
+func (c *calendarDatabase) FindOne(ctx context.Context, filter *CalendarFilter) (*models.CalendarEntry, error) {
+	entry := &models.CalendarEntry{}
+	err := c.db.Collection(calendarDBO).FindOne(ctx, filter.query().Bson()).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (c *calendarDatabase) Find(ctx context.Context, filter *CalendarFilter) ([]models.CalendarEntry, error) {
+	var entries []models.CalendarEntry
+	err := c.db.Collection(calendarDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (c *calendarDatabase) InsertOne(ctx context.Context, entry models.CalendarEntry) (*InsertOneResult, error) {
+	result, err := c.db.Collection(calendarDBO).InsertOne(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *calendarDatabase) UpdateOne(ctx context.Context, filter *CalendarFilter, update *CalendarUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := c.db.Collection(calendarDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (c *calendarDatabase) DeleteOne(ctx context.Context, filter *CalendarFilter) (*DeleteResult, error) {
+	deleted, err := c.db.Collection(calendarDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
@@ -0,0 +1,1146 @@
+// Package conformance holds the behaviour every databases.Store implementation must
+// share. Backends call Run from their own tests with a freshly migrated store
+package conformance
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// Run exercises the cow, device, user, API key, invitation, audit, version, approval, waitlist, booking policy and calendar databases of the store. Every document
+// it creates is scoped to a unique business so it can share a database with other data
+func Run(t *testing.T, store databases.Store) {
+	business := models.NewID()
+
+	t.Run("Cows", func(t *testing.T) { testCows(t, store.Cows(), business) })
+	t.Run("Devices", func(t *testing.T) { testDevices(t, store.Devices()) })
+	t.Run("Users", func(t *testing.T) { testUsers(t, store.Users(), business) })
+	t.Run("APIKeys", func(t *testing.T) { testAPIKeys(t, store.APIKeys(), business) })
+	t.Run("Invitations", func(t *testing.T) { testInvitations(t, store.Invitations(), business) })
+	t.Run("Audit", func(t *testing.T) { testAudit(t, store.Audit(), business) })
+	t.Run("Versions", func(t *testing.T) { testVersions(t, store.Versions(), business) })
+	t.Run("Approvals", func(t *testing.T) {
+		testApprovals(t, store.Cows(), store.ApprovalPolicies(), store.BookingRequests(), business)
+	})
+	t.Run("Waitlist", func(t *testing.T) { testWaitlist(t, store.Cows(), store.Waitlist(), business) })
+	t.Run("BookingPolicies", func(t *testing.T) { testBookingPolicies(t, store.Cows(), store.BookingPolicies(), business) })
+	t.Run("Calendar", func(t *testing.T) { testCalendar(t, store.Calendar(), business) })
+}
+
+func testCows(t *testing.T, db databases.CowDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	device1, device2 := models.NewID(), models.NewID()
+
+	cow := models.Cow{
+		ID: models.NewID(),
+		Details: models.CowDetails{
+			Name:        "CA-01",
+			Business:    business,
+			Collection:  "Laptop",
+			DeviceTotal: 2,
+			Bookings:    []models.BookDetails{},
+			Devices:     []models.ID{device1},
+		},
+	}
+
+	result, err := db.InsertOne(ctx, cow)
+	if err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+	if result.InsertedID != cow.ID {
+		t.Errorf("InsertOne: inserted id = %v, want %s", result.InsertedID, cow.ID)
+	}
+
+	other := models.Cow{ID: models.NewID(), Details: models.CowDetails{Name: "CA-02", Business: business, Bookings: []models.BookDetails{}, Devices: []models.ID{}}}
+	if _, err := db.InsertOne(ctx, other); err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+
+	duplicate := models.Cow{ID: models.NewID(), Details: models.CowDetails{Name: "CA-01", Business: business}}
+	if _, err := db.InsertOne(ctx, duplicate); !errors.Is(err, databases.ErrDuplicate) {
+		t.Errorf("InsertOne: got %v inserting a duplicate cow name within a business, want ErrDuplicate", err)
+	}
+
+	found, err := db.FindOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if found.Details.Name != "CA-01" || found.Details.Collection != "Laptop" || found.Details.DeviceTotal != 2 {
+		t.Errorf("FindOne: got %+v", found.Details)
+	}
+	if len(found.Details.Devices) != 1 || found.Details.Devices[0] != device1 {
+		t.Errorf("FindOne: devices = %v, want [%s]", found.Details.Devices, device1)
+	}
+
+	if _, err := db.FindOne(ctx, databases.FilterCows().ID(models.NewID())); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("FindOne: got %v for a missing cow, want ErrNotFound", err)
+	}
+
+	cows, err := db.Find(ctx, databases.FilterCows().Business(business))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(cows) != 2 {
+		t.Errorf("Find: got %d cows, want 2", len(cows))
+	}
+
+	cows, err = db.Find(ctx, databases.FilterCows().Business(business).Name("CA-02"))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(cows) != 1 || cows[0].ID != other.ID {
+		t.Errorf("Find by name: got %v", cows)
+	}
+
+	updated, err := db.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().SetCollection("iPad").SetDeviceTotal(30))
+	if err != nil {
+		t.Fatalf("UpdateOne $set: %v", err)
+	}
+	if updated.MatchedCount != 1 {
+		t.Errorf("UpdateOne $set: matched %d, want 1", updated.MatchedCount)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushDevice(device2)); err != nil {
+		t.Fatalf("UpdateOne $push device: %v", err)
+	}
+
+	start := time.Date(2022, 9, 6, 8, 30, 0, 0, time.UTC)
+	booking := models.BookDetails{
+		ID:        cow.ID.String() + ".booking",
+		Author:    "teacher",
+		Devices:   []models.ID{device1, device2},
+		Block:     "A",
+		StartDate: primitive.NewDateTimeFromTime(start),
+		EndDate:   primitive.NewDateTimeFromTime(start.Add(80 * time.Minute)),
+	}
+	if _, err := db.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(booking)); err != nil {
+		t.Fatalf("UpdateOne $push booking: %v", err)
+	}
+
+	found, err = db.FindOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if found.Details.Collection != "iPad" || found.Details.DeviceTotal != 30 {
+		t.Errorf("after $set: got %+v", found.Details)
+	}
+	if len(found.Details.Devices) != 2 || found.Details.Devices[1] != device2 {
+		t.Errorf("after $push: devices = %v", found.Details.Devices)
+	}
+	if len(found.Details.Bookings) != 1 {
+		t.Fatalf("after $push: got %d bookings, want 1", len(found.Details.Bookings))
+	}
+	got := found.Details.Bookings[0]
+	if got.ID != booking.ID || got.Author != "teacher" || got.Block != "A" || len(got.Devices) != 2 {
+		t.Errorf("booking = %+v, want %+v", got, booking)
+	}
+	if !got.StartDate.Time().Equal(start) || !got.EndDate.Time().Equal(start.Add(80*time.Minute)) {
+		t.Errorf("booking dates = %v - %v", got.StartDate.Time(), got.EndDate.Time())
+	}
+
+	booked, err := db.Find(ctx, databases.FilterCows().Business(business).BookedBetween(start.Add(time.Hour), start.Add(2*time.Hour)))
+	if err != nil {
+		t.Fatalf("Find booked between: %v", err)
+	}
+	if len(booked) != 1 || booked[0].ID != cow.ID {
+		t.Errorf("Find booked between: got %d cows, want only %s", len(booked), cow.ID)
+	}
+
+	free, err := db.Find(ctx, databases.FilterCows().Business(business).BookedBetween(start.Add(80*time.Minute), start.Add(3*time.Hour)))
+	if err != nil {
+		t.Fatalf("Find booked between: %v", err)
+	}
+	if len(free) != 0 {
+		t.Errorf("Find booked between: a booking ending at the start of the range must not match, got %d cows", len(free))
+	}
+
+	day := time.Date(2022, 9, 6, 0, 0, 0, 0, time.UTC)
+	again := models.BookDetails{ID: cow.ID.String() + ".again", Author: "teacher", Block: "A", StartDate: booking.StartDate, EndDate: booking.EndDate}
+	taken, err := db.UpdateOne(ctx, databases.FilterCows().ID(cow.ID).SlotFree("A", day, day.AddDate(0, 0, 1)), databases.UpdateCow().PushBooking(again))
+	if err != nil {
+		t.Fatalf("UpdateOne slot free: %v", err)
+	}
+	if taken.MatchedCount != 0 {
+		t.Errorf("UpdateOne slot free: matched %d pushing a booked block, want 0", taken.MatchedCount)
+	}
+	for _, slot := range []struct {
+		block string
+		day   time.Time
+	}{{"B", day}, {"A", day.AddDate(0, 0, 1)}} {
+		matched, err := db.UpdateOne(ctx, databases.FilterCows().ID(cow.ID).SlotFree(slot.block, slot.day, slot.day.AddDate(0, 0, 1)), databases.UpdateCow().SetDeviceTotal(30))
+		if err != nil {
+			t.Fatalf("UpdateOne slot free: %v", err)
+		}
+		if matched.MatchedCount != 1 {
+			t.Errorf("UpdateOne slot free: matched %d for block %s on %s, want 1", matched.MatchedCount, slot.block, slot.day.Format("2006-01-02"))
+		}
+	}
+	found, err = db.FindOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if len(found.Details.Bookings) != 1 {
+		t.Errorf("UpdateOne slot free: got %d bookings, want the block booked once", len(found.Details.Bookings))
+	}
+
+	page, err := db.Find(ctx, databases.FilterCows().Business(business).Skip(1).Limit(5))
+	if err != nil {
+		t.Fatalf("Find page: %v", err)
+	}
+	want := cow.ID
+	if other.ID > cow.ID {
+		want = other.ID
+	}
+	if len(page) != 1 || page[0].ID != want {
+		t.Errorf("Find page: got %v, want the second cow by ID", page)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow()); err != databases.ErrEmptyUpdate {
+		t.Errorf("UpdateOne empty: got %v, want ErrEmptyUpdate", err)
+	}
+
+	missing, err := db.UpdateOne(ctx, databases.FilterCows().ID(models.NewID()), databases.UpdateCow().SetName("nope"))
+	if err != nil {
+		t.Fatalf("UpdateOne missing: %v", err)
+	}
+	if missing.MatchedCount != 0 {
+		t.Errorf("UpdateOne missing: matched %d, want 0", missing.MatchedCount)
+	}
+
+	deleted, err := db.DeleteOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("DeleteOne: %v", err)
+	}
+	if deleted.DeletedCount != 1 {
+		t.Errorf("DeleteOne: deleted %d, want 1", deleted.DeletedCount)
+	}
+	if _, err := db.FindOne(ctx, databases.FilterCows().ID(cow.ID)); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("FindOne: got %v for a deleted cow, want ErrNotFound", err)
+	}
+	booked, err = db.Find(ctx, databases.FilterCows().Business(business).BookedBetween(start, start.Add(time.Hour)))
+	if err != nil {
+		t.Fatalf("Find booked between: %v", err)
+	}
+	if len(booked) != 0 {
+		t.Errorf("Find booked between: the bookings of a deleted cow must be removed, got %d cows", len(booked))
+	}
+
+	deleted, err = db.DeleteOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("DeleteOne missing: %v", err)
+	}
+	if deleted.DeletedCount != 0 {
+		t.Errorf("DeleteOne missing: deleted %d, want 0", deleted.DeletedCount)
+	}
+}
+
+func testDevices(t *testing.T, db databases.DeviceDatabase) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	parent := models.NewID()
+	for _, name := range []string{"LAP-01", "LAP-02"} {
+		device := models.Device{ID: models.NewID(), Details: models.DeviceDetails{Type: "Laptop", Name: name, Parent: parent}}
+		if _, err := db.InsertOne(ctx, device); err != nil {
+			t.Fatalf("InsertOne: %v", err)
+		}
+	}
+
+	children, err := db.Find(ctx, databases.FilterDevices().Parent(parent))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(children) != 2 {
+		t.Fatalf("Find by parent: got %d devices, want 2", len(children))
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterDevices().ID(children[0].ID), databases.UpdateDevice().SetName("LAP-99")); err != nil {
+		t.Fatalf("UpdateOne: %v", err)
+	}
+
+	found, err := db.FindOne(ctx, databases.FilterDevices().ID(children[0].ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if found.Details.Name != "LAP-99" || found.Details.Parent != parent || found.Details.Type != "Laptop" {
+		t.Errorf("FindOne: got %+v", found.Details)
+	}
+
+	deleted, err := db.DeleteOne(ctx, databases.FilterDevices().ID(children[0].ID))
+	if err != nil {
+		t.Fatalf("DeleteOne: %v", err)
+	}
+	if deleted.DeletedCount != 1 {
+		t.Errorf("DeleteOne: deleted %d, want 1", deleted.DeletedCount)
+	}
+	children, err = db.Find(ctx, databases.FilterDevices().Parent(parent))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(children) != 1 {
+		t.Errorf("Find after DeleteOne: got %d devices, want 1", len(children))
+	}
+}
+
+func testUsers(t *testing.T, db databases.UserDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	user := models.User{
+		ID: models.NewID(),
+		Details: models.UserDetails{
+			FirstName:  "Ada",
+			LastName:   "Lovelace",
+			Email:      business.String() + "@example.com",
+			Password:   "hash",
+			UID:        business.String(),
+			Business:   business,
+			UserType:   models.TypeAdmin,
+			Created_at: now,
+			Updated_at: now,
+		},
+	}
+	if _, err := db.InsertOne(ctx, user); err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+
+	duplicate := user
+	duplicate.ID = models.NewID()
+	duplicate.Details.UID = business.String() + "-2"
+	if _, err := db.InsertOne(ctx, duplicate); !errors.Is(err, databases.ErrDuplicate) {
+		t.Errorf("InsertOne: got %v inserting a duplicate email, want ErrDuplicate", err)
+	}
+
+	found, err := db.FindOne(ctx, databases.FilterUsers().Email(user.Details.Email))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if found.ID != user.ID || found.Details.UserType != models.TypeAdmin || !found.Details.Created_at.Equal(now) {
+		t.Errorf("FindOne: got %+v", found)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetTempPassword(true)); err != nil {
+		t.Fatalf("UpdateOne: %v", err)
+	}
+
+	users, err := db.Find(ctx, databases.FilterUsers().Business(business))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(users) != 1 || !users[0].Details.TempPassword {
+		t.Errorf("Find: got %+v", users)
+	}
+
+	expires := now.Add(time.Hour)
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetResetToken("reset-hash", expires)); err != nil {
+		t.Fatalf("UpdateOne: %v", err)
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ResetToken("reset-hash"))
+	if err != nil {
+		t.Fatalf("FindOne by reset token: %v", err)
+	}
+	if found.ID != user.ID || !found.Details.ResetTokenExpires.Equal(expires) {
+		t.Errorf("FindOne by reset token: got %+v", found.Details)
+	}
+
+	first := models.Session{ID: "s1", TokenHash: "h1", PasswordChange: true, IP: "10.0.0.1", UserAgent: "test", CreatedAt: now, ExpiresAt: expires}
+	second := models.Session{ID: "s2", TokenHash: "h2", CreatedAt: now, ExpiresAt: expires}
+	for _, session := range []models.Session{first, second} {
+		if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().PushSession(session)); err != nil {
+			t.Fatalf("UpdateOne PushSession: %v", err)
+		}
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if len(found.Details.Sessions) != 2 || found.Details.Sessions[0] != first || found.Details.Sessions[1].ID != "s2" {
+		t.Errorf("FindOne after PushSession: got sessions %+v", found.Details.Sessions)
+	}
+
+	update := databases.UpdateUser().SetSessions(nil).SetResetToken("", time.Time{})
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		t.Fatalf("UpdateOne SetSessions: %v", err)
+	}
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().PushSession(second)); err != nil {
+		t.Fatalf("UpdateOne PushSession after SetSessions(nil): %v", err)
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if len(found.Details.Sessions) != 1 || found.Details.ResetTokenHash != "" || !found.Details.ResetTokenExpires.IsZero() {
+		t.Errorf("FindOne after SetSessions: got %+v", found.Details)
+	}
+
+	update = databases.UpdateUser().SetTOTP("SECRET", true).SetRecoveryCodes([]string{"r1", "r2"})
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		t.Fatalf("UpdateOne SetTOTP: %v", err)
+	}
+	// a step is accepted once, the second update with it must not match
+	for i, want := range []int64{1, 0} {
+		result, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID).TOTPStepBefore(42), databases.UpdateUser().SetTOTPLastStep(42))
+		if err != nil {
+			t.Fatalf("UpdateOne TOTPStepBefore: %v", err)
+		}
+		if result.MatchedCount != want {
+			t.Errorf("UpdateOne TOTPStepBefore #%d: matched %d, want %d", i+1, result.MatchedCount, want)
+		}
+	}
+	// a recovery code is used once, the second update with it must not match
+	for i, want := range []int64{1, 0} {
+		result, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID).RecoveryCode("r1"), databases.UpdateUser().SetRecoveryCodes([]string{"r2"}))
+		if err != nil {
+			t.Fatalf("UpdateOne RecoveryCode: %v", err)
+		}
+		if result.MatchedCount != want {
+			t.Errorf("UpdateOne RecoveryCode #%d: matched %d, want %d", i+1, result.MatchedCount, want)
+		}
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	d := found.Details
+	if !d.TOTPEnabled || d.TOTPSecret != "SECRET" || d.TOTPLastStep != 42 || len(d.RecoveryCodes) != 1 || d.RecoveryCodes[0] != "r2" {
+		t.Errorf("FindOne after SetTOTP: got %+v", d)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetTOTP("", false).SetRecoveryCodes(nil)); err != nil {
+		t.Fatalf("UpdateOne SetTOTP: %v", err)
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if found.Details.TOTPEnabled || found.Details.TOTPSecret != "" || len(found.Details.RecoveryCodes) != 0 {
+		t.Errorf("FindOne after removing TOTP: got %+v", found.Details)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetAuthProvider("oidc:test")); err != nil {
+		t.Fatalf("UpdateOne SetAuthProvider: %v", err)
+	}
+	provisioned, err := db.Find(ctx, databases.FilterUsers().AuthProvider("oidc:test"))
+	if err != nil {
+		t.Fatalf("Find AuthProvider: %v", err)
+	}
+	if len(provisioned) != 1 || provisioned[0].ID != user.ID || provisioned[0].Details.AuthProvider != "oidc:test" {
+		t.Errorf("Find AuthProvider: got %+v", provisioned)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), databases.UpdateUser().SetDisabled(true)); err != nil {
+		t.Fatalf("UpdateOne SetDisabled: %v", err)
+	}
+	disabled, err := db.Find(ctx, databases.FilterUsers().Disabled())
+	if err != nil {
+		t.Fatalf("Find Disabled: %v", err)
+	}
+	if len(disabled) != 1 || disabled[0].ID != user.ID || !disabled[0].Details.Disabled {
+		t.Errorf("Find Disabled: got %+v", disabled)
+	}
+
+	failedAt := time.Now().UTC().Truncate(time.Millisecond)
+	update = databases.UpdateUser().SetFailedLogins(3, failedAt).SetLockedUntil(failedAt.Add(time.Hour)).SetKnownDevices([]string{"d1", "d2"})
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		t.Fatalf("UpdateOne SetFailedLogins: %v", err)
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		t.Fatalf("FindOne after SetFailedLogins: %v", err)
+	}
+	d = found.Details
+	if d.FailedLogins != 3 || !d.LastFailedLogin.Equal(failedAt) || !d.LockedUntil.Equal(failedAt.Add(time.Hour)) || len(d.KnownDevices) != 2 || d.KnownDevices[1] != "d2" {
+		t.Errorf("SetFailedLogins: got %+v", d)
+	}
+	update = databases.UpdateUser().SetFailedLogins(0, time.Time{}).SetLockedUntil(time.Time{}).SetKnownDevices(nil)
+	if _, err := db.UpdateOne(ctx, databases.FilterUsers().ID(user.ID), update); err != nil {
+		t.Fatalf("UpdateOne reset failed logins: %v", err)
+	}
+	found, err = db.FindOne(ctx, databases.FilterUsers().ID(user.ID))
+	if err != nil {
+		t.Fatalf("FindOne after reset: %v", err)
+	}
+	if found.Details.FailedLogins != 0 || !found.Details.LockedUntil.IsZero() || len(found.Details.KnownDevices) != 0 {
+		t.Errorf("reset failed logins: got %+v", found.Details)
+	}
+}
+
+func testAPIKeys(t *testing.T, db databases.APIKeyDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	key := models.APIKey{
+		ID: models.NewID(),
+		Details: models.APIKeyDetails{
+			Name:      "Signage",
+			Business:  business,
+			Prefix:    "dbk_" + business.String(),
+			Hash:      "hash",
+			Scopes:    []string{"bookings:read", "cows:read"},
+			CreatedBy: models.NewID(),
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Hour),
+		},
+	}
+	if _, err := db.InsertOne(ctx, key); err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+
+	duplicate := key
+	duplicate.ID = models.NewID()
+	if _, err := db.InsertOne(ctx, duplicate); !errors.Is(err, databases.ErrDuplicate) {
+		t.Errorf("InsertOne: got %v inserting a duplicate prefix, want ErrDuplicate", err)
+	}
+
+	found, err := db.FindOne(ctx, databases.FilterAPIKeys().Prefix(key.Details.Prefix))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	d := found.Details
+	if found.ID != key.ID || d.Hash != "hash" || !d.ExpiresAt.Equal(key.Details.ExpiresAt) || !d.LastUsedAt.IsZero() {
+		t.Errorf("FindOne: got %+v", found)
+	}
+	if len(d.Scopes) != 2 || d.Scopes[0] != "bookings:read" || d.Scopes[1] != "cows:read" {
+		t.Errorf("FindOne: scopes = %v, want [bookings:read cows:read]", d.Scopes)
+	}
+
+	if _, err := db.UpdateOne(ctx, databases.FilterAPIKeys().ID(key.ID), databases.UpdateAPIKey().SetLastUsed(now, "10.0.0.1")); err != nil {
+		t.Fatalf("UpdateOne SetLastUsed: %v", err)
+	}
+	keys, err := db.Find(ctx, databases.FilterAPIKeys().Business(business))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(keys) != 1 || !keys[0].Details.LastUsedAt.Equal(now) || keys[0].Details.LastUsedIP != "10.0.0.1" {
+		t.Errorf("Find: got %+v", keys)
+	}
+
+	result, err := db.DeleteOne(ctx, databases.FilterAPIKeys().ID(key.ID))
+	if err != nil {
+		t.Fatalf("DeleteOne: %v", err)
+	}
+	if result.DeletedCount != 1 {
+		t.Errorf("DeleteOne: deleted %d, want 1", result.DeletedCount)
+	}
+	if _, err := db.FindOne(ctx, databases.FilterAPIKeys().ID(key.ID)); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("FindOne: got %v for a deleted key, want ErrNotFound", err)
+	}
+}
+
+func testInvitations(t *testing.T, db databases.InvitationDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	invitation := models.Invitation{
+		ID: models.NewID(),
+		Details: models.InvitationDetails{
+			Email:     "grace@example.com",
+			FirstName: "Grace",
+			Business:  business,
+			UserType:  models.TypeUser,
+			Status:    models.InvitationPending,
+			TokenHash: "hash-" + business.String(),
+			InvitedBy: models.NewID(),
+			CreatedAt: now,
+			SentAt:    now,
+			ExpiresAt: now.Add(time.Hour),
+		},
+	}
+	if _, err := db.InsertOne(ctx, invitation); err != nil {
+		t.Fatalf("InsertOne: %v", err)
+	}
+
+	pending := func(hash string) *databases.InvitationFilter {
+		return databases.FilterInvitations().Token(hash).Status(models.InvitationPending)
+	}
+	found, err := db.FindOne(ctx, pending(invitation.Details.TokenHash))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	d := found.Details
+	if found.ID != invitation.ID || d.Email != "grace@example.com" || d.UserType != models.TypeUser || !d.ExpiresAt.Equal(invitation.Details.ExpiresAt) || !d.AcceptedAt.IsZero() || !d.User.IsZero() {
+		t.Errorf("FindOne: got %+v", found)
+	}
+
+	later := now.Add(time.Minute)
+	hash := "resent-" + business.String()
+	if _, err := db.UpdateOne(ctx, databases.FilterInvitations().ID(invitation.ID), databases.UpdateInvitation().SetToken(hash, later, later.Add(time.Hour))); err != nil {
+		t.Fatalf("UpdateOne SetToken: %v", err)
+	}
+	if _, err := db.FindOne(ctx, pending(invitation.Details.TokenHash)); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("FindOne: got %v for a replaced token, want ErrNotFound", err)
+	}
+
+	// accepting is filtered on the pending state so a token can only be used once
+	user := models.NewID()
+	accept := func() int64 {
+		result, err := db.UpdateOne(ctx, pending(hash).ID(invitation.ID), databases.UpdateInvitation().SetAccepted(user, later))
+		if err != nil {
+			t.Fatalf("UpdateOne SetAccepted: %v", err)
+		}
+		return result.MatchedCount
+	}
+	if n := accept(); n != 1 {
+		t.Errorf("UpdateOne SetAccepted: matched %d, want 1", n)
+	}
+	if n := accept(); n != 0 {
+		t.Errorf("UpdateOne SetAccepted: matched %d accepting twice, want 0", n)
+	}
+
+	invitations, err := db.Find(ctx, databases.FilterInvitations().Business(business).Status(models.InvitationAccepted))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(invitations) != 1 || invitations[0].Details.User != user || !invitations[0].Details.AcceptedAt.Equal(later) || !invitations[0].Details.SentAt.Equal(later) {
+		t.Errorf("Find: got %+v", invitations)
+	}
+
+	result, err := db.DeleteOne(ctx, databases.FilterInvitations().ID(invitation.ID))
+	if err != nil {
+		t.Fatalf("DeleteOne: %v", err)
+	}
+	if result.DeletedCount != 1 {
+		t.Errorf("DeleteOne: deleted %d, want 1", result.DeletedCount)
+	}
+}
+
+func testAudit(t *testing.T, db databases.AuditDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	actor := models.Actor{Type: models.ActorUser, ID: models.NewID(), Name: "ada@example.com", Business: business}
+	created := models.AuditEntry{
+		ID: models.NewID(),
+		Details: models.AuditDetails{
+			Time:       now.Add(-time.Hour),
+			Actor:      actor,
+			Business:   business,
+			Action:     models.AuditCreate,
+			EntityType: models.AuditBooking,
+			EntityID:   business.String() + ".1",
+			After:      models.Snapshot(`{"block":"A"}`),
+			RequestID:  "request-1",
+			IP:         "10.0.0.1",
+			Method:     "POST",
+			Path:       "/api/v2/cows/" + business.String() + "/bookings",
+		},
+	}
+	deleted := created
+	deleted.ID = models.NewID()
+	deleted.Details.Time = now
+	deleted.Details.Action = models.AuditDelete
+	deleted.Details.Before, deleted.Details.After = created.Details.After, nil
+	deleted.Details.RequestID = "request-2"
+	for _, entry := range []models.AuditEntry{created, deleted} {
+		if _, err := db.InsertOne(ctx, entry); err != nil {
+			t.Fatalf("InsertOne: %v", err)
+		}
+	}
+
+	entries, err := db.Find(ctx, databases.FilterAudit().Business(business).EntityType(models.AuditBooking).EntityID(created.Details.EntityID))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(entries) != 2 || entries[0].ID != created.ID || entries[1].ID != deleted.ID {
+		t.Fatalf("Find: got %+v, want the entries in the order they were recorded", entries)
+	}
+	d := entries[0].Details
+	if !d.Time.Equal(created.Details.Time) || d.Actor != actor || d.Action != models.AuditCreate || d.IP != "10.0.0.1" || d.Path != created.Details.Path {
+		t.Errorf("Find: got %+v", d)
+	}
+	if string(d.After) != `{"block":"A"}` || d.Before != nil {
+		t.Errorf("Find: before = %q, after = %q, want no before", d.Before, d.After)
+	}
+
+	entries, err = db.Find(ctx, databases.FilterAudit().Business(business).Since(now.Add(-time.Minute)).Until(now.Add(time.Minute)))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != deleted.ID || string(entries[0].Details.Before) != `{"block":"A"}` {
+		t.Errorf("Find Since Until: got %+v, want the delete", entries)
+	}
+
+	entries, err = db.Find(ctx, databases.FilterAudit().Actor(actor.ID).Action(models.AuditCreate).RequestID("request-1"))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(entries) != 1 || entries[0].ID != created.ID {
+		t.Errorf("Find Actor Action RequestID: got %+v, want the create", entries)
+	}
+}
+
+func testVersions(t *testing.T, db databases.VersionDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	cowID := models.NewID()
+	version := func(n int, name string) models.Version {
+		return models.Version{
+			ID: models.NewID(),
+			Details: models.VersionDetails{
+				EntityType: models.AuditCow,
+				EntityID:   cowID,
+				Version:    n,
+				Time:       time.Now().UTC().Truncate(time.Millisecond),
+				Actor:      models.Actor{Type: models.ActorUser, ID: models.NewID(), Name: "ada@example.com", Business: business},
+				Business:   business,
+				Action:     models.AuditUpdate,
+				RequestID:  "request-" + name,
+				Snapshot:   models.Snapshot(`{"cow":{"name":"` + name + `"}}`),
+			},
+		}
+	}
+	first, second := version(1, "CA-01"), version(2, "CA-02")
+	first.Details.Action = models.AuditCreate
+	second.Details.Restored = 1
+	for _, v := range []models.Version{first, second} {
+		if _, err := db.InsertOne(ctx, v); err != nil {
+			t.Fatalf("InsertOne: %v", err)
+		}
+	}
+	if _, err := db.InsertOne(ctx, version(2, "CA-03")); !errors.Is(err, databases.ErrDuplicate) {
+		t.Errorf("InsertOne with a taken version: got %v, want ErrDuplicate", err)
+	}
+
+	versions, err := db.Find(ctx, databases.FilterVersions().Entity(models.AuditCow, cowID))
+	if err != nil {
+		t.Fatalf("Find: %v", err)
+	}
+	if len(versions) != 2 || versions[0].ID != first.ID || versions[1].ID != second.ID {
+		t.Fatalf("Find: got %+v, want both versions oldest first", versions)
+	}
+	latest, err := db.Find(ctx, databases.FilterVersions().Entity(models.AuditCow, cowID).Newest().Limit(1))
+	if err != nil {
+		t.Fatalf("Find Newest: %v", err)
+	}
+	if len(latest) != 1 || latest[0].Details.Version != 2 || latest[0].Details.Restored != 1 {
+		t.Errorf("Find Newest: got %+v, want version 2", latest)
+	}
+
+	found, err := db.FindOne(ctx, databases.FilterVersions().Entity(models.AuditCow, cowID).Version(1))
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	d := found.Details
+	if found.ID != first.ID || d.Action != models.AuditCreate || d.Actor != first.Details.Actor || !d.Time.Equal(first.Details.Time) || string(d.Snapshot) != string(first.Details.Snapshot) {
+		t.Errorf("FindOne: got %+v, want %+v", d, first.Details)
+	}
+	if _, err := db.FindOne(ctx, databases.FilterVersions().Entity(models.AuditDevice, cowID).Version(1)); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("FindOne of another entity type: got %v, want ErrNotFound", err)
+	}
+}
+
+func testApprovals(t *testing.T, cows databases.CowDatabase, policies databases.ApprovalPolicyDatabase, requests databases.BookingRequestDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// the SQL backends remove a policy with its cow
+	cow := models.Cow{ID: models.NewID(), Details: models.CowDetails{Name: "CA-APPROVAL", Business: business, Bookings: []models.BookDetails{}, Devices: []models.ID{}}}
+	if _, err := cows.InsertOne(ctx, cow); err != nil {
+		t.Fatalf("InsertOne cow: %v", err)
+	}
+	defer func() { _, _ = cows.DeleteOne(ctx, databases.FilterCows().ID(cow.ID)) }()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	approver1, approver2 := models.NewID(), models.NewID()
+	policy := models.ApprovalPolicy{ID: cow.ID, Details: models.ApprovalPolicyDetails{Business: business, Approvers: []models.ID{approver1, approver2}, UpdatedBy: approver1, UpdatedAt: now}}
+	if _, err := policies.InsertOne(ctx, policy); err != nil {
+		t.Fatalf("InsertOne policy: %v", err)
+	}
+	if _, err := policies.InsertOne(ctx, policy); !errors.Is(err, databases.ErrDuplicate) {
+		t.Errorf("InsertOne a second policy for the cow: got %v, want ErrDuplicate", err)
+	}
+
+	found, err := policies.FindOne(ctx, databases.FilterApprovalPolicies().Cow(cow.ID))
+	if err != nil {
+		t.Fatalf("FindOne policy: %v", err)
+	}
+	if found.Details.Business != business || len(found.Details.Approvers) != 2 || found.Details.Approvers[1] != approver2 || !found.Details.UpdatedAt.Equal(now) {
+		t.Errorf("FindOne policy: got %+v", found)
+	}
+
+	later := now.Add(time.Minute)
+	if _, err := policies.UpdateOne(ctx, databases.FilterApprovalPolicies().Cow(cow.ID), databases.UpdateApprovalPolicy().SetApprovers([]models.ID{approver2}, approver2, later)); err != nil {
+		t.Fatalf("UpdateOne policy: %v", err)
+	}
+	list, err := policies.Find(ctx, databases.FilterApprovalPolicies().Business(business))
+	if err != nil {
+		t.Fatalf("Find policies: %v", err)
+	}
+	if len(list) != 1 || len(list[0].Details.Approvers) != 1 || list[0].Details.Approvers[0] != approver2 || list[0].Details.UpdatedBy != approver2 {
+		t.Errorf("Find policies: got %+v", list)
+	}
+
+	device := models.NewID()
+	request := models.BookingRequest{
+		ID: models.NewID(),
+		Details: models.BookingRequestDetails{
+			Cow:      cow.ID,
+			Business: business,
+			Booking: models.BookDetails{
+				ID:        cow.ID.String() + ".requested",
+				Author:    "Grace",
+				Devices:   []models.ID{device},
+				Block:     "2",
+				StartDate: primitive.NewDateTimeFromTime(now.Add(24 * time.Hour)),
+				EndDate:   primitive.NewDateTimeFromTime(now.Add(25 * time.Hour)),
+			},
+			Requester: models.NewID(),
+			Status:    models.BookingPending,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Hour),
+		},
+	}
+	expired := request
+	expired.ID = models.NewID()
+	expired.Details.ExpiresAt = now.Add(-time.Hour)
+	for _, r := range []models.BookingRequest{request, expired} {
+		if _, err := requests.InsertOne(ctx, r); err != nil {
+			t.Fatalf("InsertOne request: %v", err)
+		}
+	}
+
+	got, err := requests.FindOne(ctx, databases.FilterBookingRequests().ID(request.ID))
+	if err != nil {
+		t.Fatalf("FindOne request: %v", err)
+	}
+	b := got.Details.Booking
+	if got.Details.Cow != cow.ID || b.ID != request.Details.Booking.ID || len(b.Devices) != 1 || b.Devices[0] != device || b.StartDate != request.Details.Booking.StartDate || !got.Details.ExpiresAt.Equal(request.Details.ExpiresAt) {
+		t.Errorf("FindOne request: got %+v", got.Details)
+	}
+
+	due, err := requests.Find(ctx, databases.FilterBookingRequests().Business(business).Status(models.BookingPending).ExpiresBefore(now))
+	if err != nil {
+		t.Fatalf("Find due requests: %v", err)
+	}
+	if len(due) != 1 || due[0].ID != expired.ID {
+		t.Errorf("Find due requests: got %+v, want only the expired request", due)
+	}
+
+	// deciding is filtered on the pending state so a request is only decided once
+	decide := func() int64 {
+		result, err := requests.UpdateOne(ctx, databases.FilterBookingRequests().ID(request.ID).Status(models.BookingPending), databases.UpdateBookingRequest().SetDecision(models.BookingRejected, approver2, later, "taken"))
+		if err != nil {
+			t.Fatalf("UpdateOne request: %v", err)
+		}
+		return result.MatchedCount
+	}
+	if n := decide(); n != 1 {
+		t.Errorf("UpdateOne request: matched %d, want 1", n)
+	}
+	if n := decide(); n != 0 {
+		t.Errorf("UpdateOne request: matched %d deciding twice, want 0", n)
+	}
+	rejected, err := requests.Find(ctx, databases.FilterBookingRequests().Cow(cow.ID).Status(models.BookingRejected))
+	if err != nil {
+		t.Fatalf("Find rejected requests: %v", err)
+	}
+	if len(rejected) != 1 || rejected[0].Details.DecidedBy != approver2 || rejected[0].Details.Reason != "taken" || !rejected[0].Details.DecidedAt.Equal(later) {
+		t.Errorf("Find rejected requests: got %+v", rejected)
+	}
+
+	result, err := policies.DeleteOne(ctx, databases.FilterApprovalPolicies().Cow(cow.ID))
+	if err != nil {
+		t.Fatalf("DeleteOne policy: %v", err)
+	}
+	if result.DeletedCount != 1 {
+		t.Errorf("DeleteOne policy: deleted %d, want 1", result.DeletedCount)
+	}
+}
+
+func testWaitlist(t *testing.T, cows databases.CowDatabase, waitlist databases.WaitlistDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	device := models.NewID()
+	booking := models.BookDetails{
+		Author:    "Grace",
+		Devices:   []models.ID{device},
+		Block:     "3",
+		StartDate: primitive.NewDateTimeFromTime(now.Add(24 * time.Hour)),
+		EndDate:   primitive.NewDateTimeFromTime(now.Add(25 * time.Hour)),
+	}
+
+	// cancelling a booking removes only that booking
+	cow := models.Cow{ID: models.NewID(), Details: models.CowDetails{Name: "CA-WAITLIST", Business: business, Bookings: []models.BookDetails{}, Devices: []models.ID{}}}
+	if _, err := cows.InsertOne(ctx, cow); err != nil {
+		t.Fatalf("InsertOne cow: %v", err)
+	}
+	defer func() { _, _ = cows.DeleteOne(ctx, databases.FilterCows().ID(cow.ID)) }()
+	for _, id := range []string{".kept", ".cancelled"} {
+		b := booking
+		b.ID = cow.ID.String() + id
+		if _, err := cows.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(b)); err != nil {
+			t.Fatalf("UpdateOne push booking: %v", err)
+		}
+	}
+	if _, err := cows.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PullBooking(cow.ID.String()+".cancelled")); err != nil {
+		t.Fatalf("UpdateOne pull booking: %v", err)
+	}
+	found, err := cows.FindOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("FindOne cow: %v", err)
+	}
+	if len(found.Details.Bookings) != 1 || found.Details.Bookings[0].ID != cow.ID.String()+".kept" || len(found.Details.Bookings[0].Devices) != 1 {
+		t.Errorf("PullBooking: got %+v, want only the kept booking", found.Details.Bookings)
+	}
+
+	date := booking.StartDate.Time().UTC().Format("2006-01-02")
+	entry := func(status string, user models.ID) models.WaitlistEntry {
+		return models.WaitlistEntry{
+			ID: models.NewID(),
+			Details: models.WaitlistEntryDetails{
+				Cow:       cow.ID,
+				Business:  business,
+				Date:      date,
+				Block:     booking.Block,
+				User:      user,
+				Booking:   booking,
+				Status:    status,
+				CreatedAt: now,
+				UpdatedAt: now,
+			},
+		}
+	}
+	offered := entry(models.WaitlistOffered, models.NewID())
+	offered.Details.OfferedAt, offered.Details.OfferExpiresAt = now.Add(-2*time.Hour), now.Add(-time.Hour)
+	first, second := entry(models.WaitlistWaiting, models.NewID()), entry(models.WaitlistWaiting, models.NewID())
+	stale := entry(models.WaitlistWaiting, first.Details.User)
+	stale.Details.Date = now.Add(-48 * time.Hour).Format("2006-01-02")
+	for _, e := range []models.WaitlistEntry{offered, first, second, stale} {
+		if _, err := waitlist.InsertOne(ctx, e); err != nil {
+			t.Fatalf("InsertOne entry: %v", err)
+		}
+	}
+
+	got, err := waitlist.FindOne(ctx, databases.FilterWaitlist().ID(first.ID))
+	if err != nil {
+		t.Fatalf("FindOne entry: %v", err)
+	}
+	b := got.Details.Booking
+	if got.Details.Date != date || got.Details.User != first.Details.User || b.Block != booking.Block || len(b.Devices) != 1 || b.Devices[0] != device || b.StartDate != booking.StartDate {
+		t.Errorf("FindOne entry: got %+v", got.Details)
+	}
+
+	// the queue of a block is ordered by ID, which is the order the entries joined in
+	queue, err := waitlist.Find(ctx, databases.FilterWaitlist().Slot(cow.ID, date, booking.Block).Status(models.WaitlistWaiting))
+	if err != nil {
+		t.Fatalf("Find queue: %v", err)
+	}
+	if len(queue) != 2 || queue[0].ID != first.ID || queue[1].ID != second.ID {
+		t.Errorf("Find queue: got %+v, want the first then the second entry", queue)
+	}
+	missed, err := waitlist.Find(ctx, databases.FilterWaitlist().Business(business).Status(models.WaitlistOffered).OfferExpiresBefore(now))
+	if err != nil {
+		t.Fatalf("Find missed offers: %v", err)
+	}
+	if len(missed) != 1 || missed[0].ID != offered.ID {
+		t.Errorf("Find missed offers: got %+v, want the offered entry", missed)
+	}
+	past, err := waitlist.Find(ctx, databases.FilterWaitlist().User(first.Details.User).Status(models.WaitlistWaiting, models.WaitlistOffered).DateBefore(now.Format("2006-01-02")))
+	if err != nil {
+		t.Fatalf("Find past entries: %v", err)
+	}
+	if len(past) != 1 || past[0].ID != stale.ID {
+		t.Errorf("Find past entries: got %+v, want the stale entry", past)
+	}
+
+	// offering is filtered on the waiting state so an entry is only offered the block once
+	offer := func() int64 {
+		result, err := waitlist.UpdateOne(ctx, databases.FilterWaitlist().ID(first.ID).Status(models.WaitlistWaiting), databases.UpdateWaitlist().SetOffer(now, now.Add(time.Hour)))
+		if err != nil {
+			t.Fatalf("UpdateOne entry: %v", err)
+		}
+		return result.MatchedCount
+	}
+	if n := offer(); n != 1 {
+		t.Errorf("UpdateOne entry: matched %d, want 1", n)
+	}
+	if n := offer(); n != 0 {
+		t.Errorf("UpdateOne entry: matched %d offering twice, want 0", n)
+	}
+	got, err = waitlist.FindOne(ctx, databases.FilterWaitlist().ID(first.ID))
+	if err != nil {
+		t.Fatalf("FindOne offered entry: %v", err)
+	}
+	if got.Details.Status != models.WaitlistOffered || !got.Details.OfferedAt.Equal(now) || !got.Details.OfferExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("FindOne offered entry: got %+v", got.Details)
+	}
+}
+
+func testBookingPolicies(t *testing.T, cows databases.CowDatabase, policies databases.BookingPolicyDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// policies count the bookings of a user, so bookings keep who made them
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	user := models.NewID()
+	cow := models.Cow{ID: models.NewID(), Details: models.CowDetails{Name: "CA-POLICY", Business: business, Bookings: []models.BookDetails{}, Devices: []models.ID{}}}
+	if _, err := cows.InsertOne(ctx, cow); err != nil {
+		t.Fatalf("InsertOne cow: %v", err)
+	}
+	defer func() { _, _ = cows.DeleteOne(ctx, databases.FilterCows().ID(cow.ID)) }()
+	booking := models.BookDetails{
+		ID:        cow.ID.String() + ".mine",
+		Author:    "Grace",
+		Devices:   []models.ID{},
+		Block:     "4",
+		StartDate: primitive.NewDateTimeFromTime(now.Add(24 * time.Hour)),
+		EndDate:   primitive.NewDateTimeFromTime(now.Add(25 * time.Hour)),
+		User:      user,
+	}
+	if _, err := cows.UpdateOne(ctx, databases.FilterCows().ID(cow.ID), databases.UpdateCow().PushBooking(booking)); err != nil {
+		t.Fatalf("UpdateOne push booking: %v", err)
+	}
+	found, err := cows.FindOne(ctx, databases.FilterCows().ID(cow.ID))
+	if err != nil {
+		t.Fatalf("FindOne cow: %v", err)
+	}
+	if len(found.Details.Bookings) != 1 || found.Details.Bookings[0].User != user {
+		t.Errorf("FindOne cow: got bookings %+v, want the booking of the user", found.Details.Bookings)
+	}
+
+	everyone := models.BookingPolicy{ID: models.NewID(), Details: models.BookingPolicyDetails{Business: business, MaxLeadDays: 28, MinNoticeMinutes: 60, UpdatedBy: user, UpdatedAt: now}}
+	users := models.BookingPolicy{ID: models.NewID(), Details: models.BookingPolicyDetails{Business: business, UserType: models.TypeUser, MaxConcurrent: 2, MaxPerWeek: 5, UpdatedBy: user, UpdatedAt: now}}
+	for _, policy := range []models.BookingPolicy{everyone, users} {
+		if _, err := policies.InsertOne(ctx, policy); err != nil {
+			t.Fatalf("InsertOne policy: %v", err)
+		}
+	}
+	duplicate := users
+	duplicate.ID = models.NewID()
+	if _, err := policies.InsertOne(ctx, duplicate); !errors.Is(err, databases.ErrDuplicate) {
+		t.Errorf("InsertOne a second policy for the role: got %v, want ErrDuplicate", err)
+	}
+
+	got, err := policies.FindOne(ctx, databases.FilterBookingPolicies().Business(business).UserType(models.TypeUser))
+	if err != nil {
+		t.Fatalf("FindOne policy: %v", err)
+	}
+	if got.ID != users.ID || got.Details.MaxConcurrent != 2 || got.Details.MaxPerWeek != 5 || got.Details.MaxLeadDays != 0 || !got.Details.UpdatedAt.Equal(now) {
+		t.Errorf("FindOne policy: got %+v", got)
+	}
+
+	later := now.Add(time.Minute)
+	limits := models.BookingPolicyDetails{MaxDurationMinutes: 90, UpdatedBy: user, UpdatedAt: later}
+	if _, err := policies.UpdateOne(ctx, databases.FilterBookingPolicies().ID(everyone.ID), databases.UpdateBookingPolicy().SetLimits(limits)); err != nil {
+		t.Fatalf("UpdateOne policy: %v", err)
+	}
+	list, err := policies.Find(ctx, databases.FilterBookingPolicies().Business(business).UserType(0))
+	if err != nil {
+		t.Fatalf("Find policies: %v", err)
+	}
+	if len(list) != 1 || list[0].Details.MaxDurationMinutes != 90 || list[0].Details.MaxLeadDays != 0 || !list[0].Details.UpdatedAt.Equal(later) {
+		t.Errorf("Find policies: got %+v", list)
+	}
+
+	for _, policy := range []models.BookingPolicy{everyone, users} {
+		result, err := policies.DeleteOne(ctx, databases.FilterBookingPolicies().ID(policy.ID))
+		if err != nil {
+			t.Fatalf("DeleteOne policy: %v", err)
+		}
+		if result.DeletedCount != 1 {
+			t.Errorf("DeleteOne policy: deleted %d, want 1", result.DeletedCount)
+		}
+	}
+}
+
+func testCalendar(t *testing.T, calendar databases.CalendarDatabase, business models.ID) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	day := time.Date(2030, time.March, 4, 0, 0, 0, 0, time.UTC)
+	other := models.NewID()
+	cow := models.NewID()
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	entry := func(b models.ID, kind string, start, end time.Time) models.CalendarEntry {
+		return models.CalendarEntry{ID: models.NewID(), Details: models.CalendarEntryDetails{Business: b, Kind: kind, Reason: "Testing", Start: start, End: end, CreatedAt: now}}
+	}
+	closure := entry(business, models.CalendarClosure, day, day.AddDate(0, 0, 2))
+	closure.Details.UID = "pd-day@district.example"
+	blackout := entry(business, models.CalendarBlackout, day.AddDate(0, 0, 7).Add(9*time.Hour), day.AddDate(0, 0, 7).Add(12*time.Hour))
+	blackout.Details.Cow = cow
+	elsewhere := entry(other, models.CalendarClosure, day, day.AddDate(0, 0, 1))
+	for _, e := range []models.CalendarEntry{closure, blackout, elsewhere} {
+		if _, err := calendar.InsertOne(ctx, e); err != nil {
+			t.Fatalf("InsertOne: %v", err)
+		}
+	}
+	defer func() {
+		for _, e := range []models.CalendarEntry{closure, blackout, elsewhere} {
+			_, _ = calendar.DeleteOne(ctx, databases.FilterCalendar().ID(e.ID))
+		}
+	}()
+
+	ids := func(entries []models.CalendarEntry) []models.ID {
+		out := []models.ID{}
+		for _, e := range entries {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	// entries are found in ID order and end exclusively, so a range starting as one ends
+	// doesn't overlap it
+	for _, tc := range []struct {
+		name     string
+		filter   *databases.CalendarFilter
+		expected []models.ID
+	}{
+		{"Between", databases.FilterCalendar().Business(business).Between(day.Add(time.Hour), day.Add(2*time.Hour)), []models.ID{closure.ID}},
+		{"Between end", databases.FilterCalendar().Business(business).Between(day.AddDate(0, 0, 2), day.AddDate(0, 0, 3)), []models.ID{}},
+		{"Between start", databases.FilterCalendar().Business(business).Between(day.Add(-time.Hour), day), []models.ID{}},
+		{"Between both", databases.FilterCalendar().Business(business).Between(day, day.AddDate(0, 0, 8)), []models.ID{closure.ID, blackout.ID}},
+		{"Businesses", databases.FilterCalendar().Businesses(business, other).Between(day, day.AddDate(0, 0, 1)), []models.ID{closure.ID, elsewhere.ID}},
+		{"Kind", databases.FilterCalendar().Business(business).Kind(models.CalendarBlackout), []models.ID{blackout.ID}},
+		{"Cow", databases.FilterCalendar().Cow(cow), []models.ID{blackout.ID}},
+	} {
+		found, err := calendar.Find(ctx, tc.filter)
+		if err != nil {
+			t.Fatalf("Find %s: %v", tc.name, err)
+		}
+		if got := ids(found); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Find %s: got %v, want %v", tc.name, got, tc.expected)
+		}
+	}
+
+	// importing the same event again moves the entry
+	moved := closure.Details
+	moved.Reason, moved.Start, moved.End = "PD day", day.AddDate(0, 0, 1), day.AddDate(0, 0, 2)
+	filter := databases.FilterCalendar().Business(business).UID(closure.Details.UID)
+	if _, err := calendar.UpdateOne(ctx, filter, databases.UpdateCalendar().SetEvent(moved)); err != nil {
+		t.Fatalf("UpdateOne: %v", err)
+	}
+	got, err := calendar.FindOne(ctx, filter)
+	if err != nil {
+		t.Fatalf("FindOne: %v", err)
+	}
+	if got.ID != closure.ID || got.Details.Reason != "PD day" || !got.Details.Start.Equal(moved.Start) || !got.Details.End.Equal(moved.End) || !got.Details.CreatedAt.Equal(now) {
+		t.Errorf("FindOne: got %+v", got)
+	}
+
+	result, err := calendar.DeleteOne(ctx, databases.FilterCalendar().ID(blackout.ID))
+	if err != nil {
+		t.Fatalf("DeleteOne: %v", err)
+	}
+	if result.DeletedCount != 1 {
+		t.Errorf("DeleteOne: deleted %d, want 1", result.DeletedCount)
+	}
+	if _, err := calendar.FindOne(ctx, databases.FilterCalendar().ID(blackout.ID)); !errors.Is(err, databases.ErrNotFound) {
+		t.Errorf("FindOne deleted: got %v, want ErrNotFound", err)
+	}
+}
@@ -12,10 +12,11 @@ const cowDBO = "cows"
 
 // CowDatabase contains the methods to use with the cow database
 type CowDatabase interface {
-	FindOne(ctx context.Context, filter interface{}) (*models.Cow, error)
-	Find(ctx context.Context, filter interface{}) ([]models.Cow, error)
-	InsertOne(ctx context.Context, document interface{}) (*mongoInsertOneResult, error)
-	UpdateOne(ctx context.Context, filter, document interface{}) (*mongoUpdateResult, error)
+	FindOne(ctx context.Context, filter *CowFilter) (*models.Cow, error)
+	Find(ctx context.Context, filter *CowFilter) ([]models.Cow, error)
+	InsertOne(ctx context.Context, cow models.Cow) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *CowFilter, update *CowUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *CowFilter) (*DeleteResult, error)
 }
 
 type cowDatabase struct {
@@ -29,18 +30,18 @@ func NewCowDatabase(db DatabaseHelper) CowDatabase {
 	}
 }
 
-func (c *cowDatabase) FindOne(ctx context.Context, filter interface{}) (*models.Cow, error) {
+func (c *cowDatabase) FindOne(ctx context.Context, filter *CowFilter) (*models.Cow, error) {
 	cow := &models.Cow{}
-	err := c.db.Collection(cowDBO).FindOne(ctx, filter).Decode(&cow)
+	err := c.db.Collection(cowDBO).FindOne(ctx, filter.query().Bson()).Decode(&cow)
 	if err != nil {
 		return nil, err
 	}
 	return cow, nil
 }
 
-func (c *cowDatabase) Find(ctx context.Context, filter interface{}) ([]models.Cow, error) {
+func (c *cowDatabase) Find(ctx context.Context, filter *CowFilter) ([]models.Cow, error) {
 	var cows []models.Cow
-	err := c.db.Collection(cowDBO).Find(ctx, filter).Decode(&cows)
+	err := c.db.Collection(cowDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&cows)
 	if err != nil {
 		return nil, err
 	}
@@ -48,18 +49,29 @@ func (c *cowDatabase) Find(ctx context.Context, filter interface{}) ([]models.Co
 }
 
 // Returns the result (document id) and error
-func (c *cowDatabase) InsertOne(ctx context.Context, document interface{}) (*mongoInsertOneResult, error) {
-	result, err := c.db.Collection(cowDBO).InsertOne(ctx, document)
+func (c *cowDatabase) InsertOne(ctx context.Context, cow models.Cow) (*InsertOneResult, error) {
+	result, err := c.db.Collection(cowDBO).InsertOne(ctx, cow)
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (c *cowDatabase) UpdateOne(ctx context.Context, filter, update interface{}) (*mongoUpdateResult, error) {
-	result, err := c.db.Collection(cowDBO).UpdateOne(ctx, filter, update)
+func (c *cowDatabase) UpdateOne(ctx context.Context, filter *CowFilter, update *CowUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := c.db.Collection(cowDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
+
+func (c *cowDatabase) DeleteOne(ctx context.Context, filter *CowFilter) (*DeleteResult, error) {
+	deleted, err := c.db.Collection(cowDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
@@ -19,9 +19,12 @@ type DatabaseHelper interface {
 // CollectionHelper contains all the methods defined for collection in this project
 type CollectionHelper interface {
 	FindOne(context.Context, interface{}) SingleResultHelper
-	Find(context.Context, interface{}) CursorHelper
-	InsertOne(context.Context, interface{}) (mongoInsertOneResult, error)
-	UpdateOne(context.Context, interface{}, interface{}) (mongoUpdateResult, error)
+	Find(context.Context, interface{}, ...*options.FindOptions) CursorHelper
+	InsertOne(context.Context, interface{}) (InsertOneResult, error)
+	UpdateOne(context.Context, interface{}, interface{}) (UpdateResult, error)
+	DeleteOne(context.Context, interface{}) (int64, error)
+	CreateIndex(context.Context, mongo.IndexModel) (string, error)
+	DropIndex(context.Context, string) error
 }
 
 // SingleResultHelper contains a single method to decode the result
@@ -38,6 +41,7 @@ type CursorHelper interface {
 type ClientHelper interface {
 	Database(string) DatabaseHelper
 	Connect() error
+	Disconnect() error
 	StartSession() (mongo.Session, error)
 }
 
@@ -58,15 +62,24 @@ type mongoSingleResult struct {
 }
 
 type mongoCursor struct {
-	cr *mongo.Cursor
+	cr  *mongo.Cursor
+	err error
 }
 
-type mongoInsertOneResult struct {
-	ir *mongo.InsertOneResult
+// InsertOneResult is the backend independent result of inserting a document
+type InsertOneResult struct {
+	InsertedID interface{}
 }
 
-type mongoUpdateResult struct {
-	Ur *mongo.UpdateResult // capital to export field to ignore error in ../api/handlers/cow.go Ln 133 Col 26
+// UpdateResult is the backend independent result of updating a document
+type UpdateResult struct {
+	MatchedCount  int64
+	ModifiedCount int64
+}
+
+// DeleteResult is the backend independent result of deleting a document
+type DeleteResult struct {
+	DeletedCount int64
 }
 
 type mongoSession struct {
@@ -99,6 +112,10 @@ func (mc *mongoClient) Connect() error {
 	return mc.cl.Connect(context.TODO()) // use context.TODO() instead of nil cause good practice ¯\_(ツ)_/¯
 }
 
+func (mc *mongoClient) Disconnect() error {
+	return mc.cl.Disconnect(context.TODO())
+}
+
 func (md *mongoDatabase) Collection(colName string) CollectionHelper {
 	collection := md.db.Collection(colName)
 	return &mongoCollection{coll: collection}
@@ -114,32 +131,55 @@ func (mc *mongoCollection) FindOne(ctx context.Context, filter interface{}) Sing
 	return &mongoSingleResult{sr: singleResult}
 }
 
-func (mc *mongoCollection) Find(ctx context.Context, filter interface{}) CursorHelper {
-	cursor, _ := mc.coll.Find(ctx, filter)
-	return &mongoCursor{cr: cursor}
+func (mc *mongoCollection) Find(ctx context.Context, filter interface{}, opts ...*options.FindOptions) CursorHelper {
+	cursor, err := mc.coll.Find(ctx, filter, opts...)
+	return &mongoCursor{cr: cursor, err: err}
 }
 
-func (mc *mongoCollection) InsertOne(ctx context.Context, document interface{}) (mongoInsertOneResult, error) {
+func (mc *mongoCollection) InsertOne(ctx context.Context, document interface{}) (InsertOneResult, error) {
 	insertOneResult, err := mc.coll.InsertOne(ctx, document)
 	if err != nil {
-		return mongoInsertOneResult{}, err
+		return InsertOneResult{}, mongoError(err)
 	}
-	return mongoInsertOneResult{ir: insertOneResult}, nil
+	return InsertOneResult{InsertedID: insertOneResult.InsertedID}, nil
 }
 
-func (mc *mongoCollection) UpdateOne(ctx context.Context, filter, update interface{}) (mongoUpdateResult, error) {
+func (mc *mongoCollection) UpdateOne(ctx context.Context, filter, update interface{}) (UpdateResult, error) {
 	updateOneResult, err := mc.coll.UpdateOne(ctx, filter, update)
 	if err != nil {
-		return mongoUpdateResult{}, err
+		return UpdateResult{}, mongoError(err)
 	}
-	return mongoUpdateResult{Ur: updateOneResult}, nil
+	return UpdateResult{MatchedCount: updateOneResult.MatchedCount, ModifiedCount: updateOneResult.ModifiedCount}, nil
+}
+
+// DeleteOne removes the first document matching filter and returns the number of deleted documents
+func (mc *mongoCollection) DeleteOne(ctx context.Context, filter interface{}) (int64, error) {
+	deleteResult, err := mc.coll.DeleteOne(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return deleteResult.DeletedCount, nil
+}
+
+// CreateIndex creates the index if it does not already exist and returns its name
+func (mc *mongoCollection) CreateIndex(ctx context.Context, model mongo.IndexModel) (string, error) {
+	return mc.coll.Indexes().CreateOne(ctx, model)
+}
+
+// DropIndex drops the index with the given name
+func (mc *mongoCollection) DropIndex(ctx context.Context, name string) error {
+	_, err := mc.coll.Indexes().DropOne(ctx, name)
+	return err
 }
 
 func (sr *mongoSingleResult) Decode(v interface{}) error {
-	return sr.sr.Decode(v)
+	return mongoError(sr.sr.Decode(v))
 }
 
 func (cr *mongoCursor) Decode(v interface{}) error {
+	if cr.err != nil {
+		return cr.err
+	}
 	return cr.All(context.Background(), v)
 }
 
@@ -12,10 +12,11 @@ const deviceDBO = "devices"
 
 // DeviceDatabase contains the methods to use with the cow database
 type DeviceDatabase interface {
-	FindOne(ctx context.Context, filter interface{}) (*models.Device, error)
-	Find(ctx context.Context, filter interface{}) ([]models.Device, error)
-	InsertOne(ctx context.Context, document interface{}) (*mongoInsertOneResult, error)
-	UpdateOne(ctx context.Context, filter, document interface{}) (*mongoUpdateResult, error)
+	FindOne(ctx context.Context, filter *DeviceFilter) (*models.Device, error)
+	Find(ctx context.Context, filter *DeviceFilter) ([]models.Device, error)
+	InsertOne(ctx context.Context, device models.Device) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *DeviceFilter, update *DeviceUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *DeviceFilter) (*DeleteResult, error)
 }
 
 type deviceDatabase struct {
@@ -29,36 +30,47 @@ func NewDeviceDatabase(db DatabaseHelper) DeviceDatabase {
 	}
 }
 
-func (d *deviceDatabase) FindOne(ctx context.Context, filter interface{}) (*models.Device, error) {
+func (d *deviceDatabase) FindOne(ctx context.Context, filter *DeviceFilter) (*models.Device, error) {
 	device := &models.Device{}
-	err := d.db.Collection(deviceDBO).FindOne(ctx, filter).Decode(&device)
+	err := d.db.Collection(deviceDBO).FindOne(ctx, filter.query().Bson()).Decode(&device)
 	if err != nil {
 		return nil, err
 	}
 	return device, nil
 }
 
-func (d *deviceDatabase) Find(ctx context.Context, filter interface{}) ([]models.Device, error) {
+func (d *deviceDatabase) Find(ctx context.Context, filter *DeviceFilter) ([]models.Device, error) {
 	var devices []models.Device
-	err := d.db.Collection(deviceDBO).FindOne(ctx, filter).Decode(&devices)
+	err := d.db.Collection(deviceDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&devices)
 	if err != nil {
 		return nil, err
 	}
 	return devices, nil
 }
 
-func (d *deviceDatabase) InsertOne(ctx context.Context, document interface{}) (*mongoInsertOneResult, error) {
-	result, err := d.db.Collection(deviceDBO).InsertOne(ctx, document)
+func (d *deviceDatabase) InsertOne(ctx context.Context, device models.Device) (*InsertOneResult, error) {
+	result, err := d.db.Collection(deviceDBO).InsertOne(ctx, device)
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (d *deviceDatabase) UpdateOne(ctx context.Context, filter, update interface{}) (*mongoUpdateResult, error) {
-	result, err := d.db.Collection(deviceDBO).UpdateOne(ctx, filter, update)
+func (d *deviceDatabase) UpdateOne(ctx context.Context, filter *DeviceFilter, update *DeviceUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := d.db.Collection(deviceDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
+
+func (d *deviceDatabase) DeleteOne(ctx context.Context, filter *DeviceFilter) (*DeleteResult, error) {
+	deleted, err := d.db.Collection(deviceDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
@@ -0,0 +1,47 @@
+package databases
+
+import (
+	"errors"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrNotFound is returned by FindOne when no document matches the filter
+	ErrNotFound = errors.New("not found")
+	// ErrDuplicate is returned when an insert or update breaks a unique index
+	ErrDuplicate = errors.New("duplicate key")
+)
+
+// storeError keeps the error returned by the driver while matching one of the backend
+// independent errors above with errors.Is
+type storeError struct {
+	kind error
+	err  error
+}
+
+func (e *storeError) Error() string {
+	return e.kind.Error() + ": " + e.err.Error()
+}
+
+func (e *storeError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *storeError) Unwrap() error {
+	return e.err
+}
+
+// mongoError is a helper function translating mongo driver errors into the errors above
+func mongoError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, mongo.ErrNoDocuments):
+		return &storeError{kind: ErrNotFound, err: err}
+	case mongo.IsDuplicateKeyError(err):
+		return &storeError{kind: ErrDuplicate, err: err}
+	default:
+		return err
+	}
+}
@@ -0,0 +1,76 @@
+package databases
+
+// go generate: mockery --name InvitationDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const invitationDBO = "invitations"
+
+// InvitationDatabase contains the methods to use with the invitation database
+type InvitationDatabase interface {
+	FindOne(ctx context.Context, filter *InvitationFilter) (*models.Invitation, error)
+	Find(ctx context.Context, filter *InvitationFilter) ([]models.Invitation, error)
+	InsertOne(ctx context.Context, invitation models.Invitation) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *InvitationFilter, update *InvitationUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *InvitationFilter) (*DeleteResult, error)
+}
+
+type invitationDatabase struct {
+	db DatabaseHelper
+}
+
+// NewInvitationDatabase initializes a new instance of an invitation database with the provided db connection
+func NewInvitationDatabase(db DatabaseHelper) InvitationDatabase {
+	return &invitationDatabase{
+		db: db,
+	}
+}
+
+func (i *invitationDatabase) FindOne(ctx context.Context, filter *InvitationFilter) (*models.Invitation, error) {
+	invitation := &models.Invitation{}
+	err := i.db.Collection(invitationDBO).FindOne(ctx, filter.query().Bson()).Decode(&invitation)
+	if err != nil {
+		return nil, err
+	}
+	return invitation, nil
+}
+
+func (i *invitationDatabase) Find(ctx context.Context, filter *InvitationFilter) ([]models.Invitation, error) {
+	var invitations []models.Invitation
+	err := i.db.Collection(invitationDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&invitations)
+	if err != nil {
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (i *invitationDatabase) InsertOne(ctx context.Context, invitation models.Invitation) (*InsertOneResult, error) {
+	result, err := i.db.Collection(invitationDBO).InsertOne(ctx, invitation)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (i *invitationDatabase) UpdateOne(ctx context.Context, filter *InvitationFilter, update *InvitationUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := i.db.Collection(invitationDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (i *invitationDatabase) DeleteOne(ctx context.Context, filter *InvitationFilter) (*DeleteResult, error) {
+	deleted, err := i.db.Collection(invitationDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
@@ -0,0 +1,205 @@
+package databases
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/migrations"
+)
+
+const migrationDBO = "migrations"
+
+type mongoMigrationStore struct {
+	db DatabaseHelper
+}
+
+// NewMongoMigrationStore records applied migrations in the migrations collection
+func NewMongoMigrationStore(db DatabaseHelper) migrations.Store {
+	return &mongoMigrationStore{
+		db: db,
+	}
+}
+
+func (s *mongoMigrationStore) Applied(ctx context.Context) ([]migrations.Record, error) {
+	var records []migrations.Record
+	err := s.db.Collection(migrationDBO).Find(ctx, bson.M{}).Decode(&records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (s *mongoMigrationStore) Insert(ctx context.Context, record migrations.Record) error {
+	_, err := s.db.Collection(migrationDBO).InsertOne(ctx, record)
+	return err
+}
+
+func (s *mongoMigrationStore) Delete(ctx context.Context, version int) error {
+	_, err := s.db.Collection(migrationDBO).DeleteOne(ctx, bson.M{"_id": version})
+	return err
+}
+
+// MongoMigrations returns every migration for the MongoDB collections, in order. New
+// migrations must be appended with the next version number and never edited once released
+func MongoMigrations(db DatabaseHelper) []migrations.Migration {
+	return []migrations.Migration{
+		mongoIndex(db, 1, "users", "unique user UID", "users_uid",
+			bson.D{{Key: "details.uid", Value: 1}}, true),
+		mongoIndex(db, 2, "users", "unique user email", "users_email",
+			bson.D{{Key: "details.email", Value: 1}}, true),
+		mongoIndex(db, 3, "cows", "unique cow name per business", "cows_business_name",
+			bson.D{{Key: "Cow.Business", Value: 1}, {Key: "Cow.Name", Value: 1}}, true),
+		mongoIndex(db, 4, "devices", "device parent lookup", "devices_parent",
+			bson.D{{Key: "Device.Parent", Value: 1}}, false),
+		{
+			Version:     5,
+			Description: "store every ID as a hex string",
+			Up:          func(ctx context.Context) error { return mongoNormaliseIDs(ctx, db) },
+		},
+		mongoIndex(db, 6, "users", "password reset token lookup", "users_reset_token",
+			bson.D{{Key: "details.resettokenhash", Value: 1}}, false),
+		mongoIndex(db, 7, "users", "single sign-on provider lookup", "users_auth_provider",
+			bson.D{{Key: "details.authprovider", Value: 1}}, false),
+		mongoIndex(db, 8, "apikeys", "unique API key prefix", "apikeys_prefix",
+			bson.D{{Key: "details.prefix", Value: 1}}, true),
+		mongoIndex(db, 9, "invitations", "invitation token lookup", "invitations_token_hash",
+			bson.D{{Key: "details.tokenhash", Value: 1}}, false),
+		mongoIndex(db, 10, "audit", "audit log of a business by time", "audit_business_time",
+			bson.D{{Key: "details.business", Value: 1}, {Key: "details.time", Value: 1}}, false),
+		mongoIndex(db, 11, "audit", "audit log of an entity", "audit_entity",
+			bson.D{{Key: "details.entitytype", Value: 1}, {Key: "details.entityid", Value: 1}}, false),
+		mongoIndex(db, 12, "versions", "unique version numbers of an entity", "versions_entity_version",
+			bson.D{{Key: "details.entitytype", Value: 1}, {Key: "details.entityid", Value: 1}, {Key: "details.version", Value: 1}}, true),
+		mongoIndex(db, 13, "bookingrequests", "pending booking requests by expiry", "bookingrequests_status_expires",
+			bson.D{{Key: "details.status", Value: 1}, {Key: "details.expiresat", Value: 1}}, false),
+		mongoIndex(db, 14, "bookingrequests", "booking requests of a business", "bookingrequests_business",
+			bson.D{{Key: "details.business", Value: 1}}, false),
+		mongoIndex(db, 15, "waitlist", "waitlist queue of a block", "waitlist_slot",
+			bson.D{{Key: "details.cow", Value: 1}, {Key: "details.date", Value: 1}, {Key: "details.block", Value: 1}, {Key: "details.status", Value: 1}}, false),
+		mongoIndex(db, 16, "waitlist", "waitlist entries of a user", "waitlist_user",
+			bson.D{{Key: "details.user", Value: 1}, {Key: "details.status", Value: 1}}, false),
+		mongoIndex(db, 17, "bookingpolicies", "unique booking policy per business and role", "bookingpolicies_business_usertype",
+			bson.D{{Key: "details.business", Value: 1}, {Key: "details.usertype", Value: 1}}, true),
+		mongoIndex(db, 18, "calendar", "calendar entries by business and end", "calendar_business_end",
+			bson.D{{Key: "details.business", Value: 1}, {Key: "details.end", Value: 1}}, false),
+		mongoIndex(db, 19, "calendar", "calendar entries by imported iCal event", "calendar_business_uid",
+			bson.D{{Key: "details.business", Value: 1}, {Key: "details.uid", Value: 1}}, false),
+	}
+}
+
+// mongoNormaliseIDs converts the ObjectID _id's, device parents and cow device lists
+// written by older versions of the API into hex strings. It cannot be rolled back
+func mongoNormaliseIDs(ctx context.Context, db DatabaseHelper) error {
+	isObjectID := bson.M{"$type": "objectId"}
+
+	for _, name := range []string{cowDBO, deviceDBO, userDBO} {
+		collection := db.Collection(name)
+
+		var docs []bson.M
+		if err := collection.Find(ctx, bson.M{"_id": isObjectID}).Decode(&docs); err != nil {
+			return err
+		}
+
+		// the unique indexes stop us inserting the converted copy first, so put the
+		// original back if the copy can't be inserted
+		for _, doc := range docs {
+			oid := doc["_id"].(primitive.ObjectID)
+			if _, err := collection.DeleteOne(ctx, bson.M{"_id": oid}); err != nil {
+				return err
+			}
+			doc["_id"] = oid.Hex()
+			if _, err := collection.InsertOne(ctx, doc); err != nil {
+				doc["_id"] = oid
+				_, _ = collection.InsertOne(ctx, doc)
+				return fmt.Errorf("failed to convert %s %s: %w", name, oid.Hex(), err)
+			}
+		}
+	}
+
+	var devices []bson.M
+	if err := db.Collection(deviceDBO).Find(ctx, bson.M{"Device.Parent": isObjectID}).Decode(&devices); err != nil {
+		return err
+	}
+	for _, device := range devices {
+		parent := mongoIDString(mongoDocument(device["Device"])["Parent"])
+		update := bson.M{"$set": bson.M{"Device.Parent": parent}}
+		if _, err := db.Collection(deviceDBO).UpdateOne(ctx, bson.M{"_id": device["_id"]}, update); err != nil {
+			return err
+		}
+	}
+
+	// cow device lists may hold ObjectIDs, or {id: ...} documents pushed by the old add_device handler
+	var cows []bson.M
+	if err := db.Collection(cowDBO).Find(ctx, bson.M{"Cow.Devices": bson.M{"$elemMatch": bson.M{"$not": bson.M{"$type": "string"}}}}).Decode(&cows); err != nil {
+		return err
+	}
+	for _, cow := range cows {
+		list, _ := mongoDocument(cow["Cow"])["Devices"].(bson.A)
+
+		devices := bson.A{}
+		for _, item := range list {
+			if id := mongoIDString(item); id != "" {
+				devices = append(devices, id)
+			}
+		}
+
+		update := bson.M{"$set": bson.M{"Cow.Devices": devices}}
+		if _, err := db.Collection(cowDBO).UpdateOne(ctx, bson.M{"_id": cow["_id"]}, update); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mongoIDString is a helper function returning the hex string of an ID in any of the
+// shapes older versions of the API stored it in
+func mongoIDString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case primitive.ObjectID:
+		return value.Hex()
+	case bson.M, bson.D:
+		doc := mongoDocument(value)
+		for _, key := range []string{"_id", "id"} {
+			if id := mongoIDString(doc[key]); id != "" {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// mongoDocument is a helper function returning an embedded document as a bson.M
+func mongoDocument(v interface{}) bson.M {
+	switch doc := v.(type) {
+	case bson.M:
+		return doc
+	case bson.D:
+		return doc.Map()
+	}
+	return bson.M{}
+}
+
+// mongoIndex is a helper function building a migration that creates (and on rollback drops) a named index
+func mongoIndex(db DatabaseHelper, version int, collection, description, name string, keys bson.D, unique bool) migrations.Migration {
+	return migrations.Migration{
+		Version:     version,
+		Description: description,
+		Up: func(ctx context.Context) error {
+			_, err := db.Collection(collection).CreateIndex(ctx, mongo.IndexModel{
+				Keys:    keys,
+				Options: options.Index().SetName(name).SetUnique(unique),
+			})
+			return err
+		},
+		Down: func(ctx context.Context) error {
+			return db.Collection(collection).DropIndex(ctx, name)
+		},
+	}
+}
@@ -0,0 +1,61 @@
+package mongo_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases/conformance"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// TestConformance runs the shared store tests in a new database of the server at
+// MONGO_TEST_URI, eg. mongodb://localhost:27017. It is skipped when the variable isn't set
+func TestConformance(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	conf := &config.Config{Backend: databases.BackendMongo, URL: uri, DatabaseName: "conformance_" + models.NewID().String()}
+	defer dropDatabase(t, conf)
+
+	client, err := databases.NewClient(conf)
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	store := databases.NewMongoStore(client, databases.NewDatabase(conf, client))
+	defer store.Close(ctx)
+
+	if _, err := store.Migrator().Up(ctx, 0); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	conformance.Run(t, store)
+}
+
+// dropDatabase is a helper function removing the database a test ran in
+func dropDatabase(t *testing.T, conf *config.Config) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(conf.URL))
+	if err != nil {
+		t.Errorf("connect to drop the database: %v", err)
+		return
+	}
+	defer client.Disconnect(ctx)
+	if err := client.Database(conf.DatabaseName).Drop(ctx); err != nil {
+		t.Errorf("drop the database: %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package databases
+
+// go generate: mockery --name BookingPolicyDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const bookingPolicyDBO = "bookingpolicies"
+
+// BookingPolicyDatabase contains the methods to use with the booking policies of businesses.
+// A business has at most one policy per role, and one for every role
+type BookingPolicyDatabase interface {
+	FindOne(ctx context.Context, filter *BookingPolicyFilter) (*models.BookingPolicy, error)
+	Find(ctx context.Context, filter *BookingPolicyFilter) ([]models.BookingPolicy, error)
+	InsertOne(ctx context.Context, policy models.BookingPolicy) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *BookingPolicyFilter, update *BookingPolicyUpdate) (*UpdateResult, error)
+	DeleteOne(ctx context.Context, filter *BookingPolicyFilter) (*DeleteResult, error)
+}
+
+type bookingPolicyDatabase struct {
+	db DatabaseHelper
+}
+
+// NewBookingPolicyDatabase initializes a new instance of a booking policy database with the provided db connection
+func NewBookingPolicyDatabase(db DatabaseHelper) BookingPolicyDatabase {
+	return &bookingPolicyDatabase{
+		db: db,
+	}
+}
+
+func (b *bookingPolicyDatabase) FindOne(ctx context.Context, filter *BookingPolicyFilter) (*models.BookingPolicy, error) {
+	policy := &models.BookingPolicy{}
+	err := b.db.Collection(bookingPolicyDBO).FindOne(ctx, filter.query().Bson()).Decode(&policy)
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+func (b *bookingPolicyDatabase) Find(ctx context.Context, filter *BookingPolicyFilter) ([]models.BookingPolicy, error) {
+	var policies []models.BookingPolicy
+	err := b.db.Collection(bookingPolicyDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&policies)
+	if err != nil {
+		return nil, err
+	}
+	return policies, nil
+}
+
+func (b *bookingPolicyDatabase) InsertOne(ctx context.Context, policy models.BookingPolicy) (*InsertOneResult, error) {
+	result, err := b.db.Collection(bookingPolicyDBO).InsertOne(ctx, policy)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *bookingPolicyDatabase) UpdateOne(ctx context.Context, filter *BookingPolicyFilter, update *BookingPolicyUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := b.db.Collection(bookingPolicyDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (b *bookingPolicyDatabase) DeleteOne(ctx context.Context, filter *BookingPolicyFilter) (*DeleteResult, error) {
+	deleted, err := b.db.Collection(bookingPolicyDBO).DeleteOne(ctx, filter.query().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &DeleteResult{DeletedCount: deleted}, nil
+}
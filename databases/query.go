@@ -0,0 +1,1362 @@
+package databases
+
+import (
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// field is an entity field that can be filtered on or updated. It knows both its
+// document path for Mongo and its column for the SQL backends, so a typo can't slip
+// into a hand written filter
+type field struct {
+	path   string // document path, eg. Cow.Name
+	column string // SQL column, empty for fields stored in child tables
+}
+
+// Cow fields
+var (
+	cowID          = field{path: "_id", column: "id"}
+	cowName        = field{path: "Cow.Name", column: "name"}
+	cowBusiness    = field{path: "Cow.Business", column: "business"}
+	cowCollection  = field{path: "Cow.Collection", column: "collection"}
+	cowDeviceTotal = field{path: "Cow.DeviceTotal", column: "device_total"}
+	cowDevices     = field{path: "Cow.Devices"}
+	cowBookings    = field{path: "Cow.Bookings"}
+)
+
+// Device fields
+var (
+	deviceID     = field{path: "_id", column: "id"}
+	deviceType   = field{path: "Device.Type", column: "type"}
+	deviceName   = field{path: "Device.Name", column: "name"}
+	deviceParent = field{path: "Device.Parent", column: "parent"}
+)
+
+// User fields
+var (
+	userID           = field{path: "_id", column: "id"}
+	userFirstName    = field{path: "details.firstname", column: "first_name"}
+	userLastName     = field{path: "details.lastname", column: "last_name"}
+	userEmail        = field{path: "details.email", column: "email"}
+	userPassword     = field{path: "details.password", column: "password"}
+	userTempPassword = field{path: "details.temppassword", column: "temp_password"}
+	userUID          = field{path: "details.uid", column: "uid"}
+	userBusiness     = field{path: "details.business", column: "business"}
+	userType         = field{path: "details.usertype", column: "user_type"}
+	userUpdatedAt    = field{path: "details.updated_at", column: "updated_at"}
+	userResetToken   = field{path: "details.resettokenhash", column: "reset_token_hash"}
+	userResetExpires = field{path: "details.resettokenexpires", column: "reset_token_expires"}
+	userSessions     = field{path: "details.sessions"}
+	userTOTPEnabled  = field{path: "details.totpenabled", column: "totp_enabled"}
+	userTOTPSecret   = field{path: "details.totpsecret", column: "totp_secret"}
+	userTOTPLastStep = field{path: "details.totplaststep", column: "totp_last_step"}
+	userRecovery     = field{path: "details.recoverycodes"}
+	userProvider     = field{path: "details.authprovider", column: "auth_provider"}
+	userDisabled     = field{path: "details.disabled", column: "disabled"}
+	userFailedLogins = field{path: "details.failedlogins", column: "failed_logins"}
+	userLastFailed   = field{path: "details.lastfailedlogin", column: "last_failed_login"}
+	userLockedUntil  = field{path: "details.lockeduntil", column: "locked_until"}
+	userKnownDevices = field{path: "details.knowndevices"}
+)
+
+// API key fields
+var (
+	apiKeyID         = field{path: "_id", column: "id"}
+	apiKeyPrefix     = field{path: "details.prefix", column: "prefix"}
+	apiKeyBusiness   = field{path: "details.business", column: "business"}
+	apiKeyLastUsedAt = field{path: "details.lastusedat", column: "last_used_at"}
+	apiKeyLastUsedIP = field{path: "details.lastusedip", column: "last_used_ip"}
+)
+
+// Invitation fields
+var (
+	invitationID       = field{path: "_id", column: "id"}
+	invitationEmail    = field{path: "details.email", column: "email"}
+	invitationBusiness = field{path: "details.business", column: "business"}
+	invitationStatus   = field{path: "details.status", column: "status"}
+	invitationToken    = field{path: "details.tokenhash", column: "token_hash"}
+	invitationSentAt   = field{path: "details.sentat", column: "sent_at"}
+	invitationExpires  = field{path: "details.expiresat", column: "expires_at"}
+	invitationAccepted = field{path: "details.acceptedat", column: "accepted_at"}
+	invitationUser     = field{path: "details.user", column: "user_id"}
+)
+
+// Audit log fields
+var (
+	auditTime       = field{path: "details.time", column: "recorded_at"}
+	auditActor      = field{path: "details.actor.id", column: "actor_id"}
+	auditBusiness   = field{path: "details.business", column: "business"}
+	auditAction     = field{path: "details.action", column: "action"}
+	auditEntityType = field{path: "details.entitytype", column: "entity_type"}
+	auditEntityID   = field{path: "details.entityid", column: "entity_id"}
+	auditRequestID  = field{path: "details.requestid", column: "request_id"}
+)
+
+// Version fields
+var (
+	versionEntityType = field{path: "details.entitytype", column: "entity_type"}
+	versionEntityID   = field{path: "details.entityid", column: "entity_id"}
+	versionNumber     = field{path: "details.version", column: "version"}
+)
+
+// Approval policy fields
+var (
+	approvalID        = field{path: "_id", column: "id"}
+	approvalBusiness  = field{path: "details.business", column: "business"}
+	approvalApprovers = field{path: "details.approvers"}
+	approvalUpdatedBy = field{path: "details.updatedby", column: "updated_by"}
+	approvalUpdatedAt = field{path: "details.updatedat", column: "updated_at"}
+)
+
+// Booking request fields
+var (
+	requestID        = field{path: "_id", column: "id"}
+	requestCow       = field{path: "details.cow", column: "cow_id"}
+	requestBusiness  = field{path: "details.business", column: "business"}
+	requestRequester = field{path: "details.requester", column: "requester"}
+	requestStatus    = field{path: "details.status", column: "status"}
+	requestExpires   = field{path: "details.expiresat", column: "expires_at"}
+	requestDecidedBy = field{path: "details.decidedby", column: "decided_by"}
+	requestDecidedAt = field{path: "details.decidedat", column: "decided_at"}
+	requestReason    = field{path: "details.reason", column: "reason"}
+)
+
+// Waitlist entry fields
+var (
+	waitlistID           = field{path: "_id", column: "id"}
+	waitlistCow          = field{path: "details.cow", column: "cow_id"}
+	waitlistBusiness     = field{path: "details.business", column: "business"}
+	waitlistDate         = field{path: "details.date", column: "slot_date"}
+	waitlistBlock        = field{path: "details.block", column: "block"}
+	waitlistUser         = field{path: "details.user", column: "user_id"}
+	waitlistStatus       = field{path: "details.status", column: "status"}
+	waitlistOfferedAt    = field{path: "details.offeredat", column: "offered_at"}
+	waitlistOfferExpires = field{path: "details.offerexpiresat", column: "offer_expires_at"}
+	waitlistUpdatedAt    = field{path: "details.updatedat", column: "updated_at"}
+)
+
+// Booking policy fields
+var (
+	policyID         = field{path: "_id", column: "id"}
+	policyBusiness   = field{path: "details.business", column: "business"}
+	policyUserType   = field{path: "details.usertype", column: "user_type"}
+	policyConcurrent = field{path: "details.maxconcurrent", column: "max_concurrent"}
+	policyPerWeek    = field{path: "details.maxperweek", column: "max_per_week"}
+	policyLeadDays   = field{path: "details.maxleaddays", column: "max_lead_days"}
+	policyNotice     = field{path: "details.minnoticeminutes", column: "min_notice_minutes"}
+	policyDuration   = field{path: "details.maxdurationminutes", column: "max_duration_minutes"}
+	policyUpdatedBy  = field{path: "details.updatedby", column: "updated_by"}
+	policyUpdatedAt  = field{path: "details.updatedat", column: "updated_at"}
+)
+
+// Calendar entry fields
+var (
+	calendarID       = field{path: "_id", column: "id"}
+	calendarBusiness = field{path: "details.business", column: "business"}
+	calendarKind     = field{path: "details.kind", column: "kind"}
+	calendarReason   = field{path: "details.reason", column: "reason"}
+	calendarStart    = field{path: "details.start", column: "start_at"}
+	calendarEnd      = field{path: "details.end", column: "end_at"}
+	calendarCow      = field{path: "details.cow", column: "cow_id"}
+	calendarUID      = field{path: "details.uid", column: "uid"}
+)
+
+type operator int
+
+const (
+	opEq           operator = iota
+	opIn                    // value is a []interface{}
+	opOverlap               // value is a [2]time.Time, matches bookings overlapping the range
+	opLt                    // matches values less than the condition value
+	opGte                   // matches values greater than or equal to the condition value
+	opGt                    // matches values greater than the condition value
+	opSlotFree              // value is a slot, matches cows without a booking of its block starting in its span
+	opRecoveryCode          // value is a hash, matches users holding it among their recovery codes
+)
+
+// slot is the block of a day, the value of opSlotFree
+type slot struct {
+	block string
+	span  [2]time.Time
+}
+
+// condition is a single backend independent comparison
+type condition struct {
+	field field
+	op    operator
+	value interface{}
+}
+
+// Filter holds the conditions, all of which must match, and paging of a query.
+// Use the typed filters (CowFilter, DeviceFilter, UserFilter) to build one
+type Filter struct {
+	conditions []condition
+	limit      int64
+	skip       int64
+	order      field // sort key instead of the ID
+	descending bool
+}
+
+func (f *Filter) add(fd field, op operator, value interface{}) {
+	f.conditions = append(f.conditions, condition{field: fd, op: op, value: value})
+}
+
+// Bson compiles the filter to a Mongo filter document
+func (f *Filter) Bson() bson.M {
+	doc := bson.M{}
+	if f == nil {
+		return doc
+	}
+
+	for _, c := range f.conditions {
+		switch c.op {
+		case opEq:
+			doc[c.field.path] = c.value
+		case opIn:
+			doc[c.field.path] = bson.M{"$in": c.value}
+		case opLt:
+			// also matches documents written before the field existed, like the SQL column default
+			bounds(doc, c.field.path)["$not"] = bson.M{"$gte": c.value}
+		case opGte:
+			bounds(doc, c.field.path)["$gte"] = c.value
+		case opGt:
+			bounds(doc, c.field.path)["$gt"] = c.value
+		case opOverlap:
+			span := c.value.([2]time.Time)
+			doc[c.field.path] = bson.M{"$elemMatch": bson.M{
+				"StartDate": bson.M{"$lt": primitive.NewDateTimeFromTime(span[1])},
+				"EndDate":   bson.M{"$gt": primitive.NewDateTimeFromTime(span[0])},
+			}}
+		case opRecoveryCode:
+			doc[c.field.path] = c.value
+		case opSlotFree:
+			s := c.value.(slot)
+			doc[c.field.path] = bson.M{"$not": bson.M{"$elemMatch": bson.M{
+				"Block":     s.block,
+				"StartDate": bson.M{"$gte": primitive.NewDateTimeFromTime(s.span[0]), "$lt": primitive.NewDateTimeFromTime(s.span[1])},
+			}}}
+		}
+	}
+	return doc
+}
+
+// bounds is a helper function returning the comparisons on path in doc, so a lower and an
+// upper bound on the same field end up in one document
+func bounds(doc bson.M, path string) bson.M {
+	if m, ok := doc[path].(bson.M); ok {
+		return m
+	}
+	m := bson.M{}
+	doc[path] = m
+	return m
+}
+
+// FindOptions returns the Mongo paging options of the filter, sorted by _id so pages are
+// stable and match the SQL backends, unless the typed filter sorts by another unique key
+func (f *Filter) FindOptions() *options.FindOptions {
+	opts := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	if f == nil {
+		return opts
+	}
+	if f.order.path != "" || f.descending {
+		key, direction := "_id", 1
+		if f.order.path != "" {
+			key = f.order.path
+		}
+		if f.descending {
+			direction = -1
+		}
+		opts.SetSort(bson.D{{Key: key, Value: direction}})
+	}
+	if f.limit > 0 {
+		opts.SetLimit(f.limit)
+	}
+	if f.skip > 0 {
+		opts.SetSkip(f.skip)
+	}
+	return opts
+}
+
+// CowFilter selects cows. A nil filter matches every cow
+type CowFilter struct{ Filter }
+
+// FilterCows starts a new cow filter
+func FilterCows() *CowFilter { return &CowFilter{} }
+
+func (f *CowFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the cow with the given ID
+func (f *CowFilter) ID(id models.ID) *CowFilter { f.add(cowID, opEq, id); return f }
+
+// IDs matches any of the given cow IDs
+func (f *CowFilter) IDs(ids ...models.ID) *CowFilter { f.add(cowID, opIn, anySlice(ids)); return f }
+
+// Name matches cows with exactly this name
+func (f *CowFilter) Name(name string) *CowFilter { f.add(cowName, opEq, name); return f }
+
+// Business matches cows belonging to the business
+func (f *CowFilter) Business(business models.ID) *CowFilter {
+	f.add(cowBusiness, opEq, business)
+	return f
+}
+
+// Collection matches cows of the collection, eg. Laptop
+func (f *CowFilter) Collection(collection string) *CowFilter {
+	f.add(cowCollection, opEq, collection)
+	return f
+}
+
+// BookedBetween matches cows with at least one booking overlapping [from, to)
+func (f *CowFilter) BookedBetween(from, to time.Time) *CowFilter {
+	f.add(cowBookings, opOverlap, [2]time.Time{from, to})
+	return f
+}
+
+// SlotFree matches cows without a booking of block starting in [from, to), the day of the
+// block. Updates filtered on it add a booking only while its block is free
+func (f *CowFilter) SlotFree(block string, from, to time.Time) *CowFilter {
+	f.add(cowBookings, opSlotFree, slot{block: block, span: [2]time.Time{from, to}})
+	return f
+}
+
+// Limit caps the number of cows returned
+func (f *CowFilter) Limit(n int64) *CowFilter { f.limit = n; return f }
+
+// Skip skips the first n cows, ordered by ID
+func (f *CowFilter) Skip(n int64) *CowFilter { f.skip = n; return f }
+
+// DeviceFilter selects devices. A nil filter matches every device
+type DeviceFilter struct{ Filter }
+
+// FilterDevices starts a new device filter
+func FilterDevices() *DeviceFilter { return &DeviceFilter{} }
+
+func (f *DeviceFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the device with the given ID
+func (f *DeviceFilter) ID(id models.ID) *DeviceFilter { f.add(deviceID, opEq, id); return f }
+
+// IDs matches any of the given device IDs
+func (f *DeviceFilter) IDs(ids ...models.ID) *DeviceFilter {
+	f.add(deviceID, opIn, anySlice(ids))
+	return f
+}
+
+// Name matches devices with exactly this name
+func (f *DeviceFilter) Name(name string) *DeviceFilter { f.add(deviceName, opEq, name); return f }
+
+// Type matches devices of the type, eg. Laptop
+func (f *DeviceFilter) Type(t string) *DeviceFilter { f.add(deviceType, opEq, t); return f }
+
+// Parent matches devices belonging to the cow
+func (f *DeviceFilter) Parent(cowID models.ID) *DeviceFilter {
+	f.add(deviceParent, opEq, cowID)
+	return f
+}
+
+// Parents matches devices belonging to any of the cows
+func (f *DeviceFilter) Parents(cowIDs ...models.ID) *DeviceFilter {
+	f.add(deviceParent, opIn, anySlice(cowIDs))
+	return f
+}
+
+// Limit caps the number of devices returned
+func (f *DeviceFilter) Limit(n int64) *DeviceFilter { f.limit = n; return f }
+
+// Skip skips the first n devices, ordered by ID
+func (f *DeviceFilter) Skip(n int64) *DeviceFilter { f.skip = n; return f }
+
+// UserFilter selects users. A nil filter matches every user
+type UserFilter struct{ Filter }
+
+// FilterUsers starts a new user filter
+func FilterUsers() *UserFilter { return &UserFilter{} }
+
+func (f *UserFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the user with the given document ID
+func (f *UserFilter) ID(id models.ID) *UserFilter { f.add(userID, opEq, id); return f }
+
+// UID matches the user with the given login ID
+func (f *UserFilter) UID(uid string) *UserFilter { f.add(userUID, opEq, uid); return f }
+
+// Email matches the user with the given email
+func (f *UserFilter) Email(email string) *UserFilter { f.add(userEmail, opEq, email); return f }
+
+// Business matches users belonging to the business
+func (f *UserFilter) Business(business models.ID) *UserFilter {
+	f.add(userBusiness, opEq, business)
+	return f
+}
+
+// ResetToken matches the user with the hash of a forgot password token
+func (f *UserFilter) ResetToken(hash string) *UserFilter { f.add(userResetToken, opEq, hash); return f }
+
+// TOTPStepBefore matches users whose last accepted two-factor code is older than step, so
+// an update using it accepts each code once
+func (f *UserFilter) TOTPStepBefore(step int64) *UserFilter {
+	f.add(userTOTPLastStep, opLt, step)
+	return f
+}
+
+// RecoveryCode matches users holding the hash among their unused recovery codes, so an
+// update removing it succeeds once
+func (f *UserFilter) RecoveryCode(hash string) *UserFilter {
+	f.add(userRecovery, opRecoveryCode, hash)
+	return f
+}
+
+// AuthProvider matches users created by a single sign-on provider, eg. "oidc:sd42-google"
+func (f *UserFilter) AuthProvider(provider string) *UserFilter {
+	f.add(userProvider, opEq, provider)
+	return f
+}
+
+// Disabled matches users that can't sign in. There is no opposite, documents written
+// before the field existed don't have it
+func (f *UserFilter) Disabled() *UserFilter { f.add(userDisabled, opEq, true); return f }
+
+// UserType matches users of the given type, eg. models.TypeAdmin
+func (f *UserFilter) UserType(t int) *UserFilter { f.add(userType, opEq, t); return f }
+
+// Limit caps the number of users returned
+func (f *UserFilter) Limit(n int64) *UserFilter { f.limit = n; return f }
+
+// Skip skips the first n users, ordered by ID
+func (f *UserFilter) Skip(n int64) *UserFilter { f.skip = n; return f }
+
+// APIKeyFilter selects API keys. A nil filter matches every key
+type APIKeyFilter struct{ Filter }
+
+// FilterAPIKeys starts a new API key filter
+func FilterAPIKeys() *APIKeyFilter { return &APIKeyFilter{} }
+
+func (f *APIKeyFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the API key with the given ID
+func (f *APIKeyFilter) ID(id models.ID) *APIKeyFilter { f.add(apiKeyID, opEq, id); return f }
+
+// Prefix matches the API key starting with prefix, see auth.ParseAPIKey
+func (f *APIKeyFilter) Prefix(prefix string) *APIKeyFilter {
+	f.add(apiKeyPrefix, opEq, prefix)
+	return f
+}
+
+// Business matches the API keys of the business
+func (f *APIKeyFilter) Business(business models.ID) *APIKeyFilter {
+	f.add(apiKeyBusiness, opEq, business)
+	return f
+}
+
+// Limit caps the number of API keys returned
+func (f *APIKeyFilter) Limit(n int64) *APIKeyFilter { f.limit = n; return f }
+
+// Skip skips the first n API keys, ordered by ID
+func (f *APIKeyFilter) Skip(n int64) *APIKeyFilter { f.skip = n; return f }
+
+// InvitationFilter selects invitations. A nil filter matches every invitation
+type InvitationFilter struct{ Filter }
+
+// FilterInvitations starts a new invitation filter
+func FilterInvitations() *InvitationFilter { return &InvitationFilter{} }
+
+func (f *InvitationFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the invitation with the given ID
+func (f *InvitationFilter) ID(id models.ID) *InvitationFilter {
+	f.add(invitationID, opEq, id)
+	return f
+}
+
+// Email matches the invitations of an email
+func (f *InvitationFilter) Email(email string) *InvitationFilter {
+	f.add(invitationEmail, opEq, email)
+	return f
+}
+
+// Business matches the invitations to the business
+func (f *InvitationFilter) Business(business models.ID) *InvitationFilter {
+	f.add(invitationBusiness, opEq, business)
+	return f
+}
+
+// Status matches invitations in the state, eg. models.InvitationPending
+func (f *InvitationFilter) Status(status string) *InvitationFilter {
+	f.add(invitationStatus, opEq, status)
+	return f
+}
+
+// Token matches the invitation with the hash of a signup token
+func (f *InvitationFilter) Token(hash string) *InvitationFilter {
+	f.add(invitationToken, opEq, hash)
+	return f
+}
+
+// Limit caps the number of invitations returned
+func (f *InvitationFilter) Limit(n int64) *InvitationFilter { f.limit = n; return f }
+
+// Skip skips the first n invitations, ordered by ID
+func (f *InvitationFilter) Skip(n int64) *InvitationFilter { f.skip = n; return f }
+
+// AuditFilter selects audit log entries. A nil filter matches every entry
+type AuditFilter struct{ Filter }
+
+// FilterAudit starts a new audit log filter
+func FilterAudit() *AuditFilter { return &AuditFilter{} }
+
+func (f *AuditFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// Business matches the changes to entities of the business
+func (f *AuditFilter) Business(business models.ID) *AuditFilter {
+	f.add(auditBusiness, opEq, business)
+	return f
+}
+
+// Actor matches the changes made by a user or API key
+func (f *AuditFilter) Actor(id models.ID) *AuditFilter {
+	f.add(auditActor, opEq, id)
+	return f
+}
+
+// Action matches the changes of a kind, eg. models.AuditDelete
+func (f *AuditFilter) Action(action string) *AuditFilter {
+	f.add(auditAction, opEq, action)
+	return f
+}
+
+// EntityType matches the changes to a type of entity, eg. models.AuditBooking
+func (f *AuditFilter) EntityType(entityType string) *AuditFilter {
+	f.add(auditEntityType, opEq, entityType)
+	return f
+}
+
+// EntityID matches the changes to one entity
+func (f *AuditFilter) EntityID(id string) *AuditFilter {
+	f.add(auditEntityID, opEq, id)
+	return f
+}
+
+// RequestID matches the changes made by one request
+func (f *AuditFilter) RequestID(id string) *AuditFilter {
+	f.add(auditRequestID, opEq, id)
+	return f
+}
+
+// Since matches the changes made at or after t
+func (f *AuditFilter) Since(t time.Time) *AuditFilter {
+	f.add(auditTime, opGte, t)
+	return f
+}
+
+// Until matches the changes made before t
+func (f *AuditFilter) Until(t time.Time) *AuditFilter {
+	f.add(auditTime, opLt, t)
+	return f
+}
+
+// Limit caps the number of entries returned
+func (f *AuditFilter) Limit(n int64) *AuditFilter { f.limit = n; return f }
+
+// Skip skips the first n entries, ordered by ID which is the order they were recorded in
+func (f *AuditFilter) Skip(n int64) *AuditFilter { f.skip = n; return f }
+
+// VersionFilter selects versions of cows and devices. A nil filter matches every version
+type VersionFilter struct{ Filter }
+
+// FilterVersions starts a new version filter
+func FilterVersions() *VersionFilter { return &VersionFilter{} }
+
+func (f *VersionFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// Entity matches the versions of one entity, eg. models.AuditCow and a cow ID
+func (f *VersionFilter) Entity(entityType string, id models.ID) *VersionFilter {
+	f.add(versionEntityType, opEq, entityType)
+	f.add(versionEntityID, opEq, id)
+	return f
+}
+
+// Version matches the version with the given number
+func (f *VersionFilter) Version(n int) *VersionFilter { f.add(versionNumber, opEq, n); return f }
+
+// Newest returns the highest version numbers first instead of the oldest versions
+func (f *VersionFilter) Newest() *VersionFilter {
+	f.order, f.descending = versionNumber, true
+	return f
+}
+
+// Limit caps the number of versions returned
+func (f *VersionFilter) Limit(n int64) *VersionFilter { f.limit = n; return f }
+
+// Skip skips the first n versions
+func (f *VersionFilter) Skip(n int64) *VersionFilter { f.skip = n; return f }
+
+// ApprovalPolicyFilter selects approval policies. A nil filter matches every policy
+type ApprovalPolicyFilter struct{ Filter }
+
+// FilterApprovalPolicies starts a new approval policy filter
+func FilterApprovalPolicies() *ApprovalPolicyFilter { return &ApprovalPolicyFilter{} }
+
+func (f *ApprovalPolicyFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// Cow matches the policy of a cow, policies have the ID of their cow
+func (f *ApprovalPolicyFilter) Cow(id models.ID) *ApprovalPolicyFilter {
+	f.add(approvalID, opEq, id)
+	return f
+}
+
+// Business matches the policies of the cows of a business
+func (f *ApprovalPolicyFilter) Business(business models.ID) *ApprovalPolicyFilter {
+	f.add(approvalBusiness, opEq, business)
+	return f
+}
+
+// Limit caps the number of policies returned
+func (f *ApprovalPolicyFilter) Limit(n int64) *ApprovalPolicyFilter { f.limit = n; return f }
+
+// Skip skips the first n policies, ordered by cow ID
+func (f *ApprovalPolicyFilter) Skip(n int64) *ApprovalPolicyFilter { f.skip = n; return f }
+
+// BookingRequestFilter selects booking requests. A nil filter matches every request
+type BookingRequestFilter struct{ Filter }
+
+// FilterBookingRequests starts a new booking request filter
+func FilterBookingRequests() *BookingRequestFilter { return &BookingRequestFilter{} }
+
+func (f *BookingRequestFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the request with the given ID
+func (f *BookingRequestFilter) ID(id models.ID) *BookingRequestFilter {
+	f.add(requestID, opEq, id)
+	return f
+}
+
+// Cow matches the requests to book a cow
+func (f *BookingRequestFilter) Cow(id models.ID) *BookingRequestFilter {
+	f.add(requestCow, opEq, id)
+	return f
+}
+
+// Business matches the requests for the cows of a business
+func (f *BookingRequestFilter) Business(business models.ID) *BookingRequestFilter {
+	f.add(requestBusiness, opEq, business)
+	return f
+}
+
+// Requester matches the requests a user made
+func (f *BookingRequestFilter) Requester(id models.ID) *BookingRequestFilter {
+	f.add(requestRequester, opEq, id)
+	return f
+}
+
+// Status matches requests in the state, eg. models.BookingPending
+func (f *BookingRequestFilter) Status(status string) *BookingRequestFilter {
+	f.add(requestStatus, opEq, status)
+	return f
+}
+
+// ExpiresBefore matches the requests expiring before t
+func (f *BookingRequestFilter) ExpiresBefore(t time.Time) *BookingRequestFilter {
+	f.add(requestExpires, opLt, t)
+	return f
+}
+
+// Limit caps the number of requests returned
+func (f *BookingRequestFilter) Limit(n int64) *BookingRequestFilter { f.limit = n; return f }
+
+// Skip skips the first n requests, ordered by ID which is the order they were made in
+func (f *BookingRequestFilter) Skip(n int64) *BookingRequestFilter { f.skip = n; return f }
+
+// WaitlistFilter selects waitlist entries. A nil filter matches every entry
+type WaitlistFilter struct{ Filter }
+
+// FilterWaitlist starts a new waitlist filter
+func FilterWaitlist() *WaitlistFilter { return &WaitlistFilter{} }
+
+func (f *WaitlistFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the entry with the given ID
+func (f *WaitlistFilter) ID(id models.ID) *WaitlistFilter {
+	f.add(waitlistID, opEq, id)
+	return f
+}
+
+// Cow matches the entries queueing for a cow
+func (f *WaitlistFilter) Cow(id models.ID) *WaitlistFilter {
+	f.add(waitlistCow, opEq, id)
+	return f
+}
+
+// Slot matches the entries queueing for a block of a cow on a date, formatted YYYY-MM-DD
+func (f *WaitlistFilter) Slot(cowID models.ID, date, block string) *WaitlistFilter {
+	f.add(waitlistCow, opEq, cowID)
+	return f.Date(date).Block(block)
+}
+
+// Date matches the entries for blocks on a date, formatted YYYY-MM-DD
+func (f *WaitlistFilter) Date(date string) *WaitlistFilter {
+	f.add(waitlistDate, opEq, date)
+	return f
+}
+
+// Block matches the entries for a block
+func (f *WaitlistFilter) Block(block string) *WaitlistFilter {
+	f.add(waitlistBlock, opEq, block)
+	return f
+}
+
+// Business matches the entries for the cows of a business
+func (f *WaitlistFilter) Business(business models.ID) *WaitlistFilter {
+	f.add(waitlistBusiness, opEq, business)
+	return f
+}
+
+// User matches the entries of a user
+func (f *WaitlistFilter) User(id models.ID) *WaitlistFilter {
+	f.add(waitlistUser, opEq, id)
+	return f
+}
+
+// Status matches entries in any of the states, eg. models.WaitlistWaiting
+func (f *WaitlistFilter) Status(statuses ...string) *WaitlistFilter {
+	values := make([]interface{}, len(statuses))
+	for i, status := range statuses {
+		values[i] = status
+	}
+	f.add(waitlistStatus, opIn, values)
+	return f
+}
+
+// DateBefore matches the entries for blocks on days before date, formatted YYYY-MM-DD
+func (f *WaitlistFilter) DateBefore(date string) *WaitlistFilter {
+	f.add(waitlistDate, opLt, date)
+	return f
+}
+
+// OfferExpiresBefore matches the entries whose offer expires before t
+func (f *WaitlistFilter) OfferExpiresBefore(t time.Time) *WaitlistFilter {
+	f.add(waitlistOfferExpires, opLt, t)
+	return f
+}
+
+// Limit caps the number of entries returned
+func (f *WaitlistFilter) Limit(n int64) *WaitlistFilter { f.limit = n; return f }
+
+// Skip skips the first n entries, ordered by ID which is the order they joined in
+func (f *WaitlistFilter) Skip(n int64) *WaitlistFilter { f.skip = n; return f }
+
+// BookingPolicyFilter selects booking policies. A nil filter matches every policy
+type BookingPolicyFilter struct{ Filter }
+
+// FilterBookingPolicies starts a new booking policy filter
+func FilterBookingPolicies() *BookingPolicyFilter { return &BookingPolicyFilter{} }
+
+func (f *BookingPolicyFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the policy with the given ID
+func (f *BookingPolicyFilter) ID(id models.ID) *BookingPolicyFilter {
+	f.add(policyID, opEq, id)
+	return f
+}
+
+// Business matches the policies of a business
+func (f *BookingPolicyFilter) Business(business models.ID) *BookingPolicyFilter {
+	f.add(policyBusiness, opEq, business)
+	return f
+}
+
+// UserType matches the policies of a role, 0 for the business wide policy
+func (f *BookingPolicyFilter) UserType(userType int) *BookingPolicyFilter {
+	f.add(policyUserType, opEq, userType)
+	return f
+}
+
+// Limit caps the number of policies returned
+func (f *BookingPolicyFilter) Limit(n int64) *BookingPolicyFilter { f.limit = n; return f }
+
+// Skip skips the first n policies
+func (f *BookingPolicyFilter) Skip(n int64) *BookingPolicyFilter { f.skip = n; return f }
+
+// CalendarFilter selects calendar entries. A nil filter matches every entry
+type CalendarFilter struct{ Filter }
+
+// FilterCalendar starts a new calendar entry filter
+func FilterCalendar() *CalendarFilter { return &CalendarFilter{} }
+
+func (f *CalendarFilter) query() *Filter {
+	if f == nil {
+		return nil
+	}
+	return &f.Filter
+}
+
+// ID matches the entry with the given ID
+func (f *CalendarFilter) ID(id models.ID) *CalendarFilter {
+	f.add(calendarID, opEq, id)
+	return f
+}
+
+// Business matches the entries of a business
+func (f *CalendarFilter) Business(business models.ID) *CalendarFilter {
+	f.add(calendarBusiness, opEq, business)
+	return f
+}
+
+// Businesses matches the entries of any of the given businesses
+func (f *CalendarFilter) Businesses(businesses ...models.ID) *CalendarFilter {
+	f.add(calendarBusiness, opIn, anySlice(businesses))
+	return f
+}
+
+// Kind matches closures or blackouts, models.CalendarClosure or models.CalendarBlackout
+func (f *CalendarFilter) Kind(kind string) *CalendarFilter {
+	f.add(calendarKind, opEq, kind)
+	return f
+}
+
+// Cow matches the blackouts of a single cow
+func (f *CalendarFilter) Cow(id models.ID) *CalendarFilter {
+	f.add(calendarCow, opEq, id)
+	return f
+}
+
+// UID matches the entry imported from an iCal event
+func (f *CalendarFilter) UID(uid string) *CalendarFilter {
+	f.add(calendarUID, opEq, uid)
+	return f
+}
+
+// Between matches entries overlapping [from, to)
+func (f *CalendarFilter) Between(from, to time.Time) *CalendarFilter {
+	f.add(calendarStart, opLt, to)
+	f.add(calendarEnd, opGt, from)
+	return f
+}
+
+// Limit caps the number of entries returned
+func (f *CalendarFilter) Limit(n int64) *CalendarFilter { f.limit = n; return f }
+
+// Skip skips the first n entries, ordered by ID
+func (f *CalendarFilter) Skip(n int64) *CalendarFilter { f.skip = n; return f }
+
+// ErrEmptyUpdate is returned when an update has no changes
+var ErrEmptyUpdate = errors.New("update has no changes")
+
+// change is a single backend independent modification
+type change struct {
+	field field
+	push  bool // append value to the array field instead of replacing it
+	pull  bool // remove the array elements matching value, a Mongo condition
+	value interface{}
+}
+
+// Update holds the changes of an update. Use the typed updates (CowUpdate,
+// DeviceUpdate, UserUpdate) to build one
+type Update struct {
+	changes []change
+}
+
+func (u *Update) set(fd field, value interface{}) {
+	u.changes = append(u.changes, change{field: fd, value: value})
+}
+
+func (u *Update) push(fd field, value interface{}) {
+	u.changes = append(u.changes, change{field: fd, push: true, value: value})
+}
+
+func (u *Update) pull(fd field, match bson.M) {
+	u.changes = append(u.changes, change{field: fd, pull: true, value: match})
+}
+
+// Empty reports whether the update changes nothing
+func (u *Update) Empty() bool {
+	return u == nil || len(u.changes) == 0
+}
+
+// Bson compiles the update to a Mongo update document
+func (u *Update) Bson() bson.M {
+	set, push, pull := bson.M{}, bson.M{}, bson.M{}
+	if u != nil {
+		for _, c := range u.changes {
+			switch {
+			case c.push:
+				push[c.field.path] = c.value
+			case c.pull:
+				pull[c.field.path] = c.value
+			default:
+				set[c.field.path] = c.value
+			}
+		}
+	}
+
+	doc := bson.M{}
+	if len(set) > 0 {
+		doc["$set"] = set
+	}
+	if len(push) > 0 {
+		doc["$push"] = push
+	}
+	if len(pull) > 0 {
+		doc["$pull"] = pull
+	}
+	return doc
+}
+
+// CowUpdate modifies a cow
+type CowUpdate struct{ Update }
+
+// UpdateCow starts a new cow update
+func UpdateCow() *CowUpdate { return &CowUpdate{} }
+
+func (u *CowUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetName renames the cow
+func (u *CowUpdate) SetName(name string) *CowUpdate { u.set(cowName, name); return u }
+
+// SetBusiness moves the cow to another business
+func (u *CowUpdate) SetBusiness(business models.ID) *CowUpdate {
+	u.set(cowBusiness, business)
+	return u
+}
+
+// SetCollection changes the collection, eg. Laptop
+func (u *CowUpdate) SetCollection(collection string) *CowUpdate {
+	u.set(cowCollection, collection)
+	return u
+}
+
+// SetDeviceTotal changes the number of devices in the cart
+func (u *CowUpdate) SetDeviceTotal(total int) *CowUpdate {
+	u.set(cowDeviceTotal, total)
+	return u
+}
+
+// SetDevices replaces the device list of the cow
+func (u *CowUpdate) SetDevices(devices []models.ID) *CowUpdate {
+	u.set(cowDevices, devices)
+	return u
+}
+
+// SetBookings replaces every booking of the cow
+func (u *CowUpdate) SetBookings(bookings []models.BookDetails) *CowUpdate {
+	u.set(cowBookings, bookings)
+	return u
+}
+
+// PushDevice appends a device ID to the device list of the cow
+func (u *CowUpdate) PushDevice(deviceID models.ID) *CowUpdate {
+	u.push(cowDevices, deviceID)
+	return u
+}
+
+// PushBooking appends a booking to the cow
+func (u *CowUpdate) PushBooking(booking models.BookDetails) *CowUpdate {
+	u.push(cowBookings, booking)
+	return u
+}
+
+// PullBooking removes the booking with the given ID from the cow
+func (u *CowUpdate) PullBooking(bookingID string) *CowUpdate {
+	u.pull(cowBookings, bson.M{"ID": bookingID})
+	return u
+}
+
+// DeviceUpdate modifies a device
+type DeviceUpdate struct{ Update }
+
+// UpdateDevice starts a new device update
+func UpdateDevice() *DeviceUpdate { return &DeviceUpdate{} }
+
+func (u *DeviceUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetName renames the device
+func (u *DeviceUpdate) SetName(name string) *DeviceUpdate { u.set(deviceName, name); return u }
+
+// SetType changes the device type
+func (u *DeviceUpdate) SetType(t string) *DeviceUpdate { u.set(deviceType, t); return u }
+
+// SetParent moves the device to another cow
+func (u *DeviceUpdate) SetParent(cowID models.ID) *DeviceUpdate {
+	u.set(deviceParent, cowID)
+	return u
+}
+
+// UserUpdate modifies a user
+type UserUpdate struct{ Update }
+
+// UpdateUser starts a new user update
+func UpdateUser() *UserUpdate { return &UserUpdate{} }
+
+func (u *UserUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetFirstName changes the first name of the user
+func (u *UserUpdate) SetFirstName(name string) *UserUpdate { u.set(userFirstName, name); return u }
+
+// SetLastName changes the last name of the user
+func (u *UserUpdate) SetLastName(name string) *UserUpdate { u.set(userLastName, name); return u }
+
+// SetEmail changes the email of the user
+func (u *UserUpdate) SetEmail(email string) *UserUpdate { u.set(userEmail, email); return u }
+
+// SetPassword replaces the password hash of the user
+func (u *UserUpdate) SetPassword(hash string) *UserUpdate { u.set(userPassword, hash); return u }
+
+// SetTempPassword marks whether the password must be changed on next login
+func (u *UserUpdate) SetTempPassword(temp bool) *UserUpdate {
+	u.set(userTempPassword, temp)
+	return u
+}
+
+// SetBusiness moves the user to another business
+func (u *UserUpdate) SetBusiness(business models.ID) *UserUpdate {
+	u.set(userBusiness, business)
+	return u
+}
+
+// SetUserType changes the role of the user, eg. models.TypeAdmin
+func (u *UserUpdate) SetUserType(t int) *UserUpdate { u.set(userType, t); return u }
+
+// SetUpdatedAt stamps the time of the update
+func (u *UserUpdate) SetUpdatedAt(t time.Time) *UserUpdate { u.set(userUpdatedAt, t); return u }
+
+// SetResetToken sets the hash and expiry of the forgot password token, an empty hash
+// removes it
+func (u *UserUpdate) SetResetToken(hash string, expires time.Time) *UserUpdate {
+	u.set(userResetToken, hash)
+	u.set(userResetExpires, expires)
+	return u
+}
+
+// SetSessions replaces the signed in sessions of the user
+func (u *UserUpdate) SetSessions(sessions []models.Session) *UserUpdate {
+	if sessions == nil {
+		sessions = []models.Session{} // Mongo can't push to a null array
+	}
+	u.set(userSessions, sessions)
+	return u
+}
+
+// PushSession adds a signed in session to the user
+func (u *UserUpdate) PushSession(session models.Session) *UserUpdate {
+	u.push(userSessions, session)
+	return u
+}
+
+// SetTOTP sets the two-factor secret and whether logins need it, an empty secret removes it
+func (u *UserUpdate) SetTOTP(secret string, enabled bool) *UserUpdate {
+	u.set(userTOTPSecret, secret)
+	u.set(userTOTPEnabled, enabled)
+	return u
+}
+
+// SetTOTPLastStep records the time step of the last accepted two-factor code
+func (u *UserUpdate) SetTOTPLastStep(step int64) *UserUpdate {
+	u.set(userTOTPLastStep, step)
+	return u
+}
+
+// SetRecoveryCodes replaces the hashes of the unused two-factor recovery codes
+func (u *UserUpdate) SetRecoveryCodes(hashes []string) *UserUpdate {
+	if hashes == nil {
+		hashes = []string{}
+	}
+	u.set(userRecovery, hashes)
+	return u
+}
+
+// SetAuthProvider records the single sign-on provider that manages the user
+func (u *UserUpdate) SetAuthProvider(provider string) *UserUpdate {
+	u.set(userProvider, provider)
+	return u
+}
+
+// SetDisabled stops or allows the user signing in
+func (u *UserUpdate) SetDisabled(disabled bool) *UserUpdate {
+	u.set(userDisabled, disabled)
+	return u
+}
+
+// SetFailedLogins records the failed logins in a row and the time of the last one, 0 and
+// a zero time reset them
+func (u *UserUpdate) SetFailedLogins(n int, last time.Time) *UserUpdate {
+	u.set(userFailedLogins, n)
+	u.set(userLastFailed, last)
+	return u
+}
+
+// SetLockedUntil stops the user signing in until t, a zero time unlocks them
+func (u *UserUpdate) SetLockedUntil(t time.Time) *UserUpdate {
+	u.set(userLockedUntil, t)
+	return u
+}
+
+// SetKnownDevices replaces the hashes of the browsers the user signed in with
+func (u *UserUpdate) SetKnownDevices(hashes []string) *UserUpdate {
+	if hashes == nil {
+		hashes = []string{}
+	}
+	u.set(userKnownDevices, hashes)
+	return u
+}
+
+// APIKeyUpdate modifies an API key
+type APIKeyUpdate struct{ Update }
+
+// UpdateAPIKey starts a new API key update
+func UpdateAPIKey() *APIKeyUpdate { return &APIKeyUpdate{} }
+
+func (u *APIKeyUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetLastUsed records when and from where the key was last used
+func (u *APIKeyUpdate) SetLastUsed(at time.Time, ip string) *APIKeyUpdate {
+	u.set(apiKeyLastUsedAt, at)
+	u.set(apiKeyLastUsedIP, ip)
+	return u
+}
+
+// InvitationUpdate modifies an invitation
+type InvitationUpdate struct{ Update }
+
+// UpdateInvitation starts a new invitation update
+func UpdateInvitation() *InvitationUpdate { return &InvitationUpdate{} }
+
+func (u *InvitationUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetToken replaces the signup token of the invitation, sent at sentAt and valid until expires
+func (u *InvitationUpdate) SetToken(hash string, sentAt, expires time.Time) *InvitationUpdate {
+	u.set(invitationToken, hash)
+	u.set(invitationSentAt, sentAt)
+	u.set(invitationExpires, expires)
+	return u
+}
+
+// SetAccepted marks the invitation accepted by the new user, removing its token. A zero
+// user and time make it pending again
+func (u *InvitationUpdate) SetAccepted(user models.ID, at time.Time) *InvitationUpdate {
+	status := models.InvitationAccepted
+	if user.IsZero() {
+		status = models.InvitationPending
+	}
+	u.set(invitationStatus, status)
+	u.set(invitationUser, user)
+	u.set(invitationAccepted, at)
+	return u
+}
+
+// ApprovalPolicyUpdate modifies an approval policy
+type ApprovalPolicyUpdate struct{ Update }
+
+// UpdateApprovalPolicy starts a new approval policy update
+func UpdateApprovalPolicy() *ApprovalPolicyUpdate { return &ApprovalPolicyUpdate{} }
+
+func (u *ApprovalPolicyUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetApprovers replaces the approvers of the policy, changed by a user at t
+func (u *ApprovalPolicyUpdate) SetApprovers(approvers []models.ID, by models.ID, at time.Time) *ApprovalPolicyUpdate {
+	if approvers == nil {
+		approvers = []models.ID{}
+	}
+	u.set(approvalApprovers, approvers)
+	u.set(approvalUpdatedBy, by)
+	u.set(approvalUpdatedAt, at)
+	return u
+}
+
+// BookingRequestUpdate modifies a booking request
+type BookingRequestUpdate struct{ Update }
+
+// UpdateBookingRequest starts a new booking request update
+func UpdateBookingRequest() *BookingRequestUpdate { return &BookingRequestUpdate{} }
+
+func (u *BookingRequestUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetDecision records what became of the request, by whom, when and why. The pending
+// status with a zero user and time reopens it
+func (u *BookingRequestUpdate) SetDecision(status string, by models.ID, at time.Time, reason string) *BookingRequestUpdate {
+	u.set(requestStatus, status)
+	u.set(requestDecidedBy, by)
+	u.set(requestDecidedAt, at)
+	u.set(requestReason, reason)
+	return u
+}
+
+// WaitlistUpdate modifies a waitlist entry
+type WaitlistUpdate struct{ Update }
+
+// UpdateWaitlist starts a new waitlist entry update
+func UpdateWaitlist() *WaitlistUpdate { return &WaitlistUpdate{} }
+
+func (u *WaitlistUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetStatus moves the entry to a state at t
+func (u *WaitlistUpdate) SetStatus(status string, at time.Time) *WaitlistUpdate {
+	u.set(waitlistStatus, status)
+	u.set(waitlistUpdatedAt, at)
+	return u
+}
+
+// SetOffer offers the block to the entry at t, to be accepted before expires
+func (u *WaitlistUpdate) SetOffer(at, expires time.Time) *WaitlistUpdate {
+	u.SetStatus(models.WaitlistOffered, at)
+	u.set(waitlistOfferedAt, at)
+	u.set(waitlistOfferExpires, expires)
+	return u
+}
+
+// BookingPolicyUpdate modifies a booking policy
+type BookingPolicyUpdate struct{ Update }
+
+// UpdateBookingPolicy starts a new booking policy update
+func UpdateBookingPolicy() *BookingPolicyUpdate { return &BookingPolicyUpdate{} }
+
+func (u *BookingPolicyUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetLimits replaces every limit of the policy with those of details, which says who
+// changed them and when
+func (u *BookingPolicyUpdate) SetLimits(details models.BookingPolicyDetails) *BookingPolicyUpdate {
+	u.set(policyConcurrent, details.MaxConcurrent)
+	u.set(policyPerWeek, details.MaxPerWeek)
+	u.set(policyLeadDays, details.MaxLeadDays)
+	u.set(policyNotice, details.MinNoticeMinutes)
+	u.set(policyDuration, details.MaxDurationMinutes)
+	u.set(policyUpdatedBy, details.UpdatedBy)
+	u.set(policyUpdatedAt, details.UpdatedAt)
+	return u
+}
+
+// CalendarUpdate modifies a calendar entry
+type CalendarUpdate struct{ Update }
+
+// UpdateCalendar starts a new calendar entry update
+func UpdateCalendar() *CalendarUpdate { return &CalendarUpdate{} }
+
+func (u *CalendarUpdate) update() *Update {
+	if u == nil {
+		return nil
+	}
+	return &u.Update
+}
+
+// SetEvent replaces the kind, reason and time of the entry with those of details, for
+// entries imported again from the same iCal event
+func (u *CalendarUpdate) SetEvent(details models.CalendarEntryDetails) *CalendarUpdate {
+	u.set(calendarKind, details.Kind)
+	u.set(calendarReason, details.Reason)
+	u.set(calendarStart, details.Start)
+	u.set(calendarEnd, details.End)
+	return u
+}
+
+// anySlice is a helper function converting an ID slice for an $in condition
+func anySlice(values []models.ID) []interface{} {
+	out := make([]interface{}, len(values))
+	for i, v := range values {
+		out[i] = v
+	}
+	return out
+}
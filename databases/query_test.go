@@ -0,0 +1,208 @@
+package databases
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+func TestFilterBson(t *testing.T) {
+	id, business := models.NewID(), models.NewID()
+	from := time.Date(2024, 9, 10, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 0, 1)
+
+	for _, tt := range []struct {
+		name   string
+		filter *Filter
+		want   bson.M
+	}{{
+		name:   "a nil filter matches everything",
+		filter: (*CowFilter)(nil).query(),
+		want:   bson.M{},
+	}, {
+		name:   "conditions on different fields",
+		filter: FilterCows().ID(id).Business(business).query(),
+		want:   bson.M{"_id": id, "Cow.Business": business},
+	}, {
+		name:   "any of some IDs",
+		filter: FilterDevices().IDs(id, business).query(),
+		want:   bson.M{"_id": bson.M{"$in": []interface{}{id, business}}},
+	}, {
+		name:   "a lower and an upper bound of one field",
+		filter: FilterAudit().Since(from).Until(to).query(),
+		want:   bson.M{"details.time": bson.M{"$gte": from, "$not": bson.M{"$gte": to}}},
+	}, {
+		name:   "less than also matches documents without the field",
+		filter: FilterUsers().TOTPStepBefore(42).query(),
+		want:   bson.M{"details.totplaststep": bson.M{"$not": bson.M{"$gte": int64(42)}}},
+	}, {
+		name:   "a recovery code among the codes",
+		filter: FilterUsers().RecoveryCode("hash").query(),
+		want:   bson.M{"details.recoverycodes": "hash"},
+	}, {
+		name:   "bookings overlapping a range",
+		filter: FilterCows().BookedBetween(from, to).query(),
+		want: bson.M{"Cow.Bookings": bson.M{"$elemMatch": bson.M{
+			"StartDate": bson.M{"$lt": primitive.NewDateTimeFromTime(to)},
+			"EndDate":   bson.M{"$gt": primitive.NewDateTimeFromTime(from)},
+		}}},
+	}, {
+		name:   "no booking of a block on a day",
+		filter: FilterCows().SlotFree("2", from, to).query(),
+		want: bson.M{"Cow.Bookings": bson.M{"$not": bson.M{"$elemMatch": bson.M{
+			"Block":     "2",
+			"StartDate": bson.M{"$gte": primitive.NewDateTimeFromTime(from), "$lt": primitive.NewDateTimeFromTime(to)},
+		}}}},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.filter.Bson(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Bson() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindOptions(t *testing.T) {
+	opts := (*Filter)(nil).FindOptions()
+	if !reflect.DeepEqual(opts.Sort, bson.D{{Key: "_id", Value: 1}}) || opts.Limit != nil || opts.Skip != nil {
+		t.Errorf("a nil filter sorts by %v, limit %v, skip %v, want _id without paging", opts.Sort, opts.Limit, opts.Skip)
+	}
+
+	opts = FilterCows().Limit(10).Skip(20).query().FindOptions()
+	if opts.Limit == nil || *opts.Limit != 10 || opts.Skip == nil || *opts.Skip != 20 {
+		t.Errorf("a page of cows has limit %v, skip %v, want 10, 20", opts.Limit, opts.Skip)
+	}
+
+	opts = FilterVersions().Newest().query().FindOptions()
+	if want := (bson.D{{Key: "details.version", Value: -1}}); !reflect.DeepEqual(opts.Sort, want) {
+		t.Errorf("the newest versions sort by %v, want %v", opts.Sort, want)
+	}
+}
+
+func TestUpdateBson(t *testing.T) {
+	device := models.NewID()
+	booking := models.BookDetails{ID: "b1", Block: "1"}
+	update := UpdateCow().SetName("CA-1").SetDeviceTotal(30).PushDevice(device).PushBooking(booking).PullBooking("b0")
+	want := bson.M{
+		"$set":  bson.M{"Cow.Name": "CA-1", "Cow.DeviceTotal": 30},
+		"$push": bson.M{"Cow.Devices": device, "Cow.Bookings": booking},
+		"$pull": bson.M{"Cow.Bookings": bson.M{"ID": "b0"}},
+	}
+	if got := update.update().Bson(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Bson() = %v, want %v", got, want)
+	}
+
+	if !UpdateCow().update().Empty() || !(*CowUpdate)(nil).update().Empty() {
+		t.Error("an update without changes isn't empty")
+	}
+	if got := UpdateCow().update().Bson(); len(got) != 0 {
+		t.Errorf("an empty update compiles to %v, want an empty document", got)
+	}
+	if got := UpdateUser().SetSessions(nil).update().Bson(); !reflect.DeepEqual(got, bson.M{"$set": bson.M{"details.sessions": []models.Session{}}}) {
+		t.Errorf("clearing the sessions compiles to %v, want an empty array", got)
+	}
+}
+
+func TestSQLWhere(t *testing.T) {
+	id, other := models.NewID(), models.NewID()
+	from := time.Date(2024, 9, 10, 9, 0, 0, 0, time.FixedZone("PDT", -7*60*60))
+	to := from.Add(time.Hour)
+	sqlite, postgres := newSQLStore(nil, sqliteDialect), newSQLStore(nil, postgresDialect)
+
+	for _, tt := range []struct {
+		name   string
+		filter *Filter
+		where  string
+		args   []interface{}
+	}{{
+		name:   "a nil filter",
+		filter: (*CowFilter)(nil).query(),
+	}, {
+		name:   "conditions on columns",
+		filter: FilterCows().ID(id).Collection("Laptop").query(),
+		where:  " WHERE cows.id = ? AND cows.collection = ?",
+		args:   []interface{}{id, "Laptop"},
+	}, {
+		name:   "any of some IDs",
+		filter: FilterCows().IDs(id, other).query(),
+		where:  " WHERE cows.id IN (?, ?)",
+		args:   []interface{}{id, other},
+	}, {
+		name:   "any of no IDs matches nothing",
+		filter: FilterCows().IDs().query(),
+		where:  " WHERE 1 = 0",
+	}, {
+		name:   "times are compared in UTC",
+		filter: FilterAudit().Since(from).Until(to).query(),
+		where:  " WHERE cows.recorded_at >= ? AND cows.recorded_at < ?",
+		args:   []interface{}{from.UTC(), to.UTC()},
+	}, {
+		name:   "bookings overlapping a range",
+		filter: FilterCows().BookedBetween(from, to).query(),
+		where:  " WHERE EXISTS (SELECT 1 FROM bookings WHERE bookings.cow_id = cows.id AND bookings.start_date < ? AND bookings.end_date > ?)",
+		args:   []interface{}{to.UTC(), from.UTC()},
+	}, {
+		name:   "no booking of a block on a day",
+		filter: FilterCows().SlotFree("2", from, to).query(),
+		where:  " WHERE NOT EXISTS (SELECT 1 FROM bookings WHERE bookings.cow_id = cows.id AND bookings.block = ? AND bookings.start_date >= ? AND bookings.start_date < ?)",
+		args:   []interface{}{"2", from.UTC(), to.UTC()},
+	}} {
+		t.Run(tt.name, func(t *testing.T) {
+			where, args := sqlite.where(tt.filter, "cows")
+			if where != tt.where || !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("where() = %q %v, want %q %v", where, args, tt.where, tt.args)
+			}
+		})
+	}
+
+	if got, want := postgres.rebind("SELECT id FROM cows WHERE id = ? AND name = ?"), "SELECT id FROM cows WHERE id = $1 AND name = $2"; got != want {
+		t.Errorf("rebind() = %q, want %q", got, want)
+	}
+	if got := sqlite.rebind("id = ?"); got != "id = ?" {
+		t.Errorf("rebind() on SQLite = %q, want the query unchanged", got)
+	}
+}
+
+func TestSQLPage(t *testing.T) {
+	sqlite, postgres := newSQLStore(nil, sqliteDialect), newSQLStore(nil, postgresDialect)
+	for _, tt := range []struct {
+		name   string
+		store  *sqlStore
+		filter *Filter
+		want   string
+	}{
+		{"a nil filter", sqlite, nil, " ORDER BY id"},
+		{"a page", sqlite, FilterCows().Limit(10).Skip(20).query(), " ORDER BY id LIMIT 10 OFFSET 20"},
+		{"skipping on SQLite", sqlite, FilterCows().Skip(20).query(), " ORDER BY id LIMIT -1 OFFSET 20"},
+		{"skipping on PostgreSQL", postgres, FilterCows().Skip(20).query(), " ORDER BY id LIMIT ALL OFFSET 20"},
+		{"the newest versions", sqlite, FilterVersions().Newest().Limit(1).query(), " ORDER BY version DESC LIMIT 1"},
+	} {
+		if got := tt.store.page(tt.filter); got != tt.want {
+			t.Errorf("page() of %s = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSQLSets(t *testing.T) {
+	sqlite := newSQLStore(nil, sqliteDialect)
+	locked := time.Date(2024, 9, 10, 9, 0, 0, 0, time.FixedZone("PDT", -7*60*60))
+	session := models.Session{ID: "s1"}
+	update := UpdateUser().SetEmail("teacher@school.example").SetLockedUntil(locked).PushSession(session).SetRecoveryCodes([]string{"h1"})
+
+	set, args, children := sqlite.sets(update.update())
+	if want := "email = ?, locked_until = ?"; set != want {
+		t.Errorf("sets() = %q, want %q", set, want)
+	}
+	if want := []interface{}{"teacher@school.example", locked.UTC()}; !reflect.DeepEqual(args, want) {
+		t.Errorf("sets() args = %v, want %v", args, want)
+	}
+	// sessions and recovery codes are kept in child tables, the store writes them
+	if len(children) != 2 || children[0].field != userSessions || !children[0].push || children[1].field != userRecovery {
+		t.Errorf("sets() left the changes %+v, want the session push and the recovery codes", children)
+	}
+}
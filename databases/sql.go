@@ -0,0 +1,337 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/migrations"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+// ErrUnsupportedQuery is returned by the SQL backends when an update changes a field
+// the entity does not have
+var ErrUnsupportedQuery = errors.New("unsupported query for sql backend")
+
+// sqlDialect holds the differences between the SQL databases we support
+type sqlDialect struct {
+	name      string
+	numbered  bool   // $1, $2 placeholders instead of ?
+	timestamp string // column type used for timestamps
+	unlimited string // LIMIT value meaning no limit, needed when only skipping rows
+	lockRow   string // suffix locking the selected row until the transaction ends, empty when transactions don't overlap
+
+	duplicate func(err error) bool // reports whether err is a unique constraint violation
+}
+
+type sqlStore struct {
+	db      *sql.DB
+	dialect sqlDialect
+}
+
+// newSQLStore wraps an open database/sql connection in a store
+func newSQLStore(db *sql.DB, dialect sqlDialect) *sqlStore {
+	return &sqlStore{
+		db:      db,
+		dialect: dialect,
+	}
+}
+
+func (s *sqlStore) Cows() CowDatabase {
+	return &sqlCowDatabase{s: s}
+}
+
+func (s *sqlStore) Devices() DeviceDatabase {
+	return &sqlDeviceDatabase{s: s}
+}
+
+func (s *sqlStore) Users() UserDatabase {
+	return &sqlUserDatabase{s: s}
+}
+
+func (s *sqlStore) APIKeys() APIKeyDatabase {
+	return &sqlAPIKeyDatabase{s: s}
+}
+
+func (s *sqlStore) Invitations() InvitationDatabase {
+	return &sqlInvitationDatabase{s: s}
+}
+
+func (s *sqlStore) Audit() AuditDatabase {
+	return &sqlAuditDatabase{s: s}
+}
+
+func (s *sqlStore) Versions() VersionDatabase {
+	return &sqlVersionDatabase{s: s}
+}
+
+func (s *sqlStore) ApprovalPolicies() ApprovalPolicyDatabase {
+	return &sqlApprovalPolicyDatabase{s: s}
+}
+
+func (s *sqlStore) BookingRequests() BookingRequestDatabase {
+	return &sqlBookingRequestDatabase{s: s}
+}
+
+func (s *sqlStore) Waitlist() WaitlistDatabase {
+	return &sqlWaitlistDatabase{s: s}
+}
+
+func (s *sqlStore) BookingPolicies() BookingPolicyDatabase {
+	return &sqlBookingPolicyDatabase{s: s}
+}
+
+func (s *sqlStore) Calendar() CalendarDatabase {
+	return &sqlCalendarDatabase{s: s}
+}
+
+func (s *sqlStore) Migrator() *migrations.Migrator {
+	return migrations.New(&sqlMigrationStore{s: s}, sqlMigrations(s))
+}
+
+func (s *sqlStore) Close(ctx context.Context) error {
+	return s.db.Close()
+}
+
+// rebind rewrites the ? placeholders of a query for the dialect in use
+func (s *sqlStore) rebind(query string) string {
+	if !s.dialect.numbered {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// sqlQueryer is implemented by both *sql.DB and *sql.Tx
+type sqlQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tx runs fn inside a transaction, rolling back if it returns an error
+func (s *sqlStore) tx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// exec is a helper function rebinding and executing a statement
+func (s *sqlStore) exec(ctx context.Context, q sqlQueryer, query string, args ...interface{}) (sql.Result, error) {
+	result, err := q.ExecContext(ctx, s.rebind(query), args...)
+	if err != nil && s.dialect.duplicate(err) {
+		return nil, &storeError{kind: ErrDuplicate, err: err}
+	}
+	return result, err
+}
+
+// where compiles a filter into a SQL where clause for the rows of table
+func (s *sqlStore) where(f *Filter, table string) (string, []interface{}) {
+	if f == nil || len(f.conditions) == 0 {
+		return "", nil
+	}
+
+	var clauses []string
+	var args []interface{}
+	for _, c := range f.conditions {
+		switch c.op {
+		case opEq:
+			clauses = append(clauses, table+"."+c.field.column+" = ?")
+			args = append(args, sqlValue(c.value))
+		case opLt:
+			clauses = append(clauses, table+"."+c.field.column+" < ?")
+			args = append(args, sqlValue(c.value))
+		case opGte:
+			clauses = append(clauses, table+"."+c.field.column+" >= ?")
+			args = append(args, sqlValue(c.value))
+		case opGt:
+			clauses = append(clauses, table+"."+c.field.column+" > ?")
+			args = append(args, sqlValue(c.value))
+		case opIn:
+			values := c.value.([]interface{})
+			if len(values) == 0 {
+				clauses = append(clauses, "1 = 0")
+				continue
+			}
+			placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+			clauses = append(clauses, table+"."+c.field.column+" IN ("+placeholders+")")
+			for _, v := range values {
+				args = append(args, sqlValue(v))
+			}
+		case opOverlap:
+			span := c.value.([2]time.Time)
+			clauses = append(clauses, "EXISTS (SELECT 1 FROM bookings WHERE bookings.cow_id = "+table+".id AND bookings.start_date < ? AND bookings.end_date > ?)")
+			args = append(args, sqlValue(span[1]), sqlValue(span[0]))
+		case opRecoveryCode:
+			clauses = append(clauses, "EXISTS (SELECT 1 FROM user_recovery_codes WHERE user_recovery_codes.user_id = "+table+".id AND user_recovery_codes.hash = ?)")
+			args = append(args, c.value)
+		case opSlotFree:
+			s := c.value.(slot)
+			clauses = append(clauses, "NOT EXISTS (SELECT 1 FROM bookings WHERE bookings.cow_id = "+table+".id AND bookings.block = ? AND bookings.start_date >= ? AND bookings.start_date < ?)")
+			args = append(args, s.block, sqlValue(s.span[0]), sqlValue(s.span[1]))
+		}
+	}
+	return " WHERE " + strings.Join(clauses, " AND "), args
+}
+
+// page compiles the ordering and paging of a filter. Rows are ordered by id to match the
+// Mongo backend, unless the typed filter sorts by another unique key
+func (s *sqlStore) page(f *Filter) string {
+	clause := " ORDER BY id"
+	if f != nil && f.order.column != "" {
+		clause = " ORDER BY " + f.order.column
+	}
+	if f != nil && f.descending {
+		clause += " DESC"
+	}
+	if f == nil || (f.limit == 0 && f.skip == 0) {
+		return clause
+	}
+
+	limit := s.dialect.unlimited
+	if f.limit > 0 {
+		limit = strconv.FormatInt(f.limit, 10)
+	}
+	clause += " LIMIT " + limit
+	if f.skip > 0 {
+		clause += " OFFSET " + strconv.FormatInt(f.skip, 10)
+	}
+	return clause
+}
+
+// sets compiles the column changes of an update into a SQL set clause. Changes to
+// fields stored in child tables are returned for the caller to apply
+func (s *sqlStore) sets(u *Update) (string, []interface{}, []change) {
+	if u == nil {
+		return "", nil, nil
+	}
+
+	var clauses []string
+	var args []interface{}
+	var children []change
+	for _, c := range u.changes {
+		if c.push || c.pull || c.field.column == "" {
+			children = append(children, c)
+			continue
+		}
+		clauses = append(clauses, c.field.column+" = ?")
+		args = append(args, sqlValue(c.value))
+	}
+	return strings.Join(clauses, ", "), args, children
+}
+
+// firstID is a helper function returning the id of the first row of table matching the filter
+func (s *sqlStore) firstID(ctx context.Context, q sqlQueryer, table string, f *Filter) (models.ID, error) {
+	where, args := s.where(f, table)
+	var id models.ID
+	err := q.QueryRowContext(ctx, s.rebind("SELECT id FROM "+table+where+s.page(one(nil))), args...).Scan(&id)
+	return id, err
+}
+
+// lockedID is firstID for updates. Where the dialect locks rows, the row is locked and the
+// filter checked again, as it may look at rows other transactions change until then, eg. the
+// bookings of CowFilter.SlotFree or the recovery codes of UserFilter.RecoveryCode
+func (s *sqlStore) lockedID(ctx context.Context, tx *sql.Tx, table string, f *Filter) (models.ID, error) {
+	id, err := s.firstID(ctx, tx, table, f)
+	if err != nil || s.dialect.lockRow == "" {
+		return id, err
+	}
+	if _, err := s.exec(ctx, tx, "SELECT id FROM "+table+" WHERE id = ?"+s.dialect.lockRow, id); err != nil {
+		return "", err
+	}
+	locked := one(f)
+	locked.conditions = append(append([]condition{}, locked.conditions...), condition{field: field{column: "id"}, op: opEq, value: id})
+	return s.firstID(ctx, tx, table, locked)
+}
+
+// one is a helper function returning a copy of the filter limited to a single row
+func one(f *Filter) *Filter {
+	single := Filter{limit: 1}
+	if f != nil {
+		single.conditions = f.conditions
+	}
+	return &single
+}
+
+// sqlValue is a helper function converting values into values accepted by database/sql
+func sqlValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case primitive.DateTime:
+		return value.Time().UTC()
+	case time.Time:
+		return value.UTC()
+	default:
+		return value
+	}
+}
+
+type sqlMigrationStore struct {
+	s *sqlStore
+}
+
+func (m *sqlMigrationStore) ensure(ctx context.Context) error {
+	_, err := m.s.db.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version     INTEGER PRIMARY KEY,
+		description TEXT NOT NULL,
+		applied_at  %s NOT NULL
+	)`, m.s.dialect.timestamp))
+	return err
+}
+
+func (m *sqlMigrationStore) Applied(ctx context.Context) ([]migrations.Record, error) {
+	if err := m.ensure(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.s.db.QueryContext(ctx, "SELECT version, description, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []migrations.Record
+	for rows.Next() {
+		var record migrations.Record
+		if err := rows.Scan(&record.Version, &record.Description, &record.AppliedAt); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+func (m *sqlMigrationStore) Insert(ctx context.Context, record migrations.Record) error {
+	if err := m.ensure(ctx); err != nil {
+		return err
+	}
+	_, err := m.s.exec(ctx, m.s.db, "INSERT INTO schema_migrations (version, description, applied_at) VALUES (?, ?, ?)",
+		record.Version, record.Description, record.AppliedAt)
+	return err
+}
+
+func (m *sqlMigrationStore) Delete(ctx context.Context, version int) error {
+	_, err := m.s.exec(ctx, m.s.db, "DELETE FROM schema_migrations WHERE version = ?", version)
+	return err
+}
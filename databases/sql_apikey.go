@@ -0,0 +1,110 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const apiKeySelect = "SELECT id, name, business, prefix, hash, created_by, created_at, expires_at, last_used_at, last_used_ip FROM api_keys"
+
+type sqlAPIKeyDatabase struct {
+	s *sqlStore
+}
+
+func (k *sqlAPIKeyDatabase) FindOne(ctx context.Context, filter *APIKeyFilter) (*models.APIKey, error) {
+	keys, err := k.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &keys[0], nil
+}
+
+func (k *sqlAPIKeyDatabase) Find(ctx context.Context, filter *APIKeyFilter) ([]models.APIKey, error) {
+	return k.find(ctx, filter.query())
+}
+
+func (k *sqlAPIKeyDatabase) InsertOne(ctx context.Context, key models.APIKey) (*InsertOneResult, error) {
+	d := key.Details
+	err := k.s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := k.s.exec(ctx, tx, `INSERT INTO api_keys (id, name, business, prefix, hash, created_by, created_at, expires_at, last_used_at, last_used_ip)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			key.ID, d.Name, d.Business, d.Prefix, d.Hash, d.CreatedBy, d.CreatedAt.UTC(), d.ExpiresAt.UTC(), d.LastUsedAt.UTC(), d.LastUsedIP)
+		if err != nil {
+			return err
+		}
+		for i, scope := range d.Scopes {
+			if _, err := k.s.exec(ctx, tx, "INSERT INTO api_key_scopes (api_key_id, position, scope) VALUES (?, ?, ?)", key.ID, i, scope); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: key.ID}, nil
+}
+
+func (k *sqlAPIKeyDatabase) UpdateOne(ctx context.Context, filter *APIKeyFilter, update *APIKeyUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, k.s, "api_keys", filter.query(), update.update())
+}
+
+// DeleteOne removes the first matching API key, its scopes are removed with it
+func (k *sqlAPIKeyDatabase) DeleteOne(ctx context.Context, filter *APIKeyFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, k.s, "api_keys", filter.query())
+}
+
+func (k *sqlAPIKeyDatabase) find(ctx context.Context, filter *Filter) ([]models.APIKey, error) {
+	where, args := k.s.where(filter, "api_keys")
+
+	rows, err := k.s.db.QueryContext(ctx, k.s.rebind(apiKeySelect+where+k.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		var key models.APIKey
+		d := &key.Details
+		if err := rows.Scan(&key.ID, &d.Name, &d.Business, &d.Prefix, &d.Hash, &d.CreatedBy, &d.CreatedAt, &d.ExpiresAt, &d.LastUsedAt, &d.LastUsedIP); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range keys {
+		if keys[i].Details.Scopes, err = k.scopes(ctx, keys[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// scopes is a helper function loading the scopes of an API key
+func (k *sqlAPIKeyDatabase) scopes(ctx context.Context, keyID models.ID) ([]string, error) {
+	rows, err := k.s.db.QueryContext(ctx, k.s.rebind("SELECT scope FROM api_key_scopes WHERE api_key_id = ? ORDER BY position"), keyID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scopes := []string{}
+	for rows.Next() {
+		var scope string
+		if err := rows.Scan(&scope); err != nil {
+			return nil, err
+		}
+		scopes = append(scopes, scope)
+	}
+	return scopes, rows.Err()
+}
@@ -0,0 +1,230 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const (
+	approvalPolicySelect = "SELECT id, business, updated_by, updated_at FROM approval_policies"
+	bookingRequestSelect = "SELECT id, cow_id, business, booking_id, author, block, start_date, end_date, requester, status, created_at, expires_at, decided_by, decided_at, reason FROM booking_requests"
+)
+
+type sqlApprovalPolicyDatabase struct {
+	s *sqlStore
+}
+
+func (a *sqlApprovalPolicyDatabase) FindOne(ctx context.Context, filter *ApprovalPolicyFilter) (*models.ApprovalPolicy, error) {
+	policies, err := a.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &policies[0], nil
+}
+
+func (a *sqlApprovalPolicyDatabase) Find(ctx context.Context, filter *ApprovalPolicyFilter) ([]models.ApprovalPolicy, error) {
+	return a.find(ctx, filter.query())
+}
+
+func (a *sqlApprovalPolicyDatabase) InsertOne(ctx context.Context, policy models.ApprovalPolicy) (*InsertOneResult, error) {
+	d := policy.Details
+	err := a.s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := a.s.exec(ctx, tx, "INSERT INTO approval_policies (id, business, updated_by, updated_at) VALUES (?, ?, ?, ?)",
+			policy.ID, d.Business, d.UpdatedBy, d.UpdatedAt.UTC())
+		if err != nil {
+			return err
+		}
+		return a.insertApprovers(ctx, tx, policy.ID, d.Approvers)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: policy.ID}, nil
+}
+
+func (a *sqlApprovalPolicyDatabase) UpdateOne(ctx context.Context, filter *ApprovalPolicyFilter, update *ApprovalPolicyUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	clause, setArgs, children := a.s.sets(update.update())
+
+	result := &UpdateResult{}
+	err := a.s.tx(ctx, func(tx *sql.Tx) error {
+		id, err := a.s.firstID(ctx, tx, "approval_policies", filter.query())
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.MatchedCount = 1
+
+		if clause != "" {
+			if _, err := a.s.exec(ctx, tx, "UPDATE approval_policies SET "+clause+" WHERE id = ?", append(setArgs, id)...); err != nil {
+				return err
+			}
+		}
+		for _, change := range children {
+			if change.field != approvalApprovers || change.push || change.pull {
+				return fmt.Errorf("%w: cannot change %s", ErrUnsupportedQuery, change.field.path)
+			}
+			if _, err := a.s.exec(ctx, tx, "DELETE FROM approval_policy_approvers WHERE policy_id = ?", id); err != nil {
+				return err
+			}
+			if err := a.insertApprovers(ctx, tx, id, change.value.([]models.ID)); err != nil {
+				return err
+			}
+		}
+
+		result.ModifiedCount = 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteOne removes the first matching policy, its approvers are removed with it
+func (a *sqlApprovalPolicyDatabase) DeleteOne(ctx context.Context, filter *ApprovalPolicyFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, a.s, "approval_policies", filter.query())
+}
+
+func (a *sqlApprovalPolicyDatabase) find(ctx context.Context, filter *Filter) ([]models.ApprovalPolicy, error) {
+	where, args := a.s.where(filter, "approval_policies")
+
+	rows, err := a.s.db.QueryContext(ctx, a.s.rebind(approvalPolicySelect+where+a.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []models.ApprovalPolicy
+	for rows.Next() {
+		var policy models.ApprovalPolicy
+		d := &policy.Details
+		if err := rows.Scan(&policy.ID, &d.Business, &d.UpdatedBy, &d.UpdatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range policies {
+		approvers, err := sqlIDs(ctx, a.s, "SELECT user_id FROM approval_policy_approvers WHERE policy_id = ? ORDER BY position", policies[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		policies[i].Details.Approvers = approvers
+	}
+	return policies, nil
+}
+
+func (a *sqlApprovalPolicyDatabase) insertApprovers(ctx context.Context, tx *sql.Tx, policyID models.ID, approvers []models.ID) error {
+	for i, approver := range approvers {
+		_, err := a.s.exec(ctx, tx, "INSERT INTO approval_policy_approvers (policy_id, position, user_id) VALUES (?, ?, ?)", policyID, i, approver)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type sqlBookingRequestDatabase struct {
+	s *sqlStore
+}
+
+func (b *sqlBookingRequestDatabase) FindOne(ctx context.Context, filter *BookingRequestFilter) (*models.BookingRequest, error) {
+	requests, err := b.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(requests) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &requests[0], nil
+}
+
+func (b *sqlBookingRequestDatabase) Find(ctx context.Context, filter *BookingRequestFilter) ([]models.BookingRequest, error) {
+	return b.find(ctx, filter.query())
+}
+
+func (b *sqlBookingRequestDatabase) InsertOne(ctx context.Context, request models.BookingRequest) (*InsertOneResult, error) {
+	d := request.Details
+	err := b.s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := b.s.exec(ctx, tx, `INSERT INTO booking_requests (id, cow_id, business, booking_id, author, block, start_date, end_date, requester, status, created_at, expires_at, decided_by, decided_at, reason)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			request.ID, d.Cow, d.Business, d.Booking.ID, d.Booking.Author, d.Booking.Block, d.Booking.StartDate.Time().UTC(), d.Booking.EndDate.Time().UTC(),
+			d.Requester, d.Status, d.CreatedAt.UTC(), d.ExpiresAt.UTC(), d.DecidedBy, d.DecidedAt.UTC(), d.Reason)
+		if err != nil {
+			return err
+		}
+		for i, device := range d.Booking.Devices {
+			_, err := b.s.exec(ctx, tx, "INSERT INTO booking_request_devices (request_id, position, device_id) VALUES (?, ?, ?)", request.ID, i, device)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: request.ID}, nil
+}
+
+func (b *sqlBookingRequestDatabase) UpdateOne(ctx context.Context, filter *BookingRequestFilter, update *BookingRequestUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, b.s, "booking_requests", filter.query(), update.update())
+}
+
+func (b *sqlBookingRequestDatabase) find(ctx context.Context, filter *Filter) ([]models.BookingRequest, error) {
+	where, args := b.s.where(filter, "booking_requests")
+
+	rows, err := b.s.db.QueryContext(ctx, b.s.rebind(bookingRequestSelect+where+b.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var requests []models.BookingRequest
+	for rows.Next() {
+		var request models.BookingRequest
+		var start, end time.Time
+		d := &request.Details
+		err := rows.Scan(&request.ID, &d.Cow, &d.Business, &d.Booking.ID, &d.Booking.Author, &d.Booking.Block, &start, &end,
+			&d.Requester, &d.Status, &d.CreatedAt, &d.ExpiresAt, &d.DecidedBy, &d.DecidedAt, &d.Reason)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Booking.StartDate = primitive.NewDateTimeFromTime(start)
+		d.Booking.EndDate = primitive.NewDateTimeFromTime(end)
+		d.Booking.User = d.Requester
+		requests = append(requests, request)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range requests {
+		devices, err := sqlIDs(ctx, b.s, "SELECT device_id FROM booking_request_devices WHERE request_id = ? ORDER BY position", requests[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		requests[i].Details.Booking.Devices = devices
+	}
+	return requests, nil
+}
@@ -0,0 +1,53 @@
+package databases
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const auditSelect = "SELECT id, recorded_at, actor_type, actor_id, actor_name, actor_business, business, action, entity_type, entity_id, before_snapshot, after_snapshot, request_id, ip, method, path FROM audit_log"
+
+type sqlAuditDatabase struct {
+	s *sqlStore
+}
+
+func (a *sqlAuditDatabase) Find(ctx context.Context, filter *AuditFilter) ([]models.AuditEntry, error) {
+	where, args := a.s.where(filter.query(), "audit_log")
+
+	rows, err := a.s.db.QueryContext(ctx, a.s.rebind(auditSelect+where+a.s.page(filter.query())), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.AuditEntry
+	for rows.Next() {
+		var entry models.AuditEntry
+		var before, after string
+		d := &entry.Details
+		err := rows.Scan(&entry.ID, &d.Time, &d.Actor.Type, &d.Actor.ID, &d.Actor.Name, &d.Actor.Business, &d.Business, &d.Action, &d.EntityType, &d.EntityID, &before, &after, &d.RequestID, &d.IP, &d.Method, &d.Path)
+		if err != nil {
+			return nil, err
+		}
+		if before != "" {
+			d.Before = models.Snapshot(before)
+		}
+		if after != "" {
+			d.After = models.Snapshot(after)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+func (a *sqlAuditDatabase) InsertOne(ctx context.Context, entry models.AuditEntry) (*InsertOneResult, error) {
+	d := entry.Details
+	_, err := a.s.exec(ctx, a.s.db, `INSERT INTO audit_log (id, recorded_at, actor_type, actor_id, actor_name, actor_business, business, action, entity_type, entity_id, before_snapshot, after_snapshot, request_id, ip, method, path)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, d.Time.UTC(), d.Actor.Type, d.Actor.ID, d.Actor.Name, d.Actor.Business, d.Business, d.Action, d.EntityType, d.EntityID, string(d.Before), string(d.After), d.RequestID, d.IP, d.Method, d.Path)
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: entry.ID}, nil
+}
@@ -0,0 +1,70 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const calendarSelect = "SELECT id, business, kind, reason, start_at, end_at, cow_id, device_type, uid, created_by, created_at FROM calendar_entries"
+
+type sqlCalendarDatabase struct {
+	s *sqlStore
+}
+
+func (c *sqlCalendarDatabase) FindOne(ctx context.Context, filter *CalendarFilter) (*models.CalendarEntry, error) {
+	entries, err := c.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &entries[0], nil
+}
+
+func (c *sqlCalendarDatabase) Find(ctx context.Context, filter *CalendarFilter) ([]models.CalendarEntry, error) {
+	return c.find(ctx, filter.query())
+}
+
+func (c *sqlCalendarDatabase) InsertOne(ctx context.Context, entry models.CalendarEntry) (*InsertOneResult, error) {
+	d := entry.Details
+	_, err := c.s.exec(ctx, c.s.db, `INSERT INTO calendar_entries (id, business, kind, reason, start_at, end_at, cow_id, device_type, uid, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.ID, d.Business, d.Kind, d.Reason, d.Start.UTC(), d.End.UTC(), d.Cow, d.DeviceType, d.UID, d.CreatedBy, d.CreatedAt.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: entry.ID}, nil
+}
+
+func (c *sqlCalendarDatabase) UpdateOne(ctx context.Context, filter *CalendarFilter, update *CalendarUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, c.s, "calendar_entries", filter.query(), update.update())
+}
+
+func (c *sqlCalendarDatabase) DeleteOne(ctx context.Context, filter *CalendarFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, c.s, "calendar_entries", filter.query())
+}
+
+func (c *sqlCalendarDatabase) find(ctx context.Context, filter *Filter) ([]models.CalendarEntry, error) {
+	where, args := c.s.where(filter, "calendar_entries")
+
+	rows, err := c.s.db.QueryContext(ctx, c.s.rebind(calendarSelect+where+c.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []models.CalendarEntry
+	for rows.Next() {
+		var entry models.CalendarEntry
+		d := &entry.Details
+		err := rows.Scan(&entry.ID, &d.Business, &d.Kind, &d.Reason, &d.Start, &d.End, &d.Cow, &d.DeviceType, &d.UID, &d.CreatedBy, &d.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
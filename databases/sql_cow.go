@@ -0,0 +1,267 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+type sqlCowDatabase struct {
+	s *sqlStore
+}
+
+func (c *sqlCowDatabase) FindOne(ctx context.Context, filter *CowFilter) (*models.Cow, error) {
+	cows, err := c.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(cows) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &cows[0], nil
+}
+
+func (c *sqlCowDatabase) Find(ctx context.Context, filter *CowFilter) ([]models.Cow, error) {
+	return c.find(ctx, filter.query())
+}
+
+func (c *sqlCowDatabase) InsertOne(ctx context.Context, cow models.Cow) (*InsertOneResult, error) {
+	err := c.s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := c.s.exec(ctx, tx, "INSERT INTO cows (id, name, business, collection, device_total) VALUES (?, ?, ?, ?, ?)",
+			cow.ID, cow.Details.Name, cow.Details.Business, cow.Details.Collection, cow.Details.DeviceTotal)
+		if err != nil {
+			return err
+		}
+		if err := c.insertDevices(ctx, tx, cow.ID, 0, cow.Details.Devices); err != nil {
+			return err
+		}
+		for i, booking := range cow.Details.Bookings {
+			if err := c.insertBooking(ctx, tx, cow.ID, i, booking); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: cow.ID}, nil
+}
+
+func (c *sqlCowDatabase) UpdateOne(ctx context.Context, filter *CowFilter, update *CowUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	clause, setArgs, children := c.s.sets(update.update())
+
+	result := &UpdateResult{}
+	err := c.s.tx(ctx, func(tx *sql.Tx) error {
+		id, err := c.s.lockedID(ctx, tx, "cows", filter.query())
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.MatchedCount = 1
+
+		if clause != "" {
+			if _, err := c.s.exec(ctx, tx, "UPDATE cows SET "+clause+" WHERE id = ?", append(setArgs, id)...); err != nil {
+				return err
+			}
+		}
+
+		for _, change := range children {
+			if err := c.applyChild(ctx, tx, id, change); err != nil {
+				return err
+			}
+		}
+
+		result.ModifiedCount = 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteOne removes the first matching cow, its device list and bookings are removed with it
+func (c *sqlCowDatabase) DeleteOne(ctx context.Context, filter *CowFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, c.s, "cows", filter.query())
+}
+
+// applyChild is a helper function applying a change to the devices or bookings of a cow
+func (c *sqlCowDatabase) applyChild(ctx context.Context, tx *sql.Tx, id models.ID, change change) error {
+	switch {
+	case change.field == cowDevices && change.push:
+		next, err := c.nextPosition(ctx, tx, "cow_devices", id)
+		if err != nil {
+			return err
+		}
+		return c.insertDevices(ctx, tx, id, next, []models.ID{change.value.(models.ID)})
+	case change.field == cowDevices:
+		if _, err := c.s.exec(ctx, tx, "DELETE FROM cow_devices WHERE cow_id = ?", id); err != nil {
+			return err
+		}
+		return c.insertDevices(ctx, tx, id, 0, change.value.([]models.ID))
+	case change.field == cowBookings && change.push:
+		next, err := c.nextPosition(ctx, tx, "bookings", id)
+		if err != nil {
+			return err
+		}
+		return c.insertBooking(ctx, tx, id, next, change.value.(models.BookDetails))
+	case change.field == cowBookings && change.pull:
+		// the devices of the booking are removed with it
+		_, err := c.s.exec(ctx, tx, "DELETE FROM bookings WHERE cow_id = ? AND id = ?", id, change.value.(bson.M)["ID"])
+		return err
+	case change.field == cowBookings:
+		if _, err := c.s.exec(ctx, tx, "DELETE FROM bookings WHERE cow_id = ?", id); err != nil {
+			return err
+		}
+		for i, booking := range change.value.([]models.BookDetails) {
+			if err := c.insertBooking(ctx, tx, id, i, booking); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: cannot change %s", ErrUnsupportedQuery, change.field.path)
+	}
+}
+
+// find is a helper function loading every cow matching the filter, including its devices and bookings
+func (c *sqlCowDatabase) find(ctx context.Context, filter *Filter) ([]models.Cow, error) {
+	where, args := c.s.where(filter, "cows")
+
+	rows, err := c.s.db.QueryContext(ctx, c.s.rebind("SELECT id, name, business, collection, device_total FROM cows"+where+c.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var cows []models.Cow
+	for rows.Next() {
+		var cow models.Cow
+		if err := rows.Scan(&cow.ID, &cow.Details.Name, &cow.Details.Business, &cow.Details.Collection, &cow.Details.DeviceTotal); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		cows = append(cows, cow)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range cows {
+		if err := c.loadChildren(ctx, &cows[i]); err != nil {
+			return nil, err
+		}
+	}
+	return cows, nil
+}
+
+// loadChildren is a helper function filling in the device list and bookings of a cow
+func (c *sqlCowDatabase) loadChildren(ctx context.Context, cow *models.Cow) error {
+	devices, err := c.ids(ctx, "SELECT device_id FROM cow_devices WHERE cow_id = ? ORDER BY position", cow.ID)
+	if err != nil {
+		return err
+	}
+	cow.Details.Devices = devices
+
+	rows, err := c.s.db.QueryContext(ctx, c.s.rebind("SELECT id, author, block, start_date, end_date, user_id FROM bookings WHERE cow_id = ? ORDER BY position"), cow.ID)
+	if err != nil {
+		return err
+	}
+
+	bookings := []models.BookDetails{}
+	for rows.Next() {
+		var booking models.BookDetails
+		var start, end time.Time
+		if err := rows.Scan(&booking.ID, &booking.Author, &booking.Block, &start, &end, &booking.User); err != nil {
+			rows.Close()
+			return err
+		}
+		booking.StartDate = primitive.NewDateTimeFromTime(start)
+		booking.EndDate = primitive.NewDateTimeFromTime(end)
+		bookings = append(bookings, booking)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i := range bookings {
+		devices, err := c.ids(ctx, "SELECT device_id FROM booking_devices WHERE booking_id = ? ORDER BY position", bookings[i].ID)
+		if err != nil {
+			return err
+		}
+		bookings[i].Devices = devices
+	}
+	cow.Details.Bookings = bookings
+	return nil
+}
+
+// ids is a helper function returning a single ID column for a query
+func (c *sqlCowDatabase) ids(ctx context.Context, query string, args ...interface{}) ([]models.ID, error) {
+	return sqlIDs(ctx, c.s, query, args...)
+}
+
+// sqlIDs is a helper function returning a single ID column for a query, eg. the ID list
+// of an entity kept in a child table
+func sqlIDs(ctx context.Context, s *sqlStore, query string, args ...interface{}) ([]models.ID, error) {
+	rows, err := s.db.QueryContext(ctx, s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []models.ID{}
+	for rows.Next() {
+		var value models.ID
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
+
+// nextPosition is a helper function returning the position after the last child row of a cow
+func (c *sqlCowDatabase) nextPosition(ctx context.Context, tx *sql.Tx, table string, cowID models.ID) (int, error) {
+	var next int
+	err := tx.QueryRowContext(ctx, c.s.rebind("SELECT COALESCE(MAX(position) + 1, 0) FROM "+table+" WHERE cow_id = ?"), cowID).Scan(&next)
+	return next, err
+}
+
+func (c *sqlCowDatabase) insertDevices(ctx context.Context, tx *sql.Tx, cowID models.ID, start int, devices []models.ID) error {
+	for i, device := range devices {
+		_, err := c.s.exec(ctx, tx, "INSERT INTO cow_devices (cow_id, position, device_id) VALUES (?, ?, ?)", cowID, start+i, device)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *sqlCowDatabase) insertBooking(ctx context.Context, tx *sql.Tx, cowID models.ID, position int, booking models.BookDetails) error {
+	_, err := c.s.exec(ctx, tx, "INSERT INTO bookings (id, cow_id, position, author, block, start_date, end_date, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		booking.ID, cowID, position, booking.Author, booking.Block, booking.StartDate.Time().UTC(), booking.EndDate.Time().UTC(), booking.User)
+	if err != nil {
+		return err
+	}
+	for i, device := range booking.Devices {
+		_, err := c.s.exec(ctx, tx, "INSERT INTO booking_devices (booking_id, position, device_id) VALUES (?, ?, ?)", booking.ID, i, device)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
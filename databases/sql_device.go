@@ -0,0 +1,126 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+type sqlDeviceDatabase struct {
+	s *sqlStore
+}
+
+func (d *sqlDeviceDatabase) FindOne(ctx context.Context, filter *DeviceFilter) (*models.Device, error) {
+	devices, err := d.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &devices[0], nil
+}
+
+func (d *sqlDeviceDatabase) Find(ctx context.Context, filter *DeviceFilter) ([]models.Device, error) {
+	return d.find(ctx, filter.query())
+}
+
+func (d *sqlDeviceDatabase) InsertOne(ctx context.Context, device models.Device) (*InsertOneResult, error) {
+	_, err := d.s.exec(ctx, d.s.db, "INSERT INTO devices (id, type, name, parent) VALUES (?, ?, ?, ?)",
+		device.ID, device.Details.Type, device.Details.Name, device.Details.Parent)
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: device.ID}, nil
+}
+
+func (d *sqlDeviceDatabase) UpdateOne(ctx context.Context, filter *DeviceFilter, update *DeviceUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, d.s, "devices", filter.query(), update.update())
+}
+
+func (d *sqlDeviceDatabase) DeleteOne(ctx context.Context, filter *DeviceFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, d.s, "devices", filter.query())
+}
+
+func (d *sqlDeviceDatabase) find(ctx context.Context, filter *Filter) ([]models.Device, error) {
+	where, args := d.s.where(filter, "devices")
+
+	rows, err := d.s.db.QueryContext(ctx, d.s.rebind("SELECT id, type, name, parent FROM devices"+where+d.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var devices []models.Device
+	for rows.Next() {
+		var device models.Device
+		if err := rows.Scan(&device.ID, &device.Details.Type, &device.Details.Name, &device.Details.Parent); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+	return devices, rows.Err()
+}
+
+// updateByID is a helper function applying an update to the first row of table matching the
+// filter, for tables without child rows
+func updateByID(ctx context.Context, s *sqlStore, table string, filter *Filter, update *Update) (*UpdateResult, error) {
+	if update.Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	clause, setArgs, children := s.sets(update)
+	if len(children) > 0 {
+		return nil, fmt.Errorf("%w: %s has no field %s", ErrUnsupportedQuery, table, children[0].field.path)
+	}
+
+	result := &UpdateResult{}
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		id, err := s.firstID(ctx, tx, table, filter)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.MatchedCount = 1
+
+		if _, err := s.exec(ctx, tx, "UPDATE "+table+" SET "+clause+" WHERE id = ?", append(setArgs, id)...); err != nil {
+			return err
+		}
+		result.ModifiedCount = 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// deleteByID is a helper function deleting the first row of table matching the filter.
+// Child rows are removed by the ON DELETE CASCADE of their foreign keys
+func deleteByID(ctx context.Context, s *sqlStore, table string, filter *Filter) (*DeleteResult, error) {
+	result := &DeleteResult{}
+	err := s.tx(ctx, func(tx *sql.Tx) error {
+		id, err := s.firstID(ctx, tx, table, filter)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		deleted, err := s.exec(ctx, tx, "DELETE FROM "+table+" WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		result.DeletedCount, err = deleted.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
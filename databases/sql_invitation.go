@@ -0,0 +1,70 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const invitationSelect = "SELECT id, email, first_name, last_name, business, user_type, status, token_hash, invited_by, created_at, sent_at, expires_at, accepted_at, user_id FROM invitations"
+
+type sqlInvitationDatabase struct {
+	s *sqlStore
+}
+
+func (i *sqlInvitationDatabase) FindOne(ctx context.Context, filter *InvitationFilter) (*models.Invitation, error) {
+	invitations, err := i.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(invitations) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &invitations[0], nil
+}
+
+func (i *sqlInvitationDatabase) Find(ctx context.Context, filter *InvitationFilter) ([]models.Invitation, error) {
+	return i.find(ctx, filter.query())
+}
+
+func (i *sqlInvitationDatabase) InsertOne(ctx context.Context, invitation models.Invitation) (*InsertOneResult, error) {
+	d := invitation.Details
+	_, err := i.s.exec(ctx, i.s.db, `INSERT INTO invitations (id, email, first_name, last_name, business, user_type, status, token_hash, invited_by, created_at, sent_at, expires_at, accepted_at, user_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		invitation.ID, d.Email, d.FirstName, d.LastName, d.Business, d.UserType, d.Status, d.TokenHash, d.InvitedBy, d.CreatedAt.UTC(), d.SentAt.UTC(), d.ExpiresAt.UTC(), d.AcceptedAt.UTC(), d.User)
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: invitation.ID}, nil
+}
+
+func (i *sqlInvitationDatabase) UpdateOne(ctx context.Context, filter *InvitationFilter, update *InvitationUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, i.s, "invitations", filter.query(), update.update())
+}
+
+func (i *sqlInvitationDatabase) DeleteOne(ctx context.Context, filter *InvitationFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, i.s, "invitations", filter.query())
+}
+
+func (i *sqlInvitationDatabase) find(ctx context.Context, filter *Filter) ([]models.Invitation, error) {
+	where, args := i.s.where(filter, "invitations")
+
+	rows, err := i.s.db.QueryContext(ctx, i.s.rebind(invitationSelect+where+i.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invitations []models.Invitation
+	for rows.Next() {
+		var invitation models.Invitation
+		d := &invitation.Details
+		err := rows.Scan(&invitation.ID, &d.Email, &d.FirstName, &d.LastName, &d.Business, &d.UserType, &d.Status, &d.TokenHash, &d.InvitedBy, &d.CreatedAt, &d.SentAt, &d.ExpiresAt, &d.AcceptedAt, &d.User)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+	return invitations, rows.Err()
+}
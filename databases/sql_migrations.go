@@ -0,0 +1,375 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/migrations"
+)
+
+// sqlMigrations returns every schema migration for the SQL backends, in order. New
+// migrations must be appended with the next version number and never edited once released
+func sqlMigrations(s *sqlStore) []migrations.Migration {
+	ts := s.dialect.timestamp
+
+	return []migrations.Migration{
+		s.migration(1, "initial schema", []string{
+			`CREATE TABLE cows (
+				id           TEXT PRIMARY KEY,
+				name         TEXT NOT NULL,
+				business     TEXT NOT NULL DEFAULT '',
+				collection   TEXT NOT NULL DEFAULT '',
+				device_total INTEGER NOT NULL DEFAULT 0
+			)`,
+			`CREATE UNIQUE INDEX cows_business_name ON cows (business, name)`,
+			`CREATE TABLE cow_devices (
+				cow_id    TEXT NOT NULL REFERENCES cows (id) ON DELETE CASCADE,
+				position  INTEGER NOT NULL,
+				device_id TEXT NOT NULL,
+				PRIMARY KEY (cow_id, position)
+			)`,
+			fmt.Sprintf(`CREATE TABLE bookings (
+				id         TEXT PRIMARY KEY,
+				cow_id     TEXT NOT NULL REFERENCES cows (id) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				author     TEXT NOT NULL DEFAULT '',
+				block      TEXT NOT NULL DEFAULT '',
+				start_date %s NOT NULL,
+				end_date   %s NOT NULL
+			)`, ts, ts),
+			`CREATE INDEX bookings_cow ON bookings (cow_id, position)`,
+			`CREATE TABLE booking_devices (
+				booking_id TEXT NOT NULL REFERENCES bookings (id) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				device_id  TEXT NOT NULL,
+				PRIMARY KEY (booking_id, position)
+			)`,
+			`CREATE TABLE devices (
+				id     TEXT PRIMARY KEY,
+				type   TEXT NOT NULL DEFAULT '',
+				name   TEXT NOT NULL DEFAULT '',
+				parent TEXT NOT NULL DEFAULT ''
+			)`,
+			`CREATE INDEX devices_parent ON devices (parent)`,
+			fmt.Sprintf(`CREATE TABLE users (
+				id            TEXT PRIMARY KEY,
+				first_name    TEXT NOT NULL DEFAULT '',
+				last_name     TEXT NOT NULL DEFAULT '',
+				email         TEXT NOT NULL,
+				password      TEXT NOT NULL DEFAULT '',
+				temp_password BOOLEAN NOT NULL DEFAULT FALSE,
+				uid           TEXT NOT NULL,
+				business      TEXT NOT NULL DEFAULT '',
+				user_type     INTEGER NOT NULL DEFAULT 0,
+				created_at    %s NOT NULL,
+				updated_at    %s NOT NULL
+			)`, ts, ts),
+			`CREATE UNIQUE INDEX users_uid ON users (uid)`,
+			`CREATE UNIQUE INDEX users_email ON users (email)`,
+		}, []string{
+			`DROP TABLE users`,
+			`DROP TABLE devices`,
+			`DROP TABLE booking_devices`,
+			`DROP TABLE bookings`,
+			`DROP TABLE cow_devices`,
+			`DROP TABLE cows`,
+		}),
+		s.migration(2, "password reset tokens and sessions", []string{
+			`ALTER TABLE users ADD COLUMN reset_token_hash TEXT NOT NULL DEFAULT ''`,
+			fmt.Sprintf(`ALTER TABLE users ADD COLUMN reset_token_expires %s NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'`, ts),
+			`CREATE INDEX users_reset_token ON users (reset_token_hash)`,
+			fmt.Sprintf(`CREATE TABLE user_sessions (
+				user_id         TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				position        INTEGER NOT NULL,
+				id              TEXT NOT NULL,
+				token_hash      TEXT NOT NULL,
+				password_change BOOLEAN NOT NULL DEFAULT FALSE,
+				ip              TEXT NOT NULL DEFAULT '',
+				user_agent      TEXT NOT NULL DEFAULT '',
+				created_at      %s NOT NULL,
+				expires_at      %s NOT NULL,
+				PRIMARY KEY (user_id, position)
+			)`, ts, ts),
+		}, []string{
+			`DROP TABLE user_sessions`,
+			`DROP INDEX users_reset_token`,
+			`ALTER TABLE users DROP COLUMN reset_token_expires`,
+			`ALTER TABLE users DROP COLUMN reset_token_hash`,
+		}),
+		s.migration(3, "two-factor authentication", []string{
+			`ALTER TABLE users ADD COLUMN totp_enabled BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE users ADD COLUMN totp_secret TEXT NOT NULL DEFAULT ''`,
+			`ALTER TABLE users ADD COLUMN totp_last_step BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE user_sessions ADD COLUMN two_factor_setup BOOLEAN NOT NULL DEFAULT FALSE`,
+			`ALTER TABLE user_sessions ADD COLUMN two_factor_login BOOLEAN NOT NULL DEFAULT FALSE`,
+			`CREATE TABLE user_recovery_codes (
+				user_id  TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				position INTEGER NOT NULL,
+				hash     TEXT NOT NULL,
+				PRIMARY KEY (user_id, position)
+			)`,
+		}, []string{
+			`DROP TABLE user_recovery_codes`,
+			`ALTER TABLE user_sessions DROP COLUMN two_factor_login`,
+			`ALTER TABLE user_sessions DROP COLUMN two_factor_setup`,
+			`ALTER TABLE users DROP COLUMN totp_last_step`,
+			`ALTER TABLE users DROP COLUMN totp_secret`,
+			`ALTER TABLE users DROP COLUMN totp_enabled`,
+		}),
+		s.migration(4, "single sign-on providers", []string{
+			`ALTER TABLE users ADD COLUMN auth_provider TEXT NOT NULL DEFAULT ''`,
+			`CREATE INDEX users_auth_provider ON users (auth_provider)`,
+		}, []string{
+			`DROP INDEX users_auth_provider`,
+			`ALTER TABLE users DROP COLUMN auth_provider`,
+		}),
+		s.migration(5, "disabled users", []string{
+			`ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE`,
+		}, []string{
+			`ALTER TABLE users DROP COLUMN disabled`,
+		}),
+		s.migration(6, "api keys", []string{
+			fmt.Sprintf(`CREATE TABLE api_keys (
+				id           TEXT PRIMARY KEY,
+				name         TEXT NOT NULL DEFAULT '',
+				business     TEXT NOT NULL,
+				prefix       TEXT NOT NULL,
+				hash         TEXT NOT NULL,
+				created_by   TEXT NOT NULL DEFAULT '',
+				created_at   %s NOT NULL,
+				expires_at   %s NOT NULL,
+				last_used_at %s NOT NULL,
+				last_used_ip TEXT NOT NULL DEFAULT ''
+			)`, ts, ts, ts),
+			`CREATE UNIQUE INDEX api_keys_prefix ON api_keys (prefix)`,
+			`CREATE INDEX api_keys_business ON api_keys (business)`,
+			`CREATE TABLE api_key_scopes (
+				api_key_id TEXT NOT NULL REFERENCES api_keys (id) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				scope      TEXT NOT NULL,
+				PRIMARY KEY (api_key_id, position)
+			)`,
+		}, []string{
+			`DROP TABLE api_key_scopes`,
+			`DROP TABLE api_keys`,
+		}),
+		s.migration(7, "login throttling and known devices", []string{
+			`ALTER TABLE users ADD COLUMN failed_logins INTEGER NOT NULL DEFAULT 0`,
+			fmt.Sprintf(`ALTER TABLE users ADD COLUMN last_failed_login %s NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'`, ts),
+			fmt.Sprintf(`ALTER TABLE users ADD COLUMN locked_until %s NOT NULL DEFAULT '0001-01-01 00:00:00+00:00'`, ts),
+			`CREATE TABLE user_known_devices (
+				user_id  TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				position INTEGER NOT NULL,
+				hash     TEXT NOT NULL,
+				PRIMARY KEY (user_id, position)
+			)`,
+		}, []string{
+			`DROP TABLE user_known_devices`,
+			`ALTER TABLE users DROP COLUMN locked_until`,
+			`ALTER TABLE users DROP COLUMN last_failed_login`,
+			`ALTER TABLE users DROP COLUMN failed_logins`,
+		}),
+		s.migration(8, "invitations", []string{
+			fmt.Sprintf(`CREATE TABLE invitations (
+				id          TEXT PRIMARY KEY,
+				email       TEXT NOT NULL,
+				first_name  TEXT NOT NULL DEFAULT '',
+				last_name   TEXT NOT NULL DEFAULT '',
+				business    TEXT NOT NULL DEFAULT '',
+				user_type   INTEGER NOT NULL,
+				status      TEXT NOT NULL,
+				token_hash  TEXT NOT NULL DEFAULT '',
+				invited_by  TEXT NOT NULL DEFAULT '',
+				created_at  %s NOT NULL,
+				sent_at     %s NOT NULL,
+				expires_at  %s NOT NULL,
+				accepted_at %s NOT NULL,
+				user_id     TEXT NOT NULL DEFAULT ''
+			)`, ts, ts, ts, ts),
+			`CREATE INDEX invitations_token_hash ON invitations (token_hash)`,
+			`CREATE INDEX invitations_business_email ON invitations (business, email)`,
+		}, []string{
+			`DROP TABLE invitations`,
+		}),
+		s.migration(9, "audit log", []string{
+			fmt.Sprintf(`CREATE TABLE audit_log (
+				id              TEXT PRIMARY KEY,
+				recorded_at     %s NOT NULL,
+				actor_type      TEXT NOT NULL,
+				actor_id        TEXT NOT NULL DEFAULT '',
+				actor_name      TEXT NOT NULL DEFAULT '',
+				actor_business  TEXT NOT NULL DEFAULT '',
+				business        TEXT NOT NULL DEFAULT '',
+				action          TEXT NOT NULL,
+				entity_type     TEXT NOT NULL,
+				entity_id       TEXT NOT NULL,
+				before_snapshot TEXT NOT NULL DEFAULT '',
+				after_snapshot  TEXT NOT NULL DEFAULT '',
+				request_id      TEXT NOT NULL DEFAULT '',
+				ip              TEXT NOT NULL DEFAULT '',
+				method          TEXT NOT NULL DEFAULT '',
+				path            TEXT NOT NULL DEFAULT ''
+			)`, ts),
+			`CREATE INDEX audit_log_business_time ON audit_log (business, recorded_at)`,
+			`CREATE INDEX audit_log_entity ON audit_log (entity_type, entity_id)`,
+		}, []string{
+			`DROP TABLE audit_log`,
+		}),
+		s.migration(10, "versions", []string{
+			fmt.Sprintf(`CREATE TABLE versions (
+				id             TEXT PRIMARY KEY,
+				entity_type    TEXT NOT NULL,
+				entity_id      TEXT NOT NULL,
+				version        INTEGER NOT NULL,
+				recorded_at    %s NOT NULL,
+				actor_type     TEXT NOT NULL,
+				actor_id       TEXT NOT NULL DEFAULT '',
+				actor_name     TEXT NOT NULL DEFAULT '',
+				actor_business TEXT NOT NULL DEFAULT '',
+				business       TEXT NOT NULL DEFAULT '',
+				action         TEXT NOT NULL,
+				restored       INTEGER NOT NULL DEFAULT 0,
+				request_id     TEXT NOT NULL DEFAULT '',
+				snapshot       TEXT NOT NULL
+			)`, ts),
+			`CREATE UNIQUE INDEX versions_entity_version ON versions (entity_type, entity_id, version)`,
+		}, []string{
+			`DROP TABLE versions`,
+		}),
+		s.migration(11, "booking approvals", []string{
+			fmt.Sprintf(`CREATE TABLE approval_policies (
+				id         TEXT PRIMARY KEY REFERENCES cows (id) ON DELETE CASCADE,
+				business   TEXT NOT NULL DEFAULT '',
+				updated_by TEXT NOT NULL DEFAULT '',
+				updated_at %s NOT NULL
+			)`, ts),
+			`CREATE TABLE approval_policy_approvers (
+				policy_id TEXT NOT NULL REFERENCES approval_policies (id) ON DELETE CASCADE,
+				position  INTEGER NOT NULL,
+				user_id   TEXT NOT NULL,
+				PRIMARY KEY (policy_id, position)
+			)`,
+			fmt.Sprintf(`CREATE TABLE booking_requests (
+				id         TEXT PRIMARY KEY,
+				cow_id     TEXT NOT NULL,
+				business   TEXT NOT NULL DEFAULT '',
+				booking_id TEXT NOT NULL,
+				author     TEXT NOT NULL,
+				block      TEXT NOT NULL,
+				start_date %s NOT NULL,
+				end_date   %s NOT NULL,
+				requester  TEXT NOT NULL DEFAULT '',
+				status     TEXT NOT NULL,
+				created_at %s NOT NULL,
+				expires_at %s NOT NULL,
+				decided_by TEXT NOT NULL DEFAULT '',
+				decided_at %s NOT NULL,
+				reason     TEXT NOT NULL DEFAULT ''
+			)`, ts, ts, ts, ts, ts),
+			`CREATE INDEX booking_requests_status_expires ON booking_requests (status, expires_at)`,
+			`CREATE INDEX booking_requests_business ON booking_requests (business)`,
+			`CREATE TABLE booking_request_devices (
+				request_id TEXT NOT NULL REFERENCES booking_requests (id) ON DELETE CASCADE,
+				position   INTEGER NOT NULL,
+				device_id  TEXT NOT NULL,
+				PRIMARY KEY (request_id, position)
+			)`,
+		}, []string{
+			`DROP TABLE booking_request_devices`,
+			`DROP TABLE booking_requests`,
+			`DROP TABLE approval_policy_approvers`,
+			`DROP TABLE approval_policies`,
+		}),
+		s.migration(12, "booking waitlist", []string{
+			fmt.Sprintf(`CREATE TABLE waitlist (
+				id               TEXT PRIMARY KEY,
+				cow_id           TEXT NOT NULL,
+				business         TEXT NOT NULL DEFAULT '',
+				slot_date        TEXT NOT NULL,
+				block            TEXT NOT NULL,
+				user_id          TEXT NOT NULL,
+				booking_author   TEXT NOT NULL,
+				booking_start    %s NOT NULL,
+				booking_end      %s NOT NULL,
+				status           TEXT NOT NULL,
+				created_at       %s NOT NULL,
+				offered_at       %s NOT NULL,
+				offer_expires_at %s NOT NULL,
+				updated_at       %s NOT NULL
+			)`, ts, ts, ts, ts, ts, ts),
+			`CREATE INDEX waitlist_slot ON waitlist (cow_id, slot_date, block, status)`,
+			`CREATE INDEX waitlist_user ON waitlist (user_id, status)`,
+			`CREATE TABLE waitlist_devices (
+				entry_id  TEXT NOT NULL REFERENCES waitlist (id) ON DELETE CASCADE,
+				position  INTEGER NOT NULL,
+				device_id TEXT NOT NULL,
+				PRIMARY KEY (entry_id, position)
+			)`,
+		}, []string{
+			`DROP TABLE waitlist_devices`,
+			`DROP TABLE waitlist`,
+		}),
+		s.migration(13, "booking policies", []string{
+			fmt.Sprintf(`CREATE TABLE booking_policies (
+				id                   TEXT PRIMARY KEY,
+				business             TEXT NOT NULL DEFAULT '',
+				user_type            INTEGER NOT NULL DEFAULT 0,
+				max_concurrent       INTEGER NOT NULL DEFAULT 0,
+				max_per_week         INTEGER NOT NULL DEFAULT 0,
+				max_lead_days        INTEGER NOT NULL DEFAULT 0,
+				min_notice_minutes   INTEGER NOT NULL DEFAULT 0,
+				max_duration_minutes INTEGER NOT NULL DEFAULT 0,
+				updated_by           TEXT NOT NULL DEFAULT '',
+				updated_at           %s NOT NULL
+			)`, ts),
+			`CREATE UNIQUE INDEX booking_policies_business_type ON booking_policies (business, user_type)`,
+			`ALTER TABLE bookings ADD COLUMN user_id TEXT NOT NULL DEFAULT ''`,
+		}, []string{
+			`ALTER TABLE bookings DROP COLUMN user_id`,
+			`DROP TABLE booking_policies`,
+		}),
+		s.migration(14, "closure and blackout calendar", []string{
+			fmt.Sprintf(`CREATE TABLE calendar_entries (
+				id          TEXT PRIMARY KEY,
+				business    TEXT NOT NULL DEFAULT '',
+				kind        TEXT NOT NULL DEFAULT '',
+				reason      TEXT NOT NULL DEFAULT '',
+				start_at    %s NOT NULL,
+				end_at      %s NOT NULL,
+				cow_id      TEXT NOT NULL DEFAULT '',
+				device_type TEXT NOT NULL DEFAULT '',
+				uid         TEXT NOT NULL DEFAULT '',
+				created_by  TEXT NOT NULL DEFAULT '',
+				created_at  %s NOT NULL
+			)`, ts, ts, ts),
+			`CREATE INDEX calendar_entries_business_end ON calendar_entries (business, end_at)`,
+			`CREATE INDEX calendar_entries_business_uid ON calendar_entries (business, uid)`,
+		}, []string{
+			`DROP TABLE calendar_entries`,
+		}),
+	}
+}
+
+// migration is a helper function building a migration that runs each statement in a transaction
+func (s *sqlStore) migration(version int, description string, up, down []string) migrations.Migration {
+	run := func(statements []string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			return s.tx(ctx, func(tx *sql.Tx) error {
+				for _, statement := range statements {
+					if _, err := tx.ExecContext(ctx, statement); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+	}
+
+	return migrations.Migration{
+		Version:     version,
+		Description: description,
+		Up:          run(up),
+		Down:        run(down),
+	}
+}
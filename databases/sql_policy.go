@@ -0,0 +1,70 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const bookingPolicySelect = "SELECT id, business, user_type, max_concurrent, max_per_week, max_lead_days, min_notice_minutes, max_duration_minutes, updated_by, updated_at FROM booking_policies"
+
+type sqlBookingPolicyDatabase struct {
+	s *sqlStore
+}
+
+func (b *sqlBookingPolicyDatabase) FindOne(ctx context.Context, filter *BookingPolicyFilter) (*models.BookingPolicy, error) {
+	policies, err := b.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &policies[0], nil
+}
+
+func (b *sqlBookingPolicyDatabase) Find(ctx context.Context, filter *BookingPolicyFilter) ([]models.BookingPolicy, error) {
+	return b.find(ctx, filter.query())
+}
+
+func (b *sqlBookingPolicyDatabase) InsertOne(ctx context.Context, policy models.BookingPolicy) (*InsertOneResult, error) {
+	d := policy.Details
+	_, err := b.s.exec(ctx, b.s.db, `INSERT INTO booking_policies (id, business, user_type, max_concurrent, max_per_week, max_lead_days, min_notice_minutes, max_duration_minutes, updated_by, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		policy.ID, d.Business, d.UserType, d.MaxConcurrent, d.MaxPerWeek, d.MaxLeadDays, d.MinNoticeMinutes, d.MaxDurationMinutes, d.UpdatedBy, d.UpdatedAt.UTC())
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: policy.ID}, nil
+}
+
+func (b *sqlBookingPolicyDatabase) UpdateOne(ctx context.Context, filter *BookingPolicyFilter, update *BookingPolicyUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, b.s, "booking_policies", filter.query(), update.update())
+}
+
+func (b *sqlBookingPolicyDatabase) DeleteOne(ctx context.Context, filter *BookingPolicyFilter) (*DeleteResult, error) {
+	return deleteByID(ctx, b.s, "booking_policies", filter.query())
+}
+
+func (b *sqlBookingPolicyDatabase) find(ctx context.Context, filter *Filter) ([]models.BookingPolicy, error) {
+	where, args := b.s.where(filter, "booking_policies")
+
+	rows, err := b.s.db.QueryContext(ctx, b.s.rebind(bookingPolicySelect+where+b.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var policies []models.BookingPolicy
+	for rows.Next() {
+		var policy models.BookingPolicy
+		d := &policy.Details
+		err := rows.Scan(&policy.ID, &d.Business, &d.UserType, &d.MaxConcurrent, &d.MaxPerWeek, &d.MaxLeadDays, &d.MinNoticeMinutes, &d.MaxDurationMinutes, &d.UpdatedBy, &d.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+	return policies, rows.Err()
+}
@@ -0,0 +1,231 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const userSelect = "SELECT id, first_name, last_name, email, password, temp_password, uid, business, user_type, created_at, updated_at, reset_token_hash, reset_token_expires, totp_enabled, totp_secret, totp_last_step, auth_provider, disabled, failed_logins, last_failed_login, locked_until FROM users"
+
+type sqlUserDatabase struct {
+	s *sqlStore
+}
+
+func (u *sqlUserDatabase) FindOne(ctx context.Context, filter *UserFilter) (*models.User, error) {
+	users, err := u.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &users[0], nil
+}
+
+func (u *sqlUserDatabase) Find(ctx context.Context, filter *UserFilter) ([]models.User, error) {
+	return u.find(ctx, filter.query())
+}
+
+func (u *sqlUserDatabase) InsertOne(ctx context.Context, user models.User) (*InsertOneResult, error) {
+	d := user.Details
+	err := u.s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := u.s.exec(ctx, tx, `INSERT INTO users (id, first_name, last_name, email, password, temp_password, uid, business, user_type, created_at, updated_at, reset_token_hash, reset_token_expires, totp_enabled, totp_secret, totp_last_step, auth_provider, disabled, failed_logins, last_failed_login, locked_until)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			user.ID, d.FirstName, d.LastName, d.Email, d.Password, d.TempPassword, d.UID, d.Business, d.UserType, d.Created_at.UTC(), d.Updated_at.UTC(), d.ResetTokenHash, d.ResetTokenExpires.UTC(), d.TOTPEnabled, d.TOTPSecret, d.TOTPLastStep, d.AuthProvider, d.Disabled, d.FailedLogins, d.LastFailedLogin.UTC(), d.LockedUntil.UTC())
+		if err != nil {
+			return err
+		}
+		for i, session := range d.Sessions {
+			if err := u.insertSession(ctx, tx, user.ID, i, session); err != nil {
+				return err
+			}
+		}
+		if err := u.insertRecoveryCodes(ctx, tx, user.ID, d.RecoveryCodes); err != nil {
+			return err
+		}
+		return u.insertKnownDevices(ctx, tx, user.ID, d.KnownDevices)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: user.ID}, nil
+}
+
+func (u *sqlUserDatabase) UpdateOne(ctx context.Context, filter *UserFilter, update *UserUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	clause, setArgs, children := u.s.sets(update.update())
+
+	result := &UpdateResult{}
+	err := u.s.tx(ctx, func(tx *sql.Tx) error {
+		id, err := u.s.lockedID(ctx, tx, "users", filter.query())
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		result.MatchedCount = 1
+
+		if clause != "" {
+			if _, err := u.s.exec(ctx, tx, "UPDATE users SET "+clause+" WHERE id = ?", append(setArgs, id)...); err != nil {
+				return err
+			}
+		}
+
+		for _, change := range children {
+			if err := u.applyChild(ctx, tx, id, change); err != nil {
+				return err
+			}
+		}
+
+		result.ModifiedCount = 1
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyChild is a helper function applying a change to the sessions, recovery codes or
+// known devices of a user
+func (u *sqlUserDatabase) applyChild(ctx context.Context, tx *sql.Tx, id models.ID, change change) error {
+	switch {
+	case change.field == userSessions && change.push:
+		var next int
+		err := tx.QueryRowContext(ctx, u.s.rebind("SELECT COALESCE(MAX(position) + 1, 0) FROM user_sessions WHERE user_id = ?"), id).Scan(&next)
+		if err != nil {
+			return err
+		}
+		return u.insertSession(ctx, tx, id, next, change.value.(models.Session))
+	case change.field == userSessions:
+		if _, err := u.s.exec(ctx, tx, "DELETE FROM user_sessions WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		for i, session := range change.value.([]models.Session) {
+			if err := u.insertSession(ctx, tx, id, i, session); err != nil {
+				return err
+			}
+		}
+		return nil
+	case change.field == userRecovery && !change.push:
+		if _, err := u.s.exec(ctx, tx, "DELETE FROM user_recovery_codes WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		return u.insertRecoveryCodes(ctx, tx, id, change.value.([]string))
+	case change.field == userKnownDevices && !change.push:
+		if _, err := u.s.exec(ctx, tx, "DELETE FROM user_known_devices WHERE user_id = ?", id); err != nil {
+			return err
+		}
+		return u.insertKnownDevices(ctx, tx, id, change.value.([]string))
+	default:
+		return fmt.Errorf("%w: cannot change %s", ErrUnsupportedQuery, change.field.path)
+	}
+}
+
+func (u *sqlUserDatabase) find(ctx context.Context, filter *Filter) ([]models.User, error) {
+	where, args := u.s.where(filter, "users")
+
+	rows, err := u.s.db.QueryContext(ctx, u.s.rebind(userSelect+where+u.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		d := &user.Details
+		err := rows.Scan(&user.ID, &d.FirstName, &d.LastName, &d.Email, &d.Password, &d.TempPassword, &d.UID, &d.Business, &d.UserType, &d.Created_at, &d.Updated_at, &d.ResetTokenHash, &d.ResetTokenExpires, &d.TOTPEnabled, &d.TOTPSecret, &d.TOTPLastStep, &d.AuthProvider, &d.Disabled, &d.FailedLogins, &d.LastFailedLogin, &d.LockedUntil)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range users {
+		if users[i].Details.Sessions, err = u.sessions(ctx, users[i].ID); err != nil {
+			return nil, err
+		}
+		if users[i].Details.RecoveryCodes, err = u.hashes(ctx, "user_recovery_codes", users[i].ID); err != nil {
+			return nil, err
+		}
+		if users[i].Details.KnownDevices, err = u.hashes(ctx, "user_known_devices", users[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// sessions is a helper function loading the signed in sessions of a user
+func (u *sqlUserDatabase) sessions(ctx context.Context, userID models.ID) ([]models.Session, error) {
+	rows, err := u.s.db.QueryContext(ctx, u.s.rebind("SELECT id, token_hash, password_change, two_factor_setup, two_factor_login, ip, user_agent, created_at, expires_at FROM user_sessions WHERE user_id = ? ORDER BY position"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.TokenHash, &session.PasswordChange, &session.TwoFactorSetup, &session.TwoFactorLogin, &session.IP, &session.UserAgent, &session.CreatedAt, &session.ExpiresAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+func (u *sqlUserDatabase) insertSession(ctx context.Context, tx *sql.Tx, userID models.ID, position int, session models.Session) error {
+	_, err := u.s.exec(ctx, tx, "INSERT INTO user_sessions (user_id, position, id, token_hash, password_change, two_factor_setup, two_factor_login, ip, user_agent, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, position, session.ID, session.TokenHash, session.PasswordChange, session.TwoFactorSetup, session.TwoFactorLogin, session.IP, session.UserAgent, session.CreatedAt.UTC(), session.ExpiresAt.UTC())
+	return err
+}
+
+// hashes is a helper function loading the hashes a user has in table, ie. the unused
+// recovery codes or the known devices
+func (u *sqlUserDatabase) hashes(ctx context.Context, table string, userID models.ID) ([]string, error) {
+	rows, err := u.s.db.QueryContext(ctx, u.s.rebind("SELECT hash FROM "+table+" WHERE user_id = ? ORDER BY position"), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
+
+func (u *sqlUserDatabase) insertRecoveryCodes(ctx context.Context, tx *sql.Tx, userID models.ID, hashes []string) error {
+	for i, hash := range hashes {
+		if _, err := u.s.exec(ctx, tx, "INSERT INTO user_recovery_codes (user_id, position, hash) VALUES (?, ?, ?)", userID, i, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (u *sqlUserDatabase) insertKnownDevices(ctx context.Context, tx *sql.Tx, userID models.ID, hashes []string) error {
+	for i, hash := range hashes {
+		if _, err := u.s.exec(ctx, tx, "INSERT INTO user_known_devices (user_id, position, hash) VALUES (?, ?, ?)", userID, i, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const versionSelect = "SELECT id, entity_type, entity_id, version, recorded_at, actor_type, actor_id, actor_name, actor_business, business, action, restored, request_id, snapshot FROM versions"
+
+type sqlVersionDatabase struct {
+	s *sqlStore
+}
+
+func (v *sqlVersionDatabase) FindOne(ctx context.Context, filter *VersionFilter) (*models.Version, error) {
+	versions, err := v.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &versions[0], nil
+}
+
+func (v *sqlVersionDatabase) Find(ctx context.Context, filter *VersionFilter) ([]models.Version, error) {
+	return v.find(ctx, filter.query())
+}
+
+func (v *sqlVersionDatabase) find(ctx context.Context, filter *Filter) ([]models.Version, error) {
+	where, args := v.s.where(filter, "versions")
+
+	rows, err := v.s.db.QueryContext(ctx, v.s.rebind(versionSelect+where+v.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []models.Version
+	for rows.Next() {
+		var version models.Version
+		var snapshot string
+		d := &version.Details
+		err := rows.Scan(&version.ID, &d.EntityType, &d.EntityID, &d.Version, &d.Time, &d.Actor.Type, &d.Actor.ID, &d.Actor.Name, &d.Actor.Business, &d.Business, &d.Action, &d.Restored, &d.RequestID, &snapshot)
+		if err != nil {
+			return nil, err
+		}
+		d.Snapshot = models.Snapshot(snapshot)
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+func (v *sqlVersionDatabase) InsertOne(ctx context.Context, version models.Version) (*InsertOneResult, error) {
+	d := version.Details
+	_, err := v.s.exec(ctx, v.s.db, `INSERT INTO versions (id, entity_type, entity_id, version, recorded_at, actor_type, actor_id, actor_name, actor_business, business, action, restored, request_id, snapshot)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		version.ID, d.EntityType, d.EntityID, d.Version, d.Time.UTC(), d.Actor.Type, d.Actor.ID, d.Actor.Name, d.Actor.Business, d.Business, d.Action, d.Restored, d.RequestID, string(d.Snapshot))
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: version.ID}, nil
+}
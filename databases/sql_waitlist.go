@@ -0,0 +1,99 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const waitlistSelect = "SELECT id, cow_id, business, slot_date, block, user_id, booking_author, booking_start, booking_end, status, created_at, offered_at, offer_expires_at, updated_at FROM waitlist"
+
+type sqlWaitlistDatabase struct {
+	s *sqlStore
+}
+
+func (w *sqlWaitlistDatabase) FindOne(ctx context.Context, filter *WaitlistFilter) (*models.WaitlistEntry, error) {
+	entries, err := w.find(ctx, one(filter.query()))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, &storeError{kind: ErrNotFound, err: sql.ErrNoRows}
+	}
+	return &entries[0], nil
+}
+
+func (w *sqlWaitlistDatabase) Find(ctx context.Context, filter *WaitlistFilter) ([]models.WaitlistEntry, error) {
+	return w.find(ctx, filter.query())
+}
+
+func (w *sqlWaitlistDatabase) InsertOne(ctx context.Context, entry models.WaitlistEntry) (*InsertOneResult, error) {
+	d := entry.Details
+	err := w.s.tx(ctx, func(tx *sql.Tx) error {
+		_, err := w.s.exec(ctx, tx, `INSERT INTO waitlist (id, cow_id, business, slot_date, block, user_id, booking_author, booking_start, booking_end, status, created_at, offered_at, offer_expires_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			entry.ID, d.Cow, d.Business, d.Date, d.Block, d.User, d.Booking.Author, d.Booking.StartDate.Time().UTC(), d.Booking.EndDate.Time().UTC(),
+			d.Status, d.CreatedAt.UTC(), d.OfferedAt.UTC(), d.OfferExpiresAt.UTC(), d.UpdatedAt.UTC())
+		if err != nil {
+			return err
+		}
+		for i, device := range d.Booking.Devices {
+			_, err := w.s.exec(ctx, tx, "INSERT INTO waitlist_devices (entry_id, position, device_id) VALUES (?, ?, ?)", entry.ID, i, device)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &InsertOneResult{InsertedID: entry.ID}, nil
+}
+
+func (w *sqlWaitlistDatabase) UpdateOne(ctx context.Context, filter *WaitlistFilter, update *WaitlistUpdate) (*UpdateResult, error) {
+	return updateByID(ctx, w.s, "waitlist", filter.query(), update.update())
+}
+
+func (w *sqlWaitlistDatabase) find(ctx context.Context, filter *Filter) ([]models.WaitlistEntry, error) {
+	where, args := w.s.where(filter, "waitlist")
+
+	rows, err := w.s.db.QueryContext(ctx, w.s.rebind(waitlistSelect+where+w.s.page(filter)), args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []models.WaitlistEntry
+	for rows.Next() {
+		var entry models.WaitlistEntry
+		var start, end time.Time
+		d := &entry.Details
+		err := rows.Scan(&entry.ID, &d.Cow, &d.Business, &d.Date, &d.Block, &d.User, &d.Booking.Author, &start, &end,
+			&d.Status, &d.CreatedAt, &d.OfferedAt, &d.OfferExpiresAt, &d.UpdatedAt)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		d.Booking.Block, d.Booking.User = d.Block, d.User
+		d.Booking.StartDate = primitive.NewDateTimeFromTime(start)
+		d.Booking.EndDate = primitive.NewDateTimeFromTime(end)
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range entries {
+		devices, err := sqlIDs(ctx, w.s, "SELECT device_id FROM waitlist_devices WHERE entry_id = ? ORDER BY position", entries[i].ID)
+		if err != nil {
+			return nil, err
+		}
+		entries[i].Details.Booking.Devices = devices
+	}
+	return entries, nil
+}
@@ -0,0 +1,139 @@
+package databases
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"modernc.org/sqlite" // also registers the pure Go sqlite driver
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+var sqliteDialect = sqlDialect{name: "sqlite", numbered: false, timestamp: "DATETIME", unlimited: "-1", duplicate: sqliteDuplicate}
+
+// Backuper is implemented by stores that can write a consistent copy of themselves
+// to a file while the API keeps serving requests
+type Backuper interface {
+	Backup(ctx context.Context, path string) error
+}
+
+type sqliteStore struct {
+	*sqlStore
+}
+
+// NewSQLiteStore opens (creating if needed) the embedded database file at path. Use
+// ":memory:" for a throwaway in-memory database
+func NewSQLiteStore(path string) (Store, error) {
+	dsn := path
+	if path != ":memory:" && !strings.HasPrefix(path, "file:") {
+		dsn = "file:" + path
+	}
+
+	pragmas := url.Values{}
+	pragmas.Add("_pragma", "foreign_keys(1)")
+	pragmas.Add("_pragma", "busy_timeout(5000)")
+	if path != ":memory:" {
+		pragmas.Add("_pragma", "journal_mode(WAL)")
+	}
+	separator := "?"
+	if strings.Contains(dsn, "?") {
+		separator = "&"
+	}
+
+	db, err := sql.Open("sqlite", dsn+separator+pragmas.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite only allows a single writer, and every connection to ":memory:" is a
+	// separate database, so everything goes through one connection
+	db.SetMaxOpenConns(1)
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &sqliteStore{sqlStore: newSQLStore(db, sqliteDialect)}, nil
+}
+
+// Backup writes a consistent snapshot of the database to path without blocking readers
+func (s *sqliteStore) Backup(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return errors.New("backup file already exists")
+	}
+	_, err := s.db.ExecContext(ctx, "VACUUM INTO ?", path)
+	return err
+}
+
+// sqliteDuplicate reports whether err is a unique or primary key constraint violation
+func sqliteDuplicate(err error) bool {
+	var sqliteErr *sqlite.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+}
+
+// RestoreSQLite replaces the database file at path with a copy of backup. The backup is
+// checked before anything is replaced, and nothing may have the database open
+func RestoreSQLite(ctx context.Context, backup, path string) error {
+	if path == ":memory:" || strings.HasPrefix(path, "file:") {
+		return errors.New("restoring needs DB_URI to be the path of the database file")
+	}
+
+	db, err := sql.Open("sqlite", "file:"+backup+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	var result string
+	err = db.QueryRowContext(ctx, "PRAGMA integrity_check").Scan(&result)
+	db.Close()
+	if err != nil {
+		return fmt.Errorf("%s is not a readable database: %w", backup, err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("%s failed the integrity check: %s", backup, result)
+	}
+
+	// copy next to the database and rename it into place, so a failed copy leaves the
+	// database untouched
+	tmp := path + ".restore"
+	if err := copyFile(backup, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	return os.Rename(tmp, path)
+}
+
+// copyFile is a helper function copying src to dst and syncing it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,36 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/databases/conformance"
+)
+
+// TestConformance runs the shared store tests on a freshly migrated database file
+func TestConformance(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	store, err := databases.NewSQLiteStore(filepath.Join(t.TempDir(), "devicebooking.db"))
+	if err != nil {
+		t.Fatalf("NewSQLiteStore: %v", err)
+	}
+	defer store.Close(ctx)
+
+	if _, err := store.Migrator().Up(ctx, 0); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	conformance.Run(t, store)
+
+	// every migration must also roll back, and apply again on the emptied database
+	if _, err := store.Migrator().Down(ctx, 0); err != nil {
+		t.Fatalf("migrate down: %v", err)
+	}
+	if _, err := store.Migrator().Up(ctx, 0); err != nil {
+		t.Fatalf("migrate up again: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package databases
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/migrations"
+)
+
+// Storage backends that can be selected with config.Config.Backend
+const (
+	BackendMongo    = "mongo"
+	BackendPostgres = "postgres"
+	BackendSQLite   = "sqlite"
+)
+
+// Store bundles the entity databases of a single storage backend so the handlers
+// don't need to know which backend is in use
+type Store interface {
+	Cows() CowDatabase
+	Devices() DeviceDatabase
+	Users() UserDatabase
+	APIKeys() APIKeyDatabase
+	Invitations() InvitationDatabase
+	Audit() AuditDatabase
+	Versions() VersionDatabase
+	ApprovalPolicies() ApprovalPolicyDatabase
+	BookingRequests() BookingRequestDatabase
+	Waitlist() WaitlistDatabase
+	BookingPolicies() BookingPolicyDatabase
+	Calendar() CalendarDatabase
+	Migrator() *migrations.Migrator
+	Close(ctx context.Context) error
+}
+
+// Open connects to the backend selected in the config and returns its store
+func Open(conf *config.Config) (Store, error) {
+	switch conf.Backend {
+	case BackendMongo, "":
+		client, err := NewClient(conf)
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Connect(); err != nil {
+			return nil, err
+		}
+		return NewMongoStore(client, NewDatabase(conf, client)), nil
+	case BackendPostgres:
+		return NewPostgresStore(conf.URL)
+	case BackendSQLite:
+		return NewSQLiteStore(conf.URL)
+	default:
+		return nil, fmt.Errorf("unknown database backend %q", conf.Backend)
+	}
+}
+
+type mongoStore struct {
+	client ClientHelper
+	db     DatabaseHelper
+}
+
+// NewMongoStore returns a store backed by the MongoDB collections
+func NewMongoStore(client ClientHelper, db DatabaseHelper) Store {
+	return &mongoStore{
+		client: client,
+		db:     db,
+	}
+}
+
+func (s *mongoStore) Cows() CowDatabase {
+	return NewCowDatabase(s.db)
+}
+
+func (s *mongoStore) Devices() DeviceDatabase {
+	return NewDeviceDatabase(s.db)
+}
+
+func (s *mongoStore) Users() UserDatabase {
+	return NewUserDatabase(s.db)
+}
+
+func (s *mongoStore) APIKeys() APIKeyDatabase {
+	return NewAPIKeyDatabase(s.db)
+}
+
+func (s *mongoStore) Invitations() InvitationDatabase {
+	return NewInvitationDatabase(s.db)
+}
+
+func (s *mongoStore) Audit() AuditDatabase {
+	return NewAuditDatabase(s.db)
+}
+
+func (s *mongoStore) Versions() VersionDatabase {
+	return NewVersionDatabase(s.db)
+}
+
+func (s *mongoStore) ApprovalPolicies() ApprovalPolicyDatabase {
+	return NewApprovalPolicyDatabase(s.db)
+}
+
+func (s *mongoStore) BookingRequests() BookingRequestDatabase {
+	return NewBookingRequestDatabase(s.db)
+}
+
+func (s *mongoStore) Waitlist() WaitlistDatabase {
+	return NewWaitlistDatabase(s.db)
+}
+
+func (s *mongoStore) BookingPolicies() BookingPolicyDatabase {
+	return NewBookingPolicyDatabase(s.db)
+}
+
+func (s *mongoStore) Calendar() CalendarDatabase {
+	return NewCalendarDatabase(s.db)
+}
+
+func (s *mongoStore) Migrator() *migrations.Migrator {
+	return migrations.New(NewMongoMigrationStore(s.db), MongoMigrations(s.db))
+}
+
+func (s *mongoStore) Close(ctx context.Context) error {
+	return s.client.Disconnect()
+}
@@ -12,10 +12,10 @@ const userDBO = "users"
 
 // UserDatabase contains the methods to use with the cow database
 type UserDatabase interface {
-	FindOne(ctx context.Context, filter interface{}) (*models.User, error)
-	Find(ctx context.Context, filter interface{}) ([]models.User, error)
-	InsertOne(ctx context.Context, document interface{}) (*mongoInsertOneResult, error)
-	UpdateOne(ctx context.Context, filter, document interface{}) (*mongoUpdateResult, error)
+	FindOne(ctx context.Context, filter *UserFilter) (*models.User, error)
+	Find(ctx context.Context, filter *UserFilter) ([]models.User, error)
+	InsertOne(ctx context.Context, user models.User) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *UserFilter, update *UserUpdate) (*UpdateResult, error)
 }
 
 type userDatabase struct {
@@ -29,18 +29,18 @@ func NewUserDatabase(db DatabaseHelper) UserDatabase {
 	}
 }
 
-func (u *userDatabase) FindOne(ctx context.Context, filter interface{}) (*models.User, error) {
+func (u *userDatabase) FindOne(ctx context.Context, filter *UserFilter) (*models.User, error) {
 	user := &models.User{}
-	err := u.db.Collection(userDBO).FindOne(ctx, filter).Decode(&user)
+	err := u.db.Collection(userDBO).FindOne(ctx, filter.query().Bson()).Decode(&user)
 	if err != nil {
 		return nil, err
 	}
 	return user, nil
 }
 
-func (u *userDatabase) Find(ctx context.Context, filter interface{}) ([]models.User, error) {
+func (u *userDatabase) Find(ctx context.Context, filter *UserFilter) ([]models.User, error) {
 	var users []models.User
-	err := u.db.Collection(userDBO).Find(ctx, filter).Decode(&users)
+	err := u.db.Collection(userDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&users)
 	if err != nil {
 		return nil, err
 	}
@@ -48,16 +48,19 @@ func (u *userDatabase) Find(ctx context.Context, filter interface{}) ([]models.U
 }
 
 // Returns the result (document id) and error
-func (u *userDatabase) InsertOne(ctx context.Context, document interface{}) (*mongoInsertOneResult, error) {
-	result, err := u.db.Collection(userDBO).InsertOne(ctx, document)
+func (u *userDatabase) InsertOne(ctx context.Context, user models.User) (*InsertOneResult, error) {
+	result, err := u.db.Collection(userDBO).InsertOne(ctx, user)
 	if err != nil {
 		return nil, err
 	}
 	return &result, nil
 }
 
-func (u *userDatabase) UpdateOne(ctx context.Context, filter, update interface{}) (*mongoUpdateResult, error) {
-	result, err := u.db.Collection(userDBO).UpdateOne(ctx, filter, update)
+func (u *userDatabase) UpdateOne(ctx context.Context, filter *UserFilter, update *UserUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := u.db.Collection(userDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,57 @@
+package databases
+
+// go generate: mockery --name VersionDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const versionDBO = "versions"
+
+// VersionDatabase contains the methods to use with the versions of cows and devices. Like
+// the audit log it is append-only. Inserting a version number an entity already has
+// returns ErrDuplicate
+type VersionDatabase interface {
+	FindOne(ctx context.Context, filter *VersionFilter) (*models.Version, error)
+	Find(ctx context.Context, filter *VersionFilter) ([]models.Version, error)
+	InsertOne(ctx context.Context, version models.Version) (*InsertOneResult, error)
+}
+
+type versionDatabase struct {
+	db DatabaseHelper
+}
+
+// NewVersionDatabase initializes a new instance of the versions with the provided db connection
+func NewVersionDatabase(db DatabaseHelper) VersionDatabase {
+	return &versionDatabase{
+		db: db,
+	}
+}
+
+func (v *versionDatabase) FindOne(ctx context.Context, filter *VersionFilter) (*models.Version, error) {
+	version := &models.Version{}
+	err := v.db.Collection(versionDBO).FindOne(ctx, filter.query().Bson()).Decode(&version)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+func (v *versionDatabase) Find(ctx context.Context, filter *VersionFilter) ([]models.Version, error) {
+	var versions []models.Version
+	err := v.db.Collection(versionDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&versions)
+	if err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (v *versionDatabase) InsertOne(ctx context.Context, version models.Version) (*InsertOneResult, error) {
+	result, err := v.db.Collection(versionDBO).InsertOne(ctx, version)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,67 @@
+package databases
+
+// go generate: mockery --name WaitlistDatabase
+
+import (
+	"context"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
+)
+
+const waitlistDBO = "waitlist"
+
+// WaitlistDatabase contains the methods to use with the users queueing for booked blocks
+type WaitlistDatabase interface {
+	FindOne(ctx context.Context, filter *WaitlistFilter) (*models.WaitlistEntry, error)
+	Find(ctx context.Context, filter *WaitlistFilter) ([]models.WaitlistEntry, error)
+	InsertOne(ctx context.Context, entry models.WaitlistEntry) (*InsertOneResult, error)
+	UpdateOne(ctx context.Context, filter *WaitlistFilter, update *WaitlistUpdate) (*UpdateResult, error)
+}
+
+type waitlistDatabase struct {
+	db DatabaseHelper
+}
+
+// NewWaitlistDatabase initializes a new instance of a waitlist database with the provided db connection
+func NewWaitlistDatabase(db DatabaseHelper) WaitlistDatabase {
+	return &waitlistDatabase{
+		db: db,
+	}
+}
+
+func (w *waitlistDatabase) FindOne(ctx context.Context, filter *WaitlistFilter) (*models.WaitlistEntry, error) {
+	entry := &models.WaitlistEntry{}
+	err := w.db.Collection(waitlistDBO).FindOne(ctx, filter.query().Bson()).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+func (w *waitlistDatabase) Find(ctx context.Context, filter *WaitlistFilter) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+	err := w.db.Collection(waitlistDBO).Find(ctx, filter.query().Bson(), filter.query().FindOptions()).Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (w *waitlistDatabase) InsertOne(ctx context.Context, entry models.WaitlistEntry) (*InsertOneResult, error) {
+	result, err := w.db.Collection(waitlistDBO).InsertOne(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func (w *waitlistDatabase) UpdateOne(ctx context.Context, filter *WaitlistFilter, update *WaitlistUpdate) (*UpdateResult, error) {
+	if update.update().Empty() {
+		return nil, ErrEmptyUpdate
+	}
+	result, err := w.db.Collection(waitlistDBO).UpdateOne(ctx, filter.query().Bson(), update.update().Bson())
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,95 @@
+// Package mailer sends the emails of the API, eg. password resets, over SMTP
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+)
+
+// ErrNotConfigured is returned by Send when SMTP_HOST is not set, so callers can fall back
+// to another way of delivering the message
+var ErrNotConfigured = errors.New("email is not configured, set SMTP_HOST")
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// New returns a mailer using the SMTP server in the config, or one that only logs the
+// recipient and subject when no server is set
+func New(conf *config.Config) Mailer {
+	if conf.SMTPHost == "" {
+		return logMailer{}
+	}
+
+	m := &smtpMailer{
+		addr: net.JoinHostPort(conf.SMTPHost, conf.SMTPPort),
+		from: conf.MailFrom,
+	}
+	if m.from == "" {
+		m.from = "devicebooking@" + conf.SMTPHost
+	}
+	if conf.SMTPUsername != "" {
+		m.auth = smtp.PlainAuth("", conf.SMTPUsername, conf.SMTPPassword, conf.SMTPHost)
+	}
+	return m
+}
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+func (m *smtpMailer) Send(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") {
+		return fmt.Errorf("invalid recipient %q", msg.To)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", m.from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// net/smtp has no context support, so a cancelled request only stops waiting for it
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, []byte(b.String()))
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type logMailer struct{}
+
+// Send only logs the recipient and subject, the body can hold secrets like reset links
+func (logMailer) Send(ctx context.Context, msg Message) error {
+	zap.S().Warnw("email not sent, SMTP_HOST is not set", "to", msg.To, "subject", msg.Subject)
+	return ErrNotConfigured
+}
@@ -1,25 +1,21 @@
 package main
 
 import (
-	"log"
-	"net/http"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
 
-	"go.uber.org/zap"
-
-	"github.com/SowinskiBraeden/DeviceBookingAPI/api/handlers"
-	"github.com/SowinskiBraeden/DeviceBookingAPI/config"
+	"github.com/SowinskiBraeden/DeviceBookingAPI/cli"
 )
 
 func main() {
-	a := handlers.App{}
-	a.Config = *config.New()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 
-	err := a.Initialize() //initialize database and router
-	if err != nil {
-		zap.S().With(err).Error("error calling initialize")
-		return
+	if err := cli.Run(ctx, os.Args[1:], os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "devicebooking: %v\n", err)
+		stop()
+		os.Exit(1)
 	}
-
-	zap.S().Infow("DeviceBookingAPI is up and running", "url", a.Config.BaseURL, "port", a.Config.Port)
-	log.Fatal(http.ListenAndServe(":"+a.Config.Port, a.Router))
 }
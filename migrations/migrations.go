@@ -0,0 +1,199 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Migration is a single versioned change to the database. Versions must be unique
+// and are applied in ascending order
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(ctx context.Context) error
+	Down        func(ctx context.Context) error
+}
+
+// Record is stored for every migration that has been applied
+type Record struct {
+	Version     int       `json:"version"     bson:"_id"`
+	Description string    `json:"description" bson:"Description"`
+	AppliedAt   time.Time `json:"applied_at"  bson:"AppliedAt"`
+}
+
+// Store keeps track of which migrations have been applied
+type Store interface {
+	Applied(ctx context.Context) ([]Record, error)
+	Insert(ctx context.Context, record Record) error
+	Delete(ctx context.Context, version int) error
+}
+
+// Migrator runs a set of migrations against a store. When DryRun is set the
+// migrations that would run are returned and logged, but nothing is changed
+type Migrator struct {
+	Store      Store
+	Migrations []Migration
+	DryRun     bool
+}
+
+// New returns a migrator for the provided store and migrations
+func New(store Store, migrations []Migration) *Migrator {
+	return &Migrator{
+		Store:      store,
+		Migrations: migrations,
+	}
+}
+
+// Pending returns the migrations that have not yet been applied, in the order they would run
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range m.sorted() {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Status returns the records of every applied migration
+func (m *Migrator) Status(ctx context.Context) ([]Record, error) {
+	records, err := m.Store.Applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Version < records[j].Version })
+	return records, nil
+}
+
+// Up applies every pending migration up to and including target. A target of 0
+// applies all pending migrations
+func (m *Migrator) Up(ctx context.Context, target int) ([]Migration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	pending, err := m.Pending(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ran []Migration
+	for _, migration := range pending {
+		if target > 0 && migration.Version > target {
+			break
+		}
+
+		if m.DryRun {
+			zap.S().Infow("would apply migration", "version", migration.Version, "description", migration.Description)
+			ran = append(ran, migration)
+			continue
+		}
+
+		zap.S().Infow("applying migration", "version", migration.Version, "description", migration.Description)
+		if err := migration.Up(ctx); err != nil {
+			return ran, fmt.Errorf("migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+
+		record := Record{Version: migration.Version, Description: migration.Description, AppliedAt: time.Now().UTC()}
+		if err := m.Store.Insert(ctx, record); err != nil {
+			return ran, fmt.Errorf("failed to record migration %d: %w", migration.Version, err)
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// Down rolls back every applied migration with a version greater than target,
+// newest first. A target of 0 rolls back everything
+func (m *Migrator) Down(ctx context.Context, target int) ([]Migration, error) {
+	if err := m.validate(); err != nil {
+		return nil, err
+	}
+
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	sorted := m.sorted()
+	var ran []Migration
+	for i := len(sorted) - 1; i >= 0; i-- {
+		migration := sorted[i]
+		if migration.Version <= target {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if m.DryRun {
+			zap.S().Infow("would roll back migration", "version", migration.Version, "description", migration.Description)
+			ran = append(ran, migration)
+			continue
+		}
+
+		if migration.Down == nil {
+			return ran, fmt.Errorf("migration %d (%s) cannot be rolled back", migration.Version, migration.Description)
+		}
+
+		zap.S().Infow("rolling back migration", "version", migration.Version, "description", migration.Description)
+		if err := migration.Down(ctx); err != nil {
+			return ran, fmt.Errorf("rollback of migration %d (%s) failed: %w", migration.Version, migration.Description, err)
+		}
+
+		if err := m.Store.Delete(ctx, migration.Version); err != nil {
+			return ran, fmt.Errorf("failed to remove migration record %d: %w", migration.Version, err)
+		}
+		ran = append(ran, migration)
+	}
+	return ran, nil
+}
+
+// applied is a helper function returning the applied migration versions as a set
+func (m *Migrator) applied(ctx context.Context) (map[int]Record, error) {
+	records, err := m.Store.Applied(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+
+	applied := make(map[int]Record, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
+
+// sorted is a helper function returning a copy of the migrations ordered by version
+func (m *Migrator) sorted() []Migration {
+	sorted := make([]Migration, len(m.Migrations))
+	copy(sorted, m.Migrations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+	return sorted
+}
+
+// validate is a helper function making sure every migration has a unique, positive version
+func (m *Migrator) validate() error {
+	seen := map[int]bool{}
+	for _, migration := range m.Migrations {
+		if migration.Version <= 0 {
+			return fmt.Errorf("migration %q has invalid version %d", migration.Description, migration.Version)
+		}
+		if seen[migration.Version] {
+			return fmt.Errorf("duplicate migration version %d", migration.Version)
+		}
+		if migration.Up == nil {
+			return fmt.Errorf("migration %d (%s) has no Up function", migration.Version, migration.Description)
+		}
+		seen[migration.Version] = true
+	}
+	return nil
+}
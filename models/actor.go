@@ -0,0 +1,16 @@
+package models
+
+// Actor types
+const (
+	ActorUser      = "user"
+	ActorAPIKey    = "apiKey"
+	ActorAnonymous = "anonymous"
+)
+
+// Actor is who made a change, a signed in user or the API key of a service integration
+type Actor struct {
+	Type     string `json:"type"` // ActorUser, ActorAPIKey or ActorAnonymous
+	ID       ID     `json:"id,omitempty"`
+	Name     string `json:"name,omitempty"` // email of a user, prefix of an API key
+	Business ID     `json:"business,omitempty"`
+}
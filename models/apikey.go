@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// APIKey lets a service integration, eg. a scheduling system or a signage board, use the
+// API for one business without signing in as a user. Only a hash of the key is stored, the
+// key itself is shown once when it is created
+type APIKey struct {
+	ID      ID            `json:"id" bson:"_id"`
+	Details APIKeyDetails `json:"details"`
+}
+
+// APIKeyDetails holds the business, scopes and usage of an API key
+type APIKeyDetails struct {
+	Name       string    `json:"name"`      // what the key is for, eg. Library signage
+	Business   ID        `json:"business"`  // the only business the key can reach
+	Prefix     string    `json:"prefix"`    // start of the key, eg. dbk_1a2b3c4d, to tell keys apart
+	Hash       string    `json:"-"`         // SHA-256 of the whole key
+	Scopes     []string  `json:"scopes"`    // eg. bookings:read, see package auth
+	CreatedBy  ID        `json:"createdBy"` // the admin that created the key
+	CreatedAt  time.Time `json:"createdAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`  // zero when the key doesn't expire
+	LastUsedAt time.Time `json:"lastUsedAt"` // updated at most once a minute
+	LastUsedIP string    `json:"lastUsedIp"`
+}
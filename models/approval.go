@@ -0,0 +1,49 @@
+package models
+
+import "time"
+
+// Booking request states. Pending requests past their ExpiresAt are expired by the
+// background job, see handlers.Approval
+const (
+	BookingPending  = "pending"
+	BookingApproved = "approved"
+	BookingRejected = "rejected"
+	BookingExpired  = "expired"
+)
+
+// ApprovalPolicy makes the bookings of a cow wait for one of its approvers, eg. for carts
+// that are shared with another school. A cow without a policy is booked straight away
+type ApprovalPolicy struct {
+	ID      ID                    `json:"id" bson:"_id"` // the ID of the cow
+	Details ApprovalPolicyDetails `json:"details"`
+}
+
+// ApprovalPolicyDetails holds who approves the bookings of the cow. Admins of the cow's
+// business can always approve, the approvers don't have to be Admins
+type ApprovalPolicyDetails struct {
+	Business  ID        `json:"business"` // of the cow
+	Approvers []ID      `json:"approvers"`
+	UpdatedBy ID        `json:"updatedBy"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// BookingRequest is a booking of a cow with an approval policy that is waiting for, or got,
+// a decision. Approving it adds the booking to the cow with the ID it already has
+type BookingRequest struct {
+	ID      ID                    `json:"id" bson:"_id"`
+	Details BookingRequestDetails `json:"details"`
+}
+
+// BookingRequestDetails holds the requested booking, who asked for it and what became of it
+type BookingRequestDetails struct {
+	Cow       ID          `json:"cow"`
+	Business  ID          `json:"business"` // of the cow
+	Booking   BookDetails `json:"booking"`
+	Requester ID          `json:"requester"` // the signed in user who asked, empty for API keys
+	Status    string      `json:"status"`    // BookingPending, BookingApproved, BookingRejected or BookingExpired
+	CreatedAt time.Time   `json:"createdAt"`
+	ExpiresAt time.Time   `json:"expiresAt"` // when a pending request expires, at the latest when the booking starts
+	DecidedBy ID          `json:"decidedBy"`
+	DecidedAt time.Time   `json:"decidedAt"`
+	Reason    string      `json:"reason"` // given by the approver, required to reject
+}
@@ -0,0 +1,107 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// Audit actions
+const (
+	AuditCreate = "create"
+	AuditUpdate = "update"
+	AuditDelete = "delete"
+)
+
+// Audited entity types
+const (
+	AuditCow     = "cow"
+	AuditDevice  = "device"
+	AuditUser    = "user"
+	AuditBooking = "booking"
+)
+
+// AuditEntry records one change made through the API. Entries are only ever appended,
+// there is no way to change or remove them through the API
+type AuditEntry struct {
+	ID      ID           `json:"id" bson:"_id"`
+	Details AuditDetails `json:"details"`
+}
+
+// AuditDetails holds who changed what, how it looked before and after, and the request
+// that did it
+type AuditDetails struct {
+	Time       time.Time `json:"time"`
+	Actor      Actor     `json:"actor"`
+	Business   ID        `json:"business"` // of the changed entity, empty for SuperUsers and cows without one
+	Action     string    `json:"action"`   // AuditCreate, AuditUpdate or AuditDelete
+	EntityType string    `json:"entityType"`
+	EntityID   string    `json:"entityId"` // not an ID for bookings, they are <cow_id>.<random>
+	Before     Snapshot  `json:"before,omitempty"`
+	After      Snapshot  `json:"after,omitempty"`
+
+	RequestID string `json:"requestId"`
+	IP        string `json:"ip"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+}
+
+// Snapshot is an entity as the API returned it at the time of an audit entry. It is
+// kept as JSON text by every backend so later changes to the models can't break old entries
+type Snapshot json.RawMessage
+
+// NewSnapshot returns the JSON of v, nil when v is nil
+func NewSnapshot(v interface{}) (Snapshot, error) {
+	b, err := json.Marshal(v)
+	if err != nil || string(b) == "null" {
+		return nil, err
+	}
+	return Snapshot(b), nil
+}
+
+// MarshalJSON writes the snapshot as is, or null when it is empty
+func (s Snapshot) MarshalJSON() ([]byte, error) {
+	if len(s) == 0 {
+		return []byte("null"), nil
+	}
+	return s, nil
+}
+
+// UnmarshalJSON keeps a copy of the JSON
+func (s *Snapshot) UnmarshalJSON(b []byte) error {
+	if string(b) == "null" {
+		*s = nil
+		return nil
+	}
+	*s = append((*s)[:0], b...)
+	return nil
+}
+
+// MarshalBSONValue stores the snapshot as a string
+func (s Snapshot) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(string(s))
+}
+
+// UnmarshalBSONValue reads a snapshot stored as a string
+func (s *Snapshot) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.String:
+		str, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return errors.New("invalid snapshot")
+		}
+		*s = nil
+		if str != "" {
+			*s = Snapshot(str)
+		}
+	case bsontype.Null, bsontype.Undefined:
+		*s = nil
+	default:
+		return errors.New("cannot decode bson " + t.String() + " into a snapshot")
+	}
+	return nil
+}
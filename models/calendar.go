@@ -0,0 +1,50 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Calendar entry kinds. Closures close a business for whole days, blackouts keep its cows,
+// or some of them, from being booked for a while
+const (
+	CalendarClosure  = "closure"
+	CalendarBlackout = "blackout"
+)
+
+// CalendarEntry is a closure or blackout in the calendar of a business. Bookings and
+// availability searches overlapping an entry that applies to the cow are refused
+type CalendarEntry struct {
+	ID      ID                   `json:"id" bson:"_id"`
+	Details CalendarEntryDetails `json:"details"`
+}
+
+// CalendarEntryDetails holds when an entry is, why, and for blackouts which cows it keeps
+// from being booked. A blackout without a cow or device type applies to every cow
+type CalendarEntryDetails struct {
+	Business   ID        `json:"business"`
+	Kind       string    `json:"kind"` // CalendarClosure or CalendarBlackout
+	Reason     string    `json:"reason"`
+	Start      time.Time `json:"start"`                // midnight UTC of the first day of closures
+	End        time.Time `json:"end"`                  // exclusive, midnight UTC after the last day of closures
+	Cow        ID        `json:"cow,omitempty"`        // blackouts of a single cow
+	DeviceType string    `json:"deviceType,omitempty"` // blackouts of the cows of a collection, eg. Laptop
+	UID        string    `json:"uid,omitempty"`        // of the iCal event the entry was imported from
+	CreatedBy  ID        `json:"createdBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// Applies reports whether the entry keeps the cow from being booked, whatever the time
+func (e *CalendarEntry) Applies(cow *Cow) bool {
+	switch {
+	case e.Details.Business != cow.Details.Business:
+		return false
+	case e.Details.Kind == CalendarClosure:
+		return true
+	case !e.Details.Cow.IsZero():
+		return e.Details.Cow == cow.ID
+	case e.Details.DeviceType != "":
+		return strings.EqualFold(e.Details.DeviceType, cow.Details.Collection)
+	}
+	return true
+}
@@ -6,7 +6,7 @@ import (
 
 // Cow holds the structure for the cow collection in mongo
 type Cow struct {
-	ID      string     `json:"_id" bson:"_id"` // MongoDB ID
+	ID      ID         `json:"_id" bson:"_id"` // MongoDB ID
 	Details CowDetails `json:"cow" bson:"Cow"` // Details
 }
 
@@ -14,18 +14,23 @@ type Cow struct {
 type BookDetails struct {
 	ID        string             `json:"id"        bson:"ID"`        // Generated ID -> cow_id.random_str
 	Author    string             `json:"author"    bson:"Author"`    // User who booked
-	Devices   []string           `json:"devices"   bson:"Devices"`   // Array of device ID's
+	Devices   []ID               `json:"devices"   bson:"Devices"`   // Array of device ID's
 	Block     string             `json:"block"     bson:"Block"`     // Block that is booked
 	StartDate primitive.DateTime `json:"startdate" bson:"StartDate"` // Date this booking occurs
 	EndDate   primitive.DateTime `json:"enddate"   bson:"EndDate"`   // Date this booking ends
+
+	// Signed in user who booked, set by the API and counted by booking policies. Empty
+	// for bookings made with API keys
+	User ID `json:"user,omitempty" bson:"User,omitempty"`
 }
 
 // CowDetails holds the structure for the inner cow structure as
 // defined in the cow collection in mongo
 type CowDetails struct {
 	Name        string        `json:"name"        bson:"Name"`        // eg. CA-01
+	Business    ID            `json:"business"    bson:"Business"`    // Business the cow belongs to, names are unique per business
 	Collection  string        `json:"collection"  bson:"Collection"`  // eg. Laptop, Ipad, etc
 	DeviceTotal int           `json:"deviceTotal" bson:"DeviceTotal"` // # of devices in that cart collection
 	Bookings    []BookDetails `json:"bookings"    bson:"Bookings"`    // An array of all active bookings (send top 10)
-	Devices     []string      `json:"devices"     bson:"Devices"`     // Array of device ID's
+	Devices     []ID          `json:"devices"     bson:"Devices"`     // Array of device ID's
 }
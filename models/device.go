@@ -2,7 +2,7 @@ package models
 
 // Device holds the structure for the device collection in mongo
 type Device struct {
-	ID      string        `json:"_id"    bson:"_id"`    // MongoDB ID
+	ID      ID            `json:"_id"    bson:"_id"`    // MongoDB ID
 	Details DeviceDetails `json:"device" bson:"Device"` // Details
 }
 
@@ -10,5 +10,5 @@ type Device struct {
 type DeviceDetails struct {
 	Type   string `json:"type"   bson:"Type"`   // eg. Laptop, Ipad, etc
 	Name   string `json:"name"   bson:"Name"`   // eg. SULH-LAP-01
-	Parent string `json:"parent" bson:"Parent"` // Parent cow ID
+	Parent ID     `json:"parent" bson:"Parent"` // Parent cow ID
 }
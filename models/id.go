@@ -0,0 +1,110 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// ErrInvalidID is returned when parsing a string that is not a valid ID
+var ErrInvalidID = errors.New("invalid id: must be a 24 character hex string")
+
+// ID identifies every document (cows, devices, users and businesses). It is always
+// stored and sent as a 24 character lower case hex string, whether the backend is
+// Mongo or SQL. The zero value means no ID
+type ID string
+
+// NewID generates a new unique ID
+func NewID() ID {
+	return ID(primitive.NewObjectID().Hex())
+}
+
+// ParseID validates s and returns it as an ID
+func ParseID(s string) (ID, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if _, err := primitive.ObjectIDFromHex(s); err != nil {
+		return "", fmt.Errorf("%w: %q", ErrInvalidID, s)
+	}
+	return ID(s), nil
+}
+
+// ParseIDs validates every string and returns them as IDs
+func ParseIDs(values []string) ([]ID, error) {
+	ids := make([]ID, 0, len(values))
+	for _, value := range values {
+		id, err := ParseID(value)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (id ID) String() string {
+	return string(id)
+}
+
+// IsZero reports whether the ID is unset
+func (id ID) IsZero() bool {
+	return id == ""
+}
+
+// MarshalJSON writes the ID as a JSON string
+func (id ID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(id))
+}
+
+// UnmarshalJSON accepts a valid ID or an empty string
+func (id *ID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return ErrInvalidID
+	}
+	if s == "" {
+		*id = ""
+		return nil
+	}
+
+	parsed, err := ParseID(s)
+	if err != nil {
+		return err
+	}
+	*id = parsed
+	return nil
+}
+
+// MarshalBSONValue always stores the ID as a string
+func (id ID) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	return bson.MarshalValue(string(id))
+}
+
+// UnmarshalBSONValue reads an ID stored as a string, or as an ObjectID by older
+// versions of the API, so mixed documents can still be read before they are migrated
+func (id *ID) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.String:
+		s, _, ok := bsoncore.ReadString(data)
+		if !ok {
+			return ErrInvalidID
+		}
+		*id = ID(s)
+	case bsontype.ObjectID:
+		oid, _, ok := bsoncore.ReadObjectID(data)
+		if !ok {
+			return ErrInvalidID
+		}
+		*id = ID(oid.Hex())
+	case bsontype.Null, bsontype.Undefined:
+		*id = ""
+	default:
+		return fmt.Errorf("%w: cannot decode bson %s", ErrInvalidID, t)
+	}
+	return nil
+}
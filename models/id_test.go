@@ -0,0 +1,102 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestParseID(t *testing.T) {
+	const hex = "5f1b2c3d4e5f6a7b8c9d0e1f"
+	for _, s := range []string{hex, " 5F1B2C3D4E5F6A7B8C9D0E1F "} {
+		if id, err := ParseID(s); err != nil || id != hex {
+			t.Errorf("ParseID(%q) = %q, %v, want %q", s, id, err, hex)
+		}
+	}
+	for _, s := range []string{"", "5f1b2c3d", hex + "00", "5f1b2c3d4e5f6a7b8c9d0e1g"} {
+		if _, err := ParseID(s); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("ParseID(%q) returned %v, want ErrInvalidID", s, err)
+		}
+	}
+
+	if _, err := ParseIDs([]string{hex, "cart"}); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("ParseIDs with an invalid ID returned %v, want ErrInvalidID", err)
+	}
+	if ids, err := ParseIDs(nil); err != nil || ids == nil || len(ids) != 0 {
+		t.Errorf("ParseIDs(nil) = %v, %v, want an empty list", ids, err)
+	}
+}
+
+func TestIDJSON(t *testing.T) {
+	type doc struct {
+		ID      ID   `json:"id"`
+		Devices []ID `json:"devices"`
+	}
+	id := NewID()
+	b, err := json.Marshal(doc{ID: id, Devices: []ID{id}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if want := `{"id":"` + id.String() + `","devices":["` + id.String() + `"]}`; string(b) != want {
+		t.Errorf("Marshal = %s, want %s", b, want)
+	}
+
+	var got doc
+	if err := json.Unmarshal([]byte(`{"id":"5F1B2C3D4E5F6A7B8C9D0E1F","devices":[]}`), &got); err != nil || got.ID != "5f1b2c3d4e5f6a7b8c9d0e1f" {
+		t.Errorf("Unmarshal of an upper case ID = %q, %v, want it in lower case", got.ID, err)
+	}
+	if err := json.Unmarshal([]byte(`{"id":""}`), &got); err != nil || !got.ID.IsZero() {
+		t.Errorf("Unmarshal of an empty ID = %q, %v, want no ID", got.ID, err)
+	}
+	for _, body := range []string{`{"id":"cart"}`, `{"id":42}`, `{"devices":["cart"]}`} {
+		if err := json.Unmarshal([]byte(body), &got); !errors.Is(err, ErrInvalidID) {
+			t.Errorf("Unmarshal(%s) returned %v, want ErrInvalidID", body, err)
+		}
+	}
+}
+
+func TestIDBSON(t *testing.T) {
+	type doc struct {
+		ID      ID   `bson:"_id"`
+		Parent  ID   `bson:"parent"`
+		Devices []ID `bson:"devices"`
+	}
+	id := NewID()
+	b, err := bson.Marshal(doc{ID: id, Devices: []ID{id}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var raw bson.M
+	if err := bson.Unmarshal(b, &raw); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if raw["_id"] != id.String() || raw["parent"] != "" {
+		t.Errorf("an ID is stored as %T %v, want a string", raw["_id"], raw["_id"])
+	}
+
+	// documents written by older versions hold ObjectIDs, and sometimes nulls
+	legacy, other := primitive.NewObjectID(), primitive.NewObjectID()
+	b, err = bson.Marshal(bson.M{"_id": legacy, "parent": nil, "devices": bson.A{other, id.String()}})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	var got doc
+	if err := bson.Unmarshal(b, &got); err != nil {
+		t.Fatalf("Unmarshal of a legacy document: %v", err)
+	}
+	if want := (doc{ID: ID(legacy.Hex()), Devices: []ID{ID(other.Hex()), id}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("a legacy document reads as %+v, want %+v", got, want)
+	}
+
+	b, err = bson.Marshal(bson.M{"_id": 42})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if err := bson.Unmarshal(b, &got); !errors.Is(err, ErrInvalidID) {
+		t.Errorf("Unmarshal of a number returned %v, want ErrInvalidID", err)
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// Invitation states. A pending invitation past its ExpiresAt can no longer be accepted, an
+// admin resends it to give it a new link
+const (
+	InvitationPending  = "pending"
+	InvitationAccepted = "accepted"
+)
+
+// Invitation asks someone to create their own account in a business, so admins don't have
+// to choose passwords for them. Only a hash of the signup token is stored, the token
+// itself is only in the emailed link
+type Invitation struct {
+	ID      ID                `json:"id" bson:"_id"`
+	Details InvitationDetails `json:"details"`
+}
+
+// InvitationDetails holds who is invited, as what, and what became of it
+type InvitationDetails struct {
+	Email      string    `json:"email"`
+	FirstName  string    `json:"firstname"` // optional, the invitee can fill them in on signup
+	LastName   string    `json:"lastname"`
+	Business   ID        `json:"business"`
+	UserType   int       `json:"usertype"` // TypeAdmin or TypeUser
+	Status     string    `json:"status"`   // InvitationPending or InvitationAccepted
+	TokenHash  string    `json:"-"`        // SHA-256 of the signup token, only accepted while pending
+	InvitedBy  ID        `json:"invitedBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+	SentAt     time.Time `json:"sentAt"` // when the latest link was made, resending replaces it
+	ExpiresAt  time.Time `json:"expiresAt"`
+	AcceptedAt time.Time `json:"acceptedAt"`
+	User       ID        `json:"user"` // the user created on signup
+}
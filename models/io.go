@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 // This is io.go (input/output) for json queries and responses
 
 // HealthCheckResponse returns the health check response duh
@@ -14,15 +16,31 @@ type UserResponse struct {
 	Data    map[string]interface{} `json:"data"`
 }
 
-// ErrorMessageResponse returns the error message response struct
-type ErrorMessageResponse struct {
-	Response MessageError `json:"response"`
+// Page is returned next to the results of a paginated list
+type Page struct {
+	Limit  int64 `json:"limit"`
+	Offset int64 `json:"offset"`
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error APIError `json:"error"`
+}
+
+// APIError describes why a request failed
+type APIError struct {
+	Code      string       `json:"code"`                // Stable machine readable code, eg. not_found
+	Status    int          `json:"status"`              // HTTP status code
+	Message   string       `json:"message"`             // Human readable message
+	RequestID string       `json:"requestId,omitempty"` // Matches the X-Request-ID response header and the logs
+	Fields    []FieldError `json:"fields,omitempty"`    // Per field problems with the request body
 }
 
-// MessageError contains the inner details for the error message response
-type MessageError struct {
-	Message string `json:"message"`
-	Error   string `json:"error"`
+// FieldError describes a problem with a single field of the request body
+type FieldError struct {
+	Field   string `json:"field"`   // JSON name of the field
+	Code    string `json:"code"`    // The failed check, eg. required
+	Message string `json:"message"` // eg. "is required"
 }
 
 // Query for search queries by name
@@ -32,5 +50,284 @@ type Query struct {
 
 // NewDeviceToCow is to add device object id to cow
 type NewDeviceToCow struct {
-	ID string `json:"_id"`
+	ID ID `json:"_id" validate:"required"`
+}
+
+// SetupStatus tells clients whether the first SuperUser still has to be created
+type SetupStatus struct {
+	Required bool `json:"required"`
+}
+
+// SetupRequest creates the first SuperUser, the token is logged by the API on startup
+type SetupRequest struct {
+	Token     string `json:"token"     validate:"required"`
+	FirstName string `json:"firstname" validate:"required"`
+	LastName  string `json:"lastname"  validate:"required"`
+	Email     string `json:"email"     validate:"required,email"`
+	Password  string `json:"password"  validate:"required,min=10,max=72"`
+}
+
+// LoginRequest signs in with an email and password
+type LoginRequest struct {
+	Email    string `json:"email"    validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// LoginResponse holds the bearer token of a new session. When PasswordChangeRequired is
+// set the token can only be used to change the temporary password, and when
+// TwoFactorSetupRequired is set only to enroll in two-factor authentication.
+// When TwoFactorRequired is set the token is not a session yet, it must be sent with a
+// code to POST /api/v2/auth/2fa/verify and there is no user
+type LoginResponse struct {
+	Token                  string    `json:"token"`
+	ExpiresAt              time.Time `json:"expiresAt"`
+	PasswordChangeRequired bool      `json:"passwordChangeRequired"`
+	TwoFactorRequired      bool      `json:"twoFactorRequired,omitempty"`
+	TwoFactorSetupRequired bool      `json:"twoFactorSetupRequired,omitempty"`
+	User                   *User     `json:"user,omitempty"`
+}
+
+// SessionInfo is a signed in session of the user listing their sessions, Current is the
+// one they are using
+type SessionInfo struct {
+	Session
+	Current bool `json:"current"`
+}
+
+// SSOProvider is a single sign-on provider. Send the browser to LoginURL, optionally with
+// ?return_to=/path, and it comes back to BASE_URL/path with the LoginResponse fields in the
+// URL fragment, eg. #token=...&expiresAt=...
+type SSOProvider struct {
+	Name        string   `json:"name"`
+	DisplayName string   `json:"displayName"`
+	Business    ID       `json:"business"`
+	Domains     []string `json:"domains"`
+	LoginURL    string   `json:"loginUrl"`
+}
+
+// ChangePasswordRequest replaces the password of the signed in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword"     validate:"required,min=10,max=72"`
+}
+
+// ForgotPasswordRequest emails a password reset link
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ResetPasswordRequest sets a new password with the token from a reset link
+type ResetPasswordRequest struct {
+	Token    string `json:"token"    validate:"required"`
+	Password string `json:"password" validate:"required,min=10,max=72"`
+}
+
+// TwoFactorEnrollment is the secret to add to an authenticator app, it has to be
+// confirmed with a code before logins need it
+type TwoFactorEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`    // otpauth:// URI
+	QRCode string `json:"qrCode"` // the URI as a PNG data: URL
+}
+
+// TwoFactorCodeRequest holds a code from an authenticator app
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// TwoFactorVerifyRequest finishes a login with the token of the LoginResponse and either a
+// code from an authenticator app or a recovery code
+type TwoFactorVerifyRequest struct {
+	Token        string `json:"token"        validate:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// TwoFactorDisableRequest turns two-factor authentication off
+type TwoFactorDisableRequest struct {
+	Password string `json:"password" validate:"required"`
+	Code     string `json:"code"     validate:"required"`
+}
+
+// RecoveryCodes are single use codes for signing in without the authenticator app. They
+// are only shown once
+type RecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
+
+// PasswordResetResult is returned when an admin resets a password. The temporary password
+// is only included when it could not be emailed
+type PasswordResetResult struct {
+	Emailed           bool   `json:"emailed"`
+	TemporaryPassword string `json:"temporaryPassword,omitempty"`
+}
+
+// UserRequest creates a user. Admins create Users of their own business, SuperUsers
+// create Admins and Users of any business. Without a password a temporary one is emailed
+type UserRequest struct {
+	FirstName string `json:"firstname" validate:"required"`
+	LastName  string `json:"lastname"  validate:"required"`
+	Email     string `json:"email"     validate:"required,email"`
+	UserType  int    `json:"usertype"  validate:"required,oneof=2 3"`
+	Business  ID     `json:"business"` // Admins can leave it out for their own business
+	Password  string `json:"password"  validate:"omitempty,min=10,max=72"`
+}
+
+// UserUpdateRequest changes a user, fields left empty are kept. Only SuperUsers move users
+// to another business
+type UserUpdateRequest struct {
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	Email     string `json:"email"`
+	Business  ID     `json:"business"`
+}
+
+// RoleRequest promotes or demotes a user, see the user types in models/user.go
+type RoleRequest struct {
+	UserType int `json:"usertype" validate:"required,oneof=2 3"`
+}
+
+// CreatedUser is a new user. The temporary password is only included when one was
+// generated and it could not be emailed
+type CreatedUser struct {
+	User
+	Emailed           bool   `json:"emailed"`
+	TemporaryPassword string `json:"temporaryPassword,omitempty"`
+}
+
+// InvitationRequest invites someone to sign up. Admins invite Users of their own business,
+// SuperUsers name the business. The names are optional, the invitee fills them in on signup
+type InvitationRequest struct {
+	Email     string `json:"email"     validate:"required,email"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+	UserType  int    `json:"usertype"  validate:"required,oneof=2 3"`
+	Business  ID     `json:"business"`
+}
+
+// CreatedInvitation is a new or resent invitation. The signup link is only included when it
+// could not be emailed
+type CreatedInvitation struct {
+	Invitation
+	Emailed   bool   `json:"emailed"`
+	SignupURL string `json:"signupUrl,omitempty"`
+}
+
+// InvitationImport is the outcome of a CSV of invitations, one row per invitee
+type InvitationImport struct {
+	Invited int                   `json:"invited"`
+	Rows    []InvitationImportRow `json:"rows"`
+}
+
+// InvitationImportRow is the outcome of one line of a CSV of invitations, either an
+// invitation or the reason there is none
+type InvitationImportRow struct {
+	Line       int    `json:"line"`
+	Email      string `json:"email"`
+	Invitation ID     `json:"invitation,omitempty"`
+	Emailed    bool   `json:"emailed"`
+	SignupURL  string `json:"signupUrl,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// SignupRequest accepts an invitation with the token of its link. The names are required
+// when the invitation doesn't have them
+type SignupRequest struct {
+	Token     string `json:"token"     validate:"required"`
+	Password  string `json:"password"  validate:"required,min=10,max=72"`
+	FirstName string `json:"firstname"`
+	LastName  string `json:"lastname"`
+}
+
+// APIKeyRequest creates an API key. Admins create keys for their own business, SuperUsers
+// name the business
+type APIKeyRequest struct {
+	Name      string    `json:"name"      validate:"required,max=100"`
+	Business  ID        `json:"business"`
+	Scopes    []string  `json:"scopes"    validate:"required,min=1"`
+	ExpiresAt time.Time `json:"expiresAt"` // optional, the key doesn't expire without it
+}
+
+// CreatedAPIKey is a new API key. Key is only returned here, only its hash is stored
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// ApprovalPolicyRequest sets who approves the bookings of a cow
+type ApprovalPolicyRequest struct {
+	Approvers []ID `json:"approvers" validate:"required,min=1,max=50"`
+}
+
+// BookingDecision approves or rejects a booking request. Rejections need a reason, which
+// is emailed to the requester
+type BookingDecision struct {
+	Reason string `json:"reason" validate:"max=500"`
+}
+
+// BookingPolicyRequest sets the booking policy of a business for a role, or every role with
+// usertype 0. Admins set the policies of their own business, SuperUsers name the business
+type BookingPolicyRequest struct {
+	Business           ID  `json:"business"`
+	UserType           int `json:"usertype"           validate:"min=0,max=3"`
+	MaxConcurrent      int `json:"maxConcurrent"      validate:"min=0"`
+	MaxPerWeek         int `json:"maxPerWeek"         validate:"min=0"`
+	MaxLeadDays        int `json:"maxLeadDays"        validate:"min=0"`
+	MinNoticeMinutes   int `json:"minNoticeMinutes"   validate:"min=0"`
+	MaxDurationMinutes int `json:"maxDurationMinutes" validate:"min=0"`
+}
+
+// WaitlistPlace is a waitlist entry with the number of entries waiting for its block and,
+// while it waits, its place in that queue. 1 is offered the block next
+type WaitlistPlace struct {
+	WaitlistEntry
+	Position int `json:"position,omitempty"`
+	Waiting  int `json:"waiting"`
+}
+
+// ClosureRequest closes a business for the whole days from startDate to endDate, in the
+// time zone of the businesses.
+// Admins close their own business, SuperUsers name the business
+type ClosureRequest struct {
+	Business  ID     `json:"business"`
+	StartDate string `json:"startDate" validate:"required,datetime=2006-01-02"`
+	EndDate   string `json:"endDate"   validate:"omitempty,datetime=2006-01-02"` // the last day closed, startDate when empty
+	Reason    string `json:"reason"    validate:"required,max=200"`
+}
+
+// BlackoutRequest keeps a cow, the cows of a device type or every cow of a business from
+// being booked from start to end. Admins black out their own business, SuperUsers name it
+type BlackoutRequest struct {
+	Business   ID        `json:"business"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+	Cow        ID        `json:"cow,omitempty"`
+	DeviceType string    `json:"deviceType,omitempty" validate:"max=50"` // the collection of the cows, eg. Laptop
+	Reason     string    `json:"reason"               validate:"required,max=200"`
+}
+
+// CalendarImport is the outcome of an iCal file, one event per VEVENT
+type CalendarImport struct {
+	Imported int                   `json:"imported"`
+	Updated  int                   `json:"updated"`
+	Events   []CalendarImportEvent `json:"events"`
+}
+
+// CalendarImportEvent is the outcome of one event of an iCal file, either the calendar entry
+// made from it or the reason there is none
+type CalendarImportEvent struct {
+	UID     string `json:"uid,omitempty"`
+	Summary string `json:"summary"`
+	Entry   ID     `json:"entry,omitempty"`
+	Updated bool   `json:"updated"` // the entry imported before from the same UID was replaced
+	Error   string `json:"error,omitempty"`
+}
+
+// CowAvailability says whether a cow can be booked for the time searched, and why not
+type CowAvailability struct {
+	Cow        ID     `json:"cow"`
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	Available  bool   `json:"available"`
+	Reason     string `json:"reason,omitempty"` // eg. "booked" or "closed: Winter break"
 }
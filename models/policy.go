@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// BookingPolicy limits the bookings the users of a business make, so a few can't book every
+// block of the term. A policy with a UserType only applies to that role and replaces the
+// business wide policy, which has UserType 0. Zero limits don't apply
+type BookingPolicy struct {
+	ID      ID                   `json:"id" bson:"_id"`
+	Details BookingPolicyDetails `json:"details"`
+}
+
+// BookingPolicyDetails holds the limits of a booking policy. Bookings made with API keys are
+// held to the time limits of the business wide policy, and refused when a policy of the
+// business counts bookings
+type BookingPolicyDetails struct {
+	Business           ID        `json:"business"`
+	UserType           int       `json:"usertype"`           // TypeSuperUser, TypeAdmin or TypeUser, 0 for every role
+	MaxConcurrent      int       `json:"maxConcurrent"`      // bookings of a user that haven't ended yet
+	MaxPerWeek         int       `json:"maxPerWeek"`         // bookings of a user starting in the same Monday to Sunday UTC week
+	MaxLeadDays        int       `json:"maxLeadDays"`        // how many days ahead a booking can start
+	MinNoticeMinutes   int       `json:"minNoticeMinutes"`   // how long before it starts a booking must be made
+	MaxDurationMinutes int       `json:"maxDurationMinutes"` // how long a booking can last
+	UpdatedBy          ID        `json:"updatedBy"`
+	UpdatedAt          time.Time `json:"updatedAt"`
+}
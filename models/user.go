@@ -39,7 +39,7 @@ const (
 )
 
 type User struct {
-	ID      string      `bson:"_id"`
+	ID      ID          `bson:"_id"`
 	Details UserDetails `json:"details"`
 }
 
@@ -47,13 +47,44 @@ type UserDetails struct {
 	FirstName    string    `json:"firstname" validate:"required"`
 	LastName     string    `json:"lastname" validate:"required"`
 	Email        string    `json:"email" validate:"required"`
-	Password     string    `json:"-" validate:"min=10,max=32"`
+	Password     string    `json:"-"` // bcrypt hash, new passwords are checked by auth.ValidatePassword
 	TempPassword bool      `json:"temppassword"`
 	UID          string    `json:"uid"`
-	Business     string    `json:"business"`
+	Business     ID        `json:"business"`
 	UserType     int       `json:"usertype"`
 	Created_at   time.Time `json:"created_at"`
 	Updated_at   time.Time `json:"updated_at"`
+
+	ResetTokenHash    string    `json:"-"` // hash of the single use forgot password token
+	ResetTokenExpires time.Time `json:"-"`
+	Sessions          []Session `json:"-"` // signed in devices, see package auth
+
+	TOTPEnabled   bool     `json:"totpenabled"` // logins need a code from an authenticator app
+	TOTPSecret    string   `json:"-"`           // base32, set on enrollment before TOTPEnabled
+	TOTPLastStep  int64    `json:"-"`           // time step of the last accepted code, so codes can't be replayed
+	RecoveryCodes []string `json:"-"`           // hashes of the unused recovery codes
+
+	AuthProvider string `json:"authprovider"` // "oidc:<name>" or "ldap:<name>" for users created by a provider, empty for local users
+	Disabled     bool   `json:"disabled"`     // can't sign in, their bookings are kept
+
+	FailedLogins    int       `json:"failedlogins"` // wrong passwords or codes in a row, see auth.Throttle
+	LastFailedLogin time.Time `json:"-"`
+	LockedUntil     time.Time `json:"lockeduntil"` // zero unless too many logins failed
+	KnownDevices    []string  `json:"-"`           // hashes of the browsers that signed in, newest last
+}
+
+// Session is a signed in device. Only a hash of its token is stored, the token itself is
+// returned once at login
+type Session struct {
+	ID             string    `json:"id"`
+	TokenHash      string    `json:"-"`
+	PasswordChange bool      `json:"passwordChange"` // signed in with a temporary password, only allows changing it
+	TwoFactorSetup bool      `json:"twoFactorSetup"` // two-factor authentication is required but not set up, only allows enrolling
+	TwoFactorLogin bool      `json:"-"`              // password checked, waiting for the two-factor code, not usable as a bearer token
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"userAgent"`
+	CreatedAt      time.Time `json:"createdAt"`
+	ExpiresAt      time.Time `json:"expiresAt"`
 }
 
 func (s *User) HashPassword(password string) string {
@@ -77,7 +108,7 @@ func (a *User) CheckPasswordStrength(password string) bool {
 
 	var hasLower bool = false
 	for _, r := range password {
-		if !unicode.IsLower(r) && unicode.IsLetter(r) {
+		if unicode.IsLower(r) && unicode.IsLetter(r) {
 			hasLower = true
 		}
 	}
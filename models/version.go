@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// VersionBaseline is the action of the first version of an entity created before versions
+// were kept. It is how the entity looked before the change that started its history
+const VersionBaseline = "baseline"
+
+// Version is how a cow or device looked after a change made through the API. Versions are
+// numbered from 1 for each entity and, like the audit log, only ever appended
+type Version struct {
+	ID      ID             `json:"id" bson:"_id"`
+	Details VersionDetails `json:"details"`
+}
+
+// VersionDetails holds the snapshot of a version and the change that made it
+type VersionDetails struct {
+	EntityType string    `json:"entityType"` // AuditCow or AuditDevice
+	EntityID   ID        `json:"entityId"`
+	Version    int       `json:"version"`
+	Time       time.Time `json:"time"`
+	Actor      Actor     `json:"actor"`
+	Business   ID        `json:"business"`           // of the entity at the time
+	Action     string    `json:"action"`             // AuditCreate, AuditUpdate or VersionBaseline
+	Restored   int       `json:"restored,omitempty"` // the version a revert brought back
+	RequestID  string    `json:"requestId"`
+	Snapshot   Snapshot  `json:"snapshot"`
+}
+
+// VersionChange is one value that differs between two versions
+type VersionChange struct {
+	Path string      `json:"path"` // eg. cow.devices[2], in the JSON of the entity
+	From interface{} `json:"from"` // null when the value was added
+	To   interface{} `json:"to"`   // null when the value was removed
+}
+
+// VersionDiff lists the changes between two versions of an entity
+type VersionDiff struct {
+	From    int             `json:"from"`
+	To      int             `json:"to"`
+	Changes []VersionChange `json:"changes"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// Waitlist entry states. The first waiting entry of a block that frees up is offered it,
+// offers that aren't accepted before OfferExpiresAt are missed and pass on to the next
+// entry. Users can leave while waiting or offered, see handlers.Waitlist
+const (
+	WaitlistWaiting  = "waiting"
+	WaitlistOffered  = "offered"
+	WaitlistAccepted = "accepted"
+	WaitlistMissed   = "missed"
+	WaitlistLeft     = "left"
+	WaitlistClosed   = "closed" // the day of the block passed while waiting
+)
+
+// WaitlistEntry is a user queueing for a block of a cow that is already booked. Entries of
+// the same cow, date and block are queued in the order they joined
+type WaitlistEntry struct {
+	ID      ID                   `json:"id" bson:"_id"`
+	Details WaitlistEntryDetails `json:"details"`
+}
+
+// WaitlistEntryDetails holds the block queued for, the booking to make once it is offered
+// and where the entry is at
+type WaitlistEntryDetails struct {
+	Cow            ID          `json:"cow"`
+	Business       ID          `json:"business"` // of the cow
+	Date           string      `json:"date"`     // the day of the block, YYYY-MM-DD in UTC
+	Block          string      `json:"block"`
+	User           ID          `json:"user"`
+	Booking        BookDetails `json:"booking"` // gets its ID when the offer is accepted
+	Status         string      `json:"status"`  // WaitlistWaiting, WaitlistOffered, WaitlistAccepted, ...
+	CreatedAt      time.Time   `json:"createdAt"`
+	OfferedAt      time.Time   `json:"offeredAt"`
+	OfferExpiresAt time.Time   `json:"offerExpiresAt"` // at the latest when the booking starts
+	UpdatedAt      time.Time   `json:"updatedAt"`
+}
+
+// Active reports whether the entry is still waiting for, or holding, the block
+func (e *WaitlistEntry) Active() bool {
+	return e.Details.Status == WaitlistWaiting || e.Details.Status == WaitlistOffered
+}
@@ -7,15 +7,13 @@ import (
 
 	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
 	"go.uber.org/zap"
-
-	"go.mongodb.org/mongo-driver/bson"
 )
 
 var table = [...]byte{'1', '2', '3', '4', '5', '6', '7', '8', '9', '0'}
 
 func ValidateID(id string, DB databases.UserDatabase) bool { // true: valid id, false: id already in use
 
-	dbResp, err := DB.Find(context.TODO(), bson.M{})
+	dbResp, err := DB.Find(context.TODO(), databases.FilterUsers().UID(id))
 	if err != nil {
 		zap.S().With(err).Error("failed to get users")
 		return false
@@ -10,7 +10,6 @@ import (
 	"time"
 
 	"github.com/howeyc/gopass"
-	"go.mongodb.org/mongo-driver/bson/primitive"
 
 	"github.com/SowinskiBraeden/DeviceBookingAPI/databases"
 	"github.com/SowinskiBraeden/DeviceBookingAPI/models"
@@ -56,28 +55,32 @@ func CreateDefaultAdmin(DB databases.UserDatabase) models.User {
 	lastname = strings.ReplaceAll(lastname, "\r", "")
 	email = strings.ReplaceAll(email, "\r", "")
 
-	var admin models.User
-	admin.Details.FirstName = firstname
-	admin.Details.LastName = lastname
-	admin.Details.Email = email
-
 	pass := strings.TrimSuffix(string(password), "\n")
-	admin.Details.Password = admin.HashPassword(pass)
-	admin.Details.TempPassword = false
+	return NewUser(DB, models.UserDetails{
+		FirstName: firstname,
+		LastName:  lastname,
+		Email:     email,
+		UserType:  models.TypeSuperUser,
+	}, pass, false)
+}
+
+// NewUser fills in the ID, a free UID, the password hash and the timestamps of a new user
+func NewUser(DB databases.UserDatabase, details models.UserDetails, password string, temp bool) models.User {
+	user := models.User{ID: models.NewID(), Details: details}
+	user.Details.Password = user.HashPassword(password)
+	user.Details.TempPassword = temp
 
-	var aid string
+	var uid string
 	for {
-		aid = GenerateID(6)
-		if ValidateID(aid, DB) {
+		uid = GenerateID(6)
+		if ValidateID(uid, DB) {
 			break
 		}
 	}
-	admin.Details.UID = aid
+	user.Details.UID = uid
 
-	admin.Details.UserType = models.TypeSuperUser
-	admin.Details.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	admin.Details.Updated_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	admin.ID = primitive.NewObjectID().Hex()
+	user.Details.Created_at, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
+	user.Details.Updated_at = user.Details.Created_at
 
-	return admin
+	return user
 }